@@ -20,6 +20,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/omec-project/openapi"
 	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
 	smf_context "github.com/omec-project/smf/context"
 	"github.com/omec-project/smf/fsm"
 	"github.com/omec-project/smf/logger"
@@ -95,3 +96,12 @@ func N1N2FailureNotification(c *gin.Context) {
 	stats.IncrementN11MsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.N1N2MessageTransferFailureNotification), "Out", http.StatusText(http.StatusNoContent), "")
 	c.Status(http.StatusNoContent)
 }
+
+// AmPolicyNotification drops the cached AM policy of the UE on a PCF update or termination
+// notification, the policy is retrieved again at the next session establishment
+func AmPolicyNotification(c *gin.Context) {
+	supi := c.Params.ByName("supi")
+	logger.PduSessLog.Infof("AM policy notification received for SUPI[%s]", supi)
+	consumer.InvalidateAMPolicy(supi)
+	c.Status(http.StatusNoContent)
+}
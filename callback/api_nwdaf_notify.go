@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/omec-project/openapi"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/nnwdaf"
+)
+
+// HTTPNwdafAnalyticsNotification applies the analytics notified by the NWDAF to the SMF
+func HTTPNwdafAnalyticsNotification(c *gin.Context) {
+	client := nnwdaf.GetNWDAFClient()
+	if client == nil {
+		c.JSON(http.StatusNotFound, models.ProblemDetails{
+			Title:  "NWDAF not configured",
+			Status: http.StatusNotFound,
+			Cause:  "SUBSCRIPTION_NOT_FOUND",
+		})
+		return
+	}
+
+	var notification nnwdaf.AnalyticsNotification
+	requestBody, err := c.GetRawData()
+	if err == nil {
+		err = openapi.Deserialize(&notification, requestBody, "application/json")
+	}
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.ConsumerLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		})
+		return
+	}
+
+	if err = client.HandleAnalyticsNotification(&notification); err != nil {
+		logger.ConsumerLog.Warnf("NWDAF analytics notification discarded: %v", err)
+		c.JSON(http.StatusNotFound, models.ProblemDetails{
+			Title:  "Subscription not found",
+			Status: http.StatusNotFound,
+			Detail: err.Error(),
+			Cause:  "SUBSCRIPTION_NOT_FOUND",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -74,6 +74,18 @@ var routes = Routes{
 		"/sm-policies/:smContextRef/terminate",
 		SmPolicyControlTerminationRequestNotification,
 	},
+	{
+		"AmPolicyUpdateNotification",
+		"POST",
+		"/am-policies/:supi/update",
+		AmPolicyNotification,
+	},
+	{
+		"AmPolicyTerminationNotification",
+		"POST",
+		"/am-policies/:supi/terminate",
+		AmPolicyNotification,
+	},
 	{
 		"N1N2FailureNotification",
 		"POST",
@@ -86,4 +98,10 @@ var routes = Routes{
 		"/nf-status-notify",
 		HTTPNfSubscriptionStatusNotify,
 	},
+	{
+		"NwdafAnalyticsNotification",
+		"POST",
+		"/nwdaf-notify",
+		HTTPNwdafAnalyticsNotification,
+	},
 }
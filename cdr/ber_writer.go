@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cdr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/omec-project/smf/logger"
+)
+
+// TS 32.297 6.1.1 and 6.1.2
+const (
+	fileHeaderLength = 54
+	cdrHeaderLength  = 5
+
+	// release identifier 7 is Rel-10 or later, the release being 10 + the extension
+	releaseIdentifier          uint8 = 7
+	releaseIdentifierExtension uint8 = 6 // Rel-16
+	versionIdentifier          uint8 = 0
+	dataRecordFormatBER        uint8 = 1
+	tsNumber32255              uint8 = 20 // 5G data connectivity domain charging
+
+	ipAddressOfNodeFieldLength = 20
+)
+
+// file closure trigger reasons. TS 32.297 6.1.1.9
+const (
+	FileClosureNormal         uint8 = 0
+	FileClosureMaxCDRsReached uint8 = 3
+	// recorded in the header of the file while it is open, left if the SMF stops without
+	// closing it
+	FileClosureAbnormal uint8 = 128
+)
+
+// DefaultRecordsPerFile is the number of records of a CDR file if not configured
+const DefaultRecordsPerFile = 100
+
+// BERCDRWriter writes CDR files of TS 32.297, holding the SMF records BER-encoded per TS 32.298.
+// Each record is written to the current file and synced at once, its header updated, so that no
+// record is lost if the SMF stops. A file is closed once it holds the configured number of
+// records, or when the writer is closed.
+type BERCDRWriter struct {
+	opened         time.Time
+	lastAppended   time.Time
+	dir            string
+	nodeIP         net.IP
+	file           *os.File
+	fileLength     uint32
+	records        uint32
+	recordsPerFile int
+	fileSequence   uint32
+	// clock of the files, replaced in tests
+	now  func() time.Time
+	lock sync.Mutex
+}
+
+// NewBERCDRWriter returns a writer of the CDR files in the directory, the node IP being the
+// address of the SMF recorded in the file headers
+func NewBERCDRWriter(dir string, recordsPerFile int, nodeIP net.IP) *BERCDRWriter {
+	if recordsPerFile <= 0 {
+		recordsPerFile = DefaultRecordsPerFile
+	}
+	return &BERCDRWriter{
+		dir:            dir,
+		nodeIP:         nodeIP,
+		recordsPerFile: recordsPerFile,
+		now:            time.Now,
+	}
+}
+
+// Write appends the record to the current file, opening a file if none, and closes the file
+// once full
+func (w *BERCDRWriter) Write(record *SMFRecord) error {
+	encoded, err := record.Marshal()
+	if err != nil {
+		return fmt.Errorf("encode SMF record: %w", err)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := w.now()
+	if w.file == nil {
+		if err := w.open(now); err != nil {
+			return err
+		}
+	}
+	cdr := make([]byte, 0, cdrHeaderLength+len(encoded))
+	cdr = binary.BigEndian.AppendUint16(cdr, uint16(len(encoded)))
+	cdr = append(cdr, releaseIdentifier<<5|versionIdentifier, dataRecordFormatBER<<5|tsNumber32255,
+		releaseIdentifierExtension)
+	cdr = append(cdr, encoded...)
+	if _, err := w.file.WriteAt(cdr, int64(w.fileLength)); err != nil {
+		return fmt.Errorf("write CDR file %s: %w", w.file.Name(), err)
+	}
+	w.fileLength += uint32(len(cdr))
+	w.records++
+	w.lastAppended = now
+	if w.records >= uint32(w.recordsPerFile) {
+		return w.close(FileClosureMaxCDRsReached)
+	}
+	return w.sync(FileClosureAbnormal)
+}
+
+// Close closes the current file, if any
+func (w *BERCDRWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.close(FileClosureNormal)
+}
+
+func (w *BERCDRWriter) open(now time.Time) error {
+	w.fileSequence++
+	name := filepath.Join(w.dir, fmt.Sprintf("SMF_%s_%d.cdr", now.Format("20060102_150405"), w.fileSequence))
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("open CDR file %s: %w", name, err)
+	}
+	w.file = file
+	w.opened = now
+	w.fileLength = fileHeaderLength
+	w.records = 0
+	return nil
+}
+
+// sync writes the header of the current file with the closure reason and syncs the file
+func (w *BERCDRWriter) sync(closureReason uint8) error {
+	if _, err := w.file.WriteAt(w.encodeHeader(closureReason), 0); err != nil {
+		return fmt.Errorf("write CDR file %s: %w", w.file.Name(), err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync CDR file %s: %w", w.file.Name(), err)
+	}
+	return nil
+}
+
+func (w *BERCDRWriter) close(closureReason uint8) error {
+	err := w.sync(closureReason)
+	file := w.file
+	w.file = nil
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close CDR file %s: %w", file.Name(), closeErr)
+	}
+	if err == nil {
+		logger.ConsumerLog.Infof("CDR file %s closed with %d records", file.Name(), w.records)
+	}
+	return err
+}
+
+func (w *BERCDRWriter) encodeHeader(closureReason uint8) []byte {
+	header := make([]byte, 0, fileHeaderLength)
+	header = binary.BigEndian.AppendUint32(header, w.fileLength)
+	header = binary.BigEndian.AppendUint32(header, fileHeaderLength)
+	header = append(header, releaseIdentifier<<5|versionIdentifier, releaseIdentifier<<5|versionIdentifier)
+	header = binary.BigEndian.AppendUint32(header, encodeFileTimestamp(w.opened))
+	header = binary.BigEndian.AppendUint32(header, encodeFileTimestamp(w.lastAppended))
+	header = binary.BigEndian.AppendUint32(header, w.records)
+	header = binary.BigEndian.AppendUint32(header, w.fileSequence)
+	header = append(header, closureReason)
+	header = append(header, encodeNodeIP(w.nodeIP)...)
+	header = append(header, 0)    // lost CDR indicator
+	header = append(header, 0, 0) // length of CDR routing filter
+	header = append(header, 0, 0) // length of private extension
+	header = append(header, releaseIdentifierExtension, releaseIdentifierExtension)
+	return header
+}
+
+// encodeFileTimestamp encodes month, day, hour and minute with the UTC offset. TS 32.297 6.1.1.5
+func encodeFileTimestamp(t time.Time) uint32 {
+	_, offset := t.Zone()
+	var sign uint32
+	if offset < 0 {
+		sign = 1
+		offset = -offset
+	}
+	return uint32(t.Month())<<28 | uint32(t.Day())<<23 | uint32(t.Hour())<<18 | uint32(t.Minute())<<12 |
+		sign<<11 | uint32(offset/3600)<<6 | uint32(offset%3600/60)
+}
+
+// encodeNodeIP encodes the address of the node generating the file in the last octets of the
+// 20 octets field, the others being set to 0xff. TS 32.297 6.1.1.10
+func encodeNodeIP(ip net.IP) []byte {
+	field := make([]byte, ipAddressOfNodeFieldLength)
+	for i := range field {
+		field[i] = 0xff
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(field[ipAddressOfNodeFieldLength-net.IPv4len:], ip4)
+	} else if ip16 := ip.To16(); ip16 != nil {
+		copy(field[ipAddressOfNodeFieldLength-net.IPv6len:], ip16)
+	}
+	return field
+}
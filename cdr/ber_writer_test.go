@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cdr_test
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/cdr"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+// decodedRecord is the ChargingRecord of TS 32.298 as read by a reference decoder
+type decodedRecord struct {
+	RecordType                 int    `asn1:"tag:0"`
+	RecordingNetworkFunctionID string `asn1:"tag:1,utf8"`
+	SubscriberIdentifier       struct {
+		Type asn1.Enumerated `asn1:"tag:0"`
+		Data string          `asn1:"tag:1,utf8"`
+	} `asn1:"tag:2,optional"`
+	NFunctionConsumerInformation struct {
+		Functionality asn1.Enumerated `asn1:"tag:0"`
+		Name          string          `asn1:"tag:1,utf8,optional"`
+		IPv4Address   asn1.RawValue   `asn1:"tag:2,explicit,optional"`
+	} `asn1:"tag:3"`
+	MultipleUnitUsage []struct {
+		RatingGroup        int64 `asn1:"tag:0"`
+		UsedUnitContainers []struct {
+			Time             int64  `asn1:"tag:1,optional"`
+			TriggerTimeStamp []byte `asn1:"tag:3,optional"`
+			Total            int64  `asn1:"tag:4,optional"`
+			Uplink           int64  `asn1:"tag:5,optional"`
+			Downlink         int64  `asn1:"tag:6,optional"`
+		} `asn1:"tag:2,optional"`
+		UPFID string `asn1:"tag:3,utf8,optional"`
+	} `asn1:"tag:5,optional"`
+	RecordOpeningTime   []byte `asn1:"tag:6"`
+	Duration            int64  `asn1:"tag:7"`
+	CauseForRecClosing  int    `asn1:"tag:9"`
+	LocalSequenceNumber int64  `asn1:"tag:11,optional"`
+	PDUSession          struct {
+		ChargingID     int64         `asn1:"tag:0"`
+		UserIdentifier asn1.RawValue `asn1:"tag:1,explicit,optional"`
+		PDUSessionID   int           `asn1:"tag:6"`
+		Snssai         struct {
+			SST int    `asn1:"tag:0"`
+			SD  []byte `asn1:"tag:1,optional"`
+		} `asn1:"tag:7,optional"`
+		PDUType    asn1.Enumerated `asn1:"tag:8"`
+		Dnn        string          `asn1:"tag:13,ia5"`
+		PDUAddress asn1.RawValue   `asn1:"tag:14,optional"`
+		StartTime  []byte          `asn1:"tag:17"`
+		StopTime   []byte          `asn1:"tag:18"`
+		RANUsage   []struct {
+			RATType     asn1.Enumerated `asn1:"tag:0"`
+			FlowReports []struct {
+				QFI       int64  `asn1:"tag:0,optional"`
+				StartTime []byte `asn1:"tag:1"`
+				EndTime   []byte `asn1:"tag:2"`
+				Uplink    int64  `asn1:"tag:3"`
+				Downlink  int64  `asn1:"tag:4"`
+			} `asn1:"tag:1,optional"`
+		} `asn1:"tag:23,optional"`
+	} `asn1:"tag:13"`
+}
+
+func newSampleSMFRecord() *cdr.SMFRecord {
+	smContext := context.NewSMContext("imsi-208930000000601", 1)
+	smContext.Supi = "imsi-208930000000601"
+	smContext.Gpsi = "msisdn-33612345678"
+	smContext.Dnn = "internet"
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.ParseIP("10.60.0.1")}
+	smContext.PFCPContext["10.0.6.1"] = &context.PFCPSessionContext{LocalSEID: 42}
+
+	closed := time.Date(2026, time.March, 14, 15, 9, 26, 0, time.FixedZone("", 2*3600))
+	// established 10 minutes before, the URR measuring the last minute
+	smContext.StartTime = closed.Add(-10 * time.Minute)
+	// the NG-RAN reporting the usage of a QoS flow on the secondary RAT in the last minute
+	smContext.AddSecondaryRATUsage([]context.SecondaryRATUsage{{
+		RATType: models.RatType_NR, QFI: 1, StartTime: closed.Add(-time.Minute), EndTime: closed,
+		UplinkVolume: 400, DownlinkVolume: 3000,
+	}})
+	return cdr.NewSMFRecord(smContext, []context.UsageReport{
+		{UpfIP: "10.0.6.1", URRID: 1, TotalVolume: 6000, UplinkVolume: 1000, DownlinkVolume: 5000, Duration: 60},
+	}, closed, 7)
+}
+
+func TestSMFRecordDecoding(t *testing.T) {
+	encoded, err := newSampleSMFRecord().Marshal()
+	require.NoError(t, err)
+
+	var record decodedRecord
+	rest, err := asn1.UnmarshalWithParams(encoded, &record, "tag:200,set")
+	require.NoError(t, err)
+	require.Empty(t, rest)
+
+	require.Equal(t, cdr.SMFRecordType, record.RecordType)
+	require.Equal(t, cdr.SubscriptionIDIMSI, record.SubscriberIdentifier.Type)
+	require.Equal(t, "208930000000601", record.SubscriberIdentifier.Data)
+	require.Equal(t, cdr.NetworkFunctionSMF, record.NFunctionConsumerInformation.Functionality)
+	require.Len(t, record.MultipleUnitUsage, 1)
+	require.Equal(t, "10.0.6.1", record.MultipleUnitUsage[0].UPFID)
+	require.Len(t, record.MultipleUnitUsage[0].UsedUnitContainers, 1)
+	container := record.MultipleUnitUsage[0].UsedUnitContainers[0]
+	require.Equal(t, int64(60), container.Time)
+	require.Equal(t, int64(6000), container.Total)
+	require.Equal(t, int64(1000), container.Uplink)
+	require.Equal(t, int64(5000), container.Downlink)
+	require.Equal(t, []byte{0x26, 0x03, 0x14, 0x15, 0x09, 0x26, '+', 0x02, 0x00}, container.TriggerTimeStamp)
+	// opened at the establishment of the session, not at the start of the URR measurement
+	require.Equal(t, []byte{0x26, 0x03, 0x14, 0x14, 0x59, 0x26, '+', 0x02, 0x00}, record.RecordOpeningTime)
+	require.Equal(t, int64(600), record.Duration)
+	require.Equal(t, cdr.CauseNormalRelease, record.CauseForRecClosing)
+	require.Equal(t, int64(7), record.LocalSequenceNumber)
+
+	pduSession := record.PDUSession
+	require.Equal(t, int64(42), pduSession.ChargingID)
+	// tEL-URI [1] of InvolvedParty
+	require.Equal(t, append([]byte{0x81, 0x10}, "tel:+33612345678"...), pduSession.UserIdentifier.Bytes)
+	require.Equal(t, 1, pduSession.PDUSessionID)
+	require.Equal(t, 1, pduSession.Snssai.SST)
+	require.Equal(t, []byte{0x01, 0x02, 0x03}, pduSession.Snssai.SD)
+	require.Equal(t, cdr.PDUTypeIPv4, pduSession.PDUType)
+	require.Equal(t, "internet", pduSession.Dnn)
+	// pDUIPv4Address [0] containing iPBinV4Address [0]
+	require.Equal(t, []byte{0xa0, 0x06, 0x80, 0x04, 10, 60, 0, 1}, pduSession.PDUAddress.Bytes)
+	require.Equal(t, record.RecordOpeningTime, pduSession.StartTime)
+	require.Equal(t, container.TriggerTimeStamp, pduSession.StopTime)
+	require.Len(t, pduSession.RANUsage, 1)
+	require.Equal(t, cdr.NGRANSecondaryRATNR, pduSession.RANUsage[0].RATType)
+	require.Len(t, pduSession.RANUsage[0].FlowReports, 1)
+	flowReport := pduSession.RANUsage[0].FlowReports[0]
+	require.Equal(t, int64(1), flowReport.QFI)
+	require.Equal(t, int64(400), flowReport.Uplink)
+	require.Equal(t, int64(3000), flowReport.Downlink)
+	require.Equal(t, []byte{0x26, 0x03, 0x14, 0x15, 0x08, 0x26, '+', 0x02, 0x00}, flowReport.StartTime)
+	require.Equal(t, container.TriggerTimeStamp, flowReport.EndTime)
+}
+
+func TestBERCDRWriterFiles(t *testing.T) {
+	dir := t.TempDir()
+	writer := cdr.NewBERCDRWriter(dir, 2, net.ParseIP("10.0.0.5"))
+	record := newSampleSMFRecord()
+	encoded, err := record.Marshal()
+	require.NoError(t, err)
+
+	for range 3 {
+		require.NoError(t, writer.Write(record))
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.cdr"))
+	require.NoError(t, err)
+	require.Len(t, files, 2, "records written at once, a file closed once holding the records per file")
+
+	checkFile := func(content []byte, records uint32, sequence uint32, closureReason uint8) {
+		require.Equal(t, uint32(len(content)), binary.BigEndian.Uint32(content[0:4]), "file length")
+		require.Equal(t, uint32(54), binary.BigEndian.Uint32(content[4:8]), "header length")
+		require.Equal(t, records, binary.BigEndian.Uint32(content[18:22]), "number of CDRs")
+		require.Equal(t, sequence, binary.BigEndian.Uint32(content[22:26]), "file sequence number")
+		require.Equal(t, closureReason, content[26])
+		require.Equal(t, []byte{10, 0, 0, 5}, content[43:47], "IP address of the node")
+
+		offset := 54
+		for range records {
+			length := int(binary.BigEndian.Uint16(content[offset:]))
+			require.Equal(t, byte(1<<5|20), content[offset+3], "BER of TS 32.255")
+			offset += 5
+			require.Equal(t, encoded, content[offset:offset+length])
+			var decoded decodedRecord
+			_, err := asn1.UnmarshalWithParams(content[offset:offset+length], &decoded, "tag:200,set")
+			require.NoError(t, err)
+			offset += length
+		}
+		require.Equal(t, len(content), offset)
+	}
+
+	sequences := map[uint32]string{}
+	for _, name := range files {
+		content, err := os.ReadFile(name)
+		require.NoError(t, err)
+		sequences[binary.BigEndian.Uint32(content[22:26])] = name
+	}
+	content, err := os.ReadFile(sequences[1])
+	require.NoError(t, err)
+	checkFile(content, 2, 1, cdr.FileClosureMaxCDRsReached)
+
+	// the open file holds its records, marked abnormal until closed
+	content, err = os.ReadFile(sequences[2])
+	require.NoError(t, err)
+	checkFile(content, 1, 2, cdr.FileClosureAbnormal)
+	require.NoError(t, writer.Close())
+	content, err = os.ReadFile(sequences[2])
+	require.NoError(t, err)
+	checkFile(content, 1, 2, cdr.FileClosureNormal)
+}
@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cdr
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+)
+
+// The SMF record is the ChargingRecord of TS 32.298, the CHF-CDR of the 5G data connectivity
+// domain, with the PDU session charging information of the SMF. It is encoded as the
+// chargingFunctionRecord alternative of CHFRecord.
+const (
+	SMFRecordType      = 200 // chargingFunctionRecord
+	chargingRecordTag  = 200
+	CauseNormalRelease = 0
+	NetworkFunctionSMF = asn1.Enumerated(1)
+	SubscriptionIDIMSI = asn1.Enumerated(1)
+	// NGRANSecondaryRATType of the RAN secondary RAT usage reports
+	NGRANSecondaryRATNR    = asn1.Enumerated(0)
+	NGRANSecondaryRATEUTRA = asn1.Enumerated(1)
+)
+
+// PDUSessionType values of the PDU session charging information
+const (
+	PDUTypeIPv4v6 asn1.Enumerated = iota
+	PDUTypeIPv4
+	PDUTypeIPv6
+	PDUTypeUnstructured
+	PDUTypeEthernet
+)
+
+// SMFRecord is the CDR of a PDU session. TS 32.298 5.2.5
+type SMFRecord struct {
+	RecordType                    int                           `asn1:"tag:0"`
+	RecordingNetworkFunctionID    string                        `asn1:"tag:1,utf8"`
+	SubscriberIdentifier          SubscriptionID                `asn1:"tag:2,optional"`
+	NFunctionConsumerInformation  NetworkFunctionInformation    `asn1:"tag:3"`
+	ListOfMultipleUnitUsage       []MultipleUnitUsage           `asn1:"tag:5,optional"`
+	RecordOpeningTime             []byte                        `asn1:"tag:6"`
+	Duration                      int64                         `asn1:"tag:7"`
+	CauseForRecClosing            int                           `asn1:"tag:9"`
+	LocalRecordSequenceNumber     int64                         `asn1:"tag:11,optional"`
+	PDUSessionChargingInformation PDUSessionChargingInformation `asn1:"tag:13"`
+}
+
+// SubscriptionID identifies the subscriber of the session
+type SubscriptionID struct {
+	SubscriptionIDType asn1.Enumerated `asn1:"tag:0"`
+	SubscriptionIDData string          `asn1:"tag:1,utf8"`
+}
+
+// NetworkFunctionInformation identifies the SMF consuming the charging service
+type NetworkFunctionInformation struct {
+	NetworkFunctionality       asn1.Enumerated `asn1:"tag:0"`
+	NetworkFunctionName        string          `asn1:"tag:1,utf8,optional"`
+	NetworkFunctionIPv4Address asn1.RawValue   `asn1:"tag:2,explicit,optional"`
+	NetworkFunctionIPv6Address asn1.RawValue   `asn1:"tag:4,explicit,optional"`
+}
+
+// MultipleUnitUsage is the usage of the session reported by a UPF. The SMF measures the whole
+// session with its URRs, without rating group.
+type MultipleUnitUsage struct {
+	RatingGroup        int64               `asn1:"tag:0"`
+	UsedUnitContainers []UsedUnitContainer `asn1:"tag:2,optional"`
+	UPFID              string              `asn1:"tag:3,utf8,optional"`
+}
+
+// UsedUnitContainer is the traffic volume of a URR
+type UsedUnitContainer struct {
+	Time               int64  `asn1:"tag:1,optional"`
+	TriggerTimeStamp   []byte `asn1:"tag:3,optional"`
+	DataTotalVolume    int64  `asn1:"tag:4,optional"`
+	DataVolumeUplink   int64  `asn1:"tag:5,optional"`
+	DataVolumeDownlink int64  `asn1:"tag:6,optional"`
+}
+
+// PDUSessionChargingInformation describes the PDU session of the record
+type PDUSessionChargingInformation struct {
+	PDUSessionChargingID       int64                          `asn1:"tag:0"`
+	UserIdentifier             asn1.RawValue                  `asn1:"tag:1,explicit,optional"`
+	PDUSessionID               int                            `asn1:"tag:6"`
+	NetworkSliceInstanceID     SingleNSSAI                    `asn1:"tag:7,optional"`
+	PDUType                    asn1.Enumerated                `asn1:"tag:8"`
+	DataNetworkNameIdentifier  string                         `asn1:"tag:13,ia5"`
+	PDUAddress                 asn1.RawValue                  `asn1:"tag:14,optional"`
+	PDUSessionStartTime        []byte                         `asn1:"tag:17"`
+	PDUSessionStopTime         []byte                         `asn1:"tag:18"`
+	RANSecondaryRATUsageReport []NGRANSecondaryRATUsageReport `asn1:"tag:23,optional"`
+}
+
+// SingleNSSAI is the S-NSSAI of the session
+type SingleNSSAI struct {
+	SST int    `asn1:"tag:0"`
+	SD  []byte `asn1:"tag:1,optional"`
+}
+
+// NGRANSecondaryRATUsageReport is the traffic volume of the session on the secondary RAT of a
+// dual connectivity. The secondary RAT type is only defined for NR and E-UTRA.
+type NGRANSecondaryRATUsageReport struct {
+	NGRANSecondaryRATType asn1.Enumerated       `asn1:"tag:0"`
+	QosFlowsUsageReports  []QosFlowsUsageReport `asn1:"tag:1,optional"`
+}
+
+// QosFlowsUsageReport is the secondary RAT volume of the session, or of one of its QoS flows
+type QosFlowsUsageReport struct {
+	QosFlowIdentifier  int64  `asn1:"tag:0,optional"`
+	StartTime          []byte `asn1:"tag:1"`
+	EndTime            []byte `asn1:"tag:2"`
+	DataVolumeUplink   int64  `asn1:"tag:3"`
+	DataVolumeDownlink int64  `asn1:"tag:4"`
+}
+
+// Marshal encodes the record as a BER CHFRecord
+func (record *SMFRecord) Marshal() ([]byte, error) {
+	return asn1.MarshalWithParams(*record, fmt.Sprintf("tag:%d,set", chargingRecordTag))
+}
+
+// NewSMFRecord returns the record of the session released at the closing time, with the final
+// usage reported by the UPFs. The record opens at the establishment of the session. The charging
+// ID is the lowest local SEID of its PFCP sessions, unique in the SMF.
+func NewSMFRecord(smContext *context.SMContext, finalUsage []context.UsageReport, closed time.Time,
+	sequenceNumber int64,
+) *SMFRecord {
+	smfSelf := context.SMF_Self()
+	opened := smContext.StartTime
+	if opened.IsZero() || opened.After(closed) {
+		opened = closed
+	}
+	record := &SMFRecord{
+		RecordType:                 SMFRecordType,
+		RecordingNetworkFunctionID: smfSelf.Name,
+		NFunctionConsumerInformation: NetworkFunctionInformation{
+			NetworkFunctionality: NetworkFunctionSMF,
+			NetworkFunctionName:  smfSelf.Name,
+		},
+		RecordOpeningTime:         EncodeTimeStamp(opened),
+		Duration:                  int64(closed.Sub(opened) / time.Second),
+		CauseForRecClosing:        CauseNormalRelease,
+		LocalRecordSequenceNumber: sequenceNumber,
+		PDUSessionChargingInformation: PDUSessionChargingInformation{
+			PDUSessionID:              int(smContext.PDUSessionID),
+			DataNetworkNameIdentifier: smContext.Dnn,
+			PDUSessionStartTime:       EncodeTimeStamp(opened),
+			PDUSessionStopTime:        EncodeTimeStamp(closed),
+		},
+	}
+	if ip := smfSelf.CPNodeID.ResolveNodeIdToIp(); ip.To4() != nil {
+		record.NFunctionConsumerInformation.NetworkFunctionIPv4Address = explicitTag(2, ipBinaryAddress(ip))
+	} else if ip != nil {
+		record.NFunctionConsumerInformation.NetworkFunctionIPv6Address = explicitTag(4, ipBinaryAddress(ip))
+	}
+	if imsi, ok := strings.CutPrefix(smContext.Supi, "imsi-"); ok {
+		record.SubscriberIdentifier = SubscriptionID{SubscriptionIDType: SubscriptionIDIMSI, SubscriptionIDData: imsi}
+	}
+
+	info := &record.PDUSessionChargingInformation
+	if msisdn, ok := strings.CutPrefix(smContext.Gpsi, "msisdn-"); ok {
+		// tEL-URI alternative of InvolvedParty
+		info.UserIdentifier = explicitTag(1, asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte("tel:+" + msisdn),
+		})
+	}
+	if smContext.Snssai != nil {
+		info.NetworkSliceInstanceID.SST = int(smContext.Snssai.Sst)
+		if sd, err := hex.DecodeString(smContext.Snssai.Sd); err == nil && len(sd) > 0 {
+			info.NetworkSliceInstanceID.SD = sd
+		}
+	}
+	switch smContext.SelectedPDUSessionType {
+	case nasMessage.PDUSessionTypeIPv4:
+		info.PDUType = PDUTypeIPv4
+	case nasMessage.PDUSessionTypeIPv6:
+		info.PDUType = PDUTypeIPv6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		info.PDUType = PDUTypeIPv4v6
+	case nasMessage.PDUSessionTypeUnstructured:
+		info.PDUType = PDUTypeUnstructured
+	case nasMessage.PDUSessionTypeEthernet:
+		info.PDUType = PDUTypeEthernet
+	}
+	if smContext.PDUAddress != nil && smContext.PDUAddress.Ip != nil {
+		// pDUIPv4Address or pDUIPv6AddresswithPrefix of PDUAddress, as IPAddress
+		tag := 0
+		if smContext.PDUAddress.Ip.To4() == nil {
+			tag = 1
+		}
+		if address, err := asn1.Marshal(explicitTag(tag, ipBinaryAddress(smContext.PDUAddress.Ip))); err == nil {
+			info.PDUAddress = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 14, IsCompound: true, Bytes: address}
+		}
+	}
+	info.PDUSessionChargingID = int64(smContext.ChargingID())
+
+	for _, usage := range finalUsage {
+		record.ListOfMultipleUnitUsage = append(record.ListOfMultipleUnitUsage, MultipleUnitUsage{
+			UsedUnitContainers: []UsedUnitContainer{{
+				Time:               int64(usage.Duration),
+				TriggerTimeStamp:   EncodeTimeStamp(closed),
+				DataTotalVolume:    int64(usage.TotalVolume),
+				DataVolumeUplink:   int64(usage.UplinkVolume),
+				DataVolumeDownlink: int64(usage.DownlinkVolume),
+			}},
+			UPFID: usage.UpfIP,
+		})
+	}
+	for _, ratUsage := range smContext.SecondaryRATUsage() {
+		report := NGRANSecondaryRATUsageReport{
+			QosFlowsUsageReports: []QosFlowsUsageReport{{
+				QosFlowIdentifier:  int64(ratUsage.QFI),
+				StartTime:          EncodeTimeStamp(ratUsage.StartTime),
+				EndTime:            EncodeTimeStamp(ratUsage.EndTime),
+				DataVolumeUplink:   int64(ratUsage.UplinkVolume),
+				DataVolumeDownlink: int64(ratUsage.DownlinkVolume),
+			}},
+		}
+		if ratUsage.RATType != models.RatType_NR {
+			// the secondary RAT of an NR primary RAT
+			report.NGRANSecondaryRATType = NGRANSecondaryRATEUTRA
+		}
+		info.RANSecondaryRATUsageReport = append(info.RANSecondaryRATUsageReport, report)
+	}
+	return record
+}
+
+// ipBinaryAddress encodes the iPBinV4Address or iPBinV6Address alternative of IPAddress
+func ipBinaryAddress(ip net.IP) asn1.RawValue {
+	if ip4 := ip.To4(); ip4 != nil {
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ip4}
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: ip.To16()}
+}
+
+// explicitTag wraps the value in a constructed context-specific tag, as for the CHOICE types
+func explicitTag(tag int, value asn1.RawValue) asn1.RawValue {
+	inner, err := asn1.Marshal(value)
+	if err != nil {
+		return asn1.RawValue{}
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: inner}
+}
+
+// encodeTBCD encodes the digits two per octet, the first one in the low nibble, 0xf filling
+// an odd number of digits
+func encodeTBCD(digits string) []byte {
+	encoded := make([]byte, (len(digits)+1)/2)
+	for i := range encoded {
+		low := digits[2*i] - '0'
+		high := byte(0xf)
+		if 2*i+1 < len(digits) {
+			high = digits[2*i+1] - '0'
+		}
+		encoded[i] = high<<4 | low
+	}
+	return encoded
+}
+
+// EncodeTimeStamp encodes the local time YYMMDDhhmmss in BCD, the UTC offset sign in ASCII and
+// the UTC offset hhmm in BCD, the TimeStamp of TS 32.298
+func EncodeTimeStamp(t time.Time) []byte {
+	bcd := func(v int) byte { return byte(v/10)<<4 | byte(v%10) }
+	_, offset := t.Zone()
+	sign := byte('+')
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return []byte{
+		bcd(t.Year() % 100), bcd(int(t.Month())), bcd(t.Day()),
+		bcd(t.Hour()), bcd(t.Minute()), bcd(t.Second()),
+		sign, bcd(offset / 3600), bcd(offset % 3600 / 60),
+	}
+}
@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/omec-project/openapi/Npcf_AMPolicy"
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+)
+
+// AMPolicyData is the access and mobility policy of a UE, TS 29.507 5.6.2.3
+type AMPolicyData struct {
+	ServAreaRes *models.ServiceAreaRestriction
+	PolAssoId   string
+	Rfsp        int32
+}
+
+// PCFAMPolicyClient retrieves the AM policies of the UEs from a PCF and caches them per SUPI
+// until the PCF notifies an update or the termination of the association. The association of a
+// policy dropped from the cache is deleted at the PCF.
+type PCFAMPolicyClient struct {
+	client   *Npcf_AMPolicy.APIClient
+	policies map[string]*AMPolicyData
+	// SUPIs of the cached policies, oldest first
+	order []string
+	lock  sync.Mutex
+}
+
+// maxAMPolicies is the number of AM policies cached per PCF, the oldest being dropped beyond
+var maxAMPolicies = 10000
+
+// apiPrefix -> AM policy client
+var (
+	amPolicyClients     = make(map[string]*PCFAMPolicyClient)
+	amPolicyClientsLock sync.Mutex
+)
+
+func NewPCFAMPolicyClient(apiPrefix string) *PCFAMPolicyClient {
+	amPolicyConf := Npcf_AMPolicy.NewConfiguration()
+	amPolicyConf.SetBasePath(apiPrefix)
+	return &PCFAMPolicyClient{
+		client:   Npcf_AMPolicy.NewAPIClient(amPolicyConf),
+		policies: make(map[string]*AMPolicyData),
+	}
+}
+
+// AMPolicyClientForPCF returns the AM policy client of the PCF, nil when the PCF does not offer
+// the npcf-am-policy-control service
+func AMPolicyClientForPCF(profile models.NfProfile) *PCFAMPolicyClient {
+	if profile.NfServices == nil {
+		return nil
+	}
+	for _, service := range *profile.NfServices {
+		if service.ServiceName != models.ServiceName_NPCF_AM_POLICY_CONTROL {
+			continue
+		}
+		amPolicyClientsLock.Lock()
+		defer amPolicyClientsLock.Unlock()
+		client, ok := amPolicyClients[service.ApiPrefix]
+		if !ok {
+			client = NewPCFAMPolicyClient(service.ApiPrefix)
+			amPolicyClients[service.ApiPrefix] = client
+		}
+		return client
+	}
+	return nil
+}
+
+// InvalidateAMPolicy drops the cached AM policy of the UE from all the PCF clients, deleting its
+// associations
+func InvalidateAMPolicy(supi string) {
+	amPolicyClientsLock.Lock()
+	clients := make([]*PCFAMPolicyClient, 0, len(amPolicyClients))
+	for _, client := range amPolicyClients {
+		clients = append(clients, client)
+	}
+	amPolicyClientsLock.Unlock()
+	for _, client := range clients {
+		client.Invalidate(supi)
+	}
+}
+
+// GetAMPolicy returns the AM policy of the UE, creating an AM policy association
+// with the PCF when none is cached
+func (c *PCFAMPolicyClient) GetAMPolicy(supi string) (*AMPolicyData, error) {
+	c.lock.Lock()
+	policy, ok := c.policies[supi]
+	c.lock.Unlock()
+	if ok {
+		return policy, nil
+	}
+
+	request := models.PolicyAssociationRequest{
+		NotificationUri: fmt.Sprintf("%s/nsmf-callback/am-policies/%s",
+			smf_context.SMF_Self().SBIUri(),
+			supi,
+		),
+		Supi:     supi,
+		SuppFeat: "F",
+	}
+	association, httpRsp, err := c.client.DefaultApi.PoliciesPost(context.Background(), request)
+	if err != nil {
+		return nil, fmt.Errorf("setup am policy association failed: %v", err)
+	}
+	if httpRsp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("setup am policy association failed: status %d", httpRsp.StatusCode)
+	}
+
+	policy = &AMPolicyData{
+		ServAreaRes: association.ServAreaRes,
+		Rfsp:        association.Rfsp,
+	}
+	// the association id is the last segment of the resource URI, TS 29.507 4.2.2.2
+	if location := httpRsp.Header.Get("Location"); location != "" {
+		policy.PolAssoId = location[strings.LastIndex(location, "/")+1:]
+	}
+	logger.ConsumerLog.Infof("am policy association[%s] created for SUPI[%s]", policy.PolAssoId, supi)
+
+	var dropped []*AMPolicyData
+	c.lock.Lock()
+	// the association of a concurrent retrieval is replaced
+	if previous := c.drop(supi); previous != nil {
+		dropped = append(dropped, previous)
+	}
+	c.policies[supi] = policy
+	c.order = append(c.order, supi)
+	for len(c.order) > maxAMPolicies {
+		dropped = append(dropped, c.drop(c.order[0]))
+	}
+	c.lock.Unlock()
+	for _, previous := range dropped {
+		c.deleteAssociation(previous)
+	}
+	return policy, nil
+}
+
+// Invalidate drops the cached AM policy of the UE and deletes its association, the next
+// GetAMPolicy retrieves it again
+func (c *PCFAMPolicyClient) Invalidate(supi string) {
+	c.lock.Lock()
+	policy := c.drop(supi)
+	c.lock.Unlock()
+	if policy != nil {
+		c.deleteAssociation(policy)
+	}
+}
+
+// drop removes the cached AM policy of the UE and returns it, nil if none. The caller holds lock.
+func (c *PCFAMPolicyClient) drop(supi string) *AMPolicyData {
+	policy, ok := c.policies[supi]
+	if !ok {
+		return nil
+	}
+	delete(c.policies, supi)
+	if i := slices.Index(c.order, supi); i >= 0 {
+		c.order = slices.Delete(c.order, i, i+1)
+	}
+	return policy
+}
+
+// deleteAssociation deletes the AM policy association of the policy at the PCF, an association
+// already terminated by the PCF being gone. TS 29.507 4.2.4
+func (c *PCFAMPolicyClient) deleteAssociation(policy *AMPolicyData) {
+	if policy.PolAssoId == "" {
+		return
+	}
+	httpRsp, err := c.client.DefaultApi.PoliciesPolAssoIdDelete(context.Background(), policy.PolAssoId)
+	if httpRsp != nil && httpRsp.StatusCode == http.StatusNotFound {
+		return
+	}
+	if err != nil {
+		logger.ConsumerLog.Warnf("delete am policy association[%s] failed: %v", policy.PolAssoId, err)
+		return
+	}
+	logger.ConsumerLog.Infof("am policy association[%s] deleted", policy.PolAssoId)
+}
+
+// CheckServiceArea validates the location of the UE against the service area restriction of the
+// policy. Only the TACs of the restriction are checked, the area codes cannot be resolved by the SMF.
+func (policy *AMPolicyData) CheckServiceArea(location *models.UserLocation) error {
+	if policy == nil || policy.ServAreaRes == nil || location == nil {
+		return nil
+	}
+	var tai *models.Tai
+	switch {
+	case location.NrLocation != nil:
+		tai = location.NrLocation.Tai
+	case location.EutraLocation != nil:
+		tai = location.EutraLocation.Tai
+	}
+	if tai == nil {
+		return nil
+	}
+
+	var tacs []string
+	for _, area := range policy.ServAreaRes.Areas {
+		tacs = append(tacs, area.Tacs...)
+	}
+	inArea := slices.ContainsFunc(tacs, func(tac string) bool {
+		return strings.EqualFold(tac, tai.Tac)
+	})
+
+	switch policy.ServAreaRes.RestrictionType {
+	case models.RestrictionType_ALLOWED_AREAS:
+		if len(tacs) > 0 && !inArea {
+			return fmt.Errorf("TAC[%s] outside the allowed areas", tai.Tac)
+		}
+	case models.RestrictionType_NOT_ALLOWED_AREAS:
+		if inArea {
+			return fmt.Errorf("TAC[%s] in a not allowed area", tai.Tac)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newAMPolicyPCF starts a PCF creating the AM policy associations, counted in calls, and
+// recording the associations deleted
+func newAMPolicyPCF(t *testing.T, servAreaRes *models.ServiceAreaRestriction, calls *int, deleted *[]string) *httptest.Server {
+	// the PCF client speaks HTTP/2 over cleartext
+	pcf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			*deleted = append(*deleted, strings.TrimPrefix(r.URL.Path, "/npcf-am-policy-control/v1/policies/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var request models.PolicyAssociationRequest
+		if r.Method != http.MethodPost || r.URL.Path != "/npcf-am-policy-control/v1/policies" ||
+			json.NewDecoder(r.Body).Decode(&request) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "http://pcf/npcf-am-policy-control/v1/policies/"+request.Supi+"-1")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(models.PolicyAssociation{
+			ServAreaRes: servAreaRes,
+			Rfsp:        3,
+			SuppFeat:    "F",
+		})
+	}), &http2.Server{}))
+	t.Cleanup(pcf.Close)
+	return pcf
+}
+
+func TestGetAMPolicyCachesPerSupi(t *testing.T) {
+	calls := 0
+	var deleted []string
+	pcf := newAMPolicyPCF(t, &models.ServiceAreaRestriction{
+		RestrictionType: models.RestrictionType_ALLOWED_AREAS,
+		Areas:           []models.Area{{Tacs: []string{"000001"}}},
+	}, &calls, &deleted)
+	client := NewPCFAMPolicyClient(pcf.URL)
+
+	policy, err := client.GetAMPolicy("imsi-208930000000001")
+	require.NoError(t, err)
+	require.Equal(t, "imsi-208930000000001-1", policy.PolAssoId)
+	require.Equal(t, int32(3), policy.Rfsp)
+	require.Equal(t, models.RestrictionType_ALLOWED_AREAS, policy.ServAreaRes.RestrictionType)
+
+	cached, err := client.GetAMPolicy("imsi-208930000000001")
+	require.NoError(t, err)
+	require.Same(t, policy, cached)
+	require.Equal(t, 1, calls)
+
+	_, err = client.GetAMPolicy("imsi-208930000000002")
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+
+	// the association of the invalidated policy is deleted
+	client.Invalidate("imsi-208930000000001")
+	require.Equal(t, []string{"imsi-208930000000001-1"}, deleted)
+	_, err = client.GetAMPolicy("imsi-208930000000001")
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestGetAMPolicyBoundedCache(t *testing.T) {
+	origMaxAMPolicies := maxAMPolicies
+	t.Cleanup(func() { maxAMPolicies = origMaxAMPolicies })
+	maxAMPolicies = 2
+
+	calls := 0
+	var deleted []string
+	pcf := newAMPolicyPCF(t, nil, &calls, &deleted)
+	client := NewPCFAMPolicyClient(pcf.URL)
+
+	for _, supi := range []string{"imsi-208930000000001", "imsi-208930000000002", "imsi-208930000000003"} {
+		_, err := client.GetAMPolicy(supi)
+		require.NoError(t, err)
+	}
+	// the oldest policy is dropped and its association deleted
+	require.Equal(t, []string{"imsi-208930000000001-1"}, deleted)
+	client.lock.Lock()
+	require.Len(t, client.policies, 2)
+	require.Equal(t, []string{"imsi-208930000000002", "imsi-208930000000003"}, client.order)
+	client.lock.Unlock()
+
+	_, err := client.GetAMPolicy("imsi-208930000000001")
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+	require.Equal(t, []string{"imsi-208930000000001-1", "imsi-208930000000002-1"}, deleted)
+}
+
+func TestGetAMPolicyFailure(t *testing.T) {
+	pcf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(models.ProblemDetails{Status: http.StatusForbidden})
+	}), &http2.Server{}))
+	defer pcf.Close()
+	client := NewPCFAMPolicyClient(pcf.URL)
+
+	_, err := client.GetAMPolicy("imsi-208930000000001")
+	require.Error(t, err)
+
+	// failures are not cached
+	client.lock.Lock()
+	require.Empty(t, client.policies)
+	client.lock.Unlock()
+}
+
+func TestAMPolicyClientForPCF(t *testing.T) {
+	require.Nil(t, AMPolicyClientForPCF(models.NfProfile{}))
+
+	profile := models.NfProfile{
+		NfServices: &[]models.NfService{
+			{ServiceName: models.ServiceName_NPCF_SMPOLICYCONTROL, ApiPrefix: "http://pcf-sm"},
+			{ServiceName: models.ServiceName_NPCF_AM_POLICY_CONTROL, ApiPrefix: "http://pcf-am"},
+		},
+	}
+	client := AMPolicyClientForPCF(profile)
+	require.NotNil(t, client)
+	require.Same(t, client, AMPolicyClientForPCF(profile))
+	t.Cleanup(func() {
+		amPolicyClientsLock.Lock()
+		delete(amPolicyClients, "http://pcf-am")
+		amPolicyClientsLock.Unlock()
+	})
+
+	client.policies["imsi-208930000000001"] = &AMPolicyData{}
+	InvalidateAMPolicy("imsi-208930000000001")
+	require.Empty(t, client.policies)
+}
+
+func TestCheckServiceArea(t *testing.T) {
+	nrLocation := func(tac string) *models.UserLocation {
+		return &models.UserLocation{NrLocation: &models.NrLocation{Tai: &models.Tai{Tac: tac}}}
+	}
+	allowed := &AMPolicyData{ServAreaRes: &models.ServiceAreaRestriction{
+		RestrictionType: models.RestrictionType_ALLOWED_AREAS,
+		Areas:           []models.Area{{Tacs: []string{"000001", "000002"}}},
+	}}
+	notAllowed := &AMPolicyData{ServAreaRes: &models.ServiceAreaRestriction{
+		RestrictionType: models.RestrictionType_NOT_ALLOWED_AREAS,
+		Areas:           []models.Area{{Tacs: []string{"000003"}}},
+	}}
+
+	require.NoError(t, allowed.CheckServiceArea(nrLocation("000002")))
+	require.Error(t, allowed.CheckServiceArea(nrLocation("000003")))
+	require.NoError(t, notAllowed.CheckServiceArea(nrLocation("000001")))
+	require.Error(t, notAllowed.CheckServiceArea(nrLocation("000003")))
+	require.Error(t, notAllowed.CheckServiceArea(&models.UserLocation{
+		EutraLocation: &models.EutraLocation{Tai: &models.Tai{Tac: "000003"}},
+	}))
+
+	// nothing to validate against
+	require.NoError(t, allowed.CheckServiceArea(nil))
+	require.NoError(t, (&AMPolicyData{}).CheckServiceArea(nrLocation("000003")))
+	require.NoError(t, (*AMPolicyData)(nil).CheckServiceArea(nrLocation("000003")))
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/omec-project/nas/nasConvert"
+	"github.com/omec-project/smf/cdr"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/nchf"
+)
+
+var (
+	// writer of the CDR files, nil if not configured
+	cdrWriter *cdr.BERCDRWriter
+	// local sequence number of the CDRs
+	cdrSequenceNumber atomic.Int64
+)
+
+// InitCDRWriter starts writing the CDRs of the released sessions in the configured directory
+func InitCDRWriter(config *factory.CDRConfig) {
+	if config == nil {
+		return
+	}
+	cdrWriter = cdr.NewBERCDRWriter(config.Directory, config.RecordsPerFile,
+		smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp())
+	logger.ConsumerLog.Infof("CDR files written in %s", config.Directory)
+}
+
+// CloseCDRWriter closes the current CDR file, its records being already written
+func CloseCDRWriter() {
+	if cdrWriter == nil {
+		return
+	}
+	if err := cdrWriter.Close(); err != nil {
+		logger.ConsumerLog.Errorf("close CDR file failed: %v", err)
+	}
+}
+
+// SendChargingDataCreate opens the charging of the PDU session at the CHF, the charging data
+// reference of the session being recorded for its updates and release. Nothing is sent when no
+// CHF is configured.
+var SendChargingDataCreate = func(smContext *smf_context.SMContext) error {
+	client := nchf.GetCHFClient()
+	if client == nil {
+		return nil
+	}
+	request := chargingDataRequest(smContext)
+	chargingDataRef, err := client.Create(request)
+	if err != nil {
+		return err
+	}
+	smContext.ChargingDataRef = chargingDataRef
+	smContext.SubConsumerLog.Infof("charging opened, charging data ref[%s]", chargingDataRef)
+	return nil
+}
+
+// SendChargingDataRelease closes the charging of the PDU session at the CHF with the final usage
+// reported by the UPFs, one unit usage per UPF and URR, and the secondary RAT usage reported by the
+// NG-RAN since the previous request. The final usage is logged, and written in a CDR file when
+// configured.
+var SendChargingDataRelease = func(smContext *smf_context.SMContext, finalUsage []smf_context.UsageReport) error {
+	for _, usage := range finalUsage {
+		smContext.SubConsumerLog.Infof("charging release, UPF[%s] URR[%d] volume[total: %d, ul: %d, dl: %d] duration[%ds]",
+			usage.UpfIP, usage.URRID, usage.TotalVolume, usage.UplinkVolume, usage.DownlinkVolume, usage.Duration)
+	}
+	closed := time.Now()
+	var errs []error
+	if client := nchf.GetCHFClient(); client != nil && smContext.ChargingDataRef != "" {
+		request := chargingDataRequest(smContext)
+		request.PDUSessionChargingInformation.RANSecondaryRATUsageReport = chargeSecondaryRATUsage(smContext)
+		trigger := nchf.Trigger{TriggerType: nchf.TriggerTypeFinal, TriggerCategory: nchf.TriggerCategoryImmediateReport}
+		request.Triggers = []nchf.Trigger{trigger}
+		for _, usage := range finalUsage {
+			request.MultipleUnitUsage = append(request.MultipleUnitUsage, nchf.MultipleUnitUsage{
+				RatingGroup: client.RatingGroup(),
+				UsedUnitContainer: []nchf.UsedUnitContainer{{
+					Triggers:            []nchf.Trigger{trigger},
+					TriggerTimestamp:    &closed,
+					Time:                usage.Duration,
+					TotalVolume:         usage.TotalVolume,
+					UplinkVolume:        usage.UplinkVolume,
+					DownlinkVolume:      usage.DownlinkVolume,
+					LocalSequenceNumber: request.InvocationSequenceNumber,
+				}},
+				UPFID: usage.UpfIP,
+			})
+		}
+		if err := client.Release(smContext.ChargingDataRef, request); err != nil {
+			errs = append(errs, err)
+		} else {
+			smContext.ChargingDataRef = ""
+		}
+	}
+	if cdrWriter != nil {
+		record := cdr.NewSMFRecord(smContext, finalUsage, closed, cdrSequenceNumber.Add(1))
+		if err := cdrWriter.Write(record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendChargingDataUpdate sends the usage of the PDU session closed by a change of condition to the
+// CHF, with the secondary RAT usage reported by the NG-RAN since the previous request. The update
+// is logged.
+var SendChargingDataUpdate = func(smContext *smf_context.SMContext, update *smf_context.ChargingUpdate) error {
+	usage := update.Usage
+	smContext.SubConsumerLog.Infof("charging update, trigger[%s] RAT[%s -> %s] volume[total: %d, ul: %d, dl: %d] duration[%ds]",
+		update.Trigger, update.PreviousRATType, update.RATType, usage.TotalVolume, usage.UplinkVolume,
+		usage.DownlinkVolume, usage.Duration)
+	client := nchf.GetCHFClient()
+	if client == nil || smContext.ChargingDataRef == "" {
+		return nil
+	}
+	request := chargingDataRequest(smContext)
+	request.PDUSessionChargingInformation.RANSecondaryRATUsageReport = chargeSecondaryRATUsage(smContext)
+	trigger := nchf.Trigger{TriggerType: string(update.Trigger), TriggerCategory: nchf.TriggerCategoryImmediateReport}
+	request.Triggers = []nchf.Trigger{trigger}
+	request.MultipleUnitUsage = []nchf.MultipleUnitUsage{{
+		RatingGroup: client.RatingGroup(),
+		UsedUnitContainer: []nchf.UsedUnitContainer{{
+			Triggers:            []nchf.Trigger{trigger},
+			TriggerTimestamp:    &update.Time,
+			Time:                usage.Duration,
+			TotalVolume:         usage.TotalVolume,
+			UplinkVolume:        usage.UplinkVolume,
+			DownlinkVolume:      usage.DownlinkVolume,
+			LocalSequenceNumber: request.InvocationSequenceNumber,
+		}},
+	}}
+	return client.Update(smContext.ChargingDataRef, request)
+}
+
+// chargingDataRequest returns the Charging Data Request of the PDU session, with the next
+// invocation sequence number of the session
+func chargingDataRequest(smContext *smf_context.SMContext) *nchf.ChargingDataRequest {
+	smfSelf := smf_context.SMF_Self()
+	request := &nchf.ChargingDataRequest{
+		SubscriberIdentifier: smContext.Supi,
+		NfConsumerIdentification: nchf.NFIdentification{
+			NFName:            smfSelf.NfInstanceID,
+			NodeFunctionality: nchf.NodeFunctionalitySMF,
+		},
+		InvocationTimeStamp: time.Now(),
+		PDUSessionChargingInformation: &nchf.PDUSessionChargingInformation{
+			ChargingId: smContext.ChargingID(),
+			PduSessionInformation: nchf.PDUSessionInformation{
+				PduSessionID: smContext.PDUSessionID,
+				PduType:      nasConvert.PDUSessionTypeToModels(smContext.SelectedPDUSessionType),
+				DnnId:        smContext.Dnn,
+				RatType:      smContext.RatType,
+			},
+		},
+	}
+	if smContext.ChargingDataRef != "" {
+		smContext.ChargingInvocationSeq++
+	}
+	request.InvocationSequenceNumber = smContext.ChargingInvocationSeq
+	if ip := smfSelf.CPNodeID.ResolveNodeIdToIp(); ip.To4() != nil {
+		request.NfConsumerIdentification.NFIPv4Address = ip.String()
+	} else if ip != nil {
+		request.NfConsumerIdentification.NFIPv6Address = ip.String()
+	}
+	sessionInformation := &request.PDUSessionChargingInformation.PduSessionInformation
+	if smContext.Snssai != nil {
+		sessionInformation.NetworkSlicingInfo = &nchf.NetworkSlicingInfo{SNSSAI: *smContext.Snssai}
+	}
+	if smContext.PDUAddress != nil {
+		if ip := smContext.PDUAddress.Ip.To4(); ip != nil && !ip.IsUnspecified() {
+			sessionInformation.PduAddress = &nchf.PDUAddress{PduIPv4Address: ip.String()}
+		}
+	}
+	return request
+}
+
+// chargeSecondaryRATUsage returns the report of the secondary RAT usage of the session not sent to
+// the CHF yet, nil if none
+func chargeSecondaryRATUsage(smContext *smf_context.SMContext) *nchf.RANSecondaryRATUsageReport {
+	usage := smContext.ChargeSecondaryRATUsage()
+	if len(usage) == 0 {
+		return nil
+	}
+	report := &nchf.RANSecondaryRATUsageReport{RANSecondaryRATType: usage[0].RATType}
+	for _, u := range usage {
+		report.QosFlowsUsageReports = append(report.QosFlowsUsageReports, nchf.QosFlowsUsageReport{
+			QFI:            u.QFI,
+			StartTimestamp: u.StartTime,
+			EndTimestamp:   u.EndTime,
+			UplinkVolume:   u.UplinkVolume,
+			DownlinkVolume: u.DownlinkVolume,
+		})
+	}
+	return report
+}
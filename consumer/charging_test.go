@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/nchf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newChargingCHF starts a CHF opening the charging of the sessions under their SUPI, and recording
+// the Charging Data Requests by operation
+func newChargingCHF(t *testing.T, requests map[string]*nchf.ChargingDataRequest) {
+	t.Helper()
+	const path = "/nchf-convergedcharging/v3/chargingdata"
+	chf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &nchf.ChargingDataRequest{}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(request) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == path {
+			requests["create"] = request
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "http://"+r.Host+path+"/"+request.SubscriberIdentifier)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(nchf.ChargingDataResponse{
+				InvocationSequenceNumber: request.InvocationSequenceNumber,
+			})
+			return
+		}
+		operation := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		requests[operation] = request
+		switch operation {
+		case "release":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(nchf.ChargingDataResponse{
+				InvocationSequenceNumber: request.InvocationSequenceNumber,
+			})
+		}
+	}), &http2.Server{}))
+	t.Cleanup(chf.Close)
+	nchf.InitCHFClient(&factory.ChfConfig{Uri: chf.URL, RatingGroup: 7})
+	t.Cleanup(func() { nchf.InitCHFClient(nil) })
+}
+
+func newChargedSMContext(supi string) *smf_context.SMContext {
+	smContext := contexttest.NewSMContext(supi, 5, &models.Snssai{Sst: 1, Sd: "010203"}, nil)
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	smContext.RatType = models.RatType_NR
+	smContext.PDUAddress = &smf_context.UeIpAddr{Ip: net.ParseIP("10.60.0.5").To4()}
+	smContext.PFCPContext["10.0.7.1"] = &smf_context.PFCPSessionContext{LocalSEID: 42}
+	return smContext
+}
+
+func TestSendChargingDataCreateRelease(t *testing.T) {
+	requests := make(map[string]*nchf.ChargingDataRequest)
+	newChargingCHF(t, requests)
+	smContext := newChargedSMContext("imsi-208930000000401")
+
+	require.NoError(t, SendChargingDataCreate(smContext))
+	require.Equal(t, "imsi-208930000000401", smContext.ChargingDataRef)
+	create := requests["create"]
+	require.NotNil(t, create)
+	require.Equal(t, "imsi-208930000000401", create.SubscriberIdentifier)
+	require.Equal(t, nchf.NodeFunctionalitySMF, create.NfConsumerIdentification.NodeFunctionality)
+	require.Zero(t, create.InvocationSequenceNumber)
+	require.Equal(t, nchf.PDUSessionChargingInformation{
+		ChargingId: 42,
+		PduSessionInformation: nchf.PDUSessionInformation{
+			NetworkSlicingInfo: &nchf.NetworkSlicingInfo{SNSSAI: models.Snssai{Sst: 1, Sd: "010203"}},
+			PduSessionID:       5,
+			PduType:            models.PduSessionType_IPV4,
+			DnnId:              contexttest.Dnn,
+			RatType:            models.RatType_NR,
+			PduAddress:         &nchf.PDUAddress{PduIPv4Address: "10.60.0.5"},
+		},
+	}, *create.PDUSessionChargingInformation)
+	require.Empty(t, create.MultipleUnitUsage)
+
+	// the final usage of each UPF is a unit usage of the rating group
+	require.NoError(t, SendChargingDataRelease(smContext, []smf_context.UsageReport{
+		{UpfIP: "10.0.7.1", URRID: 1, TotalVolume: 3000, UplinkVolume: 1000, DownlinkVolume: 2000, Duration: 60},
+		{UpfIP: "10.0.7.2", URRID: 1, TotalVolume: 500, UplinkVolume: 100, DownlinkVolume: 400, Duration: 60},
+	}))
+	release := requests["release"]
+	require.NotNil(t, release)
+	require.Equal(t, uint32(1), release.InvocationSequenceNumber)
+	final := nchf.Trigger{TriggerType: nchf.TriggerTypeFinal, TriggerCategory: nchf.TriggerCategoryImmediateReport}
+	require.Equal(t, []nchf.Trigger{final}, release.Triggers)
+	require.Len(t, release.MultipleUnitUsage, 2)
+	for i, usage := range []struct {
+		upfID                   string
+		total, uplink, downlink uint64
+	}{{"10.0.7.1", 3000, 1000, 2000}, {"10.0.7.2", 500, 100, 400}} {
+		unitUsage := release.MultipleUnitUsage[i]
+		require.Equal(t, uint32(7), unitUsage.RatingGroup)
+		require.Equal(t, usage.upfID, unitUsage.UPFID)
+		require.Len(t, unitUsage.UsedUnitContainer, 1)
+		container := unitUsage.UsedUnitContainer[0]
+		require.Equal(t, []nchf.Trigger{final}, container.Triggers)
+		require.NotNil(t, container.TriggerTimestamp)
+		require.Equal(t, uint32(60), container.Time)
+		require.Equal(t, usage.total, container.TotalVolume)
+		require.Equal(t, usage.uplink, container.UplinkVolume)
+		require.Equal(t, usage.downlink, container.DownlinkVolume)
+	}
+	require.Empty(t, smContext.ChargingDataRef, "charging closed")
+}
+
+func TestSendChargingDataUpdateRATChange(t *testing.T) {
+	requests := make(map[string]*nchf.ChargingDataRequest)
+	newChargingCHF(t, requests)
+	smContext := newChargedSMContext("imsi-208930000000403")
+	require.NoError(t, SendChargingDataCreate(smContext))
+
+	changed := time.Now()
+	require.NoError(t, SendChargingDataUpdate(smContext, &smf_context.ChargingUpdate{
+		Trigger:         smf_context.ChargingTriggerRATChange,
+		Time:            changed,
+		RATType:         models.RatType_EUTRA,
+		PreviousRATType: models.RatType_NR,
+		Usage:           smf_context.SessionStats{TotalVolume: 900, UplinkVolume: 300, DownlinkVolume: 600, Duration: 30},
+	}))
+	update := requests["update"]
+	require.NotNil(t, update)
+	require.Equal(t, uint32(1), update.InvocationSequenceNumber)
+	ratChange := nchf.Trigger{TriggerType: "RAT_CHANGE", TriggerCategory: nchf.TriggerCategoryImmediateReport}
+	require.Equal(t, []nchf.Trigger{ratChange}, update.Triggers)
+	require.Len(t, update.MultipleUnitUsage, 1)
+	require.Equal(t, uint32(7), update.MultipleUnitUsage[0].RatingGroup)
+	require.Len(t, update.MultipleUnitUsage[0].UsedUnitContainer, 1)
+	container := update.MultipleUnitUsage[0].UsedUnitContainer[0]
+	require.Equal(t, []nchf.Trigger{ratChange}, container.Triggers)
+	require.True(t, changed.Equal(*container.TriggerTimestamp))
+	require.Equal(t, uint32(30), container.Time)
+	require.Equal(t, uint64(900), container.TotalVolume)
+	require.Equal(t, uint64(300), container.UplinkVolume)
+	require.Equal(t, uint64(600), container.DownlinkVolume)
+
+	// the release follows the update
+	require.NoError(t, SendChargingDataRelease(smContext, nil))
+	require.Equal(t, uint32(2), requests["release"].InvocationSequenceNumber)
+}
+
+func TestSendChargingDataSecondaryRATUsage(t *testing.T) {
+	requests := make(map[string]*nchf.ChargingDataRequest)
+	newChargingCHF(t, requests)
+	smContext := newChargedSMContext("imsi-208930000000404")
+	require.NoError(t, SendChargingDataCreate(smContext))
+
+	end := time.Date(2026, time.March, 14, 15, 9, 26, 0, time.UTC)
+	start := end.Add(-time.Minute)
+	smContext.AddSecondaryRATUsage([]smf_context.SecondaryRATUsage{
+		{RATType: models.RatType_EUTRA, StartTime: start, EndTime: end, UplinkVolume: 400, DownlinkVolume: 3000},
+		{RATType: models.RatType_EUTRA, QFI: 5, StartTime: start, EndTime: end, UplinkVolume: 100, DownlinkVolume: 700},
+	})
+	require.NoError(t, SendChargingDataUpdate(smContext, &smf_context.ChargingUpdate{
+		Trigger: smf_context.ChargingTriggerRATChange, Time: end, RATType: models.RatType_NR,
+	}))
+	update := requests["update"]
+	require.NotNil(t, update)
+	require.Equal(t, &nchf.RANSecondaryRATUsageReport{
+		RANSecondaryRATType: models.RatType_EUTRA,
+		QosFlowsUsageReports: []nchf.QosFlowsUsageReport{
+			{StartTimestamp: start, EndTimestamp: end, UplinkVolume: 400, DownlinkVolume: 3000},
+			{QFI: 5, StartTimestamp: start, EndTimestamp: end, UplinkVolume: 100, DownlinkVolume: 700},
+		},
+	}, update.PDUSessionChargingInformation.RANSecondaryRATUsageReport)
+
+	// sent once
+	require.NoError(t, SendChargingDataRelease(smContext, nil))
+	require.Nil(t, requests["release"].PDUSessionChargingInformation.RANSecondaryRATUsageReport)
+}
+
+func TestSendChargingDataReleaseNotOpened(t *testing.T) {
+	requests := make(map[string]*nchf.ChargingDataRequest)
+	newChargingCHF(t, requests)
+	smContext := newChargedSMContext("imsi-208930000000402")
+
+	// no charging data at the CHF, nothing to release
+	require.NoError(t, SendChargingDataRelease(smContext, nil))
+	require.Empty(t, requests)
+}
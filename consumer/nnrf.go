@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/antihax/optional"
@@ -19,6 +20,7 @@ import (
 	"github.com/omec-project/openapi/Nnrf_NFDiscovery"
 	"github.com/omec-project/openapi/Nnrf_NFManagement"
 	"github.com/omec-project/openapi/Nudm_SubscriberDataManagement"
+	"github.com/omec-project/openapi/Nudm_UEAuthentication"
 	"github.com/omec-project/openapi/models"
 	nrfCache "github.com/omec-project/openapi/nrfcache"
 	smf_context "github.com/omec-project/smf/context"
@@ -27,6 +29,31 @@ import (
 	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
 )
 
+// NFProfileHook receives the NFProfile of the SMF before it is registered to the NRF
+// and may change it, e.g. to set the locality or custom information
+type NFProfileHook func(profile *models.NfProfile)
+
+var (
+	nfProfileHooks     []NFProfileHook
+	nfProfileHooksLock sync.RWMutex
+)
+
+// RegisterNFProfileHook adds a hook applied to the NFProfile on every NRF registration,
+// hooks are applied in the order they are registered
+func RegisterNFProfileHook(hook NFProfileHook) {
+	nfProfileHooksLock.Lock()
+	defer nfProfileHooksLock.Unlock()
+	nfProfileHooks = append(nfProfileHooks, hook)
+}
+
+func applyNFProfileHooks(profile *models.NfProfile) {
+	nfProfileHooksLock.RLock()
+	defer nfProfileHooksLock.RUnlock()
+	for _, hook := range nfProfileHooks {
+		hook(profile)
+	}
+}
+
 func SendNFRegistration() (*models.NfProfile, error) {
 	var rep models.NfProfile
 	sNssais := []models.Snssai{}
@@ -42,16 +69,21 @@ func SendNFRegistration() (*models.NfProfile, error) {
 
 	// set nfProfile
 	profile := models.NfProfile{
-		NfInstanceId:  smf_context.SMF_Self().NfInstanceID,
-		NfType:        models.NfType_SMF,
-		NfStatus:      models.NfStatus_REGISTERED,
-		Ipv4Addresses: []string{smf_context.SMF_Self().RegisterIPv4},
-		NfServices:    smf_context.NFServices,
-		SmfInfo:       smf_context.SmfInfo,
-		SNssais:       &sNssais,
-		PlmnList:      smf_context.SmfPlmnConfig(),
-		AllowedPlmns:  smf_context.SmfPlmnConfig(),
+		NfInstanceId: smf_context.SMF_Self().NfInstanceID,
+		NfType:       models.NfType_SMF,
+		NfStatus:     models.NfStatus_REGISTERED,
+		NfServices:   smf_context.NFServices,
+		SmfInfo:      smf_context.SmfInfo,
+		SNssais:      &sNssais,
+		PlmnList:     smf_context.SmfPlmnConfig(),
+		AllowedPlmns: smf_context.SmfPlmnConfig(),
+	}
+	if ipv4, fqdn := smf_context.SMF_Self().NFProfileAddress(); ipv4 != "" {
+		profile.Ipv4Addresses = []string{ipv4}
+	} else {
+		profile.Fqdn = fqdn
 	}
+	applyNFProfileHooks(&profile)
 
 	var res *http.Response
 	var err error
@@ -97,6 +129,7 @@ func SendNFRegistration() (*models.NfProfile, error) {
 		return &rep, fmt.Errorf("NRF Registration failure, [%v]", http.StatusText(res.StatusCode))
 	}
 
+	smf_context.SMF_Self().NrfRegistered.Store(true)
 	logger.InitLog.Infof("SMF Registration to NRF %v", rep)
 	return &rep, nil
 }
@@ -232,12 +265,9 @@ func SendNrfForNfInstance(nrfUri string, targetNfType, requestNfType models.NfTy
 	for _, nfProfile := range result.NfInstances {
 		if _, ok := smfSelf.NfStatusSubscriptions.Load(nfProfile.NfInstanceId); !ok {
 			nrfSubscriptionData := models.NrfSubscriptionData{
-				NfStatusNotificationUri: fmt.Sprintf("%s://%s:%d/nsmf-callback/v1/nf-status-notify",
-					smfSelf.URIScheme,
-					smfSelf.RegisterIPv4,
-					smfSelf.SBIPort),
-				SubscrCond: &models.NfInstanceIdCond{NfInstanceId: nfProfile.NfInstanceId},
-				ReqNfType:  requestNfType,
+				NfStatusNotificationUri: smfSelf.SBIUri() + "/nsmf-callback/v1/nf-status-notify",
+				SubscrCond:              &models.NfInstanceIdCond{NfInstanceId: nfProfile.NfInstanceId},
+				ReqNfType:               requestNfType,
 			}
 			nrfSubData, problemDetails, err := SendCreateSubscription(nrfUri, nrfSubscriptionData, targetNfType)
 			if problemDetails != nil {
@@ -272,6 +302,11 @@ func SendNFDiscoveryUDM() (*models.ProblemDetails, error) {
 				SDMConf.SetBasePath(service.ApiPrefix)
 				smf_context.SMF_Self().SubscriberDataManagementClient = Nudm_SubscriberDataManagement.NewAPIClient(SDMConf)
 			}
+			if service.ServiceName == models.ServiceName_NUDM_UEAU {
+				UEAUConf := Nudm_UEAuthentication.NewConfiguration()
+				UEAUConf.SetBasePath(service.ApiPrefix)
+				smf_context.SMF_Self().UEAuthenticationClient = Nudm_UEAuthentication.NewAPIClient(UEAUConf)
+			}
 		}
 
 		if smf_context.SMF_Self().SubscriberDataManagementClient == nil {
@@ -316,10 +351,30 @@ func SendNFDiscoveryPCF() (problemDetails *models.ProblemDetails, err error) {
 	return problemDetails, err
 }
 
+// servingAMFProfile returns the profile of the serving AMF among the AMFs of its set, the first
+// one if it is not found
+func servingAMFProfile(profiles []models.NfProfile, servingNfID string) models.NfProfile {
+	for _, profile := range profiles {
+		if profile.NfInstanceId == servingNfID {
+			return profile
+		}
+	}
+	return profiles[0]
+}
+
 func SendNFDiscoveryServingAMF(smContext *smf_context.SMContext) (*models.ProblemDetails, error) {
 	localVarOptionals := Nnrf_NFDiscovery.SearchNFInstancesParamOpts{}
 
-	localVarOptionals.TargetNfInstanceId = optional.NewInterface(smContext.ServingNfId)
+	// the AMFs of the AMF set of the serving AMF share the contexts of its UEs, the N1N2 message
+	// transfers being balanced over them. Only the serving AMF is discovered without GUAMI.
+	amfSetKey, regionID, setID, ok := smf_context.AMFSetKey(smContext.Guami)
+	if ok {
+		localVarOptionals.AmfRegionId = optional.NewString(regionID)
+		localVarOptionals.AmfSetId = optional.NewString(setID)
+	} else {
+		amfSetKey = smContext.ServingNfId
+		localVarOptionals.TargetNfInstanceId = optional.NewInterface(smContext.ServingNfId)
+	}
 
 	var result models.SearchResult
 	var localErr error
@@ -331,11 +386,12 @@ func SendNFDiscoveryServingAMF(smContext *smf_context.SMContext) (*models.Proble
 	}
 
 	if localErr == nil {
-		if result.NfInstances == nil {
+		if len(result.NfInstances) == 0 {
 			return nil, openapi.ReportError("NfInstances is nil")
 		}
 		smContext.SubConsumerLog.Info("send NF Discovery Serving AMF Successful")
-		smContext.AMFProfile = deepcopy.Copy(result.NfInstances[0]).(models.NfProfile)
+		smContext.AMFProfile = deepcopy.Copy(servingAMFProfile(result.NfInstances, smContext.ServingNfId)).(models.NfProfile)
+		smContext.AMFClient = smf_context.AMFSetClient(amfSetKey, result.NfInstances)
 	} else {
 		apiError, ok := localErr.(openapi.GenericOpenAPIError)
 		if ok {
@@ -360,6 +416,7 @@ func SendDeregisterNFInstance() (*models.ProblemDetails, error) {
 		DeregisterNFInstance(context.Background(), smfSelf.NfInstanceID)
 	metrics.IncrementSvcNrfMsgStats(smfSelf.NfInstanceID, string(svcmsgtypes.NnrfNFInstanceDeRegister), "Out", "", "")
 	if err == nil {
+		smfSelf.NrfRegistered.Store(false)
 		metrics.IncrementSvcNrfMsgStats(smfSelf.NfInstanceID, string(svcmsgtypes.NnrfNFInstanceDeRegister), "In", http.StatusText(res.StatusCode), "")
 		return nil, err
 	} else if res != nil {
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/omec-project/openapi/Nnrf_NFManagement"
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestSendNFRegistrationAppliesProfileHooks(t *testing.T) {
+	var registered models.NfProfile
+	// the NRF client speaks HTTP/2 over cleartext
+	nrf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || json.NewDecoder(r.Body).Decode(&registered) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(registered)
+	}), &http2.Server{}))
+	defer nrf.Close()
+
+	smfSelf := smf_context.SMF_Self()
+	origClient := smfSelf.NFManagementClient
+	origSmfInfo := smf_context.SmfInfo
+	defer func() {
+		smfSelf.NFManagementClient = origClient
+		smf_context.SmfInfo = origSmfInfo
+		nfProfileHooks = nil
+	}()
+	configuration := Nnrf_NFManagement.NewConfiguration()
+	configuration.SetBasePath(nrf.URL)
+	smfSelf.NFManagementClient = Nnrf_NFManagement.NewAPIClient(configuration)
+	smf_context.SmfInfo = &models.SmfInfo{
+		SNssaiSmfInfoList: &[]models.SnssaiSmfInfoItem{
+			{SNssai: &models.Snssai{Sst: 1, Sd: "010203"}},
+		},
+	}
+
+	RegisterNFProfileHook(func(profile *models.NfProfile) {
+		profile.Locality = "site-a"
+	})
+	RegisterNFProfileHook(func(profile *models.NfProfile) {
+		profile.CustomInfo = map[string]interface{}{"locality": profile.Locality}
+	})
+
+	rep, err := SendNFRegistration()
+	require.NoError(t, err)
+	require.Equal(t, "site-a", registered.Locality)
+	require.Equal(t, map[string]interface{}{"locality": "site-a"}, registered.CustomInfo)
+	require.Equal(t, models.NfType_SMF, registered.NfType)
+	require.Equal(t, "site-a", rep.Locality)
+}
+
+func TestSendNFRegistrationUsesAdvertisedURI(t *testing.T) {
+	var registered models.NfProfile
+	nrf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || json.NewDecoder(r.Body).Decode(&registered) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(registered)
+	}), &http2.Server{}))
+	defer nrf.Close()
+
+	smfSelf := smf_context.SMF_Self()
+	origClient := smfSelf.NFManagementClient
+	origSmfInfo := smf_context.SmfInfo
+	origNFServices := smf_context.NFServices
+	origNfServiceVersion := smf_context.NfServiceVersion
+	origURIScheme, origRegisterIPv4, origBindingIPv4 := smfSelf.URIScheme, smfSelf.RegisterIPv4, smfSelf.BindingIPv4
+	origSBIPort, origAdvertisedURI := smfSelf.SBIPort, smfSelf.AdvertisedURI
+	defer func() {
+		smfSelf.NFManagementClient = origClient
+		smf_context.SmfInfo = origSmfInfo
+		smf_context.NFServices = origNFServices
+		smf_context.NfServiceVersion = origNfServiceVersion
+		smfSelf.URIScheme, smfSelf.RegisterIPv4, smfSelf.BindingIPv4 = origURIScheme, origRegisterIPv4, origBindingIPv4
+		smfSelf.SBIPort, smfSelf.AdvertisedURI = origSBIPort, origAdvertisedURI
+	}()
+	configuration := Nnrf_NFManagement.NewConfiguration()
+	configuration.SetBasePath(nrf.URL)
+	smfSelf.NFManagementClient = Nnrf_NFManagement.NewAPIClient(configuration)
+
+	// the server binds to a local address, the NRF and the peers reach it through the NAT
+	smfSelf.URIScheme = models.UriScheme_HTTP
+	smfSelf.RegisterIPv4 = "10.0.0.5"
+	smfSelf.BindingIPv4 = "10.0.0.5"
+	smfSelf.SBIPort = 29502
+	smfSelf.AdvertisedURI = "https://smf.example.com:8443"
+	smf_context.SetupNFProfile(&factory.Config{Configuration: &factory.Configuration{
+		ServiceNameList: []string{"nsmf-pdusession"},
+	}})
+	smf_context.SmfInfo = &models.SmfInfo{
+		SNssaiSmfInfoList: &[]models.SnssaiSmfInfoItem{
+			{SNssai: &models.Snssai{Sst: 1, Sd: "010203"}},
+		},
+	}
+
+	_, err := SendNFRegistration()
+	require.NoError(t, err)
+	require.Equal(t, "smf.example.com", registered.Fqdn)
+	require.Empty(t, registered.Ipv4Addresses)
+	require.NotNil(t, registered.NfServices)
+	require.Len(t, *registered.NfServices, 1)
+	service := (*registered.NfServices)[0]
+	require.Equal(t, "https://smf.example.com:8443", service.ApiPrefix)
+	require.Equal(t, "https://smf.example.com:8443/nsmf-pdusession/v1", (*service.Versions)[0].ApiFullVersion)
+	require.Equal(t, "10.0.0.5", smfSelf.BindingIPv4)
+}
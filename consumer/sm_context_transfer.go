@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/util"
+)
+
+// TransferSessionContext transfers the session context to the new SMF of an inter-SMF handover,
+// the session being marked transferred once the new SMF adopted it. The new SMF only accepts the
+// transfers of the SMFs configured as its transfer peers.
+func TransferSessionContext(smCtxRef string, targetSmfUri string) error {
+	smContext := smf_context.GetSMContext(smCtxRef)
+	if smContext == nil {
+		return fmt.Errorf("SM context [%s] not found", smCtxRef)
+	}
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	body, err := json.Marshal(&smf_context.SMContextTransfer{SmContext: smContext})
+	if err != nil {
+		return fmt.Errorf("serialize SM context failed: %w", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost,
+		strings.TrimSuffix(targetSmfUri, "/")+"/nsmf-pdusession/v1/sm-contexts/transfer", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := util.CallSbi(req)
+	if err != nil {
+		return fmt.Errorf("SM context transfer failed: %w", err)
+	}
+	defer func() {
+		if closeErr := rsp.Body.Close(); closeErr != nil {
+			logger.ConsumerLog.Errorf("close SM context transfer response body failed: %v", closeErr)
+		}
+	}()
+	if rsp.StatusCode != http.StatusCreated {
+		detail, _ := io.ReadAll(rsp.Body)
+		return fmt.Errorf("SM context transfer rejected with status %d: %s", rsp.StatusCode, detail)
+	}
+
+	smContext.SubConsumerLog.Infof("SM context transferred to SMF [%s]", targetSmfUri)
+	smContext.MarkTransferred()
+	return nil
+}
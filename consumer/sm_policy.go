@@ -29,19 +29,21 @@ func SendSMPolicyAssociationCreate(smContext *smf_context.SMContext) (*models.Sm
 
 	smPolicyData.Supi = smContext.Supi
 	smPolicyData.PduSessionId = smContext.PDUSessionID
-	smPolicyData.NotificationUri = fmt.Sprintf("%s://%s:%d/nsmf-callback/sm-policies/%s",
-		smf_context.SMF_Self().URIScheme,
-		smf_context.SMF_Self().RegisterIPv4,
-		smf_context.SMF_Self().SBIPort,
+	smPolicyData.NotificationUri = fmt.Sprintf("%s/nsmf-callback/sm-policies/%s",
+		smf_context.SMF_Self().SBIUri(),
 		smContext.Ref,
 	)
 	smPolicyData.Dnn = smContext.Dnn
 	smPolicyData.PduSessionType = nasConvert.PDUSessionTypeToModels(smContext.SelectedPDUSessionType)
 	smPolicyData.AccessType = smContext.AnType
 	smPolicyData.RatType = smContext.RatType
-	smPolicyData.Ipv4Address = smContext.PDUAddress.Ip.To4().String()
+	// no UE IP for the Ethernet sessions
+	if ueIP := smContext.PDUAddress.Ip.To4(); ueIP != nil {
+		smPolicyData.Ipv4Address = ueIP.String()
+	}
 	smPolicyData.SubsSessAmbr = smContext.DnnConfiguration.SessionAmbr
 	smPolicyData.SubsDefQos = smContext.DnnConfiguration.Var5gQosProfile
+	smPolicyData.Var3gppPsDataOffStatus = smContext.PsDataOff
 	smPolicyData.SliceInfo = smContext.Snssai
 	smPolicyData.ServingNetwork = &models.NetworkId{
 		Mcc: smContext.ServingNetwork.Mcc,
@@ -50,17 +52,28 @@ func SendSMPolicyAssociationCreate(smContext *smf_context.SMContext) (*models.Sm
 	smPolicyData.SuppFeat = "F"
 
 	var smPolicyDecision *models.SmPolicyDecision
-	if smPolicyDecisionFromPCF, httpRsp, err := smContext.SMPolicyClient.
-		DefaultApi.SmPoliciesPost(context.Background(), smPolicyData); err != nil {
+	for {
+		smPolicyDecisionFromPCF, httpRsp, err := smContext.SMPolicyClient.
+			DefaultApi.SmPoliciesPost(context.Background(), smPolicyData)
+		if err == nil {
+			httpRspStatusCode = http.StatusCreated
+			smPolicyDecision = &smPolicyDecisionFromPCF
+			break
+		}
+		// PCF not reachable, the next target of its SRV record is tried
+		if httpRsp == nil && smContext.PCFSelectionFallback() {
+			continue
+		}
 		if httpRsp != nil {
 			httpRspStatusCode = httpRsp.StatusCode
 		}
 		return nil, httpRspStatusCode, fmt.Errorf("setup sm policy association failed: %s", err.Error())
-	} else {
-		httpRspStatusCode = http.StatusCreated
-		smPolicyDecision = &smPolicyDecisionFromPCF
 	}
 
+	// session AMBR not authorised by the PCF, subscribed or DNN default one applied
+	if err := smContext.ApplySessionAmbrFallback(smPolicyDecision); err != nil {
+		return nil, httpRspStatusCode, fmt.Errorf("setup sm policy association failed: %s", err.Error())
+	}
 	if err := validateSmPolicyDecision(smPolicyDecision); err != nil {
 		return nil, httpRspStatusCode, fmt.Errorf("setup sm policy association failed: %s", err.Error())
 	}
@@ -72,7 +85,33 @@ func SendSMPolicyAssociationModify(smContext *smf_context.SMContext) {
 	// TODO
 }
 
+// SendSMPolicyAssociationUpdate reports the policy control request triggers met by the session to the PCF
+// and returns the updated policy decision
+var SendSMPolicyAssociationUpdate = func(smContext *smf_context.SMContext,
+	updateData *models.SmPolicyUpdateContextData,
+) (*models.SmPolicyDecision, error) {
+	if smContext.SMPolicyClient == nil {
+		return nil, errors.Errorf("smContext not selected PCF")
+	}
+
+	// Policy Id (supi-pduSessId)
+	smPolicyID := fmt.Sprintf("%s-%d", smContext.Supi, smContext.PDUSessionID)
+
+	smPolicyDecision, _, err := smContext.SMPolicyClient.
+		DefaultApi.SmPoliciesSmPolicyIdUpdatePost(context.Background(), smPolicyID, *updateData)
+	if err != nil {
+		logger.ConsumerLog.Warnf("smf policy update failed, [%v] ", err.Error())
+		return nil, err
+	}
+	return &smPolicyDecision, nil
+}
+
 func SendSMPolicyAssociationDelete(smContext *smf_context.SMContext, smDelReq *models.ReleaseSmContextRequest) (int, error) {
+	// no association with PCF, e.g. session set up with the DNN default QoS
+	if smContext.SMPolicyClient == nil {
+		return 0, errors.Errorf("smContext not selected PCF")
+	}
+
 	smPolicyDelData := models.SmPolicyDeleteData{}
 
 	// Populate Policy delete data
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newSRVDNSServer answers the SRV queries with the targets, in priority order
+func newSRVDNSServer(t *testing.T, targets []string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+			builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true})
+			_ = builder.StartQuestions()
+			_ = builder.Question(question)
+			_ = builder.StartAnswers()
+			for i, target := range targets {
+				host, port, _ := net.SplitHostPort(target)
+				portNum, _ := strconv.Atoi(port)
+				_ = builder.SRVResource(dnsmessage.ResourceHeader{
+					Name:  question.Name,
+					Class: dnsmessage.ClassINET,
+					TTL:   60,
+				}, dnsmessage.SRVResource{
+					Priority: uint16(i),
+					Port:     uint16(portNum),
+					Target:   dnsmessage.MustNewName(host + "."),
+				})
+			}
+			if rsp, err := builder.Finish(); err == nil {
+				_, _ = conn.WriteTo(rsp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestSendSMPolicyAssociationCreateSRVFallback(t *testing.T) {
+	calls := 0
+	pcf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/npcf-smpolicycontrol/v1/sm-policies" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(models.SmPolicyDecision{SuppFeat: "F"})
+	}), &http2.Server{}))
+	t.Cleanup(pcf.Close)
+	pcfURL, err := url.Parse(pcf.URL)
+	require.NoError(t, err)
+
+	// the PCF of highest priority refuses the connections
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachableAddr := unreachable.Addr().String()
+	require.NoError(t, unreachable.Close())
+
+	discovery, err := smf_context.NewSRVDNSDiscovery(&factory.SrvDiscoveryConfig{
+		Name:   "_npcf-smpolicycontrol._tcp.pcf.example.org",
+		Server: newSRVDNSServer(t, []string{unreachableAddr, pcfURL.Host}),
+	})
+	require.NoError(t, err)
+	smf_context.SMF_Self().PcfSrvDiscovery = discovery
+	t.Cleanup(func() { smf_context.SMF_Self().PcfSrvDiscovery = nil })
+
+	smContext := smf_context.NewSMContext("imsi-208930000000003", 5)
+	smContext.PDUAddress = &smf_context.UeIpAddr{Ip: net.IPv4(10, 60, 0, 1)}
+	smContext.ServingNetwork = &models.PlmnId{Mcc: "208", Mnc: "93"}
+
+	require.NoError(t, smContext.PCFSelection())
+	decision, httpStatus, err := SendSMPolicyAssociationCreate(smContext)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, httpStatus)
+	require.Equal(t, "F", decision.SuppFeat)
+	require.Equal(t, 1, calls)
+
+	// no target left once the PCF fails too
+	pcf.Close()
+	_, _, err = SendSMPolicyAssociationCreate(smContext)
+	require.Error(t, err)
+}
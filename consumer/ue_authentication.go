@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package consumer
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/eap"
+	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+)
+
+// SendGenerateAuthData asks the UDM an EAP-AKA' authentication vector of the UE of the session,
+// CK' and IK' being derived for the serving network name. TS 29.503 5.4.2.2
+func SendGenerateAuthData(smContext *smf_context.SMContext, servingNetworkName string) (*eap.Vector, error) {
+	client := smf_context.SMF_Self().UEAuthenticationClient
+	if client == nil {
+		return nil, fmt.Errorf("UDM without Nudm_UEAuthentication service")
+	}
+	smfID := smf_context.SMF_Self().NfInstanceID
+	metrics.IncrementSvcUdmMsgStats(smfID, string(svcmsgtypes.GenerateAuthData), "Out", "", "")
+
+	result, rsp, err := client.GenerateAuthDataApi.GenerateAuthData(context.Background(), smContext.Supi,
+		models.AuthenticationInfoRequest{
+			ServingNetworkName: servingNetworkName,
+			AusfInstanceId:     smfID,
+		})
+	if rsp != nil {
+		defer func() {
+			if closeErr := rsp.Body.Close(); closeErr != nil {
+				smContext.SubConsumerLog.Errorf("GenerateAuthData response body cannot close: %v", closeErr)
+			}
+		}()
+	}
+	if err != nil {
+		status := "Failure"
+		if rsp != nil {
+			status = http.StatusText(rsp.StatusCode)
+		}
+		metrics.IncrementSvcUdmMsgStats(smfID, string(svcmsgtypes.GenerateAuthData), "In", status, err.Error())
+		return nil, fmt.Errorf("generate authentication data failed: %w", err)
+	}
+	metrics.IncrementSvcUdmMsgStats(smfID, string(svcmsgtypes.GenerateAuthData), "In", http.StatusText(rsp.StatusCode), "")
+
+	av := result.AuthenticationVector
+	if result.AuthType != models.AuthType_EAP_AKA_PRIME || av == nil || av.AvType != models.AvType_EAP_AKA_PRIME {
+		return nil, fmt.Errorf("UDM answered authentication type [%s] without EAP-AKA' vector", result.AuthType)
+	}
+	vector := &eap.Vector{}
+	for _, field := range []struct {
+		name  string
+		value string
+		bytes *[]byte
+	}{
+		{"rand", av.Rand, &vector.Rand},
+		{"autn", av.Autn, &vector.Autn},
+		{"xres", av.Xres, &vector.Xres},
+		{"ckPrime", av.CkPrime, &vector.CkPrime},
+		{"ikPrime", av.IkPrime, &vector.IkPrime},
+	} {
+		if *field.bytes, err = hex.DecodeString(field.value); err != nil || len(*field.bytes) == 0 {
+			return nil, fmt.Errorf("EAP-AKA' vector with invalid %s [%s]", field.name, field.value)
+		}
+	}
+	return vector, nil
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/smferrors"
+)
+
+// ErrGnbInUse is returned on the removal of a gNB still serving active sessions
+var ErrGnbInUse = smferrors.New(smferrors.ErrCodeGnbInUse, "gNB in use by active sessions")
+
+// AddGnbToAccessNetwork adds the gNB to the access network and links it to the UPFs terminating
+// N3, without a full update of the user plane configuration. A gNB named after an IP address
+// gets it as N3 address. The topology is updated under the config lock, once the gNB is fully
+// linked, and the default paths are computed again.
+func (upi *UserPlaneInformation) AddGnbToAccessNetwork(gnbName string) error {
+	factory.SmfConfigSyncLock.Lock()
+	defer factory.SmfConfigSyncLock.Unlock()
+
+	if _, ok := upi.UPNodes[gnbName]; ok {
+		return fmt.Errorf("UP node [%s] already exists", gnbName)
+	}
+
+	gnbNode := &UPNode{
+		Type: UPNODE_AN,
+		ANIP: net.ParseIP(gnbName),
+	}
+	for _, name := range slices.Sorted(maps.Keys(upi.UPFs)) {
+		if upfNode := upi.UPFs[name]; len(upfNode.UPF.N3Interfaces) > 0 {
+			gnbNode.Links = append(gnbNode.Links, upfNode)
+		}
+	}
+	if len(gnbNode.Links) == 0 {
+		return fmt.Errorf("no UPF with N3 interface to link gNB [%s] to", gnbName)
+	}
+
+	for _, upfNode := range gnbNode.Links {
+		upfNode.Links = append(upfNode.Links, gnbNode)
+	}
+	upi.UPNodes[gnbName] = gnbNode
+	upi.AccessNetwork[gnbName] = gnbNode
+	upi.ResetDefaultUserPlanePath()
+	logger.UPNodeLog.Infof("gNB [%s] added to the access network, linked to %d UPFs", gnbName, len(gnbNode.Links))
+	return nil
+}
+
+// RemoveGnbFromAccessNetwork removes the gNB and its links from the access network. It fails
+// with ErrGnbInUse if sessions have their N3 tunnel on the gNB, unless forced: the sessions are
+// then released by the network, the UE establishing them again through another gNB. Returns the
+// released sessions.
+func (upi *UserPlaneInformation) RemoveGnbFromAccessNetwork(gnbName string, force bool) ([]*SMContext, error) {
+	factory.SmfConfigSyncLock.Lock()
+	defer factory.SmfConfigSyncLock.Unlock()
+
+	gnbNode, ok := upi.AccessNetwork[gnbName]
+	if !ok {
+		return nil, fmt.Errorf("gNB [%s] not in the access network", gnbName)
+	}
+
+	smContexts, attributed := upi.getSMContextsOnGnb(gnbNode)
+	if len(smContexts) > 0 && !force {
+		return nil, fmt.Errorf("gNB [%s]: %w (%d)", gnbName, ErrGnbInUse, len(smContexts))
+	}
+	if !attributed {
+		// the sessions may be on another gNB without known N3 address, they are left
+		logger.UPNodeLog.Warnf("gNB [%s] without known N3 address, its %d possible sessions not released",
+			gnbName, len(smContexts))
+		smContexts = nil
+	}
+	ReleaseSessions(smContexts, nasMessage.Cause5GSMReactivationRequested,
+		fmt.Sprintf("gNB [%s] removed from the access network", gnbName))
+
+	for _, upNode := range gnbNode.Links {
+		upNode.Links = slices.DeleteFunc(upNode.Links, func(link *UPNode) bool { return link == gnbNode })
+	}
+	delete(upi.AccessNetwork, gnbName)
+	delete(upi.UPNodes, gnbName)
+	upi.ResetDefaultUserPlanePath()
+	logger.UPNodeLog.Infof("gNB [%s] removed from the access network, %d sessions released", gnbName, len(smContexts))
+	return smContexts, nil
+}
+
+// getSMContextsOnGnb returns the sessions with their N3 tunnel on the gNB of the access network.
+// For a gNB without known N3 address, those whose N3 tunnel is on none of the gNBs of known
+// address, only attributed to the gNB if it is the only one without known address.
+func (upi *UserPlaneInformation) getSMContextsOnGnb(gnbNode *UPNode) ([]*SMContext, bool) {
+	if gnbNode.ANIP != nil {
+		return GetSMContextsByGnb(gnbNode.ANIP), true
+	}
+	knownIPs := make([]net.IP, 0, len(upi.AccessNetwork))
+	attributed := true
+	for _, node := range upi.AccessNetwork {
+		switch {
+		case node.ANIP != nil:
+			knownIPs = append(knownIPs, node.ANIP)
+		case node != gnbNode:
+			attributed = false
+		}
+	}
+	smContexts := make([]*SMContext, 0)
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		if smContext.Tunnel != nil && smContext.Tunnel.ANInformation.IPAddress != nil &&
+			!slices.ContainsFunc(knownIPs, smContext.Tunnel.ANInformation.IPAddress.Equal) {
+			smContexts = append(smContexts, smContext)
+		}
+		return true
+	})
+	return smContexts, attributed
+}
+
+// GetSMContextsByGnb returns the sessions with their N3 tunnel on the gNB. None for a gNB
+// without known N3 address.
+func GetSMContextsByGnb(anIP net.IP) []*SMContext {
+	smContexts := make([]*SMContext, 0)
+	if anIP == nil {
+		return smContexts
+	}
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		if smContext.Tunnel != nil && smContext.Tunnel.ANInformation.IPAddress.Equal(anIP) {
+			smContexts = append(smContexts, smContext)
+		}
+		return true
+	})
+	return smContexts
+}
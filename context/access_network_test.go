@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func newAccessNetworkUserPlane() *context.UserPlaneInformation {
+	n3 := []factory.InterfaceUpfInfoItem{
+		{InterfaceType: models.UpInterfaceType_N3, NetworkInstance: "internet", Endpoints: []string{"10.0.6.1"}},
+	}
+	return context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"gnb1":     {Type: "AN", ANIP: "10.1.0.1"},
+			"n3-upf":   {Type: "UPF", NodeID: "10.0.6.1", InterfaceUpfInfoList: n3},
+			"n9-upf":   {Type: "UPF", NodeID: "10.0.6.2"},
+			"n3-upf-2": {Type: "UPF", NodeID: "10.0.6.3", InterfaceUpfInfoList: n3},
+		},
+		Links: []factory.UPLink{
+			{A: "gnb1", B: "n3-upf"},
+			{A: "n3-upf", B: "n9-upf"},
+		},
+	})
+}
+
+func newGnbSMContext(t *testing.T, supi string, anIP net.IP) *context.SMContext {
+	t.Helper()
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+
+	smContext := context.NewSMContext(supi, 1)
+	smContext.Supi = supi
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.ParseIP("10.60.0.1"), UpfProvided: true}
+	smContext.Tunnel = context.NewUPTunnel()
+	smContext.Tunnel.ANInformation.IPAddress = anIP
+	smContext.Tunnel.ANInformation.TEID = 1
+	return smContext
+}
+
+func TestAddGnbToAccessNetwork(t *testing.T) {
+	upi := newAccessNetworkUserPlane()
+	upi.DefaultUserPlanePath["internet"] = []*context.UPNode{upi.UPFs["n3-upf"]}
+
+	require.NoError(t, upi.AddGnbToAccessNetwork("10.1.0.2"))
+	gnb := upi.AccessNetwork["10.1.0.2"]
+	require.NotNil(t, gnb)
+	require.Same(t, gnb, upi.UPNodes["10.1.0.2"])
+	require.Equal(t, context.UPNODE_AN, gnb.Type)
+	require.True(t, gnb.ANIP.Equal(net.ParseIP("10.1.0.2")))
+
+	// linked to the UPFs terminating N3 only
+	require.ElementsMatch(t, []*context.UPNode{upi.UPFs["n3-upf"], upi.UPFs["n3-upf-2"]}, gnb.Links)
+	require.Contains(t, upi.UPFs["n3-upf"].Links, gnb)
+	require.Contains(t, upi.UPFs["n3-upf-2"].Links, gnb)
+	require.NotContains(t, upi.UPFs["n9-upf"].Links, gnb)
+	require.Empty(t, upi.DefaultUserPlanePath)
+
+	// the gNB or another node of the same name exists
+	require.Error(t, upi.AddGnbToAccessNetwork("10.1.0.2"))
+	require.Error(t, upi.AddGnbToAccessNetwork("n9-upf"))
+	require.Len(t, upi.UPFs["n3-upf"].Links, 3)
+}
+
+func TestAddGnbToAccessNetworkNoN3Upf(t *testing.T) {
+	upi := context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"n9-upf": {Type: "UPF", NodeID: "10.0.6.2"},
+		},
+	})
+
+	require.Error(t, upi.AddGnbToAccessNetwork("gnb2"))
+	require.NotContains(t, upi.UPNodes, "gnb2")
+	require.NotContains(t, upi.AccessNetwork, "gnb2")
+}
+
+func TestRemoveGnbFromAccessNetwork(t *testing.T) {
+	upi := newAccessNetworkUserPlane()
+	gnb := upi.AccessNetwork["gnb1"]
+	smContext := newGnbSMContext(t, "imsi-208930000000501", net.ParseIP("10.1.0.1"))
+	t.Cleanup(func() {
+		if context.GetSMContext(smContext.Ref) != nil {
+			context.RemoveSMContext(smContext.Ref)
+		}
+	})
+
+	// a session has its N3 tunnel on the gNB
+	purged, err := upi.RemoveGnbFromAccessNetwork("gnb1", false)
+	require.ErrorIs(t, err, context.ErrGnbInUse)
+	require.Nil(t, purged)
+	require.Same(t, gnb, upi.AccessNetwork["gnb1"])
+	require.Contains(t, upi.UPFs["n3-upf"].Links, gnb)
+	require.NotNil(t, context.GetSMContext(smContext.Ref))
+
+	_, err = upi.RemoveGnbFromAccessNetwork("gnb2", false)
+	require.Error(t, err, "unknown gNB")
+}
+
+// stubSessionRelease records the sessions released by the network, with their cause
+func stubSessionRelease(t *testing.T) <-chan uint8 {
+	t.Helper()
+	released := make(chan uint8, 4)
+	origRelease := context.ReleaseSessionByNetwork
+	t.Cleanup(func() { context.ReleaseSessionByNetwork = origRelease })
+	context.ReleaseSessionByNetwork = func(smContext *context.SMContext, cause uint8, procedure string) {
+		context.RemoveSMContext(smContext.Ref)
+		released <- cause
+	}
+	return released
+}
+
+func TestRemoveGnbFromAccessNetworkForced(t *testing.T) {
+	upi := newAccessNetworkUserPlane()
+	gnb := upi.AccessNetwork["gnb1"]
+	onGnb := newGnbSMContext(t, "imsi-208930000000502", net.ParseIP("10.1.0.1"))
+	elsewhere := newGnbSMContext(t, "imsi-208930000000503", net.ParseIP("10.1.0.9"))
+	t.Cleanup(func() { context.RemoveSMContext(elsewhere.Ref) })
+	released := stubSessionRelease(t)
+
+	// the sessions on the gNB are released by the network for the UE to establish them again
+	purged, err := upi.RemoveGnbFromAccessNetwork("gnb1", true)
+	require.NoError(t, err)
+	require.Equal(t, []*context.SMContext{onGnb}, purged)
+	require.Equal(t, nasMessage.Cause5GSMReactivationRequested, <-released)
+	require.False(t, onGnb.LocalPurged)
+	require.Nil(t, context.GetSMContext(onGnb.Ref))
+	require.NotNil(t, context.GetSMContext(elsewhere.Ref))
+
+	require.NotContains(t, upi.AccessNetwork, "gnb1")
+	require.NotContains(t, upi.UPNodes, "gnb1")
+	require.NotContains(t, upi.UPFs["n3-upf"].Links, gnb)
+	require.Contains(t, upi.UPFs["n3-upf"].Links, upi.UPFs["n9-upf"])
+}
+
+func TestRemoveGnbWithoutAddress(t *testing.T) {
+	upi := newAccessNetworkUserPlane()
+	require.NoError(t, upi.AddGnbToAccessNetwork("gnb-north"))
+	onGnb1 := newGnbSMContext(t, "imsi-208930000000504", net.ParseIP("10.1.0.1"))
+	unattributed := newGnbSMContext(t, "imsi-208930000000505", net.ParseIP("10.1.0.5"))
+	t.Cleanup(func() {
+		for _, smContext := range []*context.SMContext{onGnb1, unattributed} {
+			if context.GetSMContext(smContext.Ref) != nil {
+				context.RemoveSMContext(smContext.Ref)
+			}
+		}
+	})
+	released := stubSessionRelease(t)
+
+	// the session on none of the gNBs of known address may be on the gNB
+	_, err := upi.RemoveGnbFromAccessNetwork("gnb-north", false)
+	require.ErrorIs(t, err, context.ErrGnbInUse)
+
+	// with another gNB without known address, the session is not released
+	require.NoError(t, upi.AddGnbToAccessNetwork("gnb-south"))
+	purged, err := upi.RemoveGnbFromAccessNetwork("gnb-north", true)
+	require.NoError(t, err)
+	require.Empty(t, purged)
+	require.Empty(t, released)
+	require.NotNil(t, context.GetSMContext(unattributed.Ref))
+
+	// the last gNB without known address serves it
+	purged, err = upi.RemoveGnbFromAccessNetwork("gnb-south", true)
+	require.NoError(t, err)
+	require.Equal(t, []*context.SMContext{unattributed}, purged)
+	require.Equal(t, nasMessage.Cause5GSMReactivationRequested, <-released)
+	require.NotNil(t, context.GetSMContext(onGnb1.Ref))
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/metrics"
+)
+
+// MaxAMFOverloadBackoff bounds the back-off requested by an overloaded AMF, the delayed
+// establishments being answered before the AMF gives up on them
+var MaxAMFOverloadBackoff = 30 * time.Second
+
+// amfBackoff is the back-off of an overloaded AMF
+type amfBackoff struct {
+	until  time.Time
+	queued int
+}
+
+// OverloadController delays the session establishments of the UEs served by an AMF which signalled
+// its overload. An AMF answering with 503 Service Unavailable and a Retry-After header is backed
+// off for that time, the new establishments of its UEs waiting until it expires.
+type OverloadController struct {
+	lock sync.Mutex
+	amfs map[string]*amfBackoff
+}
+
+var overloadController = NewOverloadController()
+
+func NewOverloadController() *OverloadController {
+	return &OverloadController{amfs: make(map[string]*amfBackoff)}
+}
+
+// GetOverloadController returns the overload controller of the AMFs
+func GetOverloadController() *OverloadController {
+	return overloadController
+}
+
+// Observe records the answer of the AMF received at the time, an overload answer backing it off.
+// A longer back-off already running is kept.
+func (c *OverloadController) Observe(amfID string, rsp *http.Response, at time.Time) {
+	if amfID == "" || rsp == nil || rsp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	backoff, ok := parseRetryAfter(rsp.Header.Get("Retry-After"), at)
+	if !ok {
+		return
+	}
+	backoff = min(backoff, MaxAMFOverloadBackoff)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	amf, ok := c.amfs[amfID]
+	if !ok {
+		amf = &amfBackoff{}
+		c.amfs[amfID] = amf
+	}
+	if until := at.Add(backoff); until.After(amf.until) {
+		amf.until = until
+		logger.CtxLog.Warnf("AMF[%s] overloaded, new sessions delayed for %v", amfID, backoff)
+	}
+}
+
+// Backoff returns the time left at the time before the AMF takes new sessions, zero if it is not
+// overloaded
+func (c *OverloadController) Backoff(amfID string, at time.Time) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.backoff(amfID, at)
+}
+
+func (c *OverloadController) backoff(amfID string, at time.Time) time.Duration {
+	amf, ok := c.amfs[amfID]
+	if !ok {
+		return 0
+	}
+	if left := amf.until.Sub(at); left > 0 {
+		return left
+	}
+	if amf.queued == 0 {
+		delete(c.amfs, amfID)
+	}
+	return 0
+}
+
+// Wait blocks the establishment of a session of a UE served by the AMF until the back-off of the
+// AMF expires, extended meanwhile or not. Returns the time waited.
+func (c *OverloadController) Wait(amfID string) time.Duration {
+	start := time.Now()
+	c.lock.Lock()
+	left := c.backoff(amfID, start)
+	if left == 0 {
+		c.lock.Unlock()
+		return 0
+	}
+	amf := c.amfs[amfID]
+	amf.queued++
+	metrics.SetAMFOverloadQueuedSessions(amfID, amf.queued)
+	c.lock.Unlock()
+
+	for left > 0 {
+		time.Sleep(left)
+		c.lock.Lock()
+		left = amf.until.Sub(time.Now())
+		c.lock.Unlock()
+	}
+
+	c.lock.Lock()
+	amf.queued--
+	metrics.SetAMFOverloadQueuedSessions(amfID, amf.queued)
+	c.lock.Unlock()
+	return time.Since(start)
+}
+
+// parseRetryAfter returns the delay of the Retry-After header, in seconds or as an HTTP date
+func parseRetryAfter(value string, at time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0, false
+		}
+		if seconds > int64(MaxAMFOverloadBackoff/time.Second) {
+			return MaxAMFOverloadBackoff, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(at) {
+		return 0, false
+	}
+	return date.Sub(at), true
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/Namf_Communication"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func overloadResponse(retryAfter string) *http.Response {
+	rsp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	rsp.Header.Set("Retry-After", retryAfter)
+	return rsp
+}
+
+func TestOverloadControllerBacksOffOverloadedAMF(t *testing.T) {
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+
+	communicationConf := Namf_Communication.NewConfiguration()
+	communicationConf.SetBasePath(server.URL)
+	smContext := &context.SMContext{
+		Supi:                "imsi-208930000000001",
+		ServingNfId:         "amf-overloaded",
+		CommunicationClient: Namf_Communication.NewAPIClient(communicationConf),
+	}
+	start := time.Now()
+	_, err := smContext.N1N2MessageTransfer(t.Context(), models.N1N2MessageTransferRequest{
+		JsonData: &models.N1N2MessageTransferReqData{PduSessionId: 1},
+	})
+	require.Error(t, err)
+
+	// new sessions of the AMF are delayed for 5 seconds, the ones of the other AMFs are not
+	controller := context.GetOverloadController()
+	require.InDelta(t, 5*time.Second, controller.Backoff("amf-overloaded", start), float64(time.Second))
+	require.Positive(t, controller.Backoff("amf-overloaded", start.Add(4*time.Second)))
+	require.Zero(t, controller.Backoff("amf-overloaded", time.Now().Add(5*time.Second)))
+	require.Zero(t, controller.Backoff("amf-other", start))
+	require.Zero(t, controller.Wait("amf-other"))
+}
+
+func TestOverloadControllerQueuesSessions(t *testing.T) {
+	controller := context.NewOverloadController()
+	// back-off of 5 seconds, received 4.8 seconds ago
+	controller.Observe("amf-1", overloadResponse("5"), time.Now().Add(-4800*time.Millisecond))
+
+	waited := make(chan time.Duration)
+	for range 2 {
+		go func() { waited <- controller.Wait("amf-1") }()
+	}
+	require.Eventually(t, func() bool {
+		return gatheredMetric(t, "smf_amf_overload_queued_sessions", "amf-1") == 2
+	}, time.Second, 10*time.Millisecond)
+
+	for range 2 {
+		require.GreaterOrEqual(t, <-waited, 100*time.Millisecond)
+	}
+	require.Zero(t, gatheredMetric(t, "smf_amf_overload_queued_sessions", "amf-1"))
+	require.Zero(t, controller.Wait("amf-1"))
+}
+
+func TestOverloadControllerIgnoresOtherAnswers(t *testing.T) {
+	controller := context.NewOverloadController()
+	now := time.Now()
+
+	controller.Observe("amf-1", &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, now)
+	controller.Observe("amf-1", &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}, now)
+	controller.Observe("amf-1", overloadResponse("soon"), now)
+	controller.Observe("amf-1", nil, now)
+	require.Zero(t, controller.Backoff("amf-1", now))
+
+	// a shorter back-off does not cut the running one, a large one is bounded
+	controller.Observe("amf-1", overloadResponse("10"), now)
+	controller.Observe("amf-1", overloadResponse("2"), now)
+	require.Equal(t, 10*time.Second, controller.Backoff("amf-1", now))
+	controller.Observe("amf-1", overloadResponse("86400"), now)
+	require.Equal(t, context.MaxAMFOverloadBackoff, controller.Backoff("amf-1", now))
+
+	// HTTP date
+	controller.Observe("amf-2", overloadResponse(now.Add(3*time.Second).UTC().Format(http.TimeFormat)), now)
+	require.InDelta(t, 3*time.Second, controller.Backoff("amf-2", now), float64(time.Second))
+}
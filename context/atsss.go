@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+)
+
+// ATSSS steering functionality, TS 29.244 8.2.172
+type SteeringFunctionality uint8
+
+const (
+	SteeringFunctionalityATSSSLL SteeringFunctionality = 0
+	SteeringFunctionalityMPTCP   SteeringFunctionality = 1
+)
+
+// ATSSS steering mode, TS 29.244 8.2.173
+type ATSSSMode uint8
+
+const (
+	ATSSSModeActiveStandby ATSSSMode = 0
+	ATSSSModeSmallestDelay ATSSSMode = 1
+	ATSSSModeLoadBalancing ATSSSMode = 2
+	ATSSSModePriorityBased ATSSSMode = 3
+)
+
+func (m ATSSSMode) String() string {
+	switch m {
+	case ATSSSModeActiveStandby:
+		return "ActiveStandby"
+	case ATSSSModeSmallestDelay:
+		return "SmallestDelay"
+	case ATSSSModeLoadBalancing:
+		return "LoadBalancing"
+	case ATSSSModePriorityBased:
+		return "PriorityBased"
+	default:
+		return "Unknown"
+	}
+}
+
+// ATSSSConfig is the steering of a Multi-Access PDU session over the 3GPP and non-3GPP accesses
+type ATSSSConfig struct {
+	// access carrying the traffic in ActiveStandby and PriorityBased modes
+	ActiveAccess          models.AccessType
+	SteeringFunctionality SteeringFunctionality
+	Mode                  ATSSSMode
+	// percentage of the traffic sent over 3GPP access in LoadBalancing mode
+	TGPPAccessWeight uint8
+}
+
+// ParseATSSSConfig validates the steering of the MA PDU session traffic of a DNN
+func ParseATSSSConfig(config *factory.ATSSSConfig) (*ATSSSConfig, error) {
+	atsss := &ATSSSConfig{ActiveAccess: models.AccessType__3_GPP_ACCESS}
+	switch config.SteeringFunctionality {
+	case "", factory.ATSSSSteeringFunctionalityATSSSLL:
+		atsss.SteeringFunctionality = SteeringFunctionalityATSSSLL
+	case factory.ATSSSSteeringFunctionalityMPTCP:
+		atsss.SteeringFunctionality = SteeringFunctionalityMPTCP
+	default:
+		return nil, fmt.Errorf("unknown steering functionality %q", config.SteeringFunctionality)
+	}
+	switch config.SteeringMode {
+	case factory.ATSSSModeActiveStandby:
+		atsss.Mode = ATSSSModeActiveStandby
+	case factory.ATSSSModeSmallestDelay:
+		atsss.Mode = ATSSSModeSmallestDelay
+	case factory.ATSSSModeLoadBalancing:
+		atsss.Mode = ATSSSModeLoadBalancing
+	case factory.ATSSSModePriorityBased:
+		atsss.Mode = ATSSSModePriorityBased
+	default:
+		return nil, fmt.Errorf("unknown steering mode %q", config.SteeringMode)
+	}
+	// the SMF sets up no user plane over the non-3GPP access, its traffic can only be steered to
+	// the 3GPP access
+	switch config.ActiveAccess {
+	case "", factory.ATSSSAccess3GPP:
+	case factory.ATSSSAccessNon3GPP:
+		return nil, fmt.Errorf("active access %q not supported, no non-3GPP user plane", config.ActiveAccess)
+	default:
+		return nil, fmt.Errorf("unknown active access %q", config.ActiveAccess)
+	}
+	if config.TGPPAccessWeight > 100 {
+		return nil, fmt.Errorf("3GPP access weight %d over 100", config.TGPPAccessWeight)
+	}
+	atsss.TGPPAccessWeight = config.TGPPAccessWeight
+	return atsss, nil
+}
+
+// SelectATSSSMode returns the steering mode applied to the traffic of the PCC rule.
+// Load balancing of a GBR flow over two accesses cannot guarantee its bit rate,
+// such flows are kept on the active access instead.
+func SelectATSSSMode(smContext *SMContext, policy *models.PccRule) ATSSSMode {
+	if smContext.ATSSSConfig == nil {
+		return ATSSSModeActiveStandby
+	}
+
+	mode := smContext.ATSSSConfig.Mode
+	if mode == ATSSSModeLoadBalancing && policy != nil && smContext.isGbrPccRule(policy) {
+		smContext.SubCtxLog.Infof("PCC rule[%s] is GBR, steering mode %s instead of %s",
+			policy.PccRuleId, ATSSSModeActiveStandby, mode)
+		return ATSSSModeActiveStandby
+	}
+	return mode
+}
+
+func (smContext *SMContext) isGbrPccRule(policy *models.PccRule) bool {
+	if len(policy.RefQosData) == 0 {
+		return false
+	}
+
+	qosData := smContext.SmPolicyData.SmCtxtQosData.QosData[policy.RefQosData[0]]
+	// the rule may come with a policy decision not yet committed to the context
+	for i := len(smContext.SmPolicyUpdates) - 1; qosData == nil && i >= 0; i-- {
+		if decision := smContext.SmPolicyUpdates[i].SmPolicyDecision; decision != nil {
+			qosData = decision.QosDecs[policy.RefQosData[0]]
+		}
+	}
+	return qosData != nil && (qosData.GbrUl != "" || qosData.GbrDl != "")
+}
+
+// NewMAR returns the Multi-Access Rule steering the traffic of the PDR over the 3GPP and
+// non-3GPP accesses with the given mode. Its MAR ID is allocated by the SMF from the MAR IDs of
+// the UPF, freed with RemoveMAR.
+func (upf *UPF) NewMAR(config *ATSSSConfig, mode ATSSSMode, tgppFAR, nonTgppFAR *FAR) (*MAR, error) {
+	mar, err := upf.AddMAR()
+	if err != nil {
+		return nil, err
+	}
+	mar.SteeringFunctionality = config.SteeringFunctionality
+	mar.SteeringMode = mode
+	mar.TGPPAccessFAR = tgppFAR
+	mar.NonTGPPAccessFAR = nonTgppFAR
+	mar.TGPPAccessWeight = config.TGPPAccessWeight
+	mar.NonTGPPAccessActive = config.ActiveAccess == models.AccessType_NON_3_GPP_ACCESS
+	return mar, nil
+}
+
+// activateMAR steers the traffic of the downlink PDR of the anchor UPF over the accesses of the
+// MA PDU session, with the steering mode of its PCC rule. The 3GPP access forwards with the FAR of
+// the PDR, the non-3GPP access has no FAR until its user plane is set up. The traffic of an anchor
+// UPF without the steering functionality is not steered.
+func (dpNode *DataPathNode) activateMAR(smContext *SMContext, name string, pdr *PDR) {
+	if smContext.ATSSSConfig == nil || pdr.MAR != nil || !dpNode.IsAnchorUPF() {
+		return
+	}
+	if !dpNode.UPF.IsUpfSupportSteering(smContext.ATSSSConfig.SteeringFunctionality) {
+		logger.CtxLog.Warnf("UPF[%s] does not support the steering functionality, PDR[%v] not steered",
+			dpNode.UPF.NodeID.ResolveNodeIdToIp(), name)
+		return
+	}
+	mode := SelectATSSSMode(smContext, smContext.pccRule(name))
+	mar, err := dpNode.UPF.NewMAR(smContext.ATSSSConfig, mode, pdr.FAR, nil)
+	if err != nil {
+		logger.CtxLog.Errorf("allocate MAR of PDR[%v] error: %v", name, err)
+		return
+	}
+	pdr.MAR = mar
+}
+
+// pccRule returns the PCC rule of the PDR, committed or still in a policy decision, nil for
+// the default PDR without PCC rule
+func (smContext *SMContext) pccRule(name string) *models.PccRule {
+	if rule := smContext.SmPolicyData.SmCtxtPccRules.PccRules[name]; rule != nil {
+		return rule
+	}
+	for i := len(smContext.SmPolicyUpdates) - 1; i >= 0; i-- {
+		if update := smContext.SmPolicyUpdates[i].PccRuleUpdate; update != nil {
+			if rule := update.GetAddPccRuleUpdate()[name]; rule != nil {
+				return rule
+			}
+		}
+	}
+	return nil
+}
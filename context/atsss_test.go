@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectATSSSMode(t *testing.T) {
+	smContext := context.NewSMContext("imsi-208930000000002", 6)
+	smContext.SmPolicyData.SmCtxtQosData.QosData = map[string]*models.QosData{
+		"qos-gbr":     {QosId: "qos-gbr", Var5qi: 1, GbrUl: "1 Mbps", GbrDl: "1 Mbps"},
+		"qos-non-gbr": {QosId: "qos-non-gbr", Var5qi: 9},
+	}
+	gbrRule := &models.PccRule{PccRuleId: "1", RefQosData: []string{"qos-gbr"}}
+	nonGbrRule := &models.PccRule{PccRuleId: "2", RefQosData: []string{"qos-non-gbr"}}
+
+	require.Equal(t, context.ATSSSModeActiveStandby, context.SelectATSSSMode(smContext, nonGbrRule),
+		"single access session")
+
+	for _, mode := range []context.ATSSSMode{
+		context.ATSSSModeActiveStandby, context.ATSSSModeSmallestDelay, context.ATSSSModeLoadBalancing,
+	} {
+		smContext.ATSSSConfig = &context.ATSSSConfig{Mode: mode}
+		require.Equal(t, mode, context.SelectATSSSMode(smContext, nonGbrRule), mode.String())
+	}
+
+	smContext.ATSSSConfig = &context.ATSSSConfig{Mode: context.ATSSSModeLoadBalancing}
+	require.Equal(t, context.ATSSSModeActiveStandby, context.SelectATSSSMode(smContext, gbrRule))
+	smContext.ATSSSConfig = &context.ATSSSConfig{Mode: context.ATSSSModeSmallestDelay}
+	require.Equal(t, context.ATSSSModeSmallestDelay, context.SelectATSSSMode(smContext, gbrRule))
+}
+
+func TestParseATSSSConfig(t *testing.T) {
+	atsss, err := context.ParseATSSSConfig(&factory.ATSSSConfig{
+		SteeringMode:     factory.ATSSSModeLoadBalancing,
+		TGPPAccessWeight: 70,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &context.ATSSSConfig{
+		ActiveAccess:          models.AccessType__3_GPP_ACCESS,
+		SteeringFunctionality: context.SteeringFunctionalityATSSSLL,
+		Mode:                  context.ATSSSModeLoadBalancing,
+		TGPPAccessWeight:      70,
+	}, atsss)
+
+	atsss, err = context.ParseATSSSConfig(&factory.ATSSSConfig{
+		SteeringFunctionality: factory.ATSSSSteeringFunctionalityMPTCP,
+		SteeringMode:          factory.ATSSSModePriorityBased,
+		ActiveAccess:          factory.ATSSSAccess3GPP,
+	})
+	require.NoError(t, err)
+	require.Equal(t, context.SteeringFunctionalityMPTCP, atsss.SteeringFunctionality)
+	require.Equal(t, context.ATSSSModePriorityBased, atsss.Mode)
+	require.Equal(t, models.AccessType__3_GPP_ACCESS, atsss.ActiveAccess)
+
+	for _, config := range []factory.ATSSSConfig{
+		{},
+		{SteeringMode: "round-robin"},
+		{SteeringMode: factory.ATSSSModeActiveStandby, SteeringFunctionality: "quic"},
+		{SteeringMode: factory.ATSSSModeActiveStandby, ActiveAccess: "wlan"},
+		// no non-3GPP user plane to carry the traffic
+		{SteeringMode: factory.ATSSSModeActiveStandby, ActiveAccess: factory.ATSSSAccessNon3GPP},
+		{SteeringMode: factory.ATSSSModeLoadBalancing, TGPPAccessWeight: 101},
+	} {
+		_, err := context.ParseATSSSConfig(&config)
+		require.Error(t, err, "%+v", config)
+	}
+}
+
+func TestActivateDlLinkPdrATSSS(t *testing.T) {
+	smContext, dpNode := newDefaultPdrTestNode(t, &models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"video": {
+				PccRuleId:  "1",
+				Precedence: 10,
+				RefQosData: []string{"video-qos"},
+				RefTcData:  []string{"tc"},
+				FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from 10.1.1.0/24 to assigned", PackFiltId: "1"}},
+			},
+		},
+		QosDecs: map[string]*models.QosData{
+			"video-qos": {QosId: "2", Var5qi: 2, GbrUl: "1 Mbps", GbrDl: "1 Mbps"},
+		},
+		TraffContDecs: map[string]*models.TrafficControlData{"tc": {TcId: "tc", FlowStatus: models.FlowStatus_ENABLED}},
+	})
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.IP{192, 168, 1, 1}}
+	smContext.Tunnel = &context.UPTunnel{}
+	smContext.SubCtxLog = logger.CtxLog
+	smContext.ATSSSConfig = &context.ATSSSConfig{
+		SteeringFunctionality: context.SteeringFunctionalityATSSSLL,
+		Mode:                  context.ATSSSModeLoadBalancing,
+		TGPPAccessWeight:      70,
+	}
+	dpNode.UPF.UPFunctionFeatures = &context.UPFunctionFeatures{SupportedFeatures2: context.UpFunctionFeatures2AtsssLl}
+
+	require.NoError(t, dpNode.ActivateDownLinkTunnel(smContext))
+	require.NoError(t, dpNode.ActivateDlLinkPdr(smContext, &context.QER{}, 255, &context.DataPath{FirstDPNode: dpNode}))
+
+	// the anchor UPF steers the traffic of each PDR, the GBR flow staying on the active access
+	defaultPDR := dpNode.DownLinkTunnel.PDR["default"]
+	require.NotNil(t, defaultPDR.MAR)
+	require.Equal(t, context.ATSSSModeLoadBalancing, defaultPDR.MAR.SteeringMode)
+	require.Equal(t, uint8(70), defaultPDR.MAR.TGPPAccessWeight)
+	require.Same(t, defaultPDR.FAR, defaultPDR.MAR.TGPPAccessFAR)
+	require.Nil(t, defaultPDR.MAR.NonTGPPAccessFAR)
+	videoPDR := dpNode.DownLinkTunnel.PDR["video"]
+	require.NotNil(t, videoPDR.MAR)
+	require.Equal(t, context.ATSSSModeActiveStandby, videoPDR.MAR.SteeringMode)
+	require.NotEqual(t, defaultPDR.MAR.MARID, videoPDR.MAR.MARID)
+
+	// a session without steering has no MAR
+	smContext, dpNode = newDefaultPdrTestNode(t, &models.SmPolicyDecision{})
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.IP{192, 168, 1, 2}}
+	smContext.Tunnel = &context.UPTunnel{}
+	require.NoError(t, dpNode.ActivateDownLinkTunnel(smContext))
+	require.NoError(t, dpNode.ActivateDlLinkPdr(smContext, &context.QER{}, 255, &context.DataPath{FirstDPNode: dpNode}))
+	require.Nil(t, dpNode.DownLinkTunnel.PDR["default"].MAR)
+
+	// nor a session anchored on a UPF without the steering functionality
+	smContext, dpNode = newDefaultPdrTestNode(t, &models.SmPolicyDecision{})
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.IP{192, 168, 1, 3}}
+	smContext.Tunnel = &context.UPTunnel{}
+	smContext.ATSSSConfig = &context.ATSSSConfig{
+		SteeringFunctionality: context.SteeringFunctionalityMPTCP,
+		Mode:                  context.ATSSSModeActiveStandby,
+	}
+	dpNode.UPF.UPFunctionFeatures = &context.UPFunctionFeatures{SupportedFeatures2: context.UpFunctionFeatures2AtsssLl}
+	require.NoError(t, dpNode.ActivateDownLinkTunnel(smContext))
+	require.NoError(t, dpNode.ActivateDlLinkPdr(smContext, &context.QER{}, 255, &context.DataPath{FirstDPNode: dpNode}))
+	require.Nil(t, dpNode.DownLinkTunnel.PDR["default"].MAR)
+}
+
+func TestIsUpfSupportSteering(t *testing.T) {
+	upf := &context.UPF{}
+	require.False(t, upf.IsUpfSupportSteering(context.SteeringFunctionalityATSSSLL))
+
+	upf.UPFunctionFeatures = &context.UPFunctionFeatures{SupportedFeatures2: context.UpFunctionFeatures2AtsssLl}
+	require.True(t, upf.IsUpfSupportSteering(context.SteeringFunctionalityATSSSLL))
+	require.False(t, upf.IsUpfSupportSteering(context.SteeringFunctionalityMPTCP))
+
+	upf.UPFunctionFeatures = &context.UPFunctionFeatures{SupportedFeatures1: context.UpFunctionFeatures1Mptcp}
+	require.False(t, upf.IsUpfSupportSteering(context.SteeringFunctionalityATSSSLL))
+	require.True(t, upf.IsUpfSupportSteering(context.SteeringFunctionalityMPTCP))
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"time"
+
+	"github.com/omec-project/openapi/models"
+)
+
+// UsageReport is the usage measured by a UPF for a URR of the session
+type UsageReport struct {
+	UpfIP           string `json:"upfIp"`
+	URRID           uint32 `json:"urrId"`
+	TotalVolume     uint64 `json:"totalVolume"`
+	UplinkVolume    uint64 `json:"uplinkVolume"`
+	DownlinkVolume  uint64 `json:"downlinkVolume"`
+	TotalPackets    uint64 `json:"totalPackets"`
+	UplinkPackets   uint64 `json:"uplinkPackets"`
+	DownlinkPackets uint64 `json:"downlinkPackets"`
+	Duration        uint32 `json:"duration"` // sec
+}
+
+// AddFinalUsage records the usage reported by the UPF when deleting the PFCP session
+func (smContext *SMContext) AddFinalUsage(reports []UsageReport) {
+	smContext.finalUsageLock.Lock()
+	defer smContext.finalUsageLock.Unlock()
+	smContext.finalUsage = append(smContext.finalUsage, reports...)
+}
+
+// FinalUsage returns the usage reported by the UPFs when deleting the PFCP sessions
+func (smContext *SMContext) FinalUsage() []UsageReport {
+	smContext.finalUsageLock.Lock()
+	defer smContext.finalUsageLock.Unlock()
+	return append([]UsageReport(nil), smContext.finalUsage...)
+}
+
+// CloseCharging records that the charging of the session is closed, returns false if it already
+// was
+func (smContext *SMContext) CloseCharging() bool {
+	smContext.finalUsageLock.Lock()
+	defer smContext.finalUsageLock.Unlock()
+	if smContext.chargingClosed {
+		return false
+	}
+	smContext.chargingClosed = true
+	return true
+}
+
+// ChargingID returns the charging ID of the session, the lowest local SEID of its PFCP sessions
+func (smContext *SMContext) ChargingID() uint32 {
+	var chargingID uint32
+	for _, pfcpContext := range smContext.PFCPContext {
+		if id := uint32(pfcpContext.LocalSEID); chargingID == 0 || id < chargingID {
+			chargingID = id
+		}
+	}
+	return chargingID
+}
+
+// ChargingTrigger is a change of condition closing the usage of a session in a charging update,
+// TS 32.291 TriggerType
+type ChargingTrigger string
+
+const ChargingTriggerRATChange ChargingTrigger = "RAT_CHANGE"
+
+// ChargingUpdate is the usage of a session since the previous update, closed by a trigger
+type ChargingUpdate struct {
+	Trigger ChargingTrigger `json:"trigger"`
+	Time    time.Time       `json:"time"`
+	// RAT type of the session after the trigger, the usage being measured on the previous one
+	RATType         models.RatType `json:"ratType"`
+	PreviousRATType models.RatType `json:"previousRatType,omitempty"`
+	Usage           SessionStats   `json:"usage"`
+}
+
+// UpdateRATType sets the RAT type reported by the AMF. On a change of the RAT type it returns the
+// charging update closing the usage measured on the previous RAT, nil otherwise.
+func (smContext *SMContext) UpdateRATType(ratType models.RatType) *ChargingUpdate {
+	if ratType == "" || ratType == smContext.RatType {
+		return nil
+	}
+	update := &ChargingUpdate{
+		Trigger:         ChargingTriggerRATChange,
+		Time:            time.Now(),
+		RATType:         ratType,
+		PreviousRATType: smContext.RatType,
+		Usage:           smContext.usageSinceChargingUpdate(),
+	}
+	smContext.RatType = ratType
+	return update
+}
+
+// usageSinceChargingUpdate returns the usage reported for the session since the previous
+// charging update, and records it as sent
+func (smContext *SMContext) usageSinceChargingUpdate() SessionStats {
+	stats, err := GetPacketRateRecorder().GetSessionStats(smContext.Ref)
+	if err != nil {
+		// no usage reported yet
+		return SessionStats{}
+	}
+	charged := smContext.chargedUsage
+	smContext.chargedUsage = stats
+	return SessionStats{
+		LastReport:      stats.LastReport,
+		UplinkVolume:    stats.UplinkVolume - charged.UplinkVolume,
+		DownlinkVolume:  stats.DownlinkVolume - charged.DownlinkVolume,
+		TotalVolume:     stats.TotalVolume - charged.TotalVolume,
+		UplinkPackets:   stats.UplinkPackets - charged.UplinkPackets,
+		DownlinkPackets: stats.DownlinkPackets - charged.DownlinkPackets,
+		TotalPackets:    stats.TotalPackets - charged.TotalPackets,
+		Duration:        stats.Duration - charged.Duration,
+		Reports:         stats.Reports - charged.Reports,
+	}
+}
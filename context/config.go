@@ -7,8 +7,9 @@ package context
 
 import (
 	"fmt"
-	"net"
+	"slices"
 
+	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/factory"
 	"github.com/omec-project/smf/logger"
 )
@@ -20,6 +21,11 @@ func SetupSMFContext(config *factory.Config) error {
 func (c *SMFContext) insertSmfNssaiInfo(snssaiInfoConfig *factory.SnssaiInfoItem) error {
 	logger.InitLog.Infof("Network Slices to be inserted [%v] ", factory.PrettyPrintNetworkSlices([]factory.SnssaiInfoItem{*snssaiInfoConfig}))
 
+	if !c.servesSnssai(snssaiInfoConfig.SNssai) {
+		logger.InitLog.Infof("network slice [sst:%d, sd:%s] filtered out, not served", snssaiInfoConfig.SNssai.Sst, snssaiInfoConfig.SNssai.Sd)
+		return nil
+	}
+
 	if smfContext.SnssaiInfos == nil {
 		c.SnssaiInfos = make([]SnssaiSmfInfo, 0)
 	}
@@ -42,18 +48,41 @@ func (c *SMFContext) insertSmfNssaiInfo(snssaiInfoConfig *factory.SnssaiInfoItem
 	// PLMN ID
 	snssaiInfo.PlmnId = snssaiInfoConfig.PlmnId
 
+	// Selection priority among matching slices
+	snssaiInfo.Priority = snssaiInfoConfig.Priority
+
+	// DNS servers of the DNNs, validated before any DNN of the slice is set up
+	dnsServers := make(map[string]DNS, len(snssaiInfoConfig.DnnInfos))
+	for _, dnnInfoConfig := range snssaiInfoConfig.DnnInfos {
+		dns, err := ParseDNS(&dnnInfoConfig.DNS)
+		if err != nil {
+			return fmt.Errorf("invalid DNS for dnn [%s]: %v", dnnInfoConfig.Dnn, err)
+		}
+		dnsServers[dnnInfoConfig.Dnn] = dns
+	}
+
 	// DNN Info
 	snssaiInfo.DnnInfos = make(map[string]*SnssaiSmfDnnInfo)
 
 	for _, dnnInfoConfig := range snssaiInfoConfig.DnnInfos {
 		dnnInfo := SnssaiSmfDnnInfo{}
-		dnnInfo.DNS.IPv4Addr = net.ParseIP(dnnInfoConfig.DNS.IPv4Addr).To4()
-		dnnInfo.DNS.IPv6Addr = net.ParseIP(dnnInfoConfig.DNS.IPv6Addr).To4()
-		if allocator, err := NewIPAllocator(dnnInfoConfig.UESubnet); err != nil {
-			logger.InitLog.Errorf("create ip allocator[%s] failed: %s", dnnInfoConfig.UESubnet, err)
-			continue
-		} else {
-			dnnInfo.UeIPAllocator = allocator
+		dnnInfo.DNS = dnsServers[dnnInfoConfig.Dnn]
+		switch dnnInfoConfig.PDUSessionType {
+		case "", factory.DnnPDUSessionTypeIP:
+		case factory.DnnPDUSessionTypeEthernet:
+			dnnInfo.Ethernet = true
+		default:
+			logger.InitLog.Errorf("invalid PDU session type [%s] for dnn [%s], %s used",
+				dnnInfoConfig.PDUSessionType, dnnInfoConfig.Dnn, factory.DnnPDUSessionTypeIP)
+		}
+		// no UE IP for the Ethernet sessions
+		if !dnnInfo.Ethernet {
+			if allocator, err := c.ueIPAllocator(snssaiInfo.Snssai, dnnInfoConfig.Dnn, dnnInfoConfig.UESubnet); err != nil {
+				logger.InitLog.Errorf("create ip allocator[%s] failed: %s", dnnInfoConfig.UESubnet, err)
+				continue
+			} else {
+				dnnInfo.UeIPAllocator = allocator
+			}
 		}
 
 		if dnnInfoConfig.MTU != 0 {
@@ -63,8 +92,156 @@ func (c *SMFContext) insertSmfNssaiInfo(snssaiInfoConfig *factory.SnssaiInfoItem
 			dnnInfo.MTU = 1400
 		}
 
+		// fallback QoS for this DNN, used when PCF policy is not available
+		if dnnInfoConfig.DefaultQos != (factory.DnnDefaultQos{}) {
+			if err := validateDnnDefaultQos(&dnnInfoConfig.DefaultQos); err != nil {
+				logger.InitLog.Errorf("invalid default qos for dnn [%s]: %v", dnnInfoConfig.Dnn, err)
+			} else {
+				defaultQos := dnnInfoConfig.DefaultQos
+				dnnInfo.DefaultQos = &defaultQos
+			}
+		}
+
+		// 5GS TSN bridge port configuration
+		if dnnInfoConfig.TSNConfig != nil {
+			tsnConfig := *dnnInfoConfig.TSNConfig
+			dnnInfo.TSNConfig = &tsnConfig
+		}
+
+		// retry policy of the failed session establishments
+		if dnnInfoConfig.Retry != nil {
+			if dnnInfoConfig.Retry.MaxAttempts < 1 || dnnInfoConfig.Retry.BackoffMs < 0 {
+				logger.InitLog.Errorf("invalid retry policy for dnn [%s]: %+v", dnnInfoConfig.Dnn, *dnnInfoConfig.Retry)
+			} else {
+				retry := *dnnInfoConfig.Retry
+				dnnInfo.Retry = &retry
+			}
+		}
+
+		// establishments while none of the UPFs is associated
+		if upfUnavailable := dnnInfoConfig.UPFUnavailable; upfUnavailable != nil {
+			switch {
+			case upfUnavailable.Policy == factory.UPFUnavailableReject:
+				dnnInfo.UPFUnavailable = &factory.UPFUnavailableConfig{Policy: factory.UPFUnavailableReject}
+			case upfUnavailable.Policy == factory.UPFUnavailableRetryWithBackoff &&
+				upfUnavailable.MaxAttempts >= 1 && upfUnavailable.BackoffMs >= 0:
+				policy := *upfUnavailable
+				dnnInfo.UPFUnavailable = &policy
+			default:
+				logger.InitLog.Errorf("invalid UPF unavailable policy for dnn [%s]: %+v", dnnInfoConfig.Dnn, *upfUnavailable)
+			}
+		}
+
+		dnnInfo.FallbackToIPv4 = dnnInfoConfig.FallbackToIPv4
+
+		// prioritized anchor UPFs of the DNN
+		if len(dnnInfoConfig.PreferredUPFs) > 0 {
+			dnnInfo.UPFSelectionChain = slices.Clone(dnnInfoConfig.PreferredUPFs)
+		}
+
+		if len(dnnInfoConfig.HeaderEnrichment) > 0 {
+			dnnInfo.HeaderEnrichment = slices.Clone(dnnInfoConfig.HeaderEnrichment)
+		}
+
+		// EPS bearer QoS of the QoS flows for the 5GS to EPS handover
+		for fiveQI, qci := range dnnInfoConfig.FiveQIToQCIMapping {
+			if !validQoSIdentifier(fiveQI) || !validQoSIdentifier(qci) {
+				logger.InitLog.Errorf("invalid 5QI to QCI mapping %d:%d for dnn [%s]", fiveQI, qci, dnnInfoConfig.Dnn)
+				continue
+			}
+			if dnnInfo.FiveQIToQCIMapping == nil {
+				dnnInfo.FiveQIToQCIMapping = make(map[int32]int32)
+			}
+			dnnInfo.FiveQIToQCIMapping[fiveQI] = qci
+		}
+
+		switch dnnInfoConfig.SessionContinuityMode {
+		case "", factory.SessionContinuityBufferAndWait:
+			dnnInfo.SessionContinuityMode = factory.SessionContinuityBufferAndWait
+		case factory.SessionContinuityImmediateRelease:
+			dnnInfo.SessionContinuityMode = factory.SessionContinuityImmediateRelease
+		default:
+			logger.InitLog.Errorf("invalid session continuity mode [%s] for dnn [%s], %s used",
+				dnnInfoConfig.SessionContinuityMode, dnnInfoConfig.Dnn, factory.SessionContinuityBufferAndWait)
+			dnnInfo.SessionContinuityMode = factory.SessionContinuityBufferAndWait
+		}
+
+		// service chaining of the N6 traffic
+		if dnnInfoConfig.SRv6SteeringPolicy != nil {
+			if segments, err := ParseSRv6SegmentList(dnnInfoConfig.SRv6SteeringPolicy.SegmentList); err != nil {
+				logger.InitLog.Errorf("invalid SRv6 steering policy for dnn [%s]: %v", dnnInfoConfig.Dnn, err)
+			} else {
+				dnnInfo.SRv6SegmentList = segments
+			}
+		}
+
+		// subnets behind the UEs
+		for supi, routes := range dnnInfoConfig.FramedRoutes {
+			framedRoutes, err := ParseFramedRoutes(routes)
+			if err != nil {
+				logger.InitLog.Errorf("invalid framed routes of [%s] for dnn [%s]: %v", supi, dnnInfoConfig.Dnn, err)
+				continue
+			}
+			if dnnInfo.FramedRoutes == nil {
+				dnnInfo.FramedRoutes = make(map[string][]string)
+			}
+			dnnInfo.FramedRoutes[supi] = framedRoutes
+		}
+
+		// packet delay monitoring of the QoS flows
+		if qosMonitoringConfig := dnnInfoConfig.QoSMonitoring; qosMonitoringConfig != nil {
+			qosMonitoring, err := ParseQoSMonitoring(qosMonitoringConfig)
+			if err != nil {
+				logger.InitLog.Errorf("invalid QoS monitoring for dnn [%s]: %v", dnnInfoConfig.Dnn, err)
+			} else {
+				dnnInfo.QoSMonitoring = qosMonitoring
+			}
+		}
+
+		// paging policy differentiation of the downlink data
+		for dscp, ppi := range dnnInfoConfig.PagingPolicyIndicators {
+			if dscp > factory.MaxDSCP || ppi > factory.MaxPPI {
+				logger.InitLog.Errorf("invalid DSCP to paging policy indicator mapping %d:%d for dnn [%s]", dscp, ppi, dnnInfoConfig.Dnn)
+				continue
+			}
+			if dnnInfo.PagingPolicyIndicators == nil {
+				dnnInfo.PagingPolicyIndicators = make(map[uint8]uint8)
+			}
+			dnnInfo.PagingPolicyIndicators[dscp] = ppi
+		}
+
+		// concurrent sessions limit
+		if sessionLimit := dnnInfoConfig.SessionLimit; sessionLimit != nil {
+			if sessionLimit.MaxConcurrentSessions < 1 || sessionLimit.QueueDepth < 0 || sessionLimit.QueueTimeoutMs < 0 {
+				logger.InitLog.Errorf("invalid session limit for dnn [%s]: %+v", dnnInfoConfig.Dnn, *sessionLimit)
+			} else {
+				dnnInfo.SessionLimiter = NewSessionLimiter(dnnInfoConfig.Dnn, sessionLimit)
+			}
+		}
+
+		// QoS flows per session
+		if dnnInfoConfig.MaxQoSFlows < 0 || dnnInfoConfig.MaxQoSFlows > factory.MaxQoSFlows {
+			logger.InitLog.Errorf("invalid maximum QoS flows %d for dnn [%s], 0 to %d",
+				dnnInfoConfig.MaxQoSFlows, dnnInfoConfig.Dnn, factory.MaxQoSFlows)
+		} else {
+			dnnInfo.MaxQoSFlows = dnnInfoConfig.MaxQoSFlows
+		}
+
+		// services exempt from the PS data off of the UEs
+		dnnInfo.PsDataOffExemptApps = dnnInfoConfig.PsDataOffExemptApps
+
+		// steering of the MA PDU session traffic
+		if atsssConfig := dnnInfoConfig.ATSSS; atsssConfig != nil {
+			atsss, err := ParseATSSSConfig(atsssConfig)
+			if err != nil {
+				logger.InitLog.Errorf("invalid ATSSS for dnn [%s]: %v", dnnInfoConfig.Dnn, err)
+			} else {
+				dnnInfo.ATSSS = atsss
+			}
+		}
+
 		// block static IPs for this DNN if any
-		if staticIpsCfg := c.GetDnnStaticIpInfo(dnnInfoConfig.Dnn); staticIpsCfg != nil {
+		if staticIpsCfg := c.GetDnnStaticIpInfo(dnnInfoConfig.Dnn); staticIpsCfg != nil && dnnInfo.UeIPAllocator != nil {
 			logger.InitLog.Infof("initialising slice [sst:%v, sd:%v], dnn [%s] with static IP info [%v]", snssaiInfo.Snssai.Sst, snssaiInfo.Snssai.Sd, dnnInfoConfig.Dnn, staticIpsCfg)
 			dnnInfo.UeIPAllocator.ReserveStaticIps(&staticIpsCfg.ImsiIpInfo)
 		}
@@ -79,6 +256,10 @@ func (c *SMFContext) insertSmfNssaiInfo(snssaiInfoConfig *factory.SnssaiInfoItem
 func (c *SMFContext) updateSmfNssaiInfo(modSliceInfo *factory.SnssaiInfoItem) error {
 	// identify slices to be updated
 	logger.InitLog.Infof("Network Slices to be modified [%v] ", factory.PrettyPrintNetworkSlices([]factory.SnssaiInfoItem{*modSliceInfo}))
+	if !c.servesSnssai(modSliceInfo.SNssai) {
+		logger.InitLog.Infof("network slice [sst:%d, sd:%s] filtered out, not served", modSliceInfo.SNssai.Sst, modSliceInfo.SNssai.Sd)
+		return nil
+	}
 	if err := c.deleteSmfNssaiInfo(modSliceInfo); err != nil {
 		return fmt.Errorf("network slice delete error %v", err)
 	}
@@ -91,6 +272,10 @@ func (c *SMFContext) updateSmfNssaiInfo(modSliceInfo *factory.SnssaiInfoItem) er
 
 func (c *SMFContext) deleteSmfNssaiInfo(delSliceInfo *factory.SnssaiInfoItem) error {
 	logger.InitLog.Infof("Network Slices to be deleted [%v] ", factory.PrettyPrintNetworkSlices([]factory.SnssaiInfoItem{*delSliceInfo}))
+	// a slice filtered out was never inserted
+	if !c.servesSnssai(delSliceInfo.SNssai) {
+		return nil
+	}
 
 	for index, slice := range c.SnssaiInfos {
 		if slice.Snssai.Sd == delSliceInfo.SNssai.Sd && slice.Snssai.Sst == delSliceInfo.SNssai.Sst {
@@ -114,3 +299,30 @@ func (c *SMFContext) getSmfNssaiInfo(sst int32, sd string) *SnssaiSmfInfo {
 	}
 	return nil
 }
+
+// servesSnssai returns true if the slice passes the S-NSSAI filter of the configuration
+func (c *SMFContext) servesSnssai(snssai *models.Snssai) bool {
+	if c.SnssaiFilter == nil {
+		return true
+	}
+	match := func(filter models.Snssai) bool {
+		return filter.Sst == snssai.Sst && filter.Sd == snssai.Sd
+	}
+	if len(c.SnssaiFilter.Allow) > 0 && !slices.ContainsFunc(c.SnssaiFilter.Allow, match) {
+		return false
+	}
+	return !slices.ContainsFunc(c.SnssaiFilter.Deny, match)
+}
+
+func validateDnnDefaultQos(defaultQos *factory.DnnDefaultQos) error {
+	if defaultQos.Var5qi < 1 || defaultQos.Var5qi > 255 {
+		return fmt.Errorf("5qi [%d] out of range", defaultQos.Var5qi)
+	}
+	if defaultQos.ArpPriorityLevel < 1 || defaultQos.ArpPriorityLevel > 15 {
+		return fmt.Errorf("arp priority level [%d] out of range", defaultQos.ArpPriorityLevel)
+	}
+	if defaultQos.SessionAmbrUplink == "" || defaultQos.SessionAmbrDownlink == "" {
+		return fmt.Errorf("session ambr missing")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+)
+
+// configUpdate is a config waiting to be applied, shared by the callers of the configs it
+// superseded
+type configUpdate struct {
+	cfg                 *factory.Configuration
+	done                chan struct{}
+	sendNrfRegistration bool
+}
+
+// configUpdateQueue holds the config to be applied next by the config update worker, replaced by
+// a config enqueued before it is applied
+var configUpdateQueue = struct {
+	sync.Mutex
+	pending *configUpdate
+	wake    chan struct{}
+	worker  sync.Once
+}{wake: make(chan struct{}, 1)}
+
+// beforeConfigUpdate is called by the worker before it applies a config, replaced in tests.
+// Guarded by the lock of the queue.
+var beforeConfigUpdate = func() {}
+
+// UpdateSmfContext applies the network slices, UP nodes, links and enterprises of the config to
+// the SMF context. The updates are applied one at a time by a single worker, the readers of the
+// SMF context not being blocked meanwhile. Blocks until the config is applied, or the config
+// enqueued after it while it was waiting, whose result is returned. Returns true when the network
+// slices changed and the NRF registration is to be updated.
+func UpdateSmfContext(cfg *factory.Configuration) (bool, error) {
+	if cfg == nil {
+		return false, errors.New("no configuration to apply")
+	}
+	queue := &configUpdateQueue
+	queue.worker.Do(func() { go runConfigUpdates() })
+
+	queue.Lock()
+	update := queue.pending
+	if update != nil {
+		// not applied yet, the newer config is applied instead
+		update.cfg = cfg
+	} else {
+		update = &configUpdate{cfg: cfg, done: make(chan struct{})}
+		queue.pending = update
+		select {
+		case queue.wake <- struct{}{}:
+		default:
+		}
+	}
+	queue.Unlock()
+
+	<-update.done
+	return update.sendNrfRegistration, nil
+}
+
+func runConfigUpdates() {
+	queue := &configUpdateQueue
+	for range queue.wake {
+		queue.Lock()
+		update := queue.pending
+		queue.pending = nil
+		before := beforeConfigUpdate
+		queue.Unlock()
+		if update == nil {
+			continue
+		}
+		before()
+
+		if factory.SmfConfig.StageConfigUpdate(update.cfg) {
+			update.sendNrfRegistration = ProcessConfigUpdate()
+		} else {
+			logger.CtxLog.Infoln("config update, no change to apply")
+		}
+		close(update.done)
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateSmfContextConcurrent(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	contexttest.ClearConfig(t)
+
+	// each config has a single slice of its own
+	sliceConfig := func(i int) *factory.Configuration {
+		return &factory.Configuration{
+			SNssaiInfo: []factory.SnssaiInfoItem{{
+				SNssai: &models.Snssai{Sst: 1, Sd: fmt.Sprintf("%06x", i)},
+				DnnInfos: []factory.SnssaiDnnInfoItem{
+					{Dnn: "internet", UESubnet: fmt.Sprintf("10.%d.0.0/24", i)},
+				},
+			}},
+			EnterpriseList: map[string]string{"1": fmt.Sprintf("enterprise%d", i)},
+		}
+	}
+
+	// the worker blocked applying a first config while the others are enqueued one after the other
+	applying := make(chan struct{}, 1)
+	release := make(chan struct{})
+	context.SetBeforeConfigUpdate(t, func() {
+		select {
+		case applying <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	var wg sync.WaitGroup
+	update := func(cfg *factory.Configuration) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := context.UpdateSmfContext(cfg)
+			require.NoError(t, err)
+		}()
+	}
+	update(sliceConfig(0))
+	<-applying
+	for i := 1; i <= 10; i++ {
+		cfg := sliceConfig(i)
+		update(cfg)
+		require.Eventually(t, func() bool { return context.PendingConfigUpdate() == cfg },
+			time.Second, time.Millisecond, "config %d enqueued", i)
+	}
+	close(release)
+	wg.Wait()
+
+	// the SMF context holds the whole last enqueued config, the one kept for the next compare
+	const i = 10
+	require.Len(t, factory.SmfConfig.Configuration.SNssaiInfo, 1)
+	last := factory.SmfConfig.Configuration.SNssaiInfo[0].SNssai
+	require.Equal(t, &models.Snssai{Sst: 1, Sd: fmt.Sprintf("%06x", i)}, last)
+	require.Len(t, smfSelf.SnssaiInfos, 1)
+	require.Equal(t, context.SNssai{Sst: last.Sst, Sd: last.Sd}, smfSelf.SnssaiInfos[0].Snssai)
+	require.Equal(t, sliceConfig(i).EnterpriseList, *smfSelf.EnterpriseList)
+	dnnInfo := context.RetrieveDnnInformation(*last, "internet")
+	require.NotNil(t, dnnInfo)
+	require.Equal(t, fmt.Sprintf("10.%d.0.0/24", i), dnnInfo.UeIPAllocator.Domain())
+
+	// the last config is not applied again
+	sendNrfRegistration, err := context.UpdateSmfContext(sliceConfig(i))
+	require.NoError(t, err)
+	require.False(t, sendNrfRegistration)
+	require.Same(t, dnnInfo, context.RetrieveDnnInformation(*last, "internet"))
+
+	_, err = context.UpdateSmfContext(nil)
+	require.Error(t, err)
+}
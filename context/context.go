@@ -8,8 +8,10 @@ package context
 
 import (
 	"fmt"
+	"maps"
 	"net"
 	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,6 +20,7 @@ import (
 	"github.com/omec-project/openapi/Nnrf_NFDiscovery"
 	"github.com/omec-project/openapi/Nnrf_NFManagement"
 	"github.com/omec-project/openapi/Nudm_SubscriberDataManagement"
+	"github.com/omec-project/openapi/Nudm_UEAuthentication"
 	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/factory"
 	"github.com/omec-project/smf/logger"
@@ -48,6 +51,8 @@ type SMFContext struct {
 	URIScheme    models.UriScheme
 	BindingIPv4  string
 	RegisterIPv4 string
+	// URI of the SBI advertised to the NRF and the peers, empty if not configured
+	AdvertisedURI string
 
 	UPNodeIDs []NodeID
 	Key       string
@@ -55,11 +60,32 @@ type SMFContext struct {
 	KeyLog    string
 
 	SnssaiInfos []SnssaiSmfInfo
-
-	NrfUri                         string
+	// slices of the configuration served, all if nil
+	SnssaiFilter *factory.SnssaiFilter
+	// handling of the requested S-NSSAIs without SD, factory.SdlessSnssaiWildcard if empty
+	SdlessSnssai string
+	// IP addresses of the SMFs allowed to transfer their sessions to this SMF
+	SmContextTransferPeers []string
+	// UEs establishing sessions over trusted non-3GPP access authenticated with EAP-AKA'
+	EapAkaPrimeNon3gppAccess bool
+
+	// UE IP pools per slice and DNN
+	UeIPPools     map[UeIPPoolKey]*IPAllocator
+	UeIPPoolsLock sync.Mutex
+	// time the UE IP of a released session is held for the re-establishment of the session
+	IPReleaseGracePeriod time.Duration
+	// operator ID of the session audit events, the PLMN ID of the serving network if empty
+	AuditOperatorID string
+
+	NrfUri string
+	// PCF discovered with a DNS SRV lookup instead of the NRF, if not nil
+	PcfSrvDiscovery *SRVDNSDiscovery
+	// registration of the SMF to the NRF completed
+	NrfRegistered                  atomic.Bool
 	NFManagementClient             *Nnrf_NFManagement.APIClient
 	NFDiscoveryClient              *Nnrf_NFDiscovery.APIClient
 	SubscriberDataManagementClient *Nudm_SubscriberDataManagement.APIClient
+	UEAuthenticationClient         *Nudm_UEAuthentication.APIClient
 
 	UserPlaneInformation *UserPlaneInformation
 
@@ -74,9 +100,24 @@ type SMFContext struct {
 	NfStatusSubscriptions sync.Map // map[NfInstanceID]models.NrfSubscriptionData.SubscriptionId
 	PodIp                 string
 
-	StaticIpInfo             *[]factory.StaticIpInfo
-	CPNodeID                 NodeID
+	StaticIpInfo   *[]factory.StaticIpInfo
+	UpSecurityInfo *[]factory.UpSecurityInfo
+	CPNodeID       NodeID
+	// FQDN of the Node ID of the SMF, for the UPFs associated with a Node ID of FQDN type
+	CPNodeFQDN               string
 	PFCPPort                 int
+	PFCPDscp                 uint8
+	PFCPMtu                  int
+	PFCPEnterpriseID         uint16
+	PFCPRecvBufferSize       int
+	PFCPSendBufferSize       int
+	PFCPWorkers              int
+	MaxPDRsPerSession        int
+	MaxQERsPerSession        int
+	DDNThrottlingWindow      time.Duration
+	UsageReportingPeriod     time.Duration
+	HeartbeatJitter          float64
+	PFCPRateLimit            *factory.PFCPRateLimit
 	UDMProfile               models.NfProfile
 	NrfCacheEvictionInterval time.Duration
 	SBIPort                  int
@@ -97,6 +138,75 @@ func RetrieveDnnInformation(Snssai models.Snssai, dnn string) *SnssaiSmfDnnInfo
 	return nil
 }
 
+// SelectSnssaiInfo returns the configuration of the DNN for the requested S-NSSAI, which is kept
+// by the session. The slice of the requested S-NSSAI is used when it serves the DNN. A request
+// without SD otherwise matches every slice of its SST, the slice with the highest priority is
+// used and the first configured one on a tie. It matches none if the SD-less S-NSSAIs are
+// rejected.
+func SelectSnssaiInfo(Snssai models.Snssai, dnn string) *SnssaiSmfInfo {
+	if Snssai.Sd == "" && SMF_Self().SdlessSnssai == factory.SdlessSnssaiReject {
+		logger.CtxLog.Warnf("S-NSSAI[sst: %d] without SD rejected", Snssai.Sst)
+		return nil
+	}
+	requested := &SNssai{Sst: Snssai.Sst, Sd: Snssai.Sd}
+	var selected *SnssaiSmfInfo
+	snssaiInfos := SMF_Self().SnssaiInfos
+	for i := range snssaiInfos {
+		snssaiInfo := &snssaiInfos[i]
+		if !snssaiInfo.Snssai.Serves(requested) {
+			continue
+		}
+		if _, ok := snssaiInfo.DnnInfos[dnn]; !ok {
+			continue
+		}
+		if snssaiInfo.Snssai.Equal(requested) {
+			return snssaiInfo
+		}
+		if selected == nil || snssaiInfo.Priority > selected.Priority {
+			selected = snssaiInfo
+		}
+	}
+	return selected
+}
+
+// SlicePriority returns the configured priority of the slice, 0 if it is not configured
+func SlicePriority(snssai SNssai) int32 {
+	for _, snssaiInfo := range SMF_Self().SnssaiInfos {
+		if snssaiInfo.Snssai.Equal(&snssai) {
+			return snssaiInfo.Priority
+		}
+	}
+	return 0
+}
+
+// IPPoolStats is the usage of the UE IP pool of a DNN in a network slice
+type IPPoolStats struct {
+	Snssai SNssai `json:"snssai"`
+	Dnn    string `json:"dnn"`
+	Domain string `json:"domain"`
+	IPPoolUsage
+}
+
+// PoolStats returns the usage of every UE IP pool, per slice and DNN in order
+func (smfCtxt *SMFContext) PoolStats() []IPPoolStats {
+	stats := make([]IPPoolStats, 0)
+	for _, snssaiInfo := range smfCtxt.SnssaiInfos {
+		for _, dnn := range slices.Sorted(maps.Keys(snssaiInfo.DnnInfos)) {
+			dnnInfo := snssaiInfo.DnnInfos[dnn]
+			if dnnInfo.UeIPAllocator == nil {
+				continue
+			}
+			stats = append(stats, IPPoolStats{
+				Snssai:      snssaiInfo.Snssai,
+				Dnn:         dnn,
+				Domain:      dnnInfo.UeIPAllocator.Domain(),
+				IPPoolUsage: dnnInfo.UeIPAllocator.Usage(),
+			})
+		}
+	}
+	return stats
+}
+
 func AllocateLocalSEID() (uint64, error) {
 	if factory.SmfConfig.Configuration.EnableDbStore {
 		if smfContext.DrsmCtxts.SeidPool == nil {
@@ -147,6 +257,9 @@ func InitSmfContext(config *factory.Config) *SMFContext {
 	// copy static UE IP Addr config
 	smfContext.StaticIpInfo = &configuration.StaticIpInfo
 
+	// copy UP security policy config
+	smfContext.UpSecurityInfo = &configuration.UpSecurityInfo
+
 	sbi := configuration.Sbi
 	localIp := GetLocalIP()
 	logger.CtxLog.Infof("sbi lb - localIp %v", localIp)
@@ -188,6 +301,21 @@ func InitSmfContext(config *factory.Config) *SMFContext {
 				smfContext.BindingIPv4 = "0.0.0.0"
 			}
 		}
+		if err := ValidateSbiBindingAddress(smfContext.BindingIPv4); err != nil {
+			logger.CtxLog.Errorf("invalid SBI configuration: %v", err)
+			return nil
+		}
+
+		smfContext.AdvertisedURI = ""
+		if sbi.AdvertisedURI != "" {
+			advertisedURI, err := ParseSbiAdvertisedURI(sbi.AdvertisedURI)
+			if err != nil {
+				logger.CtxLog.Errorf("invalid SBI configuration: %v", err)
+				return nil
+			}
+			smfContext.AdvertisedURI = advertisedURI
+			logger.CtxLog.Infof("SBI bound to %s:%d, advertised as %s", smfContext.BindingIPv4, smfContext.SBIPort, advertisedURI)
+		}
 	}
 
 	if configuration.NrfUri != "" {
@@ -218,8 +346,68 @@ func InitSmfContext(config *factory.Config) *SMFContext {
 
 		smfContext.PFCPPort = int(pfcp.Port)
 
+		if pfcp.DSCP > factory.MaxDSCP {
+			logger.CtxLog.Errorf("invalid PFCP DSCP %d, must be in range 0-%d, PFCP packets not marked", pfcp.DSCP, factory.MaxDSCP)
+		} else {
+			smfContext.PFCPDscp = pfcp.DSCP
+		}
+
+		smfContext.PFCPMtu = factory.DEFAULT_PFCP_MTU
+		if pfcp.MTU != 0 {
+			if pfcp.MTU < factory.MIN_PFCP_MTU {
+				logger.CtxLog.Errorf("invalid PFCP MTU %d, must be at least %d, using %d", pfcp.MTU, factory.MIN_PFCP_MTU, factory.DEFAULT_PFCP_MTU)
+			} else {
+				smfContext.PFCPMtu = int(pfcp.MTU)
+			}
+		}
+
+		smfContext.PFCPRecvBufferSize = pfcpSocketBufferSize("receive", pfcp.RecvBufferSize)
+		smfContext.PFCPSendBufferSize = pfcpSocketBufferSize("send", pfcp.SendBufferSize)
+		smfContext.PFCPWorkers = max(pfcp.Workers, 0)
+		smfContext.MaxPDRsPerSession = max(pfcp.MaxPDRsPerSession, 0)
+		smfContext.MaxQERsPerSession = max(pfcp.MaxQERsPerSession, 0)
+
+		smfContext.DDNThrottlingWindow = factory.DEFAULT_DDN_THROTTLING_WINDOW * time.Second
+		if pfcp.DDNThrottlingWindow > 0 {
+			smfContext.DDNThrottlingWindow = time.Duration(pfcp.DDNThrottlingWindow) * time.Second
+		}
+		smfContext.UsageReportingPeriod = time.Duration(max(pfcp.UsageReportingPeriod, 0)) * time.Second
+		if pfcp.HeartbeatJitter > 0 && pfcp.HeartbeatJitter < 1 {
+			smfContext.HeartbeatJitter = pfcp.HeartbeatJitter
+		}
+		smfContext.PFCPRateLimit = pfcp.RateLimit
+		smfContext.PFCPEnterpriseID = pfcp.EnterpriseID
+		if pfcp.CongestionReportIntervalMs > 0 {
+			congestionDetector = NewCongestionDetector(time.Duration(pfcp.CongestionReportIntervalMs) * time.Millisecond)
+		}
+
 		smfContext.CPNodeID.NodeIdType = 0
 		smfContext.CPNodeID.NodeIdValue = addr.IP.To4()
+		// the PFCP address when it is a name, else the hostname of the SMF
+		if net.ParseIP(pfcp.Addr) == nil {
+			smfContext.CPNodeFQDN = pfcp.Addr
+		} else if hostname, err := os.Hostname(); err == nil {
+			smfContext.CPNodeFQDN = hostname
+		}
+	}
+
+	smfContext.SnssaiFilter = configuration.SnssaiFilter
+	smfContext.SdlessSnssai = configuration.SdlessSnssai
+	smfContext.SmContextTransferPeers = configuration.SmContextTransferPeers
+	smfContext.EapAkaPrimeNon3gppAccess = configuration.EapAkaPrimeNon3gppAccess
+	smfContext.IPReleaseGracePeriod = time.Duration(max(configuration.IpReleaseGracePeriodSec, 0)) * time.Second
+	if configuration.AuditLog != nil {
+		smfContext.AuditOperatorID = configuration.AuditLog.OperatorId
+	}
+	dnsCache.SetTTL(configuration.DNSCache)
+
+	smfContext.PcfSrvDiscovery = nil
+	if srvConfig := configuration.PcfSrvDiscovery; srvConfig != nil {
+		if discovery, err := NewSRVDNSDiscovery(srvConfig); err != nil {
+			logger.CtxLog.Errorf("PCF SRV discovery not enabled, PCF discovered with the NRF: %v", err)
+		} else {
+			smfContext.PcfSrvDiscovery = discovery
+		}
 	}
 
 	// Static config
@@ -261,6 +449,20 @@ func InitSmfContext(config *factory.Config) *SMFContext {
 	return &smfContext
 }
 
+// pfcpSocketBufferSize returns the configured size of a PFCP socket buffer, 0 for the system
+// default when not set or below the minimum
+func pfcpSocketBufferSize(direction string, size int) int {
+	if size == 0 {
+		return 0
+	}
+	if size < factory.MIN_PFCP_SOCKET_BUFFER {
+		logger.CtxLog.Errorf("invalid PFCP %s buffer size %d, must be at least %d, using the system default",
+			direction, size, factory.MIN_PFCP_SOCKET_BUFFER)
+		return 0
+	}
+	return size
+}
+
 func InitSMFUERouting(routingConfig *factory.RoutingConfig) {
 	if !smfContext.ULCLSupport {
 		return
@@ -297,8 +499,27 @@ func GetUserPlaneInformation() *UserPlaneInformation {
 	return smfContext.UserPlaneInformation
 }
 
+// ReloadConfig applies the changes of the config file to the SMF context, on SIGHUP. Returns true
+// when the network slices changed and the NRF registration is to be updated.
+func ReloadConfig() (bool, error) {
+	cfg, err := factory.SmfConfig.LoadConfigFile()
+	if err != nil {
+		metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateParseError)
+		return false, fmt.Errorf("config reload failed: %w", err)
+	}
+	return UpdateSmfContext(cfg)
+}
+
 func ProcessConfigUpdate() bool {
+	// serialized with the config pod updates and the config reloads
+	factory.SmfConfigSyncLock.Lock()
+	defer factory.SmfConfigSyncLock.Unlock()
+
 	logger.CtxLog.Infof("Dynamic config update received [%+v]", factory.UpdatedSmfConfig)
+	start := time.Now()
+	defer func() {
+		metrics.ObserveConfigUpdateDuration(time.Since(start))
+	}()
 
 	sendNrfRegistration := false
 	// Lets check updated config
@@ -310,6 +531,7 @@ func ProcessConfigUpdate() bool {
 			err := SMF_Self().deleteSmfNssaiInfo(&slice)
 			if err != nil {
 				logger.CtxLog.Errorf("delete network slice [%v] failed: %v", slice, err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateValidationError)
 			}
 		}
 		factory.UpdatedSmfConfig.DelSNssaiInfo = nil
@@ -321,6 +543,7 @@ func ProcessConfigUpdate() bool {
 			err := SMF_Self().insertSmfNssaiInfo(&slice)
 			if err != nil {
 				logger.CtxLog.Errorf("insert network slice [%v] failed: %v", slice, err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateValidationError)
 			}
 		}
 		factory.UpdatedSmfConfig.AddSNssaiInfo = nil
@@ -332,6 +555,7 @@ func ProcessConfigUpdate() bool {
 			err := SMF_Self().updateSmfNssaiInfo(&slice)
 			if err != nil {
 				logger.CtxLog.Errorf("update network slice [%v] failed: %v", slice, err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateValidationError)
 			}
 		}
 		factory.UpdatedSmfConfig.ModSNssaiInfo = nil
@@ -344,6 +568,7 @@ func ProcessConfigUpdate() bool {
 			err := GetUserPlaneInformation().DeleteUPNodeLinks(&link)
 			if err != nil {
 				logger.CtxLog.Errorf("delete UP Node Links failed: %v", err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateValidationError)
 			}
 		}
 		factory.UpdatedSmfConfig.DelLinks = nil
@@ -352,9 +577,11 @@ func ProcessConfigUpdate() bool {
 	// Iterate through UserPlane Info
 	if updatedCfg.DelUPNodes != nil {
 		for name, upf := range *updatedCfg.DelUPNodes {
+			GetUserPlaneInformation().releaseRemovedUPF(name, &upf)
 			err := GetUserPlaneInformation().DeleteSmfUserPlaneNode(name, &upf)
 			if err != nil {
 				logger.CtxLog.Errorf("delete UP Node [%s] failed: %v", name, err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateUpfResolveError)
 			}
 		}
 		factory.UpdatedSmfConfig.DelUPNodes = nil
@@ -365,6 +592,7 @@ func ProcessConfigUpdate() bool {
 			err := GetUserPlaneInformation().InsertSmfUserPlaneNode(name, &upf)
 			if err != nil {
 				logger.CtxLog.Errorf("insert UP Node [%s] failed: %v", name, err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateUpfResolveError)
 			}
 		}
 		factory.UpdatedSmfConfig.AddUPNodes = nil
@@ -377,6 +605,7 @@ func ProcessConfigUpdate() bool {
 			err := GetUserPlaneInformation().UpdateSmfUserPlaneNode(name, &upf)
 			if err != nil {
 				logger.CtxLog.Errorf("update UP Node [%s] failed: %v", name, err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateUpfResolveError)
 			}
 		}
 		factory.UpdatedSmfConfig.ModUPNodes = nil
@@ -389,6 +618,7 @@ func ProcessConfigUpdate() bool {
 			err := GetUserPlaneInformation().InsertUPNodeLinks(&link)
 			if err != nil {
 				logger.CtxLog.Errorf("insert UP Node Links failed: %v", err)
+				metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateValidationError)
 			}
 		}
 		factory.UpdatedSmfConfig.AddLinks = nil
@@ -401,6 +631,10 @@ func ProcessConfigUpdate() bool {
 	// Any time config changes(Slices/UPFs/Links) then reset Default path(Key= nssai+Dnn)
 	GetUserPlaneInformation().ResetDefaultUserPlanePath()
 
+	for _, violation := range SMF_Self().SelfCheck() {
+		logger.CtxLog.Errorf("context inconsistent after config update: %v", violation)
+	}
+
 	// Send NRF Re-register if Slice info got updated
 	if sendNrfRegistration {
 		SetupNFProfile(&factory.SmfConfig)
@@ -442,6 +676,32 @@ func (smfCtxt *SMFContext) InitDrsm() error {
 	return nil
 }
 
+// GetDnnUpIntegrityProtection returns the UP integrity protection policy configured for the DNN,
+// or an empty value if no policy is configured
+func (smfCtxt *SMFContext) GetDnnUpIntegrityProtection(dnn string) models.UpIntegrity {
+	if smfCtxt.UpSecurityInfo == nil {
+		return ""
+	}
+	for _, info := range *smfCtxt.UpSecurityInfo {
+		if info.Dnn != dnn {
+			continue
+		}
+		logger.CfgLog.Debugf("get up security info for dnn [%s] found [%v]", dnn, info)
+		switch info.UpIntegrity {
+		case factory.UpIntegrityRequired:
+			return models.UpIntegrity_REQUIRED
+		case factory.UpIntegrityPreferred:
+			return models.UpIntegrity_PREFERRED
+		case factory.UpIntegrityNotNeeded:
+			return models.UpIntegrity_NOT_NEEDED
+		default:
+			logger.CfgLog.Warnf("invalid up integrity protection [%s] for dnn [%s]", info.UpIntegrity, dnn)
+			return ""
+		}
+	}
+	return ""
+}
+
 func (smfCtxt *SMFContext) GetDnnStaticIpInfo(dnn string) *factory.StaticIpInfo {
 	for _, info := range *smfCtxt.StaticIpInfo {
 		if info.Dnn == dnn {
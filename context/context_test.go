@@ -0,0 +1,346 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectSnssaiInfoByPriority(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	lowDnnInfo := &context.SnssaiSmfDnnInfo{MTU: 1400}
+	highDnnInfo := &context.SnssaiSmfDnnInfo{MTU: 1500}
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai:   context.SNssai{Sst: 1, Sd: "010203"},
+			Priority: 1,
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{"internet": lowDnnInfo},
+		},
+		{
+			Snssai:   context.SNssai{Sst: 1, Sd: "112233"},
+			Priority: 5,
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{"internet": highDnnInfo},
+		},
+		{
+			Snssai:   context.SNssai{Sst: 2, Sd: "445566"},
+			Priority: 10,
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{"internet": {}},
+		},
+	})
+
+	testCases := []struct {
+		name       string
+		snssai     models.Snssai
+		dnn        string
+		expectedSd string
+	}{
+		{
+			name:       "both slices of the SST match, higher priority selected",
+			snssai:     models.Snssai{Sst: 1},
+			dnn:        "internet",
+			expectedSd: "112233",
+		},
+		{
+			name:       "requested SD selects its slice regardless of priority",
+			snssai:     models.Snssai{Sst: 1, Sd: "010203"},
+			dnn:        "internet",
+			expectedSd: "010203",
+		},
+		{
+			name:   "no slice serves the DNN",
+			snssai: models.Snssai{Sst: 1},
+			dnn:    "ims",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			snssaiInfo := context.SelectSnssaiInfo(tc.snssai, tc.dnn)
+			if tc.expectedSd == "" {
+				require.Nil(t, snssaiInfo)
+				return
+			}
+			require.NotNil(t, snssaiInfo)
+			require.Equal(t, tc.expectedSd, snssaiInfo.Snssai.Sd)
+		})
+	}
+
+	snssaiInfo := context.SelectSnssaiInfo(models.Snssai{Sst: 1}, "internet")
+	require.Same(t, highDnnInfo, snssaiInfo.DnnInfos["internet"])
+
+	// a slice configured without SD is the exact match of a request without SD, whatever the
+	// priority of the other slices of the SST
+	exactDnnInfo := &context.SnssaiSmfDnnInfo{MTU: 1300}
+	smfSelf.SnssaiInfos = append(smfSelf.SnssaiInfos, context.SnssaiSmfInfo{
+		Snssai:   context.SNssai{Sst: 1},
+		DnnInfos: map[string]*context.SnssaiSmfDnnInfo{"internet": exactDnnInfo},
+	})
+	snssaiInfo = context.SelectSnssaiInfo(models.Snssai{Sst: 1}, "internet")
+	require.Same(t, exactDnnInfo, snssaiInfo.DnnInfos["internet"])
+}
+
+func TestSelectSnssaiInfoSdless(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	origSdlessSnssai := smfSelf.SdlessSnssai
+	t.Cleanup(func() { smfSelf.SdlessSnssai = origSdlessSnssai })
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai:   context.SNssai{Sst: 1, Sd: "010203"},
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{"internet": {}},
+		},
+	})
+
+	testCases := []struct {
+		name         string
+		sdlessSnssai string
+		snssai       models.Snssai
+		expectedSd   string
+	}{
+		{
+			name:       "missing SD matches any SD by default",
+			snssai:     models.Snssai{Sst: 1},
+			expectedSd: "010203",
+		},
+		{
+			name:         "missing SD matches any SD as wildcard",
+			sdlessSnssai: factory.SdlessSnssaiWildcard,
+			snssai:       models.Snssai{Sst: 1},
+			expectedSd:   "010203",
+		},
+		{
+			name:         "missing SD rejected",
+			sdlessSnssai: factory.SdlessSnssaiReject,
+			snssai:       models.Snssai{Sst: 1},
+		},
+		{
+			name:         "requested SD still matched if the SD-less S-NSSAIs are rejected",
+			sdlessSnssai: factory.SdlessSnssaiReject,
+			snssai:       models.Snssai{Sst: 1, Sd: "010203"},
+			expectedSd:   "010203",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smfSelf.SdlessSnssai = tc.sdlessSnssai
+			snssaiInfo := context.SelectSnssaiInfo(tc.snssai, "internet")
+			if tc.expectedSd == "" {
+				require.Nil(t, snssaiInfo)
+				return
+			}
+			require.NotNil(t, snssaiInfo)
+			require.Equal(t, tc.expectedSd, snssaiInfo.Snssai.Sd)
+		})
+	}
+}
+
+// gatheredMetric returns the value of the counter or gauge, or the sample count of the histogram,
+// with the given label values, in label name order, from the default registry
+func gatheredMetric(t *testing.T, name string, labelValues ...string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			if len(metric.GetLabel()) < len(labelValues) {
+				continue
+			}
+			for i, labelValue := range labelValues {
+				if metric.GetLabel()[i].GetValue() != labelValue {
+					continue metrics
+				}
+			}
+			switch {
+			case metric.GetHistogram() != nil:
+				return float64(metric.GetHistogram().GetSampleCount())
+			case metric.GetGauge() != nil:
+				return metric.GetGauge().GetValue()
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestProcessConfigUpdateMetrics(t *testing.T) {
+	contexttest.ClearConfig(t)
+	contexttest.SetUserPlane(t, context.NewUserPlaneInformation(configuration))
+	enterpriseList := map[string]string{}
+
+	validationErrors := gatheredMetric(t, "smf_config_update_errors_total", "validation_error")
+	upfResolveErrors := gatheredMetric(t, "smf_config_update_errors_total", "upf_resolve_error")
+	updates := gatheredMetric(t, "smf_config_update_duration_seconds")
+
+	// valid update of an existing UPF
+	factory.UpdatedSmfConfig = factory.UpdateSmfConfig{
+		ModUPNodes:     &map[string]factory.UPNode{"UPF1": configuration.UPNodes["UPF1"]},
+		EnterpriseList: &enterpriseList,
+	}
+	context.ProcessConfigUpdate()
+	require.Equal(t, validationErrors, gatheredMetric(t, "smf_config_update_errors_total", "validation_error"))
+	require.Equal(t, upfResolveErrors, gatheredMetric(t, "smf_config_update_errors_total", "upf_resolve_error"))
+	require.Equal(t, updates+1, gatheredMetric(t, "smf_config_update_duration_seconds"))
+
+	// unknown slice and UPF
+	factory.UpdatedSmfConfig = factory.UpdateSmfConfig{
+		DelSNssaiInfo:  &[]factory.SnssaiInfoItem{{SNssai: &models.Snssai{Sst: 1, Sd: "ffffff"}}},
+		ModUPNodes:     &map[string]factory.UPNode{"UPF9": {Type: "UPF", NodeID: "192.168.179.9"}},
+		EnterpriseList: &enterpriseList,
+	}
+	context.ProcessConfigUpdate()
+	require.Equal(t, validationErrors+1, gatheredMetric(t, "smf_config_update_errors_total", "validation_error"))
+	require.Equal(t, upfResolveErrors+1, gatheredMetric(t, "smf_config_update_errors_total", "upf_resolve_error"))
+	require.Equal(t, updates+2, gatheredMetric(t, "smf_config_update_duration_seconds"))
+}
+
+const reloadedSliceConfig = `
+configuration:
+  snssaiInfos:
+    - sNssai:
+        sst: 1
+        sd: "010203"
+      dnnInfos:
+        - dnn: internet
+          ueSubnet: 10.1.0.0/24
+`
+
+func TestReloadConfig(t *testing.T) {
+	contexttest.ClearConfig(t)
+	origCfgLocation := factory.SmfConfig.CfgLocation
+	t.Cleanup(func() { factory.SmfConfig.CfgLocation = origCfgLocation })
+	factory.SmfConfig.CfgLocation = filepath.Join(t.TempDir(), "smfcfg.yaml")
+	slice := models.Snssai{Sst: 1, Sd: "010203"}
+
+	// slice added to the config file
+	require.NoError(t, os.WriteFile(factory.SmfConfig.CfgLocation, []byte(reloadedSliceConfig), 0o600))
+	sendNrfRegistration, err := context.ReloadConfig()
+	require.NoError(t, err)
+	require.True(t, sendNrfRegistration)
+	dnnInfo := context.RetrieveDnnInformation(slice, "internet")
+	require.NotNil(t, dnnInfo)
+	require.NotNil(t, dnnInfo.UeIPAllocator)
+	require.Len(t, factory.SmfConfig.Configuration.SNssaiInfo, 1)
+
+	// the change already applied is not applied again
+	sendNrfRegistration, err = context.ReloadConfig()
+	require.NoError(t, err)
+	require.False(t, sendNrfRegistration)
+	require.Same(t, dnnInfo, context.RetrieveDnnInformation(slice, "internet"))
+
+	// slice removed from the config file
+	require.NoError(t, os.WriteFile(factory.SmfConfig.CfgLocation, []byte("configuration: {}\n"), 0o600))
+	sendNrfRegistration, err = context.ReloadConfig()
+	require.NoError(t, err)
+	require.True(t, sendNrfRegistration)
+	require.Nil(t, context.RetrieveDnnInformation(slice, "internet"))
+
+	// invalid config file, the running config is kept
+	require.NoError(t, os.WriteFile(factory.SmfConfig.CfgLocation, []byte("configuration: ["), 0o600))
+	_, err = context.ReloadConfig()
+	require.Error(t, err)
+	require.Empty(t, factory.SmfConfig.Configuration.SNssaiInfo)
+}
+
+func TestProcessConfigUpdateSnssaiFilter(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	contexttest.ClearConfig(t)
+	smfSelf.SnssaiFilter = &factory.SnssaiFilter{
+		Allow: []models.Snssai{{Sst: 1, Sd: "010203"}, {Sst: 1, Sd: "010204"}},
+		Deny:  []models.Snssai{{Sst: 1, Sd: "010204"}},
+	}
+	enterpriseList := map[string]string{}
+	validationErrors := gatheredMetric(t, "smf_config_update_errors_total", "validation_error")
+
+	factory.UpdatedSmfConfig = factory.UpdateSmfConfig{
+		AddSNssaiInfo: &[]factory.SnssaiInfoItem{
+			{SNssai: &models.Snssai{Sst: 1, Sd: "010203"}},
+			{SNssai: &models.Snssai{Sst: 1, Sd: "010204"}},
+			{SNssai: &models.Snssai{Sst: 2, Sd: "010203"}},
+		},
+		EnterpriseList: &enterpriseList,
+	}
+	require.True(t, context.ProcessConfigUpdate())
+	require.Equal(t, []context.SNssai{{Sst: 1, Sd: "010203"}}, snssaisOf(smfSelf.SnssaiInfos))
+	require.Equal(t, []models.Snssai{{Sst: 1, Sd: "010203"}}, registeredSnssais(),
+		"denied and not allowed slices not registered in NRF")
+
+	// updates of the slices filtered out are ignored
+	factory.UpdatedSmfConfig = factory.UpdateSmfConfig{
+		ModSNssaiInfo:  &[]factory.SnssaiInfoItem{{SNssai: &models.Snssai{Sst: 1, Sd: "010204"}}},
+		DelSNssaiInfo:  &[]factory.SnssaiInfoItem{{SNssai: &models.Snssai{Sst: 2, Sd: "010203"}}},
+		EnterpriseList: &enterpriseList,
+	}
+	context.ProcessConfigUpdate()
+	require.Equal(t, []context.SNssai{{Sst: 1, Sd: "010203"}}, snssaisOf(smfSelf.SnssaiInfos))
+	require.Equal(t, validationErrors, gatheredMetric(t, "smf_config_update_errors_total", "validation_error"))
+
+	// no filter, every slice served
+	smfSelf.SnssaiFilter = nil
+	factory.UpdatedSmfConfig = factory.UpdateSmfConfig{
+		AddSNssaiInfo:  &[]factory.SnssaiInfoItem{{SNssai: &models.Snssai{Sst: 2, Sd: "010203"}}},
+		EnterpriseList: &enterpriseList,
+	}
+	context.ProcessConfigUpdate()
+	require.Equal(t, []context.SNssai{{Sst: 1, Sd: "010203"}, {Sst: 2, Sd: "010203"}}, snssaisOf(smfSelf.SnssaiInfos))
+}
+
+func TestProcessConfigUpdateInvalidDNS(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	contexttest.ClearConfig(t)
+	enterpriseList := map[string]string{}
+	validationErrors := gatheredMetric(t, "smf_config_update_errors_total", "validation_error")
+
+	factory.UpdatedSmfConfig = factory.UpdateSmfConfig{
+		AddSNssaiInfo: &[]factory.SnssaiInfoItem{
+			{
+				SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+				DnnInfos: []factory.SnssaiDnnInfoItem{
+					{Dnn: "internet", UESubnet: "10.1.0.0/24", DNS: factory.DNS{IPv4Addr: "8.8.8.8"}},
+					{Dnn: "ims", UESubnet: "10.2.0.0/24", DNS: factory.DNS{IPv4Addr: "8.8.8.888"}},
+				},
+			},
+			{
+				SNssai:   &models.Snssai{Sst: 1, Sd: "010204"},
+				DnnInfos: []factory.SnssaiDnnInfoItem{{Dnn: "internet", UESubnet: "10.3.0.0/24"}},
+			},
+		},
+		EnterpriseList: &enterpriseList,
+	}
+	context.ProcessConfigUpdate()
+
+	// the slice with an invalid DNS server is rejected as a whole
+	require.Equal(t, validationErrors+1, gatheredMetric(t, "smf_config_update_errors_total", "validation_error"))
+	require.Equal(t, []context.SNssai{{Sst: 1, Sd: "010204"}}, snssaisOf(smfSelf.SnssaiInfos))
+	dnnInfo := context.RetrieveDnnInformation(models.Snssai{Sst: 1, Sd: "010204"}, "internet")
+	require.NotNil(t, dnnInfo)
+	require.Nil(t, dnnInfo.DNS.IPv4Addr, "no DNS server configured")
+}
+
+func snssaisOf(snssaiInfos []context.SnssaiSmfInfo) []context.SNssai {
+	snssais := make([]context.SNssai, 0, len(snssaiInfos))
+	for _, snssaiInfo := range snssaiInfos {
+		snssais = append(snssais, snssaiInfo.Snssai)
+	}
+	return snssais
+}
+
+func registeredSnssais() []models.Snssai {
+	snssais := make([]models.Snssai, 0)
+	for _, snssaiInfo := range *context.SNssaiSmfInfo() {
+		snssais = append(snssais, *snssaiInfo.SNssai)
+	}
+	return snssais
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package contexttest provides the SMF context fixtures shared by the tests: UPFs, user planes,
+// network slices and configuration of the SMF set for the duration of a test, and SM contexts of
+// the DNN of a network slice.
+package contexttest
+
+import (
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+)
+
+// DNN served by the UPFs and of the SM contexts of the fixtures
+const Dnn = "internet"
+
+// GNodeB is the name of the gNB of the user planes of the fixtures
+const GNodeB = "GNodeB"
+
+// DefaultQos returns the default QoS of the DNN: 5QI 9, ARP 8 and a session AMBR of 1 Gbps
+func DefaultQos() *factory.DnnDefaultQos {
+	return &factory.DnnDefaultQos{
+		Var5qi:              9,
+		ArpPriorityLevel:    8,
+		SessionAmbrUplink:   "1 Gbps",
+		SessionAmbrDownlink: "1 Gbps",
+	}
+}
+
+// UPF returns the config of a UPF serving the DNN in the network slice, its N3 interface on its
+// node ID
+func UPF(nodeID string, snssai *models.Snssai) factory.UPNode {
+	return factory.UPNode{
+		Type:   "UPF",
+		NodeID: nodeID,
+		SNssaiInfos: []models.SnssaiUpfInfoItem{
+			{SNssai: snssai, DnnUpfInfoList: []models.DnnUpfInfoItem{{Dnn: Dnn}}},
+		},
+		InterfaceUpfInfoList: []factory.InterfaceUpfInfoItem{
+			{InterfaceType: models.UpInterfaceType_N3, Endpoints: []string{nodeID}, NetworkInstance: Dnn},
+		},
+	}
+}
+
+// NewUserPlane returns the user plane of the gNB of the node ID linked to each of the UPFs, by
+// name. The UPFs are associated, and removed at the end of the test.
+func NewUserPlane(t testing.TB, gnbNodeID string, upfs map[string]factory.UPNode) *context.UserPlaneInformation {
+	t.Helper()
+	upNodes := map[string]factory.UPNode{GNodeB: {Type: "AN", NodeID: gnbNodeID}}
+	links := make([]factory.UPLink, 0, len(upfs))
+	for name, upf := range upfs {
+		upNodes[name] = upf
+		links = append(links, factory.UPLink{A: GNodeB, B: name})
+	}
+	upi := context.NewUserPlaneInformation(&factory.UserPlaneInformation{UPNodes: upNodes, Links: links})
+	for _, upNode := range upi.UPFs {
+		upNode.UPF.UPFStatus = context.AssociatedSetUpSuccess
+		t.Cleanup(func() { context.RemoveUPFNodeByNodeID(upNode.NodeID) })
+	}
+	return upi
+}
+
+// SetUserPlane makes the user plane the one of the SMF for the duration of the test
+func SetUserPlane(t testing.TB, upi *context.UserPlaneInformation) {
+	t.Helper()
+	smfSelf := context.SMF_Self()
+	origUserPlaneInformation := smfSelf.UserPlaneInformation
+	t.Cleanup(func() { smfSelf.UserPlaneInformation = origUserPlaneInformation })
+	smfSelf.UserPlaneInformation = upi
+}
+
+// SetSnssaiInfos makes the network slices the ones of the SMF for the duration of the test
+func SetSnssaiInfos(t testing.TB, snssaiInfos []context.SnssaiSmfInfo) {
+	t.Helper()
+	smfSelf := context.SMF_Self()
+	origSnssaiInfos := smfSelf.SnssaiInfos
+	t.Cleanup(func() { smfSelf.SnssaiInfos = origSnssaiInfos })
+	smfSelf.SnssaiInfos = snssaiInfos
+}
+
+// SetConfiguration makes the configuration the one of the SMF for the duration of the test, the
+// config update left pending at its end being dropped
+func SetConfiguration(t testing.TB, configuration *factory.Configuration) {
+	t.Helper()
+	origConfiguration := factory.SmfConfig.Configuration
+	t.Cleanup(func() {
+		factory.SmfConfig.Configuration = origConfiguration
+		factory.UpdatedSmfConfig = factory.UpdateSmfConfig{}
+	})
+	factory.SmfConfig.Configuration = configuration
+}
+
+// ClearConfig clears the config of the SMF for the duration of the test: empty configuration and
+// user plane, no network slices, static IPs or UE IP pools. The enterprises and the slice filter
+// of the SMF, set by the config updates, are restored at its end.
+func ClearConfig(t testing.TB) {
+	t.Helper()
+	smfSelf := context.SMF_Self()
+	SetUserPlane(t, context.NewUserPlaneInformation(&factory.UserPlaneInformation{}))
+	SetSnssaiInfos(t, nil)
+	SetConfiguration(t, &factory.Configuration{})
+	origStaticIpInfo := smfSelf.StaticIpInfo
+	origUeIPPools := smfSelf.UeIPPools
+	origEnterpriseList := smfSelf.EnterpriseList
+	origSnssaiFilter := smfSelf.SnssaiFilter
+	t.Cleanup(func() {
+		smfSelf.StaticIpInfo = origStaticIpInfo
+		smfSelf.UeIPPools = origUeIPPools
+		smfSelf.EnterpriseList = origEnterpriseList
+		smfSelf.SnssaiFilter = origSnssaiFilter
+	})
+	smfSelf.StaticIpInfo = &[]factory.StaticIpInfo{}
+	smfSelf.UeIPPools = nil
+}
+
+// NewUPF returns the associated UPF of the node ID, removed at the end of the test
+func NewUPF(t testing.TB, nodeID string) *context.UPF {
+	t.Helper()
+	upNodeID := context.NewNodeID(nodeID)
+	upf := context.NewUPF(upNodeID, nil)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+	return upf
+}
+
+// NewSMContext returns the SM context of the PDU session of the SUPI, of the DNN in the network
+// slice and with its DNN information
+func NewSMContext(supi string, pduSessionID int32, snssai *models.Snssai, dnnInfo *context.SnssaiSmfDnnInfo) *context.SMContext {
+	smContext := context.NewSMContext(supi, pduSessionID)
+	smContext.Supi = supi
+	smContext.Dnn = Dnn
+	smContext.Snssai = snssai
+	smContext.DNNInfo = dnnInfo
+	return smContext
+}
@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"strings"
+)
+
+// CorrelationIDHeader carries the correlation ID of the session in the SBI requests sent for it
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// sbiConfiguration is the configuration of an openapi client
+type sbiConfiguration interface {
+	AddDefaultHeader(key string, value string)
+}
+
+// CorrelationID identifies the session in the logs and in the requests sent for it over N4, N7,
+// N11 and N40. It is the UUID of the SM context reference, assigned at the establishment.
+func (smContext *SMContext) CorrelationID() string {
+	return strings.TrimPrefix(smContext.Ref, "urn:uuid:")
+}
+
+// SetCorrelationHeader adds the correlation ID of the session to all the requests of the client
+func (smContext *SMContext) SetCorrelationHeader(cfg sbiConfiguration) {
+	cfg.AddDefaultHeader(CorrelationIDHeader, smContext.CorrelationID())
+}
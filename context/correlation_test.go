@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/omec-project/openapi/Namf_Communication"
+	"github.com/omec-project/openapi/Npcf_SMPolicyControl"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestCorrelationIDAcrossSBIRequests(t *testing.T) {
+	var (
+		lock           sync.Mutex
+		correlationIDs = make(map[string]string)
+	)
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		correlationIDs[r.URL.Path] = r.Header.Get(context.CorrelationIDHeader)
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/sm-policies") {
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(models.SmPolicyDecision{})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(models.N1N2MessageTransferRspData{
+			Cause: models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED,
+		})
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+
+	smContext := context.NewSMContext("imsi-208930000000301", 1)
+	smContext.Supi = "imsi-208930000000301"
+	require.NotEmpty(t, smContext.CorrelationID())
+	require.Contains(t, smContext.Ref, smContext.CorrelationID())
+
+	// N7
+	smPolicyConf := Npcf_SMPolicyControl.NewConfiguration()
+	smPolicyConf.SetBasePath(server.URL)
+	smContext.SetCorrelationHeader(smPolicyConf)
+	_, _, err := Npcf_SMPolicyControl.NewAPIClient(smPolicyConf).DefaultApi.
+		SmPoliciesPost(t.Context(), models.SmPolicyContextData{Supi: smContext.Supi})
+	require.NoError(t, err)
+
+	// N11, over the AMF selected at the establishment and over the AMF set
+	communicationConf := Namf_Communication.NewConfiguration()
+	communicationConf.SetBasePath(server.URL)
+	smContext.SetCorrelationHeader(communicationConf)
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+	_, err = smContext.N1N2MessageTransfer(t.Context(), models.N1N2MessageTransferRequest{
+		JsonData: &models.N1N2MessageTransferReqData{PduSessionId: 1},
+	})
+	require.NoError(t, err)
+
+	other := context.NewSMContext("imsi-208930000000302", 1)
+	other.Supi = "imsi-208930000000302"
+	other.AMFClient = context.NewAMFLoadBalancedSBIClient([]models.NfProfile{
+		amfProfile("amf-1", server.URL),
+	})
+	_, err = other.N1N2MessageTransfer(t.Context(), models.N1N2MessageTransferRequest{
+		JsonData: &models.N1N2MessageTransferReqData{PduSessionId: 1},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, correlationIDs, 3)
+	for path, correlationID := range correlationIDs {
+		if strings.Contains(path, other.Supi) {
+			require.Equal(t, other.CorrelationID(), correlationID, path)
+		} else {
+			require.Equal(t, smContext.CorrelationID(), correlationID, path)
+		}
+	}
+	require.NotEqual(t, smContext.CorrelationID(), other.CorrelationID())
+}
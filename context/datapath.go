@@ -7,13 +7,14 @@
 package context
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/logger"
 	"github.com/omec-project/smf/qos"
+	"github.com/omec-project/smf/smferrors"
 	"github.com/omec-project/smf/util"
 	"github.com/omec-project/util/util_3gpp"
 )
@@ -112,43 +113,16 @@ func (node *DataPathNode) Prev() *DataPathNode {
 }
 
 func (node *DataPathNode) ActivateUpLinkTunnel(smContext *SMContext) error {
-	var err error
-	var pdr *PDR
-	var flowQer *QER
 	logger.CtxLog.Debugln("in ActivateUpLinkTunnel")
 	node.UpLinkTunnel.SrcEndPoint = node.Prev()
 	node.UpLinkTunnel.DestEndPoint = node
 
-	destUPF := node.UPF
-
-	// Iterate through PCC Rules to install PDRs
-	pccRuleUpdate := smContext.SmPolicyUpdates[0].PccRuleUpdate
-
-	if pccRuleUpdate != nil {
-		addRules := pccRuleUpdate.GetAddPccRuleUpdate()
-
-		for name, rule := range addRules {
-			if pdr, err = destUPF.BuildCreatePdrFromPccRule(rule); err == nil {
-				// Add PCC Rule Qos Data QER
-				if flowQer, err = node.CreatePccRuleQer(smContext, rule.RefQosData[0], rule.RefTcData[0]); err == nil {
-					pdr.QER = append(pdr.QER, flowQer)
-				}
-				// Set PDR in Tunnel
-				node.UpLinkTunnel.PDR[name] = pdr
-			}
-		}
-	} else {
-		// Default PDR
-		if pdr, err = destUPF.AddPDR(); err != nil {
-			logger.CtxLog.Errorln("in ActivateUpLinkTunnel UPF IP:", node.UPF.NodeID.ResolveNodeIdToIp().String())
-			logger.CtxLog.Errorln("allocate PDR error:", err)
-			return fmt.Errorf("add PDR failed: %s", err)
-		} else {
-			node.UpLinkTunnel.PDR["default"] = pdr
-		}
+	if err := node.addTunnelPDRs(smContext, node.UpLinkTunnel); err != nil {
+		logger.CtxLog.Errorln("in ActivateUpLinkTunnel UPF IP:", node.UPF.NodeID.ResolveNodeIdToIp().String())
+		return err
 	}
 
-	if err = smContext.PutPDRtoPFCPSession(destUPF.NodeID, node.UpLinkTunnel.PDR); err != nil {
+	if err := smContext.PutPDRtoPFCPSession(node.UPF.NodeID, node.UpLinkTunnel.PDR); err != nil {
 		logger.CtxLog.Errorln("put PDR Error:", err)
 		return err
 	}
@@ -157,42 +131,75 @@ func (node *DataPathNode) ActivateUpLinkTunnel(smContext *SMContext) error {
 }
 
 func (node *DataPathNode) ActivateDownLinkTunnel(smContext *SMContext) error {
-	var err error
-	var pdr *PDR
-	var flowQer *QER
 	node.DownLinkTunnel.SrcEndPoint = node.Next()
 	node.DownLinkTunnel.DestEndPoint = node
 
+	if err := node.addTunnelPDRs(smContext, node.DownLinkTunnel); err != nil {
+		logger.CtxLog.Errorln("in ActivateDownLinkTunnel UPF IP:", node.UPF.NodeID.ResolveNodeIdToIp().String())
+		return err
+	}
+
+	// Put PDRs in PFCP session
+	if err := smContext.PutPDRtoPFCPSession(node.UPF.NodeID, node.DownLinkTunnel.PDR); err != nil {
+		logger.CtxLog.Errorln("put PDR error:", err)
+		return err
+	}
+
+	return nil
+}
+
+// addTunnelPDRs installs in the tunnel a PDR per PCC rule of the session. The PDR of the default
+// PCC rule provided by the PCF is the default PDR of the tunnel, else a match-all default PDR is
+// added, gated on the AN UPF by a QER of its own for PS data off. The default PDR gets the
+// catch-all precedence when the PDRs are activated.
+func (node *DataPathNode) addTunnelPDRs(smContext *SMContext, tunnel *GTPTunnel) error {
 	destUPF := node.UPF
+
 	// Iterate through PCC Rules to install PDRs
-	pccRuleUpdate := smContext.SmPolicyUpdates[0].PccRuleUpdate
-	if pccRuleUpdate != nil {
+	if pccRuleUpdate := smContext.SmPolicyUpdates[0].PccRuleUpdate; pccRuleUpdate != nil {
 		addRules := pccRuleUpdate.GetAddPccRuleUpdate()
+		defaultRuleName := qos.GetDefaultPccRuleName(smContext.SmPolicyUpdates[0].SmPolicyDecision, addRules)
+
 		for name, rule := range addRules {
-			if pdr, err = destUPF.BuildCreatePdrFromPccRule(rule); err == nil {
-				// Add PCC Rule Qos Data QER
-				if flowQer, err = node.CreatePccRuleQer(smContext, rule.RefQosData[0], rule.RefTcData[0]); err == nil {
-					pdr.QER = append(pdr.QER, flowQer)
+			pdr, err := destUPF.BuildCreatePdrFromPccRule(rule)
+			if err != nil {
+				continue
+			}
+			// Add PCC Rule Qos Data QER
+			if flowQer, err := node.CreatePccRuleQer(smContext, rule.RefQosData[0], rule.RefTcData[0]); err == nil {
+				flowQer.PccRuleID = rule.PccRuleId
+				// the flows blocked by PS data off are gated by the AN UPF only
+				if node.IsANUPF() && smContext.psDataOffBlocks(rule) {
+					flowQer.GateStatus = &GateStatus{ULGate: GateClose, DLGate: GateClose}
 				}
-				// Set PDR in Tunnel
-				node.DownLinkTunnel.PDR[name] = pdr
+				pdr.QER = append(pdr.QER, flowQer)
+			}
+			pdr.TrafficControlID = rule.RefTcData[0]
+			if name == defaultRuleName {
+				logger.CtxLog.Debugf("pcc rule [%s] provided by PCF used as default PDR", name)
+				name = "default"
+				pdr.Precedence = 0
 			}
+			// Set PDR in Tunnel
+			tunnel.PDR[name] = pdr
 		}
-	} else {
+	}
+
+	if _, exist := tunnel.PDR["default"]; !exist {
 		// Default PDR
-		if pdr, err = destUPF.AddPDR(); err != nil {
-			logger.CtxLog.Errorln("in ActivateDownLinkTunnel UPF IP:", node.UPF.NodeID.ResolveNodeIdToIp().String())
-			logger.CtxLog.Errorln("allocate PDR Error:", err)
+		pdr, err := destUPF.AddPDR()
+		if err != nil {
+			logger.CtxLog.Errorln("allocate PDR error:", err)
 			return fmt.Errorf("add PDR failed: %s", err)
-		} else {
-			node.DownLinkTunnel.PDR["default"] = pdr
 		}
-	}
-
-	// Put PDRs in PFCP session
-	if err = smContext.PutPDRtoPFCPSession(destUPF.NodeID, node.DownLinkTunnel.PDR); err != nil {
-		logger.CtxLog.Errorln("put PDR error:", err)
-		return err
+		if node.IsANUPF() {
+			flowQer, err := node.CreateDefaultFlowQer(smContext)
+			if err != nil {
+				return fmt.Errorf("add QER failed: %s", err)
+			}
+			pdr.QER = append(pdr.QER, flowQer)
+		}
+		tunnel.PDR["default"] = pdr
 	}
 
 	return nil
@@ -269,6 +276,11 @@ func (node *DataPathNode) DeactivateDownLinkTunnel(smContext *SMContext) {
 					}
 				}
 			}
+			if mar := pdr.MAR; mar != nil {
+				if err = node.UPF.RemoveMAR(mar); err != nil {
+					logger.CtxLog.Warnln("deactivated DownLinkTunnel", err)
+				}
+			}
 			if qerList := pdr.QER; qerList != nil {
 				for _, qer := range qerList {
 					if qer != nil {
@@ -373,7 +385,7 @@ func (dataPath *DataPath) validateDataPathUpfStatus() error {
 		if curDataPathNode.UPF.UPFStatus != AssociatedSetUpSuccess {
 			logger.PduSessLog.Errorf("UPF [%v] in DataPath not associated",
 				curDataPathNode.UPF.NodeID.ResolveNodeIdToIp().String())
-			return errors.New("UPF not associated in DataPath")
+			return smferrors.New(smferrors.ErrCodeUPFNotAssociated, "UPF not associated in DataPath")
 		}
 	}
 	return nil
@@ -435,6 +447,35 @@ func (dpNode *DataPathNode) CreatePccRuleQer(smContext *SMContext, qosData strin
 	return flowQER, nil
 }
 
+// CreateDefaultFlowQer creates the QER gating the flows of the match-all default PDR, closed while
+// the PS data off of the UE is activated
+func (dpNode *DataPathNode) CreateDefaultFlowQer(smContext *SMContext) (*QER, error) {
+	gateStatus := GateOpen
+	if smContext.PsDataOff {
+		gateStatus = GateClose
+	}
+
+	newQER, err := dpNode.UPF.AddQER()
+	if err != nil {
+		logger.PduSessLog.Errorln("new QER failed")
+		return nil, err
+	}
+	if smPolicyDec := smContext.SmPolicyUpdates[0].SmPolicyDecision; smPolicyDec != nil {
+		for _, qosData := range smPolicyDec.QosDecs {
+			if qosData != nil && qosData.DefQosFlowIndication {
+				newQER.QFI.QFI = qos.GetQosFlowIdFromQosId(qosData.QosId)
+				break
+			}
+		}
+	}
+	newQER.GateStatus = &GateStatus{
+		ULGate: gateStatus,
+		DLGate: gateStatus,
+	}
+	newQER.DefaultFlow = true
+	return newQER, nil
+}
+
 func (dpNode *DataPathNode) CreateSessRuleQer(smContext *SMContext) (*QER, error) {
 	var flowQER *QER
 
@@ -475,6 +516,11 @@ func (dpNode *DataPathNode) ActivateUpLinkPdr(smContext *SMContext, defQER *QER,
 	}
 
 	curULTunnel := dpNode.UpLinkTunnel
+	localFTEID, err := dpNode.uplinkLocalFTEID(smContext)
+	if err != nil {
+		logger.CtxLog.Errorf("activate UpLink PDRs failed %v", err)
+		return err
+	}
 	for name, ULPDR := range curULTunnel.PDR {
 		ULPDR.QER = append(ULPDR.QER, defQER)
 
@@ -484,11 +530,15 @@ func (dpNode *DataPathNode) ActivateUpLinkPdr(smContext *SMContext, defQER *QER,
 		}
 
 		ULPDR.PDI.SourceInterface = SourceInterface{InterfaceValue: SourceInterfaceAccess}
-		ULPDR.PDI.LocalFTeid = &FTEID{
-			Ch: true,
+		fteid := *localFTEID
+		ULPDR.PDI.LocalFTeid = &fteid
+		if !smContext.IsEthernetSession() {
+			ULPDR.PDI.UEIPAddress = &ueIpAddr
 		}
-		ULPDR.PDI.UEIPAddress = &ueIpAddr
 		ULPDR.PDI.NetworkInstance = util_3gpp.Dnn(smContext.Dnn)
+		if dpNode.IsANUPF() {
+			ULPDR.PDI.TGPPInterfaceType = smContext.AccessInterfaceType()
+		}
 		ULPDR.OuterHeaderRemoval = &OuterHeaderRemoval{
 			OuterHeaderRemovalDescription: OuterHeaderRemovalGtpUUdpIpv4,
 		}
@@ -511,6 +561,26 @@ func (dpNode *DataPathNode) ActivateUpLinkPdr(smContext *SMContext, defQER *QER,
 		if dpNode.IsAnchorUPF() {
 			ULFAR.ForwardingParameters.
 				DestinationInterface.InterfaceValue = DestinationInterfaceSgiLanN6Lan
+
+			if headers := smContext.HeaderEnrichment(); len(headers) > 0 {
+				if dpNode.UPF.IsUpfSupportHeaderEnrichment() {
+					ULFAR.ForwardingParameters.HeaderEnrichment = headers
+				} else {
+					logger.CtxLog.Warnf("UPF[%s] does not support header enrichment, UpLink PDR[%v] forwarded without it",
+						dpNode.UPF.NodeID.ResolveNodeIdToIp(), name)
+				}
+			}
+
+			if segments := smContext.SRv6SegmentList(); len(segments) > 0 {
+				if dpNode.UPF.IsUpfSupportSRv6Steering() {
+					ULFAR.ForwardingParameters.SRv6SegmentList = segments
+				} else {
+					logger.CtxLog.Warnf("UPF[%s] does not support SRv6 steering, UpLink PDR[%v] forwarded without it",
+						dpNode.UPF.NodeID.ResolveNodeIdToIp(), name)
+				}
+			}
+
+			ULFAR.ForwardingParameters.RedirectInformation = smContext.redirectInformation(ULPDR.TrafficControlID)
 		}
 
 		if nextULDest := dpNode.Next(); nextULDest != nil {
@@ -546,6 +616,17 @@ func (dpNode *DataPathNode) ActivateDlLinkPdr(smContext *SMContext, defQER *QER,
 		ueIpAddr.Ipv4Address = smContext.PDUAddress.Ip.To4()
 	}
 
+	// subnets behind the UE, routed by the anchor UPF
+	var framedRoutes []string
+	if dpNode.IsAnchorUPF() {
+		framedRoutes = smContext.FramedRoutes()
+		if len(framedRoutes) > 0 && !dpNode.UPF.IsUpfSupportFramedRouting() {
+			logger.CtxLog.Warnf("UPF [%s] does not support framed routing, routes %v of [%s] not installed",
+				dpNode.UPF.NodeID.String(), framedRoutes, smContext.Supi)
+			framedRoutes = nil
+		}
+	}
+
 	for name, DLPDR := range curDLTunnel.PDR {
 		logger.CtxLog.Infof("activate Downlink PDR[%v]:[%v]", name, DLPDR)
 		DLPDR.QER = append(DLPDR.QER, defQER)
@@ -561,7 +642,12 @@ func (dpNode *DataPathNode) ActivateDlLinkPdr(smContext *SMContext, defQER *QER,
 		}
 
 		DLPDR.PDI.SourceInterface = SourceInterface{InterfaceValue: SourceInterfaceCore}
-		DLPDR.PDI.UEIPAddress = &ueIpAddr
+		if smContext.IsEthernetSession() {
+			DLPDR.PDI.EthernetPDUSessionInformation = true
+		} else {
+			DLPDR.PDI.UEIPAddress = &ueIpAddr
+		}
+		DLPDR.PDI.FramedRoutes = framedRoutes
 
 		DLFAR := DLPDR.FAR
 
@@ -608,8 +694,10 @@ func (dpNode *DataPathNode) ActivateDlLinkPdr(smContext *SMContext, defQER *QER,
 				dlOuterHeaderCreation.OuterHeaderCreationDescription = OuterHeaderCreationGtpUUdpIpv4
 				dlOuterHeaderCreation.Teid = smContext.Tunnel.ANInformation.TEID
 				dlOuterHeaderCreation.Ipv4Address = smContext.Tunnel.ANInformation.IPAddress.To4()
+				DLFAR.ForwardingParameters.TGPPInterfaceType = smContext.AccessInterfaceType()
 			}
 		}
+		dpNode.activateMAR(smContext, name, DLPDR)
 		logger.CtxLog.Infof("activate Downlink PDR[%v]:[%v]", name, DLPDR)
 	}
 	return nil
@@ -656,6 +744,13 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 			}
 		}
 
+		// periodic usage reports of the session traffic by the anchor UPF
+		if curDataPathNode.IsAnchorUPF() && SMF_Self().UsageReportingPeriod > 0 {
+			if err := curDataPathNode.ActivateUsageReportingURR(SMF_Self().UsageReportingPeriod); err != nil {
+				logger.CtxLog.Errorf("activate usage reporting URR error %v", err.Error())
+			}
+		}
+
 		ueIpAddr := UEIPAddress{}
 		if curDataPathNode.UPF.IsUpfSupportUeIpAddrAlloc() {
 			ueIpAddr.CHV4 = true
@@ -669,7 +764,11 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 				for _, DNDLPDR := range curDataPathNode.DownLinkTunnel.PDR {
 					DNDLPDR.PDI.SourceInterface = SourceInterface{InterfaceValue: SourceInterfaceCore}
 					DNDLPDR.PDI.NetworkInstance = util_3gpp.Dnn(smContext.Dnn)
-					DNDLPDR.PDI.UEIPAddress = &ueIpAddr
+					if smContext.IsEthernetSession() {
+						DNDLPDR.PDI.EthernetPDUSessionInformation = true
+					} else {
+						DNDLPDR.PDI.UEIPAddress = &ueIpAddr
+					}
 				}
 			}
 		}
@@ -679,12 +778,53 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 	return nil
 }
 
+// ActivateUsageReportingURR measures the volume and the duration of the traffic of all the PDRs
+// of the node with a URR reported every period
+func (node *DataPathNode) ActivateUsageReportingURR(period time.Duration) error {
+	urr, err := node.UPF.AddURR()
+	if err != nil {
+		return err
+	}
+	urr.MeasurementMethod = MeasurementMethod{Volum: true, Durat: true}
+	urr.MeasurementPeriod = period
+	for _, tunnel := range []*GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+		if tunnel == nil {
+			continue
+		}
+		for _, pdr := range tunnel.PDR {
+			pdr.URR = urr
+		}
+	}
+	return nil
+}
+
+// releaseURRs frees the IDs of the URRs of the node, shared by its PDRs
+func (node *DataPathNode) releaseURRs() {
+	released := make(map[*URR]bool)
+	for _, tunnel := range []*GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+		if tunnel == nil {
+			continue
+		}
+		for _, pdr := range tunnel.PDR {
+			if pdr == nil || pdr.URR == nil || released[pdr.URR] {
+				continue
+			}
+			if err := node.UPF.RemoveURR(pdr.URR); err != nil {
+				logger.CtxLog.Warnln("release URR", err)
+			}
+			released[pdr.URR] = true
+		}
+	}
+}
+
 func (dataPath *DataPath) DeactivateTunnelAndPDR(smContext *SMContext) {
 	firstDPNode := dataPath.FirstDPNode
 
 	// Deactivate Tunnels
 	for curDataPathNode := firstDPNode; curDataPathNode != nil; curDataPathNode = curDataPathNode.Next() {
+		curDataPathNode.releaseURRs()
 		curDataPathNode.DeactivateUpLinkTunnel(smContext)
+		curDataPathNode.releaseUplinkTEID()
 		curDataPathNode.DeactivateDownLinkTunnel(smContext)
 	}
 
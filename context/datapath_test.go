@@ -8,7 +8,10 @@ import (
 	"net"
 	"testing"
 
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/qos"
 )
 
 func TestActivateUpLinkPdr(t *testing.T) {
@@ -66,6 +69,48 @@ func TestActivateUpLinkPdr(t *testing.T) {
 	}
 }
 
+func TestActivateUpLinkPdrNon3GPPAccess(t *testing.T) {
+	testCases := []struct {
+		name     string
+		anType   models.AccessType
+		ratType  models.RatType
+		expected *uint8
+	}{
+		{"3GPP access", models.AccessType__3_GPP_ACCESS, models.RatType_NR, nil},
+		{"untrusted non-3GPP access", models.AccessType_NON_3_GPP_ACCESS, models.RatType_WLAN, &[]uint8{context.TGPPInterfaceTypeN3UntrustedNon3GPPAccess}[0]},
+		{"trusted non-3GPP access", models.AccessType_NON_3_GPP_ACCESS, context.RatTypeTrustedN3GA, &[]uint8{context.TGPPInterfaceTypeN3TrustedNon3GPPAccess}[0]},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := &context.SMContext{
+				PDUAddress: &context.UeIpAddr{Ip: net.IPv4(192, 168, 1, 1)},
+				Dnn:        "internet",
+				AnType:     tc.anType,
+				RatType:    tc.ratType,
+			}
+			dpNode := &context.DataPathNode{
+				UPF: &context.UPF{},
+				UpLinkTunnel: &context.GTPTunnel{
+					PDR: map[string]*context.PDR{"default": {FAR: &context.FAR{}}},
+				},
+			}
+			if err := dpNode.ActivateUpLinkPdr(smContext, &context.QER{}, 10); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			interfaceType := dpNode.UpLinkTunnel.PDR["default"].PDI.TGPPInterfaceType
+			if tc.expected == nil {
+				if interfaceType != nil {
+					t.Errorf("expected no 3GPP interface type, got %d", *interfaceType)
+				}
+				return
+			}
+			if interfaceType == nil || *interfaceType != *tc.expected {
+				t.Errorf("expected 3GPP interface type %d, got %v", *tc.expected, interfaceType)
+			}
+		})
+	}
+}
+
 func TestActivateDlLinkPdr(t *testing.T) {
 	smContext := &context.SMContext{
 		PDUAddress: &context.UeIpAddr{
@@ -124,3 +169,152 @@ func TestActivateDlLinkPdr(t *testing.T) {
 		t.Errorf("expected pdr.PDI.UEIPAddress.Ipv4Address to be %v, got %v", net.IP{192, 168, 1, 1}, pdr.PDI.UEIPAddress.Ipv4Address)
 	}
 }
+
+func TestActivateDlLinkPdrEthernet(t *testing.T) {
+	smContext := &context.SMContext{
+		PDUAddress:             &context.UeIpAddr{},
+		SelectedPDUSessionType: nasMessage.PDUSessionTypeEthernet,
+		Dnn:                    "lan",
+		Tunnel: &context.UPTunnel{
+			ANInformation: struct {
+				IPAddress net.IP
+				TEID      uint32
+			}{
+				IPAddress: net.IP{10, 0, 0, 1},
+				TEID:      12345,
+			},
+		},
+	}
+
+	dpNode := &context.DataPathNode{
+		UPF: &context.UPF{},
+		DownLinkTunnel: &context.GTPTunnel{
+			PDR: map[string]*context.PDR{
+				"default": {FAR: &context.FAR{}},
+			},
+		},
+	}
+
+	err := dpNode.ActivateDlLinkPdr(smContext, &context.QER{}, 10, &context.DataPath{FirstDPNode: dpNode})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	pdr := dpNode.DownLinkTunnel.PDR["default"]
+	if pdr.PDI.UEIPAddress != nil {
+		t.Errorf("expected no UE IP address in the PDI of an Ethernet session, got %+v", pdr.PDI.UEIPAddress)
+	}
+	if !pdr.PDI.EthernetPDUSessionInformation {
+		t.Errorf("expected pdr.PDI.EthernetPDUSessionInformation to be true")
+	}
+}
+
+func newDefaultPdrTestNode(t *testing.T, smPolicyDecision *models.SmPolicyDecision) (*context.SMContext, *context.DataPathNode) {
+	t.Helper()
+	upNodeID := context.NewNodeID("10.0.0.5")
+	upf := context.NewUPF(upNodeID, nil)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+
+	smPolicyData := qos.SmCtxtPolicyData{}
+	smPolicyData.Initialize()
+	smContext := &context.SMContext{
+		PFCPContext: map[string]*context.PFCPSessionContext{
+			"10.0.0.5": {PDRs: make(map[uint16]*context.PDR)},
+		},
+		SmPolicyUpdates: []*qos.PolicyUpdate{qos.BuildSmPolicyUpdate(&smPolicyData, smPolicyDecision)},
+	}
+	dpNode := context.NewDataPathNode()
+	dpNode.UPF = upf
+	return smContext, dpNode
+}
+
+func TestActivateUpLinkTunnelPcfDefaultPdr(t *testing.T) {
+	smContext, dpNode := newDefaultPdrTestNode(t, &models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"web": {
+				PccRuleId:  "1",
+				Precedence: 10,
+				RefQosData: []string{"web-qos"},
+				RefTcData:  []string{"tc"},
+				FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from 10.1.1.0/24 to assigned", PackFiltId: "1"}},
+			},
+			"pcf-default": {
+				PccRuleId:  "2",
+				Precedence: 250,
+				RefQosData: []string{"default-qos"},
+				RefTcData:  []string{"tc"},
+				FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from any to assigned", PackFiltId: "2"}},
+			},
+		},
+		QosDecs: map[string]*models.QosData{
+			"web-qos":     {QosId: "1", MaxbrUl: "10 Mbps", MaxbrDl: "10 Mbps"},
+			"default-qos": {QosId: "5", MaxbrUl: "100 Mbps", MaxbrDl: "100 Mbps", DefQosFlowIndication: true},
+		},
+		TraffContDecs: map[string]*models.TrafficControlData{"tc": {TcId: "tc", FlowStatus: models.FlowStatus_ENABLED}},
+	})
+
+	if err := dpNode.ActivateUpLinkTunnel(smContext); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tunnelPDRs := dpNode.UpLinkTunnel.PDR
+	if len(tunnelPDRs) != 2 {
+		t.Fatalf("expected 2 PDRs, got %d", len(tunnelPDRs))
+	}
+	if _, ok := tunnelPDRs["pcf-default"]; ok {
+		t.Errorf("expected PCF default rule to be installed as the default PDR")
+	}
+	pdr := tunnelPDRs["default"]
+	if pdr == nil {
+		t.Fatalf("expected default PDR to be not nil")
+	}
+	if pdr.PDI.SDFFilter == nil || string(pdr.PDI.SDFFilter.FlowDescription) != "permit out ip from any to assigned" {
+		t.Errorf("expected default PDR to carry the PCF flow description, got %+v", pdr.PDI.SDFFilter)
+	}
+	if len(pdr.QER) != 1 || pdr.QER[0].QFI.QFI != 5 {
+		t.Errorf("expected default PDR QER with QFI 5, got %+v", pdr.QER)
+	}
+
+	// catch-all precedence is given on activation, after the PCC rule PDRs
+	if err := dpNode.ActivateUpLinkPdr(&context.SMContext{PDUAddress: &context.UeIpAddr{Ip: net.IPv4(10, 60, 0, 1)}}, &context.QER{}, 255); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if pdr.Precedence != 255 {
+		t.Errorf("expected default PDR precedence 255, got %d", pdr.Precedence)
+	}
+	if tunnelPDRs["web"].Precedence != 10 {
+		t.Errorf("expected PCC rule PDR precedence 10, got %d", tunnelPDRs["web"].Precedence)
+	}
+}
+
+func TestActivateDownLinkTunnelHardcodedDefaultPdr(t *testing.T) {
+	smContext, dpNode := newDefaultPdrTestNode(t, &models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"web": {
+				PccRuleId:  "1",
+				Precedence: 10,
+				RefQosData: []string{"web-qos"},
+				RefTcData:  []string{"tc"},
+				FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from 10.1.1.0/24 to assigned", PackFiltId: "1"}},
+			},
+		},
+		QosDecs:       map[string]*models.QosData{"web-qos": {QosId: "1", MaxbrUl: "10 Mbps", MaxbrDl: "10 Mbps"}},
+		TraffContDecs: map[string]*models.TrafficControlData{"tc": {TcId: "tc", FlowStatus: models.FlowStatus_ENABLED}},
+	})
+
+	if err := dpNode.ActivateDownLinkTunnel(smContext); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	pdr := dpNode.DownLinkTunnel.PDR["default"]
+	if pdr == nil {
+		t.Fatalf("expected hardcoded default PDR when PCF provides no default rule")
+	}
+	if pdr.PDI.SDFFilter != nil {
+		t.Errorf("expected hardcoded default PDR to match all traffic, got %+v", pdr.PDI.SDFFilter)
+	}
+	if dpNode.DownLinkTunnel.PDR["web"] == nil {
+		t.Errorf("expected PCC rule PDR to be installed")
+	}
+}
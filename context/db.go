@@ -8,6 +8,7 @@
 package context
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -70,6 +71,15 @@ func SetupSmfCollection() {
 	}
 }
 
+// PingSessionStore returns an error if the database storing the sessions is not reachable
+var PingSessionStore = func(ctx context.Context) error {
+	client, ok := mongoapi.CommonDBClient.(*mongoapi.MongoClient)
+	if !ok || client == nil || client.Client == nil {
+		return fmt.Errorf("session store not connected")
+	}
+	return client.Client.Ping(ctx, nil)
+}
+
 // print out sm context
 func (smContext *SMContext) String() string {
 	return fmt.Sprintf("smContext content: Ref:[%v],\nSupi: [%v],\nPei:[%v],\nGpsi:[%v],\nPDUSessionID:[%v],\nDnn:[%v],Snssai: [%v],\nHplmnSnssai: [%v],\nServingNetwork: [%v],\nServingNfId: [%v],\nUpCnxState: [%v],\nAnType: [%v],\nRatType: [%v],\nPDUAddress: [%v],\nSelectedPDUSessionType: [%v],\nSmStatusNotifyUri: [%v],\nSelectedPCFProfile: [%v],\nSMContextState: [%v],\nTunnel: [%v],\nPFCPContext: [%v],\nIdentifier: [%v],\nDNNInfo: [%v],\nSmPolicyData: [%v],\nEstAcceptCause5gSMValue: [%v]\n", smContext.Ref, smContext.Supi, smContext.Pei, smContext.Gpsi, smContext.PDUSessionID, smContext.Dnn, smContext.Snssai, smContext.HplmnSnssai, smContext.ServingNetwork, smContext.ServingNfId, smContext.UpCnxState, smContext.AnType, smContext.RatType, smContext.PDUAddress, smContext.SelectedPDUSessionType, smContext.SmStatusNotifyUri, smContext.SelectedPCFProfile, smContext.SMContextState, smContext.Tunnel, smContext.PFCPContext, smContext.Identifier, smContext.DNNInfo, smContext.SmPolicyData, smContext.EstAcceptCause5gSMValue)
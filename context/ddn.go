@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"net"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/logger"
+)
+
+// number of downlink packets the UPF is suggested to buffer for a session with the UE in idle
+const DefaultSuggestedBufferingPacketsCount uint8 = 10
+
+// AllowDownlinkDataNotification returns true for a Downlink Data Notification of the session
+// triggering the paging of the UE, false for a duplicate received within the throttling window
+// of the previous one. To be called with SMLock held.
+func (smContext *SMContext) AllowDownlinkDataNotification(now time.Time) bool {
+	if !smContext.lastDDNTime.IsZero() && now.Sub(smContext.lastDDNTime) < SMF_Self().DDNThrottlingWindow {
+		return false
+	}
+	smContext.lastDDNTime = now
+	return true
+}
+
+// ResetDDNThrottling lets the next Downlink Data Notification of the session page the UE again,
+// once its user plane connection is activated
+func (smContext *SMContext) ResetDDNThrottling() {
+	smContext.lastDDNTime = time.Time{}
+}
+
+// PagingPolicyIndicator returns the paging policy indicator of the downlink data of the DSCP
+// reported by the UPF, false if the DNN of the session does not map the DSCP
+func (smContext *SMContext) PagingPolicyIndicator(dscp uint8) (uint8, bool) {
+	if smContext.DNNInfo == nil {
+		return 0, false
+	}
+	ppi, ok := smContext.DNNInfo.PagingPolicyIndicators[dscp]
+	return ppi, ok
+}
+
+// SetBuffering instructs the UPF to buffer the downlink packets of the FAR and to notify the SMF
+// of their arrival, the BAR of the FAR being created on the UPF on first use
+func (far *FAR) SetBuffering(upf *UPF) {
+	far.ApplyAction = ApplyAction{Buff: true, Nocp: true}
+	if far.ForwardingParameters != nil {
+		far.ForwardingParameters.OuterHeaderCreation = nil
+	}
+	if far.BAR != nil {
+		return
+	}
+	bar, err := upf.AddBAR()
+	if err != nil {
+		logger.CtxLog.Warnf("BAR of FAR[%d] not allocated, UPF default buffering used: %v", far.FARID, err)
+		return
+	}
+	bar.SuggestedBufferingPacketsCount.PacketCountValue = DefaultSuggestedBufferingPacketsCount
+	bar.State = RULE_INITIAL
+	far.BAR = bar
+}
+
+// IsANTunnel reports whether the F-TEID is the AN tunnel of the session
+func (smContext *SMContext) IsANTunnel(teid uint32, ip net.IP) bool {
+	return smContext.Tunnel != nil && smContext.Tunnel.ANInformation.IPAddress != nil &&
+		smContext.Tunnel.ANInformation.TEID == teid && smContext.Tunnel.ANInformation.IPAddress.Equal(ip)
+}
+
+// DeactivateUPConnection deactivates the user plane connection of the session whose AN tunnel
+// is lost, the AN UPF of the node ID buffering the downlink packets and notifying their arrival
+// to page the UE. The AN tunnel info is removed, the AN setting up a new tunnel on the next
+// activation. Returns the FARs to update on the UPF. To be called with SMLock held.
+func (smContext *SMContext) DeactivateUPConnection(upfNodeID NodeID) []*FAR {
+	farList := []*FAR{}
+	upfIP := upfNodeID.ResolveNodeIdToIp()
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		ANUPF := dataPath.FirstDPNode
+		if !dataPath.Activated || ANUPF == nil || ANUPF.DownLinkTunnel == nil ||
+			!ANUPF.UPF.NodeID.ResolveNodeIdToIp().Equal(upfIP) {
+			continue
+		}
+		for _, DLPDR := range ANUPF.DownLinkTunnel.PDR {
+			if DLPDR == nil || DLPDR.FAR == nil || DLPDR.FAR.ApplyAction.Buff {
+				continue
+			}
+			DLPDR.FAR.State = RULE_UPDATE
+			DLPDR.FAR.SetBuffering(ANUPF.UPF)
+			farList = append(farList, DLPDR.FAR)
+		}
+	}
+	smContext.ReleaseANTunnel()
+	smContext.Tunnel.ANInformation.IPAddress = nil
+	smContext.Tunnel.ANInformation.TEID = 0
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	smContext.ResetDDNThrottling()
+	return farList
+}
+
+// ResumeDownlinkForwarding forwards again to the AN tunnel of the session the downlink packets
+// buffered by the AN UPF of the node ID, the BARs of the FARs being removed. Returns the PDRs and
+// FARs to update on the UPF, none if no downlink packets are buffered. To be called with SMLock
+// held.
+func (smContext *SMContext) ResumeDownlinkForwarding(upfNodeID NodeID) ([]*PDR, []*FAR) {
+	pdrList := []*PDR{}
+	farList := []*FAR{}
+	upfIP := upfNodeID.ResolveNodeIdToIp()
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		ANUPF := dataPath.FirstDPNode
+		if !dataPath.Activated || ANUPF == nil || ANUPF.DownLinkTunnel == nil ||
+			!ANUPF.UPF.NodeID.ResolveNodeIdToIp().Equal(upfIP) {
+			continue
+		}
+		for _, DLPDR := range ANUPF.DownLinkTunnel.PDR {
+			if DLPDR == nil || DLPDR.FAR == nil || !DLPDR.FAR.ApplyAction.Buff {
+				continue
+			}
+			far := DLPDR.FAR
+			far.ApplyAction = ApplyAction{Forw: true}
+			far.ForwardingParameters = &ForwardingParameters{
+				DestinationInterface: DestinationInterface{
+					InterfaceValue: DestinationInterfaceAccess,
+				},
+				NetworkInstance: []byte(smContext.Dnn),
+				OuterHeaderCreation: &OuterHeaderCreation{
+					OuterHeaderCreationDescription: OuterHeaderCreationGtpUUdpIpv4,
+					Teid:                           smContext.Tunnel.ANInformation.TEID,
+					Ipv4Address:                    smContext.Tunnel.ANInformation.IPAddress.To4(),
+				},
+			}
+			if far.BAR != nil {
+				if err := ANUPF.UPF.RemoveBAR(far.BAR); err != nil {
+					logger.CtxLog.Warnf("BAR[%d] of FAR[%d] not released: %v", far.BAR.BARID, far.FARID, err)
+				}
+				// removed from the UPF with the update of the FAR
+				far.BAR.State = RULE_REMOVE
+			}
+			DLPDR.State = RULE_UPDATE
+			far.State = RULE_UPDATE
+			pdrList = append(pdrList, DLPDR)
+			farList = append(farList, far)
+		}
+	}
+	return pdrList, farList
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPagingPolicyIndicator(t *testing.T) {
+	smContext := &context.SMContext{}
+
+	// no DNN information
+	_, ok := smContext.PagingPolicyIndicator(46)
+	require.False(t, ok)
+
+	smContext.DNNInfo = &context.SnssaiSmfDnnInfo{
+		// EF voice and AF41 video paged with their own policies
+		PagingPolicyIndicators: map[uint8]uint8{46: 1, 34: 2},
+	}
+	ppi, ok := smContext.PagingPolicyIndicator(46)
+	require.True(t, ok)
+	require.Equal(t, uint8(1), ppi)
+	ppi, ok = smContext.PagingPolicyIndicator(34)
+	require.True(t, ok)
+	require.Equal(t, uint8(2), ppi)
+
+	// best effort traffic not mapped
+	_, ok = smContext.PagingPolicyIndicator(0)
+	require.False(t, ok)
+}
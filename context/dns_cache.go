@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+)
+
+// TTLs of the resolutions of the UPF hostnames if not configured
+const (
+	defaultDNSCacheTTL      = time.Minute
+	defaultDNSCacheStaleTTL = 5 * time.Minute
+)
+
+// DNSCache caches the addresses the hostnames resolve to. A resolution is used for its TTL, then
+// the hostname is resolved again. When the resolver fails, the last address is served for the
+// stale TTL after its expiry.
+type DNSCache struct {
+	entries  map[string]*dnsCacheEntry
+	lock     sync.Mutex
+	ttl      time.Duration
+	staleTTL time.Duration
+	lookup   func(host string) ([]string, error)
+}
+
+type dnsCacheEntry struct {
+	ip         net.IP
+	resolvedAt time.Time
+}
+
+var dnsCache = NewDNSCache(nil, nil)
+
+// NewDNSCache returns an empty cache of the TTLs of the config, the default ones if nil, resolving
+// the hostnames with the lookup, net.LookupHost if nil
+func NewDNSCache(config *factory.DNSCacheConfig, lookup func(host string) ([]string, error)) *DNSCache {
+	if lookup == nil {
+		lookup = net.LookupHost
+	}
+	c := &DNSCache{
+		entries: make(map[string]*dnsCacheEntry),
+		lookup:  lookup,
+	}
+	c.SetTTL(config)
+	return c
+}
+
+// GetDNSCache returns the cache of the UPF hostnames
+func GetDNSCache() *DNSCache {
+	return dnsCache
+}
+
+// FlushDNSCache forgets the resolutions of the UPF hostnames
+func FlushDNSCache() {
+	dnsCache.Flush()
+}
+
+// SetTTL sets the TTLs of the config, the default ones if nil or not set
+func (c *DNSCache) SetTTL(config *factory.DNSCacheConfig) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ttl, c.staleTTL = defaultDNSCacheTTL, defaultDNSCacheStaleTTL
+	if config == nil {
+		return
+	}
+	if config.TTLSec > 0 {
+		c.ttl = time.Duration(config.TTLSec) * time.Second
+	}
+	if config.StaleTTLSec > 0 {
+		c.staleTTL = time.Duration(config.StaleTTLSec) * time.Second
+	}
+}
+
+// Resolve returns the address of the hostname, from the cache until the TTL of its resolution
+// expires
+func (c *DNSCache) Resolve(host string) (net.IP, error) {
+	c.lock.Lock()
+	entry, ok := c.entries[host]
+	ttl := c.ttl
+	c.lock.Unlock()
+	if ok && time.Since(entry.resolvedAt) < ttl {
+		logger.CtxLog.Debugf("host [%v] found in smf dns cache ", host)
+		return entry.ip, nil
+	}
+	return c.resolve(host)
+}
+
+// resolve resolves the hostname and caches its address, the stale one is returned if the
+// resolution fails during the stale TTL
+func (c *DNSCache) resolve(host string) (net.IP, error) {
+	ns, err := c.lookup(host)
+	var ip net.IP
+	if err == nil {
+		if ip = net.ParseIP(ns[0]); ip == nil {
+			err = fmt.Errorf("host [%v] resolved to invalid address [%v]", host, ns[0])
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[host]
+	if err != nil {
+		if ok && time.Since(entry.resolvedAt) < c.ttl+c.staleTTL {
+			logger.CtxLog.Warnf("host lookup failed, stale address [%v] of host [%v] served: %+v", entry.ip, host, err)
+			return entry.ip, nil
+		}
+		delete(c.entries, host)
+		return nil, err
+	}
+	if !ok || !entry.ip.Equal(ip) {
+		logger.CtxLog.Infof("smf dns cache updated for host [%v]: [%v] ", host, ip)
+	}
+	c.entries[host] = &dnsCacheEntry{ip: ip, resolvedAt: time.Now()}
+	return ip, nil
+}
+
+// Insert caches the address of the hostname
+func (c *DNSCache) Insert(host string, ip net.IP) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[host] = &dnsCacheEntry{ip: ip, resolvedAt: time.Now()}
+}
+
+// Refresh resolves again the cached hostnames
+func (c *DNSCache) Refresh() {
+	c.lock.Lock()
+	hosts := make([]string, 0, len(c.entries))
+	for host := range c.entries {
+		hosts = append(hosts, host)
+	}
+	c.lock.Unlock()
+	for _, host := range hosts {
+		logger.CtxLog.Debugf("refreshing DNS for host [%v] ", host)
+		if _, err := c.resolve(host); err != nil {
+			logger.CtxLog.Warnf("host lookup failed: %+v", err)
+		}
+	}
+}
+
+// Flush forgets all the resolutions
+func (c *DNSCache) Flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]*dnsCacheEntry)
+}
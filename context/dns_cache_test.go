@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver resolves the hostnames to its address, or fails with its error
+type fakeResolver struct {
+	addr    string
+	err     error
+	lookups int
+}
+
+func (r *fakeResolver) lookupHost(host string) ([]string, error) {
+	r.lookups++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []string{r.addr}, nil
+}
+
+func TestDNSCacheTTL(t *testing.T) {
+	resolver := &fakeResolver{addr: "10.0.0.1"}
+	cache := context.NewDNSCache(&factory.DNSCacheConfig{TTLSec: 1}, resolver.lookupHost)
+
+	ip, err := cache.Resolve("upf.example.test")
+	require.NoError(t, err)
+	require.True(t, ip.Equal(net.ParseIP("10.0.0.1")))
+	require.Equal(t, 1, resolver.lookups)
+
+	// the second resolution hits the cache
+	ip, err = cache.Resolve("upf.example.test")
+	require.NoError(t, err)
+	require.True(t, ip.Equal(net.ParseIP("10.0.0.1")))
+	require.Equal(t, 1, resolver.lookups)
+
+	// resolved again once the TTL expired
+	resolver.addr = "10.0.0.2"
+	time.Sleep(1100 * time.Millisecond)
+	ip, err = cache.Resolve("upf.example.test")
+	require.NoError(t, err)
+	require.True(t, ip.Equal(net.ParseIP("10.0.0.2")))
+	require.Equal(t, 2, resolver.lookups)
+
+	// a flushed cache resolves again
+	cache.Flush()
+	_, err = cache.Resolve("upf.example.test")
+	require.NoError(t, err)
+	require.Equal(t, 3, resolver.lookups)
+}
+
+func TestDNSCacheStale(t *testing.T) {
+	resolver := &fakeResolver{addr: "10.0.0.1"}
+	cache := context.NewDNSCache(&factory.DNSCacheConfig{TTLSec: 1, StaleTTLSec: 1}, resolver.lookupHost)
+
+	_, err := cache.Resolve("upf.example.test")
+	require.NoError(t, err)
+
+	// the expired address is served while the resolver fails during the stale TTL
+	resolver.err = errors.New("no DNS server")
+	time.Sleep(1100 * time.Millisecond)
+	ip, err := cache.Resolve("upf.example.test")
+	require.NoError(t, err)
+	require.True(t, ip.Equal(net.ParseIP("10.0.0.1")))
+	require.Equal(t, 2, resolver.lookups)
+
+	// and forgotten after the stale TTL
+	time.Sleep(time.Second)
+	_, err = cache.Resolve("upf.example.test")
+	require.Error(t, err)
+
+	// a hostname never resolved has no stale address
+	_, err = cache.Resolve("other.example.test")
+	require.Error(t, err)
+}
+
+func TestFlushDNSCache(t *testing.T) {
+	context.InsertDnsHostIp("upf-flush.example.invalid", net.ParseIP("10.30.0.1"))
+	nodeID := context.NewNodeID("upf-flush.example.invalid")
+	require.True(t, nodeID.ResolveNodeIdToIp().Equal(net.ParseIP("10.30.0.1")))
+
+	context.FlushDNSCache()
+	require.True(t, nodeID.ResolveNodeIdToIp().IsUnspecified(), "the hostname resolved again after the flush")
+}
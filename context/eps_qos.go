@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/util"
+)
+
+// TS 24.501 9.11.4.8
+const (
+	MappedEPSBearerOpCreate        uint8 = 0x40 // create new EPS bearer
+	MappedEPSBearerEbit            uint8 = 0x10 // parameters list included
+	MappedEPSParameterIdQoS        uint8 = 0x01 // mapped EPS QoS parameters
+	MappedEPSParameterIdTFT        uint8 = 0x03 // traffic flow template
+	MappedEPSParameterIdAPNAMBR    uint8 = 0x04 // APN-AMBR
+	DefaultMappedEPSBearerIdentity uint8 = 5    // first EPS bearer identity, for the default QoS flow
+)
+
+// standardizedQCIs are the 5QIs having the same standardized QCI value, TS 23.203 Table 6.1.7
+var standardizedQCIs = map[int32]bool{
+	1: true, 2: true, 3: true, 4: true, 5: true, 6: true, 7: true, 8: true, 9: true,
+	65: true, 66: true, 67: true, 69: true, 70: true, 75: true, 79: true, 80: true,
+	82: true, 83: true, 84: true, 85: true,
+}
+
+// TS 24.008 10.5.6.12, TFT of the EPS bearer of the default QoS flow: create new TFT with a
+// bidirectional match-all packet filter of lowest precedence
+var defaultMappedEPSBearerTFT = []byte{0x21, 0x31, 0xff, 0x01, 0x01}
+
+// EPSBearerQoS is the EPS bearer QoS mapped from a QoS flow. TS 24.301 9.9.4.3
+type EPSBearerQoS struct {
+	QCI uint8
+	// ARP of the QoS flow, kept by the EPS bearer but not signalled to the UE
+	ARP *models.Arp
+	// bit rates of a GBR QoS flow, in kbps
+	MBRUplink, MBRDownlink uint64
+	GBRUplink, GBRDownlink uint64
+}
+
+// guaranteed is true for the EPS bearer QoS of a GBR QoS flow
+func (epsQoS EPSBearerQoS) guaranteed() bool {
+	return epsQoS.MBRUplink != 0 || epsQoS.MBRDownlink != 0 || epsQoS.GBRUplink != 0 || epsQoS.GBRDownlink != 0
+}
+
+// MarshalBinary encodes the EPS quality of service IE contents: the QCI, followed by the maximum
+// and guaranteed bit rates of a GBR bearer. TS 24.301 9.9.4.3
+func (epsQoS EPSBearerQoS) MarshalBinary() ([]byte, error) {
+	if !epsQoS.guaranteed() {
+		return []byte{epsQoS.QCI}, nil
+	}
+	rates := make([][3]uint8, 4)
+	for i, kbps := range []uint64{epsQoS.MBRUplink, epsQoS.MBRDownlink, epsQoS.GBRUplink, epsQoS.GBRDownlink} {
+		rates[i][0], rates[i][1], rates[i][2] = encodeEPSBitRate(kbps)
+	}
+	b := []byte{epsQoS.QCI}
+	for octet := range 3 {
+		for _, rate := range rates {
+			b = append(b, rate[octet])
+		}
+	}
+	return b, nil
+}
+
+// encodeEPSBitRate encodes a bit rate in kbps on its octet, extended and extended-2 octets, the
+// rate rounded down to the granularity of its range. TS 24.301 9.9.4.2 and 9.9.4.3
+func encodeEPSBitRate(kbps uint64) (rate, extended, extended2 uint8) {
+	switch {
+	case kbps == 0:
+		return 0xff, 0, 0
+	case kbps <= 63:
+		return uint8(kbps), 0, 0
+	case kbps <= 568:
+		return uint8(0x40 + (kbps-64)/8), 0, 0
+	case kbps <= 8640:
+		return uint8(0x80 + (kbps-576)/64), 0, 0
+	case kbps <= 16000:
+		return 0xfe, uint8((kbps - 8600) / 100), 0
+	case kbps <= 128000:
+		return 0xfe, uint8(0x4a + (kbps-16000)/1000), 0
+	case kbps <= 256000:
+		return 0xfe, uint8(0xba + (kbps-128000)/2000), 0
+	default:
+		return 0xfe, 0xfa, uint8(min((kbps-256000)/256000, 0xfe))
+	}
+}
+
+// encodeAPNAMBR encodes the APN-AMBR IE contents from the session AMBR. TS 24.301 9.9.4.2
+func encodeAPNAMBR(sessionAmbr *models.Ambr) []byte {
+	downlink, downlinkExtended, downlinkExtended2 := encodeEPSBitRate(bitRateTokbps(sessionAmbr.Downlink))
+	uplink, uplinkExtended, uplinkExtended2 := encodeEPSBitRate(bitRateTokbps(sessionAmbr.Uplink))
+	return []byte{downlink, uplink, downlinkExtended, uplinkExtended, downlinkExtended2, uplinkExtended2}
+}
+
+// bitRateTokbps converts a bit rate of the QoS data, 0 when not set or invalid
+func bitRateTokbps(bitRate string) uint64 {
+	if len(strings.Fields(bitRate)) != 2 {
+		return 0
+	}
+	return util.BitRateTokbps(bitRate)
+}
+
+func validQoSIdentifier(value int32) bool {
+	return value >= 1 && value <= 255
+}
+
+// MapQoSForEPS maps the 5QI of the QoS flow to the QCI of its EPS bearer, with the mapping of the
+// DNN first then the standardized one, along with its ARP and bit rates. Fails for an invalid 5QI,
+// or a 5QI without QCI.
+func (dnnInfo *SnssaiSmfDnnInfo) MapQoSForEPS(flow *models.QosData) (EPSBearerQoS, error) {
+	if flow == nil || !validQoSIdentifier(flow.Var5qi) {
+		return EPSBearerQoS{}, fmt.Errorf("invalid 5QI for EPS bearer QoS mapping")
+	}
+	epsQoS := EPSBearerQoS{
+		ARP:         flow.Arp,
+		MBRUplink:   bitRateTokbps(flow.MaxbrUl),
+		MBRDownlink: bitRateTokbps(flow.MaxbrDl),
+		GBRUplink:   bitRateTokbps(flow.GbrUl),
+		GBRDownlink: bitRateTokbps(flow.GbrDl),
+	}
+	if qci, ok := dnnInfo.fiveQIToQCI(flow.Var5qi); ok {
+		epsQoS.QCI = uint8(qci)
+		return epsQoS, nil
+	}
+	if standardizedQCIs[flow.Var5qi] {
+		epsQoS.QCI = uint8(flow.Var5qi)
+		return epsQoS, nil
+	}
+	return EPSBearerQoS{}, fmt.Errorf("no QCI mapped to 5QI %d", flow.Var5qi)
+}
+
+func (dnnInfo *SnssaiSmfDnnInfo) fiveQIToQCI(fiveQI int32) (int32, bool) {
+	if dnnInfo == nil {
+		return 0, false
+	}
+	qci, ok := dnnInfo.FiveQIToQCIMapping[fiveQI]
+	return qci, ok
+}
+
+// BuildMappedEPSBearerContexts encodes the Mapped EPS bearer contexts IE contents, creating the
+// EPS bearer of the default QoS flow of the session with its QoS, a match-all TFT and the session
+// AMBR as APN-AMBR
+func (smContext *SMContext) BuildMappedEPSBearerContexts(defaultQoS *models.QosData, sessionAmbr *models.Ambr) ([]byte, error) {
+	epsQoS, err := smContext.DNNInfo.MapQoSForEPS(defaultQoS)
+	if err != nil {
+		return nil, err
+	}
+	encodedQoS, err := epsQoS.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	parameters := [][]byte{
+		append([]byte{MappedEPSParameterIdQoS, uint8(len(encodedQoS))}, encodedQoS...),
+		append([]byte{MappedEPSParameterIdTFT, uint8(len(defaultMappedEPSBearerTFT))}, defaultMappedEPSBearerTFT...),
+	}
+	if sessionAmbr != nil {
+		apnAmbr := encodeAPNAMBR(sessionAmbr)
+		parameters = append(parameters, append([]byte{MappedEPSParameterIdAPNAMBR, uint8(len(apnAmbr))}, apnAmbr...))
+	}
+
+	bearerContext := []byte{
+		DefaultMappedEPSBearerIdentity << 4, 0, 0,
+		MappedEPSBearerOpCreate | MappedEPSBearerEbit | uint8(len(parameters)),
+	}
+	for _, parameter := range parameters {
+		bearerContext = append(bearerContext, parameter...)
+	}
+	binary.BigEndian.PutUint16(bearerContext[1:3], uint16(len(bearerContext)-3))
+	return bearerContext, nil
+}
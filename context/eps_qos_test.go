@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/nas"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapQoSForEPS(t *testing.T) {
+	dnnInfo := &context.SnssaiSmfDnnInfo{
+		FiveQIToQCIMapping: map[int32]int32{7: 6, 128: 130},
+	}
+
+	// standardized 5QIs keep their value as QCI
+	for _, fiveQI := range []int32{1, 5, 9, 65, 69, 70, 79, 80, 85} {
+		epsQoS, err := dnnInfo.MapQoSForEPS(&models.QosData{Var5qi: fiveQI})
+		require.NoError(t, err)
+		require.Equal(t, uint8(fiveQI), epsQoS.QCI)
+	}
+
+	// mapping of the DNN, with the ARP and bit rates of the QoS flow
+	arp := &models.Arp{PriorityLevel: 3}
+	epsQoS, err := dnnInfo.MapQoSForEPS(&models.QosData{Var5qi: 7, Arp: arp, GbrDl: "20 Mbps", MaxbrUl: "50 Kbps"})
+	require.NoError(t, err)
+	require.Equal(t, uint8(6), epsQoS.QCI)
+	require.Same(t, arp, epsQoS.ARP)
+	require.Equal(t, uint64(20000), epsQoS.GBRDownlink)
+	require.Equal(t, uint64(50), epsQoS.MBRUplink)
+	epsQoS, err = dnnInfo.MapQoSForEPS(&models.QosData{Var5qi: 128})
+	require.NoError(t, err)
+	require.Equal(t, uint8(130), epsQoS.QCI)
+
+	// invalid 5QIs, and 5QIs without QCI
+	for _, fiveQI := range []int32{0, -1, 256, 71, 86, 129} {
+		_, err := dnnInfo.MapQoSForEPS(&models.QosData{Var5qi: fiveQI})
+		require.Error(t, err, "5QI %d", fiveQI)
+	}
+	_, err = dnnInfo.MapQoSForEPS(nil)
+	require.Error(t, err)
+}
+
+func TestEPSBearerQoSMarshalBinary(t *testing.T) {
+	// QCI alone for a non-GBR bearer
+	b, err := context.EPSBearerQoS{QCI: 9}.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x09}, b)
+
+	// bit rates of the ranges of 1, 8 and 64 kbps then of the extended octet of 1 Mbps
+	b, err = context.EPSBearerQoS{QCI: 1, MBRUplink: 50, MBRDownlink: 100, GBRUplink: 1000, GBRDownlink: 20000}.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x01,
+		0x32, 0x44, 0x86, 0xfe, // MBR UL, MBR DL, GBR UL, GBR DL
+		0x00, 0x00, 0x00, 0x4e, // extended
+		0x00, 0x00, 0x00, 0x00, // extended-2
+	}, b)
+}
+
+func TestBuildGSMPDUSessionEstablishmentAcceptMappedEPSBearerContexts(t *testing.T) {
+	smContext := newPDUSessionTypeSMContext(t, false)
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.ParseIP("10.61.0.1").To4()}
+	smContext.SmPolicyUpdates = append(smContext.SmPolicyUpdates,
+		qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, smContext.DNNInfo.BuildDefaultSmPolicyDecision()))
+
+	decodeAccept := func() *nas.Message {
+		b, err := context.BuildGSMPDUSessionEstablishmentAccept(smContext)
+		require.NoError(t, err)
+		m := nas.NewMessage()
+		require.NoError(t, m.PlainNasDecode(&b))
+		require.NotNil(t, m.PDUSessionEstablishmentAccept)
+		return m
+	}
+
+	mappedEPSBearerContext := func(qci uint8) []byte {
+		return []byte{
+			0x50, 0x00, 0x13, 0x53, // EBI 5, length 19, create new EPS bearer with 3 parameters
+			0x01, 0x01, qci, // mapped EPS QoS parameters
+			0x03, 0x05, 0x21, 0x31, 0xff, 0x01, 0x01, // TFT, create with a match-all packet filter
+			0x04, 0x06, 0xfe, 0xfe, 0xfa, 0xfa, 0x02, 0x02, // APN-AMBR of 1 Gbps, as 768 Mbps
+		}
+	}
+
+	// standardized QCI without mapping configured for the DNN
+	mappedContexts := decodeAccept().PDUSessionEstablishmentAccept.MappedEPSBearerContexts
+	require.NotNil(t, mappedContexts)
+	require.Equal(t, mappedEPSBearerContext(9), mappedContexts.GetMappedEPSBearerContext())
+
+	smContext.DNNInfo.FiveQIToQCIMapping = map[int32]int32{9: 8}
+	mappedContexts = decodeAccept().PDUSessionEstablishmentAccept.MappedEPSBearerContexts
+	require.NotNil(t, mappedContexts)
+	require.Equal(t, mappedEPSBearerContext(8), mappedContexts.GetMappedEPSBearerContext())
+}
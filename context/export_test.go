@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"testing"
+
+	"github.com/omec-project/smf/factory"
+)
+
+// SetBeforeConfigUpdate replaces the hook called by the config update worker before it applies a
+// config, for the duration of the test
+func SetBeforeConfigUpdate(t *testing.T, hook func()) {
+	t.Helper()
+	queue := &configUpdateQueue
+	queue.Lock()
+	defer queue.Unlock()
+	origBeforeConfigUpdate := beforeConfigUpdate
+	t.Cleanup(func() {
+		queue.Lock()
+		defer queue.Unlock()
+		beforeConfigUpdate = origBeforeConfigUpdate
+	})
+	beforeConfigUpdate = hook
+}
+
+// PendingConfigUpdate returns the config waiting to be applied by the worker, nil if none
+func PendingConfigUpdate() *factory.Configuration {
+	queue := &configUpdateQueue
+	queue.Lock()
+	defer queue.Unlock()
+	if queue.pending == nil {
+		return nil
+	}
+	return queue.pending.cfg
+}
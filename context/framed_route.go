@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxFramedRoutes is the number of subnets that may be routed behind a UE
+	MaxFramedRoutes = 16
+	// MinFramedRoutePrefixLength bounds the size of a subnet behind a UE
+	MinFramedRoutePrefixLength = 8
+)
+
+// IsUpfSupportFramedRouting routing of the subnets behind the UEs by UPF supported
+func (upf *UPF) IsUpfSupportFramedRouting() bool {
+	return upf.UPFunctionFeatures != nil &&
+		(upf.UPFunctionFeatures.SupportedFeatures&UpFunctionFeaturesFrrt) == UpFunctionFeaturesFrrt
+}
+
+// ParseFramedRoute validates a framed route "prefix/length [gateway [metric]]" of RFC 2865. The
+// prefix is an IPv4 unicast subnet of at most /8 without host bits, the gateway an IPv4 address.
+// Returns the route with its prefix in canonical form.
+func ParseFramedRoute(route string) (string, error) {
+	fields := strings.Fields(route)
+	if len(fields) == 0 || len(fields) > 3 {
+		return "", fmt.Errorf("framed route [%s] is not prefix/length [gateway [metric]]", route)
+	}
+	ip, subnet, err := net.ParseCIDR(fields[0])
+	if err != nil || ip.To4() == nil {
+		return "", fmt.Errorf("framed route [%s] prefix is not an IPv4 subnet", route)
+	}
+	if !ip.Equal(subnet.IP) {
+		return "", fmt.Errorf("framed route [%s] prefix has host bits set", route)
+	}
+	if length, _ := subnet.Mask.Size(); length < MinFramedRoutePrefixLength {
+		return "", fmt.Errorf("framed route [%s] prefix is shorter than /%d", route, MinFramedRoutePrefixLength)
+	}
+	if ip.IsLoopback() || ip.IsMulticast() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return "", fmt.Errorf("framed route [%s] prefix is not a unicast subnet", route)
+	}
+	fields[0] = subnet.String()
+	if len(fields) > 1 {
+		if gateway := net.ParseIP(fields[1]); gateway == nil || gateway.To4() == nil {
+			return "", fmt.Errorf("framed route [%s] gateway is not an IPv4 address", route)
+		}
+	}
+	if len(fields) > 2 {
+		if _, err := strconv.ParseUint(fields[2], 10, 32); err != nil {
+			return "", fmt.Errorf("framed route [%s] metric is not a number", route)
+		}
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// ParseFramedRoutes validates the framed routes of a UE, at most MaxFramedRoutes of them
+func ParseFramedRoutes(routes []string) ([]string, error) {
+	if len(routes) > MaxFramedRoutes {
+		return nil, fmt.Errorf("%d framed routes, at most %d allowed", len(routes), MaxFramedRoutes)
+	}
+	parsed := make([]string, 0, len(routes))
+	for _, route := range routes {
+		framedRoute, err := ParseFramedRoute(route)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, framedRoute)
+	}
+	return parsed, nil
+}
+
+// FramedRoutes returns the subnets routed behind the UE, from the framed routes of its DNN
+func (smContext *SMContext) FramedRoutes() []string {
+	dnnInfo := smContext.dnnConfig()
+	if dnnInfo == nil {
+		return nil
+	}
+	return dnnInfo.FramedRoutes[smContext.Supi]
+}
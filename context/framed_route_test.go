@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFramedRoute(t *testing.T) {
+	for route, expected := range map[string]string{
+		"192.168.10.0/24":              "192.168.10.0/24",
+		"192.168.10.0/24 0.0.0.0 1":    "192.168.10.0/24 0.0.0.0 1",
+		" 172.16.0.0/16  10.60.0.1 ":   "172.16.0.0/16 10.60.0.1",
+		"10.0.0.0/8 10.60.0.1 4294967": "10.0.0.0/8 10.60.0.1 4294967",
+	} {
+		parsed, err := context.ParseFramedRoute(route)
+		require.NoError(t, err, route)
+		require.Equal(t, expected, parsed)
+	}
+
+	for _, route := range []string{
+		"",
+		"192.168.10.0",                // no prefix length
+		"192.168.10.1/24",             // host bits
+		"0.0.0.0/0",                   // default route
+		"10.0.0.0/7",                  // shorter than /8
+		"224.0.0.0/8",                 // multicast
+		"127.0.0.0/8",                 // loopback
+		"fd00::/64",                   // IPv6
+		"192.168.10.0/24 gateway",     // gateway not an address
+		"192.168.10.0/24 0.0.0.0 low", // metric not a number
+		"192.168.10.0/24 0.0.0.0 1 x", // extra field
+	} {
+		_, err := context.ParseFramedRoute(route)
+		require.Error(t, err, route)
+	}
+}
+
+func TestParseFramedRoutes(t *testing.T) {
+	routes := make([]string, context.MaxFramedRoutes+1)
+	for i := range routes {
+		routes[i] = "192.168.10.0/24"
+	}
+	_, err := context.ParseFramedRoutes(routes)
+	require.Error(t, err, "too many routes")
+
+	parsed, err := context.ParseFramedRoutes(routes[:context.MaxFramedRoutes])
+	require.NoError(t, err)
+	require.Len(t, parsed, context.MaxFramedRoutes)
+}
+
+func TestActivateDlLinkPdrFramedRoutes(t *testing.T) {
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai: context.SNssai{Sst: 1, Sd: "010203"},
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{
+				"enterprise": {FramedRoutes: map[string][]string{
+					"imsi-208930000000001": {"192.168.10.0/24 0.0.0.0 1"},
+				}},
+			},
+		},
+	})
+
+	activate := func(supi string, features *context.UPFunctionFeatures) []string {
+		smContext := &context.SMContext{
+			Supi:       supi,
+			Dnn:        "enterprise",
+			Snssai:     &models.Snssai{Sst: 1, Sd: "010203"},
+			PDUAddress: &context.UeIpAddr{Ip: net.IPv4(10, 60, 0, 1)},
+			Tunnel:     &context.UPTunnel{},
+		}
+		dpNode := &context.DataPathNode{
+			UPF: &context.UPF{UPFunctionFeatures: features},
+			DownLinkTunnel: &context.GTPTunnel{
+				PDR: map[string]*context.PDR{
+					"default": {FAR: &context.FAR{}},
+				},
+			},
+		}
+		require.NoError(t, dpNode.ActivateDlLinkPdr(smContext, &context.QER{}, 255, &context.DataPath{FirstDPNode: dpNode}))
+		return dpNode.DownLinkTunnel.PDR["default"].PDI.FramedRoutes
+	}
+
+	frrt := &context.UPFunctionFeatures{SupportedFeatures: context.UpFunctionFeaturesFrrt}
+	require.Equal(t, []string{"192.168.10.0/24 0.0.0.0 1"}, activate("imsi-208930000000001", frrt))
+
+	// no framed route for the UE
+	require.Empty(t, activate("imsi-208930000000002", frrt))
+
+	// the UPF does not support framed routing
+	require.Empty(t, activate("imsi-208930000000001", nil))
+}
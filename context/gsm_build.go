@@ -13,6 +13,7 @@ import (
 	"github.com/omec-project/nas/nasConvert"
 	"github.com/omec-project/nas/nasMessage"
 	"github.com/omec-project/nas/nasType"
+	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/qos"
 	errors "github.com/omec-project/smf/smferrors"
 )
@@ -71,6 +72,21 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 	// pDUSessionEstablishmentAccept.AuthorizedQosFlowDescriptions.SetLen(6)
 	// pDUSessionEstablishmentAccept.SetQoSFlowDescriptions([]uint8{uint8(authDefQos.Var5qi), 0x20, 0x41, 0x01, 0x01, 0x09})
 
+	// EPS bearer of the default QoS flow, for the handover to EPS
+	if sessRule.AuthDefQos != nil {
+		defaultQoS := &models.QosData{Var5qi: sessRule.AuthDefQos.Var5qi, Arp: sessRule.AuthDefQos.Arp}
+		mappedContexts, err := smContext.BuildMappedEPSBearerContexts(defaultQoS, sessRule.AuthSessAmbr)
+		if err != nil {
+			smContext.SubGsmLog.Warnf("no mapped EPS bearer context: %v", err)
+		} else {
+			pDUSessionEstablishmentAccept.MappedEPSBearerContexts = nasType.NewMappedEPSBearerContexts(
+				nasMessage.PDUSessionEstablishmentAcceptMappedEPSBearerContextsType,
+			)
+			pDUSessionEstablishmentAccept.MappedEPSBearerContexts.SetLen(uint16(len(mappedContexts)))
+			pDUSessionEstablishmentAccept.SetMappedEPSBearerContext(mappedContexts)
+		}
+	}
+
 	var sd [3]uint8
 
 	if byteArray, err := hex.DecodeString(smContext.Snssai.Sd); err != nil {
@@ -95,16 +111,16 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 		)
 		protocolConfigurationOptions := nasConvert.NewProtocolConfigurationOptions()
 
-		// IPv4 DNS
-		if smContext.ProtocolConfigurationOptions.DNSIPv4Request {
+		// IPv4 DNS, omitted if not configured
+		if smContext.ProtocolConfigurationOptions.DNSIPv4Request && smContext.DNNInfo.DNS.IPv4Addr != nil {
 			err := protocolConfigurationOptions.AddDNSServerIPv4Address(smContext.DNNInfo.DNS.IPv4Addr)
 			if err != nil {
 				smContext.SubGsmLog.Warnln("Error while adding DNS IPv4 Addr: ", err)
 			}
 		}
 
-		// IPv6 DNS
-		if smContext.ProtocolConfigurationOptions.DNSIPv6Request {
+		// IPv6 DNS, omitted if not configured
+		if smContext.ProtocolConfigurationOptions.DNSIPv6Request && smContext.DNNInfo.DNS.IPv6Addr != nil {
 			err := protocolConfigurationOptions.AddDNSServerIPv6Address(smContext.DNNInfo.DNS.IPv6Addr)
 			if err != nil {
 				smContext.SubGsmLog.Warnln("Error while adding DNS IPv6 Addr: ", err)
@@ -126,6 +142,12 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 			SetLen(uint16(pcoContentsLength))
 		pDUSessionEstablishmentAccept.SetExtendedProtocolConfigurationOptionsContents(pcoContents)
 	}
+
+	if smContext.EAPResult != nil {
+		pDUSessionEstablishmentAccept.EAPMessage = nasType.NewEAPMessage(nasMessage.PDUSessionEstablishmentAcceptEAPMessageType)
+		pDUSessionEstablishmentAccept.EAPMessage.SetLen(uint16(len(smContext.EAPResult)))
+		pDUSessionEstablishmentAccept.EAPMessage.SetEAPMessage(smContext.EAPResult)
+	}
 	return m.PlainNasEncode()
 }
 
@@ -143,10 +165,37 @@ func BuildGSMPDUSessionEstablishmentReject(smContext *SMContext, cause uint8) ([
 	pDUSessionEstablishmentReject.SetCauseValue(cause)
 	pDUSessionEstablishmentReject.SetPTI(smContext.Pti)
 
+	if smContext.EAPResult != nil {
+		pDUSessionEstablishmentReject.EAPMessage = nasType.NewEAPMessage(nasMessage.PDUSessionEstablishmentRejectEAPMessageType)
+		pDUSessionEstablishmentReject.EAPMessage.SetLen(uint16(len(smContext.EAPResult)))
+		pDUSessionEstablishmentReject.EAPMessage.SetEAPMessage(smContext.EAPResult)
+	}
+
+	return m.PlainNasEncode()
+}
+
+// BuildGSMPDUSessionAuthenticationCommand builds the PDU Session Authentication Command relaying
+// the EAP request of the authentication of the session to the UE. The command is network
+// initiated, without procedure transaction. TS 24.501 8.3.4
+func BuildGSMPDUSessionAuthenticationCommand(smContext *SMContext, eapMessage []byte) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionAuthenticationCommand)
+	m.GsmHeader.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	m.PDUSessionAuthenticationCommand = nasMessage.NewPDUSessionAuthenticationCommand(0x0)
+	pDUSessionAuthenticationCommand := m.PDUSessionAuthenticationCommand
+
+	pDUSessionAuthenticationCommand.SetMessageType(nas.MsgTypePDUSessionAuthenticationCommand)
+	pDUSessionAuthenticationCommand.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pDUSessionAuthenticationCommand.SetPDUSessionID(uint8(smContext.PDUSessionID))
+	pDUSessionAuthenticationCommand.SetPTI(0)
+	pDUSessionAuthenticationCommand.EAPMessage.SetLen(uint16(len(eapMessage)))
+	pDUSessionAuthenticationCommand.SetEAPMessage(eapMessage)
+
 	return m.PlainNasEncode()
 }
 
-/*func BuildGSMPDUSessionModificationReject(smContext *SMContext, cause uint8) ([]byte, error) {
+func BuildGSMPDUSessionModificationReject(smContext *SMContext, cause uint8) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GsmMessage = nas.NewGsmMessage()
 	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionModificationReject)
@@ -157,12 +206,19 @@ func BuildGSMPDUSessionEstablishmentReject(smContext *SMContext, cause uint8) ([
 	pDUSessionModificationReject.SetMessageType(nas.MsgTypePDUSessionModificationReject)
 	pDUSessionModificationReject.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
 	pDUSessionModificationReject.SetPDUSessionID(uint8(smContext.PDUSessionID))
+	pDUSessionModificationReject.SetPTI(smContext.Pti)
 	pDUSessionModificationReject.SetCauseValue(cause)
 
 	return m.PlainNasEncode()
-}*/
+}
 
 func BuildGSMPDUSessionReleaseCommand(smContext *SMContext) ([]byte, error) {
+	return BuildGSMPDUSessionReleaseCommandWithCause(smContext, 0x0)
+}
+
+// BuildGSMPDUSessionReleaseCommandWithCause builds the PDU Session Release Command of a release
+// for the 5GSM cause
+func BuildGSMPDUSessionReleaseCommandWithCause(smContext *SMContext, cause uint8) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GsmMessage = nas.NewGsmMessage()
 	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionReleaseCommand)
@@ -174,7 +230,7 @@ func BuildGSMPDUSessionReleaseCommand(smContext *SMContext) ([]byte, error) {
 	pDUSessionReleaseCommand.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
 	pDUSessionReleaseCommand.SetPDUSessionID(uint8(smContext.PDUSessionID))
 	pDUSessionReleaseCommand.SetPTI(smContext.Pti)
-	pDUSessionReleaseCommand.SetCauseValue(0x0)
+	pDUSessionReleaseCommand.SetCauseValue(cause)
 
 	return m.PlainNasEncode()
 }
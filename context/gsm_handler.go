@@ -27,7 +27,7 @@ func (smContext *SMContext) HandlePDUSessionEstablishmentRequest(req *nasMessage
 		}
 	} else {
 		// Set to default supported PDU Session Type
-		switch SMF_Self().SupportedPDUSessionType {
+		switch smContext.SupportedPDUSessionType() {
 		case "IPv4":
 			smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
 		case "IPv6":
@@ -100,7 +100,8 @@ func (smContext *SMContext) HandlePDUSessionEstablishmentRequest(req *nasMessage
 			case nasMessage.APNRateControlSupportIndicatorUL:
 				smContext.SubGsmLog.Infoln("Didn't Implement container type APNRateControlSupportIndicatorUL")
 			case nasMessage.UEStatus3GPPPSDataOffUL:
-				smContext.SubGsmLog.Infoln("Didn't Implement container type UEStatus3GPPPSDataOffUL")
+				smContext.PsDataOff = psDataOffActivated(container.Contents)
+				smContext.SubGsmLog.Infof("3GPP PS data off activated: %t", smContext.PsDataOff)
 			case nasMessage.ReliableDataServiceRequestIndicatorUL:
 				smContext.SubGsmLog.Infoln("Didn't Implement container type ReliableDataServiceRequestIndicatorUL")
 			case nasMessage.AdditionalAPNRateControlForExceptionDataSupportIndicatorUL:
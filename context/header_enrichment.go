@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"strings"
+)
+
+// HeaderEnrichment is a header inserted by the UPF in the forwarded traffic. 8.2.67
+type HeaderEnrichment struct {
+	Name       string
+	Value      string
+	HeaderType uint8
+}
+
+// HeaderTypeHTTP is the only header type defined for the Header Enrichment IE
+const HeaderTypeHTTP uint8 = 0
+
+// IsUpfSupportHeaderEnrichment header enrichment of the uplink traffic by UPF supported
+func (upf *UPF) IsUpfSupportHeaderEnrichment() bool {
+	return upf.UPFunctionFeatures != nil &&
+		(upf.UPFunctionFeatures.SupportedFeatures&UpFunctionFeaturesHeeu) == UpFunctionFeaturesHeeu
+}
+
+// ExpandHeaderEnrichmentValue replaces the {supi} and {msisdn} placeholders of the template with
+// the identities of the UE. The MSISDN is taken from the GPSI, without its type prefix.
+func (smContext *SMContext) ExpandHeaderEnrichmentValue(template string) string {
+	msisdn, _ := strings.CutPrefix(smContext.Gpsi, "msisdn-")
+	return strings.NewReplacer(
+		"{supi}", smContext.Supi,
+		"{msisdn}", msisdn,
+	).Replace(template)
+}
+
+// HeaderEnrichment returns the headers the anchor UPF inserts in the uplink traffic of the
+// session, expanded from the rules of its DNN
+func (smContext *SMContext) HeaderEnrichment() []HeaderEnrichment {
+	dnnInfo := smContext.dnnConfig()
+	if dnnInfo == nil {
+		return nil
+	}
+	var headers []HeaderEnrichment
+	for _, rule := range dnnInfo.HeaderEnrichment {
+		headers = append(headers, HeaderEnrichment{
+			HeaderType: HeaderTypeHTTP,
+			Name:       rule.HeaderName,
+			Value:      smContext.ExpandHeaderEnrichmentValue(rule.HeaderValue),
+		})
+	}
+	return headers
+}
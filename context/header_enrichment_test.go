@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandHeaderEnrichmentValue(t *testing.T) {
+	smContext := &context.SMContext{
+		Supi: "imsi-208930000000001",
+		Gpsi: "msisdn-33612345678",
+	}
+
+	require.Equal(t, "imsi-208930000000001", smContext.ExpandHeaderEnrichmentValue("{supi}"))
+	require.Equal(t, "33612345678", smContext.ExpandHeaderEnrichmentValue("{msisdn}"))
+	require.Equal(t, "tel:33612345678;id=imsi-208930000000001",
+		smContext.ExpandHeaderEnrichmentValue("tel:{msisdn};id={supi}"))
+	require.Equal(t, "static", smContext.ExpandHeaderEnrichmentValue("static"))
+
+	// no GPSI received from the AMF
+	require.Empty(t, (&context.SMContext{Supi: "imsi-208930000000001"}).ExpandHeaderEnrichmentValue("{msisdn}"))
+}
+
+func TestActivateUpLinkPdrHeaderEnrichment(t *testing.T) {
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai: context.SNssai{Sst: 1, Sd: "010203"},
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{
+				"internet": {
+					HeaderEnrichment: []factory.HeaderEnrichmentRule{
+						{HeaderName: "X-MSISDN", HeaderValue: "{msisdn}"},
+					},
+				},
+			},
+		},
+	})
+
+	activate := func(features *context.UPFunctionFeatures) *context.ForwardingParameters {
+		smContext := &context.SMContext{
+			Supi:       "imsi-208930000000001",
+			Gpsi:       "msisdn-33612345678",
+			Dnn:        "internet",
+			Snssai:     &models.Snssai{Sst: 1, Sd: "010203"},
+			PDUAddress: &context.UeIpAddr{Ip: net.IPv4(10, 60, 0, 1)},
+		}
+		dpNode := &context.DataPathNode{
+			UPF: &context.UPF{UPFunctionFeatures: features},
+			UpLinkTunnel: &context.GTPTunnel{
+				PDR: map[string]*context.PDR{
+					"default": {FAR: &context.FAR{}},
+				},
+			},
+		}
+		require.NoError(t, dpNode.ActivateUpLinkPdr(smContext, &context.QER{}, 255))
+		return dpNode.UpLinkTunnel.PDR["default"].FAR.ForwardingParameters
+	}
+
+	forwardingParameters := activate(&context.UPFunctionFeatures{SupportedFeatures: context.UpFunctionFeaturesHeeu})
+	require.Equal(t, []context.HeaderEnrichment{
+		{HeaderType: context.HeaderTypeHTTP, Name: "X-MSISDN", Value: "33612345678"},
+	}, forwardingParameters.HeaderEnrichment)
+
+	// the UPF did not report the HEEU feature
+	require.Empty(t, activate(&context.UPFunctionFeatures{}).HeaderEnrichment)
+	require.Empty(t, activate(nil).HeaderEnrichment)
+}
@@ -9,19 +9,36 @@ import (
 	"errors"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/smferrors"
 )
 
 type IPAllocator struct {
 	ipNetwork *net.IPNet
 	g         *_IDPool
+	// labels of the available addresses gauge, not reported when empty
+	dnn    string
+	snssai string
+	// released addresses held for the re-establishment of the sessions, by IMSI
+	graceHolds     map[string][]*graceHold
+	graceHoldsLock sync.Mutex
+}
+
+// graceHold is a released address held until its grace period expires
+type graceHold struct {
+	ip     net.IP
+	offset int64
+	timer  *time.Timer
 }
 
 func NewIPAllocator(cidr string) (*IPAllocator, error) {
-	allocator := &IPAllocator{}
+	allocator := &IPAllocator{graceHolds: make(map[string][]*graceHold)}
 
 	if _, ipnet, err := net.ParseCIDR(cidr); err != nil {
 		return nil, err
@@ -66,8 +83,12 @@ func IPAddrWithOffset(ip net.IP, offset int) net.IP {
 	return retIP
 }
 
-// IPAddrOffset calculate the input ip with base ip offset
+// IPAddrOffset calculate the input ip with base ip offset, the IPv4 addresses being compared in
+// their 4-byte form
 func IPAddrOffset(in, base net.IP) int {
+	if in4, base4 := in.To4(), base.To4(); in4 != nil && base4 != nil {
+		in, base = in4, base4
+	}
 	offset := 0
 	exp := 1
 	for i := len(base) - 1; i >= 0; i-- {
@@ -77,6 +98,11 @@ func IPAddrOffset(in, base net.IP) int {
 	return offset
 }
 
+// IsIPv4 checks that the allocator hands out IPv4 addresses
+func (a *IPAllocator) IsIPv4() bool {
+	return a.ipNetwork != nil && a.ipNetwork.IP.To4() != nil
+}
+
 // Allocate will allocate the IP address and returns it
 func (a *IPAllocator) Allocate(imsi string) (net.IP, error) {
 	// check if static IP already reserved for this IMSI
@@ -87,9 +113,16 @@ func (a *IPAllocator) Allocate(imsi string) (net.IP, error) {
 		}
 	}
 
+	// the address of a session of the IMSI released during the grace period is revived
+	if ip := a.reviveGraceHold(imsi); ip != nil {
+		logger.CtxLog.Infof("ip %v held for imsi %s revived", ip, imsi)
+		return ip, nil
+	}
+
 	if offset, err := a.g.allocate(); err != nil {
-		return nil, errors.New("ip allocation failed" + err.Error())
+		return nil, smferrors.Wrap(smferrors.ErrCodeIPPoolExhausted, err, "ip allocation failed")
 	} else {
+		a.reportAvailable()
 		smfCountStr := os.Getenv("SMF_COUNT")
 		if smfCountStr == "" {
 			smfCountStr = "1"
@@ -106,19 +139,50 @@ func (a *IPAllocator) Allocate(imsi string) (net.IP, error) {
 	}
 }
 
+// AllocateIP allocates the address of the pool, returns false if it is not in the pool or not
+// free
+func (a *IPAllocator) AllocateIP(ip net.IP) bool {
+	ip4 := ip.To4()
+	if ip4 == nil || !a.ipNetwork.Contains(ip4) {
+		return false
+	}
+	if !a.g.allocateID(int64(IPAddrOffset(ip4, a.ipNetwork.IP))) {
+		return false
+	}
+	a.reportAvailable()
+	return true
+}
+
 func (a *IPAllocator) ReserveStaticIps(ips *map[string]string) {
 	a.g.staticIps = ips
 	for _, ipStr := range *ips {
 		if ip := net.ParseIP(ipStr).To4(); ip != nil {
 			// block static IPs in pool to avoid dynamic allocation
-			a.BlockIp(ip)
+			offset := IPAddrOffset(ip, a.ipNetwork.IP)
+			if !a.ipNetwork.Contains(ip) || !a.g.mark(int64(offset), idReserved) {
+				logger.CtxLog.Warnf("static ip %v not in the pool %v", ip, a.ipNetwork)
+			}
 		}
 	}
+	a.reportAvailable()
 }
 
-func (a *IPAllocator) BlockIp(ip net.IP) {
-	offset := IPAddrOffset(ip, a.ipNetwork.IP)
-	a.g.block(int64(offset))
+// BlockIp quarantines the IP, it is not handed out until released. Returns false if the IP is not
+// a free address of the pool, an address already allocated or reserved is left as is.
+func (a *IPAllocator) BlockIp(ip net.IP) bool {
+	ip4 := ip.To4()
+	if ip4 == nil || !a.ipNetwork.Contains(ip4) {
+		return false
+	}
+	offset := int64(IPAddrOffset(ip4, a.ipNetwork.IP))
+	if offset < a.g.minValue || offset > a.g.maxValue {
+		return false
+	}
+	if !a.g.quarantineID(offset) {
+		return false
+	}
+	a.reportAvailable()
+	return true
 }
 
 func (a *IPAllocator) Release(imsi string, ip net.IP) {
@@ -130,13 +194,142 @@ func (a *IPAllocator) Release(imsi string, ip net.IP) {
 		}
 	}
 
-	offset := IPAddrOffset(ip, a.ipNetwork.IP)
-	a.g.release(int64(offset))
+	ip4 := ip.To4()
+	if ip4 == nil || !a.ipNetwork.Contains(ip4) {
+		logger.CtxLog.Warnf("released ip %v not in the pool %v", ip, a.ipNetwork)
+		return
+	}
+	a.g.release(int64(IPAddrOffset(ip4, a.ipNetwork.IP)))
+	a.reportAvailable()
 }
 
+// ReleaseWithGrace holds the address for the IMSI during the grace period, quarantined: the next
+// allocation for the IMSI revives it, else it is released when the period expires. The address
+// is released at once without a grace period.
+func (a *IPAllocator) ReleaseWithGrace(imsi string, ip net.IP, grace time.Duration) {
+	if grace <= 0 {
+		a.Release(imsi, ip)
+		return
+	}
+	// Don't release static IPs
+	if a.g.staticIps != nil {
+		staticIps := *a.g.staticIps
+		if ipStr := staticIps[imsi]; ipStr != "" {
+			return
+		}
+	}
+
+	ip4 := ip.To4()
+	if ip4 == nil || !a.ipNetwork.Contains(ip4) {
+		logger.CtxLog.Warnf("released ip %v not in the pool %v", ip, a.ipNetwork)
+		return
+	}
+	hold := &graceHold{ip: slices.Clone(ip4), offset: int64(IPAddrOffset(ip4, a.ipNetwork.IP))}
+	if !a.g.mark(hold.offset, idQuarantined) {
+		logger.CtxLog.Warnf("released ip %v out of the allocated range, not held", ip)
+		return
+	}
+	a.reportAvailable()
+
+	a.graceHoldsLock.Lock()
+	defer a.graceHoldsLock.Unlock()
+	a.graceHolds[imsi] = append(a.graceHolds[imsi], hold)
+	hold.timer = time.AfterFunc(grace, func() { a.expireGraceHold(imsi, hold) })
+}
+
+// reviveGraceHold allocates the oldest address held for the IMSI, nil if none
+func (a *IPAllocator) reviveGraceHold(imsi string) net.IP {
+	a.graceHoldsLock.Lock()
+	defer a.graceHoldsLock.Unlock()
+	holds := a.graceHolds[imsi]
+	if len(holds) == 0 {
+		return nil
+	}
+	hold := holds[0]
+	if len(holds) == 1 {
+		delete(a.graceHolds, imsi)
+	} else {
+		a.graceHolds[imsi] = holds[1:]
+	}
+	hold.timer.Stop()
+	a.g.mark(hold.offset, idAllocated)
+	a.reportAvailable()
+	return hold.ip
+}
+
+// expireGraceHold releases the address held for the IMSI unless it was revived
+func (a *IPAllocator) expireGraceHold(imsi string, hold *graceHold) {
+	a.graceHoldsLock.Lock()
+	defer a.graceHoldsLock.Unlock()
+	holds := a.graceHolds[imsi]
+	i := slices.Index(holds, hold)
+	if i < 0 {
+		return
+	}
+	holds = slices.Delete(holds, i, i+1)
+	if len(holds) == 0 {
+		delete(a.graceHolds, imsi)
+	} else {
+		a.graceHolds[imsi] = holds
+	}
+	logger.CtxLog.Infof("grace period of ip %v held for imsi %s expired", hold.ip, imsi)
+	a.g.release(hold.offset)
+	a.reportAvailable()
+}
+
+// IPPoolUsage is the usage of an IP pool, in number of addresses
+type IPPoolUsage struct {
+	Total       uint64 `json:"total"`
+	Used        uint64 `json:"used"`
+	Reserved    uint64 `json:"reserved"`
+	Quarantined uint64 `json:"quarantined"`
+}
+
+// Usage returns the usage counters of the pool
+func (a *IPAllocator) Usage() IPPoolUsage {
+	return a.g.usage()
+}
+
+// Available returns the number of addresses of the pool that can be allocated
+func (usage IPPoolUsage) Available() uint64 {
+	taken := usage.Used + usage.Reserved + usage.Quarantined
+	if taken >= usage.Total {
+		return 0
+	}
+	return usage.Total - taken
+}
+
+func (a *IPAllocator) reportAvailable() {
+	if a.dnn == "" {
+		return
+	}
+	usage := a.Usage()
+	metrics.SetAvailableUeIPs(a.dnn, a.snssai, usage.Available())
+	metrics.SetUeIPPoolAddresses(a.dnn, a.snssai, usage.Used, usage.Reserved, usage.Quarantined)
+}
+
+// Domain returns the subnet the pool allocates from
+func (a *IPAllocator) Domain() string {
+	return a.ipNetwork.String()
+}
+
+// Contains checks that the address is in the subnet of the pool, allocated or not
+func (a *IPAllocator) Contains(ip net.IP) bool {
+	return a.ipNetwork != nil && a.ipNetwork.Contains(ip)
+}
+
+type idState uint8
+
+const (
+	idAllocated idState = iota
+	idReserved
+	idQuarantined
+)
+
 type _IDPool struct {
 	staticIps *map[string]string // map of [imsi]ip
-	isUsed    map[int64]bool
+	isUsed    map[int64]idState
+	count     [idQuarantined + 1]uint64 // number of ids per state
 	minValue  int64
 	maxValue  int64
 	index     int64
@@ -147,7 +340,7 @@ func newIDPool(minValue int64, maxValue int64) (idPool *_IDPool) {
 	idPool = new(_IDPool)
 	idPool.minValue = minValue
 	idPool.maxValue = maxValue
-	idPool.isUsed = make(map[int64]bool)
+	idPool.isUsed = make(map[int64]idState)
 	idPool.index = 1
 	return
 }
@@ -158,7 +351,8 @@ func (i *_IDPool) allocate() (id int64, err error) {
 
 	for id = i.index; id <= i.maxValue; id++ {
 		if _, exist := i.isUsed[id]; !exist {
-			i.isUsed[id] = true
+			i.isUsed[id] = idAllocated
+			i.count[idAllocated]++
 			i.index = (id % i.maxValue) + 1
 			return id, nil
 		}
@@ -166,7 +360,8 @@ func (i *_IDPool) allocate() (id int64, err error) {
 
 	for id = 1; id < i.index; id++ {
 		if _, exist := i.isUsed[id]; !exist {
-			i.isUsed[id] = true
+			i.isUsed[id] = idAllocated
+			i.count[idAllocated]++
 			i.index = id + 1
 			return id, nil
 		}
@@ -175,14 +370,64 @@ func (i *_IDPool) allocate() (id int64, err error) {
 	return 0, errors.New("no available value range to allocate id")
 }
 
-func (i *_IDPool) block(id int64) {
+// allocateID allocates the id, returns false if it is out of range or not free
+func (i *_IDPool) allocateID(id int64) bool {
 	i.lock.Lock()
 	defer i.lock.Unlock()
-	i.isUsed[id] = true
+	if id < i.minValue || id > i.maxValue {
+		return false
+	}
+	if _, exist := i.isUsed[id]; exist {
+		return false
+	}
+	i.isUsed[id] = idAllocated
+	i.count[idAllocated]++
+	return true
+}
+
+// mark sets the state of the id, returns false if it is out of range
+func (i *_IDPool) mark(id int64, state idState) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if id < i.minValue || id > i.maxValue {
+		return false
+	}
+	if prev, exist := i.isUsed[id]; exist {
+		i.count[prev]--
+	}
+	i.isUsed[id] = state
+	i.count[state]++
+	return true
+}
+
+// quarantineID quarantines the id, returns false if it is not free
+func (i *_IDPool) quarantineID(id int64) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if _, exist := i.isUsed[id]; exist {
+		return false
+	}
+	i.isUsed[id] = idQuarantined
+	i.count[idQuarantined]++
+	return true
 }
 
 func (i *_IDPool) release(id int64) {
 	i.lock.Lock()
 	defer i.lock.Unlock()
-	delete(i.isUsed, id)
+	if state, exist := i.isUsed[id]; exist {
+		i.count[state]--
+		delete(i.isUsed, id)
+	}
+}
+
+func (i *_IDPool) usage() IPPoolUsage {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return IPPoolUsage{
+		Total:       uint64(i.maxValue - i.minValue + 1),
+		Used:        i.count[idAllocated],
+		Reserved:    i.count[idReserved],
+		Quarantined: i.count[idQuarantined],
+	}
 }
@@ -7,8 +7,13 @@ package context_test
 import (
 	"net"
 	"testing"
+	"time"
 
+	"github.com/omec-project/openapi/models"
 	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/smferrors"
 )
 
 func TestIPPoolAlloc(t *testing.T) {
@@ -94,3 +99,280 @@ func TestIPPoolAllocLeastRecentlyUsed(t *testing.T) {
 		t.Errorf("ip1 %v & ip2 %v same ", ip1, ip2)
 	}
 }
+
+func TestIPPoolReleaseWithGrace(t *testing.T) {
+	allocator, err := smf_context.NewIPAllocator("192.168.1.0/30")
+	if err != nil {
+		t.Fatalf("failed to allocate pool %v", err)
+	}
+
+	ip1, err := allocator.Allocate("imsi-1")
+	if err != nil {
+		t.Fatalf("failed to allocate pool %v", err)
+	}
+	allocator.ReleaseWithGrace("imsi-1", ip1, time.Minute)
+	if usage := allocator.Usage(); usage.Quarantined != 1 || usage.Used != 0 {
+		t.Errorf("expected the address held during the grace period, usage %+v", usage)
+	}
+
+	// the held address is not handed out to another UE
+	ip2, err := allocator.Allocate("imsi-2")
+	if err != nil {
+		t.Fatalf("failed to allocate pool %v", err)
+	}
+	if ip2.Equal(ip1) {
+		t.Errorf("address %v held for imsi-1 allocated to imsi-2", ip1)
+	}
+
+	// the session re-established within the grace period gets its address back
+	revived, err := allocator.Allocate("imsi-1")
+	if err != nil {
+		t.Fatalf("failed to revive the address: %v", err)
+	}
+	if !revived.Equal(ip1) {
+		t.Errorf("expected address %v revived, got %v", ip1, revived)
+	}
+	if usage := allocator.Usage(); usage.Quarantined != 0 || usage.Used != 2 {
+		t.Errorf("expected the revived address allocated, usage %+v", usage)
+	}
+
+	// the address is freed when the grace period expires
+	allocator.ReleaseWithGrace("imsi-1", ip1, 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if usage := allocator.Usage(); usage.Quarantined != 0 || usage.Used != 1 {
+		t.Errorf("expected the address freed after the grace period, usage %+v", usage)
+	}
+	ip3, err := allocator.Allocate("imsi-3")
+	if err != nil {
+		t.Fatalf("failed to allocate the expired address: %v", err)
+	}
+	if !ip3.Equal(ip1) {
+		t.Errorf("expected address %v allocated after the grace period, got %v", ip1, ip3)
+	}
+	if _, err = allocator.Allocate("imsi-1"); err == nil {
+		t.Error("expected no address left to revive after the grace period")
+	}
+}
+
+func TestIPPoolReleaseOutOfRange(t *testing.T) {
+	allocator, err := smf_context.NewIPAllocator("192.168.1.0/30")
+	if err != nil {
+		t.Fatalf("failed to allocate pool %v", err)
+	}
+	allocator.ReserveStaticIps(&map[string]string{"imsi-9": "10.0.0.9"})
+
+	ip1, err := allocator.Allocate("imsi-1")
+	if err != nil {
+		t.Fatalf("failed to allocate pool %v", err)
+	}
+	// the 16-byte form of the address releases it
+	allocator.ReleaseWithGrace("imsi-1", ip1.To16(), time.Minute)
+	if usage := allocator.Usage(); usage.Quarantined != 1 || usage.Used != 0 {
+		t.Errorf("expected the address held during the grace period, usage %+v", usage)
+	}
+
+	// addresses out of the pool leave the counters as is
+	allocator.ReleaseWithGrace("imsi-2", net.ParseIP("192.168.2.1"), time.Minute)
+	allocator.ReleaseWithGrace("imsi-3", net.ParseIP("192.168.1.3"), time.Minute)
+	allocator.Release("imsi-4", net.ParseIP("10.0.0.1"))
+	usage := allocator.Usage()
+	if usage.Quarantined != 1 || usage.Used != 0 || usage.Reserved != 0 {
+		t.Errorf("expected out of range addresses ignored, usage %+v", usage)
+	}
+	if usage.Available() != usage.Total-1 {
+		t.Errorf("expected %d addresses available, got %d", usage.Total-1, usage.Available())
+	}
+
+	if available := (smf_context.IPPoolUsage{Total: 2, Used: 2, Quarantined: 1}).Available(); available != 0 {
+		t.Errorf("expected no address available, got %d", available)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	allocator, err := smf_context.NewIPAllocator("10.10.0.0/24")
+	if err != nil {
+		t.Fatalf("failed to allocate pool %v", err)
+	}
+	allocator.ReserveStaticIps(&map[string]string{
+		"imsi-1": "10.10.0.200",
+		"imsi-2": "10.10.0.201",
+	})
+	if !allocator.BlockIp(net.ParseIP("10.10.0.250").To4()) {
+		t.Error("expected the address of the pool quarantined")
+	}
+	// addresses out of the pool, and the broadcast address, are not quarantined
+	for _, ip := range []string{"10.11.0.1", "10.10.0.255"} {
+		if allocator.BlockIp(net.ParseIP(ip).To4()) {
+			t.Errorf("expected %s not quarantined", ip)
+		}
+	}
+
+	var allocAddresses []net.IP
+	for i := 0; i < 5; i++ {
+		ip, err := allocator.Allocate("")
+		if err != nil {
+			t.Fatalf("failed to allocate address %v", err)
+		}
+		allocAddresses = append(allocAddresses, ip)
+	}
+	allocator.Release("", allocAddresses[0])
+	// static IP allocation does not consume the pool
+	if _, err = allocator.Allocate("imsi-1"); err != nil {
+		t.Fatalf("failed to allocate static address %v", err)
+	}
+
+	smfSelf := smf_context.SMF_Self()
+	contexttest.SetSnssaiInfos(t, []smf_context.SnssaiSmfInfo{
+		{
+			Snssai: smf_context.SNssai{Sst: 1, Sd: "010203"},
+			DnnInfos: map[string]*smf_context.SnssaiSmfDnnInfo{
+				"internet": {UeIPAllocator: allocator},
+			},
+		},
+	})
+
+	stats := smfSelf.PoolStats()
+	if len(stats) != 1 {
+		t.Fatalf("expected stats of 1 pool, got %v", len(stats))
+	}
+
+	expected := smf_context.IPPoolStats{
+		Snssai: smf_context.SNssai{Sst: 1, Sd: "010203"},
+		Dnn:    "internet",
+		Domain: "10.10.0.0/24",
+		IPPoolUsage: smf_context.IPPoolUsage{
+			Total:       254,
+			Used:        4,
+			Reserved:    2,
+			Quarantined: 1,
+		},
+	}
+	if stats[0] != expected {
+		t.Errorf("expected pool stats %+v, got %+v", expected, stats[0])
+	}
+}
+
+func addSlicesForPoolTest(t *testing.T, slices []factory.SnssaiInfoItem) {
+	t.Helper()
+	enterpriseList := map[string]string{}
+	factory.UpdatedSmfConfig = factory.UpdateSmfConfig{
+		AddSNssaiInfo:  &slices,
+		EnterpriseList: &enterpriseList,
+	}
+	smf_context.ProcessConfigUpdate()
+}
+
+func setupSlicePoolTest(t *testing.T) {
+	t.Helper()
+	contexttest.ClearConfig(t)
+}
+
+func TestIPPoolSliceIsolation(t *testing.T) {
+	setupSlicePoolTest(t)
+
+	slice1 := models.Snssai{Sst: 1, Sd: "010203"}
+	slice2 := models.Snssai{Sst: 1, Sd: "112233"}
+	addSlicesForPoolTest(t, []factory.SnssaiInfoItem{
+		{SNssai: &slice1, DnnInfos: []factory.SnssaiDnnInfoItem{{Dnn: "internet", UESubnet: "10.1.0.0/30"}}},
+		{SNssai: &slice2, DnnInfos: []factory.SnssaiDnnInfoItem{{Dnn: "internet", UESubnet: "10.2.0.0/30"}}},
+	})
+
+	dnnInfo1 := smf_context.RetrieveDnnInformation(slice1, "internet")
+	dnnInfo2 := smf_context.RetrieveDnnInformation(slice2, "internet")
+	if dnnInfo1 == nil || dnnInfo2 == nil {
+		t.Fatalf("DNN information of the slices not found")
+	}
+	if dnnInfo1.UeIPAllocator == dnnInfo2.UeIPAllocator {
+		t.Fatalf("slices share the UE IP pool of DNN internet")
+	}
+
+	// exhaust the pool of slice1, a /30 holds 2 addresses
+	for i := 0; i < 2; i++ {
+		if _, err := dnnInfo1.UeIPAllocator.Allocate(""); err != nil {
+			t.Fatalf("failed to allocate from slice1 pool: %v", err)
+		}
+	}
+	if _, err := dnnInfo1.UeIPAllocator.Allocate(""); err == nil {
+		t.Errorf("expected slice1 pool to be exhausted")
+	} else if code := smferrors.CodeOf(err); code != smferrors.ErrCodeIPPoolExhausted {
+		t.Errorf("expected error code %s, got %q", smferrors.ErrCodeIPPoolExhausted, code)
+	}
+
+	ip, err := dnnInfo2.UeIPAllocator.Allocate("")
+	if err != nil {
+		t.Fatalf("slice2 pool affected by slice1 exhaustion: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.2.0.1")) {
+		t.Errorf("expected 10.2.0.1 from slice2 pool, got %v", ip)
+	}
+}
+
+func TestIPPoolSliceMultiplePlmns(t *testing.T) {
+	setupSlicePoolTest(t)
+
+	slice1 := models.Snssai{Sst: 1, Sd: "010203"}
+	slice2 := models.Snssai{Sst: 1, Sd: "112233"}
+	addSlicesForPoolTest(t, []factory.SnssaiInfoItem{
+		{
+			SNssai:   &slice1,
+			PlmnId:   models.PlmnId{Mcc: "208", Mnc: "93"},
+			DnnInfos: []factory.SnssaiDnnInfoItem{{Dnn: "internet", UESubnet: "10.1.0.0/30"}},
+		},
+		{SNssai: &slice2, DnnInfos: []factory.SnssaiDnnInfoItem{{Dnn: "internet", UESubnet: "10.2.0.0/30"}}},
+	})
+	ip, err := smf_context.RetrieveDnnInformation(slice1, "internet").UeIPAllocator.Allocate("")
+	if err != nil {
+		t.Fatalf("failed to allocate from slice1 pool: %v", err)
+	}
+
+	// the same slice configured for a second PLMN keeps its pool
+	addSlicesForPoolTest(t, []factory.SnssaiInfoItem{
+		{
+			SNssai:   &slice1,
+			PlmnId:   models.PlmnId{Mcc: "001", Mnc: "01"},
+			DnnInfos: []factory.SnssaiDnnInfoItem{{Dnn: "internet", UESubnet: "10.1.0.0/30"}},
+		},
+	})
+	allocator := smf_context.RetrieveDnnInformation(slice1, "internet").UeIPAllocator
+	next, err := allocator.Allocate("")
+	if err != nil {
+		t.Fatalf("failed to allocate from slice1 pool: %v", err)
+	}
+	if next.Equal(ip) {
+		t.Errorf("address %v allocated twice in slice1 pool", ip)
+	}
+	if _, err = allocator.Allocate(""); err == nil {
+		t.Errorf("expected slice1 pool to be exhausted")
+	}
+
+	if _, err = smf_context.RetrieveDnnInformation(slice2, "internet").UeIPAllocator.Allocate(""); err != nil {
+		t.Errorf("slice2 pool affected by slice1: %v", err)
+	}
+}
+
+func TestIPPoolAvailableGauge(t *testing.T) {
+	setupSlicePoolTest(t)
+
+	slice := models.Snssai{Sst: 1, Sd: "010203"}
+	addSlicesForPoolTest(t, []factory.SnssaiInfoItem{
+		{SNssai: &slice, DnnInfos: []factory.SnssaiDnnInfoItem{{Dnn: "iot", UESubnet: "10.3.0.0/29"}}},
+	})
+	allocator := smf_context.RetrieveDnnInformation(slice, "iot").UeIPAllocator
+
+	if available := gatheredMetric(t, "smf_available_ue_ips", "iot", "1-010203"); available != 6 {
+		t.Errorf("expected 6 available IPs, got %v", available)
+	}
+
+	ip, err := allocator.Allocate("")
+	if err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	if available := gatheredMetric(t, "smf_available_ue_ips", "iot", "1-010203"); available != 5 {
+		t.Errorf("expected 5 available IPs after allocation, got %v", available)
+	}
+
+	allocator.Release("", ip)
+	if available := gatheredMetric(t, "smf_available_ue_ips", "iot", "1-010203"); available != 6 {
+		t.Errorf("expected 6 available IPs after release, got %v", available)
+	}
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"net"
+
+	"github.com/omec-project/smf/logger"
+	"go.uber.org/zap"
+)
+
+// events of the UE IP audit log
+const (
+	UeIPAllocated = "allocate"
+	UeIPReleased  = "release"
+)
+
+// allocators of the UE IPs of the audit log
+const (
+	UeIPSourceSMF = "smf"
+	UeIPSourceUPF = "upf"
+)
+
+// AuditUeIP records the allocation or release of the UE IP of the session in the audit log
+func (smContext *SMContext) AuditUeIP(event string, ip net.IP, source string) {
+	logger.AuditLog().Info("UE IP "+event,
+		zap.String("event", event),
+		zap.String("supi", smContext.Supi),
+		zap.String("ip", ip.String()),
+		zap.String("dnn", smContext.Dnn),
+		zap.Int32("pduSessionId", smContext.PDUSessionID),
+		zap.String("source", source))
+}
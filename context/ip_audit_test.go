@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func observeAuditLog(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zapcore.InfoLevel)
+	origAuditLog := logger.SetAuditLog(zap.New(core))
+	t.Cleanup(func() { logger.SetAuditLog(origAuditLog) })
+	return logs
+}
+
+func TestAuditUeIPAllocateRelease(t *testing.T) {
+	logs := observeAuditLog(t)
+	allocator, err := context.NewIPAllocator("10.62.0.0/30")
+	require.NoError(t, err)
+	smContext := &context.SMContext{
+		Supi:          "imsi-208930000000021",
+		Dnn:           "internet",
+		PDUSessionID:  3,
+		SubPduSessLog: logger.PduSessLog,
+		DNNInfo:       &context.SnssaiSmfDnnInfo{UeIPAllocator: allocator},
+	}
+
+	ip, err := smContext.AllocateUeIP(nil)
+	require.NoError(t, err)
+	smContext.PDUAddress = &context.UeIpAddr{Ip: ip}
+	require.NoError(t, smContext.ReleaseUeIpAddr())
+	// released once
+	require.NoError(t, smContext.ReleaseUeIpAddr())
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+	for i, event := range []string{context.UeIPAllocated, context.UeIPReleased} {
+		require.Equal(t, map[string]interface{}{
+			"event":        event,
+			"supi":         "imsi-208930000000021",
+			"ip":           ip.String(),
+			"dnn":          "internet",
+			"pduSessionId": int32(3),
+			"source":       context.UeIPSourceSMF,
+		}, entries[i].ContextMap())
+	}
+}
+
+func TestAuditUpfProvidedUeIPRelease(t *testing.T) {
+	logs := observeAuditLog(t)
+	smContext := &context.SMContext{
+		Supi:       "imsi-208930000000022",
+		Dnn:        "internet",
+		PDUAddress: &context.UeIpAddr{Ip: net.ParseIP("10.63.0.9"), UpfProvided: true},
+	}
+
+	require.NoError(t, smContext.ReleaseUeIpAddr())
+	entries := logs.FilterField(zap.String("event", context.UeIPReleased)).All()
+	require.Len(t, entries, 1)
+	require.Equal(t, "10.63.0.9", entries[0].ContextMap()["ip"])
+	require.Equal(t, context.UeIPSourceUPF, entries[0].ContextMap()["source"])
+}
+
+func TestUpfProvidedUeIPQuarantined(t *testing.T) {
+	logs := observeAuditLog(t)
+	allocator, err := context.NewIPAllocator("10.64.0.0/30")
+	require.NoError(t, err)
+	smContext := &context.SMContext{
+		Supi:          "imsi-208930000000023",
+		Dnn:           "internet",
+		SubPduSessLog: logger.PduSessLog,
+		PDUAddress:    &context.UeIpAddr{},
+		DNNInfo:       &context.SnssaiSmfDnnInfo{UeIPAllocator: allocator},
+	}
+
+	// the address of the pool provided by the UPF is not handed out by the SMF
+	smContext.SetUpfProvidedUeIp(net.ParseIP("10.64.0.1").To4())
+	require.True(t, smContext.PDUAddress.UpfProvided)
+	require.Equal(t, uint64(1), allocator.Usage().Quarantined)
+	ip, err := allocator.Allocate("")
+	require.NoError(t, err)
+	require.Equal(t, "10.64.0.2", ip.String())
+	_, err = allocator.Allocate("")
+	require.Error(t, err)
+
+	// until the session is released
+	require.NoError(t, smContext.ReleaseUeIpAddr())
+	require.Zero(t, allocator.Usage().Quarantined)
+	ip, err = allocator.Allocate("")
+	require.NoError(t, err)
+	require.Equal(t, "10.64.0.1", ip.String())
+
+	// an address out of the pool is not quarantined
+	smContext.SetUpfProvidedUeIp(net.ParseIP("10.63.0.9").To4())
+	require.Zero(t, allocator.Usage().Quarantined)
+	require.Len(t, logs.FilterField(zap.String("source", context.UeIPSourceUPF)).All(), 3)
+}
+
+func TestUpfProvidedUeIPInUse(t *testing.T) {
+	allocator, err := context.NewIPAllocator("10.65.0.0/30")
+	require.NoError(t, err)
+	dnnInfo := &context.SnssaiSmfDnnInfo{UeIPAllocator: allocator}
+	allocated := &context.SMContext{
+		Supi:          "imsi-208930000000024",
+		Dnn:           "internet",
+		SubPduSessLog: logger.PduSessLog,
+		DNNInfo:       dnnInfo,
+	}
+	ip, err := allocated.AllocateUeIP(nil)
+	require.NoError(t, err)
+	allocated.PDUAddress = &context.UeIpAddr{Ip: ip}
+
+	// the UPF provides another session with the address the SMF allocated
+	upfProvided := &context.SMContext{
+		Supi:          "imsi-208930000000025",
+		Dnn:           "internet",
+		SubPduSessLog: logger.PduSessLog,
+		PDUAddress:    &context.UeIpAddr{},
+		DNNInfo:       dnnInfo,
+	}
+	upfProvided.SetUpfProvidedUeIp(ip)
+	require.True(t, upfProvided.PDUAddress.UpfProvided)
+	require.False(t, upfProvided.PDUAddress.Quarantined)
+	require.Equal(t, uint64(1), allocator.Usage().Used)
+	require.Zero(t, allocator.Usage().Quarantined)
+
+	// the address stays allocated to the first UE when the other session is released
+	require.NoError(t, upfProvided.ReleaseUeIpAddr())
+	require.Equal(t, uint64(1), allocator.Usage().Used)
+	next, err := allocator.Allocate("")
+	require.NoError(t, err)
+	require.NotEqual(t, ip.String(), next.String())
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	// discarded unless enabled by the configuration
+	require.False(t, logger.AuditLog().Core().Enabled(zapcore.InfoLevel))
+}
@@ -30,10 +30,14 @@ func SetupNFProfile(config *factory.Config) {
 	nfSetupTime := time.Now()
 
 	// set NfServiceVersion
+	apiFullVersion := fmt.Sprintf("https://%s:%d/nsmf-pdusession/v1", SMF_Self().RegisterIPv4, SMF_Self().SBIPort)
+	if SMF_Self().AdvertisedURI != "" {
+		apiFullVersion = SMF_Self().AdvertisedURI + "/nsmf-pdusession/v1"
+	}
 	NfServiceVersion = &[]models.NfServiceVersion{
 		{
 			ApiVersionInUri: "v1",
-			ApiFullVersion:  fmt.Sprintf("https://%s:%d/nsmf-pdusession/v1", SMF_Self().RegisterIPv4, SMF_Self().SBIPort),
+			ApiFullVersion:  apiFullVersion,
 			Expiry:          &nfSetupTime,
 		},
 	}
@@ -52,7 +56,7 @@ func SetupNFProfile(config *factory.Config) {
 			Versions:          NfServiceVersion,
 			Scheme:            models.UriScheme_HTTPS,
 			NfServiceStatus:   models.NfServiceStatus_REGISTERED,
-			ApiPrefix:         fmt.Sprintf("%s://%s:%d", SMF_Self().URIScheme, SMF_Self().RegisterIPv4, SMF_Self().SBIPort),
+			ApiPrefix:         SMF_Self().SBIUri(),
 			AllowedPlmns:      SmfPlmnConfig(),
 		})
 	}
@@ -86,6 +86,18 @@ func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error)
 	}
 	resourceSetupRequestTransfer.ProtocolIEs.List = append(resourceSetupRequestTransfer.ProtocolIEs.List, ie)
 
+	// Security Indication(optional) TS 38.413 9.3.1.27
+	if ctx.UPIntegrityProtection != "" {
+		ie = ngapType.PDUSessionResourceSetupRequestTransferIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDSecurityIndication
+		ie.Criticality.Value = ngapType.CriticalityPresentReject
+		ie.Value = ngapType.PDUSessionResourceSetupRequestTransferIEsValue{
+			Present:            ngapType.PDUSessionResourceSetupRequestTransferIEsPresentSecurityIndication,
+			SecurityIndication: buildSecurityIndication(ctx.UPIntegrityProtection),
+		}
+		resourceSetupRequestTransfer.ProtocolIEs.List = append(resourceSetupRequestTransfer.ProtocolIEs.List, ie)
+	}
+
 	// Get Qos Flows
 	var qosAddFlows map[string]*models.QosData
 
@@ -350,10 +362,27 @@ func BuildPathSwitchRequestAcknowledgeTransfer(ctx *SMContext) ([]byte, error) {
 	}
 
 	// Security Indication(optional) TS 38.413 9.3.1.27
-	pathSwitchRequestAcknowledgeTransfer.SecurityIndication = new(ngapType.SecurityIndication)
-	securityIndication := pathSwitchRequestAcknowledgeTransfer.SecurityIndication
-	// TODO: use real value
-	securityIndication.IntegrityProtectionIndication.Value = ngapType.IntegrityProtectionIndicationPresentNotNeeded
+	pathSwitchRequestAcknowledgeTransfer.SecurityIndication = buildSecurityIndication(ctx.UPIntegrityProtection)
+
+	if buf, err := aper.MarshalWithParams(pathSwitchRequestAcknowledgeTransfer, "valueExt"); err != nil {
+		return nil, err
+	} else {
+		return buf, nil
+	}
+}
+
+// buildSecurityIndication maps the UP integrity protection policy of the session
+// to the NGAP Security Indication, defaulting to not needed
+func buildSecurityIndication(upIntegrity models.UpIntegrity) *ngapType.SecurityIndication {
+	securityIndication := new(ngapType.SecurityIndication)
+	switch upIntegrity {
+	case models.UpIntegrity_REQUIRED:
+		securityIndication.IntegrityProtectionIndication.Value = ngapType.IntegrityProtectionIndicationPresentRequired
+	case models.UpIntegrity_PREFERRED:
+		securityIndication.IntegrityProtectionIndication.Value = ngapType.IntegrityProtectionIndicationPresentPreferred
+	default:
+		securityIndication.IntegrityProtectionIndication.Value = ngapType.IntegrityProtectionIndicationPresentNotNeeded
+	}
 	// TODO: use real value
 	securityIndication.ConfidentialityProtectionIndication.Value = ngapType.ConfidentialityProtectionIndicationPresentNotNeeded
 
@@ -364,12 +393,7 @@ func BuildPathSwitchRequestAcknowledgeTransfer(ctx *SMContext) ([]byte, error) {
 		// TODO: use real value
 		securityIndication.MaximumIntegrityProtectedDataRateUL.Value = ngapType.MaximumIntegrityProtectedDataRatePresentBitrate64kbs
 	}
-
-	if buf, err := aper.MarshalWithParams(pathSwitchRequestAcknowledgeTransfer, "valueExt"); err != nil {
-		return nil, err
-	} else {
-		return buf, nil
-	}
+	return securityIndication
 }
 
 func BuildPathSwitchRequestUnsuccessfulTransfer(causePresent int, causeValue aper.Enumerated) (buf []byte, err error) {
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/aper"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func newSetupTransferSMContext(dnn string) *context.SMContext {
+	dpNode := &context.DataPathNode{
+		UPF: &context.UPF{
+			N3Interfaces: []context.UPFInterfaceInfo{
+				{IPv4EndPointAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			},
+		},
+		UpLinkTunnel: &context.GTPTunnel{TEID: 1},
+	}
+	smContext := &context.SMContext{
+		Dnn:                    dnn,
+		SelectedPDUSessionType: nasMessage.PDUSessionTypeIPv4,
+		Tunnel: &context.UPTunnel{
+			DataPathPool: context.DataPathPool{
+				1: {IsDefaultPath: true, FirstDPNode: dpNode},
+			},
+		},
+	}
+	smContext.SmPolicyData.SmCtxtSessionRules.ActiveRule = &models.SessionRule{
+		AuthSessAmbr: &models.Ambr{Uplink: "1 Gbps", Downlink: "1 Gbps"},
+	}
+	return smContext
+}
+
+func getSecurityIndication(t *testing.T, buf []byte) *ngapType.SecurityIndication {
+	transfer := ngapType.PDUSessionResourceSetupRequestTransfer{}
+	err := aper.UnmarshalWithParams(buf, &transfer, "valueExt")
+	require.NoError(t, err)
+	for _, ie := range transfer.ProtocolIEs.List {
+		if ie.Id.Value == ngapType.ProtocolIEIDSecurityIndication {
+			return ie.Value.SecurityIndication
+		}
+	}
+	return nil
+}
+
+func TestBuildPDUSessionResourceSetupRequestTransferSecurityIndication(t *testing.T) {
+	smfContext := context.SMF_Self()
+	smfContext.UpSecurityInfo = &[]factory.UpSecurityInfo{
+		{Dnn: "required-dnn", UpIntegrity: factory.UpIntegrityRequired},
+		{Dnn: "preferred-dnn", UpIntegrity: factory.UpIntegrityPreferred},
+		{Dnn: "not-needed-dnn", UpIntegrity: factory.UpIntegrityNotNeeded},
+	}
+	defer func() { smfContext.UpSecurityInfo = nil }()
+
+	testCases := []struct {
+		name         string
+		dnn          string
+		expectIE     bool
+		expected     aper.Enumerated
+		expectMaxIPD bool
+	}{
+		{
+			name:         "DNN with required policy",
+			dnn:          "required-dnn",
+			expectIE:     true,
+			expected:     ngapType.IntegrityProtectionIndicationPresentRequired,
+			expectMaxIPD: true,
+		},
+		{
+			name:         "DNN with preferred policy",
+			dnn:          "preferred-dnn",
+			expectIE:     true,
+			expected:     ngapType.IntegrityProtectionIndicationPresentPreferred,
+			expectMaxIPD: true,
+		},
+		{
+			name:     "DNN with not-needed policy",
+			dnn:      "not-needed-dnn",
+			expectIE: true,
+			expected: ngapType.IntegrityProtectionIndicationPresentNotNeeded,
+		},
+		{
+			name:     "DNN without policy",
+			dnn:      "internet",
+			expectIE: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := newSetupTransferSMContext(tc.dnn)
+			smContext.UPIntegrityProtection = smfContext.GetDnnUpIntegrityProtection(tc.dnn)
+
+			buf, err := context.BuildPDUSessionResourceSetupRequestTransfer(smContext)
+			require.NoError(t, err)
+
+			securityIndication := getSecurityIndication(t, buf)
+			if !tc.expectIE {
+				require.Nil(t, securityIndication)
+				return
+			}
+			require.NotNil(t, securityIndication)
+			require.Equal(t, tc.expected, securityIndication.IntegrityProtectionIndication.Value)
+			require.Equal(t, ngapType.ConfidentialityProtectionIndicationPresentNotNeeded,
+				securityIndication.ConfidentialityProtectionIndication.Value)
+			require.Equal(t, tc.expectMaxIPD, securityIndication.MaximumIntegrityProtectedDataRateUL != nil)
+		})
+	}
+}
@@ -35,6 +35,7 @@ func HandlePDUSessionResourceSetupResponseTransfer(b []byte, ctx *SMContext) (er
 
 	teid := binary.BigEndian.Uint32(gtpTunnel.GTPTEID.Value)
 
+	ctx.trackANTunnel(gtpTunnel.TransportLayerAddress.Value.Bytes, teid)
 	ctx.Tunnel.ANInformation.IPAddress = gtpTunnel.TransportLayerAddress.Value.Bytes
 	ctx.Tunnel.ANInformation.TEID = teid
 
@@ -55,12 +56,14 @@ func HandlePDUSessionResourceSetupResponseTransfer(b []byte, ctx *SMContext) (er
 	return nil
 }
 
-func HandlePDUSessionResourceSetupUnsuccessfulTransfer(b []byte, ctx *SMContext) (err error) {
+// HandlePDUSessionResourceSetupUnsuccessfulTransfer returns the cause of the failure of the
+// radio bearer of the session reported by the AN
+func HandlePDUSessionResourceSetupUnsuccessfulTransfer(b []byte) (*ngapType.Cause, error) {
 	resourceSetupUnsuccessfulTransfer := ngapType.PDUSessionResourceSetupUnsuccessfulTransfer{}
 
-	err = aper.UnmarshalWithParams(b, &resourceSetupUnsuccessfulTransfer, "valueExt")
+	err := aper.UnmarshalWithParams(b, &resourceSetupUnsuccessfulTransfer, "valueExt")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	switch resourceSetupUnsuccessfulTransfer.Cause.Present {
@@ -77,16 +80,14 @@ func HandlePDUSessionResourceSetupUnsuccessfulTransfer(b []byte, ctx *SMContext)
 		logger.PduSessLog.Warnf("PDU Session Resource Setup Unsuccessful by Protocol[%d]",
 			resourceSetupUnsuccessfulTransfer.Cause.Protocol.Value)
 	case ngapType.CausePresentMisc:
-		logger.PduSessLog.Warnf("PDU Session Resource Setup Unsuccessful by Protocol[%d]",
+		logger.PduSessLog.Warnf("PDU Session Resource Setup Unsuccessful by Misc[%d]",
 			resourceSetupUnsuccessfulTransfer.Cause.Misc.Value)
 	case ngapType.CausePresentChoiceExtensions:
 		logger.PduSessLog.Warnf("PDU Session Resource Setup Unsuccessful by Protocol[%v]",
 			resourceSetupUnsuccessfulTransfer.Cause.ChoiceExtensions)
 	}
 
-	ctx.UpCnxState = models.UpCnxState_ACTIVATING
-
-	return nil
+	return &resourceSetupUnsuccessfulTransfer.Cause, nil
 }
 
 func HandlePathSwitchRequestTransfer(b []byte, ctx *SMContext) error {
@@ -104,6 +105,7 @@ func HandlePathSwitchRequestTransfer(b []byte, ctx *SMContext) error {
 
 	teid := binary.BigEndian.Uint32(gtpTunnel.GTPTEID.Value)
 
+	ctx.trackANTunnel(gtpTunnel.TransportLayerAddress.Value.Bytes, teid)
 	ctx.Tunnel.ANInformation.IPAddress = gtpTunnel.TransportLayerAddress.Value.Bytes
 	ctx.Tunnel.ANInformation.TEID = teid
 
@@ -4,8 +4,8 @@
 package context
 
 import (
-	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/omec-project/smf/logger"
@@ -22,8 +22,6 @@ type NodeID struct {
 	NodeIdType  uint8 // 0x00001111
 }
 
-var dnsHostIpCache map[string]net.IP
-
 func NewNodeID(nodeID string) *NodeID {
 	ip := net.ParseIP(nodeID)
 	if ip == nil {
@@ -44,32 +42,50 @@ func NewNodeID(nodeID string) *NodeID {
 	}
 }
 
+// String returns the FQDN or the IP address of the Node ID
+func (n *NodeID) String() string {
+	if n.NodeIdType == NodeIdTypeFqdn {
+		return string(n.NodeIdValue)
+	}
+	return net.IP(n.NodeIdValue).String()
+}
+
+// Equal reports whether both Node IDs designate the same node. A Node ID of FQDN type matches
+// a Node ID of IP address type resolving to the same address, as a node may identify itself
+// with either type.
+func (n *NodeID) Equal(other NodeID) bool {
+	if n.NodeIdType == NodeIdTypeFqdn && other.NodeIdType == NodeIdTypeFqdn {
+		return strings.EqualFold(string(n.NodeIdValue), string(other.NodeIdValue))
+	}
+	if n.NodeIdType != NodeIdTypeFqdn && other.NodeIdType != NodeIdTypeFqdn {
+		return net.IP(n.NodeIdValue).Equal(net.IP(other.NodeIdValue))
+	}
+	ip := n.ResolveNodeIdToIp()
+	if ip == nil || ip.IsUnspecified() {
+		// an unresolved FQDN matches no address
+		return false
+	}
+	logger.CtxLog.Debugf("match Node ID [%s] resolved to [%s] with Node ID [%s]", n.String(), ip, other.String())
+	return ip.Equal(other.ResolveNodeIdToIp())
+}
+
 func (n *NodeID) ResolveNodeIdToIp() net.IP {
 	switch n.NodeIdType {
 	case NodeIdTypeIpv4Address, NodeIdTypeIpv6Address:
 		return n.NodeIdValue
 	case NodeIdTypeFqdn:
-		if ip, err := getDnsHostIp(string(n.NodeIdValue)); err != nil {
-			logger.CtxLog.Warnf("host [%v] not found in smf dns cache ", string(n.NodeIdValue))
-			if ns, err := net.LookupHost(string(n.NodeIdValue)); err != nil {
-				logger.CtxLog.Warnf("host lookup failed: %+v", err)
-				return net.IPv4zero
-			} else {
-				logger.CtxLog.Infof("host [%v] dns resolved, updating smf dns cache ", string(n.NodeIdValue))
-				InsertDnsHostIp(string(n.NodeIdValue), net.ParseIP(ns[0]))
-				return net.ParseIP(ns[0])
-			}
-		} else {
-			logger.CtxLog.Debugf("host [%v] found in smf dns cache ", string(n.NodeIdValue))
-			return ip
+		ip, err := dnsCache.Resolve(string(n.NodeIdValue))
+		if err != nil {
+			logger.CtxLog.Warnf("host lookup failed: %+v", err)
+			return net.IPv4zero
 		}
+		return ip
 	default:
 		return net.IPv4zero
 	}
 }
 
 func init() {
-	dnsHostIpCache = make(map[string]net.IP)
 	ticker := time.NewTicker(time.Minute)
 
 	go func() {
@@ -80,32 +96,11 @@ func init() {
 	}()
 }
 
+// RefreshDnsHostIpCache resolves again the UPF hostnames
 func RefreshDnsHostIpCache() {
-	for hostName := range dnsHostIpCache {
-		logger.CtxLog.Debugf("refreshing DNS for host [%v] ", hostName)
-		if ns, err := net.LookupHost(hostName); err != nil {
-			logger.CtxLog.Warnf("host lookup failed: %+v", err)
-			deleteDnsHost(hostName)
-			continue
-		} else if !dnsHostIpCache[hostName].Equal(net.ParseIP(ns[0])) {
-			logger.CtxLog.Infof("smf dns cache updated for host [%v]: [%v] ", hostName, net.ParseIP(ns[0]).String())
-			dnsHostIpCache[hostName] = net.ParseIP(ns[0])
-		}
-	}
-}
-
-func getDnsHostIp(hostName string) (net.IP, error) {
-	if ip, ok := dnsHostIpCache[hostName]; !ok {
-		return nil, fmt.Errorf("host [%v] not found in smf dns cache", hostName)
-	} else {
-		return ip, nil
-	}
+	dnsCache.Refresh()
 }
 
 func InsertDnsHostIp(hostName string, ip net.IP) {
-	dnsHostIpCache[hostName] = ip
-}
-
-func deleteDnsHost(hostName string) {
-	delete(dnsHostIpCache, hostName)
+	dnsCache.Insert(hostName, ip)
 }
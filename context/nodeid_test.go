@@ -72,3 +72,39 @@ func TestResolveNodeIdToIpForFqdn(t *testing.T) {
 		t.Errorf("expected 1.2.3.4 got %v", ip)
 	}
 }
+
+func TestNewNodeIDInvalidIpFqdn(t *testing.T) {
+	nodeID := context.NewNodeID("bad:ip")
+
+	if nodeID.NodeIdType != context.NodeIdTypeFqdn {
+		t.Errorf("expected NodeIdType to be %d, got %d", context.NodeIdTypeFqdn, nodeID.NodeIdType)
+	}
+
+	if nodeID.String() != "bad:ip" {
+		t.Errorf("expected bad:ip got %s", nodeID.String())
+	}
+}
+
+func TestNodeIDEqual(t *testing.T) {
+	context.InsertDnsHostIp("upf-equal.example.test", net.ParseIP("10.20.0.1"))
+	fqdn := context.NewNodeID("upf-equal.example.test")
+
+	if !fqdn.Equal(*context.NewNodeID("10.20.0.1")) {
+		t.Errorf("expected FQDN Node ID to match the IPv4 Node ID it resolves to")
+	}
+	if !context.NewNodeID("10.20.0.1").Equal(*fqdn) {
+		t.Errorf("expected IPv4 Node ID to match the FQDN Node ID resolving to it")
+	}
+	if fqdn.Equal(*context.NewNodeID("10.20.0.2")) {
+		t.Errorf("expected FQDN Node ID not to match another address")
+	}
+	if !fqdn.Equal(*context.NewNodeID("UPF-EQUAL.example.test")) {
+		t.Errorf("expected FQDN Node IDs to match regardless of case")
+	}
+	if context.NewNodeID("2001:db8::68").Equal(*context.NewNodeID("unresolved.invalid")) {
+		t.Errorf("expected an unresolved FQDN Node ID to match no address")
+	}
+	if !context.NewNodeID("2001:db8::68").Equal(*context.NewNodeID("2001:db8:0::68")) {
+		t.Errorf("expected IPv6 Node IDs of the same address to match")
+	}
+}
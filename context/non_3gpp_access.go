@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"github.com/omec-project/openapi/models"
+)
+
+// 3GPP Interface Types of the N3 interface of each access, TS 29.244 8.2.118
+const (
+	TGPPInterfaceTypeN3TGPPAccess             uint8 = 11
+	TGPPInterfaceTypeN3TrustedNon3GPPAccess   uint8 = 12
+	TGPPInterfaceTypeN3UntrustedNon3GPPAccess uint8 = 13
+)
+
+// RAT types of the trusted non-3GPP accesses, TS 29.571 5.4.3.2
+const (
+	RatTypeTrustedN3GA models.RatType = "TRUSTED_N3GA"
+	RatTypeTrustedWLAN models.RatType = "TRUSTED_WLAN"
+)
+
+// AccessInterfaceType returns the 3GPP Interface Type of the N3 interface of a session over
+// non-3GPP access, through a TNGF for the trusted accesses or an N3IWF for the others. Nil for
+// the 3GPP access, whose PFCP rules do not carry the interface type.
+func (smContext *SMContext) AccessInterfaceType() *uint8 {
+	if smContext.AnType != models.AccessType_NON_3_GPP_ACCESS {
+		return nil
+	}
+	interfaceType := TGPPInterfaceTypeN3UntrustedNon3GPPAccess
+	if smContext.IsTrustedNon3GPPAccess() {
+		interfaceType = TGPPInterfaceTypeN3TrustedNon3GPPAccess
+	}
+	return &interfaceType
+}
+
+// IsTrustedNon3GPPAccess returns true for a session over a trusted non-3GPP access, through a TNGF
+func (smContext *SMContext) IsTrustedNon3GPPAccess() bool {
+	return smContext.AnType == models.AccessType_NON_3_GPP_ACCESS &&
+		(smContext.RatType == RatTypeTrustedN3GA || smContext.RatType == RatTypeTrustedWLAN)
+}
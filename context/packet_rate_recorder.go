@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionStats is the traffic of a session accumulated from the periodic usage reports of its
+// anchor UPF
+type SessionStats struct {
+	LastReport      time.Time `json:"lastReport"`
+	UplinkVolume    uint64    `json:"uplinkVolume"`
+	DownlinkVolume  uint64    `json:"downlinkVolume"`
+	TotalVolume     uint64    `json:"totalVolume"`
+	UplinkPackets   uint64    `json:"uplinkPackets"`
+	DownlinkPackets uint64    `json:"downlinkPackets"`
+	TotalPackets    uint64    `json:"totalPackets"`
+	Duration        uint32    `json:"duration"` // sec
+	Reports         int       `json:"reports"`
+}
+
+// PacketRateRecorder accumulates the periodic usage reports of the sessions. Each report holds
+// the usage since the previous one, the stats of a session are their sum.
+type PacketRateRecorder struct {
+	stats map[string]*SessionStats
+	lock  sync.Mutex
+}
+
+var packetRateRecorder = NewPacketRateRecorder()
+
+// NewPacketRateRecorder returns a recorder without stats
+func NewPacketRateRecorder() *PacketRateRecorder {
+	return &PacketRateRecorder{
+		stats: make(map[string]*SessionStats),
+	}
+}
+
+// GetPacketRateRecorder returns the recorder of the sessions of the SMF
+func GetPacketRateRecorder() *PacketRateRecorder {
+	return packetRateRecorder
+}
+
+// Record adds the usage reported for the session
+func (r *PacketRateRecorder) Record(smCtxRef string, reports []UsageReport) {
+	if len(reports) == 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stats, ok := r.stats[smCtxRef]
+	if !ok {
+		stats = &SessionStats{}
+		r.stats[smCtxRef] = stats
+	}
+	for _, report := range reports {
+		stats.UplinkVolume += report.UplinkVolume
+		stats.DownlinkVolume += report.DownlinkVolume
+		stats.TotalVolume += report.TotalVolume
+		stats.UplinkPackets += report.UplinkPackets
+		stats.DownlinkPackets += report.DownlinkPackets
+		stats.TotalPackets += report.TotalPackets
+		stats.Duration += report.Duration
+		stats.Reports++
+	}
+	stats.LastReport = time.Now()
+}
+
+// GetSessionStats returns the traffic reported for the session
+func (r *PacketRateRecorder) GetSessionStats(smCtxRef string) (SessionStats, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stats, ok := r.stats[smCtxRef]
+	if !ok {
+		return SessionStats{}, fmt.Errorf("no usage reported for SM context [%s]", smCtxRef)
+	}
+	return *stats, nil
+}
+
+// Remove drops the stats of the released session
+func (r *PacketRateRecorder) Remove(smCtxRef string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.stats, smCtxRef)
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketRateRecorder(t *testing.T) {
+	recorder := context.NewPacketRateRecorder()
+	_, err := recorder.GetSessionStats("ref-1")
+	require.Error(t, err, "no report yet")
+
+	recorder.Record("ref-1", []context.UsageReport{
+		{URRID: 1, UplinkVolume: 100, DownlinkVolume: 300, TotalVolume: 400, TotalPackets: 4, Duration: 60},
+	})
+	recorder.Record("ref-1", []context.UsageReport{
+		{URRID: 1, UplinkVolume: 50, DownlinkVolume: 150, TotalVolume: 200, TotalPackets: 2, Duration: 60},
+	})
+	recorder.Record("ref-2", []context.UsageReport{{URRID: 2, TotalVolume: 10, Duration: 60}})
+
+	stats, err := recorder.GetSessionStats("ref-1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(150), stats.UplinkVolume)
+	require.Equal(t, uint64(450), stats.DownlinkVolume)
+	require.Equal(t, uint64(600), stats.TotalVolume)
+	require.Equal(t, uint64(6), stats.TotalPackets)
+	require.Equal(t, uint32(120), stats.Duration)
+	require.Equal(t, 2, stats.Reports)
+
+	// the stats of the released session are dropped
+	recorder.Remove("ref-1")
+	_, err = recorder.GetSessionStats("ref-1")
+	require.Error(t, err)
+	_, err = recorder.GetSessionStats("ref-2")
+	require.NoError(t, err)
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/omec-project/smf/smferrors"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// pfcpCauseNames are the rejection causes of TS 29.244 8.2.1
+var pfcpCauseNames = map[uint8]string{
+	ie.CauseRequestRejected:                 "Request rejected",
+	ie.CauseSessionContextNotFound:          "Session context not found",
+	ie.CauseMandatoryIEMissing:              "Mandatory IE missing",
+	ie.CauseConditionalIEMissing:            "Conditional IE missing",
+	ie.CauseInvalidLength:                   "Invalid length",
+	ie.CauseMandatoryIEIncorrect:            "Mandatory IE incorrect",
+	ie.CauseInvalidForwardingPolicy:         "Invalid Forwarding Policy",
+	ie.CauseInvalidFTEIDAllocationOption:    "Invalid F-TEID allocation option",
+	ie.CauseNoEstablishedPFCPAssociation:    "No established PFCP Association",
+	ie.CauseRuleCreationModificationFailure: "Rule creation/modification Failure",
+	ie.CausePFCPEntityInCongestion:          "PFCP entity in congestion",
+	ie.CauseNoResourcesAvailable:            "No resources available",
+	ie.CauseServiceNotSupported:             "Service not supported",
+	ie.CauseSystemFailure:                   "System failure",
+	ie.CauseRedirectionRequested:            "Redirection Requested",
+}
+
+// PFCPError is the rejection of a PFCP request by a UPF, with the cause of the response. It
+// unwraps to an SMFError of code ErrCodePFCPRejected.
+type PFCPError struct {
+	// name of the response message
+	MessageType string
+	Cause       uint8
+	// type of the IE which caused the rejection, 0 if the UPF did not report it
+	OffendingIE uint16
+}
+
+// NewPFCPError returns the error of a response rejecting a PFCP request with the cause IE,
+// along with its offending IE if present
+func NewPFCPError(messageType string, cause, offendingIE *ie.IE) *PFCPError {
+	pfcpErr := &PFCPError{MessageType: messageType}
+	if cause != nil {
+		if causeValue, err := cause.Cause(); err == nil {
+			pfcpErr.Cause = causeValue
+		}
+	}
+	if offendingIE != nil {
+		if ieType, err := offendingIE.OffendingIE(); err == nil {
+			pfcpErr.OffendingIE = ieType
+		}
+	}
+	return pfcpErr
+}
+
+func (e *PFCPError) Error() string {
+	causeName, ok := pfcpCauseNames[e.Cause]
+	if !ok {
+		causeName = "Unknown cause"
+	}
+	if e.OffendingIE != 0 {
+		return fmt.Sprintf("%s rejected with cause [%d] %s, offending IE [%d]", e.MessageType, e.Cause, causeName, e.OffendingIE)
+	}
+	return fmt.Sprintf("%s rejected with cause [%d] %s", e.MessageType, e.Cause, causeName)
+}
+
+func (e *PFCPError) Unwrap() error {
+	return smferrors.New(smferrors.ErrCodePFCPRejected, e.Error())
+}
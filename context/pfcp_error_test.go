@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestNewPFCPError(t *testing.T) {
+	pfcpErr := context.NewPFCPError("Session Establishment Response",
+		ie.NewCause(ie.CauseNoEstablishedPFCPAssociation), nil)
+	require.Equal(t, &context.PFCPError{
+		MessageType: "Session Establishment Response",
+		Cause:       ie.CauseNoEstablishedPFCPAssociation,
+	}, pfcpErr)
+	require.Equal(t, "Session Establishment Response rejected with cause [72] No established PFCP Association", pfcpErr.Error())
+
+	pfcpErr = context.NewPFCPError("Session Establishment Response",
+		ie.NewCause(ie.CauseMandatoryIEIncorrect), ie.NewOffendingIE(ie.CreateFAR))
+	require.Equal(t, ie.CauseMandatoryIEIncorrect, pfcpErr.Cause)
+	require.Equal(t, ie.CreateFAR, pfcpErr.OffendingIE)
+	require.Contains(t, pfcpErr.Error(), "offending IE [3]")
+}
@@ -28,12 +28,16 @@ type PDR struct {
 
 	FAR *FAR
 	URR *URR
+	MAR *MAR
 	QER []*QER
 
 	PDI        PDI
 	State      RuleState
 	PDRID      uint16
 	Precedence uint32
+
+	// traffic control data of the PCC rule of the PDR, empty for the hardcoded default PDR
+	TrafficControlID string
 }
 
 type SDFFilter struct {
@@ -83,6 +87,12 @@ type PDI struct {
 	ApplicationID   string
 	NetworkInstance util_3gpp.Dnn
 	SourceInterface SourceInterface
+	// 3GPP Interface Type of the access, set for the sessions over non-3GPP access
+	TGPPInterfaceType *uint8
+	// subnets behind the UE, in the Framed-Route format of RFC 2865
+	FramedRoutes []string
+	// match all the Ethernet frames of the Ethernet PDU session, which has no UE IP. 8.2.102
+	EthernetPDUSessionInformation bool
 }
 
 // Forwarding Action Rule. 7.5.2.3-1
@@ -109,6 +119,16 @@ type ForwardingParameters struct {
 	ForwardingPolicyID   string
 	NetworkInstance      util_3gpp.Dnn
 	DestinationInterface DestinationInterface
+	HeaderEnrichment     []HeaderEnrichment
+	SRv6SegmentList      []net.IP
+	TGPPInterfaceType    *uint8
+	RedirectInformation  *RedirectInformation
+}
+
+// Redirect Information. 8.2.20
+type RedirectInformation struct {
+	RedirectServerAddress string
+	RedirectAddressType   uint8
 }
 
 type SuggestedBufferingPacketsCount struct {
@@ -138,10 +158,43 @@ type QER struct {
 	State RuleState
 	QFI   QFI
 	QERID uint32
+
+	// PCC rule of the QER, empty for the QER of the session rule
+	PccRuleID string
+	// QER gating the flows of the match-all default PDR, not bound to a PCC rule
+	DefaultFlow bool
 }
 
-// Usage Report Rule
-type URR struct{}
+// Usage Report Rule. Table 7.5.2.4-1
+type URR struct {
+	// period of the periodic usage reports, none if not set
+	MeasurementPeriod time.Duration
+
+	State             RuleState
+	URRID             uint32
+	MeasurementMethod MeasurementMethod
+}
+
+// MeasurementMethod of the usage measured by a URR. 8.2.40
+type MeasurementMethod struct {
+	Event bool
+	Volum bool
+	Durat bool
+}
+
+// Multi-Access Rule, steering of the traffic of a MA PDU session. 7.5.2.8-1
+type MAR struct {
+	TGPPAccessFAR    *FAR
+	NonTGPPAccessFAR *FAR
+
+	State                 RuleState
+	MARID                 uint16
+	SteeringFunctionality SteeringFunctionality
+	SteeringMode          ATSSSMode
+	TGPPAccessWeight      uint8
+	// non-3GPP access is the active one in ActiveStandby and PriorityBased modes
+	NonTGPPAccessActive bool
+}
 
 func (pdr PDR) String() string {
 	return fmt.Sprintf("PDR:[PdrId:[%v], Precedence:[%v], PDI:[%v], OuterHeaderRem:[%v], Far:[%v], RuleState:[%v], QERS:[%v]]",
@@ -163,8 +216,9 @@ func ActionString(act ApplyAction) string {
 }
 
 func (fp ForwardingParameters) String() string {
-	return fmt.Sprintf("FwdParam:[DestIntf:[%v], NetworkInstance:[%v], OuterHeaderCreation:[%v], PFCPSMReqFlags:[%v], ForwardingPolicyID:[%v]]",
-		fp.DestinationInterface, fp.NetworkInstance, fp.OuterHeaderCreation, fp.PFCPSMReqFlags, fp.ForwardingPolicyID)
+	return fmt.Sprintf("FwdParam:[DestIntf:[%v], NetworkInstance:[%v], OuterHeaderCreation:[%v], PFCPSMReqFlags:[%v], ForwardingPolicyID:[%v], HeaderEnrichment:[%v], SRv6SegmentList:[%v]]",
+		fp.DestinationInterface, fp.NetworkInstance, fp.OuterHeaderCreation, fp.PFCPSMReqFlags, fp.ForwardingPolicyID, fp.HeaderEnrichment,
+		fp.SRv6SegmentList)
 }
 
 func (bar BAR) String() string {
@@ -28,6 +28,20 @@ type PFCPSessionContext struct {
 	NodeID     NodeID
 	LocalSEID  uint64
 	RemoteSEID uint64
+	// rules of an establishment split over several PFCP messages, sent one
+	// Session Modification Request at a time once the previous one is accepted
+	PendingRules []*PFCPRules
+	// set until all the messages of a split establishment are accepted
+	EstablishingFragments bool
+	// UPF counting the session among its active sessions, nil once released
+	upf *UPF
+}
+
+// PFCPRules are rules of a session sent to a UPF in one PFCP message
+type PFCPRules struct {
+	PDRs []*PDR
+	FARs []*FAR
+	QERs []*QER
 }
 
 func (pfcpSessionContext *PFCPSessionContext) String() string {
@@ -59,7 +73,31 @@ func (pfcpSessionResponseStatus PFCPSessionResponseStatus) String() string {
 		return "SessionUpdateTimeout"
 	case SessionReleaseTimeout:
 		return "SessionReleaseTimeout"
+	case SessionEstablishSuccess:
+		return "SessionEstablishSuccess"
+	case SessionEstablishFailed:
+		return "SessionEstablishFailed"
+	case SessionEstablishTimeout:
+		return "SessionEstablishTimeout"
 	default:
 		return "Unknown PFCP Session Response Status"
 	}
 }
+
+// PostPFCPResponseStatus passes the status of a PFCP session procedure to the procedure waiting
+// for it. The status is dropped if one is already pending, so that a late or duplicate response
+// never blocks its sender.
+func (smContext *SMContext) PostPFCPResponseStatus(status PFCPSessionResponseStatus) {
+	select {
+	case smContext.SBIPFCPCommunicationChan <- status:
+	default:
+		smContext.SubPfcpLog.Warnf("PFCP session status [%v] dropped, a status is already pending", status)
+	}
+}
+
+// IsEstablishingFragments returns true while the messages of a split session establishment
+// with the UPF are not all accepted
+func (smContext *SMContext) IsEstablishingFragments(nodeID NodeID) bool {
+	pfcpContext := smContext.PFCPContext[nodeID.ResolveNodeIdToIp().String()]
+	return pfcpContext != nil && pfcpContext.EstablishingFragments
+}
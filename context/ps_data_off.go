@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"slices"
+
+	"github.com/omec-project/nas/nasConvert"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+)
+
+// psDataOffActivated decodes the 3GPP PS data off UE status container, TS 24.008 10.5.6.3A
+func psDataOffActivated(contents []byte) bool {
+	return len(contents) > 0 && contents[0]&0x01 != 0
+}
+
+// PsDataOffStatus returns the 3GPP PS data off status signalled by the UE in the extended protocol
+// configuration options of a PDU Session Modification Request, present being false if the UE did
+// not signal it
+func PsDataOffStatus(req *nasMessage.PDUSessionModificationRequest) (activated bool, present bool, err error) {
+	if req.ExtendedProtocolConfigurationOptions == nil {
+		return false, false, nil
+	}
+	pco := nasConvert.NewProtocolConfigurationOptions()
+	if err := pco.UnMarshal(req.GetExtendedProtocolConfigurationOptionsContents()); err != nil {
+		return false, false, err
+	}
+	for _, container := range pco.ProtocolOrContainerList {
+		if container.ProtocolOrContainerID == nasMessage.UEStatus3GPPPSDataOffUL {
+			return psDataOffActivated(container.Contents), true, nil
+		}
+	}
+	return false, false, nil
+}
+
+// psDataOffExempt checks that the PCC rule is of a service exempt from the PS data off of the UE
+func (smContext *SMContext) psDataOffExempt(rule *models.PccRule) bool {
+	return smContext.DNNInfo != nil && rule.AppId != "" &&
+		slices.Contains(smContext.DNNInfo.PsDataOffExemptApps, rule.AppId)
+}
+
+// psDataOffBlocks checks that the flows of the PCC rule are blocked by the PS data off of the UE
+func (smContext *SMContext) psDataOffBlocks(rule *models.PccRule) bool {
+	return smContext.PsDataOff && !smContext.psDataOffExempt(rule)
+}
+
+// pccRulePolicyGate returns the gate of the flows of the PCC rule set by the flow status of its
+// traffic control data
+func (smContext *SMContext) pccRulePolicyGate(rule *models.PccRule) uint8 {
+	if len(rule.RefTcData) == 0 {
+		return GateOpen
+	}
+	if tc := smContext.SmPolicyData.SmCtxtTCData.TrafficControlData[rule.RefTcData[0]]; tc != nil &&
+		tc.FlowStatus == models.FlowStatus_DISABLED {
+		return GateClose
+	}
+	return GateOpen
+}
+
+// PsDataOffReportRequested checks that the PCF requested the changes of the PS data off status of
+// the UE to be reported
+func (smContext *SMContext) PsDataOffReportRequested() bool {
+	for _, update := range smContext.SmPolicyUpdates {
+		if update != nil && update.SmPolicyDecision != nil &&
+			slices.Contains(update.SmPolicyDecision.PolicyCtrlReqTriggers, models.PolicyControlRequestTrigger_PS_DA_OFF) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPsDataOff applies the PS data off status signalled by the UE to the gates of the QERs of the
+// PCC rules and of the match-all default PDR on the AN UPF, the flows of the services not exempt
+// being blocked while it is activated and reverting to the gate of their policy once
+// deactivated. The QERs whose gate changed are returned, to be updated on the AN UPF.
+func (smContext *SMContext) SetPsDataOff(activated bool) []*QER {
+	smContext.PsDataOff = activated
+	if smContext.Tunnel == nil {
+		return nil
+	}
+	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil || defaultPath.FirstDPNode == nil {
+		return nil
+	}
+	rules := make(map[string]*models.PccRule)
+	for _, rule := range smContext.SmPolicyData.SmCtxtPccRules.PccRules {
+		if rule != nil {
+			rules[rule.PccRuleId] = rule
+		}
+	}
+
+	var updated []*QER
+	anUPF := defaultPath.FirstDPNode
+	for _, tunnel := range []*GTPTunnel{anUPF.UpLinkTunnel, anUPF.DownLinkTunnel} {
+		if tunnel == nil {
+			continue
+		}
+		for _, pdr := range tunnel.PDR {
+			for _, qer := range pdr.QER {
+				if qer.GateStatus == nil || slices.Contains(updated, qer) {
+					continue
+				}
+				var gate uint8
+				if qer.DefaultFlow {
+					gate = GateOpen
+					if activated {
+						gate = GateClose
+					}
+				} else if rule := rules[qer.PccRuleID]; rule != nil {
+					gate = smContext.pccRulePolicyGate(rule)
+					if smContext.psDataOffBlocks(rule) {
+						gate = GateClose
+					}
+				} else {
+					continue
+				}
+				if qer.GateStatus.ULGate == gate && qer.GateStatus.DLGate == gate {
+					continue
+				}
+				qer.GateStatus = &GateStatus{ULGate: gate, DLGate: gate}
+				qer.State = RULE_UPDATE
+				updated = append(updated, qer)
+			}
+		}
+	}
+	return updated
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+)
+
+func psDataOffTestDecision() *models.SmPolicyDecision {
+	rule := func(id string, appID string, tc string) *models.PccRule {
+		return &models.PccRule{
+			PccRuleId:  id,
+			AppId:      appID,
+			Precedence: 10,
+			RefQosData: []string{"qos"},
+			RefTcData:  []string{tc},
+			FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from any to assigned", PackFiltId: id}},
+		}
+	}
+	return &models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"ims":      rule("1", "ims", "enabled"),
+			"web":      rule("2", "", "enabled"),
+			"disabled": rule("3", "video", "disabled"),
+		},
+		QosDecs: map[string]*models.QosData{
+			"qos":     {QosId: "1", MaxbrUl: "10 Mbps", MaxbrDl: "10 Mbps"},
+			"default": {QosId: "9", DefQosFlowIndication: true},
+		},
+		TraffContDecs: map[string]*models.TrafficControlData{
+			"enabled":  {TcId: "enabled", FlowStatus: models.FlowStatus_ENABLED},
+			"disabled": {TcId: "disabled", FlowStatus: models.FlowStatus_DISABLED},
+		},
+	}
+}
+
+// pccRuleGates returns the gates of the QERs of the PCC rules of the tunnel, by PCC rule ID, and
+// of the match-all default PDR
+func pccRuleGates(t *testing.T, tunnel *context.GTPTunnel) map[string]uint8 {
+	t.Helper()
+	gates := make(map[string]uint8)
+	for _, pdr := range tunnel.PDR {
+		for _, qer := range pdr.QER {
+			id := qer.PccRuleID
+			if qer.DefaultFlow {
+				id = "default"
+			} else if id == "" {
+				continue
+			}
+			require.Equal(t, qer.GateStatus.ULGate, qer.GateStatus.DLGate)
+			gates[id] = qer.GateStatus.ULGate
+		}
+	}
+	return gates
+}
+
+func TestPsDataOffGates(t *testing.T) {
+	smContext, dpNode := newDefaultPdrTestNode(t, psDataOffTestDecision())
+	smContext.DNNInfo = &context.SnssaiSmfDnnInfo{PsDataOffExemptApps: []string{"ims"}}
+	smContext.SmPolicyData.Initialize()
+	require.NoError(t, qos.CommitSmPolicyDecision(&smContext.SmPolicyData, smContext.SmPolicyUpdates[0]))
+	dataPath := context.NewDataPath()
+	dataPath.IsDefaultPath = true
+	dataPath.FirstDPNode = dpNode
+	smContext.Tunnel = &context.UPTunnel{DataPathPool: context.DataPathPool{1: dataPath}}
+
+	// activated by the UE at establishment, the flows of the exempt service are still forwarded
+	smContext.PsDataOff = true
+	require.NoError(t, dpNode.ActivateUpLinkTunnel(smContext))
+	require.Equal(t, map[string]uint8{
+		"1":       context.GateOpen,
+		"2":       context.GateClose,
+		"3":       context.GateClose,
+		"default": context.GateClose,
+	}, pccRuleGates(t, dpNode.UpLinkTunnel))
+
+	// deactivated, the gates revert to the ones of the policy
+	updated := smContext.SetPsDataOff(false)
+	require.Len(t, updated, 2)
+	for _, qer := range updated {
+		require.Equal(t, context.RULE_UPDATE, qer.State)
+	}
+	require.Equal(t, map[string]uint8{
+		"1":       context.GateOpen,
+		"2":       context.GateOpen,
+		"3":       context.GateClose,
+		"default": context.GateOpen,
+	}, pccRuleGates(t, dpNode.UpLinkTunnel))
+
+	// unchanged, nothing to update
+	require.Empty(t, smContext.SetPsDataOff(false))
+
+	// activated again
+	updated = smContext.SetPsDataOff(true)
+	require.Len(t, updated, 2)
+	require.True(t, smContext.PsDataOff)
+	require.Equal(t, map[string]uint8{
+		"1":       context.GateOpen,
+		"2":       context.GateClose,
+		"3":       context.GateClose,
+		"default": context.GateClose,
+	}, pccRuleGates(t, dpNode.UpLinkTunnel))
+}
+
+func TestPsDataOffNotActivated(t *testing.T) {
+	smContext, dpNode := newDefaultPdrTestNode(t, psDataOffTestDecision())
+	require.NoError(t, dpNode.ActivateUpLinkTunnel(smContext))
+	require.Equal(t, map[string]uint8{
+		"1":       context.GateOpen,
+		"2":       context.GateOpen,
+		"3":       context.GateClose,
+		"default": context.GateOpen,
+	}, pccRuleGates(t, dpNode.UpLinkTunnel))
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/omec-project/aper"
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/qos"
+)
+
+// QoSFlow is a QoS flow of the session released by the AN
+type QoSFlow struct {
+	Cause *models.NgApCause
+	QFI   uint8
+}
+
+// QosFlowRules are the rules of the AN UPF enforcing a set of QoS flows,
+// with the PCC rules bound to the flows
+type QosFlowRules struct {
+	PDRs       []*PDR
+	FARs       []*FAR
+	QERs       []*QER
+	PccRuleIds []string
+}
+
+// HandlePDUSessionResourceNotifyTransfer returns the QoS flows released by the AN
+func HandlePDUSessionResourceNotifyTransfer(b []byte) ([]QoSFlow, error) {
+	notifyTransfer := ngapType.PDUSessionResourceNotifyTransfer{}
+	if err := aper.UnmarshalWithParams(b, &notifyTransfer, "valueExt"); err != nil {
+		return nil, err
+	}
+
+	releasedFlows := make([]QoSFlow, 0)
+	if notifyTransfer.QosFlowReleasedList == nil {
+		return releasedFlows, nil
+	}
+	for _, item := range notifyTransfer.QosFlowReleasedList.List {
+		releasedFlows = append(releasedFlows, QoSFlow{
+			QFI:   uint8(item.QosFlowIdentifier.Value),
+			Cause: ngapCauseToModels(item.Cause),
+		})
+	}
+	return releasedFlows, nil
+}
+
+// ngapCauseToModels converts the NGAP cause, TS 29.571 5.4.4.12
+func ngapCauseToModels(cause ngapType.Cause) *models.NgApCause {
+	switch cause.Present {
+	case ngapType.CausePresentRadioNetwork:
+		return &models.NgApCause{Group: 0, Value: int32(cause.RadioNetwork.Value)}
+	case ngapType.CausePresentTransport:
+		return &models.NgApCause{Group: 1, Value: int32(cause.Transport.Value)}
+	case ngapType.CausePresentNas:
+		return &models.NgApCause{Group: 2, Value: int32(cause.Nas.Value)}
+	case ngapType.CausePresentProtocol:
+		return &models.NgApCause{Group: 3, Value: int32(cause.Protocol.Value)}
+	case ngapType.CausePresentMisc:
+		return &models.NgApCause{Group: 4, Value: int32(cause.Misc.Value)}
+	}
+	return nil
+}
+
+// GetQosFlowRules returns the rules of the AN UPF enforcing the QoS flows, other than the default one
+func (smContext *SMContext) GetQosFlowRules(qfis map[uint8]bool) *QosFlowRules {
+	rules := &QosFlowRules{}
+	if smContext.Tunnel == nil {
+		return rules
+	}
+
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated || dataPath.FirstDPNode == nil {
+			continue
+		}
+		ANUPF := dataPath.FirstDPNode
+		for _, tunnel := range []*GTPTunnel{ANUPF.UpLinkTunnel, ANUPF.DownLinkTunnel} {
+			if tunnel == nil {
+				continue
+			}
+			// the default QoS flow is only released with the session
+			defaultQERs := make(map[*QER]bool)
+			if defaultPDR, ok := tunnel.PDR["default"]; ok {
+				for _, qer := range defaultPDR.QER {
+					defaultQERs[qer] = true
+				}
+			}
+			for _, pdr := range tunnel.PDR {
+				var flowQERs []*QER
+				for _, qer := range pdr.QER {
+					if qer != nil && !defaultQERs[qer] && qfis[qer.QFI.QFI] {
+						flowQERs = append(flowQERs, qer)
+					}
+				}
+				if len(flowQERs) == 0 {
+					continue
+				}
+				rules.PDRs = append(rules.PDRs, pdr)
+				if pdr.FAR != nil {
+					rules.FARs = append(rules.FARs, pdr.FAR)
+				}
+				rules.QERs = append(rules.QERs, flowQERs...)
+			}
+		}
+	}
+
+	for id, pccRule := range smContext.SmPolicyData.SmCtxtPccRules.PccRules {
+		if len(pccRule.RefQosData) == 0 {
+			continue
+		}
+		qosData := smContext.SmPolicyData.SmCtxtQosData.QosData[pccRule.RefQosData[0]]
+		if qosData != nil && qfis[qos.GetQosFlowIdFromQosId(qosData.QosId)] {
+			rules.PccRuleIds = append(rules.PccRuleIds, id)
+		}
+	}
+	return rules
+}
+
+// RemoveQosFlowRules releases the rules removed from the AN UPF and the PCC rules of the released flows
+func (smContext *SMContext) RemoveQosFlowRules(rules *QosFlowRules) error {
+	removedQERs := make(map[*QER]bool)
+	for _, qer := range rules.QERs {
+		removedQERs[qer] = true
+	}
+
+	for _, removedPDR := range rules.PDRs {
+		for _, dataPath := range smContext.Tunnel.DataPathPool {
+			if dataPath.FirstDPNode == nil {
+				continue
+			}
+			ANUPF := dataPath.FirstDPNode
+			for _, tunnel := range []*GTPTunnel{ANUPF.UpLinkTunnel, ANUPF.DownLinkTunnel} {
+				if tunnel == nil {
+					continue
+				}
+				for name, pdr := range tunnel.PDR {
+					if pdr != removedPDR {
+						continue
+					}
+					delete(tunnel.PDR, name)
+					if err := ANUPF.UPF.releaseQosFlowPDR(pdr, removedQERs); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	for _, id := range rules.PccRuleIds {
+		delete(smContext.SmPolicyData.SmCtxtPccRules.PccRules, id)
+	}
+	return nil
+}
+
+func (upf *UPF) releaseQosFlowPDR(pdr *PDR, removedQERs map[*QER]bool) error {
+	if err := upf.RemovePDR(pdr); err != nil {
+		return fmt.Errorf("release PDR[%d] failed: %v", pdr.PDRID, err)
+	}
+	if pdr.FAR != nil {
+		if err := upf.RemoveFAR(pdr.FAR); err != nil {
+			return fmt.Errorf("release FAR[%d] failed: %v", pdr.FAR.FARID, err)
+		}
+	}
+	for _, qer := range pdr.QER {
+		if !removedQERs[qer] {
+			continue
+		}
+		if err := upf.RemoveQER(qer); err != nil {
+			return fmt.Errorf("release QER[%d] failed: %v", qer.QERID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+)
+
+// QoSMonitoringSRRID is the ID of the Session Reporting Rule of the QoS monitoring, the only
+// SRR of the sessions
+const QoSMonitoringSRRID uint8 = 1
+
+// QoSMonitoring is the validated QoS monitoring of the QoS flows of a DNN
+type QoSMonitoring struct {
+	// measured packet delays
+	Uplink    bool
+	Downlink  bool
+	RoundTrip bool
+	// reporting frequencies
+	EventTriggered bool
+	Periodic       bool
+	SessionRelease bool
+	// period of the periodic reports
+	MeasurementPeriod time.Duration
+	// thresholds in ms of the event-triggered reports, 0 if not set
+	UplinkThreshold    uint32
+	DownlinkThreshold  uint32
+	RoundTripThreshold uint32
+	// minimum time between two event-triggered reports, 0 if not set
+	MinimumWaitTime time.Duration
+}
+
+// SRR is the Session Reporting Rule requesting the anchor UPF to monitor the packet delay of
+// the QoS flows. TS 29.244 7.5.2.9
+type SRR struct {
+	SRRID         uint8
+	QFIs          []uint8
+	QoSMonitoring *QoSMonitoring
+}
+
+// QoSMonitoringReport is the packet delay of a QoS flow measured by the anchor UPF
+type QoSMonitoringReport struct {
+	UpfIP string `json:"upfIp"`
+	QFI   uint8  `json:"qfi"`
+	// packet delays in ms, nil if not measured
+	UplinkDelay    *uint32 `json:"uplinkDelay,omitempty"`
+	DownlinkDelay  *uint32 `json:"downlinkDelay,omitempty"`
+	RoundTripDelay *uint32 `json:"roundTripDelay,omitempty"`
+	// the UPF failed to measure the packet delay
+	MeasurementFailure bool      `json:"measurementFailure,omitempty"`
+	EventTime          time.Time `json:"eventTime"`
+}
+
+// ParseQoSMonitoring validates the QoS monitoring configured for a DNN
+func ParseQoSMonitoring(config *factory.QoSMonitoringConfig) (*QoSMonitoring, error) {
+	qosMonitoring := &QoSMonitoring{
+		MeasurementPeriod:  time.Duration(config.PeriodSec) * time.Second,
+		UplinkThreshold:    config.UplinkThresholdMs,
+		DownlinkThreshold:  config.DownlinkThresholdMs,
+		RoundTripThreshold: config.RoundTripThresholdMs,
+		MinimumWaitTime:    time.Duration(config.MinimumWaitTimeSec) * time.Second,
+	}
+	for _, direction := range config.Directions {
+		switch direction {
+		case factory.QoSMonitoringUplink:
+			qosMonitoring.Uplink = true
+		case factory.QoSMonitoringDownlink:
+			qosMonitoring.Downlink = true
+		case factory.QoSMonitoringRoundTrip:
+			qosMonitoring.RoundTrip = true
+		default:
+			return nil, fmt.Errorf("unknown packet delay direction %q", direction)
+		}
+	}
+	if !qosMonitoring.Uplink && !qosMonitoring.Downlink && !qosMonitoring.RoundTrip {
+		return nil, fmt.Errorf("no packet delay direction to monitor")
+	}
+	for _, frequency := range config.ReportingFrequencies {
+		switch frequency {
+		case factory.QoSMonitoringEventTriggered:
+			qosMonitoring.EventTriggered = true
+		case factory.QoSMonitoringPeriodic:
+			qosMonitoring.Periodic = true
+		case factory.QoSMonitoringSessionRelease:
+			qosMonitoring.SessionRelease = true
+		default:
+			return nil, fmt.Errorf("unknown reporting frequency %q", frequency)
+		}
+	}
+	if !qosMonitoring.EventTriggered && !qosMonitoring.Periodic && !qosMonitoring.SessionRelease {
+		return nil, fmt.Errorf("no reporting frequency")
+	}
+	if qosMonitoring.Periodic && qosMonitoring.MeasurementPeriod == 0 {
+		return nil, fmt.Errorf("periodic reporting without period")
+	}
+	if (qosMonitoring.UplinkThreshold != 0 && !qosMonitoring.Uplink) ||
+		(qosMonitoring.DownlinkThreshold != 0 && !qosMonitoring.Downlink) ||
+		(qosMonitoring.RoundTripThreshold != 0 && !qosMonitoring.RoundTrip) {
+		return nil, fmt.Errorf("threshold of an unmonitored packet delay direction")
+	}
+	if qosMonitoring.EventTriggered && qosMonitoring.UplinkThreshold == 0 &&
+		qosMonitoring.DownlinkThreshold == 0 && qosMonitoring.RoundTripThreshold == 0 {
+		return nil, fmt.Errorf("event-triggered reporting without threshold")
+	}
+	return qosMonitoring, nil
+}
+
+// IsUpfSupportQoSMonitoring QoS monitoring of the packet delay of the QoS flows by UPF supported
+func (upf *UPF) IsUpfSupportQoSMonitoring() bool {
+	return upf.UPFunctionFeatures != nil &&
+		(upf.UPFunctionFeatures.SupportedFeatures2&UpFunctionFeatures2Qfqm) == UpFunctionFeatures2Qfqm
+}
+
+// QoSMonitoringSRR returns the Session Reporting Rule requesting the UPF to monitor the QoS flows
+// of the QERs, nil if the UPF does not anchor the session or the DNN of the session is not monitored
+func (smContext *SMContext) QoSMonitoringSRR(nodeID NodeID, qerList []*QER) *SRR {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.QoSMonitoring == nil || smContext.Tunnel == nil {
+		return nil
+	}
+
+	var anchorUPF *UPF
+	nodeIP := nodeID.ResolveNodeIdToIp()
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if node.IsAnchorUPF() && node.UPF != nil && node.UPF.NodeID.ResolveNodeIdToIp().Equal(nodeIP) {
+				anchorUPF = node.UPF
+			}
+		}
+	}
+	if anchorUPF == nil {
+		return nil
+	}
+	if !anchorUPF.IsUpfSupportQoSMonitoring() {
+		logger.CtxLog.Warnf("UPF[%s] does not support QoS monitoring, QoS flows of the session not monitored", nodeIP)
+		return nil
+	}
+
+	qfis := make([]uint8, 0, len(qerList))
+	for _, qer := range qerList {
+		if qer != nil && !slices.Contains(qfis, qer.QFI.QFI) {
+			qfis = append(qfis, qer.QFI.QFI)
+		}
+	}
+	if len(qfis) == 0 {
+		return nil
+	}
+	slices.Sort(qfis)
+	return &SRR{
+		SRRID:         QoSMonitoringSRRID,
+		QFIs:          qfis,
+		QoSMonitoring: smContext.DNNInfo.QoSMonitoring,
+	}
+}
+
+// AddQoSMonitoringReports records the packet delays reported by the anchor UPF, the last report
+// of each QoS flow being kept
+func (smContext *SMContext) AddQoSMonitoringReports(reports []QoSMonitoringReport) {
+	smContext.qosMonitoringReportsLock.Lock()
+	defer smContext.qosMonitoringReportsLock.Unlock()
+	if smContext.qosMonitoringReports == nil {
+		smContext.qosMonitoringReports = make(map[uint8]QoSMonitoringReport)
+	}
+	for _, report := range reports {
+		smContext.qosMonitoringReports[report.QFI] = report
+	}
+}
+
+// QoSMonitoringReports returns the last report of each QoS flow of the session, by QFI
+func (smContext *SMContext) QoSMonitoringReports() []QoSMonitoringReport {
+	smContext.qosMonitoringReportsLock.Lock()
+	defer smContext.qosMonitoringReportsLock.Unlock()
+	reports := make([]QoSMonitoringReport, 0, len(smContext.qosMonitoringReports))
+	for _, report := range smContext.qosMonitoringReports {
+		reports = append(reports, report)
+	}
+	slices.SortFunc(reports, func(a, b QoSMonitoringReport) int { return int(a.QFI) - int(b.QFI) })
+	return reports
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQoSMonitoring(t *testing.T) {
+	qosMonitoring, err := context.ParseQoSMonitoring(&factory.QoSMonitoringConfig{
+		Directions:           []string{factory.QoSMonitoringUplink, factory.QoSMonitoringRoundTrip},
+		ReportingFrequencies: []string{factory.QoSMonitoringEventTriggered, factory.QoSMonitoringPeriodic},
+		PeriodSec:            10,
+		RoundTripThresholdMs: 20,
+		MinimumWaitTimeSec:   5,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &context.QoSMonitoring{
+		Uplink:             true,
+		RoundTrip:          true,
+		EventTriggered:     true,
+		Periodic:           true,
+		MeasurementPeriod:  10 * time.Second,
+		RoundTripThreshold: 20,
+		MinimumWaitTime:    5 * time.Second,
+	}, qosMonitoring)
+
+	for name, config := range map[string]factory.QoSMonitoringConfig{
+		"no direction":  {ReportingFrequencies: []string{factory.QoSMonitoringSessionRelease}},
+		"bad direction": {Directions: []string{"sideways"}, ReportingFrequencies: []string{factory.QoSMonitoringSessionRelease}},
+		"no frequency":  {Directions: []string{factory.QoSMonitoringUplink}},
+		"no period":     {Directions: []string{factory.QoSMonitoringUplink}, ReportingFrequencies: []string{factory.QoSMonitoringPeriodic}},
+		"no threshold": {
+			Directions:           []string{factory.QoSMonitoringUplink},
+			ReportingFrequencies: []string{factory.QoSMonitoringEventTriggered},
+		},
+		"threshold of an unmonitored direction": {
+			Directions:           []string{factory.QoSMonitoringUplink},
+			ReportingFrequencies: []string{factory.QoSMonitoringEventTriggered},
+			DownlinkThresholdMs:  10,
+		},
+	} {
+		_, err := context.ParseQoSMonitoring(&config)
+		require.Error(t, err, name)
+	}
+}
+
+func TestQoSMonitoringSRR(t *testing.T) {
+	qfqm := &context.UPFunctionFeatures{SupportedFeatures2: context.UpFunctionFeatures2Qfqm}
+	anUPF := &context.UPF{NodeID: *context.NewNodeID("10.0.0.1"), UPFunctionFeatures: qfqm}
+	anchorUPF := &context.UPF{NodeID: *context.NewNodeID("10.0.0.2"), UPFunctionFeatures: qfqm}
+	anNode := &context.DataPathNode{UPF: anUPF, DownLinkTunnel: &context.GTPTunnel{}}
+	anchorNode := &context.DataPathNode{UPF: anchorUPF, UpLinkTunnel: &context.GTPTunnel{}}
+	anNode.AddNext(anchorNode)
+	anchorNode.AddPrev(anNode)
+
+	qosMonitoring := &context.QoSMonitoring{Downlink: true, SessionRelease: true}
+	smContext := &context.SMContext{
+		DNNInfo: &context.SnssaiSmfDnnInfo{QoSMonitoring: qosMonitoring},
+		Tunnel: &context.UPTunnel{DataPathPool: context.DataPathPool{
+			1: {Activated: true, FirstDPNode: anNode},
+		}},
+	}
+	qerList := []*context.QER{{QFI: context.QFI{QFI: 9}}, {QFI: context.QFI{QFI: 1}}, {QFI: context.QFI{QFI: 9}}}
+
+	require.Equal(t, &context.SRR{
+		SRRID:         context.QoSMonitoringSRRID,
+		QFIs:          []uint8{1, 9},
+		QoSMonitoring: qosMonitoring,
+	}, smContext.QoSMonitoringSRR(anchorUPF.NodeID, qerList))
+
+	// the packet delay is measured by the anchor UPF only
+	require.Nil(t, smContext.QoSMonitoringSRR(anUPF.NodeID, qerList))
+
+	// the anchor UPF does not support QoS monitoring
+	anchorUPF.UPFunctionFeatures = nil
+	require.Nil(t, smContext.QoSMonitoringSRR(anchorUPF.NodeID, qerList))
+
+	// the DNN is not monitored
+	anchorUPF.UPFunctionFeatures = qfqm
+	smContext.DNNInfo = &context.SnssaiSmfDnnInfo{}
+	require.Nil(t, smContext.QoSMonitoringSRR(anchorUPF.NodeID, qerList))
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/qos"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// NewRedirectInformation returns the Redirect Information of the FAR of the traffic control data
+// of the PCF, nil when the redirection is not enabled
+func NewRedirectInformation(tc *models.TrafficControlData) (*RedirectInformation, error) {
+	if tc == nil || tc.RedirectInfo == nil || !tc.RedirectInfo.RedirectEnabled {
+		return nil, nil
+	}
+	address := tc.RedirectInfo.RedirectServerAddress
+	redirect := &RedirectInformation{RedirectServerAddress: address}
+	switch tc.RedirectInfo.RedirectAddressType {
+	case models.IPV4_ADDRRedirectAddressType:
+		if ip := net.ParseIP(address); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid redirect server IPv4 address %q", address)
+		}
+		redirect.RedirectAddressType = ie.RedirectAddrIPv4
+	case models.IPV6_ADDRRedirectAddressType:
+		if ip := net.ParseIP(address); ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid redirect server IPv6 address %q", address)
+		}
+		redirect.RedirectAddressType = ie.RedirectAddrIPv6
+	case models.URLRedirectAddressType:
+		if u, err := url.Parse(address); err != nil || !u.IsAbs() || u.Host == "" {
+			return nil, fmt.Errorf("invalid redirect server URL %q", address)
+		}
+		redirect.RedirectAddressType = ie.RedirectAddrURL
+	case models.SIP_URIRedirectAddressType:
+		if !strings.HasPrefix(address, "sip:") && !strings.HasPrefix(address, "sips:") {
+			return nil, fmt.Errorf("invalid redirect server SIP URI %q", address)
+		}
+		redirect.RedirectAddressType = ie.RedirectAddrSIPURI
+	default:
+		return nil, fmt.Errorf("unsupported redirect address type %q", tc.RedirectInfo.RedirectAddressType)
+	}
+	return redirect, nil
+}
+
+// redirectInformation returns the Redirect Information of the traffic control data of the policy
+// decision being applied, nil when the traffic is not redirected
+func (smContext *SMContext) redirectInformation(tcID string) *RedirectInformation {
+	if tcID == "" || len(smContext.SmPolicyUpdates) == 0 || smContext.SmPolicyUpdates[0].SmPolicyDecision == nil {
+		return nil
+	}
+	tc := qos.GetTcDataFromPolicyDecision(smContext.SmPolicyUpdates[0].SmPolicyDecision, tcID)
+	redirect, err := NewRedirectInformation(tc)
+	if err != nil {
+		smContext.SubQosLog.Errorf("traffic control data [%s] not redirected: %v", tcID, err)
+		return nil
+	}
+	return redirect
+}
+
+// UpdateRedirectInformation enables or disables the redirection of the uplink FARs of the anchor
+// UPFs for the PCC rules of the modified traffic control data, and returns the updated FARs by
+// UPF
+func (smContext *SMContext) UpdateRedirectInformation(tcData map[string]*models.TrafficControlData) map[*UPF][]*FAR {
+	updatedFARs := make(map[*UPF][]*FAR)
+	if smContext.Tunnel == nil {
+		return updatedFARs
+	}
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if !node.IsAnchorUPF() || node.UpLinkTunnel == nil {
+				continue
+			}
+			for _, pdr := range node.UpLinkTunnel.PDR {
+				tc, ok := tcData[pdr.TrafficControlID]
+				if !ok || pdr.FAR == nil || pdr.FAR.ForwardingParameters == nil {
+					continue
+				}
+				redirect, err := NewRedirectInformation(tc)
+				if err != nil {
+					smContext.SubQosLog.Errorf("traffic control data [%s] not redirected: %v", pdr.TrafficControlID, err)
+					continue
+				}
+				if current := pdr.FAR.ForwardingParameters.RedirectInformation; current == redirect ||
+					(current != nil && redirect != nil && *current == *redirect) {
+					continue
+				}
+				pdr.FAR.ForwardingParameters.RedirectInformation = redirect
+				pdr.FAR.State = RULE_UPDATE
+				updatedFARs[node.UPF] = append(updatedFARs[node.UPF], pdr.FAR)
+			}
+		}
+	}
+	return updatedFARs
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestNewRedirectInformation(t *testing.T) {
+	redirectTo := func(addressType models.RedirectAddressType, address string) *models.TrafficControlData {
+		return &models.TrafficControlData{RedirectInfo: &models.RedirectInformation{
+			RedirectEnabled:       true,
+			RedirectAddressType:   addressType,
+			RedirectServerAddress: address,
+		}}
+	}
+
+	for name, tc := range map[string]struct {
+		tc       *models.TrafficControlData
+		expected *context.RedirectInformation
+		err      bool
+	}{
+		"no traffic control data": {},
+		"no redirection":          {tc: &models.TrafficControlData{TcId: "tc"}},
+		"redirection disabled": {tc: &models.TrafficControlData{RedirectInfo: &models.RedirectInformation{
+			RedirectAddressType: models.URLRedirectAddressType, RedirectServerAddress: "http://portal.example.com",
+		}}},
+		"ipv4": {
+			tc:       redirectTo(models.IPV4_ADDRRedirectAddressType, "10.10.10.10"),
+			expected: &context.RedirectInformation{RedirectAddressType: ie.RedirectAddrIPv4, RedirectServerAddress: "10.10.10.10"},
+		},
+		"ipv6": {
+			tc:       redirectTo(models.IPV6_ADDRRedirectAddressType, "2001:db8::10"),
+			expected: &context.RedirectInformation{RedirectAddressType: ie.RedirectAddrIPv6, RedirectServerAddress: "2001:db8::10"},
+		},
+		"url": {
+			tc:       redirectTo(models.URLRedirectAddressType, "http://portal.example.com/topup"),
+			expected: &context.RedirectInformation{RedirectAddressType: ie.RedirectAddrURL, RedirectServerAddress: "http://portal.example.com/topup"},
+		},
+		"sip uri": {
+			tc:       redirectTo(models.SIP_URIRedirectAddressType, "sip:portal@example.com"),
+			expected: &context.RedirectInformation{RedirectAddressType: ie.RedirectAddrSIPURI, RedirectServerAddress: "sip:portal@example.com"},
+		},
+		"ipv6 address as ipv4": {tc: redirectTo(models.IPV4_ADDRRedirectAddressType, "2001:db8::10"), err: true},
+		"relative url":         {tc: redirectTo(models.URLRedirectAddressType, "/topup"), err: true},
+		"unknown address type": {tc: redirectTo("FQDN", "portal.example.com"), err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			redirect, err := context.NewRedirectInformation(tc.tc)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, redirect)
+		})
+	}
+}
+
+func TestActivateUpLinkPdrRedirect(t *testing.T) {
+	smContext, dpNode := newDefaultPdrTestNode(t, &models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"portal": {
+				PccRuleId:  "1",
+				Precedence: 10,
+				RefQosData: []string{"portal-qos"},
+				RefTcData:  []string{"portal-tc"},
+				FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from any 80 to assigned", PackFiltId: "1"}},
+			},
+		},
+		QosDecs: map[string]*models.QosData{"portal-qos": {QosId: "1", MaxbrUl: "10 Mbps", MaxbrDl: "10 Mbps"}},
+		TraffContDecs: map[string]*models.TrafficControlData{"portal-tc": {
+			TcId:       "portal-tc",
+			FlowStatus: models.FlowStatus_ENABLED,
+			RedirectInfo: &models.RedirectInformation{
+				RedirectEnabled:       true,
+				RedirectAddressType:   models.URLRedirectAddressType,
+				RedirectServerAddress: "http://portal.example.com/topup",
+			},
+		}},
+	})
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.IPv4(10, 60, 0, 1)}
+
+	require.NoError(t, dpNode.ActivateUpLinkTunnel(smContext))
+	require.NoError(t, dpNode.ActivateUpLinkPdr(smContext, &context.QER{}, 255))
+
+	portal := dpNode.UpLinkTunnel.PDR["portal"]
+	require.NotNil(t, portal)
+	require.Equal(t, &context.RedirectInformation{
+		RedirectAddressType:   ie.RedirectAddrURL,
+		RedirectServerAddress: "http://portal.example.com/topup",
+	}, portal.FAR.ForwardingParameters.RedirectInformation)
+	require.Nil(t, dpNode.UpLinkTunnel.PDR["default"].FAR.ForwardingParameters.RedirectInformation)
+
+	// redirection disabled then enabled again by the PCF
+	smContext.Tunnel = &context.UPTunnel{DataPathPool: context.DataPathPool{1: {FirstDPNode: dpNode, Activated: true}}}
+	portal.FAR.State = context.RULE_CREATE
+	updated := smContext.UpdateRedirectInformation(map[string]*models.TrafficControlData{
+		"portal-tc": {TcId: "portal-tc", FlowStatus: models.FlowStatus_ENABLED},
+	})
+	require.Equal(t, map[*context.UPF][]*context.FAR{dpNode.UPF: {portal.FAR}}, updated)
+	require.Nil(t, portal.FAR.ForwardingParameters.RedirectInformation)
+	require.Equal(t, context.RULE_UPDATE, portal.FAR.State)
+
+	enabled := &models.TrafficControlData{TcId: "portal-tc", RedirectInfo: &models.RedirectInformation{
+		RedirectEnabled:       true,
+		RedirectAddressType:   models.IPV4_ADDRRedirectAddressType,
+		RedirectServerAddress: "10.10.10.10",
+	}}
+	updated = smContext.UpdateRedirectInformation(map[string]*models.TrafficControlData{"portal-tc": enabled})
+	require.Equal(t, map[*context.UPF][]*context.FAR{dpNode.UPF: {portal.FAR}}, updated)
+	require.Equal(t, &context.RedirectInformation{RedirectAddressType: ie.RedirectAddrIPv4, RedirectServerAddress: "10.10.10.10"},
+		portal.FAR.ForwardingParameters.RedirectInformation)
+
+	// unchanged redirection, nothing to update
+	require.Empty(t, smContext.UpdateRedirectInformation(map[string]*models.TrafficControlData{"portal-tc": enabled}))
+}
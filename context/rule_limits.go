@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/qos"
+	"github.com/omec-project/smf/smferrors"
+)
+
+// ErrSessionRuleLimit is returned when the policy of a session needs more PFCP rules than
+// allowed per session
+var ErrSessionRuleLimit = smferrors.New(smferrors.ErrCodeSessionRuleLimit, "session rule limit exceeded")
+
+// SessionRuleCounts returns the PDRs and QERs installed on each UPF of the session for its PCC
+// rules: a PDR per rule and direction plus the default PDR of each direction if no rule is the
+// default one, a QER per rule and direction plus the session QER
+func SessionRuleCounts(decision *models.SmPolicyDecision, pccRules map[string]*models.PccRule) (pdrs, qers int) {
+	rules := 0
+	for _, rule := range pccRules {
+		if rule != nil {
+			rules++
+		}
+	}
+	pdrs = 2 * rules
+	if qos.GetDefaultPccRuleName(decision, pccRules) == "" {
+		pdrs += 2
+	}
+	qers = 2*rules + 1
+	return pdrs, qers
+}
+
+// CheckSessionRuleLimits returns an ErrSessionRuleLimit error if the session, once the policy
+// update committed, needs more PDRs or QERs per UPF than configured
+func (smContext *SMContext) CheckSessionRuleLimits(update *qos.PolicyUpdate) error {
+	maxPDRs, maxQERs := SMF_Self().MaxPDRsPerSession, SMF_Self().MaxQERsPerSession
+	if update == nil || (maxPDRs == 0 && maxQERs == 0) {
+		return nil
+	}
+	rules := qos.PccRulesAfterUpdate(smContext.SmPolicyData.SmCtxtPccRules.PccRules, update.PccRuleUpdate)
+	pdrs, qers := SessionRuleCounts(update.SmPolicyDecision, rules)
+	if maxPDRs > 0 && pdrs > maxPDRs {
+		return fmt.Errorf("%w: %d PDRs needed per UPF for %d PCC rules, maximum %d", ErrSessionRuleLimit, pdrs, len(rules), maxPDRs)
+	}
+	if maxQERs > 0 && qers > maxQERs {
+		return fmt.Errorf("%w: %d QERs needed per UPF for %d PCC rules, maximum %d", ErrSessionRuleLimit, qers, len(rules), maxQERs)
+	}
+	return nil
+}
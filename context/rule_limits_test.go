@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+)
+
+func newRuleLimitsDecision() *models.SmPolicyDecision {
+	dnnInfo := &context.SnssaiSmfDnnInfo{DefaultQos: &factory.DnnDefaultQos{
+		Var5qi:              9,
+		ArpPriorityLevel:    8,
+		SessionAmbrUplink:   "100 Mbps",
+		SessionAmbrDownlink: "100 Mbps",
+	}}
+	return dnnInfo.BuildDefaultSmPolicyDecision()
+}
+
+func TestSessionRuleCounts(t *testing.T) {
+	decision := newRuleLimitsDecision()
+
+	// the default PCC rule is the default PDR of each direction
+	pdrs, qers := context.SessionRuleCounts(decision, decision.PccRules)
+	require.Equal(t, 2, pdrs)
+	require.Equal(t, 3, qers)
+
+	// without default PCC rule, a default PDR is added per direction
+	rules := map[string]*models.PccRule{
+		"video": {
+			PccRuleId:  "video",
+			RefQosData: []string{"DefaultQosData"},
+			RefTcData:  []string{"DefaultTcData"},
+			FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from 10.10.0.0/24 to assigned"}},
+		},
+	}
+	pdrs, qers = context.SessionRuleCounts(decision, rules)
+	require.Equal(t, 4, pdrs)
+	require.Equal(t, 3, qers)
+}
+
+func TestCheckSessionRuleLimits(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	origMaxPDRs, origMaxQERs := smfSelf.MaxPDRsPerSession, smfSelf.MaxQERsPerSession
+	t.Cleanup(func() {
+		smfSelf.MaxPDRsPerSession, smfSelf.MaxQERsPerSession = origMaxPDRs, origMaxQERs
+	})
+
+	smContext := context.NewSMContext("imsi-208930000000401", 1)
+	decision := newRuleLimitsDecision()
+	decision.PccRules["video"] = &models.PccRule{
+		PccRuleId:  "video",
+		Precedence: 10,
+		RefQosData: []string{"DefaultQosData"},
+		RefTcData:  []string{"DefaultTcData"},
+		FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from 10.10.0.0/24 to assigned"}},
+	}
+	update := qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, decision)
+
+	// not limited if not configured
+	smfSelf.MaxPDRsPerSession, smfSelf.MaxQERsPerSession = 0, 0
+	require.NoError(t, smContext.CheckSessionRuleLimits(update))
+
+	smfSelf.MaxPDRsPerSession, smfSelf.MaxQERsPerSession = 4, 5
+	require.NoError(t, smContext.CheckSessionRuleLimits(update))
+
+	smfSelf.MaxPDRsPerSession = 3
+	err := smContext.CheckSessionRuleLimits(update)
+	require.ErrorIs(t, err, context.ErrSessionRuleLimit)
+	require.ErrorContains(t, err, "4 PDRs needed per UPF for 2 PCC rules, maximum 3")
+
+	smfSelf.MaxPDRsPerSession, smfSelf.MaxQERsPerSession = 0, 4
+	err = smContext.CheckSessionRuleLimits(update)
+	require.ErrorIs(t, err, context.ErrSessionRuleLimit)
+	require.ErrorContains(t, err, "5 QERs needed per UPF for 2 PCC rules, maximum 4")
+
+	// the PCC rules removed by the PCF are not counted
+	require.NoError(t, qos.CommitSmPolicyDecision(&smContext.SmPolicyData, update))
+	removal := newRuleLimitsDecision()
+	removal.PccRules = map[string]*models.PccRule{"video": nil}
+	require.NoError(t, smContext.CheckSessionRuleLimits(qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, removal)))
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ParseSbiAdvertisedURI validates the URI advertised to the NRF and the peers, returned without
+// trailing slash
+func ParseSbiAdvertisedURI(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid advertised URI %q: %w", uri, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("advertised URI %q scheme must be http or https", uri)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("advertised URI %q has no host", uri)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" || (u.Path != "" && u.Path != "/") {
+		return "", fmt.Errorf("advertised URI %q must only hold a scheme, a host and a port", uri)
+	}
+	if port := u.Port(); port != "" {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return "", fmt.Errorf("advertised URI %q has an invalid port", uri)
+		}
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// ValidateSbiBindingAddress validates the address the SBI server binds to, an IP address or a
+// host name
+func ValidateSbiBindingAddress(addr string) error {
+	if net.ParseIP(addr) != nil {
+		return nil
+	}
+	if len(addr) == 0 || len(addr) > 253 {
+		return fmt.Errorf("invalid binding address %q", addr)
+	}
+	for _, label := range strings.Split(addr, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid binding address %q", addr)
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+				return fmt.Errorf("invalid binding address %q", addr)
+			}
+		}
+	}
+	return nil
+}
+
+// SBIUri returns the URI of the SBI of the SMF as seen by the NRF and the peers, the advertised
+// URI if configured
+func (c *SMFContext) SBIUri() string {
+	if c.AdvertisedURI != "" {
+		return c.AdvertisedURI
+	}
+	return fmt.Sprintf("%s://%s:%d", c.URIScheme, c.RegisterIPv4, c.SBIPort)
+}
+
+// NFProfileAddress returns the IPv4 address or the FQDN of the SMF registered at the NRF, the host
+// of the advertised URI if configured
+func (c *SMFContext) NFProfileAddress() (ipv4 string, fqdn string) {
+	if c.AdvertisedURI == "" {
+		return c.RegisterIPv4, ""
+	}
+	u, err := url.Parse(c.AdvertisedURI)
+	if err != nil {
+		return c.RegisterIPv4, ""
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		return host, ""
+	}
+	return "", host
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSbiAdvertisedURI(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"https://smf.example.com:8443": "https://smf.example.com:8443",
+		"http://203.0.113.10:29502/":   "http://203.0.113.10:29502",
+		"https://smf.example.com":      "https://smf.example.com",
+		"http://[2001:db8::1]:29502":   "http://[2001:db8::1]:29502",
+	} {
+		parsed, err := context.ParseSbiAdvertisedURI(uri)
+		require.NoError(t, err, uri)
+		require.Equal(t, expected, parsed, uri)
+	}
+	for _, uri := range []string{
+		"",
+		"smf.example.com:8443",
+		"ftp://smf.example.com",
+		"https://",
+		"https://smf.example.com:8443/nsmf-pdusession",
+		"https://smf.example.com:8443?a=b",
+		"https://user@smf.example.com",
+		"https://smf.example.com:99999",
+	} {
+		_, err := context.ParseSbiAdvertisedURI(uri)
+		require.Error(t, err, uri)
+	}
+}
+
+func TestValidateSbiBindingAddress(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0", "10.0.0.5", "::", "smf", "smf.core.svc.cluster.local"} {
+		require.NoError(t, context.ValidateSbiBindingAddress(addr), addr)
+	}
+	for _, addr := range []string{"", "10.0.0.5:29502", "http://smf", "-smf", "smf..local", "smf_1"} {
+		require.Error(t, context.ValidateSbiBindingAddress(addr), addr)
+	}
+}
+
+func TestSBIUri(t *testing.T) {
+	smfContext := &context.SMFContext{
+		URIScheme:    models.UriScheme_HTTP,
+		RegisterIPv4: "10.0.0.5",
+		BindingIPv4:  "0.0.0.0",
+		SBIPort:      29502,
+	}
+	require.Equal(t, "http://10.0.0.5:29502", smfContext.SBIUri())
+	ipv4, fqdn := smfContext.NFProfileAddress()
+	require.Equal(t, "10.0.0.5", ipv4)
+	require.Empty(t, fqdn)
+
+	smfContext.AdvertisedURI = "https://203.0.113.10:8443"
+	require.Equal(t, "https://203.0.113.10:8443", smfContext.SBIUri())
+	ipv4, fqdn = smfContext.NFProfileAddress()
+	require.Equal(t, "203.0.113.10", ipv4)
+	require.Empty(t, fqdn)
+
+	smfContext.AdvertisedURI = "https://smf.example.com:8443"
+	ipv4, fqdn = smfContext.NFProfileAddress()
+	require.Empty(t, ipv4)
+	require.Equal(t, "smf.example.com", fqdn)
+}
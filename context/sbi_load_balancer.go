@@ -0,0 +1,274 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omec-project/openapi/Namf_Communication"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/logger"
+)
+
+// healthScoreMax is the health score of an instance answering without server errors
+const healthScoreMax = 10
+
+// SBIInstanceRecoveryInterval is the time an instance is skipped after a server error
+var SBIInstanceRecoveryInterval = 10 * time.Second
+
+// SBIInstance is an NF instance served by a LoadBalancedSBIClient
+type SBIInstance struct {
+	failedAt     time.Time
+	NfInstanceId string
+	// API root of the Namf_Communication service of the instance
+	ApiPrefix string
+	Weight    int
+	health    int
+	// smooth weighted round-robin state
+	current int
+}
+
+// LoadBalancedSBIClient distributes the requests to an NF over its instances with a smooth
+// weighted round-robin. The weight of an instance is scaled by its health score, which drops to
+// zero when the instance answers with a server error and grows back with each successful answer.
+// An instance is skipped for SBIInstanceRecoveryInterval after a server error. A failed request
+// is retried once on another instance if it is idempotent, or if it was not sent.
+type LoadBalancedSBIClient struct {
+	instances []*SBIInstance
+	lock      sync.Mutex
+}
+
+func NewLoadBalancedSBIClient(instances []*SBIInstance) *LoadBalancedSBIClient {
+	for _, instance := range instances {
+		if instance.Weight <= 0 {
+			instance.Weight = 1
+		}
+		instance.health = healthScoreMax
+	}
+	return &LoadBalancedSBIClient{instances: instances}
+}
+
+// amfInstances returns the instances of the Namf_Communication service of the AMFs, weighted by
+// their capacity
+func amfInstances(profiles []models.NfProfile) []*SBIInstance {
+	instances := make([]*SBIInstance, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.NfServices == nil {
+			continue
+		}
+		for _, service := range *profile.NfServices {
+			if service.ServiceName != models.ServiceName_NAMF_COMM {
+				continue
+			}
+			weight := service.Capacity
+			if weight == 0 {
+				weight = profile.Capacity
+			}
+			instances = append(instances, &SBIInstance{
+				NfInstanceId: profile.NfInstanceId,
+				ApiPrefix:    service.ApiPrefix,
+				Weight:       int(weight),
+			})
+			break
+		}
+	}
+	return instances
+}
+
+// NewAMFLoadBalancedSBIClient returns a client over the Namf_Communication service of the AMF
+// instances, weighted by their capacity
+func NewAMFLoadBalancedSBIClient(profiles []models.NfProfile) *LoadBalancedSBIClient {
+	return NewLoadBalancedSBIClient(amfInstances(profiles))
+}
+
+// amfSetClients are the clients of the AMF sets, by AMFSetKey. They are shared by the sessions
+// of the UEs of the sets, so that an AMF failing for a session is skipped for all of them.
+var amfSetClients sync.Map // map[string]*LoadBalancedSBIClient
+
+// AMFSetKey returns the key of the AMF set of the GUAMI, and its AMF region and AMF set IDs as
+// searched in the NRF. TS 23.003 2.10.1
+func AMFSetKey(guami *models.Guami) (key, regionID, setID string, ok bool) {
+	if guami == nil || guami.PlmnId == nil || len(guami.AmfId) != 6 {
+		return "", "", "", false
+	}
+	amfID, err := strconv.ParseUint(guami.AmfId, 16, 32)
+	if err != nil {
+		return "", "", "", false
+	}
+	regionID = fmt.Sprintf("%02x", amfID>>16)
+	setID = fmt.Sprintf("%03x", (amfID>>6)&0x3ff)
+	return guami.PlmnId.Mcc + guami.PlmnId.Mnc + "-" + regionID + "-" + setID, regionID, setID, true
+}
+
+// AMFSetClient returns the client of the AMF set shared by its sessions, its instances updated
+// to the discovered AMF profiles. The health of the instances still discovered is kept.
+func AMFSetClient(key string, profiles []models.NfProfile) *LoadBalancedSBIClient {
+	instances := amfInstances(profiles)
+	client, loaded := amfSetClients.LoadOrStore(key, NewLoadBalancedSBIClient(instances))
+	if loaded {
+		client.(*LoadBalancedSBIClient).update(instances)
+	}
+	return client.(*LoadBalancedSBIClient)
+}
+
+// update replaces the instances of the client, the ones it already had keeping their state
+func (c *LoadBalancedSBIClient) update(instances []*SBIInstance) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	known := make(map[string]*SBIInstance, len(c.instances))
+	for _, instance := range c.instances {
+		known[instance.NfInstanceId] = instance
+	}
+	updated := make([]*SBIInstance, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Weight <= 0 {
+			instance.Weight = 1
+		}
+		// the instances being read by the requests in progress, the state is copied over
+		if existing := known[instance.NfInstanceId]; existing != nil {
+			instance.health = existing.health
+			instance.failedAt = existing.failedAt
+			instance.current = existing.current
+		} else {
+			instance.health = healthScoreMax
+		}
+		updated = append(updated, instance)
+	}
+	c.instances = updated
+}
+
+// Len returns the number of instances of the client
+func (c *LoadBalancedSBIClient) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.instances)
+}
+
+func (instance *SBIInstance) effectiveWeight() int {
+	weight := instance.Weight * instance.health / healthScoreMax
+	if weight < 1 {
+		weight = 1
+	}
+	return weight
+}
+
+// pick selects the next instance, other than the excluded one. When all instances failed recently
+// and fallback is set, the instance failed for the longest time is selected.
+func (c *LoadBalancedSBIClient) pick(exclude *SBIInstance, fallback bool) *SBIInstance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	var selected, oldestFailed *SBIInstance
+	total := 0
+	for _, instance := range c.instances {
+		if instance == exclude {
+			continue
+		}
+		if instance.health == 0 && now.Sub(instance.failedAt) < SBIInstanceRecoveryInterval {
+			if oldestFailed == nil || instance.failedAt.Before(oldestFailed.failedAt) {
+				oldestFailed = instance
+			}
+			continue
+		}
+		weight := instance.effectiveWeight()
+		instance.current += weight
+		total += weight
+		if selected == nil || instance.current > selected.current {
+			selected = instance
+		}
+	}
+	if selected == nil {
+		if fallback {
+			return oldestFailed
+		}
+		return nil
+	}
+	selected.current -= total
+	return selected
+}
+
+// record updates the health score of the instance with the outcome of a request,
+// returns true when the instance failed
+func (c *LoadBalancedSBIClient) record(instance *SBIInstance, rsp *http.Response, err error) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if (err != nil && rsp == nil) || (rsp != nil && rsp.StatusCode >= http.StatusInternalServerError) {
+		instance.health = 0
+		instance.failedAt = time.Now()
+		return true
+	}
+	if instance.health < healthScoreMax {
+		instance.health++
+	}
+	return false
+}
+
+// notSent tells whether a request failed before it was sent, its connection not being set up
+func notSent(rsp *http.Response, err error) bool {
+	var opErr *net.OpError
+	return rsp == nil && errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Do sends the request built by op to an instance of the NF. A request that is not idempotent is
+// retried on another instance only if it was not sent to the failed one.
+func (c *LoadBalancedSBIClient) Do(idempotent bool, op func(instance *SBIInstance) (*http.Response, error)) (*http.Response, error) {
+	instance := c.pick(nil, true)
+	if instance == nil {
+		return nil, fmt.Errorf("no NF instance available")
+	}
+
+	rsp, err := op(instance)
+	if !c.record(instance, rsp, err) || !(idempotent || notSent(rsp, err)) {
+		return rsp, err
+	}
+
+	retry := c.pick(instance, false)
+	if retry == nil {
+		return rsp, err
+	}
+	logger.CtxLog.Warnf("NF instance[%s] failed, retrying on NF instance[%s]", instance.NfInstanceId, retry.NfInstanceId)
+	rsp, err = op(retry)
+	c.record(retry, rsp, err)
+	return rsp, err
+}
+
+// N1N2MessageTransfer sends the N1N2 message of the session to the AMF, balanced over the AMF
+// instances of the AMF set of the UE. An overload answer of the AMF delays the new sessions of its UEs.
+func (smContext *SMContext) N1N2MessageTransfer(ctx context.Context, request models.N1N2MessageTransferRequest) (
+	models.N1N2MessageTransferRspData, error,
+) {
+	if smContext.AMFClient == nil || smContext.AMFClient.Len() == 0 {
+		rspData, rsp, err := smContext.CommunicationClient.
+			N1N2MessageCollectionDocumentApi.
+			N1N2MessageTransfer(ctx, smContext.Supi, request)
+		overloadController.Observe(smContext.ServingNfId, rsp, time.Now())
+		return rspData, err
+	}
+
+	// the transfer, a POST, is not retried once sent
+	var rspData models.N1N2MessageTransferRspData
+	_, err := smContext.AMFClient.Do(false, func(instance *SBIInstance) (*http.Response, error) {
+		var (
+			rsp *http.Response
+			err error
+		)
+		communicationConf := Namf_Communication.NewConfiguration()
+		communicationConf.SetBasePath(instance.ApiPrefix)
+		smContext.SetCorrelationHeader(communicationConf)
+		rspData, rsp, err = Namf_Communication.NewAPIClient(communicationConf).
+			N1N2MessageCollectionDocumentApi.N1N2MessageTransfer(ctx, smContext.Supi, request)
+		overloadController.Observe(instance.NfInstanceId, rsp, time.Now())
+		return rsp, err
+	})
+	return rspData, err
+}
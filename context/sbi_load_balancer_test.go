@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newAMFServer returns an AMF answering N1N2 message transfers with the status, counting them
+func newAMFServer(t *testing.T, status int, count *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(count, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_ = json.NewEncoder(w).Encode(models.N1N2MessageTransferRspData{
+				Cause: models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED,
+			})
+		}
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func amfProfile(id, apiPrefix string) models.NfProfile {
+	return models.NfProfile{
+		NfInstanceId: id,
+		NfType:       models.NfType_AMF,
+		NfServices: &[]models.NfService{
+			{ServiceName: models.ServiceName_NAMF_COMM, ApiPrefix: apiPrefix},
+		},
+	}
+}
+
+func TestLoadBalancedSBIClientSkipsFailedInstance(t *testing.T) {
+	var failedCount, healthyCount int32
+	failed := newAMFServer(t, http.StatusInternalServerError, &failedCount)
+	healthy := newAMFServer(t, http.StatusOK, &healthyCount)
+
+	smContext := &context.SMContext{Supi: "imsi-208930000000001"}
+	smContext.AMFClient = context.NewAMFLoadBalancedSBIClient([]models.NfProfile{
+		amfProfile("amf-failed", failed.URL),
+		amfProfile("amf-healthy", healthy.URL),
+	})
+	require.Equal(t, 2, smContext.AMFClient.Len())
+
+	failures := 0
+	for i := 0; i < 4; i++ {
+		rspData, err := smContext.N1N2MessageTransfer(t.Context(), models.N1N2MessageTransferRequest{
+			JsonData: &models.N1N2MessageTransferReqData{PduSessionId: 1},
+		})
+		if err != nil {
+			failures++
+			continue
+		}
+		require.Equal(t, models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED, rspData.Cause)
+	}
+	// the transfer answered with a server error is not retried, the failed instance being skipped then
+	require.Equal(t, 1, failures)
+	require.Equal(t, int32(1), atomic.LoadInt32(&failedCount))
+	require.Equal(t, int32(3), atomic.LoadInt32(&healthyCount))
+}
+
+func TestLoadBalancedSBIClientRetriesUnsentRequest(t *testing.T) {
+	var healthyCount, unusedCount int32
+	unreachable := newAMFServer(t, http.StatusOK, &unusedCount)
+	unreachable.Close()
+	healthy := newAMFServer(t, http.StatusOK, &healthyCount)
+	profiles := []models.NfProfile{
+		amfProfile("amf-unreachable", unreachable.URL),
+		amfProfile("amf-healthy", healthy.URL),
+	}
+
+	// the sessions of the AMF set share its client and the health of its instances
+	smContext := &context.SMContext{Supi: "imsi-208930000000001"}
+	smContext.AMFClient = context.AMFSetClient(t.Name(), profiles)
+	other := &context.SMContext{Supi: "imsi-208930000000002"}
+	other.AMFClient = context.AMFSetClient(t.Name(), profiles)
+	require.Same(t, smContext.AMFClient, other.AMFClient)
+
+	for _, session := range []*context.SMContext{smContext, other, smContext, other} {
+		_, err := session.N1N2MessageTransfer(t.Context(), models.N1N2MessageTransferRequest{
+			JsonData: &models.N1N2MessageTransferReqData{PduSessionId: 1},
+		})
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(4), atomic.LoadInt32(&healthyCount))
+}
+
+func TestLoadBalancedSBIClientRetriesIdempotentRequest(t *testing.T) {
+	client := context.NewLoadBalancedSBIClient([]*context.SBIInstance{
+		{NfInstanceId: "amf-1"},
+		{NfInstanceId: "amf-2"},
+	})
+
+	for _, idempotent := range []bool{true, false} {
+		var attempts []string
+		_, _ = client.Do(idempotent, func(instance *context.SBIInstance) (*http.Response, error) {
+			attempts = append(attempts, instance.NfInstanceId)
+			return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+		})
+		if idempotent {
+			require.Len(t, attempts, 2)
+			require.NotEqual(t, attempts[0], attempts[1])
+		} else {
+			require.Len(t, attempts, 1)
+		}
+	}
+}
+
+func TestLoadBalancedSBIClientWeightedRoundRobin(t *testing.T) {
+	client := context.NewLoadBalancedSBIClient([]*context.SBIInstance{
+		{NfInstanceId: "amf-1", Weight: 2},
+		{NfInstanceId: "amf-2", Weight: 1},
+	})
+
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		_, err := client.Do(true, func(instance *context.SBIInstance) (*http.Response, error) {
+			counts[instance.NfInstanceId]++
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, counts["amf-1"])
+	require.Equal(t, 2, counts["amf-2"])
+}
+
+func TestAMFSetKey(t *testing.T) {
+	key, regionID, setID, ok := context.AMFSetKey(&models.Guami{
+		PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"},
+		AmfId:  "cafe00",
+	})
+	require.True(t, ok)
+	require.Equal(t, "ca", regionID)
+	require.Equal(t, "3f8", setID)
+	require.Equal(t, "20893-ca-3f8", key)
+
+	_, _, _, ok = context.AMFSetKey(nil)
+	require.False(t, ok)
+	_, _, _, ok = context.AMFSetKey(&models.Guami{PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, AmfId: "cafe"})
+	require.False(t, ok)
+}
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/omec-project/aper"
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/openapi/models"
+)
+
+// N2SmInfoTypeSecondaryRATUsage is the N2 SM information of the Secondary RAT Data Usage Report
+// Transfer relayed by the AMF. TS 29.502 6.1.6.3.7
+const N2SmInfoTypeSecondaryRATUsage models.N2SmInfoType = "SECONDARY_RAT_USAGE"
+
+// RAT types of the secondary RAT usage reports. TS 38.413 9.3.1.116
+const (
+	ngapRATTypeNR    aper.Enumerated = 0
+	ngapRATTypeEUTRA aper.Enumerated = 1
+)
+
+// seconds from the NTP epoch of the report timestamps to the Unix epoch
+const ntpUnixEpochOffset = 2208988800
+
+// SecondaryRATDataUsageReportTransfer is the NGAP Secondary RAT Data Usage Report Transfer. The
+// RAT types of the ngapType usage reports miss their constraint and cannot be decoded, they are
+// redefined here. TS 38.413 9.3.4.23
+type SecondaryRATDataUsageReportTransfer struct {
+	SecondaryRATUsageInformation *SecondaryRATUsageInformation                                                 `aper:"valueExt,optional"`
+	IEExtensions                 *ngapType.ProtocolExtensionContainerSecondaryRATDataUsageReportTransferExtIEs `aper:"optional"`
+}
+
+// SecondaryRATUsageInformation is the usage of the session and of its QoS flows on the secondary
+// RAT. TS 38.413 9.3.1.116
+type SecondaryRATUsageInformation struct {
+	PDUSessionUsageReport   *PDUSessionUsageReport                                                 `aper:"valueExt,optional"`
+	QosFlowsUsageReportList *QoSFlowsUsageReportList                                               `aper:"optional"`
+	IEExtension             *ngapType.ProtocolExtensionContainerSecondaryRATUsageInformationExtIEs `aper:"optional"`
+}
+
+// PDUSessionUsageReport is the usage of the session on the secondary RAT. TS 38.413 9.3.1.116
+type PDUSessionUsageReport struct {
+	RATType                   aper.Enumerated `aper:"valueExt,valueLB:0,valueUB:1"`
+	PDUSessionTimedReportList ngapType.VolumeTimedReportList
+	IEExtensions              *ngapType.ProtocolExtensionContainerPDUSessionUsageReportExtIEs `aper:"optional"`
+}
+
+// QoSFlowsUsageReportList is the usage of the QoS flows of the session on the secondary RAT.
+// TS 38.413 9.3.1.116
+type QoSFlowsUsageReportList struct {
+	List []QoSFlowsUsageReportItem `aper:"valueExt,sizeLB:1,sizeUB:64"`
+}
+
+// QoSFlowsUsageReportItem is the usage of a QoS flow on the secondary RAT. TS 38.413 9.3.1.116
+type QoSFlowsUsageReportItem struct {
+	QosFlowIdentifier       ngapType.QosFlowIdentifier
+	RATType                 aper.Enumerated `aper:"valueExt,valueLB:0,valueUB:1"`
+	QoSFlowsTimedReportList ngapType.VolumeTimedReportList
+	IEExtensions            *ngapType.ProtocolExtensionContainerQoSFlowsUsageReportItemExtIEs `aper:"optional"`
+}
+
+// SecondaryRATUsage is the volume of the session, or of one of its QoS flows, on the secondary RAT
+// of a dual connectivity over a period, reported by the NG-RAN. TS 38.413 9.3.4.23
+type SecondaryRATUsage struct {
+	RATType models.RatType `json:"ratType"`
+	// QoS flow of the usage, 0 for the usage of the whole session
+	QFI            uint8     `json:"qfi,omitempty"`
+	StartTime      time.Time `json:"startTime"`
+	EndTime        time.Time `json:"endTime"`
+	UplinkVolume   uint64    `json:"uplinkVolume"`
+	DownlinkVolume uint64    `json:"downlinkVolume"`
+}
+
+// AddSecondaryRATUsage records the secondary RAT usage reported by the NG-RAN, to be sent in the
+// next Charging Data Request of the session
+func (smContext *SMContext) AddSecondaryRATUsage(usage []SecondaryRATUsage) {
+	smContext.secondaryRATUsageLock.Lock()
+	defer smContext.secondaryRATUsageLock.Unlock()
+	smContext.secondaryRATUsage = append(smContext.secondaryRATUsage, usage...)
+	smContext.unchargedSecondaryRATUsage = append(smContext.unchargedSecondaryRATUsage, usage...)
+}
+
+// SecondaryRATUsage returns the secondary RAT usage reported by the NG-RAN for the session
+func (smContext *SMContext) SecondaryRATUsage() []SecondaryRATUsage {
+	smContext.secondaryRATUsageLock.Lock()
+	defer smContext.secondaryRATUsageLock.Unlock()
+	return append([]SecondaryRATUsage(nil), smContext.secondaryRATUsage...)
+}
+
+// ChargeSecondaryRATUsage returns the secondary RAT usage not sent to charging yet, of the RAT type
+// of the oldest one, and records it as sent. The usage of another RAT type is left for the next
+// Charging Data Request.
+func (smContext *SMContext) ChargeSecondaryRATUsage() []SecondaryRATUsage {
+	smContext.secondaryRATUsageLock.Lock()
+	defer smContext.secondaryRATUsageLock.Unlock()
+	if len(smContext.unchargedSecondaryRATUsage) == 0 {
+		return nil
+	}
+	ratType := smContext.unchargedSecondaryRATUsage[0].RATType
+	var charged, uncharged []SecondaryRATUsage
+	for _, usage := range smContext.unchargedSecondaryRATUsage {
+		if usage.RATType == ratType {
+			charged = append(charged, usage)
+		} else {
+			uncharged = append(uncharged, usage)
+		}
+	}
+	smContext.unchargedSecondaryRATUsage = uncharged
+	return charged
+}
+
+// HandleSecondaryRATDataUsageReportTransfer returns the secondary RAT usage of the Secondary RAT
+// Data Usage Report Transfer, per volume timed report of the session and of its QoS flows.
+// TS 38.413 9.3.4.23
+func HandleSecondaryRATDataUsageReportTransfer(b []byte) ([]SecondaryRATUsage, error) {
+	transfer := SecondaryRATDataUsageReportTransfer{}
+	if err := aper.UnmarshalWithParams(b, &transfer, "valueExt"); err != nil {
+		return nil, err
+	}
+	info := transfer.SecondaryRATUsageInformation
+	if info == nil {
+		return nil, nil
+	}
+
+	var usage []SecondaryRATUsage
+	if report := info.PDUSessionUsageReport; report != nil {
+		reports, err := secondaryRATUsage(report.RATType, 0, report.PDUSessionTimedReportList)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, reports...)
+	}
+	if info.QosFlowsUsageReportList != nil {
+		for _, item := range info.QosFlowsUsageReportList.List {
+			reports, err := secondaryRATUsage(item.RATType, uint8(item.QosFlowIdentifier.Value), item.QoSFlowsTimedReportList)
+			if err != nil {
+				return nil, err
+			}
+			usage = append(usage, reports...)
+		}
+	}
+	return usage, nil
+}
+
+func secondaryRATUsage(ngapRATType aper.Enumerated, qfi uint8, timedReports ngapType.VolumeTimedReportList) (
+	[]SecondaryRATUsage, error,
+) {
+	var ratType models.RatType
+	switch ngapRATType {
+	case ngapRATTypeNR:
+		ratType = models.RatType_NR
+	case ngapRATTypeEUTRA:
+		ratType = models.RatType_EUTRA
+	default:
+		return nil, fmt.Errorf("secondary RAT type %d not supported", ngapRATType)
+	}
+	usage := make([]SecondaryRATUsage, 0, len(timedReports.List))
+	for _, report := range timedReports.List {
+		usage = append(usage, SecondaryRATUsage{
+			RATType:        ratType,
+			QFI:            qfi,
+			StartTime:      ntpTime(report.StartTimeStamp),
+			EndTime:        ntpTime(report.EndTimeStamp),
+			UplinkVolume:   uint64(report.UsageCountUL),
+			DownlinkVolume: uint64(report.UsageCountDL),
+		})
+	}
+	return usage, nil
+}
+
+// ntpTime returns the time of the seconds of an NTP timestamp. RFC 5905 6
+func ntpTime(timestamp aper.OctetString) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint32(timestamp))-ntpUnixEpochOffset, 0).UTC()
+}
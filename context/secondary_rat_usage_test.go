@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/omec-project/aper"
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+// ntpTimeStamp encodes the seconds of the time as an NTP timestamp
+func ntpTimeStamp(t time.Time) aper.OctetString {
+	timestamp := make([]byte, 4)
+	binary.BigEndian.PutUint32(timestamp, uint32(t.Unix()+2208988800))
+	return timestamp
+}
+
+func TestHandleSecondaryRATDataUsageReportTransfer(t *testing.T) {
+	end := time.Date(2026, time.March, 14, 15, 9, 26, 0, time.UTC)
+	start := end.Add(-time.Minute)
+	timedReport := func(uplink, downlink int64) ngapType.VolumeTimedReportList {
+		return ngapType.VolumeTimedReportList{List: []ngapType.VolumeTimedReportItem{{
+			StartTimeStamp: ntpTimeStamp(start),
+			EndTimeStamp:   ntpTimeStamp(end),
+			UsageCountUL:   uplink,
+			UsageCountDL:   downlink,
+		}}}
+	}
+	buf, err := aper.MarshalWithParams(context.SecondaryRATDataUsageReportTransfer{
+		SecondaryRATUsageInformation: &context.SecondaryRATUsageInformation{
+			PDUSessionUsageReport: &context.PDUSessionUsageReport{
+				RATType:                   0,
+				PDUSessionTimedReportList: timedReport(400, 3000),
+			},
+			QosFlowsUsageReportList: &context.QoSFlowsUsageReportList{List: []context.QoSFlowsUsageReportItem{{
+				QosFlowIdentifier:       ngapType.QosFlowIdentifier{Value: 5},
+				RATType:                 1,
+				QoSFlowsTimedReportList: timedReport(100, 700),
+			}}},
+		},
+	}, "valueExt")
+	require.NoError(t, err)
+
+	usage, err := context.HandleSecondaryRATDataUsageReportTransfer(buf)
+	require.NoError(t, err)
+	require.Equal(t, []context.SecondaryRATUsage{
+		{RATType: models.RatType_NR, StartTime: start, EndTime: end, UplinkVolume: 400, DownlinkVolume: 3000},
+		{RATType: models.RatType_EUTRA, QFI: 5, StartTime: start, EndTime: end, UplinkVolume: 100, DownlinkVolume: 700},
+	}, usage)
+
+	_, err = context.HandleSecondaryRATDataUsageReportTransfer([]byte{0xff})
+	require.Error(t, err)
+}
+
+func TestChargeSecondaryRATUsage(t *testing.T) {
+	smContext := &context.SMContext{}
+	require.Nil(t, smContext.ChargeSecondaryRATUsage())
+
+	nr := context.SecondaryRATUsage{RATType: models.RatType_NR, UplinkVolume: 400, DownlinkVolume: 3000}
+	eutra := context.SecondaryRATUsage{RATType: models.RatType_EUTRA, UplinkVolume: 10, DownlinkVolume: 20}
+	smContext.AddSecondaryRATUsage([]context.SecondaryRATUsage{nr, eutra, nr})
+
+	// charged one RAT type at a time
+	require.Equal(t, []context.SecondaryRATUsage{nr, nr}, smContext.ChargeSecondaryRATUsage())
+	require.Equal(t, []context.SecondaryRATUsage{eutra}, smContext.ChargeSecondaryRATUsage())
+	require.Nil(t, smContext.ChargeSecondaryRATUsage())
+	// all the usage of the session is kept for its CDR
+	require.Len(t, smContext.SecondaryRATUsage(), 3)
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// SelfCheck verifies the invariants between the user plane indexes and the network slice
+// configuration of the SMF, and returns one error per violation found. An empty result means
+// the context is consistent.
+func (smfCtxt *SMFContext) SelfCheck() []error {
+	var violations []error
+	upi := smfCtxt.UserPlaneInformation
+	if upi == nil {
+		return violations
+	}
+
+	// every UPF with an address is indexed by it
+	for _, name := range slices.Sorted(maps.Keys(upi.UPFs)) {
+		upNode := upi.UPFs[name]
+		if upNode == nil {
+			violations = append(violations, fmt.Errorf("UPF[%s] has no node", name))
+			continue
+		}
+		ip := upNode.NodeID.ResolveNodeIdToIp()
+		if ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		ipStr := ip.String()
+		owner, ok := upi.UPFIPToName[ipStr]
+		switch {
+		case !ok:
+			violations = append(violations, fmt.Errorf("UPF[%s] IP[%s] missing from UPFIPToName", name, ipStr))
+		case owner != name && !slices.Contains(upi.UPFIPConflicts[ipStr], name):
+			violations = append(violations, fmt.Errorf("UPF[%s] IP[%s] indexed to UPF[%s] in UPFIPToName", name, ipStr, owner))
+		}
+	}
+
+	// every indexed address resolves to an existing node
+	for _, ipStr := range slices.Sorted(maps.Keys(upi.UPFIPToName)) {
+		name := upi.UPFIPToName[ipStr]
+		if _, ok := upi.UPNodes[name]; !ok {
+			violations = append(violations, fmt.Errorf("UPFIPToName IP[%s] refers to unknown UP node[%s]", ipStr, name))
+		}
+	}
+
+	// every DNN of the slices is served by an existing UPF
+	for _, snssaiInfo := range smfCtxt.SnssaiInfos {
+		for _, dnn := range slices.Sorted(maps.Keys(snssaiInfo.DnnInfos)) {
+			served := false
+			for _, upNode := range upi.UPFs {
+				if upNode != nil && upNode.UPF != nil && upNode.UPF.IsDnnConfigured(dnn) {
+					served = true
+					break
+				}
+			}
+			if !served {
+				violations = append(violations, fmt.Errorf("DNN[%s] of slice[sst:%d sd:%s] is not served by any UPF",
+					dnn, snssaiInfo.Snssai.Sst, snssaiInfo.Snssai.Sd))
+			}
+		}
+	}
+
+	// default paths only go through nodes of the topology
+	for _, selection := range slices.Sorted(maps.Keys(upi.DefaultUserPlanePath)) {
+		for _, pathNode := range upi.DefaultUserPlanePath[selection] {
+			known := false
+			for _, upNode := range upi.UPNodes {
+				if upNode == pathNode {
+					known = true
+					break
+				}
+			}
+			if !known {
+				violations = append(violations, fmt.Errorf("default path[%s] references unknown UP node[%s]",
+					selection, pathNode.NodeID.ResolveNodeIdToIp()))
+			}
+		}
+	}
+
+	return violations
+}
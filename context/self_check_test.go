@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/stretchr/testify/require"
+)
+
+func newSelfCheckContext(t *testing.T) *context.SMFContext {
+	t.Helper()
+	contexttest.SetUserPlane(t, context.NewUserPlaneInformation(configuration))
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai:   context.SNssai{Sst: 1, Sd: "112232"},
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{"internet": {}},
+		},
+	})
+	return context.SMF_Self()
+}
+
+func TestSelfCheck(t *testing.T) {
+	testCases := []struct {
+		name     string
+		corrupt  func(smfSelf *context.SMFContext)
+		expected string
+	}{
+		{
+			name: "UPF missing from IP index",
+			corrupt: func(smfSelf *context.SMFContext) {
+				delete(smfSelf.UserPlaneInformation.UPFIPToName, "192.168.179.1")
+			},
+			expected: "UPF[UPF1] IP[192.168.179.1] missing from UPFIPToName",
+		},
+		{
+			name: "IP index refers to another UPF",
+			corrupt: func(smfSelf *context.SMFContext) {
+				smfSelf.UserPlaneInformation.UPFIPToName["192.168.179.1"] = "UPF2"
+			},
+			expected: "UPF[UPF1] IP[192.168.179.1] indexed to UPF[UPF2] in UPFIPToName",
+		},
+		{
+			name: "IP index refers to a deleted node",
+			corrupt: func(smfSelf *context.SMFContext) {
+				smfSelf.UserPlaneInformation.UPFIPToName["192.168.179.9"] = "UPF9"
+			},
+			expected: "UPFIPToName IP[192.168.179.9] refers to unknown UP node[UPF9]",
+		},
+		{
+			name: "DNN not served by any UPF",
+			corrupt: func(smfSelf *context.SMFContext) {
+				smfSelf.SnssaiInfos[0].DnnInfos["ims"] = &context.SnssaiSmfDnnInfo{}
+			},
+			expected: "DNN[ims] of slice[sst:1 sd:112232] is not served by any UPF",
+		},
+		{
+			name: "default path through a deleted node",
+			corrupt: func(smfSelf *context.SMFContext) {
+				upi := smfSelf.UserPlaneInformation
+				staleNode := &context.UPNode{NodeID: *context.NewNodeID("192.168.179.9")}
+				upi.DefaultUserPlanePath["internet"] = []*context.UPNode{upi.UPNodes["GNodeB"], staleNode}
+			},
+			expected: "default path[internet] references unknown UP node[192.168.179.9]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smfSelf := newSelfCheckContext(t)
+			require.Empty(t, smfSelf.SelfCheck())
+
+			tc.corrupt(smfSelf)
+			violations := smfSelf.SelfCheck()
+			require.Len(t, violations, 1)
+			require.EqualError(t, violations[0], tc.expected)
+		})
+	}
+}
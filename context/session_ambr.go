@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/omec-project/openapi/models"
+)
+
+// sources of the session AMBR of a session without one from the policy, in order of precedence
+const (
+	SessionAmbrFromSubscription = "subscription"
+	SessionAmbrFromDnnDefault   = "dnn-default"
+)
+
+// FallbackSessionAmbr returns the session AMBR applied when the policy has none, with its source:
+// the session AMBR subscribed in the UDM, else the one of the default QoS of the DNN. Returns nil
+// when neither is known.
+func (smContext *SMContext) FallbackSessionAmbr() (*models.Ambr, string) {
+	if ambr := smContext.DnnConfiguration.SessionAmbr; ambr != nil && ambr.Uplink != "" && ambr.Downlink != "" {
+		return &models.Ambr{Uplink: ambr.Uplink, Downlink: ambr.Downlink}, SessionAmbrFromSubscription
+	}
+	if smContext.DNNInfo != nil && smContext.DNNInfo.DefaultQos != nil {
+		defaultQos := smContext.DNNInfo.DefaultQos
+		if defaultQos.SessionAmbrUplink != "" && defaultQos.SessionAmbrDownlink != "" {
+			return &models.Ambr{
+				Uplink:   defaultQos.SessionAmbrUplink,
+				Downlink: defaultQos.SessionAmbrDownlink,
+			}, SessionAmbrFromDnnDefault
+		}
+	}
+	return nil, ""
+}
+
+// ApplySessionAmbrFallback sets the fallback session AMBR of the session on the session rules of
+// the policy decision without one, the session AMBR of the policy being kept. Fails when a rule is
+// left without session AMBR, none being subscribed nor configured for the DNN.
+func (smContext *SMContext) ApplySessionAmbrFallback(smPolicyDecision *models.SmPolicyDecision) error {
+	for id, rule := range smPolicyDecision.SessRules {
+		if rule == nil || rule.AuthSessAmbr != nil {
+			continue
+		}
+		ambr, source := smContext.FallbackSessionAmbr()
+		if ambr == nil {
+			return fmt.Errorf("session rule [%s] without session AMBR, none subscribed nor configured for DNN[%s]",
+				id, smContext.Dnn)
+		}
+		rule.AuthSessAmbr = ambr
+		smContext.SubPduSessLog.Infof("session rule [%s] without session AMBR from the policy, %s AMBR applied: UL[%s] DL[%s]",
+			id, source, ambr.Uplink, ambr.Downlink)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+)
+
+func sessionAmbrTestDecision(ambr *models.Ambr) *models.SmPolicyDecision {
+	return &models.SmPolicyDecision{
+		SessRules: map[string]*models.SessionRule{
+			"rule": {
+				SessRuleId:   "rule",
+				AuthSessAmbr: ambr,
+				AuthDefQos:   &models.AuthorizedDefaultQos{Var5qi: 9},
+			},
+		},
+		QosDecs: map[string]*models.QosData{
+			"default-qos": {QosId: "1", Var5qi: 9, DefQosFlowIndication: true},
+		},
+	}
+}
+
+func TestApplySessionAmbrFallback(t *testing.T) {
+	subscribed := &models.Ambr{Uplink: "50 Mbps", Downlink: "100 Mbps"}
+	dnnDefault := &factory.DnnDefaultQos{SessionAmbrUplink: "10 Mbps", SessionAmbrDownlink: "20 Mbps"}
+
+	testCases := []struct {
+		name       string
+		policy     *models.Ambr
+		subscribed *models.Ambr
+		dnnDefault *factory.DnnDefaultQos
+		ulMbr      uint64
+		dlMbr      uint64
+	}{
+		{
+			name:       "policy",
+			policy:     &models.Ambr{Uplink: "200 Mbps", Downlink: "400 Mbps"},
+			subscribed: subscribed,
+			dnnDefault: dnnDefault,
+			ulMbr:      200000,
+			dlMbr:      400000,
+		},
+		{
+			name:       "subscription",
+			subscribed: subscribed,
+			dnnDefault: dnnDefault,
+			ulMbr:      50000,
+			dlMbr:      100000,
+		},
+		{
+			name:       "DNN default",
+			subscribed: &models.Ambr{Uplink: "50 Mbps"},
+			dnnDefault: dnnDefault,
+			ulMbr:      10000,
+			dlMbr:      20000,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext, dpNode := newDefaultPdrTestNode(t, &models.SmPolicyDecision{})
+			smContext.Dnn = "internet"
+			smContext.SubPduSessLog = logger.PduSessLog
+			smContext.DnnConfiguration.SessionAmbr = tc.subscribed
+			smContext.DNNInfo = &context.SnssaiSmfDnnInfo{DefaultQos: tc.dnnDefault}
+
+			smPolicyDecision := sessionAmbrTestDecision(tc.policy)
+			require.NoError(t, smContext.ApplySessionAmbrFallback(smPolicyDecision))
+
+			// session AMBR enforced by the aggregate QER of the session
+			smPolicyData := qos.SmCtxtPolicyData{}
+			smPolicyData.Initialize()
+			smContext.SmPolicyUpdates = []*qos.PolicyUpdate{qos.BuildSmPolicyUpdate(&smPolicyData, smPolicyDecision)}
+			qer, err := dpNode.CreateSessRuleQer(smContext)
+			require.NoError(t, err)
+			require.Equal(t, tc.ulMbr, qer.MBR.ULMBR)
+			require.Equal(t, tc.dlMbr, qer.MBR.DLMBR)
+		})
+	}
+}
+
+func TestApplySessionAmbrFallbackNone(t *testing.T) {
+	smContext := &context.SMContext{
+		Dnn:           "internet",
+		SubPduSessLog: logger.PduSessLog,
+		DNNInfo:       &context.SnssaiSmfDnnInfo{},
+	}
+	ambr, _ := smContext.FallbackSessionAmbr()
+	require.Nil(t, ambr)
+	require.Error(t, smContext.ApplySessionAmbrFallback(sessionAmbrTestDecision(nil)))
+}
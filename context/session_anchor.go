@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/logger"
+)
+
+// time a session anchor is kept for the re-establishment of the session
+var SessionAnchorTTL = 10 * time.Minute
+
+// anchors of the sessions lost with their UPF, by SUPI, DNN and S-NSSAI
+var sessionAnchors sync.Map
+
+// SessionAnchor is the anchor UPF and the UE IP of a session lost with its UPF. The session
+// re-established once the UPF is associated again returns to them, keeping its IP.
+type SessionAnchor struct {
+	UPF  NodeID
+	UeIP net.IP
+	Time time.Time
+}
+
+func sessionAnchorKey(supi, dnn string, snssai *models.Snssai) string {
+	if snssai == nil {
+		return fmt.Sprintf("%s/%s", supi, dnn)
+	}
+	return fmt.Sprintf("%s/%s/%d-%s", supi, dnn, snssai.Sst, snssai.Sd)
+}
+
+// RememberSessionAnchor records the UPF as the anchor of the session lost with it, for its
+// re-establishment after the recovery of the UPF
+func (smContext *SMContext) RememberSessionAnchor(upf *UPF) {
+	anchor := &SessionAnchor{UPF: upf.NodeID, Time: time.Now()}
+	if smContext.PDUAddress != nil && !smContext.PDUAddress.UpfProvided {
+		anchor.UeIP = slices.Clone(smContext.PDUAddress.Ip)
+	}
+	sessionAnchors.Store(sessionAnchorKey(smContext.Supi, smContext.Dnn, smContext.Snssai), anchor)
+	smContext.SubCtxLog.Infof("anchor UPF[%s] UE IP[%s] remembered for the re-establishment of the session",
+		upf.NodeID.ResolveNodeIdToIp(), anchor.UeIP)
+}
+
+// TakeSessionAnchor returns the anchor remembered for the session, nil if none or expired. The
+// anchor is forgotten.
+func (smContext *SMContext) TakeSessionAnchor() *SessionAnchor {
+	value, ok := sessionAnchors.LoadAndDelete(sessionAnchorKey(smContext.Supi, smContext.Dnn, smContext.Snssai))
+	if !ok {
+		return nil
+	}
+	anchor := value.(*SessionAnchor)
+	if time.Since(anchor.Time) > SessionAnchorTTL {
+		return nil
+	}
+	return anchor
+}
+
+// AllocateUeIP allocates the UE IP of the session from the pool of its DNN, the UE IP of the
+// anchor if it is still free
+func (smContext *SMContext) AllocateUeIP(anchor *SessionAnchor) (net.IP, error) {
+	allocator := smContext.DNNInfo.UeIPAllocator
+	if anchor != nil && anchor.UeIP != nil {
+		if allocator.AllocateIP(anchor.UeIP) {
+			smContext.SubPduSessLog.Infof("UE IP[%s] of the session before the recovery of its UPF allocated", anchor.UeIP)
+			smContext.AuditUeIP(UeIPAllocated, anchor.UeIP, UeIPSourceSMF)
+			return anchor.UeIP, nil
+		}
+		smContext.SubPduSessLog.Warnf("UE IP[%s] of the session before the recovery of its UPF no longer free", anchor.UeIP)
+	}
+	ip, err := allocator.Allocate(smContext.Supi)
+	if err != nil {
+		return nil, err
+	}
+	smContext.AuditUeIP(UeIPAllocated, ip, UeIPSourceSMF)
+	return ip, nil
+}
+
+// AnchorUserPlanePath returns the path to the anchor UPF if it is associated and serves the
+// selection, nil otherwise
+func (upi *UserPlaneInformation) AnchorUserPlanePath(anchor *SessionAnchor, selection *UPFSelectionParams) UPPath {
+	if anchor == nil {
+		return nil
+	}
+	ip := anchor.UPF.ResolveNodeIdToIp().String()
+	upNode := upi.GetUPFNodeByIP(ip)
+	switch {
+	case upNode == nil:
+		return nil
+	case upNode.UPF.UPFStatus != AssociatedSetUpSuccess:
+		logger.CtxLog.Infof("anchor UPF[%s] of the session not associated, selecting another UPF", ip)
+		return nil
+	case upNode.servedDnnInfo(selection) == nil:
+		logger.CtxLog.Infof("anchor UPF[%s] of the session no longer serves %s", ip, selection.String())
+		return nil
+	}
+	path, _ := upi.pathToUPF(selection, upNode)
+	return path
+}
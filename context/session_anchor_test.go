@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/stretchr/testify/require"
+)
+
+func newAnchorSMContext(supi string, allocator *context.IPAllocator) *context.SMContext {
+	return contexttest.NewSMContext(supi, 1, &models.Snssai{Sst: 1, Sd: "010204"},
+		&context.SnssaiSmfDnnInfo{UeIPAllocator: allocator})
+}
+
+func TestSessionAnchorStickiness(t *testing.T) {
+	upi := newSelectionChainUPI(t)
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{
+			Sst: 1,
+			Sd:  "010204",
+		},
+		Dnn: "internet",
+	}
+	allocator, err := context.NewIPAllocator("10.60.0.0/24")
+	require.NoError(t, err)
+
+	// the session anchored on UPF-B is lost with it during its restart
+	lost := newAnchorSMContext("imsi-208930000000301", allocator)
+	_, err = allocator.Allocate("")
+	require.NoError(t, err)
+	ip, err := lost.AllocateUeIP(nil)
+	require.NoError(t, err)
+	lost.PDUAddress = &context.UeIpAddr{Ip: ip}
+	upfB := upi.UPFs["UPF-B"]
+	lost.RememberSessionAnchor(upfB.UPF)
+	require.NoError(t, lost.ReleaseUeIpAddr())
+	upfB.UPF.UPFStatus = context.NotAssociated
+
+	// the session is re-established once UPF-B is associated again
+	reestablished := newAnchorSMContext("imsi-208930000000301", allocator)
+	anchor := reestablished.TakeSessionAnchor()
+	require.NotNil(t, anchor)
+	require.Nil(t, upi.AnchorUserPlanePath(anchor, selection), "UPF-B not yet associated")
+	upfB.UPF.UPFStatus = context.AssociatedSetUpSuccess
+	path := upi.AnchorUserPlanePath(anchor, selection)
+	require.NotEmpty(t, path)
+	require.Same(t, upfB, path[len(path)-1], "session re-anchored on UPF-B")
+
+	reestablishedIP, err := reestablished.AllocateUeIP(anchor)
+	require.NoError(t, err)
+	require.True(t, ip.Equal(reestablishedIP), "session keeps its IP %s, got %s", ip, reestablishedIP)
+	require.Nil(t, reestablished.TakeSessionAnchor(), "anchor used once")
+}
+
+func TestSessionAnchorIPInUse(t *testing.T) {
+	allocator, err := context.NewIPAllocator("10.60.1.0/24")
+	require.NoError(t, err)
+	smContext := newAnchorSMContext("imsi-208930000000302", allocator)
+	inUse, err := allocator.Allocate("")
+	require.NoError(t, err)
+
+	// the IP of the session was allocated to another session meanwhile
+	ip, err := smContext.AllocateUeIP(&context.SessionAnchor{UeIP: inUse})
+	require.NoError(t, err)
+	require.False(t, ip.Equal(inUse))
+
+	require.False(t, allocator.AllocateIP(net.ParseIP("10.60.2.1")), "address outside the pool")
+}
+
+func TestSessionAnchorExpired(t *testing.T) {
+	origTTL := context.SessionAnchorTTL
+	context.SessionAnchorTTL = 10 * time.Millisecond
+	t.Cleanup(func() { context.SessionAnchorTTL = origTTL })
+
+	upi := newSelectionChainUPI(t)
+	smContext := newAnchorSMContext("imsi-208930000000303", nil)
+	smContext.RememberSessionAnchor(upi.UPFs["UPF-A"].UPF)
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, smContext.TakeSessionAnchor())
+}
@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"github.com/omec-project/smf/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// events of the session audit log
+const (
+	SessionAuditCreate  = "create"
+	SessionAuditModify  = "modify"
+	SessionAuditRelease = "release"
+)
+
+// AuditSession records the lifecycle event of the session requested from the source IP in the
+// audit log, if enabled. The release of a session is recorded once, and only if its creation was.
+func (smContext *SMContext) AuditSession(eventType, sourceIP string) {
+	auditLog := logger.AuditLog()
+	if !auditLog.Core().Enabled(zapcore.InfoLevel) {
+		return
+	}
+	switch eventType {
+	case SessionAuditCreate:
+		smContext.auditOpen.Store(true)
+	case SessionAuditRelease:
+		if !smContext.auditOpen.CompareAndSwap(true, false) {
+			return
+		}
+	}
+	operatorID := SMF_Self().AuditOperatorID
+	if operatorID == "" && smContext.ServingNetwork != nil {
+		operatorID = smContext.ServingNetwork.Mcc + smContext.ServingNetwork.Mnc
+	}
+	auditLog.Info("session "+eventType,
+		zap.String("eventType", eventType),
+		zap.String("smCtxRef", smContext.Ref),
+		zap.String("supi", smContext.Supi),
+		zap.String("dnn", smContext.Dnn),
+		zap.Any("snssai", smContext.Snssai),
+		zap.String("sourceIP", sourceIP),
+		zap.String("operatorID", operatorID))
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAuditSessionReleasedOnce(t *testing.T) {
+	logs := observeAuditLog(t)
+	smfSelf := context.SMF_Self()
+	origOperatorID := smfSelf.AuditOperatorID
+	t.Cleanup(func() { smfSelf.AuditOperatorID = origOperatorID })
+	smfSelf.AuditOperatorID = "operator-1"
+
+	smContext := newGnbSMContext(t, "imsi-208930000000151", net.ParseIP("127.0.0.11"))
+	smContext.Dnn = "internet"
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+
+	// not created, its release not recorded
+	smContext.AuditSession(context.SessionAuditRelease, "10.100.0.1")
+	smContext.AuditSession(context.SessionAuditCreate, "10.100.0.1")
+	smContext.AuditSession(context.SessionAuditRelease, "10.100.0.1")
+	// the context removed after the release
+	context.RemoveSMContext(smContext.Ref)
+
+	entries := logs.FilterField(zap.String("smCtxRef", smContext.Ref)).AllUntimed()
+	require.Len(t, entries, 2)
+	require.Equal(t, map[string]interface{}{
+		"eventType":  context.SessionAuditCreate,
+		"smCtxRef":   smContext.Ref,
+		"supi":       "imsi-208930000000151",
+		"dnn":        "internet",
+		"snssai":     &models.Snssai{Sst: 1, Sd: "010203"},
+		"sourceIP":   "10.100.0.1",
+		"operatorID": "operator-1",
+	}, entries[0].ContextMap())
+	require.Equal(t, context.SessionAuditRelease, entries[1].ContextMap()["eventType"])
+	require.Equal(t, "10.100.0.1", entries[1].ContextMap()["sourceIP"])
+}
+
+func TestAuditSessionReleasedBySMF(t *testing.T) {
+	logs := observeAuditLog(t)
+
+	smContext := newGnbSMContext(t, "imsi-208930000000152", net.ParseIP("127.0.0.12"))
+	smContext.AuditSession(context.SessionAuditCreate, "10.100.0.1")
+	context.RemoveSMContext(smContext.Ref)
+
+	entries := logs.FilterField(zap.String("smCtxRef", smContext.Ref)).AllUntimed()
+	require.Len(t, entries, 2)
+	require.Equal(t, context.SessionAuditRelease, entries[1].ContextMap()["eventType"])
+	require.Equal(t, "", entries[1].ContextMap()["sourceIP"])
+}
+
+func TestAuditSessionDisabled(t *testing.T) {
+	// no creation recorded while the audit log is disabled, its release not recorded either
+	smContext := newGnbSMContext(t, "imsi-208930000000153", net.ParseIP("127.0.0.13"))
+	smContext.AuditSession(context.SessionAuditCreate, "10.100.0.1")
+
+	logs := observeAuditLog(t)
+	smContext.AuditSession(context.SessionAuditRelease, "10.100.0.1")
+	context.RemoveSMContext(smContext.Ref)
+	require.Zero(t, logs.FilterField(zap.String("smCtxRef", smContext.Ref)).Len())
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+// StartEAPExchange starts the EAP exchange of the authentication of the session, the EAP
+// responses of the UE being received on the returned channel. The caller holds SMLock.
+func (smContext *SMContext) StartEAPExchange() <-chan []byte {
+	smContext.eapResponses = make(chan []byte, 1)
+	return smContext.eapResponses
+}
+
+// EndEAPExchange ends the EAP exchange of the authentication of the session, the EAP responses
+// of the UE being dropped from then on. The caller holds SMLock.
+func (smContext *SMContext) EndEAPExchange() {
+	smContext.eapResponses = nil
+}
+
+// DeliverEAPResponse delivers the EAP response of the UE to the EAP exchange of the session,
+// returns false if the session is not being authenticated or if the previous response is not
+// taken yet. The caller holds SMLock.
+func (smContext *SMContext) DeliverEAPResponse(eapMessage []byte) bool {
+	if smContext.eapResponses == nil {
+		return false
+	}
+	select {
+	case smContext.eapResponses <- eapMessage:
+		return true
+	default:
+		return false
+	}
+}
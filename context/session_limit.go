@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"sync"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/smferrors"
+)
+
+var (
+	ErrSessionQueueFull    = smferrors.New(smferrors.ErrCodeSessionQueueFull, "maximum concurrent sessions reached and session queue full")
+	ErrSessionQueueTimeout = smferrors.New(smferrors.ErrCodeSessionQueueTimeout, "session queue timeout")
+)
+
+// SessionLimiter limits the concurrent sessions of a DNN. An establishment beyond the limit waits
+// in a FIFO queue, and is given the session slot of the next released session. With preemption,
+// it first takes the slot of a preemptable session of a lower ARP priority.
+type SessionLimiter struct {
+	mu         sync.Mutex
+	dnn        string
+	max        int
+	depth      int
+	timeout    time.Duration
+	preemption bool
+	active     int
+	// establishments waiting for a slot, the channel being closed when the slot is given
+	queue []chan struct{}
+}
+
+// NewSessionLimiter returns the limiter of the sessions of the DNN
+func NewSessionLimiter(dnn string, config *factory.SessionLimitConfig) *SessionLimiter {
+	return &SessionLimiter{
+		dnn:     dnn,
+		max:     config.MaxConcurrentSessions,
+		depth:   config.QueueDepth,
+		timeout: time.Duration(config.QueueTimeoutMs) * time.Millisecond,
+
+		preemption: config.Preemption,
+	}
+}
+
+// Acquire takes a session slot, waiting in the queue while the DNN is at its limit. It fails when
+// the queue is full or the queue timeout expires.
+func (l *SessionLimiter) Acquire() error {
+	l.mu.Lock()
+	if l.active < l.max {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	if len(l.queue) >= l.depth {
+		l.mu.Unlock()
+		return ErrSessionQueueFull
+	}
+	granted := make(chan struct{})
+	l.queue = append(l.queue, granted)
+	metrics.SetSessionQueueDepth(l.dnn, len(l.queue))
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case <-granted:
+		return nil
+	case <-timer.C:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, waiting := range l.queue {
+		if waiting == granted {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			metrics.SetSessionQueueDepth(l.dnn, len(l.queue))
+			metrics.IncrementSessionQueueTimeouts(l.dnn)
+			return ErrSessionQueueTimeout
+		}
+	}
+	// the slot was given while the timer expired
+	return nil
+}
+
+// Release gives back the slot of a released session, to the first establishment of the queue if
+// any
+func (l *SessionLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.release()
+}
+
+func (l *SessionLimiter) release() {
+	if len(l.queue) > 0 {
+		close(l.queue[0])
+		l.queue = l.queue[1:]
+		metrics.SetSessionQueueDepth(l.dnn, len(l.queue))
+		return
+	}
+	if l.active > 0 {
+		l.active--
+	}
+}
+
+// Active returns the number of session slots in use
+func (l *SessionLimiter) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+// Queued returns the number of establishments waiting in the queue
+func (l *SessionLimiter) Queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue)
+}
+
+// preempt takes, for a session whose ARP may preempt, the slot of the active preemptable session
+// of the lowest ARP priority below its own. The ARP of a candidate is the one snapshotted when it
+// took its slot. The preempted session, returned, no longer holds a slot.
+func (l *SessionLimiter) preempt(smContext *SMContext, arp *models.Arp) *SMContext {
+	if arp == nil || arp.PreemptCap != models.PreemptionCapability_MAY_PREEMPT {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var preempted *SMContext
+	var preemptedLevel int32
+	smContextPool.Range(func(key, value interface{}) bool {
+		candidate := value.(*SMContext)
+		if candidate.sessionLimiter != l {
+			return true
+		}
+		candidateArp := candidate.slotArp
+		if candidateArp == nil || candidateArp.PreemptVuln != models.PreemptionVulnerability_PREEMPTABLE ||
+			candidateArp.PriorityLevel <= arp.PriorityLevel {
+			return true
+		}
+		// the lowest priority is the highest level, the ties broken by reference for determinism
+		if preempted == nil || candidateArp.PriorityLevel > preemptedLevel ||
+			(candidateArp.PriorityLevel == preemptedLevel && candidate.Ref < preempted.Ref) {
+			if candidate.isIdleActive() {
+				preempted, preemptedLevel = candidate, candidateArp.PriorityLevel
+			}
+		}
+		return true
+	})
+	if preempted != nil {
+		preempted.sessionLimiter, preempted.slotArp = nil, nil
+		smContext.sessionLimiter, smContext.slotArp = l, arp
+	}
+	return preempted
+}
+
+// isIdleActive tells whether the session is active with no procedure in progress, a session being
+// established, modified or released holding its lock
+func (smContext *SMContext) isIdleActive() bool {
+	if !smContext.SMLock.TryLock() {
+		return false
+	}
+	defer smContext.SMLock.Unlock()
+	return smContext.SMContextState == SmStateActive
+}
+
+// SessionRuleArp returns the ARP of the default QoS flow authorized by the session rule, nil if
+// none
+func SessionRuleArp(rule *models.SessionRule) *models.Arp {
+	if rule == nil || rule.AuthDefQos == nil {
+		return nil
+	}
+	return rule.AuthDefQos.Arp
+}
+
+// AcquireSessionSlot takes a slot of the concurrent sessions of the DNN of the session, released
+// with the session. When the sessions of the DNN preempt each other, the slot is taken by
+// AcquireSessionSlotByArp once the ARP of the session is authorized.
+func (smContext *SMContext) AcquireSessionSlot() error {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.SessionLimiter == nil || smContext.DNNInfo.SessionLimiter.preemption {
+		return nil
+	}
+	return smContext.acquireSessionSlot(smContext.DNNInfo.SessionLimiter, nil)
+}
+
+// AcquireSessionSlotByArp takes a slot of the concurrent sessions of the DNN of the session when
+// they preempt each other. At the limit, a session whose ARP may preempt takes the slot of a
+// preemptable session of a lower ARP priority, returned to be released, else it waits in the queue.
+func (smContext *SMContext) AcquireSessionSlotByArp(arp *models.Arp) (*SMContext, error) {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.SessionLimiter == nil || !smContext.DNNInfo.SessionLimiter.preemption {
+		return nil, nil
+	}
+	limiter := smContext.DNNInfo.SessionLimiter
+	if limiter.tryAcquire(smContext, arp) {
+		return nil, nil
+	}
+	if preempted := limiter.preempt(smContext, arp); preempted != nil {
+		metrics.IncrementSessionPreemptions(limiter.dnn)
+		return preempted, nil
+	}
+	return nil, smContext.acquireSessionSlot(limiter, arp)
+}
+
+// tryAcquire takes a free slot for the session, if any
+func (l *SessionLimiter) tryAcquire(smContext *SMContext, arp *models.Arp) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active >= l.max {
+		return false
+	}
+	l.active++
+	smContext.sessionLimiter, smContext.slotArp = l, arp
+	return true
+}
+
+func (smContext *SMContext) acquireSessionSlot(limiter *SessionLimiter, arp *models.Arp) error {
+	if err := limiter.Acquire(); err != nil {
+		return err
+	}
+	limiter.mu.Lock()
+	smContext.sessionLimiter, smContext.slotArp = limiter, arp
+	limiter.mu.Unlock()
+	return nil
+}
+
+// releaseSessionSlot gives back the slot of the session, unless it was preempted
+func (smContext *SMContext) releaseSessionSlot() {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.SessionLimiter == nil {
+		return
+	}
+	limiter := smContext.DNNInfo.SessionLimiter
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if smContext.sessionLimiter != limiter {
+		return
+	}
+	smContext.sessionLimiter, smContext.slotArp = nil, nil
+	limiter.release()
+}
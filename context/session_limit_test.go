@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionLimiterQueue(t *testing.T) {
+	limiter := context.NewSessionLimiter("internet", &factory.SessionLimitConfig{
+		MaxConcurrentSessions: 2, QueueDepth: 1, QueueTimeoutMs: 5000,
+	})
+	dnnInfo := &context.SnssaiSmfDnnInfo{SessionLimiter: limiter}
+	newSession := func(supi string) *context.SMContext {
+		smContext := newGnbSMContext(t, supi, net.ParseIP("10.1.0.1"))
+		smContext.DNNInfo = dnnInfo
+		return smContext
+	}
+
+	// the DNN is filled to capacity
+	first := newSession("imsi-208930000000601")
+	require.NoError(t, first.AcquireSessionSlot())
+	second := newSession("imsi-208930000000602")
+	require.NoError(t, second.AcquireSessionSlot())
+	t.Cleanup(func() { context.RemoveSMContext(second.Ref) })
+	require.Equal(t, 2, limiter.Active())
+
+	// the next establishment waits in the queue
+	queued := newSession("imsi-208930000000603")
+	t.Cleanup(func() { context.RemoveSMContext(queued.Ref) })
+	served := make(chan error, 1)
+	go func() { served <- queued.AcquireSessionSlot() }()
+	require.Eventually(t, func() bool { return limiter.Queued() == 1 }, time.Second, time.Millisecond)
+
+	// the queue is full
+	require.ErrorIs(t, limiter.Acquire(), context.ErrSessionQueueFull)
+
+	// the queued establishment is served on the release of a session
+	context.RemoveSMContext(first.Ref)
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("queued establishment not served")
+	}
+	require.Equal(t, 2, limiter.Active())
+	require.Zero(t, limiter.Queued())
+}
+
+func TestSessionLimiterQueueTimeout(t *testing.T) {
+	limiter := context.NewSessionLimiter("internet", &factory.SessionLimitConfig{
+		MaxConcurrentSessions: 1, QueueDepth: 1, QueueTimeoutMs: 10,
+	})
+	require.NoError(t, limiter.Acquire())
+
+	require.ErrorIs(t, limiter.Acquire(), context.ErrSessionQueueTimeout)
+	require.Zero(t, limiter.Queued())
+
+	limiter.Release()
+	require.Zero(t, limiter.Active())
+}
+
+func TestSessionLimiterWithoutQueue(t *testing.T) {
+	limiter := context.NewSessionLimiter("internet", &factory.SessionLimitConfig{MaxConcurrentSessions: 1})
+	require.NoError(t, limiter.Acquire())
+	require.ErrorIs(t, limiter.Acquire(), context.ErrSessionQueueFull)
+}
+
+func TestSessionLimiterPreemption(t *testing.T) {
+	limiter := context.NewSessionLimiter("internet", &factory.SessionLimitConfig{
+		MaxConcurrentSessions: 2, Preemption: true,
+	})
+	dnnInfo := &context.SnssaiSmfDnnInfo{SessionLimiter: limiter}
+	newSession := func(supi string, arp *models.Arp) *context.SMContext {
+		smContext := newGnbSMContext(t, supi, net.ParseIP("10.1.0.1"))
+		smContext.DNNInfo = dnnInfo
+		smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+		// no data path, the limiter needs none
+		smContext.Tunnel = nil
+		smContext.SmPolicyData.SmCtxtSessionRules.ActiveRule = &models.SessionRule{
+			AuthDefQos: &models.AuthorizedDefaultQos{Arp: arp},
+		}
+		t.Cleanup(func() {
+			if context.GetSMContext(smContext.Ref) != nil {
+				context.RemoveSMContext(smContext.Ref)
+			}
+		})
+		return smContext
+	}
+	arp := func(level int32, preemptCap models.PreemptionCapability,
+		preemptVuln models.PreemptionVulnerability,
+	) *models.Arp {
+		return &models.Arp{PriorityLevel: level, PreemptCap: preemptCap, PreemptVuln: preemptVuln}
+	}
+
+	// the slot is taken once the ARP is known
+	vulnerable := newSession("imsi-208930000000611",
+		arp(10, models.PreemptionCapability_NOT_PREEMPT, models.PreemptionVulnerability_PREEMPTABLE))
+	require.NoError(t, vulnerable.AcquireSessionSlot())
+	require.Zero(t, limiter.Active())
+	preempted, err := vulnerable.AcquireSessionSlotByArp(context.SessionRuleArp(vulnerable.SmPolicyData.SmCtxtSessionRules.ActiveRule))
+	require.NoError(t, err)
+	require.Nil(t, preempted)
+
+	protected := newSession("imsi-208930000000612",
+		arp(12, models.PreemptionCapability_NOT_PREEMPT, models.PreemptionVulnerability_NOT_PREEMPTABLE))
+	preempted, err = protected.AcquireSessionSlotByArp(context.SessionRuleArp(protected.SmPolicyData.SmCtxtSessionRules.ActiveRule))
+	require.NoError(t, err)
+	require.Nil(t, preempted)
+	require.Equal(t, 2, limiter.Active())
+
+	// at the limit, a session which may not preempt is rejected
+	plain := newSession("imsi-208930000000613",
+		arp(1, models.PreemptionCapability_NOT_PREEMPT, models.PreemptionVulnerability_NOT_PREEMPTABLE))
+	_, err = plain.AcquireSessionSlotByArp(context.SessionRuleArp(plain.SmPolicyData.SmCtxtSessionRules.ActiveRule))
+	require.ErrorIs(t, err, context.ErrSessionQueueFull)
+
+	// a session not yet active is not preempted
+	high := newSession("imsi-208930000000614",
+		arp(5, models.PreemptionCapability_MAY_PREEMPT, models.PreemptionVulnerability_NOT_PREEMPTABLE))
+	_, err = high.AcquireSessionSlotByArp(context.SessionRuleArp(high.SmPolicyData.SmCtxtSessionRules.ActiveRule))
+	require.ErrorIs(t, err, context.ErrSessionQueueFull)
+
+	// nor is an active one with a procedure in progress
+	vulnerable.SMContextState = context.SmStateActive
+	protected.SMContextState = context.SmStateActive
+	vulnerable.SMLock.Lock()
+	_, err = high.AcquireSessionSlotByArp(context.SessionRuleArp(high.SmPolicyData.SmCtxtSessionRules.ActiveRule))
+	vulnerable.SMLock.Unlock()
+	require.ErrorIs(t, err, context.ErrSessionQueueFull)
+
+	// the ARP of a session is the one it took its slot with
+	vulnerable.SmPolicyData.SmCtxtSessionRules.ActiveRule = &models.SessionRule{
+		AuthDefQos: &models.AuthorizedDefaultQos{
+			Arp: arp(1, models.PreemptionCapability_NOT_PREEMPT, models.PreemptionVulnerability_NOT_PREEMPTABLE),
+		},
+	}
+
+	// a session of a higher priority preempts the vulnerable session, not the protected one of a
+	// lower priority
+	preempted, err = high.AcquireSessionSlotByArp(context.SessionRuleArp(high.SmPolicyData.SmCtxtSessionRules.ActiveRule))
+	require.NoError(t, err)
+	require.Equal(t, vulnerable, preempted)
+	require.Equal(t, 2, limiter.Active())
+
+	// the release of the preempted session gives back no slot
+	context.RemoveSMContext(vulnerable.Ref)
+	require.Equal(t, 2, limiter.Active())
+
+	// no vulnerable session left of a lower priority
+	higher := newSession("imsi-208930000000615",
+		arp(1, models.PreemptionCapability_MAY_PREEMPT, models.PreemptionVulnerability_NOT_PREEMPTABLE))
+	_, err = higher.AcquireSessionSlotByArp(context.SessionRuleArp(higher.SmPolicyData.SmCtxtSessionRules.ActiveRule))
+	require.ErrorIs(t, err, context.ErrSessionQueueFull)
+
+	// the release of the preempting session does
+	context.RemoveSMContext(high.Ref)
+	require.Equal(t, 1, limiter.Active())
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/omec-project/openapi/models"
+)
+
+// SessionFilter constrains the sessions returned by ListSessions, the zero value matches all of them
+type SessionFilter struct {
+	Snssai *models.Snssai
+	State  *SMContextState
+	Dnn    string
+	// IP address of a UPF handling the session
+	UPF string
+}
+
+// SessionSummary describes a session returned by ListSessions
+type SessionSummary struct {
+	Snssai       *models.Snssai
+	SessionAmbr  *models.Ambr
+	Ref          string
+	Supi         string
+	Dnn          string
+	UeIP         string
+	AnchorUPF    string
+	PDUSessionID int32
+	State        SMContextState
+	// 5QI of the default QoS flow
+	Var5qi int32
+}
+
+// ListSessions returns the summaries of the sessions matching the filter, ordered by SUPI and
+// PDU session ID. Each session is read under its lock, so it may wait for an ongoing procedure
+// of a session to complete.
+func ListSessions(filter SessionFilter) []SessionSummary {
+	summaries := make([]SessionSummary, 0)
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		if filter.match(smContext) {
+			summaries = append(summaries, smContext.summary())
+		}
+		return true
+	})
+	slices.SortFunc(summaries, func(a, b SessionSummary) int {
+		return cmp.Or(cmp.Compare(a.Supi, b.Supi), cmp.Compare(a.PDUSessionID, b.PDUSessionID))
+	})
+	return summaries
+}
+
+func (filter *SessionFilter) match(smContext *SMContext) bool {
+	if filter.Dnn != "" && smContext.Dnn != filter.Dnn {
+		return false
+	}
+	if filter.Snssai != nil && (smContext.Snssai == nil ||
+		smContext.Snssai.Sst != filter.Snssai.Sst || smContext.Snssai.Sd != filter.Snssai.Sd) {
+		return false
+	}
+	if filter.State != nil && smContext.SMContextState != *filter.State {
+		return false
+	}
+	if filter.UPF != "" {
+		if _, ok := smContext.PFCPContext[filter.UPF]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (smContext *SMContext) summary() SessionSummary {
+	summary := SessionSummary{
+		Ref:          smContext.Ref,
+		Supi:         smContext.Supi,
+		Dnn:          smContext.Dnn,
+		PDUSessionID: smContext.PDUSessionID,
+		State:        smContext.SMContextState,
+	}
+	if smContext.Snssai != nil {
+		snssai := *smContext.Snssai
+		summary.Snssai = &snssai
+	}
+	if smContext.PDUAddress != nil && smContext.PDUAddress.Ip != nil {
+		summary.UeIP = smContext.PDUAddress.Ip.String()
+	}
+	if sessRule := smContext.SmPolicyData.SmCtxtSessionRules.ActiveRule; sessRule != nil {
+		if sessRule.AuthSessAmbr != nil {
+			ambr := *sessRule.AuthSessAmbr
+			summary.SessionAmbr = &ambr
+		}
+		if sessRule.AuthDefQos != nil {
+			summary.Var5qi = sessRule.AuthDefQos.Var5qi
+		}
+	}
+	if smContext.Tunnel != nil {
+		if dataPath := smContext.Tunnel.DataPathPool.GetDefaultPath(); dataPath != nil {
+			for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+				if node.IsAnchorUPF() && node.UPF != nil {
+					summary.AnchorUPF = node.UPF.NodeID.ResolveNodeIdToIp().String()
+				}
+			}
+		}
+	}
+	return summary
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func newListedSMContext(supi, dnn, upfIP string, ueIP string) *context.SMContext {
+	smContext := context.NewSMContext(supi, 1)
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	smContext.Supi = supi
+	smContext.Dnn = dnn
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.ParseIP(ueIP)}
+	smContext.SmPolicyData.SmCtxtSessionRules.ActiveRule = &models.SessionRule{
+		AuthSessAmbr: &models.Ambr{Uplink: "1 Gbps", Downlink: "2 Gbps"},
+		AuthDefQos:   &models.AuthorizedDefaultQos{Var5qi: 9},
+	}
+	smContext.PFCPContext[upfIP] = &context.PFCPSessionContext{NodeID: *context.NewNodeID(upfIP)}
+	smContext.Tunnel = context.NewUPTunnel()
+	smContext.Tunnel.AddDataPath(&context.DataPath{
+		IsDefaultPath: true,
+		FirstDPNode:   &context.DataPathNode{UPF: &context.UPF{NodeID: *context.NewNodeID(upfIP)}},
+	})
+	return smContext
+}
+
+func TestListSessionsByDNN(t *testing.T) {
+	internet1 := newListedSMContext("imsi-208930000000402", "list-internet", "10.0.5.1", "10.60.0.2")
+	internet2 := newListedSMContext("imsi-208930000000401", "list-internet", "10.0.5.2", "10.60.0.1")
+	newListedSMContext("imsi-208930000000403", "list-ims", "10.0.5.1", "10.61.0.1")
+
+	sessions := context.ListSessions(context.SessionFilter{Dnn: "list-internet"})
+	require.Len(t, sessions, 2)
+	require.Equal(t, context.SessionSummary{
+		Ref:          internet2.Ref,
+		Supi:         "imsi-208930000000401",
+		Dnn:          "list-internet",
+		Snssai:       &models.Snssai{Sst: 1, Sd: "010203"},
+		UeIP:         "10.60.0.1",
+		AnchorUPF:    "10.0.5.2",
+		PDUSessionID: 1,
+		State:        context.SmStateInit,
+		Var5qi:       9,
+		SessionAmbr:  &models.Ambr{Uplink: "1 Gbps", Downlink: "2 Gbps"},
+	}, sessions[0])
+	require.Equal(t, internet1.Ref, sessions[1].Ref)
+
+	// filters combined
+	sessions = context.ListSessions(context.SessionFilter{Dnn: "list-internet", UPF: "10.0.5.1"})
+	require.Len(t, sessions, 1)
+	require.Equal(t, internet1.Ref, sessions[0].Ref)
+
+	active := context.SmStateActive
+	require.Empty(t, context.ListSessions(context.SessionFilter{Dnn: "list-internet", State: &active}))
+	require.Empty(t, context.ListSessions(context.SessionFilter{
+		Dnn:    "list-internet",
+		Snssai: &models.Snssai{Sst: 1, Sd: "112233"},
+	}))
+	require.Empty(t, context.ListSessions(context.SessionFilter{Dnn: "list-unknown"}))
+}
+
+func TestListSessionsConcurrentChurn(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newListedSMContext(fmt.Sprintf("imsi-2089300000005%02d", i), "list-churn", "10.0.5.3", "10.62.0.1")
+		}()
+	}
+	for range 5 {
+		context.ListSessions(context.SessionFilter{Dnn: "list-churn"})
+	}
+	wg.Wait()
+	require.Len(t, context.ListSessions(context.SessionFilter{Dnn: "list-churn"}), 20)
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"maps"
+	"net"
+	"slices"
+	"sync"
+)
+
+// CauseNoResponse is reported to a session report fetch left unanswered by the UPF,
+// 0 being a reserved PFCP cause value
+const CauseNoResponse uint8 = 0
+
+// pending session report fetches, SM context ref and UPF IP -> cause of the response
+var recoveryProbes sync.Map
+
+func recoveryProbeKey(ref string, nodeID NodeID) string {
+	return ref + "/" + nodeID.ResolveNodeIdToIp().String()
+}
+
+// StartRecoveryProbe registers a session report fetch of the session to the UPF,
+// the returned channel receives the cause of its response
+func (smContext *SMContext) StartRecoveryProbe(nodeID NodeID) <-chan uint8 {
+	ch := make(chan uint8, 1)
+	recoveryProbes.Store(recoveryProbeKey(smContext.Ref, nodeID), ch)
+	return ch
+}
+
+// CompleteRecoveryProbe delivers the cause of the response to the pending session report fetch
+// of the session to the UPF, returns false when none is pending
+func (smContext *SMContext) CompleteRecoveryProbe(nodeID NodeID, cause uint8) bool {
+	value, ok := recoveryProbes.LoadAndDelete(recoveryProbeKey(smContext.Ref, nodeID))
+	if !ok {
+		return false
+	}
+	value.(chan uint8) <- cause
+	return true
+}
+
+// CancelRecoveryProbe drops the pending session report fetch of the session to the UPF
+func (smContext *SMContext) CancelRecoveryProbe(nodeID NodeID) {
+	recoveryProbes.Delete(recoveryProbeKey(smContext.Ref, nodeID))
+}
+
+// ResetPFCPSession forgets the PFCP session of the UPF lost by a UPF restart, so that it is
+// established again with all its rules. It returns the rules of the session on the UPF.
+func (smContext *SMContext) ResetPFCPSession(nodeID NodeID) ([]*PDR, []*FAR, []*QER) {
+	pfcpContext, ok := smContext.PFCPContext[nodeID.ResolveNodeIdToIp().String()]
+	if !ok {
+		return nil, nil, nil
+	}
+	pfcpContext.RemoteSEID = 0
+
+	var (
+		pdrList []*PDR
+		farList []*FAR
+		qerList []*QER
+	)
+	fars := make(map[*FAR]bool)
+	qers := make(map[*QER]bool)
+	for _, id := range slices.Sorted(maps.Keys(pfcpContext.PDRs)) {
+		pdr := pfcpContext.PDRs[id]
+		pdr.State = RULE_INITIAL
+		pdrList = append(pdrList, pdr)
+		pdrFARs := []*FAR{pdr.FAR}
+		if pdr.MAR != nil {
+			pdr.MAR.State = RULE_INITIAL
+			pdrFARs = append(pdrFARs, pdr.MAR.TGPPAccessFAR, pdr.MAR.NonTGPPAccessFAR)
+		}
+		for _, far := range pdrFARs {
+			if far != nil && !fars[far] {
+				far.State = RULE_INITIAL
+				if far.BAR != nil {
+					far.BAR.State = RULE_INITIAL
+				}
+				fars[far] = true
+				farList = append(farList, far)
+			}
+		}
+		for _, qer := range pdr.QER {
+			if qer != nil && !qers[qer] {
+				qer.State = RULE_INITIAL
+				qers[qer] = true
+				qerList = append(qerList, qer)
+			}
+		}
+	}
+	return pdrList, farList, qerList
+}
+
+// HasRemoteFTEID reports whether a rule of the PFCP session of the UPF forwards the packets to
+// the GTP-U tunnel of the peer, the F-TEID of an Error Indication Report
+func (smContext *SMContext) HasRemoteFTEID(nodeID NodeID, teid uint32, ip net.IP) bool {
+	pfcpContext, ok := smContext.PFCPContext[nodeID.ResolveNodeIdToIp().String()]
+	if !ok {
+		return false
+	}
+	for _, pdr := range pfcpContext.PDRs {
+		if pdr.FAR == nil || pdr.FAR.ForwardingParameters == nil || pdr.FAR.ForwardingParameters.OuterHeaderCreation == nil {
+			continue
+		}
+		outerHeader := pdr.FAR.ForwardingParameters.OuterHeaderCreation
+		if outerHeader.Teid == teid && (outerHeader.Ipv4Address.Equal(ip) || outerHeader.Ipv6Address.Equal(ip)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+// ReleaseSessionByNetwork, when set, releases the session as requested by the network with the
+// 5GSM cause: the UE and the AN are sent the PDU Session Release Command, the PCF the SM policy
+// association deletion and the UPFs the PFCP Session Deletion, then the AMF is notified of the
+// release of the SM context. The procedure names the release in the logs.
+var ReleaseSessionByNetwork func(smContext *SMContext, cause uint8, procedure string)
+
+// ReleaseSessions releases the sessions as requested by the network, each in its own goroutine as
+// the release waits for the UPFs. Without ReleaseSessionByNetwork the sessions are purged locally.
+func ReleaseSessions(smContexts []*SMContext, cause uint8, procedure string) {
+	for _, smContext := range smContexts {
+		if ReleaseSessionByNetwork != nil {
+			go ReleaseSessionByNetwork(smContext, cause, procedure)
+			continue
+		}
+		smContext.SMLock.Lock()
+		smContext.SubCtxLog.Warnf("%s, session purged", procedure)
+		smContext.LocalPurged = true
+		RemoveSMContext(smContext.Ref)
+		smContext.SMLock.Unlock()
+	}
+}
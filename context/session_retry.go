@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/smferrors"
+)
+
+// SessionError is a failure of a session establishment. A failure is retriable unless
+// NonRetriable is set, as for a subscriber unknown to the UDM. It unwraps to its error and to an
+// SMFError of code ErrCodeSessionSetupFailure, the SMFError of its error being found first.
+type SessionError struct {
+	Err          error
+	NonRetriable bool
+}
+
+func (e *SessionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SessionError) Unwrap() []error {
+	return []error{e.Err, smferrors.New(smferrors.ErrCodeSessionSetupFailure, e.Error())}
+}
+
+// IsRetriable returns true if the establishment failed with err may succeed when tried again
+func IsRetriable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sessionErr *SessionError
+	if errors.As(err, &sessionErr) {
+		return !sessionErr.NonRetriable
+	}
+	return true
+}
+
+// RetryPolicy returns the number of attempts of the establishment of the session and the delay
+// before the first retry, from the retry policy of the DNN
+func (smContext *SMContext) RetryPolicy() (int, time.Duration) {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.Retry == nil {
+		return 1, 0
+	}
+	retry := smContext.DNNInfo.Retry
+	return retry.MaxAttempts, time.Duration(retry.BackoffMs) * time.Millisecond
+}
+
+// ReselectDefaultDataPath releases the default data path of the session and selects a UPF
+// serving the DNN again, so that an establishment failed on a UPF is tried on another UPF. The
+// anchor UPFs of the released paths are not selected again for the session.
+func (smContext *SMContext) ReselectDefaultDataPath() error {
+	for id, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.IsDefaultPath {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if node.IsAnchorUPF() {
+				smContext.failedAnchors = append(smContext.failedAnchors, node.UPF.NodeID.ResolveNodeIdToIp().String())
+			}
+		}
+		dataPath.DeactivateTunnelAndPDR(smContext)
+		smContext.releasePendingPFCPContexts(dataPath)
+		delete(smContext.Tunnel.DataPathPool, id)
+	}
+
+	selection := smContext.UPFSelectionParams()
+	// the user plane is changed by the configuration updates under the lock
+	factory.SmfConfigSyncLock.Lock()
+	upPath := GetUserPlaneInformation().pathExcludingAnchors(selection, smContext.failedAnchors)
+	factory.SmfConfigSyncLock.Unlock()
+	if upPath == nil {
+		return &SessionError{
+			Err: fmt.Errorf("no data path for selection param %v other than on the failed UPFs %v",
+				selection.String(), smContext.failedAnchors),
+		}
+	}
+	defaultPath := GenerateDataPath(upPath, smContext)
+	defaultPath.IsDefaultPath = true
+	smContext.Tunnel.AddDataPath(defaultPath)
+	return defaultPath.ActivateTunnelAndPDR(smContext, 255)
+}
+
+// pathExcludingAnchors returns the path to the preferred UPF serving the selection among those
+// whose IP is not excluded, nil if none can be reached
+func (upi *UserPlaneInformation) pathExcludingAnchors(selection *UPFSelectionParams, excluded []string) UPPath {
+	if len(upi.AccessNetwork) == 0 {
+		return nil
+	}
+	for _, destination := range upi.selectMatchUPF(selection) {
+		if slices.Contains(excluded, destination.UPF.NodeID.ResolveNodeIdToIp().String()) {
+			continue
+		}
+		if path, exist := upi.pathToUPF(selection, destination); exist {
+			return path
+		}
+	}
+	return nil
+}
+
+// releasePendingPFCPContexts releases the PFCP session contexts of the data path not
+// established on their UPF
+func (smContext *SMContext) releasePendingPFCPContexts(dataPath *DataPath) {
+	for curDataPathNode := dataPath.FirstDPNode; curDataPathNode != nil; curDataPathNode = curDataPathNode.Next() {
+		nodeIP := curDataPathNode.UPF.NodeID.ResolveNodeIdToIp().String()
+		pfcpSessionContext, exist := smContext.PFCPContext[nodeIP]
+		if !exist || pfcpSessionContext.RemoteSEID != 0 {
+			continue
+		}
+		pfcpSessionContext.releaseActiveSession()
+		seidSMContextMap.Delete(pfcpSessionContext.LocalSEID)
+		if factory.SmfConfig.Configuration.EnableDbStore {
+			DeleteSmContextInDBBySEID(pfcpSessionContext.LocalSEID)
+		}
+		delete(smContext.PFCPContext, nodeIP)
+	}
+}
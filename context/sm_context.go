@@ -15,6 +15,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/omec-project/nas/nasConvert"
@@ -67,6 +68,7 @@ const (
 	SmStatePfcpRelease
 	SmStateRelease
 	SmStateN1N2TransferPending
+	SmStateTransferred
 	SmStateMax
 )
 
@@ -74,13 +76,11 @@ func init() {
 }
 
 func incSMContextActive() uint64 {
-	atomic.AddUint64(&smContextActive, 1)
-	return smContextActive
+	return atomic.AddUint64(&smContextActive, 1)
 }
 
 func decSMContextActive() uint64 {
-	atomic.AddUint64(&smContextActive, ^uint64(0))
-	return smContextActive
+	return atomic.AddUint64(&smContextActive, ^uint64(0))
 }
 
 func GetSMContextCount() uint64 {
@@ -91,20 +91,24 @@ func GetSMContextCount() uint64 {
 type UeIpAddr struct {
 	Ip          net.IP
 	UpfProvided bool
+	// the address provided by the UPF is quarantined in the UE IP pool of the SMF for the session
+	Quarantined bool
 }
 
 type SMContext struct {
 	Ref string `json:"ref" yaml:"ref" bson:"ref"`
 
 	// SUPI or PEI
-	Supi              string `json:"supi,omitempty" yaml:"supi" bson:"supi,omitempty"`
-	Pei               string `json:"pei,omitempty" yaml:"pei" bson:"pei,omitempty"`
-	Identifier        string `json:"identifier" yaml:"identifier" bson:"identifier"`
-	Gpsi              string `json:"gpsi,omitempty" yaml:"gpsi" bson:"gpsi,omitempty"`
-	Dnn               string `json:"dnn" yaml:"dnn" bson:"dnn"`
-	UeTimeZone        string `json:"ueTimeZone,omitempty" yaml:"ueTimeZone" bson:"ueTimeZone,omitempty"` // ignore
-	ServingNfId       string `json:"servingNfId,omitempty" yaml:"servingNfId" bson:"servingNfId,omitempty"`
-	SmStatusNotifyUri string `json:"smStatusNotifyUri,omitempty" yaml:"smStatusNotifyUri" bson:"smStatusNotifyUri,omitempty"`
+	Supi        string `json:"supi,omitempty" yaml:"supi" bson:"supi,omitempty"`
+	Pei         string `json:"pei,omitempty" yaml:"pei" bson:"pei,omitempty"`
+	Identifier  string `json:"identifier" yaml:"identifier" bson:"identifier"`
+	Gpsi        string `json:"gpsi,omitempty" yaml:"gpsi" bson:"gpsi,omitempty"`
+	Dnn         string `json:"dnn" yaml:"dnn" bson:"dnn"`
+	UeTimeZone  string `json:"ueTimeZone,omitempty" yaml:"ueTimeZone" bson:"ueTimeZone,omitempty"` // ignore
+	ServingNfId string `json:"servingNfId,omitempty" yaml:"servingNfId" bson:"servingNfId,omitempty"`
+	// GUAMI of the serving AMF, its AMF set serving the N1N2 message transfers of the session
+	Guami             *models.Guami `json:"guami,omitempty" yaml:"guami" bson:"guami,omitempty"`
+	SmStatusNotifyUri string        `json:"smStatusNotifyUri,omitempty" yaml:"smStatusNotifyUri" bson:"smStatusNotifyUri,omitempty"`
 
 	UpCnxState         models.UpCnxState       `json:"upCnxState,omitempty" yaml:"upCnxState" bson:"upCnxState,omitempty"`
 	AMFProfile         models.NfProfile        `json:"amfProfile,omitempty" yaml:"amfProfile" bson:"amfProfile,omitempty"`
@@ -123,10 +127,16 @@ type SMContext struct {
 
 	// PDUAddress             net.IP `json:"pduAddress,omitempty" yaml:"pduAddress" bson:"pduAddress,omitempty"`
 	PDUAddress *UeIpAddr `json:"pduAddress,omitempty" yaml:"pduAddress" bson:"pduAddress,omitempty"`
+	// creation of the SM context, the opening of its charging record
+	StartTime time.Time `json:"startTime" yaml:"startTime" bson:"startTime"`
 
 	// Client
 	SMPolicyClient      *Npcf_SMPolicyControl.APIClient `json:"smPolicyClient,omitempty" yaml:"smPolicyClient" bson:"smPolicyClient,omitempty"`                // ?
 	CommunicationClient *Namf_Communication.APIClient   `json:"communicationClient,omitempty" yaml:"communicationClient" bson:"communicationClient,omitempty"` // ?
+	// URI of the PCF discovered with the SRV lookup
+	pcfSrvURI string
+	// AMF instances discovered for the session, balancing the Namf_Communication requests
+	AMFClient *LoadBalancedSBIClient `json:"-" yaml:"-" bson:"-"`
 
 	// encountered a cycle via *context.GTPTunnel
 	Tunnel *UPTunnel `json:"-" yaml:"tunnel" bson:"-"`
@@ -134,6 +144,21 @@ type SMContext struct {
 	BPManager *BPManager `json:"bpManager,omitempty" yaml:"bpManager" bson:"bpManager,omitempty"` // ignore
 
 	DNNInfo *SnssaiSmfDnnInfo `json:"dnnInfo,omitempty" yaml:"dnnInfo" bson:"dnnInfo,omitempty"`
+	// slice of the SMF serving the session, of its DNN information, UE IP pool and UPFs: the
+	// requested S-NSSAI, or the slice selected by priority for a request without SD
+	SelectedSnssai *SNssai `json:"selectedSnssai,omitempty" yaml:"selectedSnssai" bson:"selectedSnssai,omitempty"`
+
+	// Trace activation, TS 32.422
+	TraceData *models.TraceData `json:"traceData,omitempty" yaml:"traceData" bson:"traceData,omitempty"`
+
+	// UP security, negotiated with the RAN through the Security Indication
+	UPIntegrityProtection models.UpIntegrity `json:"upIntegrityProtection,omitempty" yaml:"upIntegrityProtection" bson:"upIntegrityProtection,omitempty"`
+
+	// Multi-Access PDU session steering, nil for a single access session
+	ATSSSConfig *ATSSSConfig `json:"atsssConfig,omitempty" yaml:"atsssConfig" bson:"atsssConfig,omitempty"`
+
+	// ports of the session on the 5GS TSN bridge, nil if the DNN is not a TSN bridge
+	TSNBridge *TSNBridge `json:"tsnBridge,omitempty" yaml:"tsnBridge" bson:"tsnBridge,omitempty"`
 
 	// PCO Related
 	ProtocolConfigurationOptions *ProtocolConfigurationOptions `json:"protocolConfigurationOptions" yaml:"protocolConfigurationOptions" bson:"protocolConfigurationOptions"` // ignore
@@ -155,8 +180,40 @@ type SMContext struct {
 	SmPolicyData qos.SmCtxtPolicyData `json:"smPolicyData" yaml:"smPolicyData" bson:"smPolicyData"`
 	// unsupported structure - madatory!
 	SBIPFCPCommunicationChan chan PFCPSessionResponseStatus `json:"-" yaml:"sbiPFCPCommunicationChan" bson:"-"` // ignore
+	// rejection of the PFCP session establishment by the UPF, set before SessionEstablishFailed
+	PFCPError *PFCPError `json:"-" yaml:"-" bson:"-"`
 
 	PendingUPF PendingUPF `json:"pendingUPF,omitempty" yaml:"pendingUPF" bson:"pendingUPF,omitempty"` // ignore
+	// usage reported by the UPFs in PFCP Session Deletion Responses
+	finalUsage     []UsageReport
+	chargingClosed bool
+	finalUsageLock sync.Mutex
+	// secondary RAT usage reported by the NG-RAN, and the part not sent to charging yet
+	secondaryRATUsage          []SecondaryRATUsage
+	unchargedSecondaryRATUsage []SecondaryRATUsage
+	secondaryRATUsageLock      sync.Mutex
+	// usage of the session already sent in a charging update, guarded by SMLock
+	chargedUsage SessionStats
+	// last packet delay of each QoS flow reported by the anchor UPF, by QFI
+	qosMonitoringReports     map[uint8]QoSMonitoringReport
+	qosMonitoringReportsLock sync.Mutex
+	// time of the last Downlink Data Notification paging the UE, guarded by SMLock
+	lastDDNTime time.Time
+	// limiter whose session slot the session holds, nil if it holds none
+	sessionLimiter *SessionLimiter
+	// ARP of the session when it took its session slot, guarded by the lock of the limiter
+	slotArp *models.Arp
+	// creation of the session recorded in the session audit log and its release not yet
+	auditOpen atomic.Bool
+	// EAP responses of the UE during the authentication of the session, nil out of it, guarded
+	// by SMLock
+	eapResponses chan []byte
+	// IPs of the anchor UPFs of the default data paths the establishment of the session failed
+	// on, not selected again
+	failedAnchors []string
+	// EAP-Success or EAP-Failure ending the authentication of the session, sent to the UE in the
+	// PDU Session Establishment Accept or Reject
+	EAPResult []byte `json:"-" yaml:"-" bson:"-"`
 	// NodeID(string form) to PFCP Session Context
 	PFCPContext map[string]*PFCPSessionContext `json:"-" yaml:"pfcpContext" bson:"-"`
 	// TxnBus per subscriber
@@ -177,6 +234,14 @@ type SMContext struct {
 	// NAS
 	Pti                     uint8 `json:"pti,omitempty" yaml:"pti" bson:"pti,omitempty"` // ignore
 	EstAcceptCause5gSMValue uint8 `json:"estAcceptCause5gSMValue,omitempty" yaml:"estAcceptCause5gSMValue" bson:"estAcceptCause5gSMValue,omitempty"`
+	// 3GPP PS data off activated by the UE, the flows of the services not exempt being blocked
+	PsDataOff bool `json:"psDataOff,omitempty" yaml:"psDataOff" bson:"psDataOff,omitempty"`
+	// outcome of the last UE reachability probe, nil if the UE was never probed
+	UEReachability *UEReachability `json:"ueReachability,omitempty" yaml:"ueReachability" bson:"ueReachability,omitempty"`
+	// charging data of the session at the CHF, empty if its charging is not opened at a CHF
+	ChargingDataRef string `json:"chargingDataRef,omitempty" yaml:"chargingDataRef" bson:"chargingDataRef,omitempty"`
+	// invocation sequence number of the last Charging Data Request of the session
+	ChargingInvocationSeq uint32 `json:"chargingInvocationSeq,omitempty" yaml:"chargingInvocationSeq" bson:"chargingInvocationSeq,omitempty"`
 }
 
 func canonicalName(identifier string, pduSessID int32) (canonical string) {
@@ -199,10 +264,9 @@ func NewSMContext(identifier string, pduSessID int32) (smContext *SMContext) {
 	smContext = new(SMContext)
 	// Create Ref and identifier
 	smContext.Ref = uuid.New().URN()
-	smContextPool.Store(smContext.Ref, smContext)
-	canonicalRef.Store(canonicalName(identifier, pduSessID), smContext.Ref)
 
 	smContext.SMContextState = SmStateInit
+	smContext.StartTime = time.Now()
 	smContext.Identifier = identifier
 	smContext.PDUSessionID = pduSessID
 	smContext.PFCPContext = make(map[string]*PFCPSessionContext)
@@ -224,17 +288,25 @@ func NewSMContext(identifier string, pduSessID int32) (smContext *SMContext) {
 	// initialise log tags
 	smContext.initLogTags()
 
+	// published once initialized, the pool being walked concurrently
+	smContextPool.Store(smContext.Ref, smContext)
+	canonicalRef.Store(canonicalName(identifier, pduSessID), smContext.Ref)
+
 	return smContext
 }
 
 func (smContext *SMContext) initLogTags() {
-	smContext.SubPfcpLog = logger.PfcpLog.With("uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID)
-	smContext.SubCtxLog = logger.CtxLog.With("uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID)
-	smContext.SubPduSessLog = logger.PduSessLog.With("uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID)
-	smContext.SubGsmLog = logger.GsmLog.With("uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID)
-	smContext.SubConsumerLog = logger.ConsumerLog.With("uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID)
-	smContext.SubFsmLog = logger.FsmLog.With("uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID)
-	smContext.SubQosLog = logger.QosLog.With("uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID)
+	tags := []interface{}{
+		"uuid", smContext.Ref, "id", smContext.Identifier, "pduid", smContext.PDUSessionID,
+		"correlationId", smContext.CorrelationID(),
+	}
+	smContext.SubPfcpLog = logger.PfcpLog.With(tags...)
+	smContext.SubCtxLog = logger.CtxLog.With(tags...)
+	smContext.SubPduSessLog = logger.PduSessLog.With(tags...)
+	smContext.SubGsmLog = logger.GsmLog.With(tags...)
+	smContext.SubConsumerLog = logger.ConsumerLog.With(tags...)
+	smContext.SubFsmLog = logger.FsmLog.With(tags...)
+	smContext.SubQosLog = logger.QosLog.With(tags...)
 }
 
 func (smContext *SMContext) ChangeState(nextState SMContextState) {
@@ -294,6 +366,20 @@ func GetSMContext(ref string) (smContext *SMContext) {
 	return
 }
 
+// GetSMContextsByUPF returns the sessions with a PFCP session on the UPF
+func GetSMContextsByUPF(nodeID NodeID) []*SMContext {
+	upfIP := nodeID.ResolveNodeIdToIp().String()
+	smContexts := make([]*SMContext, 0)
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		if _, ok := smContext.PFCPContext[upfIP]; ok {
+			smContexts = append(smContexts, smContext)
+		}
+		return true
+	})
+	return smContexts
+}
+
 // *** add unit test ***//
 func RemoveSMContext(ref string) {
 	var smContext *SMContext
@@ -305,12 +391,18 @@ func RemoveSMContext(ref string) {
 	smContext.ChangeState(SmStateRelease)
 
 	for _, pfcpSessionContext := range smContext.PFCPContext {
+		pfcpSessionContext.releaseActiveSession()
 		seidSMContextMap.Delete(pfcpSessionContext.LocalSEID)
 		if factory.SmfConfig.Configuration.EnableDbStore {
 			DeleteSmContextInDBBySEID(pfcpSessionContext.LocalSEID)
 		}
 	}
 
+	smContext.ReleaseANTunnel()
+	smContext.releaseSessionSlot()
+	// released by the SMF unless already recorded on the release by the AMF
+	smContext.AuditSession(SessionAuditRelease, "")
+
 	// Release UE IP-Address
 	err := smContext.ReleaseUeIpAddr()
 	if err != nil {
@@ -318,6 +410,8 @@ func RemoveSMContext(ref string) {
 	}
 
 	smContextPool.Delete(ref)
+	metrics.DeleteQoSMonitoringPacketDelays(smContext.Supi, smContext.PDUSessionID)
+	GetPacketRateRecorder().Remove(ref)
 
 	canonicalRef.Delete(canonicalName(smContext.Supi, smContext.PDUSessionID))
 	// Sess Stats
@@ -341,14 +435,101 @@ func GetSMContextBySEID(SEID uint64) (smContext *SMContext) {
 }
 
 func (smContext *SMContext) ReleaseUeIpAddr() error {
-	if ip := smContext.PDUAddress.Ip; ip != nil && !smContext.PDUAddress.UpfProvided {
-		smContext.SubPduSessLog.Infof("Release IP[%s]", smContext.PDUAddress.Ip.String())
-		smContext.DNNInfo.UeIPAllocator.Release(smContext.Supi, ip)
-		smContext.PDUAddress.Ip = net.IPv4(0, 0, 0, 0)
+	ip := smContext.PDUAddress.Ip
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	if smContext.PDUAddress.UpfProvided {
+		// the quarantine of an address of the pool provided by the UPF ends with the session
+		if allocator := smContext.ueIPAllocator(); allocator != nil && smContext.PDUAddress.Quarantined {
+			allocator.Release(smContext.Supi, ip)
+			smContext.PDUAddress.Quarantined = false
+		}
+		smContext.AuditUeIP(UeIPReleased, ip, UeIPSourceUPF)
+		return nil
+	}
+	smContext.SubPduSessLog.Infof("Release IP[%s]", smContext.PDUAddress.Ip.String())
+	smContext.DNNInfo.UeIPAllocator.ReleaseWithGrace(smContext.Supi, ip, SMF_Self().IPReleaseGracePeriod)
+	smContext.PDUAddress.Ip = net.IPv4(0, 0, 0, 0)
+	smContext.AuditUeIP(UeIPReleased, ip, UeIPSourceSMF)
+	return nil
+}
+
+// SetUpfProvidedUeIp records the UE IP allocated by the UPF. A free address of the UE IP pool of
+// the SMF is quarantined for the session, for the SMF not to hand it out to another UE.
+func (smContext *SMContext) SetUpfProvidedUeIp(ip net.IP) {
+	smContext.PDUAddress.Ip = ip
+	smContext.PDUAddress.UpfProvided = true
+	smContext.PDUAddress.Quarantined = false
+	if allocator := smContext.ueIPAllocator(); allocator != nil && allocator.Contains(ip) {
+		if allocator.BlockIp(ip) {
+			smContext.PDUAddress.Quarantined = true
+			smContext.SubPduSessLog.Warnf("UE IP[%s] provided by the UPF is in the pool [%s], quarantined",
+				ip, allocator.Domain())
+		} else {
+			// left to the UE it is allocated to, the address is not freed with this session
+			smContext.SubPduSessLog.Errorf("UE IP[%s] provided by the UPF is already in use in the pool [%s]",
+				ip, allocator.Domain())
+		}
+	}
+	smContext.AuditUeIP(UeIPAllocated, ip, UeIPSourceUPF)
+}
+
+func (smContext *SMContext) ueIPAllocator() *IPAllocator {
+	if smContext.DNNInfo == nil {
+		return nil
+	}
+	return smContext.DNNInfo.UeIPAllocator
+}
+
+// SelectDNNInfo sets the DNN information of the session from the slice selected for its requested
+// S-NSSAI and DNN, returns false if no slice serves them
+func (smContext *SMContext) SelectDNNInfo() bool {
+	smContext.DNNInfo, smContext.SelectedSnssai = nil, nil
+	if smContext.Snssai == nil {
+		return false
+	}
+	snssaiInfo := SelectSnssaiInfo(*smContext.Snssai, smContext.Dnn)
+	if snssaiInfo == nil || snssaiInfo.DnnInfos[smContext.Dnn] == nil {
+		return false
+	}
+	smContext.DNNInfo = snssaiInfo.DnnInfos[smContext.Dnn]
+	smContext.SelectedSnssai = &SNssai{Sst: snssaiInfo.Snssai.Sst, Sd: snssaiInfo.Snssai.Sd}
+	return true
+}
+
+// ServingSnssai returns the slice of the SMF serving the session, its requested S-NSSAI if no
+// slice was selected, nil if it has none
+func (smContext *SMContext) ServingSnssai() *SNssai {
+	switch {
+	case smContext.SelectedSnssai != nil:
+		return &SNssai{Sst: smContext.SelectedSnssai.Sst, Sd: smContext.SelectedSnssai.Sd}
+	case smContext.Snssai != nil:
+		return &SNssai{Sst: smContext.Snssai.Sst, Sd: smContext.Snssai.Sd}
 	}
 	return nil
 }
 
+// UPFSelectionParams returns the selection of the anchor UPF of the session, among the UPFs of
+// its serving slice
+func (smContext *SMContext) UPFSelectionParams() *UPFSelectionParams {
+	return &UPFSelectionParams{
+		Dnn:    smContext.Dnn,
+		SNssai: smContext.ServingSnssai(),
+		Tai:    smContext.ServingTai(),
+	}
+}
+
+// dnnConfig returns the configuration of the DNN of the session in its serving slice, nil if
+// not configured
+func (smContext *SMContext) dnnConfig() *SnssaiSmfDnnInfo {
+	snssai := smContext.ServingSnssai()
+	if snssai == nil {
+		return nil
+	}
+	return RetrieveDnnInformation(models.Snssai{Sst: snssai.Sst, Sd: snssai.Sd}, smContext.Dnn)
+}
+
 // *** add unit test ***//
 func (smContext *SMContext) SetCreateData(createData *models.SmContextCreateData) {
 	smContext.Gpsi = createData.Gpsi
@@ -365,6 +546,8 @@ func (smContext *SMContext) SetCreateData(createData *models.SmContextCreateData
 	smContext.AddUeLocation = createData.AddUeLocation
 	smContext.OldPduSessionId = createData.OldPduSessionId
 	smContext.ServingNfId = createData.ServingNfId
+	smContext.Guami = createData.Guami
+	smContext.TraceData = createData.TraceData
 }
 
 func (smContext *SMContext) BuildCreatedData() (createdData *models.SmContextCreatedData) {
@@ -387,6 +570,10 @@ func (smContext *SMContext) PDUAddressToNAS() (addr [12]byte, addrLen uint8) {
 
 // PCFSelection will select PCF for this SM Context
 func (smContext *SMContext) PCFSelection() error {
+	if discovery := SMF_Self().PcfSrvDiscovery; discovery != nil {
+		return smContext.pcfSelectionBySRV(discovery)
+	}
+
 	// Send NFDiscovery for find PCF
 	localVarOptionals := Nnrf_NFDiscovery.SearchNFInstancesParamOpts{}
 
@@ -432,6 +619,7 @@ func (smContext *SMContext) PCFSelection() error {
 		if service.ServiceName == models.ServiceName_NPCF_SMPOLICYCONTROL {
 			SmPolicyControlConf := Npcf_SMPolicyControl.NewConfiguration()
 			SmPolicyControlConf.SetBasePath(service.ApiPrefix)
+			smContext.SetCorrelationHeader(SmPolicyControlConf)
 			smContext.SMPolicyClient = Npcf_SMPolicyControl.NewAPIClient(SmPolicyControlConf)
 		}
 	}
@@ -439,6 +627,38 @@ func (smContext *SMContext) PCFSelection() error {
 	return nil
 }
 
+func (smContext *SMContext) pcfSelectionBySRV(discovery *SRVDNSDiscovery) error {
+	uri, err := discovery.URI()
+	if err != nil {
+		return err
+	}
+	smContext.pcfSrvURI = uri
+	// no NF profile, nor then AM policy, for a PCF discovered without the NRF
+	smContext.SelectedPCFProfile = models.NfProfile{NfType: models.NfType_PCF}
+
+	SmPolicyControlConf := Npcf_SMPolicyControl.NewConfiguration()
+	SmPolicyControlConf.SetBasePath(uri)
+	smContext.SetCorrelationHeader(SmPolicyControlConf)
+	smContext.SMPolicyClient = Npcf_SMPolicyControl.NewAPIClient(SmPolicyControlConf)
+	return nil
+}
+
+// PCFSelectionFallback selects the next target of the SRV record after a connection failure to
+// the PCF selected, false if the PCF was not discovered with an SRV lookup or no target is left
+func (smContext *SMContext) PCFSelectionFallback() bool {
+	discovery := SMF_Self().PcfSrvDiscovery
+	if discovery == nil || smContext.pcfSrvURI == "" {
+		return false
+	}
+	discovery.MarkFailed(smContext.pcfSrvURI)
+	if err := smContext.pcfSelectionBySRV(discovery); err != nil {
+		smContext.SubPduSessLog.Errorf("PCF SRV fallback failed: %v", err)
+		return false
+	}
+	smContext.SubPduSessLog.Infof("PCF SRV fallback to %s", smContext.pcfSrvURI)
+	return true
+}
+
 func (smContext *SMContext) GetNodeIDByLocalSEID(seid uint64) (nodeID NodeID) {
 	for _, pfcpCtx := range smContext.PFCPContext {
 		if pfcpCtx.LocalSEID == seid {
@@ -463,7 +683,9 @@ func (smContext *SMContext) AllocateLocalSEIDForDataPath(dataPath *DataPath) {
 				PDRs:      make(map[uint16]*PDR),
 				NodeID:    curDataPathNode.UPF.NodeID,
 				LocalSEID: allocatedSEID,
+				upf:       curDataPathNode.UPF,
 			}
+			curDataPathNode.UPF.addActiveSession(1)
 
 			seidSMContextMap.Store(allocatedSEID, smContext)
 
@@ -518,7 +740,7 @@ func (smContext *SMContext) isAllowedPDUSessionType(requestedPDUSessionType uint
 		}
 	}
 
-	supportedPDUSessionType := SMF_Self().SupportedPDUSessionType
+	supportedPDUSessionType := smContext.SupportedPDUSessionType()
 	switch supportedPDUSessionType {
 	case "IPv4":
 		if !allowIPv4 {
@@ -579,6 +801,50 @@ func (smContext *SMContext) isAllowedPDUSessionType(requestedPDUSessionType uint
 	return nil
 }
 
+// SupportedPDUSessionType returns the PDU session type supported for the session, Ethernet on an
+// Ethernet DNN, else the one of the SMF
+func (smContext *SMContext) SupportedPDUSessionType() string {
+	if smContext.DNNInfo != nil && smContext.DNNInfo.Ethernet {
+		return "Ethernet"
+	}
+	return SMF_Self().SupportedPDUSessionType
+}
+
+// IsEthernetSession returns true for an Ethernet PDU session, which has no UE IP
+func (smContext *SMContext) IsEthernetSession() bool {
+	return smContext.SelectedPDUSessionType == nasMessage.PDUSessionTypeEthernet
+}
+
+// MatchPDUSessionTypeToUePool restricts the selected PDU session type to the IPv4 UE pool of the
+// DNN. An IPv4v6 session is downgraded to IPv4 with cause #50 in the accept, so is an IPv6 session
+// when the DNN falls back to IPv4, else the IPv6 session is not allowed. An Ethernet DNN, without
+// UE pool, only carries Ethernet sessions, and the other DNNs no Ethernet session.
+func (smContext *SMContext) MatchPDUSessionTypeToUePool() error {
+	dnnInfo := smContext.DNNInfo
+	if dnnInfo != nil && dnnInfo.Ethernet != smContext.IsEthernetSession() {
+		return fmt.Errorf("%w: PDU session type[%d] not carried by DNN[%s]",
+			ErrPDUSessionTypeNotAllowed, smContext.SelectedPDUSessionType, smContext.Dnn)
+	}
+	if dnnInfo == nil || dnnInfo.UeIPAllocator == nil || !dnnInfo.UeIPAllocator.IsIPv4() {
+		return nil
+	}
+
+	switch smContext.SelectedPDUSessionType {
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+	case nasMessage.PDUSessionTypeIPv6:
+		if !dnnInfo.FallbackToIPv4 {
+			return fmt.Errorf("PduSessionType_IPV6 is not supported by the IPv4 UE pool of DNN[%s]", smContext.Dnn)
+		}
+	default:
+		return nil
+	}
+	smContext.SubGsmLog.Infof("PDU session type[%d] downgraded to IPv4, DNN[%s] only has an IPv4 UE pool",
+		smContext.SelectedPDUSessionType, smContext.Dnn)
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	smContext.EstAcceptCause5gSMValue = nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed
+	return nil
+}
+
 // SM Policy related operation
 
 // SelectedSessionRule - return the SMF selected session rule for this SM Context
@@ -611,6 +877,8 @@ func (smContextState SMContextState) String() string {
 		return "SmStatePfcpRelease"
 	case SmStateN1N2TransferPending:
 		return "SmStateN1N2TransferPending"
+	case SmStateTransferred:
+		return "SmStateTransferred"
 
 	default:
 		return "Unknown State"
@@ -739,6 +1007,8 @@ func mapPduSessStateToMetricStateAndOp(state SMContextState) (string, mi.Subscri
 		return DISCONNECTED, mi.SubsOpDel
 	case SmStateN1N2TransferPending:
 		return IDLE, mi.SubsOpMod
+	case SmStateTransferred:
+		return DISCONNECTED, mi.SubsOpDel
 	default:
 		return "unknown", mi.SubsOpDel
 	}
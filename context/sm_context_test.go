@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/nas"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+)
+
+func newPDUSessionTypeSMContext(t *testing.T, fallbackToIPv4 bool) *context.SMContext {
+	t.Helper()
+	allocator, err := context.NewIPAllocator("10.61.0.0/24")
+	require.NoError(t, err)
+	return contexttest.NewSMContext("imsi-208930000000201", 1, &models.Snssai{Sst: 1, Sd: "010203"},
+		&context.SnssaiSmfDnnInfo{
+			UeIPAllocator:  allocator,
+			FallbackToIPv4: fallbackToIPv4,
+			DefaultQos:     contexttest.DefaultQos(),
+		})
+}
+
+func TestMatchPDUSessionTypeToUePool(t *testing.T) {
+	testCases := []struct {
+		name           string
+		requested      uint8
+		fallbackToIPv4 bool
+		expectErr      bool
+		expectedType   uint8
+		expectedCause  uint8
+	}{
+		{
+			name:          "IPv4 kept",
+			requested:     nasMessage.PDUSessionTypeIPv4,
+			expectedType:  nasMessage.PDUSessionTypeIPv4,
+			expectedCause: 0,
+		},
+		{
+			name:          "IPv4v6 downgraded",
+			requested:     nasMessage.PDUSessionTypeIPv4IPv6,
+			expectedType:  nasMessage.PDUSessionTypeIPv4,
+			expectedCause: nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
+		},
+		{
+			name:      "IPv6 rejected without fallback",
+			requested: nasMessage.PDUSessionTypeIPv6,
+			expectErr: true,
+		},
+		{
+			name:           "IPv6 downgraded with fallback",
+			requested:      nasMessage.PDUSessionTypeIPv6,
+			fallbackToIPv4: true,
+			expectedType:   nasMessage.PDUSessionTypeIPv4,
+			expectedCause:  nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := newPDUSessionTypeSMContext(t, tc.fallbackToIPv4)
+			smContext.SelectedPDUSessionType = tc.requested
+
+			err := smContext.MatchPDUSessionTypeToUePool()
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedType, smContext.SelectedPDUSessionType)
+			require.Equal(t, tc.expectedCause, smContext.EstAcceptCause5gSMValue)
+		})
+	}
+}
+
+func TestMatchPDUSessionTypeToEthernetDnn(t *testing.T) {
+	// Ethernet DNN, without UE pool
+	smContext := newPDUSessionTypeSMContext(t, false)
+	smContext.DNNInfo.UeIPAllocator = nil
+	smContext.DNNInfo.Ethernet = true
+
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeEthernet
+	require.NoError(t, smContext.MatchPDUSessionTypeToUePool())
+	require.Equal(t, uint8(nasMessage.PDUSessionTypeEthernet), smContext.SelectedPDUSessionType)
+	require.Equal(t, "Ethernet", smContext.SupportedPDUSessionType())
+
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	require.ErrorIs(t, smContext.MatchPDUSessionTypeToUePool(), context.ErrPDUSessionTypeNotAllowed)
+
+	// Ethernet session on an IP DNN
+	smContext = newPDUSessionTypeSMContext(t, false)
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeEthernet
+	require.ErrorIs(t, smContext.MatchPDUSessionTypeToUePool(), context.ErrPDUSessionTypeNotAllowed)
+}
+
+func TestBuildGSMPDUSessionEstablishmentAcceptDowngradedIPv6(t *testing.T) {
+	smContext := newPDUSessionTypeSMContext(t, true)
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv6
+	require.NoError(t, smContext.MatchPDUSessionTypeToUePool())
+
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.ParseIP("10.61.0.1").To4()}
+	smContext.SmPolicyUpdates = append(smContext.SmPolicyUpdates,
+		qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, smContext.DNNInfo.BuildDefaultSmPolicyDecision()))
+
+	b, err := context.BuildGSMPDUSessionEstablishmentAccept(smContext)
+	require.NoError(t, err)
+
+	m := nas.NewMessage()
+	require.NoError(t, m.PlainNasDecode(&b))
+	accept := m.PDUSessionEstablishmentAccept
+	require.NotNil(t, accept)
+	require.Equal(t, nasMessage.PDUSessionTypeIPv4, accept.GetPDUSessionType())
+	require.NotNil(t, accept.Cause5GSM)
+	require.Equal(t, nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed, accept.GetCauseValue())
+	require.NotNil(t, accept.PDUAddress)
+	require.Equal(t, nasMessage.PDUSessionTypeIPv4, accept.GetPDUSessionTypeValue())
+	require.Equal(t, uint8(5), accept.PDUAddress.GetLen())
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/qos"
+)
+
+// SMContextTransfer is the request of the old SMF of an inter-SMF handover transferring the
+// session context to the new SMF
+type SMContextTransfer struct {
+	SmContext *SMContext `json:"smContext"`
+}
+
+// IsSmContextTransferPeer returns true if the SMF of the IP address is allowed to transfer its
+// sessions to this SMF
+func (c *SMFContext) IsSmContextTransferPeer(ip string) bool {
+	return ip != "" && slices.Contains(c.SmContextTransferPeers, ip)
+}
+
+// MarkTransferred records that the session context was transferred to a new SMF, the session
+// being served there from now on
+func (smContext *SMContext) MarkTransferred() {
+	smContext.ChangeState(SmStateTransferred)
+}
+
+// AdoptTransferredSMContext takes over the session context transferred by the old SMF of an
+// inter-SMF handover, under a reference of its own. Only the subscriber, the PDU session, its AN
+// tunnel and its policy are kept from the old SMF: the DNN information is the one of the slice
+// selected for the S-NSSAI and DNN of the session, and the UE IP is reserved in the local pool of
+// the DNN. The PFCP sessions and data paths of the old SMF are dropped, the committed policy of the
+// session being staged again for its user plane to be set up by the caller, as are its SBI clients.
+func AdoptTransferredSMContext(smContext *SMContext) error {
+	if smContext.Identifier == "" || smContext.Supi == "" {
+		return fmt.Errorf("transferred SM context without SUPI")
+	}
+	if smContext.Snssai == nil {
+		return fmt.Errorf("transferred SM context without S-NSSAI")
+	}
+	if _, err := ResolveRef(smContext.Identifier, smContext.PDUSessionID); err == nil {
+		return fmt.Errorf("PDU session %d of %s already served", smContext.PDUSessionID, smContext.Identifier)
+	}
+	if !smContext.SelectDNNInfo() {
+		return fmt.Errorf("S-NSSAI[sst: %d, sd: %s] DNN[%s] not served", smContext.Snssai.Sst,
+			smContext.Snssai.Sd, smContext.Dnn)
+	}
+
+	smContext.restagePolicy()
+	if smContext.SmPolicyUpdates[0].SessRuleUpdate == nil {
+		return fmt.Errorf("transferred SM context without session rule")
+	}
+
+	smContext.Ref = uuid.New().URN()
+	smContext.initLogTags()
+	if err := smContext.reserveTransferredUeIP(); err != nil {
+		return err
+	}
+	smContext.SMPolicyClient = nil
+	smContext.CommunicationClient = nil
+	smContext.AMFClient = nil
+	smContext.PFCPContext = make(map[string]*PFCPSessionContext)
+	smContext.PendingUPF = nil
+	smContext.SBIPFCPCommunicationChan = make(chan PFCPSessionResponseStatus, 1)
+	tunnel := NewUPTunnel()
+	if smContext.Tunnel != nil {
+		tunnel.ANInformation = smContext.Tunnel.ANInformation
+	}
+	smContext.Tunnel = tunnel
+	if smContext.ProtocolConfigurationOptions == nil {
+		smContext.ProtocolConfigurationOptions = &ProtocolConfigurationOptions{}
+	}
+	smContext.SMContextState = SmStateInit
+
+	smContextActive := incSMContextActive()
+	metrics.SetSessStats(SMF_Self().NfInstanceID, smContextActive)
+
+	smContextPool.Store(smContext.Ref, smContext)
+	canonicalRef.Store(canonicalName(smContext.Identifier, smContext.PDUSessionID), smContext.Ref)
+	smContext.SubCtxLog.Infof("SM context transferred from the old SMF adopted")
+	return nil
+}
+
+// reserveTransferredUeIP reserves the UE IP of the transferred session in the pool of its DNN,
+// failing if the IP is not in the pool or already allocated
+func (smContext *SMContext) reserveTransferredUeIP() error {
+	if smContext.PDUAddress == nil || smContext.PDUAddress.UpfProvided {
+		return nil
+	}
+	ip := smContext.PDUAddress.Ip
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	allocator := smContext.DNNInfo.UeIPAllocator
+	if allocator == nil || !allocator.AllocateIP(ip) {
+		return fmt.Errorf("UE IP[%s] not free in the pool of DNN[%s]", ip, smContext.Dnn)
+	}
+	// decoded in its 16-byte form, the IP is released from the pool as allocated
+	smContext.PDUAddress.Ip = ip.To4()
+	smContext.AuditUeIP(UeIPAllocated, ip, UeIPSourceSMF)
+	return nil
+}
+
+// restagePolicy makes the policy committed by the old SMF the pending policy update of the session,
+// its rules being installed on the UPFs of this SMF. A policy update left pending by the old SMF,
+// not applied to its user plane, is dropped.
+func (smContext *SMContext) restagePolicy() {
+	policyData := smContext.SmPolicyData
+	smPolicyDecision := &models.SmPolicyDecision{
+		SessRules:     policyData.SmCtxtSessionRules.SessionRules,
+		PccRules:      policyData.SmCtxtPccRules.PccRules,
+		QosDecs:       policyData.SmCtxtQosData.QosData,
+		ChgDecs:       policyData.SmCtxtChargingData.ChargingData,
+		TraffContDecs: policyData.SmCtxtTCData.TrafficControlData,
+		Conds:         policyData.SmCtxtCondData.CondData,
+	}
+	smContext.SmPolicyData.Initialize()
+	smContext.SmPolicyUpdates = []*qos.PolicyUpdate{qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, smPolicyDecision)}
+}
@@ -16,6 +16,12 @@ func (s *SNssai) Equal(target *SNssai) bool {
 	return s.Sst == target.Sst && s.Sd == target.Sd
 }
 
+// Serves returns true if the slice serves the requested S-NSSAI, a request without SD being
+// served by every slice of its SST
+func (s *SNssai) Serves(requested *SNssai) bool {
+	return s.Sst == requested.Sst && (requested.Sd == "" || s.Sd == requested.Sd)
+}
+
 type SnssaiUPFInfo struct {
 	SNssai  SNssai
 	DnnList []DnnUPFInfoItem
@@ -24,6 +30,7 @@ type SnssaiUPFInfo struct {
 // DnnUpfInfoItem presents UPF dnn information
 type DnnUPFInfoItem struct {
 	Dnn             string
+	Role            UPFRole
 	DnaiList        []string
 	PduSessionTypes []models.PduSessionType
 }
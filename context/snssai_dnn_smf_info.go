@@ -6,26 +6,165 @@
 package context
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/smferrors"
 )
 
+// ErrPDUSessionTypeNotAllowed is returned when the PDU session type is not carried by the DNN
+var ErrPDUSessionTypeNotAllowed = smferrors.New(smferrors.ErrCodePDUSessionTypeNotAllowed, "PDU session type not allowed by the DNN")
+
 // SnssaiSmfInfo records the SMF S-NSSAI related information
 type SnssaiSmfInfo struct {
 	DnnInfos map[string]*SnssaiSmfDnnInfo
 	PlmnId   models.PlmnId
 	Snssai   SNssai
+	Priority int32
 }
 
 // SnssaiSmfDnnInfo records the SMF per S-NSSAI DNN information
 type SnssaiSmfDnnInfo struct {
-	UeIPAllocator *IPAllocator
+	UeIPAllocator *IPAllocator           // nil for an Ethernet DNN
+	DefaultQos    *factory.DnnDefaultQos // nil if no fallback QoS is configured
+	TSNConfig     *factory.TSNConfig     // nil if the DNN is not a TSN bridge
+	Retry         *factory.RetryConfig   // nil if failed establishments are not retried
 	DNS           DNS
 	MTU           uint16
+	// downgrade the IPv6 PDU sessions to IPv4 instead of rejecting them
+	FallbackToIPv4 bool
+	// the DNN carries Ethernet PDU sessions, without UE IP
+	Ethernet bool
+
+	// names of the UPFs tried in order to anchor the sessions, empty to select among all
+	// the UPFs serving the DNN
+	UPFSelectionChain []string
+
+	// HTTP headers inserted in the uplink traffic by the anchor UPF
+	HeaderEnrichment []factory.HeaderEnrichmentRule
+
+	// QCI of the EPS bearers mapped from the QoS flows, by 5QI, for the interworking with EPS
+	FiveQIToQCIMapping map[int32]int32
+
+	// handling of the sessions whose radio bearer setup failed, factory.SessionContinuity*
+	SessionContinuityMode string
+
+	// SRv6 segments traversed by the uplink traffic on N6, nil if the traffic is not steered
+	SRv6SegmentList []net.IP
+
+	// validated framed routes of the UEs, by SUPI
+	FramedRoutes map[string][]string
+
+	// policy of the establishments while none of the UPFs is associated, nil if the
+	// establishments are tried on the unassociated UPFs as on the others
+	UPFUnavailable *factory.UPFUnavailableConfig
+
+	// packet delay monitoring of the QoS flows by the anchor UPF, nil if not monitored
+	QoSMonitoring *QoSMonitoring
+
+	// paging policy indicators of the downlink data notifications, by DSCP
+	PagingPolicyIndicators map[uint8]uint8
+
+	// limiter of the concurrent sessions, nil if the sessions are not limited
+	SessionLimiter *SessionLimiter `json:"-" bson:"-"`
+
+	// maximum QoS flows of a session, 0 if only limited by the QFI range
+	MaxQoSFlows int
+
+	// application IDs of the PCC rules still forwarded while the UE activated PS data off
+	PsDataOffExemptApps []string
+
+	// steering of the MA PDU session traffic, nil if not steered
+	ATSSS *ATSSSConfig
 }
 
 type DNS struct {
 	IPv4Addr net.IP
 	IPv6Addr net.IP
 }
+
+// ParseDNS returns the DNS server addresses of the configuration, nil for the ones not
+// configured, or an error if an address is not of its IP version
+func ParseDNS(config *factory.DNS) (DNS, error) {
+	var dns DNS
+	if config.IPv4Addr != "" {
+		if dns.IPv4Addr = net.ParseIP(config.IPv4Addr).To4(); dns.IPv4Addr == nil {
+			return DNS{}, fmt.Errorf("DNS server %q is not an IPv4 address", config.IPv4Addr)
+		}
+	}
+	if config.IPv6Addr != "" {
+		if dns.IPv6Addr = net.ParseIP(config.IPv6Addr); dns.IPv6Addr == nil || dns.IPv6Addr.To4() != nil {
+			return DNS{}, fmt.Errorf("DNS server %q is not an IPv6 address", config.IPv6Addr)
+		}
+	}
+	return dns, nil
+}
+
+// BuildDefaultSmPolicyDecision builds a policy decision out of the DNN default QoS, to be used
+// in place of the PCF decision. It holds the default session rule, the default QoS flow and a
+// match-all PCC rule bound to it. Returns nil if no default QoS is configured for the DNN.
+func (dnnInfo *SnssaiSmfDnnInfo) BuildDefaultSmPolicyDecision() *models.SmPolicyDecision {
+	if dnnInfo == nil || dnnInfo.DefaultQos == nil {
+		return nil
+	}
+	defaultQos := dnnInfo.DefaultQos
+
+	arp := &models.Arp{
+		PriorityLevel: defaultQos.ArpPriorityLevel,
+		PreemptCap:    models.PreemptionCapability_NOT_PREEMPT,
+		PreemptVuln:   models.PreemptionVulnerability_PREEMPTABLE,
+	}
+
+	sessRule := &models.SessionRule{
+		SessRuleId: "DefaultSessRule",
+		AuthSessAmbr: &models.Ambr{
+			Uplink:   defaultQos.SessionAmbrUplink,
+			Downlink: defaultQos.SessionAmbrDownlink,
+		},
+		AuthDefQos: &models.AuthorizedDefaultQos{
+			Var5qi:        defaultQos.Var5qi,
+			Arp:           arp,
+			PriorityLevel: defaultQos.ArpPriorityLevel,
+		},
+	}
+
+	qosData := &models.QosData{
+		QosId:                "1",
+		Var5qi:               defaultQos.Var5qi,
+		MaxbrUl:              defaultQos.SessionAmbrUplink,
+		MaxbrDl:              defaultQos.SessionAmbrDownlink,
+		Arp:                  arp,
+		DefQosFlowIndication: true,
+	}
+
+	tcData := &models.TrafficControlData{
+		TcId:       "DefaultTcData",
+		FlowStatus: models.FlowStatus_ENABLED,
+	}
+
+	pccRule := &models.PccRule{
+		PccRuleId:  "255",
+		Precedence: 255,
+		RefQosData: []string{"DefaultQosData"},
+		RefTcData:  []string{"DefaultTcData"},
+		FlowInfos: []models.FlowInformation{
+			{
+				FlowDescription:   "permit out ip from any to assigned",
+				PackFiltId:        "1",
+				PacketFilterUsage: true,
+				FlowDirection:     models.FlowDirectionRm_BIDIRECTIONAL,
+			},
+		},
+	}
+
+	return &models.SmPolicyDecision{
+		SessRules: map[string]*models.SessionRule{"DefaultSessRule": sessRule},
+		PccRules:  map[string]*models.PccRule{"DefaultPccRule": pccRule},
+		QosDecs:   map[string]*models.QosData{"DefaultQosData": qosData},
+		TraffContDecs: map[string]*models.TrafficControlData{
+			"DefaultTcData": tcData,
+		},
+	}
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDNS(t *testing.T) {
+	dns, err := context.ParseDNS(&factory.DNS{IPv4Addr: "8.8.8.8", IPv6Addr: "2001:4860:4860::8888"})
+	require.NoError(t, err)
+	require.Equal(t, net.IPv4(8, 8, 8, 8).To4(), dns.IPv4Addr)
+	require.Equal(t, net.ParseIP("2001:4860:4860::8888"), dns.IPv6Addr)
+
+	// DNS servers not configured, not provided to the UEs
+	dns, err = context.ParseDNS(&factory.DNS{})
+	require.NoError(t, err)
+	require.Nil(t, dns.IPv4Addr)
+	require.Nil(t, dns.IPv6Addr)
+
+	for _, invalid := range []factory.DNS{
+		{IPv4Addr: "8.8.8"},
+		{IPv4Addr: "dns.example.com"},
+		{IPv4Addr: "8.8.8.8 "},
+		{IPv4Addr: "2001:4860:4860::8888"},
+		{IPv6Addr: "2001:4860:4860::88888"},
+		{IPv6Addr: "8.8.8.8"},
+	} {
+		_, err := context.ParseDNS(&invalid)
+		require.Error(t, err, "%+v", invalid)
+	}
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"net"
+)
+
+// IsUpfSupportSRv6Steering steering of the N6 traffic with SRv6 segment lists by UPF supported
+func (upf *UPF) IsUpfSupportSRv6Steering() bool {
+	return upf.SRv6Steering
+}
+
+// ParseSRv6SegmentList parses the segment IDs of a steering policy, which are IPv6 addresses
+func ParseSRv6SegmentList(segmentList []string) ([]net.IP, error) {
+	if len(segmentList) == 0 {
+		return nil, fmt.Errorf("empty segment list")
+	}
+	segments := make([]net.IP, 0, len(segmentList))
+	for _, segment := range segmentList {
+		ip := net.ParseIP(segment)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("segment ID %s is not an IPv6 address", segment)
+		}
+		segments = append(segments, ip)
+	}
+	return segments, nil
+}
+
+// SRv6SegmentList returns the SRv6 segments the uplink traffic of the session traverses on N6,
+// from the steering policy of its DNN
+func (smContext *SMContext) SRv6SegmentList() []net.IP {
+	dnnInfo := smContext.dnnConfig()
+	if dnnInfo == nil {
+		return nil
+	}
+	return dnnInfo.SRv6SegmentList
+}
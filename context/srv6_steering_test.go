@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSRv6SegmentList(t *testing.T) {
+	segments, err := context.ParseSRv6SegmentList([]string{"fc00:1::1", "fc00:2::1"})
+	require.NoError(t, err)
+	require.Equal(t, []net.IP{net.ParseIP("fc00:1::1"), net.ParseIP("fc00:2::1")}, segments)
+
+	_, err = context.ParseSRv6SegmentList(nil)
+	require.Error(t, err)
+	_, err = context.ParseSRv6SegmentList([]string{"fc00:1::1", "10.0.0.1"})
+	require.Error(t, err, "IPv4 segment ID")
+	_, err = context.ParseSRv6SegmentList([]string{"firewall"})
+	require.Error(t, err)
+}
+
+func TestActivateUpLinkPdrSRv6Steering(t *testing.T) {
+	segments := []net.IP{net.ParseIP("fc00:1::1"), net.ParseIP("fc00:2::1")}
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai: context.SNssai{Sst: 1, Sd: "010203"},
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{
+				"internet": {SRv6SegmentList: segments},
+			},
+		},
+	})
+
+	activate := func(srv6Steering bool) *context.ForwardingParameters {
+		smContext := &context.SMContext{
+			Supi:       "imsi-208930000000001",
+			Dnn:        "internet",
+			Snssai:     &models.Snssai{Sst: 1, Sd: "010203"},
+			PDUAddress: &context.UeIpAddr{Ip: net.IPv4(10, 60, 0, 1)},
+		}
+		dpNode := &context.DataPathNode{
+			UPF: &context.UPF{SRv6Steering: srv6Steering},
+			UpLinkTunnel: &context.GTPTunnel{
+				PDR: map[string]*context.PDR{
+					"default": {FAR: &context.FAR{}},
+				},
+			},
+		}
+		require.NoError(t, dpNode.ActivateUpLinkPdr(smContext, &context.QER{}, 255))
+		return dpNode.UpLinkTunnel.PDR["default"].FAR.ForwardingParameters
+	}
+
+	require.Equal(t, segments, activate(true).SRv6SegmentList)
+
+	// the UPF is not configured for SRv6
+	require.Empty(t, activate(false).SRv6SegmentList)
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SRVLookupTimeout is the time the DNS server is given to answer an SRV query
+var SRVLookupTimeout = 2 * time.Second
+
+const resolvConf = "/etc/resolv.conf"
+
+// SRVTarget is a target of an SRV record
+type SRVTarget struct {
+	Host     string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// SRVDNSDiscovery discovers the instances of an NF with a DNS SRV lookup. The targets are
+// ordered by ascending priority then descending weight and cached for the lowest TTL of the
+// records. A target failing to connect is skipped until the records are looked up again.
+type SRVDNSDiscovery struct {
+	expiry  time.Time
+	failed  map[string]bool
+	name    string
+	scheme  string
+	server  string
+	targets []SRVTarget
+	lock    sync.Mutex
+}
+
+// NewSRVDNSDiscovery returns the discovery of the targets of the SRV record of the config
+func NewSRVDNSDiscovery(config *factory.SrvDiscoveryConfig) (*SRVDNSDiscovery, error) {
+	if config == nil || config.Name == "" {
+		return nil, fmt.Errorf("SRV record name missing")
+	}
+	name, err := dnsmessage.NewName(fqdn(config.Name))
+	if err != nil {
+		return nil, fmt.Errorf("SRV record name %s: %v", config.Name, err)
+	}
+	discovery := &SRVDNSDiscovery{
+		name:   name.String(),
+		scheme: "http",
+		server: config.Server,
+	}
+	if config.Scheme != "" {
+		discovery.scheme = config.Scheme
+	}
+	if discovery.server == "" {
+		nameserver, err := resolvConfNameserver(resolvConf)
+		if err != nil {
+			return nil, err
+		}
+		discovery.server = net.JoinHostPort(nameserver, "53")
+	}
+	return discovery, nil
+}
+
+// URI returns the URI of the target with the highest priority not failed since the lookup,
+// the SRV record is looked up again once its TTL expired
+func (d *SRVDNSDiscovery) URI() (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if time.Now().After(d.expiry) {
+		targets, ttl, err := lookupSRV(d.server, d.name)
+		if err != nil {
+			return "", err
+		}
+		d.targets = targets
+		d.expiry = time.Now().Add(time.Duration(ttl) * time.Second)
+		d.failed = make(map[string]bool)
+		logger.CtxLog.Infof("SRV record %s: %d targets cached for %ds", d.name, len(targets), ttl)
+	}
+	for _, target := range d.targets {
+		if uri := d.targetURI(target); !d.failed[uri] {
+			return uri, nil
+		}
+	}
+	return "", fmt.Errorf("no target of SRV record %s available", d.name)
+}
+
+// MarkFailed skips the target of the URI until the SRV record is looked up again
+func (d *SRVDNSDiscovery) MarkFailed(uri string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.failed != nil {
+		d.failed[uri] = true
+		logger.CtxLog.Warnf("SRV target %s of %s failed", uri, d.name)
+	}
+}
+
+func (d *SRVDNSDiscovery) targetURI(target SRVTarget) string {
+	return fmt.Sprintf("%s://%s", d.scheme, net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+}
+
+// lookupSRV queries the SRV records of the name over UDP, net.LookupSRV does not return their TTL
+func lookupSRV(server, name string) ([]SRVTarget, uint32, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Uint32())
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	if err = builder.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err = builder.Question(dnsmessage.Question{
+		Name:  qname,
+		Type:  dnsmessage.TypeSRV,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, 0, err
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(SRVLookupTimeout)); err != nil {
+		return nil, 0, err
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, 0, err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("SRV lookup of %s: %v", name, err)
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			// not the answer to the query
+			continue
+		}
+		if header.RCode != dnsmessage.RCodeSuccess {
+			return nil, 0, fmt.Errorf("SRV lookup of %s: %s", name, header.RCode)
+		}
+		if header.Truncated {
+			return nil, 0, fmt.Errorf("SRV lookup of %s: answer truncated", name)
+		}
+		return parseSRVAnswers(&parser, name)
+	}
+}
+
+func parseSRVAnswers(parser *dnsmessage.Parser, name string) ([]SRVTarget, uint32, error) {
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+	var targets []SRVTarget
+	var ttl uint32
+	for {
+		header, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if header.Type != dnsmessage.TypeSRV {
+			if err = parser.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		srv, err := parser.SRVResource()
+		if err != nil {
+			return nil, 0, err
+		}
+		targets = append(targets, SRVTarget{
+			Host:     strings.TrimSuffix(srv.Target.String(), "."),
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+		})
+		if len(targets) == 1 || header.TTL < ttl {
+			ttl = header.TTL
+		}
+	}
+	if len(targets) == 0 {
+		return nil, 0, fmt.Errorf("no SRV record for %s", name)
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		if targets[i].Priority != targets[j].Priority {
+			return targets[i].Priority < targets[j].Priority
+		}
+		return targets[i].Weight > targets[j].Weight
+	})
+	return targets, ttl, nil
+}
+
+func resolvConfNameserver(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 1 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver in %s", path)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type srvRecord struct {
+	target   string
+	port     uint16
+	priority uint16
+	weight   uint16
+	ttl      uint32
+}
+
+// mockDNSServer answers the SRV queries with its records
+type mockDNSServer struct {
+	records []srvRecord
+	queries int
+	lock    sync.Mutex
+}
+
+func (s *mockDNSServer) setRecords(records []srvRecord) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records = records
+}
+
+func (s *mockDNSServer) queryCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.queries
+}
+
+func newMockDNSServer(t *testing.T, records []srvRecord) (*mockDNSServer, string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	server := &mockDNSServer{records: records}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+
+			server.lock.Lock()
+			server.queries++
+			builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true})
+			_ = builder.StartQuestions()
+			_ = builder.Question(question)
+			_ = builder.StartAnswers()
+			for _, record := range server.records {
+				_ = builder.SRVResource(dnsmessage.ResourceHeader{
+					Name:  question.Name,
+					Class: dnsmessage.ClassINET,
+					TTL:   record.ttl,
+				}, dnsmessage.SRVResource{
+					Priority: record.priority,
+					Weight:   record.weight,
+					Port:     record.port,
+					Target:   dnsmessage.MustNewName(record.target),
+				})
+			}
+			server.lock.Unlock()
+			if rsp, err := builder.Finish(); err == nil {
+				_, _ = conn.WriteTo(rsp, addr)
+			}
+		}
+	}()
+	return server, conn.LocalAddr().String()
+}
+
+func TestSRVDNSDiscoveryPriority(t *testing.T) {
+	_, addr := newMockDNSServer(t, []srvRecord{
+		{target: "pcf-c.example.org.", port: 29507, priority: 20, weight: 100, ttl: 60},
+		{target: "pcf-b.example.org.", port: 29507, priority: 10, weight: 10, ttl: 60},
+		{target: "pcf-a.example.org.", port: 29508, priority: 10, weight: 50, ttl: 60},
+	})
+	discovery, err := context.NewSRVDNSDiscovery(&factory.SrvDiscoveryConfig{
+		Name:   "_npcf-smpolicycontrol._tcp.pcf.5gc.mnc001.mcc001.3gppnetwork.org",
+		Server: addr,
+	})
+	require.NoError(t, err)
+
+	// highest priority first, then highest weight
+	uri, err := discovery.URI()
+	require.NoError(t, err)
+	require.Equal(t, "http://pcf-a.example.org:29508", uri)
+
+	// the next target is used after a connection failure
+	discovery.MarkFailed(uri)
+	uri, err = discovery.URI()
+	require.NoError(t, err)
+	require.Equal(t, "http://pcf-b.example.org:29507", uri)
+	discovery.MarkFailed(uri)
+	uri, err = discovery.URI()
+	require.NoError(t, err)
+	require.Equal(t, "http://pcf-c.example.org:29507", uri)
+
+	discovery.MarkFailed(uri)
+	_, err = discovery.URI()
+	require.Error(t, err)
+}
+
+func TestSRVDNSDiscoveryTTL(t *testing.T) {
+	server, addr := newMockDNSServer(t, []srvRecord{
+		{target: "pcf-a.example.org.", port: 29507, priority: 10, weight: 10, ttl: 1},
+		{target: "pcf-b.example.org.", port: 29507, priority: 20, weight: 10, ttl: 60},
+	})
+	discovery, err := context.NewSRVDNSDiscovery(&factory.SrvDiscoveryConfig{
+		Name:   "_npcf-smpolicycontrol._tcp.pcf.example.org",
+		Scheme: "https",
+		Server: addr,
+	})
+	require.NoError(t, err)
+
+	uri, err := discovery.URI()
+	require.NoError(t, err)
+	require.Equal(t, "https://pcf-a.example.org:29507", uri)
+	discovery.MarkFailed(uri)
+
+	// cached until the lowest TTL of the records expires
+	server.setRecords([]srvRecord{
+		{target: "pcf-d.example.org.", port: 29507, priority: 5, weight: 10, ttl: 60},
+	})
+	uri, err = discovery.URI()
+	require.NoError(t, err)
+	require.Equal(t, "https://pcf-b.example.org:29507", uri)
+	require.Equal(t, 1, server.queryCount())
+
+	// looked up again, the failed targets are retried
+	time.Sleep(1100 * time.Millisecond)
+	uri, err = discovery.URI()
+	require.NoError(t, err)
+	require.Equal(t, "https://pcf-d.example.org:29507", uri)
+	require.Equal(t, 2, server.queryCount())
+}
+
+func TestSRVDNSDiscoveryNoRecord(t *testing.T) {
+	_, addr := newMockDNSServer(t, nil)
+	discovery, err := context.NewSRVDNSDiscovery(&factory.SrvDiscoveryConfig{
+		Name:   "_npcf-smpolicycontrol._tcp.pcf.example.org",
+		Server: addr,
+	})
+	require.NoError(t, err)
+	_, err = discovery.URI()
+	require.Error(t, err)
+
+	_, err = context.NewSRVDNSDiscovery(&factory.SrvDiscoveryConfig{})
+	require.Error(t, err)
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/smferrors"
+	"github.com/omec-project/util/mongoapi"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var ErrTEIDPoolExhausted = smferrors.New(smferrors.ErrCodeTEIDPoolExhausted, "TEID pool exhausted")
+
+// TEIDPool tracks the GTP-U TEIDs in use towards each gNB, so that a TEID is never held by two
+// sessions of the same gNB at the same time. The gNBs are expected to choose their TEIDs in
+// [min, max], the TEIDs outside it are tracked and logged.
+type TEIDPool struct {
+	// gNB IP -> TEID -> SM context ref
+	allocated map[string]map[uint32]string
+	minTEID   uint32
+	maxTEID   uint32
+	lock      sync.Mutex
+}
+
+func NewTEIDPool(minTEID, maxTEID uint32) *TEIDPool {
+	if minTEID == 0 {
+		// TEID 0 is reserved for the GTP-U echo and error indication
+		minTEID = 1
+	}
+	if maxTEID < minTEID {
+		logger.CtxLog.Warnf("invalid TEID range [%d-%d], using [1-%d]", minTEID, maxTEID, uint32(math.MaxUint32))
+		minTEID, maxTEID = 1, math.MaxUint32
+	}
+	return &TEIDPool{
+		allocated: make(map[string]map[uint32]string),
+		minTEID:   minTEID,
+		maxTEID:   maxTEID,
+	}
+}
+
+// Reserve records a TEID chosen by the gNB for the session. The gNB choosing its TEIDs, a TEID
+// already held by another session of the gNB is re-keyed to the session, the previous owner,
+// returned, being stale. It fails for TEID 0, reserved, a TEID outside the range of the pool is
+// tracked all the same.
+func (p *TEIDPool) Reserve(gnbIP string, teid uint32, ref string) (string, error) {
+	if teid == 0 {
+		return "", fmt.Errorf("TEID[0] reserved")
+	}
+	if teid < p.minTEID || teid > p.maxTEID {
+		logger.CtxLog.Warnf("TEID[%d] of gNB[%s] outside range [%d-%d]", teid, gnbIP, p.minTEID, p.maxTEID)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	inUse := p.allocated[gnbIP]
+	if inUse == nil {
+		inUse = make(map[uint32]string)
+		p.allocated[gnbIP] = inUse
+	}
+	stale := ""
+	if owner, used := inUse[teid]; used && owner != ref {
+		stale = owner
+	}
+	inUse[teid] = ref
+	return stale, nil
+}
+
+// Release frees the TEID if it is still held by the session
+func (p *TEIDPool) Release(gnbIP string, teid uint32, ref string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	inUse := p.allocated[gnbIP]
+	if owner, used := inUse[teid]; !used || owner != ref {
+		return
+	}
+	delete(inUse, teid)
+	if len(inUse) == 0 {
+		delete(p.allocated, gnbIP)
+	}
+}
+
+// InUse returns the number of TEIDs held towards the gNB
+func (p *TEIDPool) InUse(gnbIP string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.allocated[gnbIP])
+}
+
+// LoadFromDB reserves the gNB TEIDs of the sessions kept in the session store,
+// so that they are not handed out again after a restart
+func (p *TEIDPool) LoadFromDB() {
+	results, err := mongoapi.CommonDBClient.RestfulAPIGetMany(SmContextDataColl, bson.M{})
+	if err != nil {
+		logger.DataRepoLog.Errorf("load TEIDs from DB failed: %v", err)
+		return
+	}
+
+	loaded := 0
+	for _, result := range results {
+		var stored struct {
+			Ref    string `json:"ref"`
+			Tunnel struct {
+				ANInformation struct {
+					IPAddress net.IP
+					TEID      uint32
+				}
+			} `json:"tunnel"`
+		}
+		if err = json.Unmarshal(mapToByte(result), &stored); err != nil {
+			logger.DataRepoLog.Warnf("decode stored session failed: %v", err)
+			continue
+		}
+		anInformation := stored.Tunnel.ANInformation
+		if anInformation.IPAddress == nil || anInformation.TEID == 0 {
+			continue
+		}
+		stale, err := p.Reserve(anInformation.IPAddress.String(), anInformation.TEID, stored.Ref)
+		if err != nil {
+			logger.DataRepoLog.Warnf("reload TEID of session[%s] failed: %v", stored.Ref, err)
+			continue
+		}
+		if stale != "" {
+			logger.DataRepoLog.Warnf("TEID[%d] of gNB[%s] stored for sessions[%s] and [%s]", anInformation.TEID,
+				anInformation.IPAddress, stale, stored.Ref)
+		}
+		loaded++
+	}
+	logger.DataRepoLog.Infof("reloaded %d gNB TEIDs from DB", loaded)
+}
+
+// trackANTunnel records the gNB tunnel endpoint of the session in the TEID pool, releasing the
+// previous one. The session which held the TEID before, no longer reachable by the gNB on it, is
+// released for the UE to establish it again.
+func (smContext *SMContext) trackANTunnel(anIP net.IP, teid uint32) {
+	upi := GetUserPlaneInformation()
+	if upi == nil || upi.TEIDPool == nil {
+		return
+	}
+	smContext.ReleaseANTunnel()
+	staleRef, err := upi.TEIDPool.Reserve(anIP.String(), teid, smContext.Ref)
+	if err != nil {
+		smContext.SubCtxLog.Warnf("gNB tunnel tracking: %v", err)
+		return
+	}
+	if stale := GetSMContext(staleRef); stale != nil {
+		smContext.SubCtxLog.Warnf("TEID[%d] of gNB[%s] reused, session[%s] holding it released", teid, anIP, staleRef)
+		ReleaseSessions([]*SMContext{stale}, nasMessage.Cause5GSMReactivationRequested,
+			fmt.Sprintf("TEID[%d] of gNB[%s] reused", teid, anIP))
+	}
+}
+
+// ReleaseANTunnel frees the gNB TEID of the session in the TEID pool, to be called whenever the
+// AN tunnel of the session is torn down or its user plane deactivated, the gNB being free to give
+// the TEID to another session
+func (smContext *SMContext) ReleaseANTunnel() {
+	upi := GetUserPlaneInformation()
+	if upi == nil || upi.TEIDPool == nil || smContext.Tunnel == nil {
+		return
+	}
+	anInformation := smContext.Tunnel.ANInformation
+	if anInformation.IPAddress == nil {
+		return
+	}
+	upi.TEIDPool.Release(anInformation.IPAddress.String(), anInformation.TEID, smContext.Ref)
+}
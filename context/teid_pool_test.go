@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/omec-project/aper"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTEIDPoolReserve(t *testing.T) {
+	pool := context.NewTEIDPool(1, 10)
+	gnbIP := "192.168.179.100"
+
+	stale, err := pool.Reserve(gnbIP, 5, "ref-1")
+	require.NoError(t, err)
+	require.Empty(t, stale)
+	stale, err = pool.Reserve(gnbIP, 5, "ref-1")
+	require.NoError(t, err)
+	require.Empty(t, stale)
+	_, err = pool.Reserve(gnbIP, 0, "ref-2")
+	require.Error(t, err)
+	// a TEID outside the range is tracked all the same
+	stale, err = pool.Reserve(gnbIP, 11, "ref-2")
+	require.NoError(t, err)
+	require.Empty(t, stale)
+	require.Equal(t, 2, pool.InUse(gnbIP))
+	stale, err = pool.Reserve(gnbIP, 11, "ref-4")
+	require.NoError(t, err)
+	require.Equal(t, "ref-2", stale)
+	pool.Release(gnbIP, 11, "ref-4")
+
+	// the range is tracked per gNB
+	stale, err = pool.Reserve("192.168.179.101", 5, "ref-2")
+	require.NoError(t, err)
+	require.Empty(t, stale)
+
+	// a TEID reused by the gNB is re-keyed to the new session
+	stale, err = pool.Reserve(gnbIP, 5, "ref-3")
+	require.NoError(t, err)
+	require.Equal(t, "ref-1", stale)
+	require.Equal(t, 1, pool.InUse(gnbIP))
+
+	// only the owner releases a TEID
+	pool.Release(gnbIP, 5, "ref-1")
+	require.Equal(t, 1, pool.InUse(gnbIP))
+	pool.Release(gnbIP, 5, "ref-3")
+	require.Zero(t, pool.InUse(gnbIP))
+}
+
+func buildPathSwitchRequestTransfer(t *testing.T, anIP net.IP, teid uint32) []byte {
+	t.Helper()
+	transfer := ngapType.PathSwitchRequestTransfer{}
+	transfer.DLNGUUPTNLInformation.Present = ngapType.UPTransportLayerInformationPresentGTPTunnel
+	transfer.DLNGUUPTNLInformation.GTPTunnel = new(ngapType.GTPTunnel)
+	transfer.DLNGUUPTNLInformation.GTPTunnel.TransportLayerAddress.Value = aper.BitString{
+		Bytes: anIP.To4(), BitLength: 32,
+	}
+	transfer.DLNGUUPTNLInformation.GTPTunnel.GTPTEID.Value = binary.BigEndian.AppendUint32(nil, teid)
+	transfer.QosFlowAcceptedList.List = []ngapType.QosFlowAcceptedItem{
+		{QosFlowIdentifier: ngapType.QosFlowIdentifier{Value: 1}},
+	}
+	buf, err := aper.MarshalWithParams(transfer, "valueExt")
+	require.NoError(t, err)
+	return buf
+}
+
+func TestPathSwitchTEIDReuseReleasesStaleSession(t *testing.T) {
+	upi := newAccessNetworkUserPlane()
+	contexttest.SetUserPlane(t, upi)
+	released := stubSessionRelease(t)
+
+	gnbIP := net.ParseIP("10.1.0.1")
+	stale := newGnbSMContext(t, "imsi-208930000000701", gnbIP)
+	current := newGnbSMContext(t, "imsi-208930000000702", gnbIP)
+	t.Cleanup(func() { context.RemoveSMContext(current.Ref) })
+
+	require.NoError(t, context.HandlePathSwitchRequestTransfer(buildPathSwitchRequestTransfer(t, gnbIP, 7), stale))
+	require.Equal(t, 1, upi.TEIDPool.InUse(gnbIP.String()))
+	require.Empty(t, released)
+
+	// the gNB gives the TEID to another session, the one holding it is stale
+	require.NoError(t, context.HandlePathSwitchRequestTransfer(buildPathSwitchRequestTransfer(t, gnbIP, 7), current))
+	require.Equal(t, nasMessage.Cause5GSMReactivationRequested, <-released)
+	require.Nil(t, context.GetSMContext(stale.Ref))
+	require.NotNil(t, context.GetSMContext(current.Ref))
+	require.Equal(t, 1, upi.TEIDPool.InUse(gnbIP.String()), "TEID held by the current session")
+}
+
+func TestTEIDReuseKeepsDeactivatedSession(t *testing.T) {
+	upi := newAccessNetworkUserPlane()
+	contexttest.SetUserPlane(t, upi)
+	released := stubSessionRelease(t)
+
+	gnbIP := net.ParseIP("10.1.0.1")
+	idle := newGnbSMContext(t, "imsi-208930000000703", gnbIP)
+	current := newGnbSMContext(t, "imsi-208930000000704", gnbIP)
+	t.Cleanup(func() {
+		context.RemoveSMContext(idle.Ref)
+		context.RemoveSMContext(current.Ref)
+	})
+
+	require.NoError(t, context.HandlePathSwitchRequestTransfer(buildPathSwitchRequestTransfer(t, gnbIP, 7), idle))
+	require.Equal(t, 1, upi.TEIDPool.InUse(gnbIP.String()))
+
+	// the UE goes idle, the gNB being free to give its TEID to another session
+	idle.DeactivateUPConnection(*context.NewNodeID("10.0.6.1"))
+	require.Zero(t, upi.TEIDPool.InUse(gnbIP.String()))
+
+	require.NoError(t, context.HandlePathSwitchRequestTransfer(buildPathSwitchRequestTransfer(t, gnbIP, 7), current))
+	require.Empty(t, released)
+	require.NotNil(t, context.GetSMContext(idle.Ref))
+	require.Equal(t, 1, upi.TEIDPool.InUse(gnbIP.String()), "TEID held by the current session")
+}
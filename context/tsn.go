@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// Port management service message types, TS 24.539
+const (
+	PortMgmtMsgManagePortCommand uint8 = 0x01
+)
+
+// Port management parameters set by the SMF on the DS-TT and NW-TT ports
+const (
+	PortMgmtParamTxPropagationDelay  uint16 = 0x0001
+	PortMgmtParamGptpDomainNumber    uint16 = 0x0080
+	PortMgmtParamLogSyncInterval     uint16 = 0x0081
+	PortMgmtParamLogAnnounceInterval uint16 = 0x0082
+	PortMgmtParamPriority1           uint16 = 0x0083
+	PortMgmtParamPriority2           uint16 = 0x0084
+)
+
+// TSNBridge is the DS-TT port of the session on the 5GS TSN bridge, created by the UPF on the
+// PFCP Session Establishment, and the NW-TT port the SMF manages on the UPF. TS 23.501 5.28
+type TSNBridge struct {
+	UPFNodeID      NodeID
+	BridgeID       net.HardwareAddr
+	DSTTPortNumber uint32
+	// 0 if the UPF reported no NW-TT port
+	NWTTPortNumber uint32
+	// last port management information container of the NW-TT reported by the UPF
+	NWTTPortManagement []byte
+}
+
+// GetTSNPortManagement returns the port management information container commanding the
+// TSN configuration of the DNN of the session. The container is a MANAGE PORT COMMAND followed
+// by the length of the port management list and its parameters, each encoded as identifier,
+// length and value.
+func GetTSNPortManagement(smContext *SMContext) ([]byte, error) {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.TSNConfig == nil {
+		return nil, fmt.Errorf("no TSN configuration for DNN[%s]", smContext.Dnn)
+	}
+	tsnConfig := smContext.DNNInfo.TSNConfig
+
+	list := make([]byte, 0)
+	appendParam := func(id uint16, value []byte) {
+		list = binary.BigEndian.AppendUint16(list, id)
+		list = append(list, uint8(len(value)))
+		list = append(list, value...)
+	}
+	appendParam(PortMgmtParamTxPropagationDelay, binary.BigEndian.AppendUint32(nil, tsnConfig.TxPropagationDelay))
+	appendParam(PortMgmtParamGptpDomainNumber, []byte{tsnConfig.GptpDomainNumber})
+	appendParam(PortMgmtParamLogSyncInterval, []byte{uint8(tsnConfig.LogSyncInterval)})
+	appendParam(PortMgmtParamLogAnnounceInterval, []byte{uint8(tsnConfig.LogAnnounceInterval)})
+	appendParam(PortMgmtParamPriority1, []byte{tsnConfig.Priority1})
+	appendParam(PortMgmtParamPriority2, []byte{tsnConfig.Priority2})
+
+	container := []byte{PortMgmtMsgManagePortCommand}
+	container = binary.BigEndian.AppendUint16(container, uint16(len(list)))
+	return append(container, list...), nil
+}
+
+// ParseCreatedBridgeInfo decodes the DS-TT port and the TSN bridge created by the UPF for the
+// session. TS 29.244 7.5.3.6
+func ParseCreatedBridgeInfo(createdBridgeInfo *ie.IE) (*TSNBridge, error) {
+	ies, err := createdBridgeInfo.CreatedBridgeInfoForTSC()
+	if err != nil {
+		return nil, err
+	}
+	bridge := &TSNBridge{}
+	for _, i := range ies {
+		switch i.Type {
+		case ie.DSTTPortNumber:
+			if bridge.DSTTPortNumber, err = i.DSTTPortNumber(); err != nil {
+				return nil, err
+			}
+		case ie.NWTTPortNumber:
+			if bridge.NWTTPortNumber, err = i.NWTTPortNumber(); err != nil {
+				return nil, err
+			}
+		case ie.TSNBridgeID:
+			if bridge.BridgeID, err = i.TSNBridgeID(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if bridge.DSTTPortNumber == 0 {
+		return nil, fmt.Errorf("missing DS-TT port number")
+	}
+	return bridge, nil
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestGetTSNPortManagement(t *testing.T) {
+	smContext := &context.SMContext{
+		Dnn: "tsn",
+		DNNInfo: &context.SnssaiSmfDnnInfo{
+			TSNConfig: &factory.TSNConfig{
+				TxPropagationDelay:  1000,
+				GptpDomainNumber:    0,
+				LogSyncInterval:     -3,
+				LogAnnounceInterval: 0,
+				Priority1:           246,
+				Priority2:           248,
+			},
+		},
+	}
+
+	pmic, err := context.GetTSNPortManagement(smContext)
+	require.NoError(t, err)
+
+	expected := []byte{
+		0x01,       // MANAGE PORT COMMAND
+		0x00, 0x1b, // port management list length
+		0x00, 0x01, 0x04, 0x00, 0x00, 0x03, 0xe8, // txPropagationDelay
+		0x00, 0x80, 0x01, 0x00, // gPTP domain number
+		0x00, 0x81, 0x01, 0xfd, // logSyncInterval
+		0x00, 0x82, 0x01, 0x00, // logAnnounceInterval
+		0x00, 0x83, 0x01, 0xf6, // priority1
+		0x00, 0x84, 0x01, 0xf8, // priority2
+	}
+	require.Equal(t, expected, pmic)
+}
+
+func TestGetTSNPortManagementWithoutConfig(t *testing.T) {
+	smContext := &context.SMContext{
+		Dnn:     "internet",
+		DNNInfo: &context.SnssaiSmfDnnInfo{},
+	}
+
+	_, err := context.GetTSNPortManagement(smContext)
+	require.Error(t, err)
+}
+
+func TestParseCreatedBridgeInfo(t *testing.T) {
+	bridgeID := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	bridge, err := context.ParseCreatedBridgeInfo(ie.NewCreatedBridgeInfoForTSC(
+		ie.NewDSTTPortNumber(2),
+		ie.NewNWTTPortNumber(7),
+		ie.NewTSNBridgeID(bridgeID),
+	))
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), bridge.DSTTPortNumber)
+	require.Equal(t, uint32(7), bridge.NWTTPortNumber)
+	require.Equal(t, bridgeID, bridge.BridgeID)
+
+	_, err = context.ParseCreatedBridgeInfo(ie.NewCreatedBridgeInfoForTSC(ie.NewNWTTPortNumber(7)))
+	require.Error(t, err)
+}
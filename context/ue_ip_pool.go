@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/omec-project/smf/logger"
+)
+
+// UeIPPoolKey identifies the UE IP pool of a DNN in a network slice.
+// Slices sharing a DNN name get distinct pools, so that one slice exhausting
+// its pool does not affect the others.
+type UeIPPoolKey struct {
+	Dnn    string
+	Snssai SNssai
+}
+
+// ueIPAllocator returns the UE IP pool of the DNN in the slice, creating it on first use.
+// The pool is kept across re-insertions of the slice, such as a slice configured for two
+// PLMNs or a modified slice, unless its subnet changed, so that the addresses held by the
+// existing sessions are not handed out again.
+func (c *SMFContext) ueIPAllocator(snssai SNssai, dnn, cidr string) (*IPAllocator, error) {
+	c.UeIPPoolsLock.Lock()
+	defer c.UeIPPoolsLock.Unlock()
+
+	if c.UeIPPools == nil {
+		c.UeIPPools = make(map[UeIPPoolKey]*IPAllocator)
+	}
+
+	key := UeIPPoolKey{Snssai: snssai, Dnn: dnn}
+	if allocator, ok := c.UeIPPools[key]; ok {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.String() == allocator.Domain() {
+			return allocator, nil
+		}
+		logger.CtxLog.Infof("UE subnet of slice [sst:%v, sd:%v], dnn [%s] changed from [%s] to [%s]",
+			snssai.Sst, snssai.Sd, dnn, allocator.Domain(), cidr)
+	}
+
+	allocator, err := NewIPAllocator(cidr)
+	if err != nil {
+		return nil, err
+	}
+	allocator.dnn = dnn
+	allocator.snssai = fmt.Sprintf("%d-%s", snssai.Sst, snssai.Sd)
+	allocator.reportAvailable()
+	c.UeIPPools[key] = allocator
+	return allocator, nil
+}
+
+// PoolContext is the DNN and network slice whose UE IP pool holds an address
+type PoolContext struct {
+	Dnn    string
+	Snssai SNssai
+	// subnet of the pool
+	Domain string
+}
+
+// GetPoolContext returns the DNN, network slice and subnet of the UE IP pool holding the address,
+// allocated or not, to correlate the logs of the UE addresses to the slices. Among overlapping
+// pools the one of the narrowest subnet is returned. Returns false when no configured DNN holds
+// the address.
+func GetPoolContext(ip net.IP) (PoolContext, bool) {
+	var poolContext PoolContext
+	found := false
+	prefixLength := -1
+	for _, snssaiInfo := range SMF_Self().SnssaiInfos {
+		dnns := make([]string, 0, len(snssaiInfo.DnnInfos))
+		for dnn := range snssaiInfo.DnnInfos {
+			dnns = append(dnns, dnn)
+		}
+		slices.Sort(dnns)
+		for _, dnn := range dnns {
+			allocator := snssaiInfo.DnnInfos[dnn].UeIPAllocator
+			if allocator == nil || !allocator.Contains(ip) {
+				continue
+			}
+			if ones, _ := allocator.ipNetwork.Mask.Size(); ones > prefixLength {
+				prefixLength = ones
+				poolContext = PoolContext{Dnn: dnn, Snssai: snssaiInfo.Snssai, Domain: allocator.Domain()}
+				found = true
+			}
+		}
+	}
+	return poolContext, found
+}
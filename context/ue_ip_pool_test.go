@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPoolContext(t *testing.T) {
+	dnnInfo := func(cidr string) *context.SnssaiSmfDnnInfo {
+		allocator, err := context.NewIPAllocator(cidr)
+		require.NoError(t, err)
+		return &context.SnssaiSmfDnnInfo{UeIPAllocator: allocator}
+	}
+	embb := context.SNssai{Sst: 1, Sd: "010203"}
+	iot := context.SNssai{Sst: 2, Sd: "112233"}
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai: embb,
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{
+				"internet": dnnInfo("10.60.0.0/16"),
+				"ims":      dnnInfo("10.61.0.0/24"),
+				"lan":      {Ethernet: true},
+			},
+		},
+		{
+			Snssai: iot,
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{
+				"internet": dnnInfo("10.70.0.0/24"),
+				// carved out of the internet pool of the eMBB slice
+				"sensors": dnnInfo("10.60.8.0/24"),
+			},
+		},
+	})
+
+	for _, tc := range []struct {
+		ip       string
+		expected context.PoolContext
+	}{
+		{"10.60.0.1", context.PoolContext{Dnn: "internet", Snssai: embb, Domain: "10.60.0.0/16"}},
+		{"10.60.255.254", context.PoolContext{Dnn: "internet", Snssai: embb, Domain: "10.60.0.0/16"}},
+		{"10.61.0.20", context.PoolContext{Dnn: "ims", Snssai: embb, Domain: "10.61.0.0/24"}},
+		{"10.70.0.5", context.PoolContext{Dnn: "internet", Snssai: iot, Domain: "10.70.0.0/24"}},
+		// the narrowest of the overlapping pools
+		{"10.60.8.9", context.PoolContext{Dnn: "sensors", Snssai: iot, Domain: "10.60.8.0/24"}},
+	} {
+		poolContext, ok := context.GetPoolContext(net.ParseIP(tc.ip))
+		require.True(t, ok, tc.ip)
+		require.Equal(t, tc.expected, poolContext, tc.ip)
+	}
+
+	// not in any pool
+	_, ok := context.GetPoolContext(net.ParseIP("10.62.0.1"))
+	require.False(t, ok)
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// UEReachability is the outcome of the last UE reachability probe of the session
+type UEReachability struct {
+	Reachable bool      `json:"reachable"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// pending messages of the UE reachability probes, SM context ref, UPF IP and sequence number ->
+// UPF response, nil if the UPF did not answer
+var reachabilityProbes sync.Map
+
+func reachabilityProbeKey(ref string, nodeID NodeID, seq uint32) string {
+	return fmt.Sprintf("%s/%s/%d", ref, nodeID.ResolveNodeIdToIp(), seq)
+}
+
+// StartReachabilityProbe registers a Session Modification of the UE reachability probe of the
+// session sent to the UPF with the sequence number, the returned channel receives its response
+func (smContext *SMContext) StartReachabilityProbe(nodeID NodeID, seq uint32) <-chan *message.SessionModificationResponse {
+	ch := make(chan *message.SessionModificationResponse, 1)
+	reachabilityProbes.Store(reachabilityProbeKey(smContext.Ref, nodeID, seq), ch)
+	return ch
+}
+
+// CompleteReachabilityProbe delivers the response to the pending message of the UE reachability
+// probe of the session, nil if the UPF did not answer. Returns false when none is pending.
+func (smContext *SMContext) CompleteReachabilityProbe(nodeID NodeID, seq uint32,
+	rsp *message.SessionModificationResponse,
+) bool {
+	value, ok := reachabilityProbes.LoadAndDelete(reachabilityProbeKey(smContext.Ref, nodeID, seq))
+	if !ok {
+		return false
+	}
+	value.(chan *message.SessionModificationResponse) <- rsp
+	return true
+}
+
+// CancelReachabilityProbe drops the pending message of the UE reachability probe of the session
+func (smContext *SMContext) CancelReachabilityProbe(nodeID NodeID, seq uint32) {
+	reachabilityProbes.Delete(reachabilityProbeKey(smContext.Ref, nodeID, seq))
+}
+
+// UpdateReachabilityRouting routes the downlink packets of the session on the AN UPF of the node
+// ID according to the reachability of its UE: the packets forwarded to the AN are dropped while
+// the UE is unreachable, and forwarded again once reachable. Records the outcome of the probe and
+// returns the FARs to update on the UPF, none if the routing is unchanged. To be called with
+// SMLock held.
+func (smContext *SMContext) UpdateReachabilityRouting(upfNodeID NodeID, reachable bool, checkedAt time.Time) []*FAR {
+	wasReachable := smContext.UEReachability == nil || smContext.UEReachability.Reachable
+	smContext.UEReachability = &UEReachability{Reachable: reachable, CheckedAt: checkedAt}
+	farList := []*FAR{}
+	if reachable == wasReachable || smContext.Tunnel == nil {
+		return farList
+	}
+
+	upfIP := upfNodeID.ResolveNodeIdToIp()
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		ANUPF := dataPath.FirstDPNode
+		if !dataPath.Activated || ANUPF == nil || ANUPF.DownLinkTunnel == nil ||
+			!ANUPF.UPF.NodeID.ResolveNodeIdToIp().Equal(upfIP) {
+			continue
+		}
+		for _, DLPDR := range ANUPF.DownLinkTunnel.PDR {
+			// the downlink packets buffered for a UE in idle are left buffered
+			if DLPDR == nil || DLPDR.FAR == nil || DLPDR.FAR.ForwardingParameters == nil ||
+				DLPDR.FAR.ForwardingParameters.OuterHeaderCreation == nil {
+				continue
+			}
+			far := DLPDR.FAR
+			switch {
+			case !reachable && far.ApplyAction.Forw:
+				far.ApplyAction = ApplyAction{Drop: true}
+			case reachable && far.ApplyAction.Drop:
+				far.ApplyAction = ApplyAction{Forw: true}
+			default:
+				continue
+			}
+			far.State = RULE_UPDATE
+			farList = append(farList, far)
+		}
+	}
+	return farList
+}
@@ -11,9 +11,9 @@ import (
 	"fmt"
 	"math"
 	"net"
-	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,6 +21,8 @@ import (
 	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/factory"
 	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/smferrors"
 	"github.com/omec-project/util/idgenerator"
 )
 
@@ -76,6 +78,10 @@ type UPF struct {
 	barIDGenerator *idgenerator.IDGenerator
 	urrIDGenerator *idgenerator.IDGenerator
 	qerIDGenerator *idgenerator.IDGenerator
+	marIDGenerator *idgenerator.IDGenerator
+	// TEIDs of the UPF allocated by the SMF in its partition, nil if chosen by the UPF
+	teidGenerator *idgenerator.IDGenerator
+	teidPartition factory.TEIDPartition
 
 	RecoveryTimeStamp RecoveryTimeStamp
 	NodeID            NodeID
@@ -83,16 +89,86 @@ type UPF struct {
 	uuid              uuid.UUID
 	Port              uint16
 	NHeartBeat        uint8
+	// the UPF deleted the PFCP sessions with the association released by the SMF, they are
+	// recovered on the next association whatever the recovery timestamp of the UPF
+	SessionsLost bool
+	// N6 traffic steering with SRv6 segment lists, configured as it is not a UP function
+	// feature of TS 29.244
+	SRv6Steering bool
+	// the SMF presents its FQDN as Node ID to the UPF, else its IP address
+	CPNodeIDFqdn bool
+
+	// last association or PFCP error of the UPF, nil since its last successful association
+	lastError     *UPFError
+	lastErrorLock sync.RWMutex
+
+	// end of the congestion of the UPF predicted by the NWDAF, zero if none
+	congestedUntil time.Time
+	congestionLock sync.RWMutex
+
+	// last load reported by the UPF in a PFCP Node Report
+	loadMetrics     UPFLoadMetrics
+	loadMetricsLock sync.RWMutex
+	// number of sessions with a PFCP session context on the UPF
+	activeSessions atomic.Int64
+
+	// PFCP sequence numbers and requests awaiting a response on this association
+	pendingPfcpReqs map[uint32]*PendingPfcpRequest
+	pfcpSeq         uint32
+	pendingPfcpLock sync.Mutex
 
 	// lock
 	UpfLock sync.RWMutex
 }
 
+// ErrPfcpRequestTimeout resolves the pending requests evicted without response
+var ErrPfcpRequestTimeout = smferrors.New(smferrors.ErrCodePFCPTimeout, "PFCP request timed out without response")
+
+// PendingPfcpRequest is a PFCP request sent to the UPF which is still waiting for its response
+type PendingPfcpRequest struct {
+	SentAt time.Time
+	NodeID *NodeID
+	// resolved once, nil on the response or ErrPfcpRequestTimeout on the eviction
+	done chan error
+}
+
+// NewPendingPfcpRequest returns a request sent now to the node, waiting for its response
+func NewPendingPfcpRequest(nodeID *NodeID) *PendingPfcpRequest {
+	return &PendingPfcpRequest{
+		NodeID: nodeID,
+		SentAt: time.Now(),
+		done:   make(chan error, 1),
+	}
+}
+
+// Wait blocks until the response to the request is received, or the request is evicted
+func (req *PendingPfcpRequest) Wait() error {
+	return <-req.done
+}
+
+// Resolve releases the waiter of the request with the outcome, nil once answered
+func (req *PendingPfcpRequest) Resolve(err error) {
+	select {
+	case req.done <- err:
+	default:
+	}
+}
+
+// Expired returns true once the request waits for longer than ttl
+func (req *PendingPfcpRequest) Expired(now time.Time, ttl time.Duration) bool {
+	return now.Sub(req.SentAt) > ttl
+}
+
+// pfcpSeqMax is the largest value of the 24 bits PFCP sequence number
+const pfcpSeqMax = 1<<24 - 1
+
 // UPFSelectionParams ... parameters for upf selection
 type UPFSelectionParams struct {
-	Dnn    string
 	SNssai *SNssai
-	Dnai   string
+	// tracking area of the UE, the UPFs mapped to it are preferred
+	Tai  *models.Tai
+	Dnn  string
+	Dnai string
 }
 
 // UPFInterfaceInfo store the UPF interface information
@@ -131,6 +207,11 @@ func NewUPFInterfaceInfo(i *factory.InterfaceUpfInfoItem) *UPFInterfaceInfo {
 // *** add unit test ***//
 // IP returns the IP of the user plane IP information of the pduSessType
 func (i *UPFInterfaceInfo) IP(pduSessType uint8) (net.IP, error) {
+	// the tunnels of the Ethernet sessions use any IP version of the interface
+	if pduSessType == nasMessage.PDUSessionTypeEthernet {
+		pduSessType = nasMessage.PDUSessionTypeIPv4IPv6
+	}
+
 	if (pduSessType == nasMessage.PDUSessionTypeIPv4 || pduSessType == nasMessage.PDUSessionTypeIPv4IPv6) && len(i.IPv4EndPointAddresses) != 0 {
 		return i.IPv4EndPointAddresses[0].To4(), nil
 	}
@@ -179,6 +260,10 @@ func (upfSelectionParams *UPFSelectionParams) String() string {
 		str += fmt.Sprintf("DNAI: %s\n", Dnai)
 	}
 
+	if Tai := upfSelectionParams.Tai; Tai != nil {
+		str += fmt.Sprintf("TAI: %s\n", TAIKey(Tai))
+	}
+
 	return str
 }
 
@@ -225,6 +310,8 @@ func NewUPF(nodeID *NodeID, ifaces []factory.InterfaceUpfInfoItem) (upf *UPF) {
 	upf.barIDGenerator = idgenerator.NewGenerator(1, math.MaxUint8)
 	upf.qerIDGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
 	upf.urrIDGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
+	upf.marIDGenerator = idgenerator.NewGenerator(1, math.MaxUint16)
+	upf.pendingPfcpReqs = make(map[uint32]*PendingPfcpRequest)
 
 	upf.N3Interfaces = make([]UPFInterfaceInfo, 0)
 	upf.N9Interfaces = make([]UPFInterfaceInfo, 0)
@@ -263,6 +350,103 @@ func (upf *UPF) GetInterface(interfaceType models.UpInterfaceType, dnn string) *
 	return nil
 }
 
+// NextPfcpSeqNumber allocates the next PFCP sequence number of the association,
+// the offset of the SMF instance is added before it wraps around within 24 bits
+// and it never returns 0
+func (upf *UPF) NextPfcpSeqNumber(offset uint32) uint32 {
+	for {
+		if seq := (atomic.AddUint32(&upf.pfcpSeq, 1) + offset) & pfcpSeqMax; seq != 0 {
+			return seq
+		}
+	}
+}
+
+// InsertPendingPfcpRequest records a request sent to the UPF until its response is received
+func (upf *UPF) InsertPendingPfcpRequest(seq uint32, nodeID *NodeID) *PendingPfcpRequest {
+	upf.pendingPfcpLock.Lock()
+	defer upf.pendingPfcpLock.Unlock()
+	if upf.pendingPfcpReqs == nil {
+		upf.pendingPfcpReqs = make(map[uint32]*PendingPfcpRequest)
+	}
+	if overwritten, exist := upf.pendingPfcpReqs[seq]; exist {
+		logger.PfcpLog.Warnf("overwriting pending pfcp request seq[%d] of UPF[%s]", seq, upf.NodeID.ResolveNodeIdToIp())
+		overwritten.Resolve(ErrPfcpRequestTimeout)
+	}
+	req := NewPendingPfcpRequest(nodeID)
+	upf.pendingPfcpReqs[seq] = req
+	metrics.SetPendingPfcpRequests(upf.NodeID.String(), len(upf.pendingPfcpReqs))
+	return req
+}
+
+// FetchPendingPfcpRequest returns and removes the pending request matching the response sequence number
+func (upf *UPF) FetchPendingPfcpRequest(seq uint32) *PendingPfcpRequest {
+	return upf.ResolvePendingPfcpRequest(seq, nil)
+}
+
+// ResolvePendingPfcpRequest returns and removes the pending request of the sequence number, its
+// waiter getting err, nil once answered
+func (upf *UPF) ResolvePendingPfcpRequest(seq uint32, err error) *PendingPfcpRequest {
+	upf.pendingPfcpLock.Lock()
+	defer upf.pendingPfcpLock.Unlock()
+	req, exist := upf.pendingPfcpReqs[seq]
+	if exist {
+		delete(upf.pendingPfcpReqs, seq)
+		metrics.SetPendingPfcpRequests(upf.NodeID.String(), len(upf.pendingPfcpReqs))
+		req.Resolve(err)
+	}
+	return req
+}
+
+// dropPendingPfcpRequests resolves the requests of the removed UPF with ErrPfcpRequestTimeout,
+// no longer evicted, and deletes their metric
+func (upf *UPF) dropPendingPfcpRequests() {
+	upf.pendingPfcpLock.Lock()
+	defer upf.pendingPfcpLock.Unlock()
+	for seq, req := range upf.pendingPfcpReqs {
+		delete(upf.pendingPfcpReqs, seq)
+		req.Resolve(ErrPfcpRequestTimeout)
+	}
+	metrics.DeletePendingPfcpRequests(upf.NodeID.String())
+}
+
+// EvictExpiredPfcpRequests removes the requests waiting for longer than ttl, their waiters
+// getting ErrPfcpRequestTimeout. Returns the number of evicted requests.
+func (upf *UPF) EvictExpiredPfcpRequests(now time.Time, ttl time.Duration) int {
+	upf.pendingPfcpLock.Lock()
+	defer upf.pendingPfcpLock.Unlock()
+	evicted := 0
+	for seq, req := range upf.pendingPfcpReqs {
+		if req.Expired(now, ttl) {
+			delete(upf.pendingPfcpReqs, seq)
+			req.Resolve(ErrPfcpRequestTimeout)
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		logger.PfcpLog.Warnf("evicted %d pfcp requests of UPF[%s] left without response", evicted, upf.NodeID.String())
+		metrics.SetPendingPfcpRequests(upf.NodeID.String(), len(upf.pendingPfcpReqs))
+	}
+	return evicted
+}
+
+// EvictExpiredPfcpRequests evicts the requests pending for longer than ttl on all the UPFs
+func EvictExpiredPfcpRequests(ttl time.Duration) int {
+	now := time.Now()
+	evicted := 0
+	upfPool.Range(func(key, value interface{}) bool {
+		evicted += value.(*UPF).EvictExpiredPfcpRequests(now, ttl)
+		return true
+	})
+	return evicted
+}
+
+// PendingPfcpRequestCount returns the number of requests still waiting for a response
+func (upf *UPF) PendingPfcpRequestCount() int {
+	upf.pendingPfcpLock.Lock()
+	defer upf.pendingPfcpLock.Unlock()
+	return len(upf.pendingPfcpReqs)
+}
+
 func (upf *UPF) PFCPAddr() *net.UDPAddr {
 	return &net.UDPAddr{
 		IP:   upf.NodeID.ResolveNodeIdToIp(),
@@ -270,21 +454,23 @@ func (upf *UPF) PFCPAddr() *net.UDPAddr {
 	}
 }
 
-// *** add unit test ***//
+// CPNodeID returns the Node ID the SMF presents in the PFCP association with the UPF
+func (upf *UPF) CPNodeID() NodeID {
+	smfSelf := SMF_Self()
+	if upf.CPNodeIDFqdn && smfSelf.CPNodeFQDN != "" {
+		return NodeID{
+			NodeIdType:  NodeIdTypeFqdn,
+			NodeIdValue: []byte(smfSelf.CPNodeFQDN),
+		}
+	}
+	return smfSelf.CPNodeID
+}
+
 func RetrieveUPFNodeByNodeID(nodeID NodeID) *UPF {
 	var targetUPF *UPF = nil
 	upfPool.Range(func(key, value interface{}) bool {
 		curUPF := value.(*UPF)
-		if curUPF.NodeID.NodeIdType != nodeID.NodeIdType &&
-			(curUPF.NodeID.NodeIdType == NodeIdTypeFqdn || nodeID.NodeIdType == NodeIdTypeFqdn) {
-			curUPFNodeIdIP := curUPF.NodeID.ResolveNodeIdToIp().To4()
-			nodeIdIP := nodeID.ResolveNodeIdToIp().To4()
-			logger.CtxLog.Debugf("retrieveUPF - upfNodeIdIP:[%+v], nodeIdIP:[%+v]", curUPFNodeIdIP, nodeIdIP)
-			if reflect.DeepEqual(curUPFNodeIdIP, nodeIdIP) {
-				targetUPF = curUPF
-				return false
-			}
-		} else if reflect.DeepEqual(curUPF.NodeID, nodeID) {
+		if curUPF.NodeID.Equal(nodeID) {
 			targetUPF = curUPF
 			return false
 		}
@@ -297,18 +483,11 @@ func RetrieveUPFNodeByNodeID(nodeID NodeID) *UPF {
 // *** add unit test ***//
 func RemoveUPFNodeByNodeID(nodeID NodeID) bool {
 	upfID := ""
+	var removed *UPF
 	upfPool.Range(func(key, value interface{}) bool {
 		upfID = key.(string)
-		upf := value.(*UPF)
-		if upf.NodeID.NodeIdType != nodeID.NodeIdType &&
-			(upf.NodeID.NodeIdType == NodeIdTypeFqdn || nodeID.NodeIdType == NodeIdTypeFqdn) {
-			upfNodeIdIP := upf.NodeID.ResolveNodeIdToIp().To4()
-			nodeIdIP := nodeID.ResolveNodeIdToIp().To4()
-			logger.CtxLog.Debugf("removeUPF - upfNodeIdIP:[%+v], nodeIdIP:[%+v]", upfNodeIdIP, nodeIdIP)
-			if reflect.DeepEqual(upfNodeIdIP, nodeIdIP) {
-				return false
-			}
-		} else if reflect.DeepEqual(upf.NodeID, nodeID) {
+		removed = value.(*UPF)
+		if removed.NodeID.Equal(nodeID) {
 			return false
 		}
 		upfID = ""
@@ -317,6 +496,7 @@ func RemoveUPFNodeByNodeID(nodeID NodeID) bool {
 
 	if upfID != "" {
 		upfPool.Delete(upfID)
+		removed.dropPendingPfcpRequests()
 		return true
 	}
 	return false
@@ -397,6 +577,38 @@ func (upf *UPF) qerID() (uint32, error) {
 	return qerID, nil
 }
 
+func (upf *UPF) urrID() (uint32, error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err := fmt.Errorf("this upf not associate with smf")
+		return 0, err
+	}
+
+	var urrID uint32
+	if tmpID, err := upf.urrIDGenerator.Allocate(); err != nil {
+		return 0, err
+	} else {
+		urrID = uint32(tmpID)
+	}
+
+	return urrID, nil
+}
+
+func (upf *UPF) marID() (uint16, error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err := fmt.Errorf("this upf not associate with smf")
+		return 0, err
+	}
+
+	var marID uint16
+	if tmpID, err := upf.marIDGenerator.Allocate(); err != nil {
+		return 0, err
+	} else {
+		marID = uint16(tmpID)
+	}
+
+	return marID, nil
+}
+
 func (upf *UPF) BuildCreatePdrFromPccRule(rule *models.PccRule) (*PDR, error) {
 	var pdr *PDR
 	var err error
@@ -446,6 +658,16 @@ func (upf *UPF) BuildCreatePdrFromPccRule(rule *models.PccRule) (*PDR, error) {
 		SDFFilter: &sdfFilter,
 	}
 
+	// Application Detection
+	if rule.AppId != "" {
+		if upf.IsUpfSupportAppDetection() {
+			pdi.ApplicationID = rule.AppId
+		} else {
+			logger.CtxLog.Warnf("UPF[%s] does not support application detection, application id [%s] of pcc rule [%s] not applied",
+				upf.NodeID.ResolveNodeIdToIp().String(), rule.AppId, rule.PccRuleId)
+		}
+	}
+
 	pdr.PDI = pdi
 	pdr.Precedence = uint32(rule.Precedence)
 
@@ -526,6 +748,38 @@ func (upf *UPF) AddQER() (*QER, error) {
 	return qer, nil
 }
 
+func (upf *UPF) AddURR() (*URR, error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err := fmt.Errorf("this upf do not associate with smf")
+		return nil, err
+	}
+
+	urr := new(URR)
+	if URRID, err := upf.urrID(); err != nil {
+		return nil, err
+	} else {
+		urr.URRID = URRID
+	}
+
+	return urr, nil
+}
+
+func (upf *UPF) AddMAR() (*MAR, error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err := fmt.Errorf("this upf do not associate with smf")
+		return nil, err
+	}
+
+	mar := new(MAR)
+	if MARID, err := upf.marID(); err != nil {
+		return nil, err
+	} else {
+		mar.MARID = MARID
+	}
+
+	return mar, nil
+}
+
 // *** add unit test ***//
 func (upf *UPF) RemovePDR(pdr *PDR) (err error) {
 	if upf.UPFStatus != AssociatedSetUpSuccess {
@@ -574,9 +828,29 @@ func (upf *UPF) RemoveQER(qer *QER) (err error) {
 	return nil
 }
 
+func (upf *UPF) RemoveURR(urr *URR) (err error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err = fmt.Errorf("this upf not associate with smf")
+		return err
+	}
+
+	upf.urrIDGenerator.FreeID(int64(urr.URRID))
+	return nil
+}
+
+func (upf *UPF) RemoveMAR(mar *MAR) (err error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err = fmt.Errorf("this upf not associate with smf")
+		return err
+	}
+
+	upf.marIDGenerator.FreeID(int64(mar.MARID))
+	return nil
+}
+
 func (upf *UPF) isSupportSnssai(snssai *SNssai) bool {
 	for _, snssaiInfo := range upf.SNssaiInfos {
-		if snssaiInfo.SNssai.Equal(snssai) {
+		if snssaiInfo.SNssai.Serves(snssai) {
 			return true
 		}
 	}
@@ -596,6 +870,25 @@ func (upf *UPF) IsDnnConfigured(sDnn string) bool {
 	return false
 }
 
+// IsSessionServed checks that the UPF serves the serving slice and DNN of the session
+func (upf *UPF) IsSessionServed(smContext *SMContext) bool {
+	snssai := smContext.ServingSnssai()
+	if snssai == nil {
+		return false
+	}
+	for _, snssaiInfo := range upf.SNssaiInfos {
+		if !snssaiInfo.SNssai.Serves(snssai) {
+			continue
+		}
+		for _, dnn := range snssaiInfo.DnnList {
+			if dnn.Dnn == smContext.Dnn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // IsUpfSupportUeIpAddrAlloc UE IP addr alloc by UPF supported
 func (upf *UPF) IsUpfSupportUeIpAddrAlloc() bool {
 	if upf.UPFunctionFeatures != nil &&
@@ -605,3 +898,30 @@ func (upf *UPF) IsUpfSupportUeIpAddrAlloc() bool {
 
 	return false
 }
+
+// IsUpfSupportSteering steering of the MA PDU session traffic by UPF supported, with the ATSSS-LL
+// or MPTCP feature
+func (upf *UPF) IsUpfSupportSteering(functionality SteeringFunctionality) bool {
+	if upf.UPFunctionFeatures == nil {
+		return false
+	}
+	switch functionality {
+	case SteeringFunctionalityATSSSLL:
+		return (upf.UPFunctionFeatures.SupportedFeatures2 & UpFunctionFeatures2AtsssLl) == UpFunctionFeatures2AtsssLl
+	case SteeringFunctionalityMPTCP:
+		return (upf.UPFunctionFeatures.SupportedFeatures1 & UpFunctionFeatures1Mptcp) == UpFunctionFeatures1Mptcp
+	}
+	return false
+}
+
+// IsUpfSupportAppDetection application detection by UPF supported, reported through the
+// PFD management (ADC) or ATSSS-LL features
+func (upf *UPF) IsUpfSupportAppDetection() bool {
+	if upf.UPFunctionFeatures != nil &&
+		((upf.UPFunctionFeatures.SupportedFeatures&UpFunctionFeaturesPfdm) == UpFunctionFeaturesPfdm ||
+			(upf.UPFunctionFeatures.SupportedFeatures2&UpFunctionFeatures2AtsssLl) == UpFunctionFeatures2AtsssLl) {
+		return true
+	}
+
+	return false
+}
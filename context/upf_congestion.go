@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"time"
+)
+
+// SetCongestedUntil records the congestion of the UPF predicted by the NWDAF, the UPF being
+// selected for the new sessions after the others until the end of the prediction
+func (upf *UPF) SetCongestedUntil(until time.Time) {
+	upf.congestionLock.Lock()
+	defer upf.congestionLock.Unlock()
+	upf.congestedUntil = until
+}
+
+// ClearCongestion forgets the congestion predicted for the UPF
+func (upf *UPF) ClearCongestion() {
+	upf.SetCongestedUntil(time.Time{})
+}
+
+// IsCongested returns true while a congestion of the UPF is predicted
+func (upf *UPF) IsCongested(now time.Time) bool {
+	upf.congestionLock.RLock()
+	defer upf.congestionLock.RUnlock()
+	return now.Before(upf.congestedUntil)
+}
+
+// GetUPFNodeByNFInstance returns the UPF identified by an NWDAF analytics, by its name or its
+// node ID, nil if none matches
+func (upi *UserPlaneInformation) GetUPFNodeByNFInstance(nfInstanceID string) *UPNode {
+	if upNode, ok := upi.UPFs[nfInstanceID]; ok {
+		return upNode
+	}
+	for _, upNode := range upi.UPFs {
+		if upNode.NodeID.NodeIdType == NodeIdTypeFqdn {
+			if string(upNode.NodeID.NodeIdValue) == nfInstanceID {
+				return upNode
+			}
+			continue
+		}
+		if upNode.NodeID.ResolveNodeIdToIp().String() == nfInstanceID {
+			return upNode
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"sync"
+	"time"
+
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/metrics"
+)
+
+const (
+	// weight of the last interval in the smoothed interval of the session reports
+	congestionSmoothingFactor = 0.2
+	// alerts not consumed yet, the next ones being dropped
+	congestionAlertsDepth = 16
+)
+
+// UPFCongestionAlert is emitted when the smoothed interval of the session reports of a UPF drops
+// below the congestion threshold. Level is the threshold over the smoothed interval.
+type UPFCongestionAlert struct {
+	UPFName string
+	Level   float64
+}
+
+// reportIntervals is the smoothed interval of the session reports of a UPF
+type reportIntervals struct {
+	last      time.Time
+	smoothed  time.Duration
+	congested bool
+}
+
+// CongestionDetector tracks the interval of the PFCP session reports of each UPF. A UPF shedding
+// load reports more often, it is congested while the exponential moving average of its report
+// intervals is below the threshold, an alert being emitted when it becomes congested.
+type CongestionDetector struct {
+	lock      sync.Mutex
+	threshold time.Duration
+	upfs      map[string]*reportIntervals
+	alerts    chan UPFCongestionAlert
+}
+
+var congestionDetector *CongestionDetector
+
+// NewCongestionDetector returns a detector of the UPFs whose session reports arrive on average
+// more often than the threshold
+func NewCongestionDetector(threshold time.Duration) *CongestionDetector {
+	return &CongestionDetector{
+		threshold: threshold,
+		upfs:      make(map[string]*reportIntervals),
+		alerts:    make(chan UPFCongestionAlert, congestionAlertsDepth),
+	}
+}
+
+// GetCongestionDetector returns the congestion detector of the UPFs, nil if not configured
+func GetCongestionDetector() *CongestionDetector {
+	return congestionDetector
+}
+
+// Alerts returns the channel of the congestion alerts
+func (d *CongestionDetector) Alerts() <-chan UPFCongestionAlert {
+	return d.alerts
+}
+
+// Observe records a session report of the UPF received at the time
+func (d *CongestionDetector) Observe(upfName string, at time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	intervals, ok := d.upfs[upfName]
+	if !ok {
+		d.upfs[upfName] = &reportIntervals{last: at}
+		return
+	}
+	interval := max(at.Sub(intervals.last), 0)
+	intervals.last = at
+	if intervals.smoothed == 0 {
+		intervals.smoothed = interval
+	} else {
+		intervals.smoothed = time.Duration(congestionSmoothingFactor*float64(interval) +
+			(1-congestionSmoothingFactor)*float64(intervals.smoothed))
+	}
+
+	level := float64(d.threshold) / float64(max(intervals.smoothed, time.Microsecond))
+	metrics.SetUPFCongestionLevel(upfName, level)
+	congested := intervals.smoothed < d.threshold
+	if congested == intervals.congested {
+		return
+	}
+	intervals.congested = congested
+	if !congested {
+		logger.PfcpLog.Infof("UPF[%s] no longer congested, session reports every %v", upfName, intervals.smoothed)
+		return
+	}
+	select {
+	case d.alerts <- UPFCongestionAlert{UPFName: upfName, Level: level}:
+	default:
+		logger.PfcpLog.Warnf("UPF[%s] congestion alert dropped, alerts not consumed", upfName)
+	}
+}
+
+// Remove forgets the report intervals of the UPF
+func (d *CongestionDetector) Remove(upfName string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.upfs, upfName)
+}
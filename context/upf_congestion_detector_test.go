@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCongestionDetector(t *testing.T) {
+	detector := context.NewCongestionDetector(100 * time.Millisecond)
+	noAlert := func() {
+		t.Helper()
+		select {
+		case alert := <-detector.Alerts():
+			t.Fatalf("unexpected alert %+v", alert)
+		default:
+		}
+	}
+	at := time.Now()
+	report := func(interval time.Duration, count int) {
+		for range count {
+			at = at.Add(interval)
+			detector.Observe("upf1", at)
+		}
+	}
+
+	// reports every second
+	report(time.Second, 5)
+	noAlert()
+	require.InDelta(t, 0.1, gatheredMetric(t, "smf_upf_congestion_level", "upf1"), 0.01)
+
+	// a single rapid report is smoothed out
+	report(10*time.Millisecond, 1)
+	noAlert()
+
+	// rapid reports, the UPF sheds load
+	report(10*time.Millisecond, 20)
+	select {
+	case alert := <-detector.Alerts():
+		require.Equal(t, "upf1", alert.UPFName)
+		require.Greater(t, alert.Level, 1.0)
+	default:
+		t.Fatal("no congestion alert")
+	}
+	require.Greater(t, gatheredMetric(t, "smf_upf_congestion_level", "upf1"), 1.0)
+
+	// alerted once while congested
+	report(10*time.Millisecond, 10)
+	noAlert()
+
+	// the UPF recovers, then is congested again
+	report(time.Second, 10)
+	noAlert()
+	require.Less(t, gatheredMetric(t, "smf_upf_congestion_level", "upf1"), 1.0)
+	report(time.Millisecond, 20)
+	require.Equal(t, "upf1", (<-detector.Alerts()).UPFName)
+
+	// the reports of another UPF are tracked apart
+	detector.Observe("upf2", at)
+	detector.Observe("upf2", at.Add(time.Second))
+	noAlert()
+}
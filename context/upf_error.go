@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"time"
+
+	"github.com/omec-project/smf/smferrors"
+)
+
+// UPFError is an association or PFCP error of a UPF, kept so that the reason a UPF is not
+// associated is known without the logs. It unwraps to an SMFError of its code.
+type UPFError struct {
+	Time time.Time `json:"time"`
+	// code of the SMFError recorded, ErrCodeUPFFailure if the error had none
+	Code    smferrors.SMFErrorCode `json:"code"`
+	Message string                 `json:"error"`
+}
+
+func (e *UPFError) Error() string {
+	return e.Message
+}
+
+func (e *UPFError) Unwrap() error {
+	return smferrors.New(e.Code, e.Message)
+}
+
+// RecordError records the error as the last one of the UPF
+func (upf *UPF) RecordError(err error) {
+	code := smferrors.CodeOf(err)
+	if code == "" {
+		code = smferrors.ErrCodeUPFFailure
+	}
+	upf.lastErrorLock.Lock()
+	defer upf.lastErrorLock.Unlock()
+	upf.lastError = &UPFError{Time: time.Now(), Code: code, Message: err.Error()}
+}
+
+// ClearError forgets the last error of the UPF, on its successful association
+func (upf *UPF) ClearError() {
+	upf.lastErrorLock.Lock()
+	defer upf.lastErrorLock.Unlock()
+	upf.lastError = nil
+}
+
+// LastError returns the last error of the UPF, nil if none since its last successful association
+func (upf *UPF) LastError() *UPFError {
+	upf.lastErrorLock.RLock()
+	defer upf.lastErrorLock.RUnlock()
+	if upf.lastError == nil {
+		return nil
+	}
+	lastError := *upf.lastError
+	return &lastError
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"cmp"
+	"time"
+
+	"github.com/omec-project/smf/metrics"
+)
+
+// time the load reported by a UPF is taken into account in the selection, a UPF which stopped
+// reporting being compared by its number of sessions
+var UPFLoadReportValidity = 5 * time.Minute
+
+// UPFLoadMetrics is the load of a UPF reported in a PFCP Node Report
+type UPFLoadMetrics struct {
+	CPULoad      uint8     `json:"cpuLoad"`    // percent
+	MemoryLoad   uint8     `json:"memoryLoad"` // percent
+	SessionCount uint32    `json:"sessionCount"`
+	ReportTime   time.Time `json:"reportTime"`
+}
+
+// SetLoadMetrics records the load reported by the UPF
+func (upf *UPF) SetLoadMetrics(load UPFLoadMetrics) {
+	upf.loadMetricsLock.Lock()
+	defer upf.loadMetricsLock.Unlock()
+	upf.loadMetrics = load
+}
+
+// LoadMetrics returns the last load reported by the UPF, zero if none
+func (upf *UPF) LoadMetrics() UPFLoadMetrics {
+	upf.loadMetricsLock.RLock()
+	defer upf.loadMetricsLock.RUnlock()
+	return upf.loadMetrics
+}
+
+// CPULoad returns the CPU load last reported by the UPF, 0 if none was reported during the
+// validity of the reports
+func (upf *UPF) CPULoad(now time.Time) uint8 {
+	if !upf.hasLoadReport(now) {
+		return 0
+	}
+	return upf.LoadMetrics().CPULoad
+}
+
+// hasLoadReport is true when the UPF reported its load during the validity of the reports
+func (upf *UPF) hasLoadReport(now time.Time) bool {
+	reportTime := upf.LoadMetrics().ReportTime
+	return !reportTime.IsZero() && now.Sub(reportTime) <= UPFLoadReportValidity
+}
+
+// SessionCount returns the number of sessions with a PFCP session context on the UPF
+func (upf *UPF) SessionCount() int64 {
+	return upf.activeSessions.Load()
+}
+
+func (upf *UPF) addActiveSession(delta int64) {
+	count := upf.activeSessions.Add(delta)
+	metrics.SetUPFActiveSessions(upf.NodeID.String(), count)
+}
+
+// releaseActiveSession removes the session from the active sessions of its UPF, once
+func (pfcpSessionContext *PFCPSessionContext) releaseActiveSession() {
+	if pfcpSessionContext.upf == nil {
+		return
+	}
+	pfcpSessionContext.upf.addActiveSession(-1)
+	pfcpSessionContext.upf = nil
+}
+
+// compareLoad orders the UPFs by load, the least loaded first: by the CPU load they reported when
+// both did, then by their number of sessions, the only load known of a UPF without load report
+func compareLoad(a, b *UPNode, now time.Time) int {
+	if a.UPF == nil || b.UPF == nil {
+		return 0
+	}
+	if a.UPF.hasLoadReport(now) && b.UPF.hasLoadReport(now) {
+		if load := cmp.Compare(a.UPF.CPULoad(now), b.UPF.CPULoad(now)); load != 0 {
+			return load
+		}
+	}
+	return cmp.Compare(a.UPF.SessionCount(), b.UPF.SessionCount())
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDefaultUserPlanePathByDNNLeastSessions(t *testing.T) {
+	snssai := &models.Snssai{Sst: 1, Sd: "010203"}
+	upi := contexttest.NewUserPlane(t, "192.168.190.100", map[string]factory.UPNode{
+		"UPF-1": contexttest.UPF("192.168.190.1", snssai),
+		"UPF-2": contexttest.UPF("192.168.190.2", snssai),
+	})
+	upf1, upf2 := upi.UPFs["UPF-1"], upi.UPFs["UPF-2"]
+
+	selection := &context.UPFSelectionParams{SNssai: &context.SNssai{Sst: 1, Sd: "010203"}, Dnn: "internet"}
+	anchor := func() *context.UPNode {
+		path := upi.GetDefaultUserPlanePathByDNN(selection)
+		require.NotEmpty(t, path)
+		return path[len(path)-1]
+	}
+	establish := func(supi string, upNode *context.UPNode) *context.SMContext {
+		smContext := newGnbSMContext(t, supi, nil)
+		smContext.AllocateLocalSEIDForDataPath(&context.DataPath{FirstDPNode: &context.DataPathNode{UPF: upNode.UPF}})
+		return smContext
+	}
+
+	require.Same(t, upf1, anchor())
+
+	// the UPF with fewer sessions is preferred
+	first := establish("imsi-208930000000201", upf1)
+	require.Equal(t, int64(1), upf1.UPF.SessionCount())
+	require.Same(t, upf2, anchor(), "sessions move to the UPF without session")
+
+	second := establish("imsi-208930000000202", upf2)
+	t.Cleanup(func() { context.RemoveSMContext(second.Ref) })
+	third := establish("imsi-208930000000203", upf2)
+	t.Cleanup(func() { context.RemoveSMContext(third.Ref) })
+	require.Equal(t, int64(2), upf2.UPF.SessionCount())
+	require.Same(t, upf1, anchor(), "sessions move back to the UPF with fewer sessions")
+
+	// the released session is no longer counted
+	context.RemoveSMContext(first.Ref)
+	require.Equal(t, int64(0), upf1.UPF.SessionCount())
+	require.Equal(t, int64(2), upf2.UPF.SessionCount())
+
+	// a UPF without load report is compared by its number of sessions
+	upf1.UPF.SetLoadMetrics(context.UPFLoadMetrics{CPULoad: 80, ReportTime: time.Now()})
+	require.Same(t, upf1, anchor(), "sessions on the UPF with fewer sessions")
+
+	// the CPU load reported by the UPFs comes before their number of sessions
+	upf2.UPF.SetLoadMetrics(context.UPFLoadMetrics{CPULoad: 10, ReportTime: time.Now()})
+	require.Same(t, upf2, anchor(), "sessions on the UPF of lower CPU load")
+}
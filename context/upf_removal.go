@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+)
+
+// ReleaseUPFAssociation, when set, sends the PFCP Association Release Request to the UPF removed
+// from the configuration
+var ReleaseUPFAssociation func(nodeID NodeID, port uint16) error
+
+// releaseRemovedUPF tears down the UPF removed from the configuration, before it is deleted from
+// the user plane. Its PFCP association is released, the UPF deleting all the PFCP sessions of the
+// association, and the sessions with a PFCP session on it are released by the network, the UE
+// being asked to re-establish them. Returns the released sessions.
+func (upi *UserPlaneInformation) releaseRemovedUPF(name string, node *factory.UPNode) []*SMContext {
+	upNode := upi.UPNodes[name]
+	if upNode == nil {
+		upNode = upi.UPNodes[node.NodeID]
+		name = node.NodeID
+	}
+	if upNode == nil || upNode.Type != UPNODE_UPF || upNode.UPF == nil {
+		return nil
+	}
+	upf := upNode.UPF
+
+	upf.UpfLock.Lock()
+	if upf.UPFStatus == AssociatedSetUpSuccess && ReleaseUPFAssociation != nil {
+		if err := ReleaseUPFAssociation(upf.NodeID, upf.Port); err != nil {
+			logger.UPNodeLog.Errorf("release PFCP association of UPF[%s] failed: %v", name, err)
+			upf.RecordError(err)
+		}
+	}
+	upf.UPFStatus = NotAssociated
+	upf.UpfLock.Unlock()
+
+	smContexts := GetSMContextsByUPF(upf.NodeID)
+	upfIP := upf.NodeID.ResolveNodeIdToIp().String()
+	for _, smContext := range smContexts {
+		// the PFCP session went with the association, no deletion is sent to the removed UPF
+		smContext.SMLock.Lock()
+		delete(smContext.PFCPContext, upfIP)
+		smContext.SMLock.Unlock()
+	}
+	ReleaseSessions(smContexts, nasMessage.Cause5GSMReactivationRequested,
+		fmt.Sprintf("UPF[%s] removed from the configuration", name))
+	logger.UPNodeLog.Infof("UPF[%s] removed from the configuration, %d sessions released", name, len(smContexts))
+	return smContexts
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateSmfContextRemovesUPF(t *testing.T) {
+	origReleaseUPFAssociation := context.ReleaseUPFAssociation
+	t.Cleanup(func() { context.ReleaseUPFAssociation = origReleaseUPFAssociation })
+
+	// sessions on each UPF
+	onUpf1 := newGnbSMContext(t, "imsi-208930000000101", nil)
+	onUpf1.PFCPContext["10.0.1.1"] = &context.PFCPSessionContext{NodeID: *context.NewNodeID("10.0.1.1")}
+	t.Cleanup(func() { context.RemoveSMContext(onUpf1.Ref) })
+	onUpf2 := newGnbSMContext(t, "imsi-208930000000102", nil)
+	onUpf2.PFCPContext["10.0.1.2"] = &context.PFCPSessionContext{NodeID: *context.NewNodeID("10.0.1.2")}
+
+	contexttest.SetUserPlane(t, context.NewUserPlaneInformation(&factory.UserPlaneInformation{}))
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+
+	releasedSessions := stubSessionRelease(t)
+	released := make([]string, 0)
+	context.ReleaseUPFAssociation = func(nodeID context.NodeID, port uint16) error {
+		released = append(released, nodeID.ResolveNodeIdToIp().String())
+		return nil
+	}
+
+	// one UPF per slice, then a single UPF for all the slices
+	multiSlice := &factory.Configuration{
+		UserPlaneInformation: factory.UserPlaneInformation{
+			UPNodes: map[string]factory.UPNode{
+				"gnb":   {Type: "AN", ANIP: "10.0.0.1"},
+				"upf-1": {Type: "UPF", NodeID: "10.0.1.1", Port: 8805},
+				"upf-2": {Type: "UPF", NodeID: "10.0.1.2", Port: 8805},
+			},
+			Links: []factory.UPLink{{A: "gnb", B: "upf-1"}, {A: "gnb", B: "upf-2"}},
+		},
+	}
+	singleSlice := &factory.Configuration{
+		UserPlaneInformation: factory.UserPlaneInformation{
+			UPNodes: map[string]factory.UPNode{
+				"gnb":   {Type: "AN", ANIP: "10.0.0.1"},
+				"upf-1": {Type: "UPF", NodeID: "10.0.1.1", Port: 8805},
+			},
+			Links: []factory.UPLink{{A: "gnb", B: "upf-1"}},
+		},
+	}
+
+	_, err := context.UpdateSmfContext(multiSlice)
+	require.NoError(t, err)
+	upi := context.GetUserPlaneInformation()
+	require.Contains(t, upi.UPFs, "upf-2")
+	upf2 := upi.UPFs["upf-2"].UPF
+	upf2.UPFStatus = context.AssociatedSetUpSuccess
+	upi.UPFs["upf-1"].UPF.UPFStatus = context.AssociatedSetUpSuccess
+
+	_, err = context.UpdateSmfContext(singleSlice)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"10.0.1.2"}, released)
+	require.Equal(t, context.NotAssociated, upf2.UPFStatus)
+	require.NotContains(t, upi.UPFs, "upf-2")
+	require.NotContains(t, upi.UPNodes, "upf-2")
+	require.Nil(t, context.RetrieveUPFNodeByNodeID(*context.NewNodeID("10.0.1.2")))
+	require.Contains(t, upi.UPFs, "upf-1")
+
+	// the sessions of the removed UPF are released by the network, the others are kept
+	require.Equal(t, nasMessage.Cause5GSMReactivationRequested, <-releasedSessions)
+	require.Nil(t, context.GetSMContext(onUpf2.Ref))
+	require.NotContains(t, onUpf2.PFCPContext, "10.0.1.2")
+	require.False(t, onUpf2.LocalPurged)
+	require.NotNil(t, context.GetSMContext(onUpf1.Ref))
+	require.Len(t, releasedSessions, 0)
+}
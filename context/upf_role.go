@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/omec-project/smf/logger"
+)
+
+// UPFRole is the role of a UPF for a DNN in active-standby deployments. A backup UPF is only
+// selected when no primary UPF of the DNN is available.
+type UPFRole string
+
+const (
+	UPFRolePrimary UPFRole = "primary"
+	UPFRoleBackup  UPFRole = "backup"
+)
+
+func parseUPFRole(upfName, dnn string, roles map[string]string) UPFRole {
+	switch role := UPFRole(roles[dnn]); role {
+	case "", UPFRolePrimary:
+		return UPFRolePrimary
+	case UPFRoleBackup:
+		return UPFRoleBackup
+	default:
+		logger.UPNodeLog.Warnf("UPF[%s] has unknown role [%s] for DNN[%s], using primary", upfName, role, dnn)
+		return UPFRolePrimary
+	}
+}
+
+// servedDnnInfo returns the DNN information of the UPF matching the selection, nil if the UPF
+// does not serve it
+func (upNode *UPNode) servedDnnInfo(selection *UPFSelectionParams) *DnnUPFInfoItem {
+	if upNode.UPF == nil {
+		return nil
+	}
+	for i := range upNode.UPF.SNssaiInfos {
+		snssaiInfo := &upNode.UPF.SNssaiInfos[i]
+		if !snssaiInfo.SNssai.Serves(selection.SNssai) {
+			continue
+		}
+		for j := range snssaiInfo.DnnList {
+			dnnInfo := &snssaiInfo.DnnList[j]
+			if dnnInfo.Dnn == selection.Dnn && dnnInfo.ContainsDNAI(selection.Dnai) {
+				return dnnInfo
+			}
+		}
+	}
+	return nil
+}
+
+// slicePriority returns the highest priority of the slices of the UPF serving the selection,
+// the UPFs of a selection without SD being ordered by the priority of their slices
+func (upNode *UPNode) slicePriority(selection *UPFSelectionParams) int32 {
+	var priority int32
+	served := false
+	for i := range upNode.UPF.SNssaiInfos {
+		snssaiInfo := &upNode.UPF.SNssaiInfos[i]
+		if !snssaiInfo.SNssai.Serves(selection.SNssai) ||
+			!slices.ContainsFunc(snssaiInfo.DnnList, func(dnnInfo DnnUPFInfoItem) bool { return dnnInfo.Dnn == selection.Dnn }) {
+			continue
+		}
+		if p := SlicePriority(snssaiInfo.SNssai); !served || p > priority {
+			priority, served = p, true
+		}
+	}
+	return priority
+}
+
+// roleRank orders the UPFs serving the selection by role, the lowest rank is preferred:
+// associated primary, associated backup, then not associated primary and backup
+func (upNode *UPNode) roleRank(selection *UPFSelectionParams) int {
+	rank := 0
+	if dnnInfo := upNode.servedDnnInfo(selection); dnnInfo != nil && dnnInfo.Role == UPFRoleBackup {
+		rank = 1
+	}
+	if upNode.UPF == nil || upNode.UPF.UPFStatus != AssociatedSetUpSuccess {
+		rank += 2
+	}
+	return rank
+}
+
+// compareSelection orders two UPFs serving the selection, the preferred first: by role, then
+// within a role the UPFs mapped to the tracking area of the UE, then the UPFs without a
+// congestion predicted by the NWDAF
+func compareSelection(a, b *UPNode, selection *UPFSelectionParams, taiAnchors map[*UPNode]bool, now time.Time) int {
+	if role := cmp.Compare(a.roleRank(selection), b.roleRank(selection)); role != 0 {
+		return role
+	}
+	if taiAnchors[a] != taiAnchors[b] {
+		if taiAnchors[a] {
+			return -1
+		}
+		return 1
+	}
+	congestedA := a.UPF != nil && a.UPF.IsCongested(now)
+	congestedB := b.UPF != nil && b.UPF.IsCongested(now)
+	if congestedA != congestedB {
+		if congestedB {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// isPreferredPath returns false when the anchor UPF of the path is ranked after the preferred UPF
+// of the selection, as a backup UPF once the primary is back or a UPF outside the tracking area of
+// the UE while a UPF of the area with the same role is available, is not the first available UPF
+// of the selection chain, or is more loaded than a UPF of the same rank
+func (upi *UserPlaneInformation) isPreferredPath(path UPPath, selection *UPFSelectionParams) bool {
+	if len(path) == 0 {
+		return false
+	}
+	candidates := upi.selectMatchUPF(selection)
+	anchor := path[len(path)-1]
+	if len(UPFSelectionChain(selection)) > 0 {
+		return len(candidates) > 0 && anchor == candidates[0]
+	}
+	if len(candidates) == 0 {
+		return true
+	}
+	now := time.Now()
+	if rank := compareSelection(anchor, candidates[0], selection, upi.taiAnchors(selection), now); rank != 0 {
+		return rank < 0
+	}
+	return compareLoad(anchor, candidates[0], now) <= 0
+}
+
+// HasAssociatedUPF returns true if one of the UPFs the selection may be anchored on is associated
+func (upi *UserPlaneInformation) HasAssociatedUPF(selection *UPFSelectionParams) bool {
+	for _, upNode := range upi.selectMatchUPF(selection) {
+		if upNode.UPF.UPFStatus == AssociatedSetUpSuccess {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/smferrors"
+)
+
+// UPFSelectionChain returns the names of the UPFs configured to anchor the sessions of the
+// selection, in order of preference. Returns nil if the anchor is selected among all the UPFs.
+func UPFSelectionChain(selection *UPFSelectionParams) []string {
+	if selection.SNssai == nil {
+		return nil
+	}
+	dnnInfo := RetrieveDnnInformation(models.Snssai{
+		Sst: selection.SNssai.Sst,
+		Sd:  selection.SNssai.Sd,
+	}, selection.Dnn)
+	if dnnInfo == nil {
+		return nil
+	}
+	return dnnInfo.UPFSelectionChain
+}
+
+// selectChainUPFs returns the UPFs of the chain serving the selection and associated with the
+// SMF, in the order of the chain, the UPFs with a congestion predicted by the NWDAF last
+func (upi *UserPlaneInformation) selectChainUPFs(selection *UPFSelectionParams, chain []string) []*UPNode {
+	upList := make([]*UPNode, 0, len(chain))
+	for _, name := range chain {
+		upNode, ok := upi.UPFs[name]
+		switch {
+		case !ok:
+			logger.UPNodeLog.Warnf("UPF[%s] of the selection chain of %s is not configured", name, selection.String())
+		case upNode.servedDnnInfo(selection) == nil:
+			logger.UPNodeLog.Warnf("UPF[%s] of the selection chain does not serve %s", name, selection.String())
+		case upNode.UPF.UPFStatus != AssociatedSetUpSuccess:
+			logger.UPNodeLog.Debugf("UPF[%s] of the selection chain of %s is unavailable", name, selection.String())
+		default:
+			upList = append(upList, upNode)
+		}
+	}
+	now := time.Now()
+	slices.SortStableFunc(upList, func(a, b *UPNode) int {
+		switch congestedA, congestedB := a.UPF.IsCongested(now), b.UPF.IsCongested(now); {
+		case congestedA == congestedB:
+			return 0
+		case congestedB:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return upList
+}
+
+// SelectAnchorUPF returns the first UPF of the chain serving the selection and associated with
+// the SMF, skipping the unavailable ones, the UPFs mapped to the tracking area of the selection
+// first. It fails once every UPF of the chain is exhausted.
+func (upi *UserPlaneInformation) SelectAnchorUPF(selection *UPFSelectionParams, chain []string) (*UPNode, error) {
+	upList := upi.preferTAIAnchors(selection, upi.selectChainUPFs(selection, chain))
+	if len(upList) == 0 {
+		return nil, smferrors.New(smferrors.ErrCodeUPFNotFound,
+			fmt.Sprintf("no UPF of the selection chain %v available for %s", chain, selection.String()))
+	}
+	return upList[0], nil
+}
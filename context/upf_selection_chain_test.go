@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func newSelectionChainUPI(t *testing.T) *context.UserPlaneInformation {
+	snssai := &models.Snssai{Sst: 1, Sd: "010204"}
+	return contexttest.NewUserPlane(t, "192.168.181.100", map[string]factory.UPNode{
+		"UPF-A": contexttest.UPF("192.168.181.1", snssai),
+		"UPF-B": contexttest.UPF("192.168.181.2", snssai),
+		"UPF-C": contexttest.UPF("192.168.181.3", snssai),
+	})
+}
+
+func TestSelectAnchorUPF(t *testing.T) {
+	upi := newSelectionChainUPI(t)
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{
+			Sst: 1,
+			Sd:  "010204",
+		},
+		Dnn: "internet",
+	}
+	chain := []string{"UPF-C", "UPF-A"}
+
+	anchor, err := upi.SelectAnchorUPF(selection, chain)
+	require.NoError(t, err)
+	require.Same(t, upi.UPFs["UPF-C"], anchor, "first UPF of the chain is preferred")
+
+	upi.UPFs["UPF-C"].UPF.UPFStatus = context.NotAssociated
+	anchor, err = upi.SelectAnchorUPF(selection, chain)
+	require.NoError(t, err)
+	require.Same(t, upi.UPFs["UPF-A"], anchor, "next UPF of the chain is used once the first is unavailable")
+
+	upi.UPFs["UPF-A"].UPF.UPFStatus = context.NotAssociated
+	_, err = upi.SelectAnchorUPF(selection, chain)
+	require.Error(t, err, "UPF-B is available but not in the chain")
+}
+
+func TestGetDefaultUserPlanePathByDNNSelectionChain(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	snssaiInfos := smfSelf.SnssaiInfos
+	t.Cleanup(func() {
+		smfSelf.SnssaiInfos = snssaiInfos
+	})
+	smfSelf.SnssaiInfos = []context.SnssaiSmfInfo{
+		{
+			Snssai: context.SNssai{
+				Sst: 1,
+				Sd:  "010204",
+			},
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{
+				"internet": {UPFSelectionChain: []string{"UPF-B", "UPF-C"}},
+			},
+		},
+	}
+
+	upi := newSelectionChainUPI(t)
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{
+			Sst: 1,
+			Sd:  "010204",
+		},
+		Dnn: "internet",
+	}
+
+	path := upi.GetDefaultUserPlanePathByDNN(selection)
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-B"], path[len(path)-1])
+
+	upi.UPFs["UPF-B"].UPF.UPFStatus = context.NotAssociated
+	path = upi.GetDefaultUserPlanePathByDNN(selection)
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-C"], path[len(path)-1])
+
+	upi.UPFs["UPF-C"].UPF.UPFStatus = context.NotAssociated
+	require.Nil(t, upi.GetDefaultUserPlanePathByDNN(selection), "no path once the chain is exhausted")
+}
+
+func TestHasAssociatedUPF(t *testing.T) {
+	upi := newSelectionChainUPI(t)
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{
+			Sst: 1,
+			Sd:  "010204",
+		},
+		Dnn: "internet",
+	}
+
+	require.True(t, upi.HasAssociatedUPF(selection))
+
+	upi.UPFs["UPF-A"].UPF.UPFStatus = context.NotAssociated
+	upi.UPFs["UPF-B"].UPF.UPFStatus = context.AssociatedSettingUp
+	require.True(t, upi.HasAssociatedUPF(selection), "UPF-C is associated")
+
+	upi.UPFs["UPF-C"].UPF.UPFStatus = context.NotAssociated
+	require.False(t, upi.HasAssociatedUPF(selection))
+
+	upi.UPFs["UPF-A"].UPF.UPFStatus = context.AssociatedSetUpSuccess
+	require.False(t, upi.HasAssociatedUPF(&context.UPFSelectionParams{
+		SNssai: &context.SNssai{Sst: 1, Sd: "010204"},
+		Dnn:    "ims",
+	}), "no UPF serves the DNN")
+}
+
+func TestSelectAnchorUPFCongested(t *testing.T) {
+	upi := newSelectionChainUPI(t)
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{
+			Sst: 1,
+			Sd:  "010204",
+		},
+		Dnn: "internet",
+	}
+	chain := []string{"UPF-C", "UPF-A"}
+
+	upi.UPFs["UPF-C"].UPF.SetCongestedUntil(time.Now().Add(time.Minute))
+	anchor, err := upi.SelectAnchorUPF(selection, chain)
+	require.NoError(t, err)
+	require.Same(t, upi.UPFs["UPF-A"], anchor, "UPF predicted congested selected last")
+
+	// every UPF of the chain congested, the chain order applies
+	upi.UPFs["UPF-A"].UPF.SetCongestedUntil(time.Now().Add(time.Minute))
+	anchor, err = upi.SelectAnchorUPF(selection, chain)
+	require.NoError(t, err)
+	require.Same(t, upi.UPFs["UPF-C"], anchor)
+
+	// end of the prediction
+	upi.UPFs["UPF-A"].UPF.ClearCongestion()
+	upi.UPFs["UPF-C"].UPF.SetCongestedUntil(time.Now().Add(-time.Second))
+	anchor, err = upi.SelectAnchorUPF(selection, chain)
+	require.NoError(t, err)
+	require.Same(t, upi.UPFs["UPF-C"], anchor)
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/omec-project/openapi/models"
+)
+
+// TAIKey returns the key of the tracking area in TAIToUPFs
+func TAIKey(tai *models.Tai) string {
+	if tai.PlmnId == nil {
+		return strings.ToLower(tai.Tac)
+	}
+	return fmt.Sprintf("%s-%s-%s", tai.PlmnId.Mcc, tai.PlmnId.Mnc, strings.ToLower(tai.Tac))
+}
+
+// ServingTai returns the tracking area of the UE reported by the AMF, nil if unknown
+func (smContext *SMContext) ServingTai() *models.Tai {
+	location := smContext.UeLocation
+	switch {
+	case location == nil:
+		return nil
+	case location.NrLocation != nil:
+		return location.NrLocation.Tai
+	case location.EutraLocation != nil:
+		return location.EutraLocation.Tai
+	}
+	return nil
+}
+
+// setUPFTais maps the tracking areas to the UPF, replacing its previous ones
+func (upi *UserPlaneInformation) setUPFTais(name string, tais []models.Tai) {
+	upi.releaseUPFTais(name)
+	for i := range tais {
+		key := TAIKey(&tais[i])
+		if !slices.Contains(upi.TAIToUPFs[key], name) {
+			upi.TAIToUPFs[key] = append(upi.TAIToUPFs[key], name)
+			slices.Sort(upi.TAIToUPFs[key])
+		}
+	}
+}
+
+// releaseUPFTais removes the UPF from the tracking areas it is mapped to
+func (upi *UserPlaneInformation) releaseUPFTais(name string) {
+	for key, names := range upi.TAIToUPFs {
+		names = slices.DeleteFunc(names, func(n string) bool { return n == name })
+		if len(names) == 0 {
+			delete(upi.TAIToUPFs, key)
+		} else {
+			upi.TAIToUPFs[key] = names
+		}
+	}
+}
+
+// taiAnchors returns the associated UPFs mapped to the tracking area of the selection
+func (upi *UserPlaneInformation) taiAnchors(selection *UPFSelectionParams) map[*UPNode]bool {
+	if selection.Tai == nil {
+		return nil
+	}
+	anchors := make(map[*UPNode]bool)
+	for _, name := range upi.TAIToUPFs[TAIKey(selection.Tai)] {
+		if upNode := upi.UPFs[name]; upNode != nil && upNode.UPF != nil &&
+			upNode.UPF.UPFStatus == AssociatedSetUpSuccess {
+			anchors[upNode] = true
+		}
+	}
+	return anchors
+}
+
+// preferTAIAnchors moves the associated UPFs mapped to the tracking area of the selection ahead
+// of the other candidates, keeping their order. The candidates are unchanged when no UPF is
+// mapped to the tracking area.
+func (upi *UserPlaneInformation) preferTAIAnchors(selection *UPFSelectionParams, candidates []*UPNode) []*UPNode {
+	anchors := upi.taiAnchors(selection)
+	if len(anchors) == 0 {
+		return candidates
+	}
+	slices.SortStableFunc(candidates, func(a, b *UPNode) int {
+		switch {
+		case anchors[a] == anchors[b]:
+			return 0
+		case anchors[a]:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return candidates
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func newTAIUPI(t *testing.T) *context.UserPlaneInformation {
+	edgeUPF := func(nodeID, tac string) factory.UPNode {
+		upf := contexttest.UPF(nodeID, &models.Snssai{Sst: 1, Sd: "010203"})
+		upf.Tais = []models.Tai{{PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: tac}}
+		return upf
+	}
+	return contexttest.NewUserPlane(t, "192.168.182.100", map[string]factory.UPNode{
+		"UPF-EDGE-1": edgeUPF("192.168.182.1", "000001"),
+		"UPF-EDGE-2": edgeUPF("192.168.182.2", "000002"),
+	})
+}
+
+func taiSelection(tac string) *context.UPFSelectionParams {
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{
+			Sst: 1,
+			Sd:  "010203",
+		},
+		Dnn: "internet",
+	}
+	if tac != "" {
+		selection.Tai = &models.Tai{PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: tac}
+	}
+	return selection
+}
+
+func TestGetDefaultUserPlanePathByDNNTai(t *testing.T) {
+	upi := newTAIUPI(t)
+	require.Equal(t, map[string][]string{
+		"208-93-000001": {"UPF-EDGE-1"},
+		"208-93-000002": {"UPF-EDGE-2"},
+	}, upi.TAIToUPFs)
+
+	path := upi.GetDefaultUserPlanePathByDNN(taiSelection("000001"))
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-EDGE-1"], path[len(path)-1])
+
+	path = upi.GetDefaultUserPlanePathByDNN(taiSelection("000002"))
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-EDGE-2"], path[len(path)-1])
+
+	// no UPF mapped to the tracking area, or no tracking area: DNN and slice candidates
+	require.NotEmpty(t, upi.GetDefaultUserPlanePathByDNN(taiSelection("000003")))
+	require.NotEmpty(t, upi.GetDefaultUserPlanePathByDNN(taiSelection("")))
+
+	// the UPF of the tracking area is unavailable, the other one serves the DNN
+	upi.UPFs["UPF-EDGE-2"].UPF.UPFStatus = context.NotAssociated
+	path = upi.GetDefaultUserPlanePathByDNN(taiSelection("000002"))
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-EDGE-1"], path[len(path)-1])
+
+	// and the path moves back once it is available again
+	upi.UPFs["UPF-EDGE-2"].UPF.UPFStatus = context.AssociatedSetUpSuccess
+	path = upi.GetDefaultUserPlanePathByDNN(taiSelection("000002"))
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-EDGE-2"], path[len(path)-1])
+}
+
+func TestGetDefaultUserPlanePathByDNNTaiWithinRole(t *testing.T) {
+	snssai := &models.Snssai{Sst: 1, Sd: "010203"}
+	backup := contexttest.UPF("192.168.183.1", snssai)
+	backup.Tais = []models.Tai{{PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: "000001"}}
+	backup.DnnRoles = map[string]string{"internet": "backup"}
+	upi := contexttest.NewUserPlane(t, "192.168.183.100", map[string]factory.UPNode{
+		"UPF-BACKUP":  backup,
+		"UPF-PRIMARY": contexttest.UPF("192.168.183.2", snssai),
+	})
+
+	// the backup UPF of the tracking area is not preferred to the primary UPF
+	path := upi.GetDefaultUserPlanePathByDNN(taiSelection("000001"))
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-PRIMARY"], path[len(path)-1])
+
+	// it is once the primary UPF failed
+	upi.UPFs["UPF-PRIMARY"].UPF.UPFStatus = context.NotAssociated
+	path = upi.GetDefaultUserPlanePathByDNN(taiSelection("000001"))
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-BACKUP"], path[len(path)-1])
+
+	// and the path moves back to the primary UPF once it is available again
+	upi.UPFs["UPF-PRIMARY"].UPF.UPFStatus = context.AssociatedSetUpSuccess
+	path = upi.GetDefaultUserPlanePathByDNN(taiSelection("000001"))
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["UPF-PRIMARY"], path[len(path)-1])
+}
+
+func TestUPFTaisUpdateAndDelete(t *testing.T) {
+	upi := newTAIUPI(t)
+
+	node := factory.UPNode{
+		Type:   "UPF",
+		NodeID: "192.168.182.2",
+		Tais:   []models.Tai{{PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: "000001"}},
+	}
+	require.NoError(t, upi.UpdateSmfUserPlaneNode("UPF-EDGE-2", &node))
+	require.Equal(t, map[string][]string{
+		"208-93-000001": {"UPF-EDGE-1", "UPF-EDGE-2"},
+	}, upi.TAIToUPFs)
+
+	require.NoError(t, upi.DeleteSmfUserPlaneNode("UPF-EDGE-1", &factory.UPNode{NodeID: "192.168.182.1"}))
+	require.Equal(t, map[string][]string{
+		"208-93-000001": {"UPF-EDGE-2"},
+	}, upi.TAIToUPFs)
+}
+
+func TestServingTai(t *testing.T) {
+	tai := &models.Tai{Tac: "000001"}
+	require.Nil(t, (&context.SMContext{}).ServingTai())
+	require.Same(t, tai, (&context.SMContext{
+		UeLocation: &models.UserLocation{NrLocation: &models.NrLocation{Tai: tai}},
+	}).ServingTai())
+	require.Same(t, tai, (&context.SMContext{
+		UeLocation: &models.UserLocation{EutraLocation: &models.EutraLocation{Tai: tai}},
+	}).ServingTai())
+}
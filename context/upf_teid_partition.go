@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/util/idgenerator"
+)
+
+// setTEIDPartition makes the SMF allocate the TEIDs of the UPF in the partition, the UPF choosing
+// them if none is configured or it is invalid. The TEIDs in use are kept if the partition is
+// unchanged.
+func (upf *UPF) setTEIDPartition(name string, partition *factory.TEIDPartition) {
+	if partition != nil && upf.teidGenerator != nil && *partition == upf.teidPartition {
+		return
+	}
+	upf.teidGenerator = nil
+	upf.teidPartition = factory.TEIDPartition{}
+	if partition == nil {
+		return
+	}
+	if err := partition.Validate(); err != nil {
+		logger.InitLog.Errorf("invalid TEID partition of UPF [%s], TEIDs chosen by the UPF: %v", name, err)
+		return
+	}
+	upf.teidPartition = *partition
+	upf.teidGenerator = idgenerator.NewGenerator(int64(partition.Base), int64(partition.Base)+int64(partition.Size)-1)
+	logger.InitLog.Infof("TEIDs of UPF [%s] allocated in [%d-%d]", name,
+		partition.Base, uint64(partition.Base)+uint64(partition.Size)-1)
+}
+
+// HasTEIDPartition returns true if the SMF allocates the TEIDs of the UPF
+func (upf *UPF) HasTEIDPartition() bool {
+	return upf != nil && upf.teidGenerator != nil
+}
+
+// AllocateTEID returns a TEID of the partition of the UPF not in use
+func (upf *UPF) AllocateTEID() (uint32, error) {
+	if !upf.HasTEIDPartition() {
+		return 0, fmt.Errorf("no TEID partition for UPF[%s]", upf.NodeID.ResolveNodeIdToIp())
+	}
+	teid, err := upf.teidGenerator.Allocate()
+	if err != nil {
+		return 0, fmt.Errorf("TEID partition of UPF[%s]: %w (%v)", upf.NodeID.ResolveNodeIdToIp(), ErrTEIDPoolExhausted, err)
+	}
+	return uint32(teid), nil
+}
+
+// ReleaseTEID returns the TEID to the partition of the UPF
+func (upf *UPF) ReleaseTEID(teid uint32) {
+	if !upf.HasTEIDPartition() || teid == 0 {
+		return
+	}
+	upf.teidGenerator.FreeID(int64(teid))
+}
+
+// uplinkLocalFTEID returns the local F-TEID of the uplink PDRs of the node, with the TEID of the
+// uplink tunnel allocated by the SMF in the partition of the UPF, or to be chosen by the UPF if
+// it has no partition
+func (node *DataPathNode) uplinkLocalFTEID(smContext *SMContext) (*FTEID, error) {
+	upf := node.UPF
+	if !upf.HasTEIDPartition() {
+		return &FTEID{Ch: true}, nil
+	}
+
+	interfaceType := models.UpInterfaceType_N9
+	if node.IsANUPF() {
+		interfaceType = models.UpInterfaceType_N3
+	}
+	iface := upf.GetInterface(interfaceType, smContext.Dnn)
+	if iface == nil {
+		return nil, fmt.Errorf("UPF[%s] without %s interface for DNN[%s]",
+			upf.NodeID.ResolveNodeIdToIp(), interfaceType, smContext.Dnn)
+	}
+	ip, err := iface.IP(smContext.SelectedPDUSessionType)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.UpLinkTunnel.TEID == 0 {
+		teid, err := upf.AllocateTEID()
+		if err != nil {
+			return nil, err
+		}
+		node.UpLinkTunnel.TEID = teid
+	}
+	fteid := &FTEID{Teid: node.UpLinkTunnel.TEID}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		fteid.V4 = true
+		fteid.Ipv4Address = ipv4
+	} else {
+		fteid.V6 = true
+		fteid.Ipv6Address = ip
+	}
+	return fteid, nil
+}
+
+// releaseUplinkTEID returns the TEID of the uplink tunnel of the node to the partition of its UPF
+func (node *DataPathNode) releaseUplinkTEID() {
+	if !node.UPF.HasTEIDPartition() || node.UpLinkTunnel == nil {
+		return
+	}
+	node.UPF.ReleaseTEID(node.UpLinkTunnel.TEID)
+	node.UpLinkTunnel.TEID = 0
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func newTEIDPartitionUPI(t *testing.T, partition *factory.TEIDPartition) *context.UserPlaneInformation {
+	t.Helper()
+	upf := contexttest.UPF("192.168.183.1", &models.Snssai{Sst: 1, Sd: "010203"})
+	upf.InterfaceUpfInfoList[0].Endpoints = []string{"10.1.3.1"}
+	upf.TEIDPartition = partition
+	return contexttest.NewUserPlane(t, "192.168.183.100", map[string]factory.UPNode{"UPF": upf})
+}
+
+func TestTEIDPartitionAllocation(t *testing.T) {
+	upf := newTEIDPartitionUPI(t, &factory.TEIDPartition{Base: 0xfffffffc, Size: 4}).UPFs["UPF"].UPF
+	require.True(t, upf.HasTEIDPartition())
+
+	allocated := make(map[uint32]bool)
+	for range 4 {
+		teid, err := upf.AllocateTEID()
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, teid, uint32(0xfffffffc))
+		require.False(t, allocated[teid])
+		allocated[teid] = true
+	}
+	// the partition is exhausted
+	_, err := upf.AllocateTEID()
+	require.ErrorIs(t, err, context.ErrTEIDPoolExhausted)
+
+	// until a TEID is released
+	upf.ReleaseTEID(0xfffffffd)
+	teid, err := upf.AllocateTEID()
+	require.NoError(t, err)
+	require.Equal(t, uint32(0xfffffffd), teid)
+}
+
+func TestTEIDPartitionNotConfigured(t *testing.T) {
+	upf := newTEIDPartitionUPI(t, nil).UPFs["UPF"].UPF
+	require.False(t, upf.HasTEIDPartition())
+	_, err := upf.AllocateTEID()
+	require.Error(t, err)
+
+	// an invalid partition leaves the UPF choosing the TEIDs
+	upf = newTEIDPartitionUPI(t, &factory.TEIDPartition{Base: 0xffffffff, Size: 2}).UPFs["UPF"].UPF
+	require.False(t, upf.HasTEIDPartition())
+}
+
+func TestTEIDPartitionUpdate(t *testing.T) {
+	upi := newTEIDPartitionUPI(t, &factory.TEIDPartition{Base: 100, Size: 10})
+	upf := upi.UPFs["UPF"].UPF
+	teid, err := upf.AllocateTEID()
+	require.NoError(t, err)
+	require.Equal(t, uint32(100), teid)
+
+	node := factory.UPNode{
+		Type:          "UPF",
+		NodeID:        "192.168.183.1",
+		TEIDPartition: &factory.TEIDPartition{Base: 1000, Size: 10},
+	}
+	require.NoError(t, upi.UpdateSmfUserPlaneNode("UPF", &node))
+	teid, err = upi.UPFs["UPF"].UPF.AllocateTEID()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1000), teid)
+}
+
+func TestActivateUpLinkPdrTEIDPartition(t *testing.T) {
+	upf := newTEIDPartitionUPI(t, &factory.TEIDPartition{Base: 0x10000, Size: 16}).UPFs["UPF"].UPF
+	smContext := &context.SMContext{
+		PDUAddress:             &context.UeIpAddr{Ip: net.IPv4(192, 168, 1, 1)},
+		Dnn:                    "internet",
+		SelectedPDUSessionType: nasMessage.PDUSessionTypeIPv4,
+	}
+	dpNode := &context.DataPathNode{
+		UPF: upf,
+		UpLinkTunnel: &context.GTPTunnel{
+			PDR: map[string]*context.PDR{
+				"default": {FAR: &context.FAR{}},
+				"web":     {FAR: &context.FAR{}},
+			},
+		},
+	}
+
+	require.NoError(t, dpNode.ActivateUpLinkPdr(smContext, &context.QER{}, 10))
+	teid := dpNode.UpLinkTunnel.TEID
+	require.GreaterOrEqual(t, teid, uint32(0x10000))
+	require.LessOrEqual(t, teid, uint32(0x1000f))
+	for _, pdr := range dpNode.UpLinkTunnel.PDR {
+		fteid := pdr.PDI.LocalFTeid
+		require.False(t, fteid.Ch)
+		require.True(t, fteid.V4)
+		require.Equal(t, teid, fteid.Teid)
+		require.True(t, net.IPv4(10, 1, 3, 1).Equal(fteid.Ipv4Address))
+	}
+}
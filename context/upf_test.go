@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package context_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCreatePdrFromPccRuleApplicationID(t *testing.T) {
+	pccRule := &models.PccRule{
+		PccRuleId:  "1",
+		Precedence: 100,
+		AppId:      "video-streaming",
+		FlowInfos: []models.FlowInformation{
+			{
+				FlowDescription: "permit out ip from any to assigned",
+				PackFiltId:      "1",
+				FlowDirection:   models.FlowDirectionRm_BIDIRECTIONAL,
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		features      *context.UPFunctionFeatures
+		expectedAppID string
+	}{
+		{
+			name:          "UPF without reported features",
+			features:      nil,
+			expectedAppID: "",
+		},
+		{
+			name:          "UPF without application detection",
+			features:      &context.UPFunctionFeatures{SupportedFeatures1: context.UpFunctionFeatures1Ueip},
+			expectedAppID: "",
+		},
+		{
+			name:          "UPF with ADC",
+			features:      &context.UPFunctionFeatures{SupportedFeatures: context.UpFunctionFeaturesPfdm},
+			expectedAppID: "video-streaming",
+		},
+		{
+			name:          "UPF with ATSSS",
+			features:      &context.UPFunctionFeatures{SupportedFeatures2: context.UpFunctionFeatures2AtsssLl},
+			expectedAppID: "video-streaming",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeID := context.NewNodeID("10.0.0.1")
+			upf := context.NewUPF(nodeID, nil)
+			defer context.RemoveUPFNodeByNodeID(*nodeID)
+			upf.UPFStatus = context.AssociatedSetUpSuccess
+			upf.UPFunctionFeatures = tc.features
+
+			pdr, err := upf.BuildCreatePdrFromPccRule(pccRule)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedAppID, pdr.PDI.ApplicationID)
+		})
+	}
+}
+
+func TestUPFNextPfcpSeqNumberOffsetWraps(t *testing.T) {
+	nodeID := context.NewNodeID("10.20.30.41")
+	upf := context.NewUPF(nodeID, nil)
+	defer context.RemoveUPFNodeByNodeID(*nodeID)
+
+	const seqMax = 1<<24 - 1
+	offset := uint32(seqMax - 8)
+	for i := range 16 {
+		seq := upf.NextPfcpSeqNumber(offset)
+		require.NotZero(t, seq)
+		require.LessOrEqual(t, seq, uint32(seqMax), "sequence number %d overflows 24 bits at call %d", seq, i)
+	}
+}
+
+func TestRemoveUPFResolvesPendingPfcpRequests(t *testing.T) {
+	nodeID := context.NewNodeID("10.20.30.42")
+	upf := context.NewUPF(nodeID, nil)
+	req := upf.InsertPendingPfcpRequest(upf.NextPfcpSeqNumber(0), nodeID)
+
+	require.True(t, context.RemoveUPFNodeByNodeID(*nodeID))
+	require.ErrorIs(t, req.Wait(), context.ErrPfcpRequestTimeout)
+	require.Zero(t, upf.PendingPfcpRequestCount())
+}
+
+func TestUPFPendingPfcpRequestsConcurrent(t *testing.T) {
+	nodeID := context.NewNodeID("10.20.30.40")
+	upf := context.NewUPF(nodeID, nil)
+	defer context.RemoveUPFNodeByNodeID(*nodeID)
+
+	const numRequests = 1000
+	seqs := make(chan uint32, numRequests)
+
+	var wg sync.WaitGroup
+	for range numRequests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seq := upf.NextPfcpSeqNumber(0)
+			upf.InsertPendingPfcpRequest(seq, nodeID)
+			seqs <- seq
+		}()
+	}
+	wg.Wait()
+	close(seqs)
+
+	require.Equal(t, numRequests, upf.PendingPfcpRequestCount())
+
+	seen := make(map[uint32]bool, numRequests)
+	responses := make([]uint32, 0, numRequests)
+	for seq := range seqs {
+		require.NotZero(t, seq)
+		require.False(t, seen[seq], "sequence number %d allocated twice", seq)
+		seen[seq] = true
+		responses = append(responses, seq)
+	}
+
+	var matched atomic.Int32
+	for _, seq := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if req := upf.FetchPendingPfcpRequest(seq); req != nil && req.NodeID == nodeID {
+				matched.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(numRequests), matched.Load())
+	require.Zero(t, upf.PendingPfcpRequestCount())
+	require.Nil(t, upf.FetchPendingPfcpRequest(responses[0]))
+}
+
+func TestUPFEvictExpiredPfcpRequests(t *testing.T) {
+	nodeID := context.NewNodeID("10.20.30.41")
+	upf := context.NewUPF(nodeID, nil)
+	defer context.RemoveUPFNodeByNodeID(*nodeID)
+
+	unanswered := upf.InsertPendingPfcpRequest(1, nodeID)
+	answered := upf.InsertPendingPfcpRequest(2, nodeID)
+	require.NotNil(t, upf.FetchPendingPfcpRequest(2))
+	require.NoError(t, answered.Wait())
+
+	// still within its retransmissions
+	require.Zero(t, upf.EvictExpiredPfcpRequests(time.Now(), time.Minute))
+	require.Equal(t, 1, upf.PendingPfcpRequestCount())
+
+	require.Equal(t, 1, upf.EvictExpiredPfcpRequests(time.Now().Add(2*time.Minute), time.Minute))
+	require.Zero(t, upf.PendingPfcpRequestCount())
+	require.ErrorIs(t, unanswered.Wait(), context.ErrPfcpRequestTimeout)
+	require.Nil(t, upf.FetchPendingPfcpRequest(1), "late response of an evicted request")
+}
@@ -3,9 +3,29 @@
 
 package context
 
+// Supported Feature
+// PFD management, required by the UPF to detect applications referred by Application ID
+const UpFunctionFeaturesPfdm uint16 = 1 << 5
+
+// Header enrichment of the uplink traffic
+const UpFunctionFeaturesHeeu uint16 = 1 << 6
+
+// Framed routing, required by the UPF to route the subnets behind the UEs
+const UpFunctionFeaturesFrrt uint16 = 1 << 13
+
 // Supported Feature-1
 const UpFunctionFeatures1Ueip uint16 = 1 << 2
 
+// MPTCP steering of the traffic of the MA PDU sessions
+const UpFunctionFeatures1Mptcp uint16 = 1 << 15
+
+// Supported Feature-2
+// ATSSS-LL steering of the traffic of the MA PDU sessions
+const UpFunctionFeatures2AtsssLl uint16 = 1 << 0
+
+// QoS flow QoS monitoring, required by the anchor UPF to measure the packet delay of the QoS flows
+const UpFunctionFeatures2Qfqm uint16 = 1 << 1
+
 type UPFunctionFeatures struct {
 	SupportedFeatures  uint16
 	SupportedFeatures1 uint16
@@ -7,9 +7,15 @@ package context
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
+	"maps"
+	"math"
 	"net"
 	"reflect"
+	"slices"
+	"sort"
+	"time"
 
 	"github.com/omec-project/smf/factory"
 	"github.com/omec-project/smf/logger"
@@ -21,9 +27,12 @@ type UserPlaneInformation struct {
 	UPFs                 map[string]*UPNode
 	AccessNetwork        map[string]*UPNode
 	UPFIPToName          map[string]string
+	UPFIPConflicts       map[string][]string  // ip->names of all UPFs resolving to it
 	UPFsID               map[string]string    // name to id
 	UPFsIPtoID           map[string]string    // ip->id table, for speed optimization
 	DefaultUserPlanePath map[string][]*UPNode // DNN to Default Path
+	TEIDPool             *TEIDPool            // gNB TEIDs in use
+	TAIToUPFs            map[string][]string  // TAI->names of the UPFs anchoring the sessions of its area
 }
 
 type UPNodeType string
@@ -67,9 +76,17 @@ func NewUserPlaneInformation(upTopology *factory.UserPlaneInformation) *UserPlan
 		UPFs:                 make(map[string]*UPNode),
 		AccessNetwork:        make(map[string]*UPNode),
 		UPFIPToName:          make(map[string]string),
+		UPFIPConflicts:       make(map[string][]string),
 		UPFsID:               make(map[string]string),
 		UPFsIPtoID:           make(map[string]string),
 		DefaultUserPlanePath: make(map[string][]*UPNode),
+		TAIToUPFs:            make(map[string][]string),
+	}
+
+	if teidRange := upTopology.TEIDRange; teidRange != nil {
+		userplaneInformation.TEIDPool = NewTEIDPool(teidRange.Min, teidRange.Max)
+	} else {
+		userplaneInformation.TEIDPool = NewTEIDPool(1, math.MaxUint32)
 	}
 
 	// Load UP Nodes to SMF
@@ -116,7 +133,19 @@ func (upi *UserPlaneInformation) GetDefaultUserPlanePathByDNN(selection *UPFSele
 	path, pathExist := upi.DefaultUserPlanePath[selection.String()]
 	logger.CtxLog.Debugln("in GetDefaultUserPlanePathByDNN")
 	logger.CtxLog.Debugln("selection:", selection.String())
-	if pathExist {
+	if pathExist && upi.isPreferredPath(path, selection) {
+		return
+	} else if pathExist {
+		// a UPF with a better role is available, keep the path only if none can be reached
+		logger.CtxLog.Infof("default path of selection %v no longer on the preferred UPF", selection.String())
+		if upi.GenerateDefaultPath(selection) {
+			return upi.DefaultUserPlanePath[selection.String()]
+		}
+		if len(UPFSelectionChain(selection)) > 0 {
+			// every UPF of the selection chain is unavailable
+			delete(upi.DefaultUserPlanePath, selection.String())
+			return nil
+		}
 		return
 	} else {
 		pathExist = upi.GenerateDefaultPath(selection)
@@ -173,7 +202,6 @@ func GenerateDataPath(upPath UPPath, smContext *SMContext) *DataPath {
 }
 
 func (upi *UserPlaneInformation) GenerateDefaultPath(selection *UPFSelectionParams) (pathExist bool) {
-	var source *UPNode
 	var destinations []*UPNode
 
 	for len(upi.AccessNetwork) == 0 {
@@ -192,53 +220,68 @@ func (upi *UserPlaneInformation) GenerateDefaultPath(selection *UPFSelectionPara
 			selection.SNssai.Sst, selection.SNssai.Sd, selection.Dnai)
 	}
 
-	// Run DFS
-	visited := make(map[*UPNode]bool)
-
-	for _, upNode := range upi.UPNodes {
-		visited[upNode] = false
+	// Run DFS, from the preferred UPF down to the backups
+	for _, destination := range destinations {
+		if path, exist := upi.pathToUPF(selection, destination); exist {
+			upi.DefaultUserPlanePath[selection.String()] = path
+			return true
+		}
 	}
 
+	return false
+}
+
+// pathToUPF returns the path from an AN node to the UPF, without the AN node
+func (upi *UserPlaneInformation) pathToUPF(selection *UPFSelectionParams, destination *UPNode) (UPPath, bool) {
 	for anName, node := range upi.AccessNetwork {
-		if node.Type == UPNODE_AN {
-			source = node
-			var path []*UPNode
-			path, pathExist = getPathBetween(source, destinations[0], visited, selection)
-
-			if pathExist {
-				if path[0].Type == UPNODE_AN {
-					path = path[1:]
-				}
-				upi.DefaultUserPlanePath[selection.String()] = path
-				break
-			} else {
-				logger.CtxLog.Debugf("no path between an-node[%v] and upf[%v]", anName, string(destinations[0].NodeID.NodeIdValue))
-				continue
+		if node.Type != UPNODE_AN {
+			continue
+		}
+		visited := make(map[*UPNode]bool)
+		for _, upNode := range upi.UPNodes {
+			visited[upNode] = false
+		}
+		path, pathExist := getPathBetween(node, destination, visited, selection)
+		if pathExist {
+			if path[0].Type == UPNODE_AN {
+				path = path[1:]
 			}
+			return path, true
 		}
+		logger.CtxLog.Debugf("no path between an-node[%v] and upf[%v]", anName, string(destination.NodeID.NodeIdValue))
 	}
-
-	return pathExist
+	return nil, false
 }
 
+// selectMatchUPF returns the UPFs serving the selection in order of preference: the available
+// UPFs of its selection chain if one is configured, the ones mapped to the tracking area of the
+// selection first, else the associated primary UPFs, the associated backup UPFs, then the others
+// in the same role order. Within a role, the UPFs mapped to the tracking area come first, then the
+// UPFs without predicted congestion, the least loaded first among the UPFs of the same rank.
 func (upi *UserPlaneInformation) selectMatchUPF(selection *UPFSelectionParams) []*UPNode {
+	if chain := UPFSelectionChain(selection); len(chain) > 0 {
+		return upi.preferTAIAnchors(selection, upi.selectChainUPFs(selection, chain))
+	}
+
 	upList := make([]*UPNode, 0)
 
-	for _, upNode := range upi.UPFs {
-		for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
-			currentSnssai := &snssaiInfo.SNssai
-			targetSnssai := selection.SNssai
-
-			if currentSnssai.Equal(targetSnssai) {
-				for _, dnnInfo := range snssaiInfo.DnnList {
-					if dnnInfo.Dnn == selection.Dnn && dnnInfo.ContainsDNAI(selection.Dnai) {
-						upList = append(upList, upNode)
-						break
-					}
-				}
-			}
+	for _, name := range slices.Sorted(maps.Keys(upi.UPFs)) {
+		upNode := upi.UPFs[name]
+		if upNode.servedDnnInfo(selection) != nil {
+			upList = append(upList, upNode)
 		}
 	}
+	now := time.Now()
+	taiAnchors := upi.taiAnchors(selection)
+	slices.SortStableFunc(upList, func(a, b *UPNode) int {
+		if rank := compareSelection(a, b, selection, taiAnchors, now); rank != 0 {
+			return rank
+		}
+		if priority := cmp.Compare(b.slicePriority(selection), a.slicePriority(selection)); priority != 0 {
+			return priority
+		}
+		return compareLoad(a, b, now)
+	})
 	return upList
 }
 
@@ -293,38 +336,14 @@ func (upi *UserPlaneInformation) InsertSmfUserPlaneNode(name string, node *facto
 		upNode.ANIP = net.ParseIP(node.ANIP)
 		upi.AccessNetwork[name] = upNode
 	case UPNODE_UPF:
-		// ParseIp() always return 16 bytes
-		// so we can't use the length of return ip to separate IPv4 and IPv6
-		var (
-			nodeIdType uint8
-			ip         net.IP
-		)
-
-		// Find IP
-		if ip = net.ParseIP(node.NodeID); ip != nil {
-			// v4 or v6
-			if ip.To4() != nil {
-				// IPv4
-				ip = ip.To4()
-				nodeIdType = NodeIdTypeIpv4Address
-			} else {
-				// IPv6
-				ip = ip.To16()
-				nodeIdType = NodeIdTypeIpv6Address
-			}
-		} else {
-			// FQDN
-			nodeIdType = NodeIdTypeFqdn
-			ip = []byte(node.NodeID)
-		}
-		// Populate outcome
-		upNode.NodeID = NodeID{
-			NodeIdType:  nodeIdType,
-			NodeIdValue: []byte(ip),
-		}
+		// IPv4 or IPv6 address, anything else being an FQDN
+		upNode.NodeID = *NewNodeID(node.NodeID)
 
 		upNode.UPF = NewUPF(&upNode.NodeID, node.InterfaceUpfInfoList)
 		upNode.UPF.Port = upNode.Port
+		upNode.UPF.SRv6Steering = node.SRv6
+		upNode.UPF.CPNodeIDFqdn = parseCPNodeIDType(name, node)
+		upNode.UPF.setTEIDPartition(name, node.TEIDPartition)
 
 		snssaiInfos := make([]SnssaiUPFInfo, 0)
 		for _, snssaiInfoConfig := range node.SNssaiInfos {
@@ -339,6 +358,7 @@ func (upi *UserPlaneInformation) InsertSmfUserPlaneNode(name string, node *facto
 			for _, dnnInfoConfig := range snssaiInfoConfig.DnnUpfInfoList {
 				snssaiInfo.DnnList = append(snssaiInfo.DnnList, DnnUPFInfoItem{
 					Dnn:             dnnInfoConfig.Dnn,
+					Role:            parseUPFRole(name, dnnInfoConfig.Dnn, node.DnnRoles),
 					DnaiList:        dnnInfoConfig.DnaiList,
 					PduSessionTypes: dnnInfoConfig.PduSessionTypes,
 				})
@@ -347,18 +367,31 @@ func (upi *UserPlaneInformation) InsertSmfUserPlaneNode(name string, node *facto
 		}
 		upNode.UPF.SNssaiInfos = snssaiInfos
 		upi.UPFs[name] = upNode
+		upi.setUPFTais(name, node.Tais)
 	default:
 		logger.InitLog.Warnf("invalid UPNodeType: %s", upNode.Type)
 	}
 
 	upi.UPNodes[name] = upNode
 
-	ipStr := upNode.NodeID.ResolveNodeIdToIp().String()
-	upi.UPFIPToName[ipStr] = name
+	upi.setUPFIPToName(upNode, name)
 
 	return nil
 }
 
+// parseCPNodeIDType returns whether the SMF presents its FQDN as Node ID to the UPF. Unless
+// configured as fqdn, the SMF presents its IP address, whatever the type of the node ID of the UPF.
+func parseCPNodeIDType(name string, node *factory.UPNode) bool {
+	switch node.NodeIDType {
+	case factory.NodeIDTypeFQDN:
+		return true
+	case factory.NodeIDTypeIP, "":
+	default:
+		logger.InitLog.Warnf("invalid nodeIdType [%s] of UPF [%s], ip used", node.NodeIDType, name)
+	}
+	return false
+}
+
 // Update an existing User Plane Node.
 // If the node is of type AN, then the node is updated with the new port.
 // If the node is of type UPF, then the node is updated with the new port and the new UPF information.
@@ -376,30 +409,15 @@ func (upi *UserPlaneInformation) UpdateSmfUserPlaneNode(name string, newNode *fa
 	case UPNODE_AN:
 		existingNode.ANIP = net.ParseIP(newNode.ANIP)
 	case UPNODE_UPF:
-
-		var nodeIdType uint8
-
-		ip := net.ParseIP(newNode.NodeID)
-		if ip == nil {
-			nodeIdType = NodeIdTypeFqdn
-			ip = []byte(newNode.NodeID)
-		} else if ip.To4() != nil {
-			nodeIdType = NodeIdTypeIpv4Address
-			ip = ip.To4()
-		} else {
-			nodeIdType = NodeIdTypeIpv6Address
-			ip = ip.To16()
-		}
-
-		newNodeID := NodeID{
-			NodeIdType:  nodeIdType,
-			NodeIdValue: []byte(ip),
-		}
+		newNodeID := *NewNodeID(newNode.NodeID)
 
 		if !reflect.DeepEqual(existingNode.NodeID, newNodeID) {
 			existingNode.NodeID = newNodeID
 			existingNode.UPF = NewUPF(&existingNode.NodeID, newNode.InterfaceUpfInfoList)
 		}
+		existingNode.UPF.SRv6Steering = newNode.SRv6
+		existingNode.UPF.CPNodeIDFqdn = parseCPNodeIDType(name, newNode)
+		existingNode.UPF.setTEIDPartition(name, newNode.TEIDPartition)
 
 		existingNode.UPF.SNssaiInfos = make([]SnssaiUPFInfo, len(newNode.SNssaiInfos))
 		for i, snssaiInfoConfig := range newNode.SNssaiInfos {
@@ -414,20 +432,21 @@ func (upi *UserPlaneInformation) UpdateSmfUserPlaneNode(name string, newNode *fa
 			for j, dnnInfoConfig := range snssaiInfoConfig.DnnUpfInfoList {
 				existingNode.UPF.SNssaiInfos[i].DnnList[j] = DnnUPFInfoItem{
 					Dnn:             dnnInfoConfig.Dnn,
+					Role:            parseUPFRole(name, dnnInfoConfig.Dnn, newNode.DnnRoles),
 					DnaiList:        dnnInfoConfig.DnaiList,
 					PduSessionTypes: dnnInfoConfig.PduSessionTypes,
 				}
 			}
 		}
 		upi.UPFs[name] = existingNode
+		upi.setUPFTais(name, newNode.Tais)
 	default:
 		logger.InitLog.Warnf("invalid UPNodeType: %s", existingNode.Type)
 	}
 
 	upi.UPNodes[name] = existingNode
 
-	ipStr := existingNode.NodeID.ResolveNodeIdToIp().String()
-	upi.UPFIPToName[ipStr] = name
+	upi.setUPFIPToName(existingNode, name)
 
 	logger.CtxLog.Infof("UPNode [%s] updated successfully", name)
 	return nil
@@ -457,6 +476,10 @@ func (upi *UserPlaneInformation) DeleteSmfUserPlaneNode(name string, node *facto
 			logger.UPNodeLog.Debugf("content of map[UPFsID] %v", upi.UPFsID)
 			delete(upi.UPFs, name)
 			delete(upi.UPFsID, name)
+			upi.releaseUPFTais(name)
+			if detector := GetCongestionDetector(); detector != nil {
+				detector.Remove(name)
+			}
 			// IP to ID map(Host may not be resolvable to IP, so iterate through all entries)
 			logger.UPNodeLog.Debugf("content of map[UPFsIPtoID] %v", upi.UPFsIPtoID)
 			for ipStr, nodeId := range upi.UPFsIPtoID {
@@ -484,12 +507,94 @@ func (upi *UserPlaneInformation) DeleteSmfUserPlaneNode(name string, node *facto
 				logger.UPNodeLog.Infof("UPNode[%v] deleted from table[UPFIPToName]", name)
 			}
 		}
+		upi.releaseUPFIPConflict(name)
 	}
 
 	// also clean up default paths to UPFs
 	return nil
 }
 
+// setUPFIPToName records the IP of the given node in UPFIPToName. If another UPF
+// already resolves to the same IP, the collision is logged and recorded in
+// UPFIPConflicts, and the lexicographically smallest name is kept as the owner
+// of the IP so that the outcome does not depend on insertion order.
+func (upi *UserPlaneInformation) setUPFIPToName(upNode *UPNode, name string) {
+	ip := upNode.NodeID.ResolveNodeIdToIp()
+	ipStr := ip.String()
+
+	// Only UPFs with a resolved address can legitimately collide
+	if upNode.Type != UPNODE_UPF || ip == nil || ip.IsUnspecified() {
+		upi.UPFIPToName[ipStr] = name
+		return
+	}
+
+	// a node whose address changed no longer owns or conflicts on its old IP
+	for oldIP, nodeName := range upi.UPFIPToName {
+		if nodeName == name && oldIP != ipStr {
+			delete(upi.UPFIPToName, oldIP)
+		}
+	}
+	upi.releaseUPFIPConflictExcept(name, ipStr)
+
+	existing, exists := upi.UPFIPToName[ipStr]
+	if !exists || existing == name {
+		upi.UPFIPToName[ipStr] = name
+		return
+	}
+
+	if upi.UPFIPConflicts == nil {
+		upi.UPFIPConflicts = make(map[string][]string)
+	}
+	names := upi.UPFIPConflicts[ipStr]
+	if len(names) == 0 {
+		names = append(names, existing)
+	}
+	if !slices.Contains(names, name) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	upi.UPFIPConflicts[ipStr] = names
+
+	upi.UPFIPToName[ipStr] = names[0]
+	logger.UPNodeLog.Warnf("UPNode[%v] resolves to IP[%v] already used by UPNode[%v], UPNode[%v] selected, conflicting nodes %v",
+		name, ipStr, existing, names[0], names)
+}
+
+// releaseUPFIPConflict removes the given node from all recorded IP collisions
+// and hands the IP over to the next remaining node, if any.
+func (upi *UserPlaneInformation) releaseUPFIPConflict(name string) {
+	upi.releaseUPFIPConflictExcept(name, "")
+}
+
+func (upi *UserPlaneInformation) releaseUPFIPConflictExcept(name, keepIP string) {
+	for ipStr, names := range upi.UPFIPConflicts {
+		if ipStr == keepIP || !slices.Contains(names, name) {
+			continue
+		}
+		remaining := make([]string, 0, len(names)-1)
+		for _, nodeName := range names {
+			if nodeName != name {
+				remaining = append(remaining, nodeName)
+			}
+		}
+		if len(remaining) > 0 {
+			upi.UPFIPToName[ipStr] = remaining[0]
+			logger.UPNodeLog.Infof("UPNode[%v] now owns IP[%v] in table[UPFIPToName]", remaining[0], ipStr)
+		}
+		if len(remaining) > 1 {
+			upi.UPFIPConflicts[ipStr] = remaining
+		} else {
+			delete(upi.UPFIPConflicts, ipStr)
+			logger.UPNodeLog.Infof("IP[%v] collision in table[UPFIPToName] resolved", ipStr)
+		}
+	}
+}
+
+// HasUPFIPConflict reports whether more than one UPF resolves to the given IP
+func (upi *UserPlaneInformation) HasUPFIPConflict(ip string) bool {
+	return len(upi.UPFIPConflicts[ip]) > 1
+}
+
 func (upi *UserPlaneInformation) InsertUPNodeLinks(link *factory.UPLink) error {
 	// Update Links
 	logger.UPNodeLog.Infof("inserting UP Node link[%v] ", link)
@@ -8,9 +8,11 @@ package context_test
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
 	"github.com/omec-project/smf/factory"
 	"github.com/stretchr/testify/require"
 )
@@ -307,3 +309,220 @@ func TestUpdateSmfUserPlaneNode_NodeIDChange(t *testing.T) {
 		t.Errorf("Expected UPNode NodeID to be updated")
 	}
 }
+
+func TestUPFIPToNameCollision(t *testing.T) {
+	context.InsertDnsHostIp("upf-a.example.test", net.ParseIP("10.10.0.1"))
+	context.InsertDnsHostIp("upf-b.example.test", net.ParseIP("10.10.0.1"))
+
+	upTopology := &factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"UPF-B": {
+				Type:   "UPF",
+				NodeID: "upf-b.example.test",
+			},
+			"UPF-A": {
+				Type:   "UPF",
+				NodeID: "upf-a.example.test",
+			},
+		},
+	}
+
+	upi := context.NewUserPlaneInformation(upTopology)
+
+	// lexicographically smallest name wins regardless of map iteration order
+	require.Equal(t, "UPF-A", upi.GetUPFNameByIp("10.10.0.1"))
+	require.True(t, upi.HasUPFIPConflict("10.10.0.1"))
+	require.Equal(t, []string{"UPF-A", "UPF-B"}, upi.UPFIPConflicts["10.10.0.1"])
+
+	// removing the winner hands the IP over to the remaining UPF
+	err := upi.DeleteSmfUserPlaneNode("UPF-A", &factory.UPNode{NodeID: "upf-a.example.test"})
+	require.NoError(t, err)
+	require.Equal(t, "UPF-B", upi.GetUPFNameByIp("10.10.0.1"))
+	require.False(t, upi.HasUPFIPConflict("10.10.0.1"))
+}
+
+func TestGetDefaultUserPlanePathByDNNPrimaryBackup(t *testing.T) {
+	snssaiInfos := []models.SnssaiUpfInfoItem{
+		{
+			SNssai: &models.Snssai{
+				Sst: 1,
+				Sd:  "010203",
+			},
+			DnnUpfInfoList: []models.DnnUpfInfoItem{
+				{Dnn: "internet"},
+			},
+		},
+	}
+	upi := context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"GNodeB": {
+				Type:   "AN",
+				NodeID: "192.168.180.100",
+			},
+			"UPF-Backup": {
+				Type:        "UPF",
+				NodeID:      "192.168.180.1",
+				SNssaiInfos: snssaiInfos,
+				DnnRoles:    map[string]string{"internet": "backup"},
+			},
+			"UPF-Primary": {
+				Type:        "UPF",
+				NodeID:      "192.168.180.2",
+				SNssaiInfos: snssaiInfos,
+			},
+		},
+		Links: []factory.UPLink{
+			{A: "GNodeB", B: "UPF-Backup"},
+			{A: "GNodeB", B: "UPF-Primary"},
+		},
+	})
+	primary := upi.UPFs["UPF-Primary"]
+	backup := upi.UPFs["UPF-Backup"]
+	t.Cleanup(func() {
+		context.RemoveUPFNodeByNodeID(primary.NodeID)
+		context.RemoveUPFNodeByNodeID(backup.NodeID)
+	})
+	primary.UPF.UPFStatus = context.AssociatedSetUpSuccess
+	backup.UPF.UPFStatus = context.AssociatedSetUpSuccess
+
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{
+			Sst: 1,
+			Sd:  "010203",
+		},
+		Dnn: "internet",
+	}
+	anchor := func() *context.UPNode {
+		path := upi.GetDefaultUserPlanePathByDNN(selection)
+		require.NotEmpty(t, path)
+		return path[len(path)-1]
+	}
+
+	require.Same(t, primary, anchor(), "sessions land on the primary UPF")
+
+	primary.UPF.SetCongestedUntil(time.Now().Add(time.Hour))
+	require.Same(t, primary, anchor(), "a congestion predicted on the primary UPF does not move sessions to the backup")
+	primary.UPF.ClearCongestion()
+
+	primary.UPF.UPFStatus = context.NotAssociated
+	require.Same(t, backup, anchor(), "sessions move to the backup UPF once the primary failed")
+
+	primary.UPF.UPFStatus = context.AssociatedSetUpSuccess
+	require.Same(t, primary, anchor(), "sessions return to the primary UPF once it is back")
+}
+
+func TestGetDefaultUserPlanePathBySlicePriority(t *testing.T) {
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{Snssai: context.SNssai{Sst: 1, Sd: "010203"}, Priority: 1},
+		{Snssai: context.SNssai{Sst: 1, Sd: "112233"}, Priority: 5},
+	})
+
+	upfSnssaiInfos := func(sd string) []models.SnssaiUpfInfoItem {
+		return []models.SnssaiUpfInfoItem{
+			{SNssai: &models.Snssai{Sst: 1, Sd: sd}, DnnUpfInfoList: []models.DnnUpfInfoItem{{Dnn: "internet"}}},
+		}
+	}
+	upi := context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"GNodeB":   {Type: "AN", NodeID: "192.168.181.100"},
+			"UPF-Low":  {Type: "UPF", NodeID: "192.168.181.1", SNssaiInfos: upfSnssaiInfos("010203")},
+			"UPF-High": {Type: "UPF", NodeID: "192.168.181.2", SNssaiInfos: upfSnssaiInfos("112233")},
+		},
+		Links: []factory.UPLink{
+			{A: "GNodeB", B: "UPF-Low"},
+			{A: "GNodeB", B: "UPF-High"},
+		},
+	})
+	low, high := upi.UPFs["UPF-Low"], upi.UPFs["UPF-High"]
+	t.Cleanup(func() {
+		context.RemoveUPFNodeByNodeID(low.NodeID)
+		context.RemoveUPFNodeByNodeID(high.NodeID)
+	})
+	low.UPF.UPFStatus = context.AssociatedSetUpSuccess
+	high.UPF.UPFStatus = context.AssociatedSetUpSuccess
+
+	anchor := func(sd string) *context.UPNode {
+		path := upi.GetDefaultUserPlanePathByDNN(&context.UPFSelectionParams{
+			SNssai: &context.SNssai{Sst: 1, Sd: sd},
+			Dnn:    "internet",
+		})
+		require.NotEmpty(t, path)
+		return path[len(path)-1]
+	}
+
+	require.Same(t, high, anchor(""), "without SD, the UPF of the slice of the highest priority")
+	require.Same(t, low, anchor("010203"), "the UPF of the requested slice regardless of priority")
+
+	high.UPF.UPFStatus = context.NotAssociated
+	require.Same(t, low, anchor(""), "an available UPF before the priority of its slice")
+}
+
+func TestSessionAnchoredInSelectedSlice(t *testing.T) {
+	lowAllocator, err := context.NewIPAllocator("10.60.0.0/24")
+	require.NoError(t, err)
+	highAllocator, err := context.NewIPAllocator("10.61.0.0/24")
+	require.NoError(t, err)
+	contexttest.SetSnssaiInfos(t, []context.SnssaiSmfInfo{
+		{
+			Snssai:   context.SNssai{Sst: 1, Sd: "010203"},
+			Priority: 1,
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{contexttest.Dnn: {UeIPAllocator: lowAllocator}},
+		},
+		{
+			Snssai:   context.SNssai{Sst: 1, Sd: "112233"},
+			Priority: 5,
+			DnnInfos: map[string]*context.SnssaiSmfDnnInfo{contexttest.Dnn: {UeIPAllocator: highAllocator}},
+		},
+	})
+	upi := contexttest.NewUserPlane(t, "192.168.182.100", map[string]factory.UPNode{
+		"UPF-Low":  contexttest.UPF("192.168.182.1", &models.Snssai{Sst: 1, Sd: "010203"}),
+		"UPF-High": contexttest.UPF("192.168.182.2", &models.Snssai{Sst: 1, Sd: "112233"}),
+	})
+	// the UPF of the slice of the highest priority ranks behind the other one
+	high := upi.UPFs["UPF-High"]
+	high.UPF.SetCongestedUntil(time.Now().Add(time.Hour))
+
+	smContext := contexttest.NewSMContext("imsi-208930000000105", 1, &models.Snssai{Sst: 1}, nil)
+	require.True(t, smContext.SelectDNNInfo())
+	require.Equal(t, &context.SNssai{Sst: 1, Sd: "112233"}, smContext.SelectedSnssai)
+	require.Same(t, highAllocator, smContext.DNNInfo.UeIPAllocator)
+	require.Equal(t, models.Snssai{Sst: 1}, *smContext.Snssai, "the requested S-NSSAI is kept")
+
+	// the anchor UPF is the one of the slice of the UE IP pool
+	path := upi.GetDefaultUserPlanePathByDNN(smContext.UPFSelectionParams())
+	require.NotEmpty(t, path)
+	require.Same(t, high, path[len(path)-1])
+	require.True(t, high.UPF.IsSessionServed(smContext))
+	require.False(t, upi.UPFs["UPF-Low"].UPF.IsSessionServed(smContext))
+}
+
+func TestUPFCPNodeIDType(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	origCPNodeID, origCPNodeFQDN := smfSelf.CPNodeID, smfSelf.CPNodeFQDN
+	t.Cleanup(func() { smfSelf.CPNodeID, smfSelf.CPNodeFQDN = origCPNodeID, origCPNodeFQDN })
+	smfSelf.CPNodeID = *context.NewNodeID("10.30.0.100")
+	smfSelf.CPNodeFQDN = "smf.example.test"
+
+	upi := context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"UPF-FQDN":    {Type: "UPF", NodeID: "upf-type.example.test"},
+			"UPF-IP":      {Type: "UPF", NodeID: "10.30.0.1"},
+			"UPF-AS-FQDN": {Type: "UPF", NodeID: "10.30.0.2", NodeIDType: factory.NodeIDTypeFQDN},
+			"UPF-AS-IP":   {Type: "UPF", NodeID: "upf-type-ip.example.test", NodeIDType: factory.NodeIDTypeIP},
+			"UPF-BAD-IP":  {Type: "UPF", NodeID: "bad:ip"},
+		},
+	})
+	fqdn := context.NodeID{NodeIdType: context.NodeIdTypeFqdn, NodeIdValue: []byte("smf.example.test")}
+
+	// IP address unless configured, whatever the node ID of the UPF
+	require.Equal(t, smfSelf.CPNodeID, upi.UPFs["UPF-FQDN"].UPF.CPNodeID())
+	require.Equal(t, smfSelf.CPNodeID, upi.UPFs["UPF-IP"].UPF.CPNodeID())
+
+	// configured
+	require.Equal(t, fqdn, upi.UPFs["UPF-AS-FQDN"].UPF.CPNodeID())
+	require.Equal(t, smfSelf.CPNodeID, upi.UPFs["UPF-AS-IP"].UPF.CPNodeID())
+
+	// a node ID which is not an IP address is an FQDN
+	require.Equal(t, context.NodeIdTypeFqdn, upi.UPFs["UPF-BAD-IP"].NodeID.NodeIdType)
+	require.Equal(t, smfSelf.CPNodeID, upi.UPFs["UPF-BAD-IP"].UPF.CPNodeID())
+}
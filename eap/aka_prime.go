@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package eap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+)
+
+// EAP-AKA' subtypes, RFC 4187 11
+const (
+	SubtypeChallenge              uint8 = 1
+	SubtypeAuthenticationReject   uint8 = 2
+	SubtypeSynchronizationFailure uint8 = 4
+	SubtypeClientError            uint8 = 14
+)
+
+// EAP-AKA' attribute types, RFC 4187 11 and RFC 5448 6
+const (
+	AttributeRand     uint8 = 1
+	AttributeAutn     uint8 = 2
+	AttributeRes      uint8 = 3
+	AttributeMac      uint8 = 11
+	AttributeKdfInput uint8 = 23
+	AttributeKdf      uint8 = 24
+)
+
+// KdfAKAPrime is the key derivation function of EAP-AKA' in AT_KDF, RFC 5448 3.2
+const KdfAKAPrime uint16 = 1
+
+// macLength is the length of AT_MAC, HMAC-SHA-256-128
+const macLength = 16
+
+// akaPrimeDataOffset is the offset of the attributes in an EAP-AKA' packet, after the EAP header,
+// the type, the subtype and the reserved bytes
+const akaPrimeDataOffset = headerLength + 4
+
+// Attribute is an EAP-AKA' attribute, its value following the type and the length
+type Attribute struct {
+	Type  uint8
+	Value []byte
+}
+
+// RandAttribute returns AT_RAND of the RAND of the authentication vector
+func RandAttribute(rand []byte) Attribute {
+	return Attribute{Type: AttributeRand, Value: append([]byte{0, 0}, rand...)}
+}
+
+// AutnAttribute returns AT_AUTN of the AUTN of the authentication vector
+func AutnAttribute(autn []byte) Attribute {
+	return Attribute{Type: AttributeAutn, Value: append([]byte{0, 0}, autn...)}
+}
+
+// ResAttribute returns AT_RES of the RES computed by the UE
+func ResAttribute(res []byte) Attribute {
+	value := binary.BigEndian.AppendUint16(nil, uint16(len(res)*8))
+	return Attribute{Type: AttributeRes, Value: append(value, res...)}
+}
+
+// MacAttribute returns AT_MAC with a zero MAC, the MAC being set when the packet is signed
+func MacAttribute() Attribute {
+	return Attribute{Type: AttributeMac, Value: make([]byte, 2+macLength)}
+}
+
+// KdfAttribute returns AT_KDF of the key derivation function
+func KdfAttribute(kdf uint16) Attribute {
+	return Attribute{Type: AttributeKdf, Value: binary.BigEndian.AppendUint16(nil, kdf)}
+}
+
+// KdfInputAttribute returns AT_KDF_INPUT of the network name
+func KdfInputAttribute(networkName string) Attribute {
+	value := binary.BigEndian.AppendUint16(nil, uint16(len(networkName)))
+	return Attribute{Type: AttributeKdfInput, Value: append(value, networkName...)}
+}
+
+// AKAPrimeData returns the type data of an EAP-AKA' packet of the subtype and attributes, each
+// attribute being padded to a multiple of 4 bytes
+func AKAPrimeData(subtype uint8, attributes ...Attribute) []byte {
+	data := []byte{subtype, 0, 0}
+	for _, attribute := range attributes {
+		length := (2 + len(attribute.Value) + 3) / 4
+		data = append(data, attribute.Type, uint8(length))
+		data = append(data, attribute.Value...)
+		data = append(data, make([]byte, length*4-2-len(attribute.Value))...)
+	}
+	return data
+}
+
+// ParseAKAPrime returns the subtype and the attribute values of the EAP-AKA' packet, by type
+func ParseAKAPrime(p *Packet) (uint8, map[uint8][]byte, error) {
+	if p.Type != TypeAKAPrime {
+		return 0, nil, fmt.Errorf("EAP type %d not EAP-AKA'", p.Type)
+	}
+	if len(p.Data) < 3 {
+		return 0, nil, fmt.Errorf("EAP-AKA' packet without subtype")
+	}
+	attributes := make(map[uint8][]byte)
+	for data := p.Data[3:]; len(data) > 0; {
+		if len(data) < 4 || data[1] == 0 || int(data[1])*4 > len(data) {
+			return 0, nil, fmt.Errorf("EAP-AKA' attribute truncated")
+		}
+		length := int(data[1]) * 4
+		attributes[data[0]] = data[2:length]
+		data = data[length:]
+	}
+	return p.Data[0], attributes, nil
+}
+
+// macOffset returns the offset of the MAC of AT_MAC in the EAP-AKA' packet
+func macOffset(packet []byte) (int, error) {
+	for offset := akaPrimeDataOffset; offset+4 <= len(packet); {
+		length := int(packet[offset+1]) * 4
+		if length == 0 || offset+length > len(packet) {
+			break
+		}
+		if packet[offset] == AttributeMac && length == 4+macLength {
+			return offset + 4, nil
+		}
+		offset += length
+	}
+	return 0, fmt.Errorf("EAP-AKA' packet without AT_MAC")
+}
+
+// mac returns the HMAC-SHA-256-128 of the EAP-AKA' packet with a zero MAC, RFC 5448 3.4.2
+func mac(kAut, packet []byte, offset int) []byte {
+	zeroed := bytes.Clone(packet)
+	clear(zeroed[offset : offset+macLength])
+	h := hmac.New(sha256.New, kAut)
+	h.Write(zeroed)
+	return h.Sum(nil)[:macLength]
+}
+
+// Sign sets the MAC of AT_MAC of the EAP-AKA' packet, keyed by K_aut
+func Sign(kAut, packet []byte) error {
+	offset, err := macOffset(packet)
+	if err != nil {
+		return err
+	}
+	copy(packet[offset:], mac(kAut, packet, offset))
+	return nil
+}
+
+// Verify checks the MAC of AT_MAC of the EAP-AKA' packet, keyed by K_aut
+func Verify(kAut, packet []byte) bool {
+	offset, err := macOffset(packet)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(packet[offset:offset+macLength], mac(kAut, packet, offset))
+}
+
+// Keys are the keys of EAP-AKA' derived from CK' and IK', RFC 5448 3.3
+type Keys struct {
+	KEncr []byte
+	KAut  []byte
+	KRe   []byte
+	MSK   []byte
+	EMSK  []byte
+}
+
+// DeriveKeys derives the keys of EAP-AKA' of the identity of the UE from CK' and IK'. The master
+// key is PRF'(IK' | CK', "EAP-AKA'" | Identity), RFC 5448 3.3.
+func DeriveKeys(identity string, ikPrime, ckPrime []byte) Keys {
+	key := append(bytes.Clone(ikPrime), ckPrime...)
+	mk := prfPrime(key, append([]byte("EAP-AKA'"), identity...), 208)
+	return Keys{
+		KEncr: mk[0:16],
+		KAut:  mk[16:48],
+		KRe:   mk[48:80],
+		MSK:   mk[80:144],
+		EMSK:  mk[144:208],
+	}
+}
+
+// prfPrime returns the first length bytes of PRF'(K, S) = T1 | T2 | ..., Tn being
+// HMAC-SHA-256(K, Tn-1 | S | n), RFC 5448 3.4.1
+func prfPrime(key, s []byte, length int) []byte {
+	var out, t []byte
+	for n := byte(1); len(out) < length; n++ {
+		h := hmac.New(sha256.New, key)
+		h.Write(t)
+		h.Write(s)
+		h.Write([]byte{n})
+		t = h.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+// Vector is an EAP-AKA' authentication vector of the UE, CK' and IK' being derived by the UDM
+// for the network name of the serving network
+type Vector struct {
+	Rand    []byte
+	Autn    []byte
+	Xres    []byte
+	CkPrime []byte
+	IkPrime []byte
+}
+
+// AKAPrimeServer is an EAP-AKA' server authenticating the UE with a vector of the UDM, RFC 5448.
+// The UE is asked its identity with an EAP-Request/Identity, then challenged with the vector,
+// its response being checked against the MAC of the derived K_aut and the XRES of the vector.
+// The resynchronization of the sequence number on a Synchronization-Failure is not supported,
+// the UE not being authenticated.
+type AKAPrimeServer struct {
+	// network name of the serving network in AT_KDF_INPUT, with which CK' and IK' are derived
+	NetworkName string
+	// Vector returns the authentication vector of the UE generated by the UDM
+	Vector func() (*Vector, error)
+
+	identifier uint8
+	identity   string
+	vector     *Vector
+	keys       Keys
+}
+
+// Start returns the EAP-Request/Identity asking the UE its identity
+func (s *AKAPrimeServer) Start() ([]byte, error) {
+	s.identifier = uint8(rand.UintN(256))
+	return IdentityRequest(s.identifier), nil
+}
+
+// Next answers the identity of the UE with the EAP-Request/AKA'-Challenge and its challenge
+// response with the EAP-Success or EAP-Failure ending the authentication
+func (s *AKAPrimeServer) Next(response []byte) ([]byte, error) {
+	p, err := Decode(response)
+	if err != nil {
+		return nil, err
+	}
+	if p.Code != CodeResponse || p.Identifier != s.identifier {
+		return nil, fmt.Errorf("EAP %s %d not a response to the request %d", codeName(p.Code),
+			p.Identifier, s.identifier)
+	}
+	if s.vector == nil {
+		return s.challenge(p)
+	}
+	if s.authenticated(p, response) {
+		return Success(p.Identifier), nil
+	}
+	return Failure(p.Identifier), nil
+}
+
+// challenge returns the EAP-Request/AKA'-Challenge of the vector of the UE of the identity
+func (s *AKAPrimeServer) challenge(p *Packet) ([]byte, error) {
+	if p.Type != TypeIdentity || len(p.Data) == 0 {
+		return Failure(p.Identifier), nil
+	}
+	vector, err := s.Vector()
+	if err != nil {
+		return nil, fmt.Errorf("EAP-AKA' authentication vector not generated: %w", err)
+	}
+	s.identity = string(p.Data)
+	s.vector = vector
+	s.keys = DeriveKeys(s.identity, vector.IkPrime, vector.CkPrime)
+	s.identifier++
+
+	challenge := (&Packet{
+		Code:       CodeRequest,
+		Identifier: s.identifier,
+		Type:       TypeAKAPrime,
+		Data: AKAPrimeData(SubtypeChallenge,
+			RandAttribute(vector.Rand),
+			AutnAttribute(vector.Autn),
+			KdfAttribute(KdfAKAPrime),
+			KdfInputAttribute(s.NetworkName),
+			MacAttribute()),
+	}).Encode()
+	if err := Sign(s.keys.KAut, challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// authenticated checks that the response of the UE to the challenge is signed with K_aut and
+// carries the XRES of the vector
+func (s *AKAPrimeServer) authenticated(p *Packet, response []byte) bool {
+	subtype, attributes, err := ParseAKAPrime(p)
+	if err != nil || subtype != SubtypeChallenge {
+		return false
+	}
+	if !Verify(s.keys.KAut, response) {
+		return false
+	}
+	res, ok := attributes[AttributeRes]
+	if !ok || len(res) < 2 {
+		return false
+	}
+	resLength := int(binary.BigEndian.Uint16(res[:2])+7) / 8
+	if resLength > len(res)-2 {
+		return false
+	}
+	return hmac.Equal(res[2:2+resLength], s.vector.Xres)
+}
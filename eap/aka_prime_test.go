@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package eap_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/omec-project/smf/eap"
+	"github.com/stretchr/testify/require"
+)
+
+const testNetworkName = "5G:mnc093.mcc208.3gppnetwork.org"
+
+func testVector() *eap.Vector {
+	return &eap.Vector{
+		Rand:    bytes.Repeat([]byte{0x01}, 16),
+		Autn:    bytes.Repeat([]byte{0x02}, 16),
+		Xres:    bytes.Repeat([]byte{0x03}, 8),
+		CkPrime: bytes.Repeat([]byte{0x04}, 16),
+		IkPrime: bytes.Repeat([]byte{0x05}, 16),
+	}
+}
+
+// answerChallenge returns the response of the UE to the EAP-AKA' challenge with the RES, signed
+// with the K_aut of the identity and vector
+func answerChallenge(t *testing.T, identity string, vector *eap.Vector, challenge, res []byte) []byte {
+	keys := eap.DeriveKeys(identity, vector.IkPrime, vector.CkPrime)
+	require.True(t, eap.Verify(keys.KAut, challenge))
+	p, err := eap.Decode(challenge)
+	require.NoError(t, err)
+	subtype, attributes, err := eap.ParseAKAPrime(p)
+	require.NoError(t, err)
+	require.Equal(t, eap.SubtypeChallenge, subtype)
+	require.Equal(t, append([]byte{0, 0}, vector.Rand...), attributes[eap.AttributeRand])
+	require.Equal(t, append([]byte{0, 0}, vector.Autn...), attributes[eap.AttributeAutn])
+	require.Equal(t, []byte{0, 1}, attributes[eap.AttributeKdf])
+	require.Equal(t, eap.KdfInputAttribute(testNetworkName).Value,
+		attributes[eap.AttributeKdfInput][:2+len(testNetworkName)])
+
+	response := (&eap.Packet{
+		Code:       eap.CodeResponse,
+		Identifier: p.Identifier,
+		Type:       eap.TypeAKAPrime,
+		Data:       eap.AKAPrimeData(eap.SubtypeChallenge, eap.ResAttribute(res), eap.MacAttribute()),
+	}).Encode()
+	require.NoError(t, eap.Sign(keys.KAut, response))
+	return response
+}
+
+// challengeUE runs the EAP-AKA' server up to the challenge of the UE of the identity
+func challengeUE(t *testing.T, server *eap.AKAPrimeServer, identity string) []byte {
+	request, err := server.Start()
+	require.NoError(t, err)
+	p, err := eap.Decode(request)
+	require.NoError(t, err)
+	require.Equal(t, eap.CodeRequest, p.Code)
+	require.Equal(t, eap.TypeIdentity, p.Type)
+
+	challenge, err := server.Next((&eap.Packet{
+		Code:       eap.CodeResponse,
+		Identifier: p.Identifier,
+		Type:       eap.TypeIdentity,
+		Data:       []byte(identity),
+	}).Encode())
+	require.NoError(t, err)
+	return challenge
+}
+
+func TestAKAPrimeServer(t *testing.T) {
+	const identity = "0208930000000001@nai.5gc.mnc093.mcc208.3gppnetwork.org"
+	vector := testVector()
+	signedWith := func(identity string) func(t *testing.T, challenge []byte) []byte {
+		return func(t *testing.T, challenge []byte) []byte {
+			return answerChallenge(t, identity, vector, challenge, vector.Xres)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		answer func(t *testing.T, challenge []byte) []byte
+		code   uint8
+	}{
+		{
+			name:   "Authenticated",
+			answer: signedWith(identity),
+			code:   eap.CodeSuccess,
+		},
+		{
+			name: "WrongRes",
+			answer: func(t *testing.T, challenge []byte) []byte {
+				return answerChallenge(t, identity, vector, challenge, bytes.Repeat([]byte{0x09}, 8))
+			},
+			code: eap.CodeFailure,
+		},
+		{
+			name: "WrongMac",
+			answer: func(t *testing.T, challenge []byte) []byte {
+				response := answerChallenge(t, identity, vector, challenge, vector.Xres)
+				response[len(response)-1] ^= 0xff
+				return response
+			},
+			code: eap.CodeFailure,
+		},
+		{
+			name: "AuthenticationReject",
+			answer: func(t *testing.T, challenge []byte) []byte {
+				p, err := eap.Decode(challenge)
+				require.NoError(t, err)
+				return (&eap.Packet{
+					Code:       eap.CodeResponse,
+					Identifier: p.Identifier,
+					Type:       eap.TypeAKAPrime,
+					Data:       eap.AKAPrimeData(eap.SubtypeAuthenticationReject),
+				}).Encode()
+			},
+			code: eap.CodeFailure,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &eap.AKAPrimeServer{
+				NetworkName: testNetworkName,
+				Vector:      func() (*eap.Vector, error) { return vector, nil },
+			}
+			challenge := challengeUE(t, server, identity)
+			response := tc.answer(t, challenge)
+
+			result, err := server.Next(response)
+			require.NoError(t, err)
+			p, err := eap.Decode(result)
+			require.NoError(t, err)
+			require.Equal(t, tc.code, p.Code)
+			require.Equal(t, response[1], p.Identifier)
+		})
+	}
+
+	t.Run("KeysOfAnotherIdentity", func(t *testing.T) {
+		server := &eap.AKAPrimeServer{
+			NetworkName: testNetworkName,
+			Vector:      func() (*eap.Vector, error) { return vector, nil },
+		}
+		challenge := challengeUE(t, server, identity)
+		keys := eap.DeriveKeys("another", vector.IkPrime, vector.CkPrime)
+		require.False(t, eap.Verify(keys.KAut, challenge))
+	})
+}
+
+func TestAKAPrimeServerErrors(t *testing.T) {
+	t.Run("VectorNotGenerated", func(t *testing.T) {
+		server := &eap.AKAPrimeServer{
+			NetworkName: testNetworkName,
+			Vector:      func() (*eap.Vector, error) { return nil, errors.New("UDM unavailable") },
+		}
+		request, err := server.Start()
+		require.NoError(t, err)
+		_, err = server.Next((&eap.Packet{
+			Code:       eap.CodeResponse,
+			Identifier: request[1],
+			Type:       eap.TypeIdentity,
+			Data:       []byte("imsi"),
+		}).Encode())
+		require.Error(t, err)
+	})
+
+	t.Run("StaleIdentifier", func(t *testing.T) {
+		server := &eap.AKAPrimeServer{
+			NetworkName: testNetworkName,
+			Vector:      func() (*eap.Vector, error) { return testVector(), nil },
+		}
+		request, err := server.Start()
+		require.NoError(t, err)
+		_, err = server.Next((&eap.Packet{
+			Code:       eap.CodeResponse,
+			Identifier: request[1] + 1,
+			Type:       eap.TypeIdentity,
+			Data:       []byte("imsi"),
+		}).Encode())
+		require.Error(t, err)
+	})
+}
+
+func TestDecode(t *testing.T) {
+	request := (&eap.Packet{Code: eap.CodeRequest, Identifier: 7, Type: eap.TypeIdentity}).Encode()
+	require.Equal(t, []byte{1, 7, 0, 5, 1}, request)
+	p, err := eap.Decode(append(request, 0xff))
+	require.NoError(t, err)
+	require.Equal(t, &eap.Packet{Code: eap.CodeRequest, Identifier: 7, Type: eap.TypeIdentity, Data: []byte{}}, p)
+
+	require.Equal(t, []byte{3, 7, 0, 4}, eap.Success(7))
+	require.Equal(t, []byte{4, 7, 0, 4}, eap.Failure(7))
+
+	for _, b := range [][]byte{{1, 7}, {1, 7, 0, 9, 1}, {2, 7, 0, 4}} {
+		_, err := eap.Decode(b)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package eap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EAP codes, RFC 3748 4
+const (
+	CodeRequest  uint8 = 1
+	CodeResponse uint8 = 2
+	CodeSuccess  uint8 = 3
+	CodeFailure  uint8 = 4
+)
+
+// EAP types, RFC 3748 5 and RFC 5448 5
+const (
+	TypeIdentity uint8 = 1
+	TypeAKAPrime uint8 = 50
+)
+
+// headerLength is the length of the header of an EAP packet, code, identifier and length
+const headerLength = 4
+
+// Packet is an EAP packet, RFC 3748 4. The type and its data are only carried by the requests
+// and responses.
+type Packet struct {
+	Code       uint8
+	Identifier uint8
+	Type       uint8
+	Data       []byte
+}
+
+// Decode decodes the EAP packet, failing if it is shorter than its length
+func Decode(b []byte) (*Packet, error) {
+	if len(b) < headerLength {
+		return nil, fmt.Errorf("EAP packet of %d bytes too short", len(b))
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < headerLength || length > len(b) {
+		return nil, fmt.Errorf("EAP packet length %d invalid for %d bytes", length, len(b))
+	}
+	p := &Packet{Code: b[0], Identifier: b[1]}
+	if p.Code != CodeRequest && p.Code != CodeResponse {
+		return p, nil
+	}
+	if length == headerLength {
+		return nil, fmt.Errorf("EAP %s without type", codeName(p.Code))
+	}
+	p.Type = b[headerLength]
+	p.Data = b[headerLength+1 : length]
+	return p, nil
+}
+
+// Encode encodes the EAP packet
+func (p *Packet) Encode() []byte {
+	length := headerLength
+	if p.Code == CodeRequest || p.Code == CodeResponse {
+		length += 1 + len(p.Data)
+	}
+	b := make([]byte, length)
+	b[0] = p.Code
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:4], uint16(length))
+	if length > headerLength {
+		b[headerLength] = p.Type
+		copy(b[headerLength+1:], p.Data)
+	}
+	return b
+}
+
+// Success returns the EAP-Success ending the authentication of the request of the identifier
+func Success(identifier uint8) []byte {
+	return (&Packet{Code: CodeSuccess, Identifier: identifier}).Encode()
+}
+
+// Failure returns the EAP-Failure ending the authentication of the request of the identifier
+func Failure(identifier uint8) []byte {
+	return (&Packet{Code: CodeFailure, Identifier: identifier}).Encode()
+}
+
+// IdentityRequest returns the EAP-Request/Identity of the identifier
+func IdentityRequest(identifier uint8) []byte {
+	return (&Packet{Code: CodeRequest, Identifier: identifier, Type: TypeIdentity}).Encode()
+}
+
+// Authenticator is the EAP server authenticating the UE of a session, the EAP messages being
+// relayed to the UE by the SMF
+type Authenticator interface {
+	// Start returns the first EAP request to the UE
+	Start() ([]byte, error)
+	// Next returns the EAP message answering the EAP response of the UE, the authentication
+	// being complete once it is an EAP-Success or an EAP-Failure
+	Next(response []byte) ([]byte, error)
+}
+
+func codeName(code uint8) string {
+	switch code {
+	case CodeRequest:
+		return "Request"
+	case CodeResponse:
+		return "Response"
+	case CodeSuccess:
+		return "Success"
+	case CodeFailure:
+		return "Failure"
+	}
+	return fmt.Sprintf("code %d", code)
+}
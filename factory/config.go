@@ -12,6 +12,7 @@ package factory
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"strconv"
@@ -53,6 +54,35 @@ type Info struct {
 	Description string `yaml:"description,omitempty"`
 }
 
+// MaxDSCP is the highest DSCP value, a 6-bit field of the IP header
+const MaxDSCP = 63
+
+// MaxPPI is the highest paging policy indicator, a 3-bit value. TS 29.518 6.1.6.3.2
+const MaxPPI = 7
+
+// MaxQoSFlows is the highest number of QoS flows of a PDU session, identified by a 6-bit QFI.
+// TS 23.501 5.7.1.1
+const MaxQoSFlows = 64
+
+const (
+	DEFAULT_PFCP_MTU = 1500
+	// minimum MTU of an IPv6 link
+	MIN_PFCP_MTU = 1280
+	// seconds of the Downlink Data Notification throttling window if not configured
+	DEFAULT_DDN_THROTTLING_WINDOW = 10
+	// enterprise ID of the enterprise specific PFCP IEs if not configured, the enterprise number
+	// reserved for documentation by RFC 5612
+	DEFAULT_PFCP_ENTERPRISE_ID = 32473
+	// smallest PFCP socket buffer accepted, below it bursts of messages are dropped
+	MIN_PFCP_SOCKET_BUFFER = 64 * 1024
+	// load level in percent from which the NWDAF predicts a UPF congested if not configured
+	DEFAULT_NWDAF_LOAD_LEVEL_THRESHOLD = 80
+	// seconds a congestion prediction of the NWDAF without expiry is applied if not configured
+	DEFAULT_NWDAF_PREDICTION_VALIDITY = 300
+	// rating group of the usage of the sessions charged at the CHF if not configured
+	DEFAULT_CHF_RATING_GROUP = 1
+)
+
 const (
 	SMF_DEFAULT_IPV4     = "127.0.0.2"
 	SMF_DEFAULT_PORT     = "8000"
@@ -82,17 +112,127 @@ type Configuration struct {
 	SmfName                  string               `yaml:"smfName,omitempty"`
 	SmfDbName                string               `yaml:"smfDBName,omitempty"`
 	SNssaiInfo               []SnssaiInfoItem     `yaml:"snssaiInfos,omitempty"`
+	SnssaiFilter             *SnssaiFilter        `yaml:"snssaiFilter,omitempty"`
 	StaticIpInfo             []StaticIpInfo       `yaml:"staticIpInfo"`
+	UpSecurityInfo           []UpSecurityInfo     `yaml:"upSecurityInfo,omitempty"`
 	ServiceNameList          []string             `yaml:"serviceNameList,omitempty"`
 	EnterpriseList           map[string]string    `yaml:"enterpriseList,omitempty"`
 	KafkaInfo                KafkaInfo            `yaml:"kafkaInfo,omitempty"`
 	UserPlaneInformation     UserPlaneInformation `yaml:"userplane_information"`
 	NrfCacheEvictionInterval int                  `yaml:"nrfCacheEvictionInterval"`
 	DebugProfilePort         int                  `yaml:"debugProfilePort,omitempty"`
-	EnableNrfCaching         bool                 `yaml:"enableNrfCaching"`
-	EnableDbStore            bool                 `yaml:"enableDBStore,omitempty"`
-	EnableUpfAdapter         bool                 `yaml:"enableUPFAdapter,omitempty"`
-	ULCL                     bool                 `yaml:"ulcl,omitempty"`
+	// port of the liveness and readiness probes, separate from the SBI
+	HealthProbePort  int  `yaml:"healthProbePort,omitempty"`
+	EnableNrfCaching bool `yaml:"enableNrfCaching"`
+	EnableDbStore    bool `yaml:"enableDBStore,omitempty"`
+	EnableUpfAdapter bool `yaml:"enableUPFAdapter,omitempty"`
+	ULCL             bool `yaml:"ulcl,omitempty"`
+	// CDR files of the released sessions, none written if not set
+	CDR *CDRConfig `yaml:"cdr,omitempty"`
+	// CHF charging the sessions, none if not set
+	Chf *ChfConfig `yaml:"chf,omitempty"`
+	// analytics of the NWDAF the SMF subscribes to, none if not set
+	Nwdaf *NwdafConfig `yaml:"nwdaf,omitempty"`
+	// NSSAAF authenticating the UEs for the slices requiring NSSAA, none if not set
+	Nssaaf *NssaafConfig `yaml:"nssaaf,omitempty"`
+	// audit log of the allocations and releases of the UE IPs and of the creations, modifications
+	// and releases of the sessions, none if not set
+	AuditLog *AuditLogConfig `yaml:"auditLog,omitempty"`
+	// PCF discovered with a DNS SRV lookup instead of the NRF, if set
+	PcfSrvDiscovery *SrvDiscoveryConfig `yaml:"pcfSrvDiscovery,omitempty"`
+	// registration to the NRF at the startup, immediate if not set
+	Startup *StartupConfig `yaml:"startup,omitempty"`
+	// time the UE IP of a released session is held for its re-establishment, freed at once if not set
+	IpReleaseGracePeriodSec int `yaml:"ipReleaseGracePeriodSec,omitempty"`
+	// caching of the resolutions of the UPF hostnames, default TTLs if not set
+	DNSCache *DNSCacheConfig `yaml:"dnsCache,omitempty"`
+	// handling of the requested S-NSSAIs without SD, wildcard if not set
+	SdlessSnssai string `yaml:"sdlessSnssai,omitempty"`
+	// IP addresses of the SMFs allowed to transfer their sessions to this SMF, none if not set
+	SmContextTransferPeers []string `yaml:"smContextTransferPeers,omitempty"`
+	// EAP-AKA' authentication of the UEs establishing sessions over trusted non-3GPP access
+	EapAkaPrimeNon3gppAccess bool `yaml:"eapAkaPrimeNon3gppAccess,omitempty"`
+}
+
+// Handlings of the requested S-NSSAIs without SD
+const (
+	// a missing SD matches the slices of the SST with any SD
+	SdlessSnssaiWildcard = "wildcard"
+	// the sessions requested without SD are rejected
+	SdlessSnssaiReject = "reject"
+)
+
+// SnssaiFilter restricts the slices of the snssaiInfos served by the SMF
+type SnssaiFilter struct {
+	// slices served, all if empty
+	Allow []models.Snssai `yaml:"allow,omitempty"`
+	// slices not served, even if allowed
+	Deny []models.Snssai `yaml:"deny,omitempty"`
+}
+
+// NwdafConfig is the subscription of the SMF to the analytics of an NWDAF
+type NwdafConfig struct {
+	Uri string `yaml:"uri"`
+	// load level in percent from which a UPF is predicted congested, 80 if not set
+	LoadLevelThreshold int32 `yaml:"loadLevelThreshold,omitempty"`
+	// seconds a congestion prediction without expiry is applied, 300 if not set
+	PredictionValiditySec int `yaml:"predictionValiditySec,omitempty"`
+}
+
+// NssaafConfig is the NSSAAF the SMF asks for the network slice-specific authentication and
+// authorization of the UEs establishing a session in the slices
+type NssaafConfig struct {
+	Uri string `yaml:"uri"`
+	// slices requiring NSSAA
+	Snssais []models.Snssai `yaml:"snssais"`
+}
+
+// CDRConfig is the output of the CDR files, encoded in ASN.1 BER per TS 32.298
+type CDRConfig struct {
+	Directory string `yaml:"directory"`
+	// records written per file, 100 if not set
+	RecordsPerFile int `yaml:"recordsPerFile,omitempty"`
+}
+
+// ChfConfig is the CHF of the converged charging of the sessions. TS 32.291
+type ChfConfig struct {
+	Uri string `yaml:"uri"`
+	// rating group of the usage of the sessions, 1 if not set
+	RatingGroup uint32 `yaml:"ratingGroup,omitempty"`
+}
+
+// AuditLogConfig is the audit log of the UE IPs and of the session lifecycle events, in JSON lines
+type AuditLogConfig struct {
+	// regular file dedicated to the audit log, only appended to
+	File string `yaml:"file,omitempty"`
+	// operator ID of the session events, the PLMN ID of the serving network of the session if not set
+	OperatorId string `yaml:"operatorId,omitempty"`
+}
+
+// DNSCacheConfig is the caching of the resolutions of the UPF hostnames
+type DNSCacheConfig struct {
+	// seconds a resolution is used before resolving the hostname again, 60 if not set
+	TTLSec int `yaml:"ttlSec,omitempty"`
+	// seconds an expired resolution is still used while the resolver fails, 300 if not set
+	StaleTTLSec int `yaml:"staleTtlSec,omitempty"`
+}
+
+// StartupConfig defers the registration of the SMF to the NRF at the startup
+type StartupConfig struct {
+	// registration deferred until a UPF is associated for each DNN
+	WaitForUpfs bool `yaml:"waitForUpfs,omitempty"`
+	// seconds the registration is deferred at most, 60 if not set
+	UpfTimeoutSec int `yaml:"upfTimeoutSec,omitempty"`
+}
+
+// SrvDiscoveryConfig is the DNS SRV lookup of the instances of an NF
+type SrvDiscoveryConfig struct {
+	// SRV record, e.g. _npcf-smpolicycontrol._tcp.pcf.5gc.mnc001.mcc001.3gppnetwork.org
+	Name string `yaml:"name"`
+	// scheme of the URIs of the targets, http if not set
+	Scheme string `yaml:"scheme,omitempty"`
+	// DNS server host:port, the first nameserver of /etc/resolv.conf if not set
+	Server string `yaml:"server,omitempty"`
 }
 
 type StaticIpInfo struct {
@@ -100,17 +240,232 @@ type StaticIpInfo struct {
 	Dnn        string            `yaml:"dnn"`
 }
 
+// UP integrity protection policies applied to the Security Indication sent to the RAN
+const (
+	UpIntegrityRequired  = "required"
+	UpIntegrityPreferred = "preferred"
+	UpIntegrityNotNeeded = "not-needed"
+)
+
+type UpSecurityInfo struct {
+	Dnn         string `yaml:"dnn"`
+	UpIntegrity string `yaml:"upIntegrity"` // required, preferred or not-needed
+}
+
 type SnssaiInfoItem struct {
 	SNssai   *models.Snssai      `yaml:"sNssai"`
 	PlmnId   models.PlmnId       `yaml:"plmnId"`
 	DnnInfos []SnssaiDnnInfoItem `yaml:"dnnInfos"`
+	// Priority of the slice when several slices match a request without SD, higher value wins.
+	// The UPFs of the slice are preferred, the session keeps its requested S-NSSAI.
+	Priority int32 `yaml:"priority,omitempty"`
 }
 
 type SnssaiDnnInfoItem struct {
-	Dnn      string `yaml:"dnn"`
-	DNS      DNS    `yaml:"dns"`
-	UESubnet string `yaml:"ueSubnet"`
-	MTU      uint16 `yaml:"mtu"`
+	Dnn        string        `yaml:"dnn"`
+	DNS        DNS           `yaml:"dns"`
+	UESubnet   string        `yaml:"ueSubnet"`
+	MTU        uint16        `yaml:"mtu"`
+	DefaultQos DnnDefaultQos `yaml:"defaultQos,omitempty"`
+	TSNConfig  *TSNConfig    `yaml:"tsnConfig,omitempty"`
+	Retry      *RetryConfig  `yaml:"retry,omitempty"`
+	// handling of the establishments while none of the UPFs of the DNN is associated
+	UPFUnavailable *UPFUnavailableConfig `yaml:"upfUnavailable,omitempty"`
+	// UPFs anchoring the sessions of the DNN, in order of preference
+	PreferredUPFs []string `yaml:"preferredUpfs,omitempty"`
+	// accept the IPv6 PDU sessions as IPv4 ones, the UE pool being IPv4 only
+	FallbackToIPv4 bool `yaml:"fallbackToIPv4,omitempty"`
+	// HTTP headers inserted by the anchor UPF in the uplink traffic of the DNN
+	HeaderEnrichment []HeaderEnrichmentRule `yaml:"headerEnrichment,omitempty"`
+	// QCI of the EPS bearers mapped from the QoS flows of the DNN, by 5QI. Overrides the
+	// standardized mapping, and is required for the non-standardized 5QIs.
+	FiveQIToQCIMapping map[int32]int32 `yaml:"fiveQiToQciMapping,omitempty"`
+	// handling of the sessions whose radio bearer could not be set up by the AN, BufferAndWait
+	// or ImmediateRelease, BufferAndWait if not set
+	SessionContinuityMode string `yaml:"sessionContinuityMode,omitempty"`
+	// SRv6 segments the uplink traffic of the DNN traverses on N6, such as a service chain
+	SRv6SteeringPolicy *SRv6SteeringPolicy `yaml:"srv6SteeringPolicy,omitempty"`
+	// subnets routed behind the UEs of the DNN, by SUPI, in the Framed-Route format of RFC 2865:
+	// "prefix/length [gateway [metric]]"
+	FramedRoutes map[string][]string `yaml:"framedRoutes,omitempty"`
+	// packet delay monitoring of the QoS flows of the sessions by the anchor UPF
+	QoSMonitoring *QoSMonitoringConfig `yaml:"qosMonitoring,omitempty"`
+	// paging policy indicator sent to the AMF when paging the UE for downlink data, by DSCP of
+	// the packet buffered by the UPF. TS 23.501 5.4.3.2
+	PagingPolicyIndicators map[uint8]uint8 `yaml:"pagingPolicyIndicators,omitempty"`
+	// limit of the concurrent sessions of the DNN, not limited if not set
+	SessionLimit *SessionLimitConfig `yaml:"sessionLimit,omitempty"`
+	// maximum QoS flows of the sessions of the DNN, the PCC rules of the lowest priority beyond
+	// it are rejected. Up to MaxQoSFlows if not set
+	MaxQoSFlows int `yaml:"maxQosFlows,omitempty"`
+	// PDU sessions carried by the DNN, IP or Ethernet, IP if not set. The Ethernet DNNs have no
+	// UE subnet. The IP domains of the config pod are IP DNNs
+	PDUSessionType string `yaml:"pduSessionType,omitempty"`
+	// application IDs of the PCC rules of the services exempt from the 3GPP PS data off of the
+	// UEs, such as IMS, the flows of the other PCC rules being blocked while it is activated.
+	// TS 23.501 5.24
+	PsDataOffExemptApps []string `yaml:"psDataOffExemptApps,omitempty"`
+	// steering of the traffic of the Multi-Access PDU sessions of the DNN over the 3GPP and
+	// non-3GPP accesses by the anchor UPF, not steered if not set. TS 23.501 5.32
+	ATSSS *ATSSSConfig `yaml:"atsss,omitempty"`
+}
+
+// PDU session types of a DNN
+const (
+	DnnPDUSessionTypeIP       = "IP"
+	DnnPDUSessionTypeEthernet = "Ethernet"
+)
+
+// Session continuity modes, on the failure of the radio bearer of a session
+const (
+	// the session is kept, the UPF buffers its downlink data until the UE is paged
+	SessionContinuityBufferAndWait = "BufferAndWait"
+	// the session is released
+	SessionContinuityImmediateRelease = "ImmediateRelease"
+)
+
+// SRv6SteeringPolicy steers the N6 traffic of the sessions through SRv6 segments, the IPv6
+// segment IDs being listed in the order they are traversed
+type SRv6SteeringPolicy struct {
+	SegmentList []string `yaml:"segmentList"`
+}
+
+// HeaderEnrichmentRule is an HTTP header inserted in the uplink traffic of the sessions.
+// The value is a template where {supi} and {msisdn} are replaced by the identities of the UE.
+type HeaderEnrichmentRule struct {
+	HeaderName  string `yaml:"headerName"`
+	HeaderValue string `yaml:"headerValue"`
+}
+
+// RetryConfig is the retry policy of the session establishments of a DNN failing on a
+// retriable error, such as a UPF not answering the PFCP session establishment. Each retry is
+// run on another UPF serving the DNN, after the backoff.
+type RetryConfig struct {
+	// attempts of the establishment, including the first one
+	MaxAttempts int `yaml:"maxAttempts"`
+	// delay in ms before the first retry, doubled on each retry
+	BackoffMs int `yaml:"backoffMs,omitempty"`
+}
+
+// Policies of the session establishments of a DNN none of whose UPFs is associated
+const (
+	// the establishment is rejected at once
+	UPFUnavailableReject = "reject"
+	// the UPF selection is retried until a UPF is associated, then the establishment rejected
+	UPFUnavailableRetryWithBackoff = "retry-with-backoff"
+)
+
+// SessionLimitConfig limits the concurrent sessions of a DNN. The establishments beyond the limit
+// wait in a queue for the release of a session, and are rejected when the queue is full or when
+// they waited longer than the queue timeout.
+type SessionLimitConfig struct {
+	MaxConcurrentSessions int `yaml:"maxConcurrentSessions"`
+	// establishments waiting for a session to be released, rejected at once if 0
+	QueueDepth int `yaml:"queueDepth,omitempty"`
+	// time in ms an establishment waits in the queue
+	QueueTimeoutMs int `yaml:"queueTimeoutMs,omitempty"`
+	// at the limit, a session whose ARP may preempt takes the slot of a preemptable session of a
+	// lower ARP priority, which is released
+	Preemption bool `yaml:"preemption,omitempty"`
+}
+
+// UPFUnavailableConfig is the policy of the session establishments of a DNN while none of its
+// UPFs is associated
+type UPFUnavailableConfig struct {
+	// reject or retry-with-backoff
+	Policy string `yaml:"policy"`
+	// attempts of the UPF selection with retry-with-backoff, including the first one
+	MaxAttempts int `yaml:"maxAttempts,omitempty"`
+	// delay in ms before the first retry, doubled on each retry up to 1s. The retries end after
+	// 5s whatever the attempts left.
+	BackoffMs int `yaml:"backoffMs,omitempty"`
+}
+
+// Measured packet delays of the QoS monitoring
+const (
+	QoSMonitoringUplink    = "uplink"
+	QoSMonitoringDownlink  = "downlink"
+	QoSMonitoringRoundTrip = "round-trip"
+)
+
+// Reporting frequencies of the QoS monitoring
+const (
+	// reported when a threshold is reached, at most once per minimum wait time
+	QoSMonitoringEventTriggered = "event-triggered"
+	// reported every period
+	QoSMonitoringPeriodic = "periodic"
+	// reported when the session is released
+	QoSMonitoringSessionRelease = "session-release"
+)
+
+// QoSMonitoringConfig is the monitoring of the packet delay of the QoS flows of the sessions of
+// a DNN, measured by the anchor UPF and reported to the SMF. TS 23.501 5.33.3
+type QoSMonitoringConfig struct {
+	// measured delays, uplink, downlink and/or round-trip
+	Directions []string `yaml:"directions"`
+	// event-triggered, periodic and/or session-release
+	ReportingFrequencies []string `yaml:"reportingFrequencies"`
+	// reporting period in seconds, required with periodic
+	PeriodSec uint32 `yaml:"periodSec,omitempty"`
+	// thresholds in ms of the measured delays, required with event-triggered
+	UplinkThresholdMs    uint32 `yaml:"uplinkThresholdMs,omitempty"`
+	DownlinkThresholdMs  uint32 `yaml:"downlinkThresholdMs,omitempty"`
+	RoundTripThresholdMs uint32 `yaml:"roundTripThresholdMs,omitempty"`
+	// minimum time in seconds between two event-triggered reports
+	MinimumWaitTimeSec uint32 `yaml:"minimumWaitTimeSec,omitempty"`
+}
+
+// ATSSSConfig is the steering of the traffic of the Multi-Access PDU sessions of a DNN
+type ATSSSConfig struct {
+	// atsss-ll or mptcp, atsss-ll if not set
+	SteeringFunctionality string `yaml:"steeringFunctionality,omitempty"`
+	// active-standby, smallest-delay, load-balancing or priority-based
+	SteeringMode string `yaml:"steeringMode"`
+	// access carrying the traffic in active-standby and priority-based modes, only 3gpp as the
+	// SMF sets up no non-3GPP user plane
+	ActiveAccess string `yaml:"activeAccess,omitempty"`
+	// percentage of the traffic sent over 3GPP access in load-balancing mode
+	TGPPAccessWeight uint8 `yaml:"tgppAccessWeight,omitempty"`
+}
+
+// Steering functionalities of the ATSSS
+const (
+	ATSSSSteeringFunctionalityATSSSLL = "atsss-ll"
+	ATSSSSteeringFunctionalityMPTCP   = "mptcp"
+)
+
+// Steering modes of the ATSSS
+const (
+	ATSSSModeActiveStandby = "active-standby"
+	ATSSSModeSmallestDelay = "smallest-delay"
+	ATSSSModeLoadBalancing = "load-balancing"
+	ATSSSModePriorityBased = "priority-based"
+)
+
+// Accesses of the ATSSS
+const (
+	ATSSSAccess3GPP    = "3gpp"
+	ATSSSAccessNon3GPP = "non-3gpp"
+)
+
+// TSNConfig is the DS-TT/NW-TT port configuration of a DNN acting as a 5GS TSN bridge
+type TSNConfig struct {
+	// port management information, delay in ns
+	TxPropagationDelay uint32 `yaml:"txPropagationDelay"`
+	// IEEE 802.1AS parameters
+	GptpDomainNumber    uint8 `yaml:"gptpDomainNumber"`
+	LogSyncInterval     int8  `yaml:"logSyncInterval"`
+	LogAnnounceInterval int8  `yaml:"logAnnounceInterval"`
+	Priority1           uint8 `yaml:"priority1"`
+	Priority2           uint8 `yaml:"priority2"`
+}
+
+// DnnDefaultQos is the QoS applied to sessions of the DNN when no policy could be retrieved from PCF
+type DnnDefaultQos struct {
+	SessionAmbrUplink   string `yaml:"sessionAmbrUplink"`
+	SessionAmbrDownlink string `yaml:"sessionAmbrDownlink"`
+	Var5qi              int32  `yaml:"5qi"`
+	ArpPriorityLevel    int32  `yaml:"arpPriorityLevel"`
 }
 
 type Sbi struct {
@@ -120,16 +475,77 @@ type Sbi struct {
 	// IPv6Addr string `yaml:"ipv6Addr,omitempty"`
 	BindingIPv4 string `yaml:"bindingIPv4,omitempty"` // IP used to run the server in the node.
 	Port        int    `yaml:"port,omitempty"`
+	// URI advertised to the NRF and in the callbacks when it differs from the bind address, e.g.
+	// behind a NAT, registerIPv4 and port used if not set
+	AdvertisedURI string `yaml:"advertisedUri,omitempty"`
+	// rate limiting of the N11 requests, none if not set
+	RateLimit *RateLimit `yaml:"rateLimit,omitempty"`
+}
+
+// RateLimit limits the N11 requests of each AMF with a token bucket, the AMFs being identified
+// by their source IP
+type RateLimit struct {
+	// buckets of the AMFs by IP address, the default one applying to the other AMFs
+	Amfs    map[string]TokenBucket `yaml:"amfs,omitempty"`
+	Default TokenBucket            `yaml:"default"`
+}
+
+// TokenBucket holds up to Burst requests, refilled by Rate requests per second.
+// A bucket without burst does not limit the requests.
+type TokenBucket struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
 }
 
 type TLS struct {
 	PEM string `yaml:"pem,omitempty"`
 	Key string `yaml:"key,omitempty"`
+	// TLS versions and cipher suites of the SBI server and clients, Go defaults if not set
+	Policy *TLSPolicy `yaml:"policy,omitempty"`
 }
 
 type PFCP struct {
 	Addr string `yaml:"addr,omitempty"`
 	Port uint16 `yaml:"port,omitempty"`
+	// DSCP marking of the outgoing PFCP packets, 0-63
+	DSCP uint8 `yaml:"dscp,omitempty"`
+	// MTU of the N4 path, larger session establishments are split over several messages
+	MTU uint16 `yaml:"mtu,omitempty"`
+	// SO_RCVBUF and SO_SNDBUF of the PFCP socket in bytes, system default if not set
+	RecvBufferSize int `yaml:"recvBufferSize,omitempty"`
+	SendBufferSize int `yaml:"sendBufferSize,omitempty"`
+	// workers handling the received PFCP messages, the messages of a UPF being handled in order
+	// by the same worker. A goroutine is started per message if not set.
+	Workers int `yaml:"workers,omitempty"`
+	// seconds during which the duplicate Downlink Data Notifications of a session are not
+	// paging the UE again, 10 if not set
+	DDNThrottlingWindow int `yaml:"ddnThrottlingWindow,omitempty"`
+	// seconds between the usage reports of the traffic of each session sent by the anchor UPF,
+	// the sessions are not reported if not set
+	UsageReportingPeriod int `yaml:"usageReportingPeriod,omitempty"`
+	// maximum PDRs and QERs of the PFCP session of a UPF, the policies of a session needing more
+	// are rejected before any PFCP message is sent. Not limited if not set.
+	MaxPDRsPerSession int `yaml:"maxPdrsPerSession,omitempty"`
+	MaxQERsPerSession int `yaml:"maxQersPerSession,omitempty"`
+	// interval in ms below which the smoothed interval of the session reports of a UPF signals its
+	// congestion, not detected if not set
+	CongestionReportIntervalMs int `yaml:"congestionReportIntervalMs,omitempty"`
+	// fraction of the heartbeat interval, below 1, by which each heartbeat after the first one of
+	// an association is randomly advanced or delayed. The heartbeats are evenly spaced if not set.
+	HeartbeatJitter float64 `yaml:"heartbeatJitter,omitempty"`
+	// rate limiting of the PFCP session requests sent to each UPF, none if not set
+	RateLimit *PFCPRateLimit `yaml:"rateLimit,omitempty"`
+	// IANA private enterprise number of the UPF vendor, carried by the enterprise specific IEs
+	// (SRv6 steering, secondary RAT usage, UPF load), DEFAULT_PFCP_ENTERPRISE_ID if not set
+	EnterpriseID uint16 `yaml:"enterpriseId,omitempty"`
+}
+
+// PFCPRateLimit limits the PFCP session requests sent to each UPF with a token bucket, the
+// requests exceeding the rate being queued
+type PFCPRateLimit struct {
+	TokenBucket `yaml:",inline"`
+	// ms a request is queued at most before it is dropped, 1000 if not set
+	QueueDeadlineMs int `yaml:"queueDeadlineMs,omitempty"`
 }
 
 type DNS struct {
@@ -192,8 +608,16 @@ type RoutingConfig struct {
 
 // UserPlaneInformation describe core network userplane information
 type UserPlaneInformation struct {
-	UPNodes map[string]UPNode `yaml:"up_nodes"`
-	Links   []UPLink          `yaml:"links"`
+	UPNodes   map[string]UPNode `yaml:"up_nodes"`
+	TEIDRange *TEIDRange        `yaml:"teidRange,omitempty"`
+	Links     []UPLink          `yaml:"links"`
+}
+
+// TEIDRange bounds the GTP-U TEIDs the gNBs are expected to choose, the TEIDs outside it are
+// tracked and logged
+type TEIDRange struct {
+	Min uint32 `yaml:"min"`
+	Max uint32 `yaml:"max"`
 }
 
 // UPNode represent the user plane node
@@ -204,9 +628,74 @@ type UPNode struct {
 	Dnn                  string                     `yaml:"dnn"`
 	SNssaiInfos          []models.SnssaiUpfInfoItem `yaml:"sNssaiUpfInfos,omitempty"`
 	InterfaceUpfInfoList []InterfaceUpfInfoItem     `yaml:"interfaces,omitempty"`
-	Port                 uint16                     `yaml:"port"`
+	// role of the UPF for the DNNs it serves, primary (default) or backup
+	DnnRoles map[string]string `yaml:"dnnRoles,omitempty"`
+	// tracking areas whose sessions the UPF anchors in preference to the other UPFs
+	Tais []models.Tai `yaml:"tais,omitempty"`
+	Port uint16       `yaml:"port"`
+	// the UPF steers the N6 traffic with SRv6 segment lists
+	SRv6 bool `yaml:"srv6,omitempty"`
+	// type of the Node ID of the SMF in the PFCP association with the UPF, fqdn or ip, ip if not
+	// set whatever the type of the node ID of the UPF
+	NodeIDType string `yaml:"nodeIdType,omitempty"`
+	// TEIDs of the UPF allocated by the SMF, chosen by the UPF if not set
+	TEIDPartition *TEIDPartition `yaml:"teidPartition,omitempty"`
+}
+
+// TEIDPartition is the range [base, base+size-1] of the GTP-U TEIDs of a UPF shared by several
+// SMFs, each SMF allocating the TEIDs of its own partition
+type TEIDPartition struct {
+	Base uint32 `yaml:"base"`
+	Size uint32 `yaml:"size"`
 }
 
+// Validate checks that the partition is not empty, does not include the reserved TEID 0 and
+// does not exceed the 32-bit TEID space
+func (p *TEIDPartition) Validate() error {
+	if p.Base == 0 {
+		return fmt.Errorf("TEID partition base 0 reserved")
+	}
+	if p.Size == 0 {
+		return fmt.Errorf("empty TEID partition")
+	}
+	if uint64(p.Base)+uint64(p.Size)-1 > math.MaxUint32 {
+		return fmt.Errorf("TEID partition [base %d, size %d] exceeds the 32-bit TEID space", p.Base, p.Size)
+	}
+	return nil
+}
+
+// validate checks the settings of the configuration the schema cannot
+func (c *Configuration) validate() error {
+	if err := c.validateTEIDPartitions(); err != nil {
+		return err
+	}
+	if c.Sbi != nil && c.Sbi.TLS != nil && c.Sbi.TLS.Policy != nil {
+		if err := c.Sbi.TLS.Policy.Validate(); err != nil {
+			return fmt.Errorf("SBI TLS policy: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateTEIDPartitions checks the TEID partitions of the UPFs
+func (c *Configuration) validateTEIDPartitions() error {
+	for name, node := range c.UserPlaneInformation.UPNodes {
+		if node.TEIDPartition == nil {
+			continue
+		}
+		if err := node.TEIDPartition.Validate(); err != nil {
+			return fmt.Errorf("UPF [%s]: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Node ID types of the SMF in the PFCP association with a UPF
+const (
+	NodeIDTypeFQDN = "fqdn"
+	NodeIDTypeIP   = "ip"
+)
+
 type InterfaceUpfInfoItem struct {
 	NetworkInstance string                 `yaml:"networkInstance"`
 	InterfaceType   models.UpInterfaceType `yaml:"interfaceType"`
@@ -218,10 +707,14 @@ type UPLink struct {
 	B string `yaml:"B"`
 }
 
-var ConfigPodTrigger chan bool
+// ConfigPodTrigger carries the configs received from the config pod
+var ConfigPodTrigger chan *Configuration
+
+// OnConfigParseError, when set, is called for each config update from the config pod that cannot be parsed
+var OnConfigParseError func(err error)
 
 func init() {
-	ConfigPodTrigger = make(chan bool, 1)
+	ConfigPodTrigger = make(chan *Configuration, 1)
 }
 
 func (c *Config) GetVersion() string {
@@ -247,22 +740,39 @@ func (c *Config) UpdateConfig(commChannel chan *protos.NetworkSliceResponse) boo
 		cfgNew := Configuration{}
 		if err := cfgNew.parseRocConfig(rsp); err != nil {
 			logger.GrpcLog.Errorf("config update error: %v \n", err.Error())
+			if OnConfigParseError != nil {
+				OnConfigParseError(err)
+			}
 			continue
 		}
 
-		// updates UpdatedSmfConfig struct to be consumed by SMF config update routine.
-		compareAndProcessConfigs(c.Configuration, &cfgNew)
+		// compared with the running config and applied by the SMF config update routine, one
+		// update at a time
+		ConfigPodTrigger <- &cfgNew
+	}
+}
+
+// placeholderUpfNames are UPF names left by unfinished site configurations,
+// which would otherwise become UPFs the SMF keeps trying to associate with
+var placeholderUpfNames = map[string]bool{
+	"0.0.0.0":     true,
+	"::":          true,
+	"none":        true,
+	"null":        true,
+	"undefined":   true,
+	"placeholder": true,
+}
 
-		// Update SMF's config copy for future compare
-		// Acquire Lock before update as SMF main go-routine might be
-		// still processing initial config and we don't want to update it in middle
-		SmfConfigSyncLock.Lock()
-		c.Configuration.SNssaiInfo = cfgNew.SNssaiInfo
-		c.Configuration.UserPlaneInformation = cfgNew.UserPlaneInformation
-		SmfConfigSyncLock.Unlock()
-		// Send trigger to update SMF Context
-		ConfigPodTrigger <- true
+// validateUpfName rejects an empty UPF name, or one of the known placeholders
+func validateUpfName(name string) error {
+	trimmed := strings.TrimSpace(name)
+	if trimmed == "" {
+		return fmt.Errorf("UPF name is empty")
 	}
+	if placeholderUpfNames[strings.ToLower(trimmed)] {
+		return fmt.Errorf("UPF name [%s] is a placeholder", name)
+	}
+	return nil
 }
 
 // Update level-1 Configuration(Not actual SMF config structure used by SMF)
@@ -359,6 +869,10 @@ func (c *Configuration) parseRocConfig(rsp *protos.NetworkSliceResponse) error {
 			nodeStr = ns.Site.Upf.UpfName[:strings.LastIndex(ns.Site.Upf.UpfName, ":")]
 		}
 
+		if err := validateUpfName(nodeStr); err != nil {
+			return fmt.Errorf("network slice [%s]: %v", ns.Name, err)
+		}
+
 		ns.Site.Upf.UpfName = nodeStr
 		// iterate through UPFs config received
 		upf := UPNode{
@@ -408,11 +922,14 @@ func (c *Configuration) parseRocConfig(rsp *protos.NetworkSliceResponse) error {
 	return nil
 }
 
-func compareAndProcessConfigs(smfCfg, newCfg *Configuration) {
+// compareAndProcessConfigs stores the changes of newCfg from smfCfg in UpdatedSmfConfig, returns
+// true if the network slices, UP nodes, links or enterprises changed
+func compareAndProcessConfigs(smfCfg, newCfg *Configuration) (changed bool) {
 	// compare Network slices
 	match, addSlices, modSlices, delSlices := compareNetworkSlices(smfCfg.SNssaiInfo, newCfg.SNssaiInfo)
 
 	if !match {
+		changed = true
 		logger.CfgLog.Infof("changes in network slice config")
 
 		if len(delSlices) > 0 {
@@ -440,6 +957,7 @@ func compareAndProcessConfigs(smfCfg, newCfg *Configuration) {
 	match, addUPNodes, modUPNodes, delUPNodes := compareUPNodesConfigs(smfCfg.UserPlaneInformation.UPNodes, newCfg.UserPlaneInformation.UPNodes)
 
 	if !match {
+		changed = true
 		logger.CfgLog.Infof("changes in user plane config")
 
 		if len(delUPNodes) > 0 {
@@ -467,6 +985,7 @@ func compareAndProcessConfigs(smfCfg, newCfg *Configuration) {
 	match, addLinks, delLinks := compareGenericSlices(smfCfg.UserPlaneInformation.Links,
 		newCfg.UserPlaneInformation.Links, compareUPLinks)
 	if !match {
+		changed = true
 		logger.CfgLog.Infof("changes in UP nodes links config")
 
 		if s := addLinks.([]UPLink); len(s) > 0 {
@@ -483,11 +1002,16 @@ func compareAndProcessConfigs(smfCfg, newCfg *Configuration) {
 	}
 
 	// Enterprise Name
+	if !reflect.DeepEqual(smfCfg.EnterpriseList, newCfg.EnterpriseList) {
+		changed = true
+		logger.CfgLog.Infoln("changes in enterprise config")
+	}
 	UpdatedSmfConfig.EnterpriseList = &newCfg.EnterpriseList
+	return changed
 }
 
 func compareNsDnn(c1, c2 interface{}) bool {
-	return c1.(SnssaiDnnInfoItem) == c2.(SnssaiDnnInfoItem)
+	return reflect.DeepEqual(c1.(SnssaiDnnInfoItem), c2.(SnssaiDnnInfoItem))
 }
 
 func compareUPLinks(c1, c2 interface{}) bool {
@@ -503,7 +1027,10 @@ func compareUPNode(u1, u2 UPNode) bool {
 	if u1.ANIP == u2.ANIP &&
 		u1.Dnn == u2.Dnn &&
 		u1.NodeID == u2.NodeID &&
-		u1.Type == u2.Type {
+		u1.Type == u2.Type &&
+		reflect.DeepEqual(u1.DnnRoles, u2.DnnRoles) &&
+		reflect.DeepEqual(u1.Tais, u2.Tais) &&
+		reflect.DeepEqual(u1.TEIDPartition, u2.TEIDPartition) {
 		if match, _, _, _ := compareUPNetworkSlices(u1.SNssaiInfos, u2.SNssaiInfos); !match {
 			return false
 		}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package factory
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/omec-project/smf/logger"
+	"gopkg.in/yaml.v2"
+)
+
+// configSchema is the JSON Schema of the SMF configuration. The validation supports the
+// keywords of the schema: $ref to its definitions, type, properties, required,
+// additionalProperties, items, enum, minimum, maximum, minLength, pattern and minItems.
+//
+//go:embed config_schema.json
+var configSchema []byte
+
+// ValidationError is a violation of the configuration schema
+type ValidationError struct {
+	// JSON path of the invalid value, e.g. $.configuration.pfcp.dscp
+	Path string
+	// keyword of the schema violated, e.g. maximum
+	Constraint string
+	Message    string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *json.Number           `json:"minimum"`
+	Maximum              *json.Number           `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	Pattern              string                 `json:"pattern"`
+	MinItems             *int                   `json:"minItems"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+}
+
+// schemaTypes is the type keyword, a type or a list of types
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// ValidateConfigSchema validates the configuration in JSON against the schema of the SMF
+// configuration, and returns one error per violation found
+func ValidateConfigSchema(rawJSON []byte) []ValidationError {
+	var schema jsonSchema
+	if err := json.Unmarshal(configSchema, &schema); err != nil {
+		return []ValidationError{{Path: "$", Constraint: "schema", Message: fmt.Sprintf("invalid schema: %v", err)}}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(rawJSON))
+	decoder.UseNumber()
+	var config interface{}
+	if err := decoder.Decode(&config); err != nil {
+		return []ValidationError{{Path: "$", Constraint: "json", Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	v := schemaValidator{root: &schema}
+	v.validate("$", &schema, config)
+	return v.errors
+}
+
+type schemaValidator struct {
+	root   *jsonSchema
+	errors []ValidationError
+}
+
+func (v *schemaValidator) fail(path, constraint, format string, args ...interface{}) {
+	v.errors = append(v.errors, ValidationError{Path: path, Constraint: constraint, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *schemaValidator) validate(path string, schema *jsonSchema, value interface{}) {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/definitions/")
+		ref, ok := v.root.Definitions[name]
+		if !ok {
+			v.fail(path, "$ref", "unknown schema reference %s", schema.Ref)
+			return
+		}
+		schema = ref
+	}
+
+	if len(schema.Type) > 0 && !slices.ContainsFunc(schema.Type, func(t string) bool { return isSchemaType(t, value) }) {
+		v.fail(path, "type", "expected %s, got %s", strings.Join(schema.Type, " or "), jsonTypeName(value))
+		return
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(e interface{}) bool { return fmt.Sprint(e) == fmt.Sprint(value) }) {
+		v.fail(path, "enum", "%v is not one of %v", value, schema.Enum)
+		return
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				v.fail(path+"."+name, "required", "missing required property")
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(value)) {
+			if property, ok := schema.Properties[name]; ok {
+				v.validate(path+"."+name, property, value[name])
+			} else if schema.AdditionalProperties != nil {
+				v.validate(path+"."+name, schema.AdditionalProperties, value[name])
+			}
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			v.fail(path, "minItems", "expected at least %d items, got %d", *schema.MinItems, len(value))
+		}
+		if schema.Items != nil {
+			for i, item := range value {
+				v.validate(fmt.Sprintf("%s[%d]", path, i), schema.Items, item)
+			}
+		}
+	case json.Number:
+		n, _ := value.Float64()
+		if schema.Minimum != nil {
+			if minimum, _ := schema.Minimum.Float64(); n < minimum {
+				v.fail(path, "minimum", "%s is less than the minimum %s", value, *schema.Minimum)
+			}
+		}
+		if schema.Maximum != nil {
+			if maximum, _ := schema.Maximum.Float64(); n > maximum {
+				v.fail(path, "maximum", "%s is greater than the maximum %s", value, *schema.Maximum)
+			}
+		}
+	case string:
+		if schema.MinLength != nil && len([]rune(value)) < *schema.MinLength {
+			v.fail(path, "minLength", "expected at least %d characters", *schema.MinLength)
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err != nil {
+				v.fail(path, "pattern", "invalid pattern %s: %v", schema.Pattern, err)
+			} else if !re.MatchString(value) {
+				v.fail(path, "pattern", "%q does not match %s", value, schema.Pattern)
+			}
+		}
+	}
+}
+
+func isSchemaType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil || !strings.ContainsAny(n.String(), ".eE")
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	default:
+		return jsonTypeName(value) == schemaType
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// yamlToJSON converts a YAML document to JSON, for its validation against the schema
+func yamlToJSON(content []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonCompatible(document))
+}
+
+// jsonCompatible converts the maps of a YAML document to maps with string keys
+func jsonCompatible(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			converted[i] = jsonCompatible(item)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+// validateConfigContent validates the YAML configuration against the schema, logging each
+// violation
+func validateConfigContent(location string, content []byte) error {
+	rawJSON, err := yamlToJSON(content)
+	if err != nil {
+		return err
+	}
+	validationErrors := ValidateConfigSchema(rawJSON)
+	if len(validationErrors) == 0 {
+		return nil
+	}
+	for _, validationError := range validationErrors {
+		logger.CfgLog.Errorf("invalid configuration %s, %s violated: %v", location, validationError.Constraint, validationError)
+	}
+	return fmt.Errorf("configuration %s does not match its schema: %v", location, validationErrors[0])
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package factory
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validSchemaConfig = `
+info:
+  version: 1.0.0
+configuration:
+  pfcp:
+    addr: 127.0.0.1
+    dscp: 46
+  snssaiInfos:
+    - sNssai:
+        sst: 1
+        sd: "010203"
+      dnnInfos:
+        - dnn: internet
+          ueSubnet: 10.250.0.0/16
+  userplane_information:
+    up_nodes:
+      gNB1:
+        type: AN
+      UPF:
+        type: UPF
+        node_id: 127.0.0.8
+    links:
+      - A: gNB1
+        B: UPF
+`
+
+func schemaErrors(t *testing.T, config string) []ValidationError {
+	rawJSON, err := yamlToJSON([]byte(config))
+	if err != nil {
+		t.Fatalf("error converting config: %v", err)
+	}
+	return ValidateConfigSchema(rawJSON)
+}
+
+func TestValidateConfigSchemaSampleConfigs(t *testing.T) {
+	for _, location := range []string{"../config/smfcfg.yaml", "../config/smfcfg_with_custom_webui_url.yaml"} {
+		content, err := os.ReadFile(location)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", location, err)
+		}
+		assert.Empty(t, schemaErrors(t, string(content)), location)
+	}
+	assert.Empty(t, schemaErrors(t, validSchemaConfig))
+}
+
+func TestValidateConfigSchemaInvalid(t *testing.T) {
+	config := `
+info:
+  version: "1.0"
+configuration:
+  pfcp:
+    dscp: 64
+  sbi:
+    scheme: ftp
+    port: "29502"
+  snssaiInfos:
+    - sNssai:
+        sst: one
+        sd: "0102"
+      dnnInfos:
+        - ueSubnet: 10.250.0.0
+          sessionLimit:
+            maxConcurrentSessions: 0
+  userplane_information:
+    up_nodes:
+      UPF:
+        type: SMF
+    links:
+      - A: gNB1
+`
+	expected := []ValidationError{
+		{Path: "$.configuration.pfcp.addr", Constraint: "required"},
+		{Path: "$.configuration.pfcp.dscp", Constraint: "maximum"},
+		{Path: "$.configuration.sbi.port", Constraint: "type"},
+		{Path: "$.configuration.sbi.scheme", Constraint: "enum"},
+		{Path: "$.configuration.snssaiInfos[0].dnnInfos[0].dnn", Constraint: "required"},
+		{Path: "$.configuration.snssaiInfos[0].dnnInfos[0].sessionLimit.maxConcurrentSessions", Constraint: "minimum"},
+		{Path: "$.configuration.snssaiInfos[0].dnnInfos[0].ueSubnet", Constraint: "pattern"},
+		{Path: "$.configuration.snssaiInfos[0].sNssai.sd", Constraint: "pattern"},
+		{Path: "$.configuration.snssaiInfos[0].sNssai.sst", Constraint: "type"},
+		{Path: "$.configuration.userplane_information.links[0].B", Constraint: "required"},
+		{Path: "$.configuration.userplane_information.up_nodes.UPF.type", Constraint: "enum"},
+		{Path: "$.info.version", Constraint: "pattern"},
+	}
+
+	errs := schemaErrors(t, config)
+	if assert.Len(t, errs, len(expected)) {
+		for i := range expected {
+			assert.Equal(t, expected[i].Path, errs[i].Path)
+			assert.Equal(t, expected[i].Constraint, errs[i].Constraint, errs[i].Path)
+			assert.NotEmpty(t, errs[i].Message)
+		}
+	}
+}
+
+func TestValidateConfigSchemaMissingConfiguration(t *testing.T) {
+	errs := schemaErrors(t, "info:\n  version: 1.0.0\n")
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "$.configuration", errs[0].Path)
+		assert.Equal(t, "required", errs[0].Constraint)
+	}
+}
+
+func TestInitConfigFactorySchemaInvalid(t *testing.T) {
+	location := t.TempDir() + "/smfcfg.yaml"
+	config := validSchemaConfig + "  nrfCacheEvictionInterval: -1\n"
+	if err := os.WriteFile(location, []byte(config), 0o600); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	err := InitConfigFactory(location)
+	assert.ErrorContains(t, err, "$.configuration.nrfCacheEvictionInterval")
+
+	if err = os.WriteFile(location, []byte(validSchemaConfig), 0o600); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	assert.NoError(t, InitConfigFactory(location))
+}
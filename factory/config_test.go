@@ -33,6 +33,36 @@ func TestUpdateSliceInfo(t *testing.T) {
 	compareAndProcessConfigs(&cfg1, &cfg2)
 }
 
+func TestParseRocConfigInvalidUpfName(t *testing.T) {
+	for _, name := range []string{"", "  ", ":8805", "0.0.0.0", "none"} {
+		cfg := Configuration{}
+		rsp := makeDummyConfig("1", "010203")
+		rsp.NetworkSlice[0].Site.Upf.UpfName = name
+
+		err := cfg.parseRocConfig(rsp)
+		if err == nil {
+			t.Errorf("expected UPF name [%s] to be rejected", name)
+			continue
+		}
+		assert.Contains(t, err.Error(), "Enterprise-1")
+		if _, ok := cfg.UserPlaneInformation.UPNodes[name]; ok {
+			t.Errorf("UPF name [%s] added to the UP nodes", name)
+		}
+	}
+}
+
+func TestParseRocConfigIpDomainWithoutUePool(t *testing.T) {
+	cfg := Configuration{}
+	rsp := makeDummyConfig("1", "010203")
+	rsp.NetworkSlice[0].DeviceGroup[0].IpDomainDetails.UePool = ""
+
+	if err := cfg.parseRocConfig(rsp); err != nil {
+		t.Fatalf("error parsing config: %v", err)
+	}
+	// an IP DNN, its missing UE pool rejected as such by the UE IP allocator
+	assert.Empty(t, cfg.SNssaiInfo[0].DnnInfos[0].PDUSessionType)
+}
+
 func makeDummyConfig(sst, sd string) *protos.NetworkSliceResponse {
 	var rsp protos.NetworkSliceResponse
 
@@ -457,3 +487,21 @@ func TestGetCustomWebuiUrl(t *testing.T) {
 	want := "myspecialwebui:9872"
 	assert.Equal(t, got, want, "The webui URL is not correct.")
 }
+
+func TestTEIDPartitionValidate(t *testing.T) {
+	assert.NoError(t, (&TEIDPartition{Base: 1, Size: 0xffffffff}).Validate())
+	assert.NoError(t, (&TEIDPartition{Base: 0xffffff00, Size: 0x100}).Validate())
+	assert.Error(t, (&TEIDPartition{Base: 0, Size: 10}).Validate())
+	assert.Error(t, (&TEIDPartition{Base: 10, Size: 0}).Validate())
+	// beyond the 32-bit TEID space
+	assert.Error(t, (&TEIDPartition{Base: 0xffffff00, Size: 0x101}).Validate())
+
+	cfg := Configuration{
+		UserPlaneInformation: UserPlaneInformation{
+			UPNodes: map[string]UPNode{
+				"UPF": {Type: "UPF", TEIDPartition: &TEIDPartition{Base: 2, Size: 0xffffffff}},
+			},
+		},
+	}
+	assert.ErrorContains(t, cfg.validateTEIDPartitions(), "UPF [UPF]")
+}
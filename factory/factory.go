@@ -34,12 +34,22 @@ func InitConfigFactory(f string) error {
 	if content, err := os.ReadFile(f); err != nil {
 		return err
 	} else {
+		if schemaErr := validateConfigContent(f, content); schemaErr != nil {
+			return schemaErr
+		}
+
 		SmfConfig = Config{}
 
 		if yamlErr := yaml.Unmarshal(content, &SmfConfig); yamlErr != nil {
 			return yamlErr
 		}
 
+		if SmfConfig.Configuration != nil {
+			if err := SmfConfig.Configuration.validate(); err != nil {
+				return err
+			}
+		}
+
 		if SmfConfig.Configuration.WebuiUri == "" {
 			SmfConfig.Configuration.WebuiUri = "webui:9876"
 		}
@@ -66,6 +76,46 @@ func InitRoutingConfigFactory(f string) error {
 	return nil
 }
 
+// LoadConfigFile re-reads and validates the config file, whose network slices, UP nodes, links
+// and enterprises are to be applied to the SMF context as the config pod updates. The other
+// sections are applied at restart.
+func (c *Config) LoadConfigFile() (*Configuration, error) {
+	content, err := os.ReadFile(c.CfgLocation)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateConfigContent(c.CfgLocation, content); err != nil {
+		return nil, err
+	}
+	cfgNew := Config{}
+	if err = yaml.Unmarshal(content, &cfgNew); err != nil {
+		return nil, err
+	}
+	if cfgNew.Configuration == nil {
+		return nil, fmt.Errorf("no configuration in %s", c.CfgLocation)
+	}
+	if err = cfgNew.Configuration.validate(); err != nil {
+		return nil, err
+	}
+	return cfgNew.Configuration, nil
+}
+
+// StageConfigUpdate stores the changes of the network slices, UP nodes, links and enterprises of
+// cfgNew in UpdatedSmfConfig, to be applied to the SMF context, and keeps these sections for the
+// next compare. Returns false if none of them changed.
+func (c *Config) StageConfigUpdate(cfgNew *Configuration) bool {
+	// compared and updated as a whole, a change is applied once
+	SmfConfigSyncLock.Lock()
+	defer SmfConfigSyncLock.Unlock()
+	if !compareAndProcessConfigs(c.Configuration, cfgNew) {
+		return false
+	}
+	c.Configuration.SNssaiInfo = cfgNew.SNssaiInfo
+	c.Configuration.UserPlaneInformation = cfgNew.UserPlaneInformation
+	c.Configuration.EnterpriseList = cfgNew.EnterpriseList
+	return true
+}
+
 func CheckConfigVersion() error {
 	currentVersion := SmfConfig.GetVersion()
 
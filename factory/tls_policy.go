@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package factory
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+)
+
+// TLSPolicy restricts the TLS versions and cipher suites of the SBI connections, both of the
+// server and of the clients of the SMF
+type TLSPolicy struct {
+	// "1.2" or "1.3", TLS 1.2 if not set
+	MinVersion string `yaml:"minVersion,omitempty"`
+	// "1.2" or "1.3", the latest version if not set
+	MaxVersion string `yaml:"maxVersion,omitempty"`
+	// IANA identifiers of the TLS 1.2 cipher suites, the default ones if not set. The TLS 1.3
+	// cipher suites are not configurable.
+	CipherSuites []uint16 `yaml:"cipherSuites,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipher suites of which HTTP/2 requires one over TLS 1.2, RFC 7540 9.2.2
+var http2RequiredCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", version)
+	}
+	if v < tls.VersionTLS12 {
+		return 0, fmt.Errorf("TLS version %s not allowed, 1.2 or later required", version)
+	}
+	return v, nil
+}
+
+// Validate checks the policy, TLS 1.1 and older versions and insecure cipher suites being rejected
+func (p *TLSPolicy) Validate() error {
+	return p.Apply(&tls.Config{})
+}
+
+// Apply restricts the TLS configuration to the versions and cipher suites of the policy
+func (p *TLSPolicy) Apply(config *tls.Config) error {
+	minVersion := uint16(tls.VersionTLS12)
+	if p.MinVersion != "" {
+		v, err := parseTLSVersion(p.MinVersion)
+		if err != nil {
+			return fmt.Errorf("minVersion: %w", err)
+		}
+		minVersion = v
+	}
+	var maxVersion uint16
+	if p.MaxVersion != "" {
+		v, err := parseTLSVersion(p.MaxVersion)
+		if err != nil {
+			return fmt.Errorf("maxVersion: %w", err)
+		}
+		if v < minVersion {
+			return fmt.Errorf("maxVersion %s lower than minVersion %s", p.MaxVersion, p.MinVersion)
+		}
+		maxVersion = v
+	}
+
+	if len(p.CipherSuites) != 0 {
+		secure := make(map[uint16]bool)
+		for _, suite := range tls.CipherSuites() {
+			secure[suite.ID] = true
+		}
+		for _, id := range p.CipherSuites {
+			if !secure[id] {
+				return fmt.Errorf("cipher suite 0x%04x (%s) unknown or insecure", id, tls.CipherSuiteName(id))
+			}
+		}
+		if minVersion < tls.VersionTLS13 && !slices.ContainsFunc(p.CipherSuites, func(id uint16) bool {
+			return slices.Contains(http2RequiredCipherSuites, id)
+		}) {
+			return fmt.Errorf("cipher suites without TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or " +
+				"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 required by HTTP/2 over TLS 1.2")
+		}
+	}
+
+	config.MinVersion = minVersion
+	config.MaxVersion = maxVersion
+	config.CipherSuites = p.CipherSuites
+	return nil
+}
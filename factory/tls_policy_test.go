@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package factory
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSPolicyApply(t *testing.T) {
+	config := &tls.Config{}
+	policy := &TLSPolicy{
+		MinVersion:   "1.2",
+		MaxVersion:   "1.3",
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	}
+	assert.NoError(t, policy.Apply(config))
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MaxVersion)
+	assert.Equal(t, policy.CipherSuites, config.CipherSuites)
+
+	// TLS 1.2 minimum by default
+	config = &tls.Config{}
+	assert.NoError(t, (&TLSPolicy{}).Apply(config))
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Zero(t, config.MaxVersion)
+}
+
+func TestTLSPolicyValidate(t *testing.T) {
+	for name, policy := range map[string]TLSPolicy{
+		"TLS 1.0":          {MinVersion: "1.0"},
+		"TLS 1.1":          {MinVersion: "1.1"},
+		"TLS 1.1 maximum":  {MaxVersion: "1.1"},
+		"unknown version":  {MinVersion: "1.4"},
+		"max below min":    {MinVersion: "1.3", MaxVersion: "1.2"},
+		"insecure cipher":  {CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_RC4_128_SHA}},
+		"unknown cipher":   {CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, 0xffff}},
+		"no HTTP/2 cipher": {CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}},
+	} {
+		assert.Error(t, policy.Validate(), name)
+	}
+
+	// TLS 1.3 only, the cipher suites required by HTTP/2 over TLS 1.2 are not needed
+	assert.NoError(t, (&TLSPolicy{
+		MinVersion:   "1.3",
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+	}).Validate())
+
+	cfg := Configuration{Sbi: &Sbi{TLS: &TLS{Policy: &TLSPolicy{MinVersion: "1.1"}}}}
+	assert.ErrorContains(t, cfg.validate(), "SBI TLS policy")
+}
@@ -93,15 +93,12 @@ func EmptyEventHandler(event SmEvent, eventData *SmEventData) (smf_context.SMCon
 
 func HandleStateInitEventPduSessCreate(event SmEvent, eventData *SmEventData) (smf_context.SMContextState, error) {
 	if err := producer.HandlePDUSessionSMContextCreate(eventData.Txn); err != nil {
-		err := stats.PublishMsgEvent(mi.Smf_msg_type_pdu_sess_create_rsp_failure)
-		errorMessage := ""
-		if err != nil {
-			logger.FsmLog.Errorf("error while publishing pdu session create response failure, %v", err.Error())
-			errorMessage = err.Error()
+		if pubErr := stats.PublishMsgEvent(mi.Smf_msg_type_pdu_sess_create_rsp_failure); pubErr != nil {
+			logger.FsmLog.Errorf("error while publishing pdu session create response failure, %v", pubErr.Error())
 		}
 		txn := eventData.Txn.(*transaction.Transaction)
 		txn.Err = err
-		return smf_context.SmStateInit, fmt.Errorf("pdu session create: %v", errorMessage)
+		return smf_context.SmStateInit, fmt.Errorf("pdu session create: %w", err)
 	}
 
 	err := stats.PublishMsgEvent(mi.Smf_msg_type_pdu_sess_create_rsp_success)
@@ -115,18 +112,12 @@ func HandleStatePfcpCreatePendingEventPfcpSessCreate(event SmEvent, eventData *S
 	txn := eventData.Txn.(*transaction.Transaction)
 	smCtxt := txn.Ctxt.(*smf_context.SMContext)
 
-	producer.SendPFCPRules(smCtxt)
-	smCtxt.SubFsmLog.Debug("waiting for pfcp session establish response")
-	switch <-smCtxt.SBIPFCPCommunicationChan {
-	case smf_context.SessionEstablishSuccess:
-		smCtxt.SubFsmLog.Debug("pfcp session establish response success")
-		return smf_context.SmStateN1N2TransferPending, nil
-	case smf_context.SessionEstablishFailed:
-		fallthrough
-	default:
-		smCtxt.SubFsmLog.Errorf("pfcp session establish response failure")
-		return smf_context.SmStatePfcpCreatePending, fmt.Errorf("pfcp establishment failure")
+	if err := producer.EstablishPfcpSession(smCtxt); err != nil {
+		smCtxt.SubFsmLog.Errorf("pfcp session establish failure, %v", err)
+		return smf_context.SmStatePfcpCreatePending, fmt.Errorf("pfcp establishment failure: %w", err)
 	}
+	smCtxt.SubFsmLog.Debug("pfcp session establish response success")
+	return smf_context.SmStateN1N2TransferPending, nil
 }
 
 func HandleStateN1N2TransferPendingEventN1N2Transfer(event SmEvent, eventData *SmEventData) (smf_context.SMContextState, error) {
@@ -31,7 +31,7 @@ func (SmfTxnFsm) TxnDecode(txn *transaction.Transaction) (transaction.TxnEvent,
 func (SmfTxnFsm) TxnLoadCtxt(txn *transaction.Transaction) (transaction.TxnEvent, error) {
 	switch txn.MsgType {
 	case svcmsgtypes.CreateSmContext:
-		req := txn.Req.(models.PostSmContextsRequest)
+		req := txn.Req.(producer.CreateSmContextRequest)
 		createData := req.JsonData
 		if smCtxtRef, err := smf_context.ResolveRef(createData.Supi, createData.PduSessionId); err == nil {
 			// Previous context exist
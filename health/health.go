@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	utilLogger "github.com/omec-project/util/logger"
+)
+
+// Components checked by the readiness probe
+const (
+	ComponentNRF          = "nrf"
+	ComponentUPF          = "upf"
+	ComponentSessionStore = "sessionStore"
+)
+
+// time given to the session store to answer the readiness probe
+var SessionStoreTimeout = 2 * time.Second
+
+// NotReady is a component of the SMF not ready to serve the sessions
+type NotReady struct {
+	Component string `json:"component"`
+	Reason    string `json:"reason"`
+}
+
+// Readiness is the body of the readiness probe
+type Readiness struct {
+	Status   string     `json:"status"`
+	NotReady []NotReady `json:"notReady,omitempty"`
+}
+
+// CheckReadiness returns the components not ready: the registration to the NRF, the PFCP
+// association of each configured UPF and, if the sessions are stored, the session store
+func CheckReadiness(ctx context.Context) []NotReady {
+	var notReady []NotReady
+	smfSelf := smf_context.SMF_Self()
+	if !smfSelf.NrfRegistered.Load() {
+		notReady = append(notReady, NotReady{Component: ComponentNRF, Reason: "not registered to the NRF"})
+	}
+
+	if upi := smfSelf.UserPlaneInformation; upi != nil {
+		var upfs []string
+		for name, upNode := range upi.UPFs {
+			if upNode.UPF == nil || upNode.UPF.UPFStatus != smf_context.AssociatedSetUpSuccess {
+				upfs = append(upfs, name)
+			}
+		}
+		slices.Sort(upfs)
+		for _, name := range upfs {
+			notReady = append(notReady, NotReady{
+				Component: ComponentUPF,
+				Reason:    fmt.Sprintf("UPF[%s] PFCP association not established", name),
+			})
+		}
+	}
+
+	if factory.SmfConfig.Configuration != nil && factory.SmfConfig.Configuration.EnableDbStore {
+		ctx, cancel := context.WithTimeout(ctx, SessionStoreTimeout)
+		defer cancel()
+		if err := smf_context.PingSessionStore(ctx); err != nil {
+			notReady = append(notReady, NotReady{
+				Component: ComponentSessionStore,
+				Reason:    fmt.Sprintf("session store not reachable: %v", err),
+			})
+		}
+	}
+	return notReady
+}
+
+// CheckUPFsPerDNN returns the DNNs of the slices served without a UPF associated, the readiness
+// required at the startup before the SMF is advertised to the NRF
+func CheckUPFsPerDNN() []NotReady {
+	smfSelf := smf_context.SMF_Self()
+	upi := smfSelf.UserPlaneInformation
+	var dnns []string
+	for _, snssaiInfo := range smfSelf.SnssaiInfos {
+		for dnn := range snssaiInfo.DnnInfos {
+			if !slices.Contains(dnns, dnn) {
+				dnns = append(dnns, dnn)
+			}
+		}
+	}
+	slices.Sort(dnns)
+
+	var notReady []NotReady
+	for _, dnn := range dnns {
+		associated := false
+		if upi != nil {
+			for _, upNode := range upi.UPFs {
+				if upNode.UPF != nil && upNode.UPF.UPFStatus == smf_context.AssociatedSetUpSuccess &&
+					upNode.UPF.IsDnnConfigured(dnn) {
+					associated = true
+					break
+				}
+			}
+		}
+		if !associated {
+			notReady = append(notReady, NotReady{
+				Component: ComponentUPF,
+				Reason:    fmt.Sprintf("no UPF associated for DNN[%s]", dnn),
+			})
+		}
+	}
+	return notReady
+}
+
+// AwaitUPFsPerDNN polls every interval until a UPF is associated for each DNN, it returns the
+// DNNs still without a UPF after the timeout
+func AwaitUPFsPerDNN(timeout, interval time.Duration) []NotReady {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		notReady := CheckUPFsPerDNN()
+		if len(notReady) == 0 {
+			return nil
+		}
+		select {
+		case <-deadline.C:
+			return notReady
+		case <-ticker.C:
+		}
+	}
+}
+
+// HTTPLiveness answers 200 as long as the process runs
+func HTTPLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// HTTPReadiness answers 200 when all the components are ready, 503 with the components not
+// ready otherwise
+func HTTPReadiness(c *gin.Context) {
+	notReady := CheckReadiness(c.Request.Context())
+	if len(notReady) > 0 {
+		c.JSON(http.StatusServiceUnavailable, Readiness{Status: "not ready", NotReady: notReady})
+		return
+	}
+	c.JSON(http.StatusOK, Readiness{Status: "ready"})
+}
+
+// NewRouter returns the router of the probes
+func NewRouter() *gin.Engine {
+	router := utilLogger.NewGinWithZap(logger.GinLog)
+	router.GET("/healthz", HTTPLiveness)
+	router.GET("/readyz", HTTPReadiness)
+	return router
+}
+
+// Run serves the probes on the port, separately from the SBI
+func Run(port int) {
+	addr := fmt.Sprintf(":%d", port)
+	logger.InitLog.Infof("serving the health probes on %s", addr)
+	if err := http.ListenAndServe(addr, NewRouter()); err != nil {
+		logger.InitLog.Errorf("health probe server failed: %v", err)
+	}
+}
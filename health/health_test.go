@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func newProbeTestSMF(t *testing.T) *smf_context.UserPlaneInformation {
+	t.Helper()
+	smfSelf := smf_context.SMF_Self()
+	origPingSessionStore := smf_context.PingSessionStore
+	t.Cleanup(func() {
+		smf_context.PingSessionStore = origPingSessionStore
+		smfSelf.NrfRegistered.Store(false)
+	})
+	contexttest.SetConfiguration(t, &factory.Configuration{})
+
+	snssai := &models.Snssai{Sst: 1, Sd: "010203"}
+	upi := contexttest.NewUserPlane(t, "10.210.0.100", map[string]factory.UPNode{
+		"upf1": contexttest.UPF("10.210.0.1", snssai),
+		"upf2": contexttest.UPF("10.210.0.2", snssai),
+	})
+	// the tests associate the UPFs themselves
+	for _, upf := range upi.UPFs {
+		upf.UPF.UPFStatus = smf_context.NotAssociated
+	}
+	contexttest.SetUserPlane(t, upi)
+	return upi
+}
+
+func probe(t *testing.T, path string) (int, Readiness) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	NewRouter().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	var body Readiness
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	return recorder.Code, body
+}
+
+func TestReadinessPartial(t *testing.T) {
+	upi := newProbeTestSMF(t)
+	factory.SmfConfig.Configuration.EnableDbStore = true
+	pingErr := errors.New("connection refused")
+	smf_context.PingSessionStore = func(context.Context) error { return pingErr }
+
+	// registered to the NRF, upf1 associated, upf2 not and the session store down
+	smf_context.SMF_Self().NrfRegistered.Store(true)
+	upi.UPFs["upf1"].UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+
+	code, body := probe(t, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, Readiness{
+		Status: "not ready",
+		NotReady: []NotReady{
+			{Component: ComponentUPF, Reason: "UPF[upf2] PFCP association not established"},
+			{Component: ComponentSessionStore, Reason: "session store not reachable: connection refused"},
+		},
+	}, body)
+
+	// the process is alive meanwhile
+	code, body = probe(t, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "alive", body.Status)
+
+	// all the components ready
+	upi.UPFs["upf2"].UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+	pingErr = nil
+	code, body = probe(t, "/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, Readiness{Status: "ready"}, body)
+}
+
+func TestReadinessNotRegistered(t *testing.T) {
+	upi := newProbeTestSMF(t)
+	for _, upNode := range upi.UPFs {
+		upNode.UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+	}
+
+	// the session store is not checked when the sessions are not stored
+	smf_context.PingSessionStore = func(context.Context) error {
+		t.Fatal("session store checked")
+		return nil
+	}
+	code, body := probe(t, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, []NotReady{{Component: ComponentNRF, Reason: "not registered to the NRF"}}, body.NotReady)
+}
+
+func setProbeTestDNNs(t *testing.T, dnns ...string) {
+	t.Helper()
+	dnnInfos := make(map[string]*smf_context.SnssaiSmfDnnInfo)
+	for _, dnn := range dnns {
+		dnnInfos[dnn] = &smf_context.SnssaiSmfDnnInfo{}
+	}
+	contexttest.SetSnssaiInfos(t, []smf_context.SnssaiSmfInfo{
+		{Snssai: smf_context.SNssai{Sst: 1, Sd: "010203"}, DnnInfos: dnnInfos},
+	})
+}
+
+func TestAwaitUPFsPerDNNDefersRegistration(t *testing.T) {
+	upi := newProbeTestSMF(t)
+	setProbeTestDNNs(t, "internet")
+
+	registered := make(chan []NotReady, 1)
+	go func() {
+		registered <- AwaitUPFsPerDNN(5*time.Second, 10*time.Millisecond)
+	}()
+
+	// no UPF associated, the registration is deferred
+	select {
+	case <-registered:
+		t.Fatal("registered before a UPF is associated")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// one UPF serving the DNN is enough
+	upi.UPFs["upf2"].UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+	select {
+	case notReady := <-registered:
+		require.Empty(t, notReady)
+	case <-time.After(time.Second):
+		t.Fatal("not registered once the UPF is associated")
+	}
+}
+
+func TestAwaitUPFsPerDNNTimeout(t *testing.T) {
+	upi := newProbeTestSMF(t)
+	setProbeTestDNNs(t, "internet", "ims")
+	upi.UPFs["upf1"].UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+
+	// no UPF serves the ims DNN
+	start := time.Now()
+	notReady := AwaitUPFsPerDNN(50*time.Millisecond, 10*time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Equal(t, []NotReady{{Component: ComponentUPF, Reason: "no UPF associated for DNN[ims]"}}, notReady)
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// auditLog records the allocations and releases of the UE IPs and the lifecycle events of the
+// sessions required by the regulated operators, in JSON apart from the logs of the SMF and
+// regardless of their level. Discarded if not enabled.
+var auditLog atomic.Pointer[zap.Logger]
+
+func init() {
+	auditLog.Store(zap.NewNop())
+}
+
+// AuditLog returns the audit log, discarding the records if not enabled
+func AuditLog() *zap.Logger {
+	return auditLog.Load()
+}
+
+// SetAuditLog replaces the audit log, discarding the records if nil, and returns the previous one
+func SetAuditLog(log *zap.Logger) *zap.Logger {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return auditLog.Swap(log)
+}
+
+// InitAuditLog enables the audit log, only appended to the file. The audit log is kept apart from
+// the logs of the SMF, the file being a regular file dedicated to it.
+func InitAuditLog(file string) error {
+	if file == "" {
+		return fmt.Errorf("no audit log file")
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Join(fmt.Errorf("audit log %s is not a regular file", file), f.Close())
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig.LevelKey = ""
+	encoderConfig.CallerKey = ""
+	encoderConfig.MessageKey = "message"
+	encoderConfig.StacktraceKey = ""
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.Lock(f), zap.InfoLevel)
+	log := zap.New(core, zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+	SetAuditLog(log.With(zap.String("component", "SMF"), zap.String("category", "Audit")))
+	return nil
+}
+
+// CloseAuditLog flushes the audit log and disables it
+func CloseAuditLog() {
+	if err := SetAuditLog(nil).Sync(); err != nil {
+		AppLog.Debugf("sync audit log: %v", err)
+	}
+}
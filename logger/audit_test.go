@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitAuditLog(t *testing.T) {
+	t.Cleanup(CloseAuditLog)
+
+	// a dedicated file is required, the audit log not being mixed with other output
+	require.Error(t, InitAuditLog(""))
+	require.Error(t, InitAuditLog(t.TempDir()))
+	require.Error(t, InitAuditLog("/dev/stdout"))
+
+	// the records are appended to the file
+	file := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(file, []byte("{\"eventType\":\"previous\"}\n"), 0o600))
+	require.NoError(t, InitAuditLog(file))
+	AuditLog().Info("session create")
+	CloseAuditLog()
+
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Regexp(t, `^\{"eventType":"previous"\}\n\{"timestamp":"[^"]+","message":"session create","component":"SMF","category":"Audit"\}\n$`, string(content))
+}
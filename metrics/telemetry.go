@@ -11,6 +11,8 @@ package metrics
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/omec-project/smf/logger"
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,8 +28,40 @@ type SmfStats struct {
 	svcUdmMsg   *prometheus.CounterVec
 	sessions    *prometheus.GaugeVec
 	sessProfile *prometheus.GaugeVec
+
+	configUpdateDuration prometheus.Histogram
+	configUpdateErrors   *prometheus.CounterVec
+	availableUeIPs       *prometheus.GaugeVec
+	ueIPPoolAddresses    *prometheus.GaugeVec
+	pendingPfcpRequests  *prometheus.GaugeVec
+	qosMonitoringDelay   *prometheus.GaugeVec
+	sessionQueueDepth    *prometheus.GaugeVec
+	sessionQueueTimeouts *prometheus.CounterVec
+	sessionPreemptions   *prometheus.CounterVec
+	upfCongestionLevel   *prometheus.GaugeVec
+	upfActiveSessions    *prometheus.GaugeVec
+	amfOverloadQueued    *prometheus.GaugeVec
+
+	sessionSetupPhaseDuration *prometheus.HistogramVec
 }
 
+// reasons of the config update errors
+const (
+	ConfigUpdateParseError      = "parse_error"
+	ConfigUpdateValidationError = "validation_error"
+	ConfigUpdateUpfResolveError = "upf_resolve_error"
+)
+
+// phases of the PDU session setup
+const (
+	SessionSetupUdmQuery      = "udm_query"
+	SessionSetupPcfQuery      = "pcf_query"
+	SessionSetupUpfSelection  = "upf_selection"
+	SessionSetupPfcpEstablish = "pfcp_establish"
+	SessionSetupNasEncode     = "nas_encode"
+	SessionSetupAmfNotify     = "amf_notify"
+)
+
 var smfStats *SmfStats
 
 func initSmfStats() *SmfStats {
@@ -66,6 +100,71 @@ func initSmfStats() *SmfStats {
 			Name: "smf_pdu_session_profile",
 			Help: "SMF PDU session Profile",
 		}, []string{"id", "ip", "state", "upf", "enterprise"}),
+
+		configUpdateDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "smf_config_update_duration_seconds",
+			Help: "Time taken to apply a config update to the SMF context",
+		}),
+
+		configUpdateErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smf_config_update_errors_total",
+			Help: "SMF config update errors",
+		}, []string{"reason"}),
+
+		availableUeIPs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_available_ue_ips",
+			Help: "Number of UE IP addresses available for allocation",
+		}, []string{"dnn", "snssai"}),
+
+		ueIPPoolAddresses: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_ue_ip_pool_addresses",
+			Help: "Number of UE IP addresses of the pool of the DNN, by state: used, reserved or quarantined",
+		}, []string{"dnn", "snssai", "state"}),
+
+		pendingPfcpRequests: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_pfcp_pending_requests",
+			Help: "Number of PFCP requests waiting for the response of the UPF",
+		}, []string{"node_id"}),
+
+		qosMonitoringDelay: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_qos_monitoring_packet_delay_ms",
+			Help: "Packet delay of the QoS flows last reported by the UPF QoS monitoring",
+		}, []string{"supi", "pdu_session_id", "qfi", "direction"}),
+
+		sessionQueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_session_queue_depth",
+			Help: "Number of PDU session establishments waiting for a session of the DNN to be released",
+		}, []string{"dnn"}),
+
+		sessionQueueTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smf_session_queue_timeouts_total",
+			Help: "PDU session establishments rejected after waiting in the session queue of the DNN",
+		}, []string{"dnn"}),
+
+		sessionPreemptions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smf_session_preemptions_total",
+			Help: "PDU sessions released at the session limit of the DNN for a session of a higher ARP priority",
+		}, []string{"dnn"}),
+
+		upfCongestionLevel: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_upf_congestion_level",
+			Help: "Congestion threshold of the session report interval over the smoothed interval of the UPF, congested above 1",
+		}, []string{"upf"}),
+
+		upfActiveSessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_upf_active_sessions",
+			Help: "Number of PDU sessions with a PFCP session on the UPF",
+		}, []string{"node_id"}),
+
+		amfOverloadQueued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_amf_overload_queued_sessions",
+			Help: "Number of PDU session establishments delayed until the back-off of the overloaded AMF expires",
+		}, []string{"amf"}),
+
+		sessionSetupPhaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "smf_session_setup_phase_duration_seconds",
+			Help: "Time taken by the phases of the PDU session setup",
+		}, []string{"snssai_sst", "snssai_sd", "dnn", "phase"}),
 	}
 }
 
@@ -91,6 +190,45 @@ func (ps *SmfStats) register() error {
 	if err := prometheus.Register(ps.sessProfile); err != nil {
 		return err
 	}
+	if err := prometheus.Register(ps.configUpdateDuration); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.configUpdateErrors); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.availableUeIPs); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.ueIPPoolAddresses); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.pendingPfcpRequests); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.qosMonitoringDelay); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.sessionQueueDepth); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.sessionQueueTimeouts); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.sessionPreemptions); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.upfCongestionLevel); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.upfActiveSessions); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.amfOverloadQueued); err != nil {
+		return err
+	}
+	if err := prometheus.Register(ps.sessionSetupPhaseDuration); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -145,3 +283,90 @@ func SetSessStats(nodeId string, count uint64) {
 func SetSessProfileStats(id, ip, state, upf, enterprise string, count uint64) {
 	smfStats.sessProfile.WithLabelValues(id, ip, state, upf, enterprise).Set(float64(count))
 }
+
+// ObserveConfigUpdateDuration records the time taken to apply a config update
+func ObserveConfigUpdateDuration(duration time.Duration) {
+	smfStats.configUpdateDuration.Observe(duration.Seconds())
+}
+
+// IncrementConfigUpdateErrors counts a config update error
+func IncrementConfigUpdateErrors(reason string) {
+	smfStats.configUpdateErrors.WithLabelValues(reason).Inc()
+}
+
+// SetAvailableUeIPs maintains the number of UE IP addresses available in the pool of the DNN
+func SetAvailableUeIPs(dnn, snssai string, count uint64) {
+	smfStats.availableUeIPs.WithLabelValues(dnn, snssai).Set(float64(count))
+}
+
+// SetUeIPPoolAddresses maintains the number of UE IP addresses used, reserved and quarantined in
+// the pool of the DNN
+func SetUeIPPoolAddresses(dnn, snssai string, used, reserved, quarantined uint64) {
+	smfStats.ueIPPoolAddresses.WithLabelValues(dnn, snssai, "used").Set(float64(used))
+	smfStats.ueIPPoolAddresses.WithLabelValues(dnn, snssai, "reserved").Set(float64(reserved))
+	smfStats.ueIPPoolAddresses.WithLabelValues(dnn, snssai, "quarantined").Set(float64(quarantined))
+}
+
+// SetPendingPfcpRequests maintains the number of PFCP requests waiting for the response of the UPF
+func SetPendingPfcpRequests(nodeId string, count int) {
+	smfStats.pendingPfcpRequests.WithLabelValues(nodeId).Set(float64(count))
+}
+
+// DeletePendingPfcpRequests removes the number of pending PFCP requests of the removed UPF
+func DeletePendingPfcpRequests(nodeId string) {
+	smfStats.pendingPfcpRequests.DeleteLabelValues(nodeId)
+}
+
+// SetQoSMonitoringPacketDelay maintains the packet delay of a QoS flow of the session, direction
+// being uplink, downlink or round-trip
+func SetQoSMonitoringPacketDelay(supi string, pduSessionID int32, qfi uint8, direction string, delayMs uint32) {
+	smfStats.qosMonitoringDelay.WithLabelValues(supi, strconv.Itoa(int(pduSessionID)), strconv.Itoa(int(qfi)),
+		direction).Set(float64(delayMs))
+}
+
+// DeleteQoSMonitoringPacketDelays removes the packet delays of the QoS flows of the released session
+func DeleteQoSMonitoringPacketDelays(supi string, pduSessionID int32) {
+	smfStats.qosMonitoringDelay.DeletePartialMatch(prometheus.Labels{
+		"supi":           supi,
+		"pdu_session_id": strconv.Itoa(int(pduSessionID)),
+	})
+}
+
+// SetSessionQueueDepth maintains the number of establishments waiting in the session queue of the DNN
+func SetSessionQueueDepth(dnn string, depth int) {
+	smfStats.sessionQueueDepth.WithLabelValues(dnn).Set(float64(depth))
+}
+
+// IncrementSessionQueueTimeouts counts the establishments rejected on the session queue timeout of
+// the DNN
+func IncrementSessionQueueTimeouts(dnn string) {
+	smfStats.sessionQueueTimeouts.WithLabelValues(dnn).Inc()
+}
+
+// IncrementSessionPreemptions counts the sessions preempted at the session limit of the DNN
+func IncrementSessionPreemptions(dnn string) {
+	smfStats.sessionPreemptions.WithLabelValues(dnn).Inc()
+}
+
+// SetUPFCongestionLevel maintains the congestion level of the UPF detected from its session
+// report intervals
+func SetUPFCongestionLevel(upf string, level float64) {
+	smfStats.upfCongestionLevel.WithLabelValues(upf).Set(level)
+}
+
+// SetUPFActiveSessions maintains the number of sessions with a PFCP session on the UPF
+func SetUPFActiveSessions(nodeId string, count int64) {
+	smfStats.upfActiveSessions.WithLabelValues(nodeId).Set(float64(count))
+}
+
+// SetAMFOverloadQueuedSessions maintains the number of establishments delayed by the back-off of
+// the overloaded AMF
+func SetAMFOverloadQueuedSessions(amf string, queued int) {
+	smfStats.amfOverloadQueued.WithLabelValues(amf).Set(float64(queued))
+}
+
+// ObserveSessionSetupPhaseDuration records the time taken by a phase of the setup of a PDU session
+// of the slice and DNN
+func ObserveSessionSetupPhaseDuration(sst int32, sd, dnn, phase string, duration time.Duration) {
+	smfStats.sessionSetupPhaseDuration.WithLabelValues(strconv.Itoa(int(sst)), sd, dnn, phase).Observe(duration.Seconds())
+}
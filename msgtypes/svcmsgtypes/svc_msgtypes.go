@@ -16,6 +16,7 @@ const (
 	ReleaseSmContext      SmfMsgType = "ReleaseSmContext"
 	NotifySmContextStatus SmfMsgType = "NotifySmContextStatus"
 	RetrieveSmContext     SmfMsgType = "RetrieveSmContext"
+	TransferSmContext     SmfMsgType = "TransferSmContext"
 	NsmfPDUSessionCreate  SmfMsgType = "Create"  // Create a PDU session in the H-SMF
 	NsmfPDUSessionUpdate  SmfMsgType = "Update"  // Update a PDU session in the H-SMF or V- SMF
 	NsmfPDUSessionRelease SmfMsgType = "Release" // Release a PDU session in the H-SMF
@@ -30,6 +31,7 @@ const (
 
 	// NUDM_
 	SmSubscriptionDataRetrieval SmfMsgType = "SmSubscriptionDataRetrieval"
+	GenerateAuthData            SmfMsgType = "GenerateAuthData"
 
 	// NPCF_
 	SmPolicyAssociationCreate       SmfMsgType = "SmPolicyAssociationCreate"
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nchf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/omec-project/openapi"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/util"
+)
+
+// CHFClient opens, updates and closes the converged charging of the PDU sessions at a CHF
+type CHFClient struct {
+	uri string
+	// rating group of the usage of the sessions
+	ratingGroup uint32
+}
+
+var chfClient *CHFClient
+
+// NewCHFClient returns a client of the CHF of the configuration
+func NewCHFClient(config *factory.ChfConfig) *CHFClient {
+	client := &CHFClient{
+		uri:         strings.TrimSuffix(config.Uri, "/"),
+		ratingGroup: factory.DEFAULT_CHF_RATING_GROUP,
+	}
+	if config.RatingGroup > 0 {
+		client.ratingGroup = config.RatingGroup
+	}
+	return client
+}
+
+// InitCHFClient sets the client of the CHF of the configuration, none if not configured
+func InitCHFClient(config *factory.ChfConfig) *CHFClient {
+	if config == nil || config.Uri == "" {
+		chfClient = nil
+		return nil
+	}
+	chfClient = NewCHFClient(config)
+	return chfClient
+}
+
+// GetCHFClient returns the client of the CHF of the SMF, nil if not configured
+func GetCHFClient() *CHFClient {
+	return chfClient
+}
+
+// RatingGroup returns the rating group of the usage of the sessions
+func (c *CHFClient) RatingGroup() uint32 {
+	return c.ratingGroup
+}
+
+// Create opens the charging of a session, returns the charging data reference of the session at
+// the CHF. TS 32.291 6.1.3.2.2
+func (c *CHFClient) Create(request *ChargingDataRequest) (string, error) {
+	var response ChargingDataResponse
+	header, err := c.send(c.uri+"/nchf-convergedcharging/v3/chargingdata", request, http.StatusCreated, &response)
+	if err != nil {
+		return "", err
+	}
+	location := header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("charging data created without location")
+	}
+	return path.Base(location), nil
+}
+
+// Update sends the usage of a session closed by a change of condition. TS 32.291 6.1.3.2.3
+func (c *CHFClient) Update(chargingDataRef string, request *ChargingDataRequest) error {
+	var response ChargingDataResponse
+	_, err := c.send(c.chargingDataUri(chargingDataRef)+"/update", request, http.StatusOK, &response)
+	return err
+}
+
+// Release closes the charging of a session with its final usage. TS 32.291 6.1.3.2.4
+func (c *CHFClient) Release(chargingDataRef string, request *ChargingDataRequest) error {
+	_, err := c.send(c.chargingDataUri(chargingDataRef)+"/release", request, http.StatusNoContent, nil)
+	return err
+}
+
+func (c *CHFClient) chargingDataUri(chargingDataRef string) string {
+	return c.uri + "/nchf-convergedcharging/v3/chargingdata/" + chargingDataRef
+}
+
+// send posts the Charging Data Request to the CHF and decodes its answer of the expected status,
+// none if out is nil, returns the headers of the answer
+func (c *CHFClient) send(uri string, request *ChargingDataRequest, status int, out any) (http.Header, error) {
+	buf, err := openapi.Serialize(request, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, uri, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := util.CallSbi(req)
+	if err != nil {
+		return nil, fmt.Errorf("charging data request failed: %w", err)
+	}
+	defer func() {
+		if closeErr := rsp.Body.Close(); closeErr != nil {
+			logger.ConsumerLog.Errorf("close CHF response body failed: %v", closeErr)
+		}
+	}()
+	if rsp.StatusCode != status {
+		detail, _ := io.ReadAll(rsp.Body)
+		return nil, fmt.Errorf("charging data request rejected with status %d: %s", rsp.StatusCode, detail)
+	}
+	if out != nil {
+		if err := json.NewDecoder(rsp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("decode charging data response failed: %w", err)
+		}
+	}
+	return rsp.Header, nil
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nchf_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/nchf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newCHFServer returns a CHF creating the charging data of the sessions under the reference, and
+// recording the paths of the requests
+func newCHFServer(t *testing.T, chargingDataRef string, paths *[]string) *httptest.Server {
+	t.Helper()
+	const path = "/nchf-convergedcharging/v3/chargingdata"
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request nchf.ChargingDataRequest
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&request) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*paths = append(*paths, r.URL.Path)
+		switch r.URL.Path {
+		case path:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "http://"+r.Host+path+"/"+chargingDataRef)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(nchf.ChargingDataResponse{
+				InvocationTimeStamp:      request.InvocationTimeStamp,
+				InvocationSequenceNumber: request.InvocationSequenceNumber,
+			})
+		case path + "/" + chargingDataRef + "/update":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(nchf.ChargingDataResponse{
+				InvocationTimeStamp:      request.InvocationTimeStamp,
+				InvocationSequenceNumber: request.InvocationSequenceNumber,
+			})
+		case path + "/" + chargingDataRef + "/release":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCHFClientCreateUpdateRelease(t *testing.T) {
+	var paths []string
+	server := newCHFServer(t, "charging-1", &paths)
+	client := nchf.NewCHFClient(&factory.ChfConfig{Uri: server.URL + "/"})
+	require.Equal(t, uint32(factory.DEFAULT_CHF_RATING_GROUP), client.RatingGroup())
+
+	chargingDataRef, err := client.Create(&nchf.ChargingDataRequest{SubscriberIdentifier: "imsi-208930000000001"})
+	require.NoError(t, err)
+	require.Equal(t, "charging-1", chargingDataRef)
+	require.NoError(t, client.Update(chargingDataRef, &nchf.ChargingDataRequest{InvocationSequenceNumber: 1}))
+	require.NoError(t, client.Release(chargingDataRef, &nchf.ChargingDataRequest{InvocationSequenceNumber: 2}))
+	require.Equal(t, []string{
+		"/nchf-convergedcharging/v3/chargingdata",
+		"/nchf-convergedcharging/v3/chargingdata/charging-1/update",
+		"/nchf-convergedcharging/v3/chargingdata/charging-1/release",
+	}, paths)
+
+	// the charging data of an unknown reference is rejected
+	require.Error(t, client.Update("charging-2", &nchf.ChargingDataRequest{}))
+	require.Error(t, client.Release("charging-2", &nchf.ChargingDataRequest{}))
+}
+
+func TestInitCHFClient(t *testing.T) {
+	t.Cleanup(func() { nchf.InitCHFClient(nil) })
+	require.Nil(t, nchf.InitCHFClient(&factory.ChfConfig{}))
+	require.Nil(t, nchf.GetCHFClient())
+
+	client := nchf.InitCHFClient(&factory.ChfConfig{Uri: "http://chf:29594", RatingGroup: 10})
+	require.Same(t, client, nchf.GetCHFClient())
+	require.Equal(t, uint32(10), client.RatingGroup())
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nchf
+
+import (
+	"time"
+
+	"github.com/omec-project/openapi/models"
+)
+
+// Node functionality of the SMF consuming the charging service. TS 32.291 6.1.6.3.5
+const NodeFunctionalitySMF = "SMF"
+
+// Trigger types and categories of the SMF. TS 32.291 6.1.6.3.6, 6.1.6.3.7
+const (
+	TriggerTypeFinal = "FINAL"
+
+	TriggerCategoryImmediateReport = "IMMEDIATE_REPORT"
+)
+
+// ChargingDataRequest is the request of the SMF opening, updating or closing the converged
+// charging of a PDU session. TS 32.291 6.1.6.2.1.1
+type ChargingDataRequest struct {
+	SubscriberIdentifier          string                         `json:"subscriberIdentifier,omitempty"`
+	NfConsumerIdentification      NFIdentification               `json:"nfConsumerIdentification"`
+	InvocationTimeStamp           time.Time                      `json:"invocationTimeStamp"`
+	InvocationSequenceNumber      uint32                         `json:"invocationSequenceNumber"`
+	MultipleUnitUsage             []MultipleUnitUsage            `json:"multipleUnitUsage,omitempty"`
+	Triggers                      []Trigger                      `json:"triggers,omitempty"`
+	PDUSessionChargingInformation *PDUSessionChargingInformation `json:"pDUSessionChargingInformation,omitempty"`
+}
+
+// ChargingDataResponse is the answer of the CHF to a Charging Data Request.
+// TS 32.291 6.1.6.2.1.2
+type ChargingDataResponse struct {
+	InvocationTimeStamp      time.Time `json:"invocationTimeStamp"`
+	InvocationSequenceNumber uint32    `json:"invocationSequenceNumber"`
+}
+
+// NFIdentification identifies the SMF to the CHF. TS 32.291 6.1.6.2.1.3
+type NFIdentification struct {
+	NFName            string `json:"nFName,omitempty"`
+	NFIPv4Address     string `json:"nFIPv4Address,omitempty"`
+	NFIPv6Address     string `json:"nFIPv6Address,omitempty"`
+	NodeFunctionality string `json:"nodeFunctionality"`
+}
+
+// MultipleUnitUsage is the usage of a rating group. TS 32.291 6.1.6.2.1.4
+type MultipleUnitUsage struct {
+	RatingGroup       uint32              `json:"ratingGroup"`
+	UsedUnitContainer []UsedUnitContainer `json:"usedUnitContainer,omitempty"`
+	UPFID             string              `json:"uPFID,omitempty"`
+}
+
+// UsedUnitContainer is the usage measured until a trigger. TS 32.291 6.1.6.2.1.7
+type UsedUnitContainer struct {
+	Triggers            []Trigger  `json:"triggers,omitempty"`
+	TriggerTimestamp    *time.Time `json:"triggerTimestamp,omitempty"`
+	Time                uint32     `json:"time,omitempty"`
+	TotalVolume         uint64     `json:"totalVolume,omitempty"`
+	UplinkVolume        uint64     `json:"uplinkVolume,omitempty"`
+	DownlinkVolume      uint64     `json:"downlinkVolume,omitempty"`
+	LocalSequenceNumber uint32     `json:"localSequenceNumber"`
+}
+
+// Trigger is a change of condition reported to the CHF. TS 32.291 6.1.6.2.1.11
+type Trigger struct {
+	TriggerType     string `json:"triggerType"`
+	TriggerCategory string `json:"triggerCategory"`
+}
+
+// PDUSessionChargingInformation is the PDU session of the charging. TS 32.291 6.2.1.2.1.2
+type PDUSessionChargingInformation struct {
+	ChargingId            uint32                `json:"chargingId"`
+	PduSessionInformation PDUSessionInformation `json:"pduSessionInformation"`
+	// usage of the secondary RAT of a dual connectivity, reported by the NG-RAN
+	RANSecondaryRATUsageReport *RANSecondaryRATUsageReport `json:"rANSecondaryRATUsageReport,omitempty"`
+}
+
+// PDUSessionInformation describes the PDU session. TS 32.291 6.2.1.2.1.4
+type PDUSessionInformation struct {
+	NetworkSlicingInfo *NetworkSlicingInfo   `json:"networkSlicingInfo,omitempty"`
+	PduSessionID       int32                 `json:"pduSessionID"`
+	PduType            models.PduSessionType `json:"pduType,omitempty"`
+	DnnId              string                `json:"dnnId"`
+	RatType            models.RatType        `json:"ratType,omitempty"`
+	PduAddress         *PDUAddress           `json:"pduAddress,omitempty"`
+}
+
+// NetworkSlicingInfo is the slice of the PDU session. TS 32.291 6.2.1.2.1.6
+type NetworkSlicingInfo struct {
+	SNSSAI models.Snssai `json:"sNSSAI"`
+}
+
+// PDUAddress is the address of the UE in the PDU session. TS 32.291 6.2.1.2.1.5
+type PDUAddress struct {
+	PduIPv4Address string `json:"pduIPv4Address,omitempty"`
+}
+
+// RANSecondaryRATUsageReport is the usage of the session on a secondary RAT.
+// TS 32.291 6.2.1.2.1.14
+type RANSecondaryRATUsageReport struct {
+	RANSecondaryRATType  models.RatType        `json:"rANSecondaryRATType"`
+	QosFlowsUsageReports []QosFlowsUsageReport `json:"qosFlowsUsageReports"`
+}
+
+// QosFlowsUsageReport is the usage of the session, or of one of its QoS flows, over a period.
+// TS 32.291 6.2.1.2.1.15
+type QosFlowsUsageReport struct {
+	QFI            uint8     `json:"qFI,omitempty"`
+	StartTimestamp time.Time `json:"startTimestamp"`
+	EndTimestamp   time.Time `json:"endTimestamp"`
+	UplinkVolume   uint64    `json:"uplinkVolume"`
+	DownlinkVolume uint64    `json:"downlinkVolume"`
+}
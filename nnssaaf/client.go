@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nnssaaf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/omec-project/openapi"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/eap"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/smferrors"
+	"github.com/omec-project/smf/util"
+)
+
+// ErrSliceAuthFailed is returned when the NSSAAF did not authenticate the UE for the slice
+var ErrSliceAuthFailed = smferrors.New(smferrors.ErrCodeSliceAuthFailed, "network slice-specific authentication failed")
+
+// NSSAAFClient asks an NSSAAF for the network slice-specific authentication and authorization of
+// the UEs establishing a session in the slices requiring it
+type NSSAAFClient struct {
+	uri     string
+	snssais []models.Snssai
+}
+
+var nssaafClient *NSSAAFClient
+
+// NewNSSAAFClient returns a client of the NSSAAF of the configuration
+func NewNSSAAFClient(config *factory.NssaafConfig) *NSSAAFClient {
+	return &NSSAAFClient{
+		uri:     strings.TrimSuffix(config.Uri, "/"),
+		snssais: config.Snssais,
+	}
+}
+
+// InitNSSAAFClient sets the client of the NSSAAF of the configuration, none if not configured
+func InitNSSAAFClient(config *factory.NssaafConfig) *NSSAAFClient {
+	if config == nil || config.Uri == "" {
+		nssaafClient = nil
+		return nil
+	}
+	nssaafClient = NewNSSAAFClient(config)
+	return nssaafClient
+}
+
+// GetNSSAAFClient returns the client of the NSSAAF of the SMF, nil if not configured
+func GetNSSAAFClient() *NSSAAFClient {
+	return nssaafClient
+}
+
+// Required checks that the slice requires NSSAA
+func (c *NSSAAFClient) Required(snssai models.Snssai) bool {
+	for _, required := range c.snssais {
+		if required.Sst == snssai.Sst && strings.EqualFold(required.Sd, snssai.Sd) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator returns the EAP authenticator of the UE for the slice, the EAP exchange between
+// the NSSAAF and the UE being relayed by the SMF. The UE is asked for its EAP identity, then the
+// EAP messages of the NSSAAF are relayed until it answers with the result of the authentication.
+// TS 29.526 5.2.2.2, TS 23.502 4.2.9.2
+func (c *NSSAAFClient) Authenticator(supi, gpsi string, snssai models.Snssai) eap.Authenticator {
+	return &sliceAuthenticator{client: c, supi: supi, gpsi: gpsi, snssai: snssai}
+}
+
+// sliceAuthenticator relays the EAP messages of the UE to the NSSAAF, the authentication context
+// being created by the EAP identity response of the UE
+type sliceAuthenticator struct {
+	client     *NSSAAFClient
+	supi       string
+	gpsi       string
+	snssai     models.Snssai
+	identifier uint8
+	// authentication context of the UE at the NSSAAF, empty until created
+	authCtxUri string
+}
+
+// Start returns the EAP-Request/Identity to the UE
+func (s *sliceAuthenticator) Start() ([]byte, error) {
+	s.identifier = uint8(rand.UintN(256))
+	return eap.IdentityRequest(s.identifier), nil
+}
+
+// Next relays the EAP response of the UE to the NSSAAF and returns its EAP message, the
+// EAP-Success or EAP-Failure of the result once the authentication is complete
+func (s *sliceAuthenticator) Next(response []byte) ([]byte, error) {
+	p, err := eap.Decode(response)
+	if err != nil {
+		return nil, err
+	}
+	if s.authCtxUri == "" {
+		return s.create(response)
+	}
+
+	var confirmation SliceAuthConfirmationResponse
+	if _, err := s.client.send(http.MethodPut, s.authCtxUri, SliceAuthConfirmationData{
+		Gpsi:       s.gpsi,
+		Snssai:     s.snssai,
+		EapMessage: response,
+	}, http.StatusOK, &confirmation); err != nil {
+		return nil, err
+	}
+	switch confirmation.AuthResult {
+	case AuthResultSuccess:
+		logger.ConsumerLog.Infof("SUPI[%s] authenticated by NSSAAF for slice [sst:%d, sd:%s]", s.supi,
+			s.snssai.Sst, s.snssai.Sd)
+		if result, err := eap.Decode(confirmation.EapMessage); err == nil && result.Code == eap.CodeSuccess {
+			return confirmation.EapMessage, nil
+		}
+		return eap.Success(p.Identifier), nil
+	case AuthResultFailure:
+		logger.ConsumerLog.Warnf("SUPI[%s] not authenticated by NSSAAF for slice [sst:%d, sd:%s]", s.supi,
+			s.snssai.Sst, s.snssai.Sd)
+		return eap.Failure(p.Identifier), nil
+	}
+	if len(confirmation.EapMessage) == 0 {
+		return nil, fmt.Errorf("slice authentication answered without EAP message nor result")
+	}
+	return confirmation.EapMessage, nil
+}
+
+// create creates the authentication context of the UE at the NSSAAF with its EAP identity
+// response, returns the first EAP message of the NSSAAF to the UE
+func (s *sliceAuthenticator) create(identityResponse []byte) ([]byte, error) {
+	var authContext SliceAuthContext
+	uri := s.client.uri + "/nnssaaf-nssaa/v1/slice-authentications"
+	header, err := s.client.send(http.MethodPost, uri, SliceAuthInfo{
+		Supi:     s.supi,
+		Gpsi:     s.gpsi,
+		Snssai:   s.snssai,
+		EapIdRsp: identityResponse,
+	}, http.StatusCreated, &authContext)
+	if err != nil {
+		return nil, err
+	}
+	location := header.Get("Location")
+	if location == "" {
+		if authContext.AuthCtxId == "" {
+			return nil, fmt.Errorf("slice authentication context created without ID")
+		}
+		location = uri + "/" + authContext.AuthCtxId
+	}
+	if len(authContext.EapMessage) == 0 {
+		return nil, fmt.Errorf("slice authentication context created without EAP message")
+	}
+	s.authCtxUri = location
+	return authContext.EapMessage, nil
+}
+
+// send sends the request to the NSSAAF and decodes its answer of the expected status, returns the
+// headers of the answer
+func (c *NSSAAFClient) send(method, uri string, body any, status int, out any) (http.Header, error) {
+	buf, err := openapi.Serialize(body, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, uri, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := util.CallSbi(req)
+	if err != nil {
+		return nil, fmt.Errorf("slice authentication failed: %w", err)
+	}
+	defer func() {
+		if closeErr := rsp.Body.Close(); closeErr != nil {
+			logger.ConsumerLog.Errorf("close NSSAAF response body failed: %v", closeErr)
+		}
+	}()
+	switch rsp.StatusCode {
+	case status:
+	case http.StatusForbidden:
+		return nil, ErrSliceAuthFailed
+	default:
+		detail, _ := io.ReadAll(rsp.Body)
+		return nil, fmt.Errorf("slice authentication rejected with status %d: %s", rsp.StatusCode, detail)
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("decode slice authentication answer failed: %w", err)
+	}
+	return rsp.Header, nil
+}
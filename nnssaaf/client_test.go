@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nnssaaf_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/eap"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/nnssaaf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// challengeType is the EAP method of the challenges of the test NSSAAF, EAP-MD5-Challenge
+const challengeType uint8 = 4
+
+// newNSSAAFServer returns an NSSAAF challenging the UEs for the secret of their SUPI, forbidding
+// the unknown SUPIs
+func newNSSAAFServer(t *testing.T, secrets map[string]string) *httptest.Server {
+	t.Helper()
+	const path = "/nnssaaf-nssaa/v1/slice-authentications"
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == path:
+			var authInfo nnssaaf.SliceAuthInfo
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&authInfo))
+			identity, err := eap.Decode(authInfo.EapIdRsp)
+			require.NoError(t, err)
+			require.Equal(t, eap.CodeResponse, identity.Code)
+			require.Equal(t, eap.TypeIdentity, identity.Type)
+			if _, ok := secrets[authInfo.Supi]; !ok {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Location", "http://"+r.Host+path+"/"+authInfo.Supi)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(nnssaaf.SliceAuthContext{
+				Gpsi:      authInfo.Gpsi,
+				Snssai:    authInfo.Snssai,
+				AuthCtxId: authInfo.Supi,
+				EapMessage: (&eap.Packet{
+					Code: eap.CodeRequest, Identifier: 7, Type: challengeType, Data: []byte("challenge"),
+				}).Encode(),
+			})
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, path+"/"):
+			var confirmation nnssaaf.SliceAuthConfirmationData
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&confirmation))
+			response, err := eap.Decode(confirmation.EapMessage)
+			require.NoError(t, err)
+			rsp := nnssaaf.SliceAuthConfirmationResponse{
+				Gpsi:       confirmation.Gpsi,
+				Snssai:     confirmation.Snssai,
+				EapMessage: eap.Failure(response.Identifier),
+				AuthResult: nnssaaf.AuthResultFailure,
+			}
+			if string(response.Data) == secrets[strings.TrimPrefix(r.URL.Path, path+"/")] {
+				rsp.EapMessage = eap.Success(response.Identifier)
+				rsp.AuthResult = nnssaaf.AuthResultSuccess
+			}
+			_ = json.NewEncoder(w).Encode(rsp)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// authenticate runs the EAP exchange of the UE answering the challenge of the NSSAAF with the
+// secret, returns the EAP message ending it
+func authenticate(t *testing.T, authenticator eap.Authenticator, secret string) ([]byte, error) {
+	t.Helper()
+	request, err := authenticator.Start()
+	require.NoError(t, err)
+	p, err := eap.Decode(request)
+	require.NoError(t, err)
+	require.Equal(t, eap.TypeIdentity, p.Type)
+	challenge, err := authenticator.Next((&eap.Packet{
+		Code: eap.CodeResponse, Identifier: p.Identifier, Type: eap.TypeIdentity, Data: []byte("user@slice"),
+	}).Encode())
+	if err != nil {
+		return nil, err
+	}
+	p, err = eap.Decode(challenge)
+	require.NoError(t, err)
+	require.Equal(t, eap.CodeRequest, p.Code)
+	require.Equal(t, challengeType, p.Type)
+	return authenticator.Next((&eap.Packet{
+		Code: eap.CodeResponse, Identifier: p.Identifier, Type: challengeType, Data: []byte(secret),
+	}).Encode())
+}
+
+func TestAuthenticator(t *testing.T) {
+	server := newNSSAAFServer(t, map[string]string{
+		"imsi-208930000000001": "secret-1",
+		"imsi-208930000000002": "secret-2",
+	})
+	snssai := models.Snssai{Sst: 1, Sd: "010203"}
+	client := nnssaaf.NewNSSAAFClient(&factory.NssaafConfig{
+		Uri:     server.URL + "/",
+		Snssais: []models.Snssai{snssai},
+	})
+
+	require.True(t, client.Required(snssai))
+	require.False(t, client.Required(models.Snssai{Sst: 1, Sd: "112233"}))
+
+	result, err := authenticate(t, client.Authenticator("imsi-208930000000001", "msisdn-0900000001", snssai), "secret-1")
+	require.NoError(t, err)
+	require.Equal(t, eap.Success(7), result)
+
+	result, err = authenticate(t, client.Authenticator("imsi-208930000000002", "msisdn-0900000002", snssai), "secret-1")
+	require.NoError(t, err)
+	require.Equal(t, eap.Failure(7), result)
+
+	_, err = authenticate(t, client.Authenticator("imsi-208930000000003", "msisdn-0900000003", snssai), "secret-3")
+	require.ErrorIs(t, err, nnssaaf.ErrSliceAuthFailed)
+
+	// the NSSAAF not answering
+	server.Close()
+	_, err = authenticate(t, client.Authenticator("imsi-208930000000001", "msisdn-0900000001", snssai), "secret-1")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, nnssaaf.ErrSliceAuthFailed)
+}
+
+func TestInitNSSAAFClient(t *testing.T) {
+	t.Cleanup(func() { nnssaaf.InitNSSAAFClient(nil) })
+
+	require.Nil(t, nnssaaf.InitNSSAAFClient(nil))
+	require.Nil(t, nnssaaf.GetNSSAAFClient())
+	client := nnssaaf.InitNSSAAFClient(&factory.NssaafConfig{Uri: "http://nssaaf:29526"})
+	require.NotNil(t, client)
+	require.Same(t, client, nnssaaf.GetNSSAAFClient())
+}
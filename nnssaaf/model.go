@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nnssaaf
+
+import (
+	"github.com/omec-project/openapi/models"
+)
+
+// Results of the slice authentication. TS 29.526 6.1.6.3.3
+const (
+	AuthResultSuccess = "EAP_SUCCESS"
+	AuthResultFailure = "EAP_FAILURE"
+)
+
+// SliceAuthInfo is the request of the authentication of a UE for a slice, with the EAP identity
+// response of the UE. TS 29.526 6.1.6.2.2
+type SliceAuthInfo struct {
+	Supi     string        `json:"supi,omitempty"`
+	Gpsi     string        `json:"gpsi,omitempty"`
+	Snssai   models.Snssai `json:"snssai"`
+	EapIdRsp []byte        `json:"eapIdRsp"`
+}
+
+// SliceAuthContext is the answer of the NSSAAF creating the authentication context of a UE for a
+// slice, with the first EAP message to the UE. TS 29.526 6.1.6.2.3
+type SliceAuthContext struct {
+	Gpsi       string        `json:"gpsi,omitempty"`
+	Snssai     models.Snssai `json:"snssai"`
+	AuthCtxId  string        `json:"authCtxId"`
+	EapMessage []byte        `json:"eapMessage"`
+}
+
+// SliceAuthConfirmationData is an EAP message of the UE relayed to the NSSAAF.
+// TS 29.526 6.1.6.2.4
+type SliceAuthConfirmationData struct {
+	Gpsi       string        `json:"gpsi,omitempty"`
+	Snssai     models.Snssai `json:"snssai"`
+	EapMessage []byte        `json:"eapMessage"`
+}
+
+// SliceAuthConfirmationResponse is the answer of the NSSAAF to an EAP message of the UE, with the
+// result once the authentication is complete. TS 29.526 6.1.6.2.5
+type SliceAuthConfirmationResponse struct {
+	Gpsi       string        `json:"gpsi,omitempty"`
+	Snssai     models.Snssai `json:"snssai"`
+	EapMessage []byte        `json:"eapMessage"`
+	AuthResult string        `json:"authResult,omitempty"`
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nnwdaf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omec-project/openapi"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/util"
+)
+
+// NWDAFClient subscribes the SMF to the analytics of an NWDAF and applies their notifications
+type NWDAFClient struct {
+	uri             string
+	notificationURI string
+	// load level in percent from which a UPF is predicted congested
+	loadLevelThreshold int32
+	// duration a prediction without expiry is applied
+	predictionValidity time.Duration
+
+	// analytics ID of the subscriptions, by subscription ID
+	subscriptions map[string]string
+	lock          sync.Mutex
+}
+
+var nwdafClient *NWDAFClient
+
+// NewNWDAFClient returns a client of the NWDAF of the URI, notifying the analytics to the
+// notification URI of the SMF
+func NewNWDAFClient(config *factory.NwdafConfig, notificationURI string) *NWDAFClient {
+	client := &NWDAFClient{
+		uri:                strings.TrimSuffix(config.Uri, "/"),
+		notificationURI:    notificationURI,
+		loadLevelThreshold: factory.DEFAULT_NWDAF_LOAD_LEVEL_THRESHOLD,
+		predictionValidity: factory.DEFAULT_NWDAF_PREDICTION_VALIDITY * time.Second,
+		subscriptions:      make(map[string]string),
+	}
+	if config.LoadLevelThreshold > 0 {
+		client.loadLevelThreshold = config.LoadLevelThreshold
+	}
+	if config.PredictionValiditySec > 0 {
+		client.predictionValidity = time.Duration(config.PredictionValiditySec) * time.Second
+	}
+	return client
+}
+
+// InitNWDAFClient sets the client of the NWDAF of the configuration, none if not configured
+func InitNWDAFClient(config *factory.NwdafConfig, notificationURI string) *NWDAFClient {
+	if config == nil || config.Uri == "" {
+		nwdafClient = nil
+		return nil
+	}
+	nwdafClient = NewNWDAFClient(config, notificationURI)
+	return nwdafClient
+}
+
+// GetNWDAFClient returns the client of the NWDAF of the SMF, nil if not configured
+func GetNWDAFClient() *NWDAFClient {
+	return nwdafClient
+}
+
+// SubscribeAnalytics subscribes the SMF to the analytics of the NWDAF matching the filter.
+// TS 29.520 5.1.3.2.3.1
+func (c *NWDAFClient) SubscribeAnalytics(analyticsID string, filter AnalyticsFilter) error {
+	eventSubscription := EventSubscription{
+		Event:         analyticsID,
+		Snssais:       filter.Snssais,
+		NfTypes:       filter.NfTypes,
+		NfInstanceIds: filter.NfInstanceIds,
+	}
+	if analyticsID == AnalyticsNfLoad {
+		eventSubscription.LoadLevelThreshold = c.loadLevelThreshold
+	}
+	body, err := openapi.Serialize(EventsSubscription{
+		EventSubscriptions: []EventSubscription{eventSubscription},
+		NotificationURI:    c.notificationURI,
+	}, "application/json")
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost,
+		c.uri+"/nnwdaf-eventssubscription/v1/subscriptions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := util.CallSbi(req)
+	if err != nil {
+		return fmt.Errorf("%s analytics subscription failed: %w", analyticsID, err)
+	}
+	defer func() {
+		if closeErr := rsp.Body.Close(); closeErr != nil {
+			logger.ConsumerLog.Errorf("close NWDAF response body failed: %v", closeErr)
+		}
+	}()
+	if rsp.StatusCode != http.StatusCreated {
+		detail, _ := io.ReadAll(rsp.Body)
+		return fmt.Errorf("%s analytics subscription rejected with status %d: %s", analyticsID, rsp.StatusCode, detail)
+	}
+
+	// the subscription ID ends the URI of the created subscription
+	location := rsp.Header.Get("Location")
+	subscriptionID := location[strings.LastIndex(location, "/")+1:]
+	if subscriptionID == "" {
+		return fmt.Errorf("%s analytics subscription without Location", analyticsID)
+	}
+	c.lock.Lock()
+	c.subscriptions[subscriptionID] = analyticsID
+	c.lock.Unlock()
+	logger.ConsumerLog.Infof("subscribed to %s analytics of NWDAF, subscription [%s]", analyticsID, subscriptionID)
+	return nil
+}
+
+// analyticsOf returns the analytics ID of the subscription, false if the SMF did not subscribe
+func (c *NWDAFClient) analyticsOf(subscriptionID string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	analyticsID, ok := c.subscriptions[subscriptionID]
+	return analyticsID, ok
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nnwdaf_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/callback"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/nnwdaf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestSubscribeAnalyticsAndCongestionPrediction(t *testing.T) {
+	var subscription nnwdaf.EventsSubscription
+	nwdaf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/nnwdaf-eventssubscription/v1/subscriptions" ||
+			json.NewDecoder(r.Body).Decode(&subscription) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", "http://"+r.Host+"/nnwdaf-eventssubscription/v1/subscriptions/sub-1")
+		w.WriteHeader(http.StatusCreated)
+	}), &http2.Server{}))
+	t.Cleanup(nwdaf.Close)
+
+	snssai := &models.Snssai{Sst: 1, Sd: "010203"}
+	upi := contexttest.NewUserPlane(t, "192.168.181.100", map[string]factory.UPNode{
+		"UPF-A": contexttest.UPF("192.168.181.1", snssai),
+		"UPF-B": contexttest.UPF("192.168.181.2", snssai),
+	})
+	upfA, upfB := upi.UPFs["UPF-A"], upi.UPFs["UPF-B"]
+	contexttest.SetUserPlane(t, upi)
+	t.Cleanup(func() { nnwdaf.InitNWDAFClient(nil, "") })
+
+	client := nnwdaf.InitNWDAFClient(&factory.NwdafConfig{Uri: nwdaf.URL, LoadLevelThreshold: 90},
+		"http://10.0.0.5:29502/nsmf-callback/nwdaf-notify")
+	require.NoError(t, client.SubscribeAnalytics(nnwdaf.AnalyticsNfLoad, nnwdaf.AnalyticsFilter{
+		NfTypes: []models.NfType{models.NfType_UPF},
+	}))
+	require.Equal(t, "http://10.0.0.5:29502/nsmf-callback/nwdaf-notify", subscription.NotificationURI)
+	require.Equal(t, []nnwdaf.EventSubscription{{
+		Event:              nnwdaf.AnalyticsNfLoad,
+		NfTypes:            []models.NfType{models.NfType_UPF},
+		LoadLevelThreshold: 90,
+	}}, subscription.EventSubscriptions)
+
+	router := callback.NewRouter()
+	notify := func(subscriptionID, upfName string, load int32) int {
+		body, err := json.Marshal(nnwdaf.AnalyticsNotification{
+			SubscriptionId: subscriptionID,
+			EventNotifications: []nnwdaf.EventNotification{{
+				Event: nnwdaf.AnalyticsNfLoad,
+				NfLoadLevelInfos: []nnwdaf.NfLoadLevelInformation{{
+					NfType:             models.NfType_UPF,
+					NfInstanceId:       upfName,
+					NfLoadLevelAverage: load,
+					Confidence:         80,
+				}},
+			}},
+		})
+		require.NoError(t, err)
+		rsp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/nsmf-callback/nwdaf-notify", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rsp, req)
+		return rsp.Code
+	}
+	selection := &context.UPFSelectionParams{
+		SNssai: &context.SNssai{Sst: 1, Sd: "010203"},
+		Dnn:    "internet",
+	}
+	anchor := func() *context.UPNode {
+		path := upi.GetDefaultUserPlanePathByDNN(selection)
+		require.NotEmpty(t, path)
+		return path[len(path)-1]
+	}
+
+	require.Same(t, upfA, anchor())
+
+	// notification of an unknown subscription
+	require.Equal(t, http.StatusNotFound, notify("sub-2", "UPF-A", 95))
+	require.Same(t, upfA, anchor())
+
+	// congestion predicted, the new sessions are anchored on the other UPF
+	require.Equal(t, http.StatusNoContent, notify("sub-1", "UPF-A", 95))
+	require.True(t, upfA.UPF.IsCongested(time.Now()))
+	require.Same(t, upfB, anchor())
+
+	// load predicted below the threshold, then the other UPF predicted congested
+	require.Equal(t, http.StatusNoContent, notify("sub-1", "UPF-A", 40))
+	require.False(t, upfA.UPF.IsCongested(time.Now()))
+	require.Equal(t, http.StatusNoContent, notify("sub-1", "192.168.181.2", 92))
+	require.Same(t, upfA, anchor())
+}
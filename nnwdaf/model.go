@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nnwdaf
+
+import (
+	"time"
+
+	"github.com/omec-project/openapi/models"
+)
+
+// Analytics IDs of the Nnwdaf_EventsSubscription service. TS 29.520 5.1.6.3.4
+const (
+	AnalyticsNfLoad             = "NF_LOAD"
+	AnalyticsQosSustainability  = "QOS_SUSTAINABILITY"
+	AnalyticsUserDataCongestion = "USER_DATA_CONGESTION"
+)
+
+// AnalyticsFilter restricts the analytics of a subscription to the NFs and slices of the SMF
+type AnalyticsFilter struct {
+	Snssais       []models.Snssai
+	NfTypes       []models.NfType
+	NfInstanceIds []string
+}
+
+// EventsSubscription is the subscription of the SMF to the analytics of the NWDAF.
+// TS 29.520 5.1.6.2.2
+type EventsSubscription struct {
+	EventSubscriptions []EventSubscription `json:"eventSubscriptions"`
+	NotificationURI    string              `json:"notificationURI,omitempty"`
+}
+
+// EventSubscription is an analytics of the subscription. TS 29.520 5.1.6.2.3
+type EventSubscription struct {
+	Event              string          `json:"event"`
+	Snssais            []models.Snssai `json:"snssais,omitempty"`
+	NfTypes            []models.NfType `json:"nfTypes,omitempty"`
+	NfInstanceIds      []string        `json:"nfInstanceIds,omitempty"`
+	LoadLevelThreshold int32           `json:"loadLevelThreshold,omitempty"`
+}
+
+// AnalyticsNotification is the notification of the analytics of a subscription.
+// TS 29.520 5.1.6.2.4
+type AnalyticsNotification struct {
+	EventNotifications []EventNotification `json:"eventNotifications"`
+	SubscriptionId     string              `json:"subscriptionId"`
+}
+
+// EventNotification is the analytics of an event. TS 29.520 5.1.6.2.5
+type EventNotification struct {
+	Event string `json:"event"`
+	// validity of the analytics, a prediction when in the future
+	Start            *time.Time               `json:"start,omitempty"`
+	Expiry           *time.Time               `json:"expiry,omitempty"`
+	NfLoadLevelInfos []NfLoadLevelInformation `json:"nfLoadLevelInfos,omitempty"`
+}
+
+// NfLoadLevelInformation is the load of an NF. TS 29.520 5.1.6.2.10
+type NfLoadLevelInformation struct {
+	NfType       models.NfType `json:"nfType"`
+	NfInstanceId string        `json:"nfInstanceId"`
+	// load in percent
+	NfLoadLevelAverage int32 `json:"nfLoadLevelAverage,omitempty"`
+	NfLoadLevelpeak    int32 `json:"nfLoadLevelpeak,omitempty"`
+	// confidence of a prediction, 0 for statistics
+	Confidence int32 `json:"confidence,omitempty"`
+}
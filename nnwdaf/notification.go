@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nnwdaf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+)
+
+// HandleAnalyticsNotification applies the analytics notified by the NWDAF. A UPF whose load is
+// predicted above the threshold is selected after the others for the new sessions until the end
+// of the prediction, the sessions it anchors are left in place.
+func (c *NWDAFClient) HandleAnalyticsNotification(notification *AnalyticsNotification) error {
+	analyticsID, ok := c.analyticsOf(notification.SubscriptionId)
+	if !ok {
+		return fmt.Errorf("unknown NWDAF subscription [%s]", notification.SubscriptionId)
+	}
+	now := time.Now()
+	for _, eventNotification := range notification.EventNotifications {
+		switch eventNotification.Event {
+		case AnalyticsNfLoad:
+			c.handleNfLoad(&eventNotification, now)
+		default:
+			logger.ConsumerLog.Infof("%s analytics of NWDAF subscription [%s] not applied: %+v",
+				eventNotification.Event, notification.SubscriptionId, eventNotification)
+		}
+	}
+	logger.ConsumerLog.Debugf("%s analytics notification of NWDAF subscription [%s] handled", analyticsID,
+		notification.SubscriptionId)
+	return nil
+}
+
+// handleNfLoad updates the congestion of the UPFs of the load predictions
+func (c *NWDAFClient) handleNfLoad(eventNotification *EventNotification, now time.Time) {
+	until := now.Add(c.predictionValidity)
+	if eventNotification.Expiry != nil {
+		until = *eventNotification.Expiry
+	}
+	upi := smf_context.GetUserPlaneInformation()
+	for _, loadInfo := range eventNotification.NfLoadLevelInfos {
+		// statistics of the past load do not predict a congestion
+		if loadInfo.NfType != models.NfType_UPF || loadInfo.Confidence == 0 {
+			continue
+		}
+		upNode := upi.GetUPFNodeByNFInstance(loadInfo.NfInstanceId)
+		if upNode == nil || upNode.UPF == nil {
+			logger.ConsumerLog.Warnf("NWDAF load prediction of unknown UPF [%s] discarded", loadInfo.NfInstanceId)
+			continue
+		}
+		load := max(loadInfo.NfLoadLevelAverage, loadInfo.NfLoadLevelpeak)
+		if load >= c.loadLevelThreshold {
+			logger.ConsumerLog.Warnf("NWDAF predicts UPF [%s] congested (load %d%%, confidence %d) until %s, "+
+				"new sessions anchored on other UPFs", loadInfo.NfInstanceId, load, loadInfo.Confidence, until)
+			upNode.UPF.SetCongestedUntil(until)
+		} else if upNode.UPF.IsCongested(now) {
+			logger.ConsumerLog.Infof("NWDAF no longer predicts UPF [%s] congested (load %d%%)", loadInfo.NfInstanceId, load)
+			upNode.UPF.ClearCongestion()
+		}
+	}
+}
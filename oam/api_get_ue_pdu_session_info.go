@@ -5,7 +5,10 @@
 package oam
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/producer"
 	"github.com/omec-project/util/httpwrapper"
 )
@@ -19,3 +22,49 @@ func HTTPGetUEPDUSessionInfo(c *gin.Context) {
 
 	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
 }
+
+func HTTPGetUPFStatus(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMGetUPFStatus()
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPGetIPPoolStats(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMGetIPPoolStats()
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPReassociateUPF(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMReassociateUPF(c.Params.ByName("nodeId"))
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPTransferSMContext(c *gin.Context) {
+	var request producer.SMContextTransferRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Detail: err.Error(),
+		})
+		return
+	}
+	HTTPResponse := producer.HandleOAMTransferSMContext(c.Params.ByName("smContextRef"), request)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPCheckUEReachability(c *gin.Context) {
+	var request producer.UEReachabilityCheckRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Detail: err.Error(),
+		})
+		return
+	}
+	HTTPResponse := producer.HandleOAMCheckUEReachability(c.Params.ByName("smContextRef"), request)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
@@ -41,6 +41,8 @@ func AddService(engine *gin.Engine) *gin.RouterGroup {
 		switch route.Method {
 		case "GET":
 			group.GET(route.Pattern, route.HandlerFunc)
+		case "POST":
+			group.POST(route.Pattern, route.HandlerFunc)
 		}
 	}
 	return group
@@ -64,4 +66,34 @@ var routes = Routes{
 		"/ue-pdu-session-info/:smContextRef",
 		HTTPGetUEPDUSessionInfo,
 	},
+	{
+		"Get UPF Status",
+		"GET",
+		"/upf-status",
+		HTTPGetUPFStatus,
+	},
+	{
+		"Get IP Pool Stats",
+		"GET",
+		"/ip-pool-stats",
+		HTTPGetIPPoolStats,
+	},
+	{
+		"Reassociate UPF",
+		"POST",
+		"/upfs/:nodeId/reassociate",
+		HTTPReassociateUPF,
+	},
+	{
+		"Transfer SM Context",
+		"POST",
+		"/sm-contexts/:smContextRef/transfer",
+		HTTPTransferSMContext,
+	},
+	{
+		"Check UE Reachability",
+		"POST",
+		"/sm-contexts/:smContextRef/reachability-check",
+		HTTPCheckUEReachability,
+	},
 }
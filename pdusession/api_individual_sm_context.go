@@ -76,6 +76,7 @@ func HTTPReleaseSmContext(c *gin.Context) {
 	smContextRef := req.Params["smContextRef"]
 	txn := transaction.NewTransaction(req.Body.(models.ReleaseSmContextRequest), nil, svcmsgtypes.ReleaseSmContext)
 	txn.CtxtKey = smContextRef
+	txn.SourceIP = c.ClientIP()
 	go txn.StartTxnLifeCycle(fsm.SmfTxnFsmHandle)
 	<-txn.Status
 
@@ -134,6 +135,7 @@ func HTTPUpdateSmContext(c *gin.Context) {
 
 	txn := transaction.NewTransaction(req.Body.(models.UpdateSmContextRequest), nil, svcmsgtypes.UpdateSmContext)
 	txn.CtxtKey = smContextRef
+	txn.SourceIP = c.ClientIP()
 	go txn.StartTxnLifeCycle(fsm.SmfTxnFsmHandle)
 	<-txn.Status
 	HTTPResponse := txn.Rsp.(*httpwrapper.Response)
@@ -18,6 +18,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/omec-project/openapi"
 	"github.com/omec-project/openapi/models"
 	smf_context "github.com/omec-project/smf/context"
@@ -25,16 +26,53 @@ import (
 	"github.com/omec-project/smf/logger"
 	stats "github.com/omec-project/smf/metrics"
 	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+	"github.com/omec-project/smf/producer"
+	"github.com/omec-project/smf/smferrors"
 	"github.com/omec-project/smf/transaction"
 	"github.com/omec-project/util/httpwrapper"
 	mi "github.com/omec-project/util/metricinfo"
 )
 
+// maPduSessionCreateData is the MA PDU session request indication of the SM context create data,
+// absent from its model. TS 29.502 6.1.6.2.2
+type maPduSessionCreateData struct {
+	N1SmMsg      *models.RefToBinaryData `json:"n1SmMsg,omitempty"`
+	MaRequestInd bool                    `json:"maRequestInd,omitempty"`
+}
+
+// maPduSessionRequest is the Create SM Context Request read for its MA PDU session request
+// indication
+type maPduSessionRequest struct {
+	JsonData              *maPduSessionCreateData `json:"jsonData,omitempty" multipart:"contentType:application/json"`
+	BinaryDataN1SmMessage []byte                  `json:"binaryDataN1SmMessage,omitempty" multipart:"contentType:application/vnd.3gpp.5gnas,ref:JsonData.N1SmMsg.ContentId"`
+}
+
+// bindCreateSmContextRequest binds the Create SM Context Request, its body being bound a second
+// time for the MA PDU session request indication
+func bindCreateSmContextRequest(c *gin.Context) (producer.CreateSmContextRequest, error) {
+	var err error
+	request := producer.CreateSmContextRequest{}
+	request.JsonData = new(models.SmContextCreateData)
+	maRequest := maPduSessionRequest{JsonData: new(maPduSessionCreateData)}
+	s := strings.Split(c.GetHeader("Content-Type"), ";")
+	switch s[0] {
+	case "application/json":
+		if err = c.ShouldBindBodyWith(request.JsonData, binding.JSON); err == nil {
+			err = c.ShouldBindBodyWith(maRequest.JsonData, binding.JSON)
+		}
+	case "multipart/related":
+		if err = c.ShouldBindBodyWith(&request.PostSmContextsRequest, openapi.MultipartRelatedBinding{}); err == nil {
+			err = c.ShouldBindBodyWith(&maRequest, openapi.MultipartRelatedBinding{})
+		}
+	}
+	request.MaRequestInd = maRequest.JsonData != nil && maRequest.JsonData.MaRequestInd
+	return request, err
+}
+
 // HTTPPostSmContexts - Create SM Context
 func HTTPPostSmContexts(c *gin.Context) {
 	logger.PduSessLog.Infoln("receive create SM Context Request")
 	var err error
-	var request models.PostSmContextsRequest
 	stats.IncrementN11MsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.CreateSmContext), "In", "", "")
 	err = stats.PublishMsgEvent(mi.Smf_msg_type_pdu_sess_create_req)
 	if err != nil {
@@ -42,16 +80,7 @@ func HTTPPostSmContexts(c *gin.Context) {
 		return
 	}
 
-	request.JsonData = new(models.SmContextCreateData)
-
-	s := strings.Split(c.GetHeader("Content-Type"), ";")
-	switch s[0] {
-	case "application/json":
-		err = c.ShouldBindJSON(request.JsonData)
-	case "multipart/related":
-		err = c.ShouldBindWith(&request, openapi.MultipartRelatedBinding{})
-	}
-
+	request, err := bindCreateSmContextRequest(c)
 	if err != nil {
 		problemDetail := "[Request Body] " + err.Error()
 		rsp := models.ProblemDetails{
@@ -66,7 +95,8 @@ func HTTPPostSmContexts(c *gin.Context) {
 	}
 
 	req := httpwrapper.NewRequest(c.Request, request)
-	txn := transaction.NewTransaction(req.Body.(models.PostSmContextsRequest), nil, svcmsgtypes.CreateSmContext)
+	txn := transaction.NewTransaction(req.Body.(producer.CreateSmContextRequest), nil, svcmsgtypes.CreateSmContext)
+	txn.SourceIP = c.ClientIP()
 
 	go txn.StartTxnLifeCycle(fsm.SmfTxnFsmHandle)
 	<-txn.Status // wait for txn to complete at SMF
@@ -74,7 +104,10 @@ func HTTPPostSmContexts(c *gin.Context) {
 	smContext := txn.Ctxt.(*smf_context.SMContext)
 	errStr := ""
 	if txn.Err != nil {
-		errStr = txn.Err.Error()
+		// the code of the failure, not its details, labels the metric
+		if errStr = string(smferrors.CodeOf(txn.Err)); errStr == "" {
+			errStr = txn.Err.Error()
+		}
 	}
 
 	// Http Response to AMF
@@ -99,6 +132,10 @@ func HTTPPostSmContexts(c *gin.Context) {
 	go func(smContext *smf_context.SMContext) {
 		var txn *transaction.Transaction
 		if HTTPResponse.Status == http.StatusCreated {
+			// the session of a UE not authenticated is rejected and released
+			if err := producer.AuthenticateSession(smContext); err != nil {
+				return
+			}
 			txn = transaction.NewTransaction(nil, nil, svcmsgtypes.PfcpSessCreate)
 			txn.Ctxt = smContext
 			go txn.StartTxnLifeCycle(fsm.SmfTxnFsmHandle)
@@ -108,3 +145,46 @@ func HTTPPostSmContexts(c *gin.Context) {
 		}
 	}(smContext)
 }
+
+// HTTPTransferSmContext - Transfer SM Context, the session context of an inter-SMF handover
+// transferred by the old SMF
+func HTTPTransferSmContext(c *gin.Context) {
+	logger.PduSessLog.Infoln("receive transfer SM Context Request")
+	stats.IncrementN11MsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.TransferSmContext), "In", "", "")
+
+	if peer := c.RemoteIP(); !smf_context.SMF_Self().IsSmContextTransferPeer(peer) {
+		rsp := models.ProblemDetails{
+			Title:  "SM context transfer not allowed",
+			Status: http.StatusForbidden,
+			Detail: "SMF " + peer + " not allowed to transfer SM contexts",
+		}
+		stats.IncrementN11MsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.TransferSmContext), "Out", http.StatusText(http.StatusForbidden), "PeerNotAllowed")
+		logger.PduSessLog.Errorf("SM context transfer from %s rejected, not a transfer peer", peer)
+		c.JSON(http.StatusForbidden, rsp)
+		return
+	}
+
+	var transfer smf_context.SMContextTransfer
+	if err := c.ShouldBindJSON(&transfer); err != nil || transfer.SmContext == nil {
+		detail := "[Request Body] no SM context"
+		if err != nil {
+			detail = "[Request Body] " + err.Error()
+		}
+		rsp := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: detail,
+		}
+		stats.IncrementN11MsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.TransferSmContext), "Out", http.StatusText(http.StatusBadRequest), "Malformed")
+		logger.PduSessLog.Errorln(detail)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	HTTPResponse := producer.HandleSMContextTransfer(transfer.SmContext)
+	for key, val := range HTTPResponse.Header {
+		c.Header(key, val[0])
+	}
+	stats.IncrementN11MsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.TransferSmContext), "Out", http.StatusText(HTTPResponse.Status), "")
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
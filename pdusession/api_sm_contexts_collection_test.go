@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pdusession
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindCreateSmContextRequest(t *testing.T) {
+	bind := func(contentType string, body []byte) (bool, []byte) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/sm-contexts", bytes.NewReader(body))
+		c.Request.Header.Set("Content-Type", contentType)
+		request, err := bindCreateSmContextRequest(c)
+		require.NoError(t, err)
+		require.Equal(t, "imsi-208930000000001", request.JsonData.Supi)
+		return request.MaRequestInd, request.BinaryDataN1SmMessage
+	}
+
+	maRequested, _ := bind("application/json", []byte(`{"supi":"imsi-208930000000001","maRequestInd":true}`))
+	require.True(t, maRequested)
+	maRequested, _ = bind("application/json", []byte(`{"supi":"imsi-208930000000001"}`))
+	require.False(t, maRequested)
+
+	// the N1 SM message of the AMF is bound along the indication
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	require.NoError(t, err)
+	_, err = part.Write([]byte(`{"supi":"imsi-208930000000001","n1SmMsg":{"contentId":"n1msg"},"maRequestInd":true}`))
+	require.NoError(t, err)
+	part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/vnd.3gpp.5gnas"},
+		"Content-Id":   {"n1msg"},
+	})
+	require.NoError(t, err)
+	_, err = part.Write([]byte{0x2e, 0x01, 0x01})
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	contentType := "multipart/related; boundary=" + writer.Boundary()
+	maRequested, n1SmMsg := bind(contentType, body.Bytes())
+	require.True(t, maRequested)
+	require.Equal(t, []byte{0x2e, 0x01, 0x01}, n1SmMsg)
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pdusession
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+)
+
+// RateLimiter limits the N11 requests of each AMF with a token bucket per source IP.
+// Session releases are never limited, to free resources during an overload.
+type RateLimiter struct {
+	config  factory.RateLimit
+	buckets map[string]*tokenBucket
+	// clock of the buckets, replaced in tests
+	now  func() time.Time
+	lock sync.Mutex
+}
+
+type tokenBucket struct {
+	last   time.Time
+	rate   float64
+	tokens float64
+	burst  float64
+}
+
+// NewRateLimiter returns a limiter over the buckets of the configuration
+func NewRateLimiter(config factory.RateLimit) *RateLimiter {
+	return &RateLimiter{
+		config:  config,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of the AMF. When it is empty, it returns false with the
+// time until the next token.
+func (rl *RateLimiter) Allow(amfIP string) (bool, time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	now := rl.now()
+	bucket, ok := rl.buckets[amfIP]
+	if !ok {
+		config, ok := rl.config.Amfs[amfIP]
+		if !ok {
+			config = rl.config.Default
+		}
+		bucket = &tokenBucket{
+			last:   now,
+			rate:   config.Rate,
+			tokens: float64(config.Burst),
+			burst:  float64(config.Burst),
+		}
+		rl.buckets[amfIP] = bucket
+	}
+	if bucket.burst == 0 {
+		return true, 0
+	}
+
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	if bucket.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	return false, time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+// Middleware rejects the requests of the AMFs exceeding their rate with 429 Too Many Requests
+func (rl *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodPost && strings.HasSuffix(c.FullPath(), "/release") {
+			c.Next()
+			return
+		}
+		allowed, retryAfter := rl.Allow(c.RemoteIP())
+		if allowed {
+			c.Next()
+			return
+		}
+		logger.PduSessLog.Warnf("N11 request from AMF[%s] rate limited", c.RemoteIP())
+		c.Header("Retry-After", strconv.FormatInt(retryAfterSeconds(retryAfter), 10))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, models.ProblemDetails{
+			Title:  http.StatusText(http.StatusTooManyRequests),
+			Status: http.StatusTooManyRequests,
+			Cause:  "NF_CONGESTION_RISK",
+		})
+	}
+}
+
+// retryAfterSeconds rounds the delay up to the seconds of the Retry-After header, at least 1
+func retryAfterSeconds(delay time.Duration) int64 {
+	if delay >= time.Duration(math.MaxInt64) {
+		return 3600
+	}
+	return max(1, int64(math.Ceil(delay.Seconds())))
+}
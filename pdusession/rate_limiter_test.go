@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pdusession
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+func newRateLimitedRouter(rl *RateLimiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	group := router.Group("/nsmf-pdusession/v1")
+	group.Use(rl.Middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	group.POST("/sm-contexts", ok)
+	group.POST("/sm-contexts/:smContextRef/release", ok)
+	return router
+}
+
+// fire sends the requests concurrently from the AMF, returns the responses by status
+func fire(router *gin.Engine, amfIP, path string, requests int) map[int][]*httptest.ResponseRecorder {
+	var (
+		wg        sync.WaitGroup
+		lock      sync.Mutex
+		responses = make(map[int][]*httptest.ResponseRecorder)
+	)
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, path, nil)
+			req.RemoteAddr = amfIP + ":29518"
+			rsp := httptest.NewRecorder()
+			router.ServeHTTP(rsp, req)
+			lock.Lock()
+			responses[rsp.Code] = append(responses[rsp.Code], rsp)
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	return responses
+}
+
+func TestRateLimiterConcurrentRequests(t *testing.T) {
+	rl := NewRateLimiter(factory.RateLimit{
+		Default: factory.TokenBucket{Rate: 2, Burst: 20},
+		Amfs: map[string]factory.TokenBucket{
+			"10.0.0.2": {Rate: 2, Burst: 60},
+			"10.0.0.3": {},
+		},
+	})
+	start := time.Now()
+	rl.now = func() time.Time { return start }
+	router := newRateLimitedRouter(rl)
+
+	responses := fire(router, "10.0.0.1", "/nsmf-pdusession/v1/sm-contexts", 100)
+	require.Len(t, responses[http.StatusOK], 20)
+	require.Len(t, responses[http.StatusTooManyRequests], 80)
+	require.Equal(t, "1", responses[http.StatusTooManyRequests][0].Header().Get("Retry-After"))
+
+	// buckets configured per AMF
+	responses = fire(router, "10.0.0.2", "/nsmf-pdusession/v1/sm-contexts", 100)
+	require.Len(t, responses[http.StatusOK], 60)
+	require.Len(t, responses[http.StatusTooManyRequests], 40)
+	responses = fire(router, "10.0.0.3", "/nsmf-pdusession/v1/sm-contexts", 100)
+	require.Len(t, responses[http.StatusOK], 100)
+
+	// the releases are never limited
+	responses = fire(router, "10.0.0.1", "/nsmf-pdusession/v1/sm-contexts/ref/release", 100)
+	require.Len(t, responses[http.StatusOK], 100)
+
+	// the bucket is refilled over time
+	rl.now = func() time.Time { return start.Add(5 * time.Second) }
+	responses = fire(router, "10.0.0.1", "/nsmf-pdusession/v1/sm-contexts", 100)
+	require.Len(t, responses[http.StatusOK], 10)
+	require.Len(t, responses[http.StatusTooManyRequests], 90)
+}
@@ -18,6 +18,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/omec-project/smf/factory"
 	"github.com/omec-project/smf/logger"
 	utilLogger "github.com/omec-project/util/logger"
 )
@@ -46,6 +47,10 @@ func NewRouter() *gin.Engine {
 
 func AddService(engine *gin.Engine) *gin.RouterGroup {
 	group := engine.Group("/nsmf-pdusession/v1")
+	if configuration := factory.SmfConfig.Configuration; configuration != nil &&
+		configuration.Sbi != nil && configuration.Sbi.RateLimit != nil {
+		group.Use(NewRateLimiter(*configuration.Sbi.RateLimit).Middleware())
+	}
 
 	for _, route := range routes {
 		switch route.Method {
@@ -89,6 +94,13 @@ var routes = Routes{
 		UpdatePduSession,
 	},
 
+	{
+		"TransferSmContext",
+		strings.ToUpper("Post"),
+		"/sm-contexts/transfer",
+		HTTPTransferSmContext,
+	},
+
 	{
 		"ReleaseSmContext",
 		strings.ToUpper("Post"),
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pdusession
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/omec-project/openapi/Nnrf_NFDiscovery"
+	"github.com/omec-project/openapi/Nnrf_NFManagement"
+	"github.com/omec-project/openapi/models"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/producer"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newTransferTestSMF configures the SMF serving the DNN internet of the slice 1-010203 from the UE
+// pool returned, up to 2 concurrent sessions, discovering its PCF and AMF from an NRF, and accepting
+// the SM contexts transferred by the SMFs of the peers. The user plane of the adopted sessions is
+// set up at once. It returns the router of the SMF.
+func newTransferTestSMF(t *testing.T, peers []string) (*gin.Engine, *smf_context.IPAllocator) {
+	t.Helper()
+	smfSelf := smf_context.SMF_Self()
+	origNrfUri := smfSelf.NrfUri
+	origNFDiscoveryClient := smfSelf.NFDiscoveryClient
+	origNFManagementClient := smfSelf.NFManagementClient
+	origPeers := smfSelf.SmContextTransferPeers
+	origEstablishUserPlane := producer.EstablishTransferredUserPlane
+	t.Cleanup(func() {
+		producer.EstablishTransferredUserPlane = origEstablishUserPlane
+		smfSelf.NrfUri = origNrfUri
+		smfSelf.NFDiscoveryClient = origNFDiscoveryClient
+		smfSelf.NFManagementClient = origNFManagementClient
+		for _, nfInstanceID := range []string{"PCF-1", "AMF-1"} {
+			smfSelf.NfStatusSubscriptions.Delete(nfInstanceID)
+		}
+		smfSelf.SmContextTransferPeers = origPeers
+	})
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+
+	var nrf *httptest.Server
+	nrf = httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/subscriptions") {
+			w.WriteHeader(http.StatusCreated)
+			require.NoError(t, json.NewEncoder(w).Encode(models.NrfSubscriptionData{SubscriptionId: "1"}))
+			return
+		}
+		nfType := models.NfType(r.URL.Query().Get("target-nf-type"))
+		services := map[models.NfType]models.ServiceName{
+			models.NfType_PCF: models.ServiceName_NPCF_SMPOLICYCONTROL,
+			models.NfType_AMF: models.ServiceName_NAMF_COMM,
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(models.SearchResult{
+			NfInstances: []models.NfProfile{{
+				NfInstanceId: string(nfType) + "-1",
+				NfType:       nfType,
+				NfServices: &[]models.NfService{{
+					ServiceName: services[nfType],
+					ApiPrefix:   nrf.URL,
+				}},
+			}},
+		}))
+	}), &http2.Server{}))
+	t.Cleanup(nrf.Close)
+	discoveryConfig := Nnrf_NFDiscovery.NewConfiguration()
+	discoveryConfig.SetBasePath(nrf.URL)
+	smfSelf.NFDiscoveryClient = Nnrf_NFDiscovery.NewAPIClient(discoveryConfig)
+	managementConfig := Nnrf_NFManagement.NewConfiguration()
+	managementConfig.SetBasePath(nrf.URL)
+	smfSelf.NFManagementClient = Nnrf_NFManagement.NewAPIClient(managementConfig)
+	smfSelf.NrfUri = nrf.URL
+
+	ueIPAllocator, err := smf_context.NewIPAllocator("10.60.0.0/24")
+	require.NoError(t, err)
+	contexttest.SetSnssaiInfos(t, []smf_context.SnssaiSmfInfo{{
+		Snssai: smf_context.SNssai{Sst: 1, Sd: "010203"},
+		DnnInfos: map[string]*smf_context.SnssaiSmfDnnInfo{
+			"internet": {
+				UeIPAllocator:  ueIPAllocator,
+				SessionLimiter: smf_context.NewSessionLimiter("internet", &factory.SessionLimitConfig{MaxConcurrentSessions: 2}),
+			},
+		},
+	}})
+	smfSelf.SmContextTransferPeers = peers
+	producer.EstablishTransferredUserPlane = func(*smf_context.SMContext) error { return nil }
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	AddService(router)
+	return router, ueIPAllocator
+}
+
+// newTransferTestSMContext creates the session of the SUPI, with the UE IP 10.60.0.7 of a pool of
+// its own and a committed session rule
+func newTransferTestSMContext(t *testing.T, supi string) *smf_context.SMContext {
+	t.Helper()
+	ueIPAllocator, err := smf_context.NewIPAllocator("10.60.0.0/24")
+	require.NoError(t, err)
+	require.True(t, ueIPAllocator.AllocateIP(net.ParseIP("10.60.0.7")))
+	smContext := contexttest.NewSMContext(supi, 5, &models.Snssai{Sst: 1, Sd: "010203"},
+		&smf_context.SnssaiSmfDnnInfo{UeIPAllocator: ueIPAllocator})
+	smContext.AnType = models.AccessType__3_GPP_ACCESS
+	smContext.ServingNfId = "AMF-1"
+	smContext.PDUAddress = &smf_context.UeIpAddr{Ip: net.ParseIP("10.60.0.7").To4()}
+	smContext.Tunnel = smf_context.NewUPTunnel()
+	smContext.Tunnel.ANInformation.IPAddress = net.ParseIP("10.1.0.2")
+	smContext.Tunnel.ANInformation.TEID = 7
+	smContext.PFCPContext["10.0.0.5"] = &smf_context.PFCPSessionContext{LocalSEID: 1, RemoteSEID: 2}
+	smContext.SMContextState = smf_context.SmStateInActivePending
+	smContext.SmPolicyData.SmCtxtSessionRules.SessionRules["rule-1"] = &models.SessionRule{SessRuleId: "rule-1"}
+	t.Cleanup(func() {
+		if ref, err := smf_context.ResolveRef(supi, 5); err == nil && smf_context.GetSMContext(ref) != nil {
+			smf_context.RemoveSMContext(ref)
+		}
+	})
+	return smContext
+}
+
+// transferBody returns the transfer of the SM context by the old SMF, the SM context being removed
+// from this SMF
+func transferBody(t *testing.T, smContext *smf_context.SMContext) []byte {
+	t.Helper()
+	body, err := json.Marshal(&smf_context.SMContextTransfer{SmContext: smContext})
+	require.NoError(t, err)
+	smf_context.RemoveSMContext(smContext.Ref)
+	return body
+}
+
+// transferSMContext sends the transfer of the SM context from the SMF of the IP address
+func transferSMContext(router *gin.Engine, from string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/sm-contexts/transfer", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = from + ":38412"
+	rsp := httptest.NewRecorder()
+	router.ServeHTTP(rsp, req)
+	return rsp
+}
+
+func TestHTTPTransferSmContext(t *testing.T) {
+	router, ueIPAllocator := newTransferTestSMF(t, []string{"10.0.0.20"})
+	source := newTransferTestSMContext(t, "imsi-208930000000011")
+
+	body := transferBody(t, source)
+	rsp := transferSMContext(router, "10.0.0.20", body)
+	require.Equal(t, http.StatusCreated, rsp.Code)
+
+	// adopted under its own reference, without the PFCP sessions of the old SMF
+	targetRef, err := smf_context.ResolveRef("imsi-208930000000011", 5)
+	require.NoError(t, err)
+	require.Equal(t, targetRef, rsp.Header().Get("Location"))
+	require.NotEqual(t, source.Ref, targetRef)
+	target := smf_context.GetSMContext(targetRef)
+	require.NotNil(t, target)
+	require.NotSame(t, source, target)
+	require.Equal(t, "imsi-208930000000011", target.Supi)
+	require.Equal(t, "internet", target.Dnn)
+	require.Equal(t, models.Snssai{Sst: 1, Sd: "010203"}, *target.Snssai)
+	require.Same(t, smf_context.SMF_Self().SnssaiInfos[0].DnnInfos["internet"], target.DNNInfo)
+	require.True(t, target.PDUAddress.Ip.Equal(net.ParseIP("10.60.0.7")))
+	require.False(t, ueIPAllocator.AllocateIP(net.ParseIP("10.60.0.7")), "UE IP reserved in the local pool")
+	require.NotNil(t, target.SMPolicyClient)
+	require.NotNil(t, target.CommunicationClient)
+	require.True(t, target.Tunnel.ANInformation.IPAddress.Equal(net.ParseIP("10.1.0.2")))
+	require.Equal(t, uint32(7), target.Tunnel.ANInformation.TEID)
+	require.Equal(t, 1, target.DNNInfo.SessionLimiter.Active())
+
+	// a session already served is not adopted twice
+	rsp = transferSMContext(router, "10.0.0.20", body)
+	require.Equal(t, http.StatusBadRequest, rsp.Code)
+	ref, err := smf_context.ResolveRef("imsi-208930000000011", 5)
+	require.NoError(t, err)
+	require.Equal(t, targetRef, ref)
+}
+
+func TestHTTPTransferSmContextUserPlaneFailed(t *testing.T) {
+	router, ueIPAllocator := newTransferTestSMF(t, []string{"10.0.0.20"})
+	var adopted *smf_context.SMContext
+	producer.EstablishTransferredUserPlane = func(smContext *smf_context.SMContext) error {
+		adopted = smContext
+		return errors.New("no UPF")
+	}
+	source := newTransferTestSMContext(t, "imsi-208930000000015")
+
+	// the transfer is rejected, the old SMF keeping its user plane
+	rsp := transferSMContext(router, "10.0.0.20", transferBody(t, source))
+	require.Equal(t, http.StatusServiceUnavailable, rsp.Code)
+	require.NotNil(t, adopted)
+	_, err := smf_context.ResolveRef("imsi-208930000000015", 5)
+	require.Error(t, err)
+	require.Equal(t, 0, adopted.DNNInfo.SessionLimiter.Active())
+	require.True(t, ueIPAllocator.AllocateIP(net.ParseIP("10.60.0.7")), "UE IP released")
+}
+
+func TestHTTPTransferSmContextRejected(t *testing.T) {
+	testCases := []struct {
+		name   string
+		from   string
+		dnn    string
+		ueIP   bool
+		status int
+	}{
+		{name: "PeerNotAllowed", from: "10.0.0.21", dnn: "internet", status: http.StatusForbidden},
+		{name: "DnnNotServed", from: "10.0.0.20", dnn: "enterprise", status: http.StatusBadRequest},
+		{name: "UeIPAllocated", from: "10.0.0.20", dnn: "internet", ueIP: true, status: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router, ueIPAllocator := newTransferTestSMF(t, []string{"10.0.0.20"})
+			if tc.ueIP {
+				require.True(t, ueIPAllocator.AllocateIP(net.ParseIP("10.60.0.7")))
+			}
+			source := newTransferTestSMContext(t, "imsi-208930000000012")
+			source.Dnn = tc.dnn
+
+			rsp := transferSMContext(router, tc.from, transferBody(t, source))
+			require.Equal(t, tc.status, rsp.Code)
+			_, err := smf_context.ResolveRef("imsi-208930000000012", 5)
+			require.Error(t, err)
+			if !tc.ueIP {
+				require.True(t, ueIPAllocator.AllocateIP(net.ParseIP("10.60.0.7")), "UE IP not reserved")
+			}
+		})
+	}
+}
+
+func TestTransferSMContext(t *testing.T) {
+	newTransferTestSMF(t, nil)
+	var transferred smf_context.SMContextTransfer
+	targetSmf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/nsmf-pdusession/v1/sm-contexts/transfer", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&transferred))
+		w.WriteHeader(http.StatusCreated)
+	}), &http2.Server{}))
+	t.Cleanup(targetSmf.Close)
+	source := newTransferTestSMContext(t, "imsi-208930000000013")
+	sourceAllocator := source.DNNInfo.UeIPAllocator
+
+	require.NoError(t, producer.TransferSMContext(source.Ref, targetSmf.URL))
+	require.Equal(t, "imsi-208930000000013", transferred.SmContext.Supi)
+
+	// the old SMF released the session and its UE IP
+	require.Nil(t, smf_context.GetSMContext(source.Ref))
+	require.True(t, sourceAllocator.AllocateIP(net.ParseIP("10.60.0.7")))
+}
+
+func TestTransferSMContextFailed(t *testing.T) {
+	newTransferTestSMF(t, nil)
+	targetSmf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}), &http2.Server{}))
+	t.Cleanup(targetSmf.Close)
+	source := newTransferTestSMContext(t, "imsi-208930000000014")
+
+	require.Error(t, producer.TransferSMContext(source.Ref, targetSmf.URL))
+	require.Same(t, source, smf_context.GetSMContext(source.Ref))
+	require.Equal(t, smf_context.SmStateInActivePending, source.SMContextState)
+	require.Error(t, producer.TransferSMContext("urn:uuid:unknown", targetSmf.URL))
+}
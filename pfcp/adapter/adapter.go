@@ -4,6 +4,7 @@
 package adapter
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -130,6 +131,7 @@ func HandlePfcpAssociationSetupResponse(msg *udp.Message) {
 		}
 
 		upf.UPFStatus = context.AssociatedSetUpSuccess
+		upf.ClearError()
 		if rsp.RecoveryTimeStamp == nil {
 			logger.PfcpLog.Errorln("pfcp association setup response has no RecoveryTimeStamp")
 			return
@@ -187,6 +189,7 @@ func HandlePfcpHeartbeatResponse(msg *udp.Message) {
 		// change UPF state to not associated so that
 		// PFCP Association can be initiated again
 		upf.UPFStatus = context.NotAssociated
+		upf.RecordError(fmt.Errorf("recovery timestamp changed from [%v] to [%v]", upf.RecoveryTimeStamp.RecoveryTimeStamp, recoveryTimestamp))
 		logger.PfcpLog.Warnf("PFCP Heartbeat Response, upf [%v] recovery timestamp changed", upf.NodeID)
 
 		// TODO: Session cleanup required and updated to AMF/PCF
@@ -262,27 +265,38 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 			}
 
 			// Update with one received from UPF
-			smContext.PDUAddress.Ip = ueIPAddress
-			smContext.PDUAddress.UpfProvided = true
+			smContext.SetUpfProvidedUeIp(ueIPAddress)
 		}
 
-		// Store F-TEID created by UPF
-		fteid, err := FindFTEID(rsp.CreatedPDR)
-		if err != nil {
-			logger.PfcpLog.Errorf("failed to parse TEID IE: %+v", err)
-			return
+		// Store F-TEID created by UPF, unless allocated by the SMF in the TEID partition of the UPF
+		if !ANUPF.UPF.HasTEIDPartition() {
+			fteid, err := FindFTEID(rsp.CreatedPDR)
+			if err != nil {
+				logger.PfcpLog.Errorf("failed to parse TEID IE: %+v", err)
+				return
+			}
+			logger.PfcpLog.Infof("created PDR FTEID: %+v", fteid)
+			ANUPF.UpLinkTunnel.TEID = fteid.TEID
+			upf := context.RetrieveUPFNodeByNodeID(*nodeID)
+			if upf == nil {
+				logger.PfcpLog.Errorf("can't find UPF[%s]", nodeID.ResolveNodeIdToIp().String())
+				return
+			}
+			upf.N3Interfaces = make([]context.UPFInterfaceInfo, 0)
+			n3Interface := context.UPFInterfaceInfo{}
+			n3Interface.IPv4EndPointAddresses = append(n3Interface.IPv4EndPointAddresses, fteid.IPv4Address)
+			upf.N3Interfaces = append(upf.N3Interfaces, n3Interface)
 		}
-		logger.PfcpLog.Infof("created PDR FTEID: %+v", fteid)
-		ANUPF.UpLinkTunnel.TEID = fteid.TEID
-		upf := context.RetrieveUPFNodeByNodeID(*nodeID)
-		if upf == nil {
-			logger.PfcpLog.Errorf("can't find UPF[%s]", nodeID.ResolveNodeIdToIp().String())
-			return
+	}
+
+	// DS-TT port of the session on the TSN bridge of the UPF
+	if rsp.CreatedBridgeInfoForTSC != nil {
+		if bridge, err := context.ParseCreatedBridgeInfo(rsp.CreatedBridgeInfoForTSC); err != nil {
+			smContext.SubPfcpLog.Errorf("invalid Created Bridge Info for TSC: %v", err)
+		} else {
+			bridge.UPFNodeID = *nodeID
+			smContext.TSNBridge = bridge
 		}
-		upf.N3Interfaces = make([]context.UPFInterfaceInfo, 0)
-		n3Interface := context.UPFInterfaceInfo{}
-		n3Interface.IPv4EndPointAddresses = append(n3Interface.IPv4EndPointAddresses, fteid.IPv4Address)
-		upf.N3Interfaces = append(upf.N3Interfaces, n3Interface)
 	}
 
 	if rsp.NodeID == nil {
@@ -313,11 +327,12 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 		}
 		// UPF Accept
 		if causeValue == ie.CauseRequestAccepted {
-			smContext.SBIPFCPCommunicationChan <- context.SessionEstablishSuccess
+			smContext.PostPFCPResponseStatus(context.SessionEstablishSuccess)
 			smContext.SubPfcpLog.Infof("PFCP Session Establishment accepted")
 		} else {
-			smContext.SBIPFCPCommunicationChan <- context.SessionEstablishFailed
-			smContext.SubPfcpLog.Errorf("PFCP Session Establishment rejected with cause [%v]", causeValue)
+			smContext.PFCPError = context.NewPFCPError(rsp.MessageTypeName(), rsp.Cause, rsp.OffendingIE)
+			smContext.PostPFCPResponseStatus(context.SessionEstablishFailed)
+			smContext.SubPfcpLog.Errorf("PFCP Session Establishment failed: %v", smContext.PFCPError)
 			if causeValue == ie.CauseNoEstablishedPFCPAssociation {
 				SetUpfInactive(*rspNodeID)
 			}
@@ -370,7 +385,7 @@ func HandlePfcpSessionModificationResponse(msg *udp.Message) {
 			smContext.SubPduSessLog.Debugf("delete pending pfcp response: UPF IP [%s]", upfIP)
 
 			if smContext.PendingUPF.IsEmpty() {
-				smContext.SBIPFCPCommunicationChan <- context.SessionUpdateSuccess
+				smContext.PostPFCPResponseStatus(context.SessionUpdateSuccess)
 			}
 		}
 
@@ -378,7 +393,7 @@ func HandlePfcpSessionModificationResponse(msg *udp.Message) {
 	} else {
 		smContext.SubPfcpLog.Infof("PFCP Session Modification Failed[%d]\n", SEID)
 		if smContext.SMContextState == context.SmStatePfcpModify {
-			smContext.SBIPFCPCommunicationChan <- context.SessionUpdateFailed
+			smContext.PostPFCPResponseStatus(context.SessionUpdateFailed)
 		}
 	}
 
@@ -432,13 +447,13 @@ func HandlePfcpSessionDeletionResponse(msg *udp.Message) {
 			smContext.SubPduSessLog.Debugf("delete pending pfcp response: UPF IP [%s]", upfIP)
 
 			if smContext.PendingUPF.IsEmpty() && !smContext.LocalPurged {
-				smContext.SBIPFCPCommunicationChan <- context.SessionReleaseSuccess
+				smContext.PostPFCPResponseStatus(context.SessionReleaseSuccess)
 			}
 		}
 		smContext.SubPfcpLog.Infof("PFCP Session Deletion Success[%d]", SEID)
 	} else {
 		if smContext.SMContextState == context.SmStatePfcpRelease && !smContext.LocalPurged {
-			smContext.SBIPFCPCommunicationChan <- context.SessionReleaseSuccess
+			smContext.PostPFCPResponseStatus(context.SessionReleaseSuccess)
 		}
 		smContext.SubPfcpLog.Infof("PFCP Session Deletion Failed[%d]", SEID)
 	}
@@ -456,4 +471,5 @@ func SetUpfInactive(nodeID context.NodeID) {
 
 	upf.UPFStatus = context.NotAssociated
 	upf.NHeartBeat = 0 // reset Heartbeat attempt to 0
+	upf.RecordError(errors.New("PFCP Session Establishment rejected, no PFCP association established"))
 }
@@ -10,6 +10,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"slices"
+	"time"
 
 	"github.com/omec-project/openapi/models"
 	smf_context "github.com/omec-project/smf/context"
@@ -19,6 +21,7 @@ import (
 	"github.com/omec-project/smf/pfcp/ies"
 	pfcp_message "github.com/omec-project/smf/pfcp/message"
 	"github.com/omec-project/smf/pfcp/udp"
+	pfcp_upf "github.com/omec-project/smf/pfcp/upf"
 	"github.com/omec-project/smf/producer"
 	mi "github.com/omec-project/util/metricinfo"
 	"github.com/wmnsk/go-pfcp/ie"
@@ -68,7 +71,7 @@ func HandlePfcpHeartbeatResponse(msg *udp.Message) {
 
 	// Get NodeId from Seq:NodeId Map
 	seq := rsp.Sequence()
-	nodeID := pfcp_message.FetchPfcpTxn(seq)
+	nodeID := pfcp_message.FetchPfcpTxn(msg.RemoteAddr.IP, seq)
 
 	if nodeID == nil {
 		logger.PfcpLog.Errorf("no pending pfcp heartbeat response for sequence no: %v", seq)
@@ -97,6 +100,7 @@ func HandlePfcpHeartbeatResponse(msg *udp.Message) {
 		// change UPF state to not associated so that
 		// PFCP Association can be initiated again
 		upf.UPFStatus = smf_context.NotAssociated
+		upf.RecordError(fmt.Errorf("recovery timestamp changed from [%v] to [%v]", upf.RecoveryTimeStamp.RecoveryTimeStamp, rspRecoveryTimeStamp))
 		logger.PfcpLog.Warnf("PFCP Heartbeat Response, upf [%v] recovery timestamp changed, previous [%v], new [%v] ", upf.NodeID, upf.RecoveryTimeStamp, *rsp.RecoveryTimeStamp)
 
 		// TODO: Session cleanup required and updated to AMF/PCF
@@ -133,6 +137,7 @@ func SetUpfInactive(nodeID smf_context.NodeID, msgTypeName string) {
 	defer upf.UpfLock.Unlock()
 	upf.UPFStatus = smf_context.NotAssociated
 	upf.NHeartBeat = 0 // reset Heartbeat attempt to 0
+	upf.RecordError(fmt.Errorf("%s rejected, no PFCP association established", msgTypeName))
 }
 
 func HandlePfcpPfdManagementRequest(msg *udp.Message) {
@@ -174,6 +179,10 @@ func HandlePfcpAssociationSetupRequest(msg *udp.Message) {
 	}
 
 	upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
+	if upf == nil && msg.RemoteAddr != nil {
+		// the UPF identifies itself with a Node ID of another type than the configured one
+		upf = smf_context.RetrieveUPFNodeByNodeID(*smf_context.NewNodeID(msg.RemoteAddr.IP.String()))
+	}
 	if upf == nil {
 		logger.PfcpLog.Errorf("can not find UPF[%s]", nodeIDStr)
 		return
@@ -188,7 +197,7 @@ func HandlePfcpAssociationSetupRequest(msg *udp.Message) {
 	upf.NHeartBeat = 0 // reset Heartbeat attempt to 0
 
 	// Response with PFCP Association Setup Response
-	err = pfcp_message.SendPfcpAssociationSetupResponse(*nodeID, ie.CauseRequestAccepted, upf.Port)
+	err = pfcp_message.SendPfcpAssociationSetupResponse(upf.NodeID, ie.CauseRequestAccepted, upf.Port)
 	if err != nil {
 		logger.PfcpLog.Errorf("failed to send PFCP Association Setup Response: %+v", err)
 	}
@@ -225,7 +234,7 @@ func HandlePfcpAssociationSetupResponse(msg *udp.Message) {
 
 		// Get NodeId from Seq:NodeId Map
 		seq := rsp.Sequence()
-		nodeID := pfcp_message.FetchPfcpTxn(seq)
+		nodeID := pfcp_message.FetchPfcpTxn(msg.RemoteAddr.IP, seq)
 
 		if nodeID == nil {
 			logger.PfcpLog.Errorf("no pending pfcp Assoc req for sequence no: %v", seq)
@@ -242,14 +251,24 @@ func HandlePfcpAssociationSetupResponse(msg *udp.Message) {
 		upf.UpfLock.Lock()
 		defer upf.UpfLock.Unlock()
 		upf.UPFStatus = smf_context.AssociatedSetUpSuccess
+		upf.ClearError()
 		recoveryTimestamp, err := rsp.RecoveryTimeStamp.RecoveryTimeStamp()
 		if err != nil {
 			logger.PfcpLog.Errorf("failed to parse RecoveryTimeStamp: %+v", err)
 			return
 		}
+		previousTimestamp := upf.RecoveryTimeStamp.RecoveryTimeStamp
 		upf.RecoveryTimeStamp = smf_context.RecoveryTimeStamp{
 			RecoveryTimeStamp: recoveryTimestamp,
 		}
+		if upf.SessionsLost || !previousTimestamp.IsZero() && !previousTimestamp.Equal(recoveryTimestamp) {
+			// the UPF restarted or was re-associated, verify the sessions it held instead of
+			// tearing them down
+			logger.PfcpLog.Infof("UPF[%s] restarted or re-associated, recovering its sessions",
+				nodeID.ResolveNodeIdToIp().String())
+			upf.SessionsLost = false
+			go pfcp_upf.OffloadSessionRecovery(upf)
+		}
 		upf.NHeartBeat = 0 // reset Heartbeat attempt to 0
 
 		if *factory.SmfConfig.Configuration.KafkaInfo.EnableKafka {
@@ -277,6 +296,24 @@ func HandlePfcpAssociationSetupResponse(msg *udp.Message) {
 			logger.PfcpLog.Debugf("handle PFCP Association Setup success Response, received UPFunctionFeatures= %v ", UPFunctionFeatures)
 			upf.UPFunctionFeatures = UPFunctionFeatures
 		}
+	} else {
+		logger.PfcpLog.Errorf("PFCP Association Setup Response with NodeID[%s] rejected with cause [%d]", nodeIDStr, causeValue)
+
+		seq := rsp.Sequence()
+		nodeID := pfcp_message.FetchPfcpTxn(msg.RemoteAddr.IP, seq)
+		if nodeID == nil {
+			logger.PfcpLog.Errorf("no pending pfcp Assoc req for sequence no: %v", seq)
+			return
+		}
+		upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
+		if upf == nil {
+			logger.PfcpLog.Errorf("can not find UPF[%s]", nodeID.ResolveNodeIdToIp().String())
+			return
+		}
+		upf.UpfLock.Lock()
+		upf.UPFStatus = smf_context.NotAssociated
+		upf.UpfLock.Unlock()
+		upf.RecordError(fmt.Errorf("PFCP Association Setup rejected with cause [%d]", causeValue))
 	}
 }
 
@@ -358,8 +395,55 @@ func HandlePfcpVersionNotSupportedResponse(msg *udp.Message) {
 	logger.PfcpLog.Warnln("PFCP Version Not Support Response handling is not implemented")
 }
 
+// HandlePfcpNodeReportRequest records the load of the UPF carried in its UPF Load Report IE, the
+// other node reports are acknowledged only
 func HandlePfcpNodeReportRequest(msg *udp.Message) {
-	logger.PfcpLog.Warnln("PFCP Node Report Request handling is not implemented")
+	pfcpMsg, ok := msg.PfcpMessage.(*message.NodeReportRequest)
+	if !ok {
+		logger.PfcpLog.Errorln("invalid message type for node report request")
+		return
+	}
+	logger.PfcpLog.Infoln("handle PFCP Node Report Request")
+
+	if pfcpMsg.NodeID == nil {
+		logger.PfcpLog.Errorln("pfcp node report needs NodeID")
+		return
+	}
+	nodeIDStr, err := pfcpMsg.NodeID.NodeID()
+	if err != nil {
+		logger.PfcpLog.Errorf("failed to parse NodeID IE: %+v", err)
+		return
+	}
+	nodeID := smf_context.NewNodeID(nodeIDStr)
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
+	if upf == nil {
+		logger.PfcpLog.Errorf("can not find UPF[%s]", nodeIDStr)
+		if err = pfcp_message.SendPfcpNodeReportResponse(*nodeID, msg.RemoteAddr, ie.CauseNoEstablishedPFCPAssociation,
+			pfcpMsg.Sequence()); err != nil {
+			logger.PfcpLog.Errorf("failed to send PFCP Node Report Response: %+v", err)
+		}
+		return
+	}
+
+	for _, i := range pfcpMsg.IEs {
+		if i.Type != ies.UPFLoadReportIEType {
+			continue
+		}
+		load, err := ies.ParseUPFLoadReport(i, time.Now())
+		if err != nil {
+			logger.PfcpLog.Warnf("UPF[%s] load report ignored: %v", nodeIDStr, err)
+			continue
+		}
+		upf.SetLoadMetrics(*load)
+		logger.PfcpLog.Infof("UPF[%s] load [cpu: %d%%, memory: %d%%, sessions: %d]", nodeIDStr,
+			load.CPULoad, load.MemoryLoad, load.SessionCount)
+	}
+
+	if err = pfcp_message.SendPfcpNodeReportResponse(*nodeID, msg.RemoteAddr, ie.CauseRequestAccepted,
+		pfcpMsg.Sequence()); err != nil {
+		logger.PfcpLog.Errorf("failed to send PFCP Node Report Response: %+v", err)
+	}
 }
 
 func HandlePfcpNodeReportResponse(msg *udp.Message) {
@@ -400,7 +484,7 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 
 	// Get NodeId from Seq:NodeId Map
 	seq := rsp.Sequence()
-	nodeID := pfcp_message.FetchPfcpTxn(seq)
+	nodeID := pfcp_message.FetchPfcpTxn(msg.RemoteAddr.IP, seq)
 	if nodeID == nil {
 		logger.PfcpLog.Errorf("no pending pfcp response for sequence no: %v", seq)
 		return
@@ -419,6 +503,18 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 		smContext.SubPfcpLog.Infof("in HandlePfcpSessionEstablishmentResponse rsp.UPFSEID.Seid [%v] ", rspUPFseid.SEID)
 	}
 
+	// DS-TT port of the session on the TSN bridge of the UPF
+	if rsp.CreatedBridgeInfoForTSC != nil {
+		if bridge, err := smf_context.ParseCreatedBridgeInfo(rsp.CreatedBridgeInfoForTSC); err != nil {
+			smContext.SubPfcpLog.Errorf("invalid Created Bridge Info for TSC: %v", err)
+		} else {
+			bridge.UPFNodeID = *nodeID
+			smContext.TSNBridge = bridge
+			smContext.SubPfcpLog.Infof("TSN bridge [%s] DS-TT port %d NW-TT port %d", bridge.BridgeID,
+				bridge.DSTTPortNumber, bridge.NWTTPortNumber)
+		}
+	}
+
 	// Get N3 interface UPF
 	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
 	if defaultPath == nil {
@@ -438,27 +534,28 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 			}
 
 			// Update with one received from UPF
-			smContext.PDUAddress.Ip = ueIPAddress
-			smContext.PDUAddress.UpfProvided = true
+			smContext.SetUpfProvidedUeIp(ueIPAddress)
 		}
 
-		// Store F-TEID created by UPF
-		fteid, err := FindFTEID(rsp.CreatedPDR)
-		if err != nil {
-			logger.PfcpLog.Errorf("failed to parse TEID IE: %+v", err)
-			return
-		}
-		logger.PfcpLog.Infof("created PDR FTEID: %+v", fteid)
-		ANUPF.UpLinkTunnel.TEID = fteid.TEID
-		upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
-		if upf == nil {
-			logger.PfcpLog.Errorf("can't find UPF[%s]", nodeID.ResolveNodeIdToIp().String())
-			return
+		// Store F-TEID created by UPF, unless allocated by the SMF in the TEID partition of the UPF
+		if !ANUPF.UPF.HasTEIDPartition() {
+			fteid, err := FindFTEID(rsp.CreatedPDR)
+			if err != nil {
+				logger.PfcpLog.Errorf("failed to parse TEID IE: %+v", err)
+				return
+			}
+			logger.PfcpLog.Infof("created PDR FTEID: %+v", fteid)
+			ANUPF.UpLinkTunnel.TEID = fteid.TEID
+			upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
+			if upf == nil {
+				logger.PfcpLog.Errorf("can't find UPF[%s]", nodeID.ResolveNodeIdToIp().String())
+				return
+			}
+			upf.N3Interfaces = make([]smf_context.UPFInterfaceInfo, 0)
+			n3Interface := smf_context.UPFInterfaceInfo{}
+			n3Interface.IPv4EndPointAddresses = append(n3Interface.IPv4EndPointAddresses, fteid.IPv4Address)
+			upf.N3Interfaces = append(upf.N3Interfaces, n3Interface)
 		}
-		upf.N3Interfaces = make([]smf_context.UPFInterfaceInfo, 0)
-		n3Interface := smf_context.UPFInterfaceInfo{}
-		n3Interface.IPv4EndPointAddresses = append(n3Interface.IPv4EndPointAddresses, fteid.IPv4Address)
-		upf.N3Interfaces = append(upf.N3Interfaces, n3Interface)
 	}
 
 	if rsp.NodeID == nil {
@@ -472,6 +569,8 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 	}
 	rspNodeID := smf_context.NewNodeID(rspNodeIDStr)
 
+	pendingFragments, fragmentErr := continueSplitEstablishment(smContext, *nodeID, rsp.Cause)
+
 	if ANUPF.UPF == nil {
 		logger.PfcpLog.Errorln("failed to get UPF from default path")
 		return
@@ -489,11 +588,20 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 			return
 		}
 		if causeValue == ie.CauseRequestAccepted {
-			smContext.SBIPFCPCommunicationChan <- smf_context.SessionEstablishSuccess
-			smContext.SubPfcpLog.Infoln("PFCP Session Establishment accepted")
+			switch {
+			case fragmentErr != nil:
+				smContext.PostPFCPResponseStatus(smf_context.SessionEstablishFailed)
+				smContext.SubPfcpLog.Errorf("PFCP Session Establishment failed: %v", fragmentErr)
+			case pendingFragments:
+				smContext.SubPfcpLog.Infoln("PFCP Session Establishment accepted, sending the remaining rules")
+			default:
+				smContext.PostPFCPResponseStatus(smf_context.SessionEstablishSuccess)
+				smContext.SubPfcpLog.Infoln("PFCP Session Establishment accepted")
+			}
 		} else {
-			smContext.SBIPFCPCommunicationChan <- smf_context.SessionEstablishFailed
-			smContext.SubPfcpLog.Errorf("PFCP Session Establishment rejected with cause [%v]", causeValue)
+			smContext.PFCPError = smf_context.NewPFCPError(rsp.MessageTypeName(), rsp.Cause, rsp.OffendingIE)
+			smContext.PostPFCPResponseStatus(smf_context.SessionEstablishFailed)
+			smContext.SubPfcpLog.Errorf("PFCP Session Establishment failed: %v", smContext.PFCPError)
 			if causeValue == ie.CauseNoEstablishedPFCPAssociation {
 				SetUpfInactive(*rspNodeID, msg.PfcpMessage.MessageTypeName())
 			}
@@ -509,6 +617,44 @@ func HandlePfcpSessionEstablishmentResponse(msg *udp.Message) {
 	}
 }
 
+// continueSplitEstablishment sends the next rules of a session establishment split over several
+// PFCP messages once the previous message is accepted. It returns true while rules remain to be
+// acknowledged, and an error when the establishment failed.
+func continueSplitEstablishment(smContext *smf_context.SMContext, nodeID smf_context.NodeID, cause *ie.IE) (bool, error) {
+	pfcpContext := smContext.PFCPContext[nodeID.ResolveNodeIdToIp().String()]
+	if pfcpContext == nil || !pfcpContext.EstablishingFragments {
+		return false, nil
+	}
+
+	if cause == nil {
+		pfcpContext.PendingRules = nil
+		pfcpContext.EstablishingFragments = false
+		return false, fmt.Errorf("response missing Cause")
+	}
+	if causeValue, err := cause.Cause(); err != nil || causeValue != ie.CauseRequestAccepted {
+		pfcpContext.PendingRules = nil
+		pfcpContext.EstablishingFragments = false
+		return false, fmt.Errorf("split establishment rejected with cause [%v]", causeValue)
+	}
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(nodeID)
+	if upf == nil {
+		pfcpContext.PendingRules = nil
+		pfcpContext.EstablishingFragments = false
+		return false, fmt.Errorf("can't find UPF[%s]", nodeID.ResolveNodeIdToIp().String())
+	}
+	sent, err := pfcp_message.SendNextPfcpSessionRules(nodeID, smContext, upf.Port)
+	if err != nil {
+		pfcpContext.PendingRules = nil
+		pfcpContext.EstablishingFragments = false
+		return false, err
+	}
+	if !sent {
+		pfcpContext.EstablishingFragments = false
+	}
+	return sent, nil
+}
+
 func HandlePfcpSessionModificationResponse(msg *udp.Message) {
 	rsp, ok := msg.PfcpMessage.(*message.SessionModificationResponse)
 	if !ok {
@@ -532,6 +678,27 @@ func HandlePfcpSessionModificationResponse(msg *udp.Message) {
 
 	logger.PfcpLog.Infoln("in HandlePfcpSessionModificationResponse")
 
+	// response to a message of the UE reachability probe
+	if smContext != nil &&
+		smContext.CompleteReachabilityProbe(smContext.GetNodeIDByLocalSEID(SEID), rsp.Sequence(), rsp) {
+		return
+	}
+
+	// response to a session report fetch of the session recovery
+	if rsp.Cause != nil {
+		if causeValue, err := rsp.Cause.Cause(); err == nil &&
+			smContext.CompleteRecoveryProbe(smContext.GetNodeIDByLocalSEID(SEID), causeValue) {
+			return
+		}
+	}
+
+	// port management of the NW-TT answered by the UPF
+	for _, i := range rsp.IEs {
+		if i.Type == ie.TSCManagementInformationWithinSessionModificationResponse {
+			handleTSCManagementInformation(smContext, i)
+		}
+	}
+
 	if smf_context.SMF_Self().ULCLSupport && smContext.BPManager != nil {
 		if smContext.BPManager.BPStatus == smf_context.AddingPSA {
 			smContext.SubPfcpLog.Infoln("keep Adding PSAAndULCL")
@@ -552,6 +719,27 @@ func HandlePfcpSessionModificationResponse(msg *udp.Message) {
 		return
 	}
 
+	// response to a message of a split session establishment
+	if upfNodeID := smContext.GetNodeIDByLocalSEID(SEID); smContext.IsEstablishingFragments(upfNodeID) {
+		pending, err := continueSplitEstablishment(smContext, upfNodeID, rsp.Cause)
+		if pending {
+			return
+		}
+		defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+		if defaultPath == nil || defaultPath.FirstDPNode == nil || defaultPath.FirstDPNode.UPF == nil ||
+			!defaultPath.FirstDPNode.UPF.NodeID.ResolveNodeIdToIp().Equal(upfNodeID.ResolveNodeIdToIp()) {
+			return
+		}
+		if err != nil {
+			smContext.PostPFCPResponseStatus(smf_context.SessionEstablishFailed)
+			smContext.SubPfcpLog.Errorf("PFCP Session Establishment failed: %v", err)
+		} else {
+			smContext.PostPFCPResponseStatus(smf_context.SessionEstablishSuccess)
+			smContext.SubPfcpLog.Infoln("PFCP Session Establishment accepted")
+		}
+		return
+	}
+
 	if causeValue == ie.CauseRequestAccepted {
 		smContext.SubPduSessLog.Infoln("PFCP Modification Response Accept")
 		if smContext.SMContextState == smf_context.SmStatePfcpModify {
@@ -561,7 +749,7 @@ func HandlePfcpSessionModificationResponse(msg *udp.Message) {
 			smContext.SubPduSessLog.Debugf("delete pending pfcp response: UPF IP [%s]", upfIP)
 
 			if smContext.PendingUPF.IsEmpty() {
-				smContext.SBIPFCPCommunicationChan <- smf_context.SessionUpdateSuccess
+				smContext.PostPFCPResponseStatus(smf_context.SessionUpdateSuccess)
 			}
 
 			if smf_context.SMF_Self().ULCLSupport && smContext.BPManager != nil {
@@ -578,7 +766,7 @@ func HandlePfcpSessionModificationResponse(msg *udp.Message) {
 	} else {
 		smContext.SubPfcpLog.Infof("PFCP Session Modification Failed[%d]", SEID)
 		if smContext.SMContextState == smf_context.SmStatePfcpModify {
-			smContext.SBIPFCPCommunicationChan <- smf_context.SessionUpdateFailed
+			smContext.PostPFCPResponseStatus(smf_context.SessionUpdateFailed)
 		}
 	}
 
@@ -625,23 +813,86 @@ func HandlePfcpSessionDeletionResponse(msg *udp.Message) {
 	}
 
 	if causeValue == ie.CauseRequestAccepted {
+		upfNodeID := smContext.GetNodeIDByLocalSEID(SEID)
+		upfIP := upfNodeID.ResolveNodeIdToIp().String()
+		// final usage of the session, forwarded to charging on release
+		if usageReports := parseUsageReports(upfIP, rsp.UsageReport); len(usageReports) > 0 {
+			smContext.AddFinalUsage(usageReports)
+			smContext.SubPfcpLog.Infof("PFCP Session Deletion final usage from UPF[%s]: %+v", upfIP, usageReports)
+		}
 		if smContext.SMContextState == smf_context.SmStatePfcpRelease {
-			upfNodeID := smContext.GetNodeIDByLocalSEID(SEID)
-			upfIP := upfNodeID.ResolveNodeIdToIp().String()
 			delete(smContext.PendingUPF, upfIP)
 			smContext.SubPduSessLog.Debugf("delete pending pfcp response: UPF IP [%s]", upfIP)
 
 			if smContext.PendingUPF.IsEmpty() && !smContext.LocalPurged {
-				smContext.SBIPFCPCommunicationChan <- smf_context.SessionReleaseSuccess
+				smContext.PostPFCPResponseStatus(smf_context.SessionReleaseSuccess)
 			}
 		}
 		smContext.SubPfcpLog.Infof("PFCP Session Deletion Success[%d]", SEID)
 	} else {
 		if smContext.SMContextState == smf_context.SmStatePfcpRelease && !smContext.LocalPurged {
-			smContext.SBIPFCPCommunicationChan <- smf_context.SessionReleaseSuccess
+			smContext.PostPFCPResponseStatus(smf_context.SessionReleaseSuccess)
+		}
+		smContext.SubPfcpLog.Infof("PFCP Session Deletion Failed[%d], cause[%d]", SEID, causeValue)
+	}
+}
+
+// parseUsageReports decodes the Usage Report IEs of a PFCP message, invalid reports are skipped
+func parseUsageReports(upfIP string, usageReportIEs []*ie.IE) []smf_context.UsageReport {
+	reports := make([]smf_context.UsageReport, 0, len(usageReportIEs))
+	for _, usageReportIE := range usageReportIEs {
+		usageReportIEs, err := usageReportIE.UsageReport()
+		if err != nil {
+			logger.PfcpLog.Warnf("failed to parse Usage Report IE: %+v", err)
+			continue
+		}
+		report := smf_context.UsageReport{UpfIP: upfIP}
+		for _, i := range usageReportIEs {
+			switch i.Type {
+			case ie.URRID:
+				if report.URRID, err = i.URRID(); err != nil {
+					logger.PfcpLog.Warnf("failed to parse URR ID IE: %+v", err)
+				}
+			case ie.VolumeMeasurement:
+				volume, err := i.VolumeMeasurement()
+				if err != nil {
+					logger.PfcpLog.Warnf("failed to parse Volume Measurement IE: %+v", err)
+					continue
+				}
+				report.TotalVolume = volume.TotalVolume
+				report.UplinkVolume = volume.UplinkVolume
+				report.DownlinkVolume = volume.DownlinkVolume
+				report.TotalPackets = volume.TotalNumberOfPackets
+				report.UplinkPackets = volume.UplinkNumberOfPackets
+				report.DownlinkPackets = volume.DownlinkNumberOfPackets
+			case ie.DurationMeasurement:
+				duration, err := i.DurationMeasurement()
+				if err != nil {
+					logger.PfcpLog.Warnf("failed to parse Duration Measurement IE: %+v", err)
+					continue
+				}
+				report.Duration = uint32(duration.Seconds())
+			}
 		}
-		smContext.SubPfcpLog.Infof("PFCP Session Deletion Failed[%d]", SEID)
+		reports = append(reports, report)
 	}
+	return reports
+}
+
+// observeSessionReport records the session report of the UPF in the congestion detector, the UPF
+// being named by its address when not configured
+func observeSessionReport(remoteAddr *net.UDPAddr) {
+	detector := smf_context.GetCongestionDetector()
+	if detector == nil || remoteAddr == nil {
+		return
+	}
+	upfName := remoteAddr.IP.String()
+	if upi := smf_context.GetUserPlaneInformation(); upi != nil {
+		if name := upi.GetUPFNameByIp(upfName); name != "" {
+			upfName = name
+		}
+	}
+	detector.Observe(upfName, time.Now())
 }
 
 func HandlePfcpSessionReportRequest(msg *udp.Message) {
@@ -652,6 +903,7 @@ func HandlePfcpSessionReportRequest(msg *udp.Message) {
 	}
 
 	logger.PfcpLog.Infoln("handle PFCP Session Report Request")
+	observeSessionReport(msg.RemoteAddr)
 
 	SEID := req.SEID()
 	smContext := smf_context.GetSMContextBySEID(SEID)
@@ -661,6 +913,9 @@ func HandlePfcpSessionReportRequest(msg *udp.Message) {
 	var pfcpSRflag smf_context.PFCPSRRspFlags
 
 	if smContext == nil {
+		if req.ReportType != nil && req.ReportType.HasERIR() {
+			logger.PfcpLog.Warnf("PFCP Session Report Request error indication for unknown SEID[%d] discarded", SEID)
+		}
 		logger.PfcpLog.Warnln("PFCP Session Report Request Found SM Context NULL, Request Rejected")
 		cause = ie.CauseRequestRejected
 
@@ -677,6 +932,71 @@ func HandlePfcpSessionReportRequest(msg *udp.Message) {
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
 
+	// N3 path of the session restored by the UPF, the downlink data it buffered is forwarded again
+	if len(pathRecoveryPeers(req)) > 0 {
+		report := &PFCPSessionReport{SMContext: smContext, UPFNodeID: smContext.GetNodeIDByLocalSEID(SEID), Request: req}
+		if err := HandlePathRestoredReport(report); err != nil {
+			smContext.SubPfcpLog.Warnf("PFCP Session Report path recovery discarded: %v", err)
+		}
+		if req.ReportType == nil || (!req.ReportType.HasDLDR() && !req.ReportType.HasUSAR() &&
+			!req.ReportType.HasERIR() && !hasSESR(req.ReportType)) {
+			err := pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, ie.CauseRequestAccepted, pfcpSRflag, seqFromUPF, SEID)
+			if err != nil {
+				logger.PfcpLog.Errorf("failed to send PFCP Session Report Response: %+v", err)
+			}
+			return
+		}
+	}
+
+	// port management of the NW-TT reported by the UPF
+	if req.PortManagementInformationForTSC != nil {
+		handleTSCManagementInformation(smContext, req.PortManagementInformationForTSC)
+		if req.ReportType == nil || (!req.ReportType.HasDLDR() && !req.ReportType.HasUSAR() &&
+			!req.ReportType.HasERIR() && !hasSESR(req.ReportType)) {
+			err := pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, ie.CauseRequestAccepted, pfcpSRflag, seqFromUPF, SEID)
+			if err != nil {
+				logger.PfcpLog.Errorf("failed to send PFCP Session Report Response: %+v", err)
+			}
+			return
+		}
+	}
+
+	// GTP-U Error Indication of the peer of the UPF, the tunnel of the session is lost
+	if req.ReportType != nil && req.ReportType.HasERIR() {
+		handleErrorIndicationReport(smContext, smContext.GetNodeIDByLocalSEID(SEID), req.ErrorIndicationReport)
+		if !req.ReportType.HasDLDR() && !req.ReportType.HasUSAR() && !hasSESR(req.ReportType) {
+			err := pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, ie.CauseRequestAccepted, pfcpSRflag, seqFromUPF, SEID)
+			if err != nil {
+				logger.PfcpLog.Errorf("failed to send PFCP Session Report Response: %+v", err)
+			}
+			return
+		}
+	}
+
+	// usage and packet delays of the session measured by the anchor UPF
+	if req.ReportType != nil && (req.ReportType.HasUSAR() || hasSESR(req.ReportType)) {
+		upfNodeID := smContext.GetNodeIDByLocalSEID(SEID)
+		upfIP := upfNodeID.ResolveNodeIdToIp().String()
+		if req.ReportType.HasUSAR() {
+			if usageReports := parseUsageReports(upfIP, req.UsageReport); len(usageReports) > 0 {
+				smf_context.GetPacketRateRecorder().Record(smContext.Ref, usageReports)
+				smContext.SubPfcpLog.Debugf("PFCP Session Report usage from UPF[%s]: %+v", upfIP, usageReports)
+			}
+		}
+		if hasSESR(req.ReportType) {
+			if reports := parseQoSMonitoringReports(upfIP, req.SessionReport); len(reports) > 0 {
+				producer.HandleQoSMonitoringReports(smContext, reports)
+			}
+		}
+		if !req.ReportType.HasDLDR() {
+			err := pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, ie.CauseRequestAccepted, pfcpSRflag, seqFromUPF, SEID)
+			if err != nil {
+				logger.PfcpLog.Errorf("failed to send PFCP Session Report Response: %+v", err)
+			}
+			return
+		}
+	}
+
 	if smContext.UpCnxState == models.UpCnxState_DEACTIVATED {
 		if req.ReportType.HasDLDR() {
 			downlinkServiceInfo, err := req.DownlinkDataReport.DownlinkDataServiceInformation()
@@ -684,8 +1004,23 @@ func HandlePfcpSessionReportRequest(msg *udp.Message) {
 				logger.PfcpLog.Warnln("DownlinkDataServiceInformation not found in DownlinkDataReport")
 			}
 
-			if downlinkServiceInfo != nil {
-				smContext.SubPfcpLog.Warnln("PFCP Session Report Request DownlinkDataServiceInformation handling is not implemented")
+			// paging policy differentiation by the DSCP of the buffered packet. TS 23.501 5.4.3.2
+			var ppi int32
+			if dscp, ok := downlinkDataDSCP(downlinkServiceInfo); ok {
+				if value, ok := smContext.PagingPolicyIndicator(dscp); ok {
+					smContext.SubPfcpLog.Infof("downlink data with DSCP %d, paging policy indicator %d", dscp, value)
+					ppi = int32(value)
+				}
+			}
+
+			// the UE is already being paged, the UPF keeps buffering
+			if !smContext.AllowDownlinkDataNotification(time.Now()) {
+				smContext.SubPfcpLog.Infoln("duplicate Downlink Data Notification within the throttling window, UE not paged again")
+				err = pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, ie.CauseRequestAccepted, pfcpSRflag, seqFromUPF, SEID)
+				if err != nil {
+					logger.PfcpLog.Errorf("failed to send PFCP Session Report Response: %+v", err)
+				}
+				return
 			}
 
 			n1n2Request := models.N1N2MessageTransferRequest{}
@@ -706,12 +1041,9 @@ func HandlePfcpSessionReportRequest(msg *udp.Message) {
 			n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
 				PduSessionId: smContext.PDUSessionID,
 				SkipInd:      false,
+				Ppi:          ppi,
 				// Temporarily assign SMF itself, TODO: TS 23.502 4.2.3.3 5. Namf_Communication_N1N2TransferFailureNotification
-				N1n2FailureTxfNotifURI: fmt.Sprintf("%s://%s:%d%s",
-					smf_context.SMF_Self().URIScheme,
-					smf_context.SMF_Self().RegisterIPv4,
-					smf_context.SMF_Self().SBIPort,
-					n1n2FailureTxfNotifURI),
+				N1n2FailureTxfNotifURI: smf_context.SMF_Self().SBIUri() + n1n2FailureTxfNotifURI,
 				N2InfoContainer: &models.N2InfoContainer{
 					N2InformationClass: models.N2InformationClass_SM,
 					SmInfo: &models.N2SmInformation{
@@ -727,11 +1059,11 @@ func HandlePfcpSessionReportRequest(msg *udp.Message) {
 				},
 			}
 
-			rspData, _, err := smContext.CommunicationClient.
-				N1N2MessageCollectionDocumentApi.
-				N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+			rspData, err := smContext.N1N2MessageTransfer(context.Background(), n1n2Request)
 			if err != nil {
 				smContext.SubPfcpLog.Warnf("Send N1N2Transfer failed")
+				// the UE was not paged, the next DDN retries
+				smContext.ResetDDNThrottling()
 			}
 			if rspData.Cause == models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE {
 				smContext.SubPfcpLog.Infof("Receive %v, AMF is able to page the UE", rspData.Cause)
@@ -765,6 +1097,255 @@ func HandlePfcpSessionReportRequest(msg *udp.Message) {
 	// pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, cause, seqFromUPF, SEID)
 }
 
+// handleErrorIndicationReport handles the GTP-U Error Indication received by the UPF for a tunnel
+// of the session, the peer having lost the tunnel. For the AN tunnel the user plane connection of
+// the session is deactivated: the UPF buffers the downlink data and notifies its arrival to page
+// the UE, the AN setting up a new tunnel. For the N9 tunnel of another UPF the session is
+// released. Reports of other tunnels are discarded. TS 23.527, TS 29.244 5.2.2.3.6
+func handleErrorIndicationReport(smContext *smf_context.SMContext, upfNodeID smf_context.NodeID, errorIndicationReport *ie.IE) {
+	upfIP := upfNodeID.ResolveNodeIdToIp().String()
+	if errorIndicationReport == nil {
+		smContext.SubPfcpLog.Warnf("PFCP Session Report error indication from UPF[%s] without Error Indication Report discarded", upfIP)
+		return
+	}
+	fteidIEs, err := errorIndicationReport.ErrorIndicationReport()
+	if err != nil {
+		smContext.SubPfcpLog.Warnf("failed to parse Error Indication Report IE: %+v", err)
+		return
+	}
+	anTunnelLost, peerTunnelLost := false, false
+	for _, fteidIE := range fteidIEs {
+		if fteidIE.Type != ie.FTEID {
+			continue
+		}
+		fteid, err := fteidIE.FTEID()
+		if err != nil {
+			smContext.SubPfcpLog.Warnf("failed to parse Remote F-TEID IE: %+v", err)
+			continue
+		}
+		ip := fteid.IPv4Address
+		if ip == nil {
+			ip = fteid.IPv6Address
+		}
+		switch {
+		case smContext.IsANTunnel(fteid.TEID, ip):
+			smContext.SubPfcpLog.Warnf("error indication from UPF[%s] for AN tunnel TEID[%d] IP[%s]", upfIP, fteid.TEID, ip)
+			anTunnelLost = true
+		case smContext.HasRemoteFTEID(upfNodeID, fteid.TEID, ip):
+			smContext.SubPfcpLog.Warnf("error indication from UPF[%s] for N9 tunnel TEID[%d] IP[%s]", upfIP, fteid.TEID, ip)
+			peerTunnelLost = true
+		default:
+			smContext.SubPfcpLog.Warnf("error indication from UPF[%s] for unknown tunnel TEID[%d] IP[%s] discarded", upfIP, fteid.TEID, ip)
+		}
+	}
+
+	switch {
+	case peerTunnelLost:
+		// the session lock is held until the report is answered
+		go producer.ReleaseSessionOnErrorIndication(smContext)
+	case anTunnelLost:
+		upf := smf_context.RetrieveUPFNodeByNodeID(upfNodeID)
+		if upf == nil {
+			smContext.SubPfcpLog.Errorf("can't find UPF[%s], user plane connection not deactivated", upfIP)
+			return
+		}
+		farList := smContext.DeactivateUPConnection(upfNodeID)
+		smContext.SubPfcpLog.Infof("AN tunnel lost, user plane connection deactivated, downlink data buffered by UPF[%s]", upfIP)
+		if len(farList) == 0 {
+			return
+		}
+		if err := pfcp_message.SendPfcpSessionModificationRequest(upfNodeID, smContext, nil, farList, nil, nil, upf.Port); err != nil {
+			smContext.SubPfcpLog.Errorf("send PFCP Session Modification to buffer the downlink data failed: %v", err)
+		}
+	}
+}
+
+// PFCPSessionReport is a PFCP Session Report Request of a UPF for a session
+type PFCPSessionReport struct {
+	SMContext *smf_context.SMContext
+	// node ID of the UPF reporting
+	UPFNodeID smf_context.NodeID
+	Request   *message.SessionReportRequest
+}
+
+// HandlePathRestoredReport handles the restoration by the UPF of the N3 path of the session,
+// reported with a User Plane Path Recovery Report of the gNB of the session while the UPF buffers
+// its downlink data. The paging of the UE is cancelled, and a PFCP Session Modification removes
+// the BAR and forwards the downlink data to the gNB again. Fails if the report is not a path
+// restoration of the session. To be called with SMLock held.
+func HandlePathRestoredReport(report *PFCPSessionReport) error {
+	smContext := report.SMContext
+	upfIP := report.UPFNodeID.ResolveNodeIdToIp().String()
+	// the downlink data of a UE in idle stays buffered until the UE is paged
+	if smContext.Tunnel == nil || smContext.UpCnxState == models.UpCnxState_DEACTIVATED {
+		return fmt.Errorf("user plane of the session deactivated, path recovery of UPF[%s] ignored", upfIP)
+	}
+	anIP := smContext.Tunnel.ANInformation.IPAddress
+	if anIP == nil || !slices.ContainsFunc(pathRecoveryPeers(report.Request), anIP.Equal) {
+		return fmt.Errorf("no N3 path recovery of the gNB of the session reported by UPF[%s]", upfIP)
+	}
+	upf := smf_context.RetrieveUPFNodeByNodeID(report.UPFNodeID)
+	if upf == nil {
+		return fmt.Errorf("can't find UPF[%s]", upfIP)
+	}
+
+	pdrList, farList := smContext.ResumeDownlinkForwarding(report.UPFNodeID)
+	if len(farList) == 0 {
+		return fmt.Errorf("no downlink data of the session buffered by UPF[%s]", upfIP)
+	}
+	smContext.ResetDDNThrottling()
+	smContext.SubPfcpLog.Infof("N3 path to gNB[%s] restored by UPF[%s], downlink data forwarded again", anIP, upfIP)
+	return pfcp_message.SendPfcpSessionModificationRequest(report.UPFNodeID, smContext, pdrList, farList, nil, nil, upf.Port)
+}
+
+// pathRecoveryPeers returns the remote GTP-U peers of the User Plane Path Recovery Reports of the
+// Session Report Request, invalid peers are skipped. TS 29.244 7.4.5.1.3
+func pathRecoveryPeers(req *message.SessionReportRequest) []net.IP {
+	peers := []net.IP{}
+	for _, reportIE := range req.IEs {
+		if reportIE.Type != ie.UserPlanePathRecoveryReport {
+			continue
+		}
+		peerIEs, err := reportIE.UserPlanePathRecoveryReport()
+		if err != nil {
+			logger.PfcpLog.Warnf("failed to parse User Plane Path Recovery Report IE: %+v", err)
+			continue
+		}
+		for _, peerIE := range peerIEs {
+			if peerIE.Type != ie.RemoteGTPUPeer {
+				continue
+			}
+			peer, err := peerIE.RemoteGTPUPeer()
+			if err != nil {
+				logger.PfcpLog.Warnf("failed to parse Remote GTP-U Peer IE: %+v", err)
+				continue
+			}
+			if peer.IPv4Address != nil {
+				peers = append(peers, peer.IPv4Address)
+			}
+			if peer.IPv6Address != nil {
+				peers = append(peers, peer.IPv6Address)
+			}
+		}
+	}
+	return peers
+}
+
+// downlinkDataDSCP returns the DSCP of the buffered packet, the PPI value of the Downlink Data
+// Service Information, false if not reported. TS 29.244 8.2.27
+func downlinkDataDSCP(downlinkServiceInfo []byte) (uint8, bool) {
+	if len(downlinkServiceInfo) == 0 {
+		return 0, false
+	}
+	serviceInfoIE := ie.New(ie.DownlinkDataServiceInformation, downlinkServiceInfo)
+	if !serviceInfoIE.HasPPI() {
+		return 0, false
+	}
+	ppiValue, err := serviceInfoIE.PPI()
+	if err != nil {
+		logger.PfcpLog.Warnf("failed to parse PPI value: %+v", err)
+		return 0, false
+	}
+	return ppiValue & 0x3f, true
+}
+
+// hasSESR reports whether the Report Type IE has the SESR bit, the session report. TS 29.244 8.2.21
+func hasSESR(reportType *ie.IE) bool {
+	if reportType == nil {
+		return false
+	}
+	v, err := reportType.ReportType()
+	return err == nil && v&(1<<5) != 0
+}
+
+// parseQoSMonitoringReports decodes the QoS Monitoring Reports of the Session Report IEs of a
+// PFCP Session Report Request, invalid reports are skipped. TS 29.244 7.5.8.6
+func parseQoSMonitoringReports(upfIP string, sessionReportIEs []*ie.IE) []smf_context.QoSMonitoringReport {
+	reports := make([]smf_context.QoSMonitoringReport, 0, len(sessionReportIEs))
+	for _, sessionReportIE := range sessionReportIEs {
+		sessionReport, err := sessionReportIE.SessionReport()
+		if err != nil {
+			logger.PfcpLog.Warnf("failed to parse Session Report IE: %+v", err)
+			continue
+		}
+		for _, qosMonitoringReportIE := range sessionReport {
+			if qosMonitoringReportIE.Type != ie.QoSMonitoringReport {
+				continue
+			}
+			ies, err := qosMonitoringReportIE.QoSMonitoringReport()
+			if err != nil {
+				logger.PfcpLog.Warnf("failed to parse QoS Monitoring Report IE: %+v", err)
+				continue
+			}
+			report := smf_context.QoSMonitoringReport{UpfIP: upfIP}
+			var hasQFI, hasMeasurement bool
+			for _, i := range ies {
+				switch i.Type {
+				case ie.QFI:
+					if report.QFI, err = i.QFI(); err != nil {
+						logger.PfcpLog.Warnf("failed to parse QFI IE: %+v", err)
+						continue
+					}
+					hasQFI = true
+				case ie.QoSMonitoringMeasurement:
+					measurement, err := i.QoSMonitoringMeasurement()
+					if err != nil {
+						logger.PfcpLog.Warnf("failed to parse QoS Monitoring Measurement IE: %+v", err)
+						continue
+					}
+					hasMeasurement = true
+					report.MeasurementFailure = measurement.HasPLMF()
+					if measurement.HasDL() {
+						report.DownlinkDelay = &measurement.DownlinkPacketDelay
+					}
+					if measurement.HasUL() {
+						report.UplinkDelay = &measurement.UplinkPacketDelay
+					}
+					if measurement.HasRP() {
+						report.RoundTripDelay = &measurement.RoundTripPacketDelay
+					}
+				case ie.EventTimeStamp:
+					if report.EventTime, err = i.EventTimeStamp(); err != nil {
+						logger.PfcpLog.Warnf("failed to parse Event Time Stamp IE: %+v", err)
+					}
+				}
+			}
+			if !hasQFI || !hasMeasurement {
+				logger.PfcpLog.Warnln("QoS Monitoring Report without QFI or measurement skipped")
+				continue
+			}
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
 func HandlePfcpSessionReportResponse(msg *udp.Message) {
 	logger.PfcpLog.Warnln("PFCP Session Report Response handling is not implemented")
 }
+
+// handleTSCManagementInformation records the port management information container of the
+// NW-TT reported by the UPF in a Session Modification Response or Session Report Request
+func handleTSCManagementInformation(smContext *smf_context.SMContext, tscManagementInfo *ie.IE) {
+	ies, err := tscManagementInfo.TSCManagementInformation()
+	if err != nil {
+		smContext.SubPfcpLog.Warnf("invalid TSC Management Information: %v", err)
+		return
+	}
+	for _, i := range ies {
+		if i.Type != ie.PortManagementInformationContainer {
+			continue
+		}
+		container, err := i.PortManagementInformationContainer()
+		if err != nil {
+			smContext.SubPfcpLog.Warnf("invalid Port Management Information Container: %v", err)
+			return
+		}
+		if smContext.TSNBridge == nil {
+			smContext.SubPfcpLog.Warnln("Port Management Information Container of a session without TSN bridge discarded")
+			return
+		}
+		smContext.TSNBridge.NWTTPortManagement = []byte(container)
+		smContext.SubPfcpLog.Infof("NW-TT port %d management information reported by UPF", smContext.TSNBridge.NWTTPortNumber)
+	}
+}
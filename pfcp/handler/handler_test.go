@@ -5,17 +5,29 @@
 package handler_test
 
 import (
+	"encoding/json"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/omec-project/openapi/Namf_Communication"
+	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/context"
 	"github.com/omec-project/smf/factory"
 	"github.com/omec-project/smf/pfcp/handler"
+	"github.com/omec-project/smf/pfcp/ies"
 	pfcp_message "github.com/omec-project/smf/pfcp/message"
 	"github.com/omec-project/smf/pfcp/udp"
+	"github.com/omec-project/smf/producer"
 	"github.com/wmnsk/go-pfcp/ie"
 	"github.com/wmnsk/go-pfcp/message"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type Flag uint8
@@ -129,6 +141,84 @@ func TestHandlePfcpAssociationSetupResponse(t *testing.T) {
 	}
 }
 
+func TestHandlePfcpAssociationSetupResponseLastError(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{
+			KafkaInfo: factory.KafkaInfo{EnableKafka: boolPointer(false)},
+		},
+	}
+	upNodeID := context.NewNodeID("1.1.1.2")
+	upf := context.NewUPF(upNodeID, nil)
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+	udpMessage := func(seq uint32, cause uint8) *udp.Message {
+		pfcp_message.InsertPfcpTxn(seq, upNodeID)
+		return &udp.Message{
+			RemoteAddr: &net.UDPAddr{IP: net.ParseIP("1.1.1.2"), Port: 8805},
+			PfcpMessage: message.NewAssociationSetupResponse(
+				seq,
+				ie.NewCause(cause),
+				ie.NewNodeID("1.1.1.2", "", ""),
+				ie.NewRecoveryTimeStamp(time.Now()),
+			),
+		}
+	}
+
+	// the rejection of the association is recorded
+	handler.HandlePfcpAssociationSetupResponse(udpMessage(2, ie.CauseRequestRejected))
+	if upf.UPFStatus != context.NotAssociated {
+		t.Errorf("Expected UPFStatus %v, got %v", context.NotAssociated, upf.UPFStatus)
+	}
+	lastError := upf.LastError()
+	if lastError == nil {
+		t.Fatalf("Expected the rejected association to be recorded")
+	}
+	if !strings.Contains(lastError.Message, "rejected") || lastError.Time.IsZero() {
+		t.Errorf("Unexpected last error %+v", lastError)
+	}
+
+	// and cleared by the successful association
+	handler.HandlePfcpAssociationSetupResponse(udpMessage(3, ie.CauseRequestAccepted))
+	if upf.UPFStatus != context.AssociatedSetUpSuccess {
+		t.Errorf("Expected UPFStatus %v, got %v", context.AssociatedSetUpSuccess, upf.UPFStatus)
+	}
+	if lastError := upf.LastError(); lastError != nil {
+		t.Errorf("Expected no last error, got %+v", lastError)
+	}
+}
+
+func TestHandlePfcpAssociationSetupResponseSessionsLost(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{
+			KafkaInfo: factory.KafkaInfo{EnableKafka: boolPointer(false)},
+		},
+	}
+	upNodeID := context.NewNodeID("1.1.1.3")
+	upf := context.NewUPF(upNodeID, nil)
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+	recoveryTimestamp := time.Now()
+	upf.RecoveryTimeStamp = context.RecoveryTimeStamp{RecoveryTimeStamp: recoveryTimestamp}
+	// re-associated by the SMF, the UPF did not restart
+	upf.UPFStatus = context.AssociatedSettingUp
+	upf.SessionsLost = true
+
+	pfcp_message.InsertPfcpTxn(4, upNodeID)
+	handler.HandlePfcpAssociationSetupResponse(&udp.Message{
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("1.1.1.3"), Port: 8805},
+		PfcpMessage: message.NewAssociationSetupResponse(
+			4,
+			ie.NewCause(ie.CauseRequestAccepted),
+			ie.NewNodeID("1.1.1.3", "", ""),
+			ie.NewRecoveryTimeStamp(recoveryTimestamp),
+		),
+	})
+	if upf.UPFStatus != context.AssociatedSetUpSuccess {
+		t.Errorf("Expected UPFStatus %v, got %v", context.AssociatedSetUpSuccess, upf.UPFStatus)
+	}
+	if upf.SessionsLost {
+		t.Errorf("Expected the lost sessions to be recovered")
+	}
+}
+
 func TestHandlePfcpSessionEstablishmentResponse(t *testing.T) {
 	recoveryTimestamp := time.Now()
 	nodeID := context.NewNodeID("1.1.1.1")
@@ -202,3 +292,811 @@ func TestHandlePfcpSessionEstablishmentResponse(t *testing.T) {
 		t.Errorf("Expected ANInformation IP %v, got %v", expectedIP, smContext.Tunnel.ANInformation.IPAddress)
 	}
 }
+
+func TestHandlePfcpSessionEstablishmentResponseRejected(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	nodeID := context.NewNodeID("1.1.2.1")
+	upf := context.NewUPF(nodeID, nil)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	defer context.RemoveUPFNodeByNodeID(*nodeID)
+
+	smContext := context.NewSMContext("imsi-123456789012346", 10)
+	datapath := &context.DataPath{
+		IsDefaultPath: true,
+		FirstDPNode: &context.DataPathNode{
+			UPF: upf,
+		},
+	}
+	smContext.Tunnel = &context.UPTunnel{
+		DataPathPool: context.DataPathPool{10: datapath},
+	}
+	smContext.AllocateLocalSEIDForDataPath(datapath)
+	seid := smContext.PFCPContext[nodeID.ResolveNodeIdToIp().String()].LocalSEID
+	pfcp_message.InsertPfcpTxn(2, nodeID)
+
+	rsp := message.NewSessionEstablishmentResponse(
+		0,
+		0,
+		seid,
+		2,
+		0,
+		ie.NewCause(ie.CauseNoEstablishedPFCPAssociation),
+		ie.NewNodeID("1.1.2.1", "", ""),
+	)
+
+	udpMessage := udp.Message{
+		RemoteAddr: &net.UDPAddr{
+			IP:   net.ParseIP("1.1.2.1"),
+			Port: 8805,
+		},
+		PfcpMessage: rsp,
+	}
+
+	handler.HandlePfcpSessionEstablishmentResponse(&udpMessage)
+
+	if status := <-smContext.SBIPFCPCommunicationChan; status != context.SessionEstablishFailed {
+		t.Errorf("Expected SessionEstablishFailed, got %v", status)
+	}
+	if smContext.PFCPError == nil {
+		t.Fatalf("Expected PFCPError, got nil")
+	}
+	if smContext.PFCPError.Cause != ie.CauseNoEstablishedPFCPAssociation {
+		t.Errorf("Expected cause %d, got %d", ie.CauseNoEstablishedPFCPAssociation, smContext.PFCPError.Cause)
+	}
+	if smContext.PFCPError.MessageType != rsp.MessageTypeName() {
+		t.Errorf("Expected message type %s, got %s", rsp.MessageTypeName(), smContext.PFCPError.MessageType)
+	}
+	if upf.UPFStatus != context.NotAssociated {
+		t.Errorf("Expected UPF NotAssociated, got %v", upf.UPFStatus)
+	}
+}
+
+func TestHandlePfcpSessionDeletionResponseFinalUsage(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	smContext := context.NewSMContext("imsi-123456789012346", 11)
+	upf := &context.UPF{NodeID: *context.NewNodeID("2.2.2.2")}
+	smContext.AllocateLocalSEIDForDataPath(&context.DataPath{
+		FirstDPNode: &context.DataPathNode{UPF: upf},
+	})
+	seid := smContext.PFCPContext["2.2.2.2"].LocalSEID
+	smContext.SMContextState = context.SmStatePfcpRelease
+	smContext.PendingUPF = context.PendingUPF{"2.2.2.2": true}
+
+	rsp := message.NewSessionDeletionResponse(
+		0,
+		0,
+		seid,
+		1,
+		0,
+		ie.NewCause(ie.CauseRequestAccepted),
+		ie.NewUsageReportWithinSessionDeletionResponse(
+			ie.NewURRID(5),
+			ie.NewURSEQN(1),
+			ie.NewUsageReportTrigger(0, 0, 0),
+			ie.NewVolumeMeasurement(0x07, 3000, 1000, 2000, 0, 0, 0),
+			ie.NewDurationMeasurement(90*time.Second),
+		),
+	)
+
+	handler.HandlePfcpSessionDeletionResponse(&udp.Message{
+		RemoteAddr: &net.UDPAddr{
+			IP:   net.ParseIP("2.2.2.2"),
+			Port: 8805,
+		},
+		PfcpMessage: rsp,
+	})
+
+	if status := <-smContext.SBIPFCPCommunicationChan; status != context.SessionReleaseSuccess {
+		t.Errorf("Expected %v, got %v", context.SessionReleaseSuccess, status)
+	}
+
+	finalUsage := smContext.FinalUsage()
+	if len(finalUsage) != 1 {
+		t.Fatalf("Expected 1 usage report, got %d", len(finalUsage))
+	}
+	expected := context.UsageReport{
+		UpfIP:          "2.2.2.2",
+		URRID:          5,
+		TotalVolume:    3000,
+		UplinkVolume:   1000,
+		DownlinkVolume: 2000,
+		Duration:       90,
+	}
+	if finalUsage[0] != expected {
+		t.Errorf("Expected usage report %+v, got %+v", expected, finalUsage[0])
+	}
+}
+
+// The response to a message of the UE reachability probe is delivered to the probe only
+func TestHandlePfcpSessionModificationResponseReachabilityProbe(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	smContext := context.NewSMContext("imsi-123456789012348", 13)
+	upf := &context.UPF{NodeID: *context.NewNodeID("2.2.2.4")}
+	smContext.AllocateLocalSEIDForDataPath(&context.DataPath{
+		FirstDPNode: &context.DataPathNode{UPF: upf},
+	})
+	seid := smContext.PFCPContext["2.2.2.4"].LocalSEID
+	smContext.SMContextState = context.SmStatePfcpModify
+	smContext.PendingUPF = context.PendingUPF{"2.2.2.4": true}
+	probe := smContext.StartReachabilityProbe(upf.NodeID, 7)
+
+	rsp := message.NewSessionModificationResponse(0, 0, seid, 7, 0, ie.NewCause(ie.CauseRequestAccepted))
+	handler.HandlePfcpSessionModificationResponse(&udp.Message{
+		RemoteAddr:  &net.UDPAddr{IP: net.ParseIP("2.2.2.4"), Port: 8805},
+		PfcpMessage: rsp,
+	})
+
+	select {
+	case got := <-probe:
+		if got != rsp {
+			t.Errorf("Expected the response delivered to the probe, got %v", got)
+		}
+	default:
+		t.Fatalf("Expected the response delivered to the probe")
+	}
+	// the procedure of the session waiting for its own modification is left pending
+	if !smContext.PendingUPF["2.2.2.4"] {
+		t.Errorf("Expected the pending modification of the session left untouched")
+	}
+}
+
+func TestHandlePfcpSessionReportRequestDDNThrottling(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	origWindow := smfSelf.DDNThrottlingWindow
+	smfSelf.DDNThrottlingWindow = time.Minute
+	t.Cleanup(func() { smfSelf.DDNThrottlingWindow = origWindow })
+
+	var pagings atomic.Int32
+	amf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pagings.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(models.N1N2MessageTransferRspData{
+			Cause: models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE,
+		})
+	}), &http2.Server{}))
+	t.Cleanup(amf.Close)
+
+	smContext := context.NewSMContext("imsi-123456789012347", 12)
+	smContext.Supi = "imsi-123456789012347"
+	communicationConf := Namf_Communication.NewConfiguration()
+	communicationConf.SetBasePath(amf.URL)
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	upf := &context.UPF{
+		NodeID: *context.NewNodeID("3.3.3.3"),
+		N3Interfaces: []context.UPFInterfaceInfo{
+			{IPv4EndPointAddresses: []net.IP{net.ParseIP("10.0.0.3")}},
+		},
+	}
+	dataPath := &context.DataPath{
+		IsDefaultPath: true,
+		FirstDPNode: &context.DataPathNode{
+			UPF:          upf,
+			UpLinkTunnel: &context.GTPTunnel{TEID: 1},
+		},
+	}
+	smContext.Tunnel = &context.UPTunnel{DataPathPool: context.DataPathPool{1: dataPath}}
+	smContext.SmPolicyData.SmCtxtSessionRules.ActiveRule = &models.SessionRule{
+		AuthSessAmbr: &models.Ambr{Uplink: "1 Gbps", Downlink: "1 Gbps"},
+	}
+	smContext.AllocateLocalSEIDForDataPath(dataPath)
+	seid := smContext.PFCPContext["3.3.3.3"].LocalSEID
+
+	reportDownlinkData := func(sequence uint32) {
+		handler.HandlePfcpSessionReportRequest(&udp.Message{
+			RemoteAddr: &net.UDPAddr{IP: net.ParseIP("3.3.3.3"), Port: 8805},
+			PfcpMessage: message.NewSessionReportRequest(0, 0, seid, sequence, 0,
+				ie.NewReportType(0, 0, 0, 1),
+				ie.NewDownlinkDataReport(ie.NewPDRID(2)),
+			),
+		})
+	}
+
+	// the UPF notifies each buffered packet, the UE is paged once
+	for sequence := range uint32(3) {
+		reportDownlinkData(sequence + 1)
+	}
+	if got := pagings.Load(); got != 1 {
+		t.Errorf("Expected 1 paging within the throttling window, got %d", got)
+	}
+
+	// once the UE answered, a new DDN pages it again
+	smContext.SMLock.Lock()
+	smContext.ResetDDNThrottling()
+	smContext.SMLock.Unlock()
+	reportDownlinkData(4)
+	if got := pagings.Load(); got != 2 {
+		t.Errorf("Expected 2 pagings after the UP activation, got %d", got)
+	}
+}
+
+func TestHandlePfcpSessionReportRequestQoSMonitoring(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	smContext := context.NewSMContext("imsi-123456789012348", 13)
+	smContext.Supi = "imsi-123456789012348"
+	upf := &context.UPF{NodeID: *context.NewNodeID("4.4.4.4")}
+	dataPath := &context.DataPath{FirstDPNode: &context.DataPathNode{UPF: upf}}
+	smContext.AllocateLocalSEIDForDataPath(dataPath)
+	seid := smContext.PFCPContext["4.4.4.4"].LocalSEID
+	eventTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// session report, SESR
+	handler.HandlePfcpSessionReportRequest(&udp.Message{
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("4.4.4.4"), Port: 8805},
+		PfcpMessage: message.NewSessionReportRequest(0, 0, seid, 1, 0,
+			ie.New(ie.ReportType, []byte{0x20}),
+			ie.NewSessionReport(
+				ie.NewSRRID(context.QoSMonitoringSRRID),
+				ie.NewQoSMonitoringReport(
+					ie.NewQFI(5),
+					ie.NewQoSMonitoringMeasurement(0x03, 12, 8, 0),
+					ie.NewEventTimeStamp(eventTime),
+				),
+				// no measurement, skipped
+				ie.NewQoSMonitoringReport(ie.NewQFI(6)),
+			),
+		),
+	})
+
+	reports := smContext.QoSMonitoringReports()
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 QoS monitoring report, got %d", len(reports))
+	}
+	report := reports[0]
+	if report.UpfIP != "4.4.4.4" || report.QFI != 5 || report.MeasurementFailure || !report.EventTime.Equal(eventTime) {
+		t.Errorf("Unexpected QoS monitoring report %+v", report)
+	}
+	if report.DownlinkDelay == nil || *report.DownlinkDelay != 12 || report.UplinkDelay == nil || *report.UplinkDelay != 8 ||
+		report.RoundTripDelay != nil {
+		t.Errorf("Expected downlink 12ms and uplink 8ms delays, got %v %v %v", report.DownlinkDelay, report.UplinkDelay,
+			report.RoundTripDelay)
+	}
+}
+
+func TestHandlePfcpSessionReportRequestTSCManagement(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	smContext := context.NewSMContext("imsi-123456789012352", 13)
+	smContext.Supi = "imsi-123456789012352"
+	upf := &context.UPF{NodeID: *context.NewNodeID("4.4.4.8")}
+	dataPath := &context.DataPath{FirstDPNode: &context.DataPathNode{UPF: upf}}
+	smContext.AllocateLocalSEIDForDataPath(dataPath)
+	seid := smContext.PFCPContext["4.4.4.8"].LocalSEID
+	smContext.TSNBridge = &context.TSNBridge{UPFNodeID: upf.NodeID, DSTTPortNumber: 2, NWTTPortNumber: 7}
+	portManagement := "\x02\x00\x00"
+
+	// port management information of the NW-TT reported by the UPF
+	handler.HandlePfcpSessionReportRequest(&udp.Message{
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("4.4.4.8"), Port: 8805},
+		PfcpMessage: message.NewSessionReportRequest(0, 0, seid, 1, 0,
+			ie.NewTSCManagementInformationWithinSessionReportRequest(
+				ie.NewPortManagementInformationContainer(portManagement),
+				ie.NewNWTTPortNumber(7),
+			),
+		),
+	})
+
+	if string(smContext.TSNBridge.NWTTPortManagement) != portManagement {
+		t.Errorf("Expected NW-TT port management %x, got %x", portManagement, smContext.TSNBridge.NWTTPortManagement)
+	}
+}
+
+func TestHandlePfcpSessionReportRequestPeriodicUsage(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	smContext := context.NewSMContext("imsi-123456789012349", 14)
+	upf := &context.UPF{NodeID: *context.NewNodeID("5.5.5.5")}
+	dataPath := &context.DataPath{FirstDPNode: &context.DataPathNode{UPF: upf}}
+	smContext.AllocateLocalSEIDForDataPath(dataPath)
+	seid := smContext.PFCPContext["5.5.5.5"].LocalSEID
+	t.Cleanup(func() { context.GetPacketRateRecorder().Remove(smContext.Ref) })
+
+	// usage of each period, USAR
+	for sequence, usage := range []struct{ uplink, downlink uint64 }{{1000, 4000}, {500, 2500}, {0, 100}} {
+		handler.HandlePfcpSessionReportRequest(&udp.Message{
+			RemoteAddr: &net.UDPAddr{IP: net.ParseIP("5.5.5.5"), Port: 8805},
+			PfcpMessage: message.NewSessionReportRequest(0, 0, seid, uint32(sequence+1), 0,
+				ie.NewReportType(0, 0, 1, 0),
+				ie.NewUsageReportWithinSessionReportRequest(
+					ie.NewURRID(3),
+					ie.NewURSEQN(uint32(sequence)),
+					ie.NewUsageReportTrigger(0x01, 0, 0), // PERIO
+					ie.NewVolumeMeasurement(0x3f, usage.uplink+usage.downlink, usage.uplink, usage.downlink,
+						usage.uplink/100+usage.downlink/100, usage.uplink/100, usage.downlink/100),
+					ie.NewDurationMeasurement(60*time.Second),
+				),
+			),
+		})
+	}
+
+	stats, err := context.GetPacketRateRecorder().GetSessionStats(smContext.Ref)
+	if err != nil {
+		t.Fatalf("Expected stats of the session, got %v", err)
+	}
+	if stats.UplinkVolume != 1500 || stats.DownlinkVolume != 6600 || stats.TotalVolume != 8100 {
+		t.Errorf("Expected uplink 1500, downlink 6600 and total 8100 bytes, got %+v", stats)
+	}
+	if stats.UplinkPackets != 15 || stats.DownlinkPackets != 66 || stats.TotalPackets != 81 {
+		t.Errorf("Expected uplink 15, downlink 66 and total 81 packets, got %+v", stats)
+	}
+	if stats.Duration != 180 || stats.Reports != 3 || stats.LastReport.IsZero() {
+		t.Errorf("Expected 3 reports over 180s, got %+v", stats)
+	}
+}
+
+func TestHandlePfcpSessionReportRequestErrorIndication(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	upfConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.6")})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	t.Cleanup(func() { _ = upfConn.Close() })
+	smfConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	origServer := udp.Server
+	udp.Server = &udp.PfcpServer{Conn: smfConn}
+	t.Cleanup(func() {
+		udp.Server = origServer
+		_ = smfConn.Close()
+	})
+	upfAddr := upfConn.LocalAddr().(*net.UDPAddr)
+
+	released := make(chan *context.SMContext, 1)
+	origRelease := producer.ReleaseSessionOnErrorIndication
+	producer.ReleaseSessionOnErrorIndication = func(smContext *context.SMContext) { released <- smContext }
+	t.Cleanup(func() { producer.ReleaseSessionOnErrorIndication = origRelease })
+
+	nodeID := context.NewNodeID("127.0.0.6")
+	upf := context.NewUPF(nodeID, nil)
+	upf.Port = uint16(upfAddr.Port)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*nodeID) })
+
+	// the downlink data of the session is forwarded to the gNB, the uplink data to another UPF
+	smContext := context.NewSMContext("imsi-123456789012350", 15)
+	smContext.UpCnxState = models.UpCnxState_ACTIVATED
+	newFAR := func(farID uint32, ip string, teid uint32) *context.FAR {
+		return &context.FAR{
+			FARID: farID,
+			State: context.RULE_CREATE,
+			ForwardingParameters: &context.ForwardingParameters{
+				OuterHeaderCreation: &context.OuterHeaderCreation{
+					OuterHeaderCreationDescription: context.OuterHeaderCreationGtpUUdpIpv4,
+					Ipv4Address:                    net.ParseIP(ip).To4(),
+					Teid:                           teid,
+				},
+			},
+		}
+	}
+	dlPDR := &context.PDR{PDRID: 1, State: context.RULE_CREATE, FAR: newFAR(1, "10.1.1.1", 0x100)}
+	ulPDR := &context.PDR{PDRID: 2, State: context.RULE_CREATE, FAR: newFAR(2, "10.2.2.2", 0x300)}
+	dataPath := &context.DataPath{
+		Activated: true,
+		FirstDPNode: &context.DataPathNode{
+			UPF:            upf,
+			DownLinkTunnel: &context.GTPTunnel{PDR: map[string]*context.PDR{"default": dlPDR}},
+		},
+	}
+	smContext.Tunnel = &context.UPTunnel{DataPathPool: context.DataPathPool{1: dataPath}}
+	smContext.Tunnel.ANInformation.IPAddress = net.ParseIP("10.1.1.1")
+	smContext.Tunnel.ANInformation.TEID = 0x100
+	smContext.AllocateLocalSEIDForDataPath(dataPath)
+	pfcpContext := smContext.PFCPContext["127.0.0.6"]
+	pfcpContext.RemoteSEID = 100
+	pfcpContext.PDRs[1] = dlPDR
+	pfcpContext.PDRs[2] = ulPDR
+	seid := pfcpContext.LocalSEID
+	smContext.AllowDownlinkDataNotification(time.Now())
+
+	reportErrorIndication := func(sequence uint32, ip string, teid uint32) {
+		handler.HandlePfcpSessionReportRequest(&udp.Message{
+			RemoteAddr: upfAddr,
+			PfcpMessage: message.NewSessionReportRequest(0, 0, seid, sequence, 0,
+				ie.New(ie.ReportType, []byte{0x04}), // ERIR
+				ie.NewErrorIndicationReport(ie.NewFTEID(0x01, teid, net.ParseIP(ip), nil, 0)),
+			),
+		})
+	}
+	// waitRequest returns the next PFCP session request sent to the UPF, if any
+	waitRequest := func() message.Message {
+		buf := make([]byte, 2048)
+		for {
+			if err := upfConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond)); err != nil {
+				t.Fatalf("error setting the read deadline: %v", err)
+			}
+			n, err := upfConn.Read(buf)
+			if err != nil {
+				return nil
+			}
+			msg, err := message.Parse(buf[:n])
+			if err != nil {
+				t.Fatalf("error parsing PFCP message: %v", err)
+			}
+			if msg.MessageType() != message.MsgTypeSessionReportResponse {
+				return msg
+			}
+		}
+	}
+
+	// error indication of a tunnel not of the session, discarded
+	reportErrorIndication(1, "10.1.1.1", 0x200)
+	if req := waitRequest(); req != nil {
+		t.Fatalf("Expected no session request for an unknown tunnel, got %s", req.MessageTypeName())
+	}
+	select {
+	case <-released:
+		t.Fatalf("Expected the session not released for an unknown tunnel")
+	default:
+	}
+
+	// error indication of the N9 tunnel of the session, released
+	reportErrorIndication(2, "10.2.2.2", 0x300)
+	select {
+	case releasedContext := <-released:
+		if releasedContext != smContext {
+			t.Errorf("Expected the session released after the N9 error indication")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the session released after the N9 error indication")
+	}
+
+	// error indication of the gNB tunnel, the user plane connection is deactivated and the
+	// downlink data buffered, the tunnel not being set up again
+	reportErrorIndication(3, "10.1.1.1", 0x100)
+	msg := waitRequest()
+	req, ok := msg.(*message.SessionModificationRequest)
+	if !ok {
+		t.Fatalf("Expected a session modification after the error indication, got %v", msg)
+	}
+	if len(req.UpdateFAR) != 1 {
+		t.Fatalf("Expected the update of the downlink FAR, got %d", len(req.UpdateFAR))
+	}
+	applyAction, err := req.UpdateFAR[0].ApplyAction()
+	if err != nil || applyAction[0] != 0x0c {
+		t.Errorf("Expected the BUFF and NOCP apply action, got %v %v", applyAction, err)
+	}
+	if req.CreateBAR == nil {
+		t.Errorf("Expected the BAR of the buffering FAR to be created")
+	}
+	if dlPDR.FAR.ForwardingParameters.OuterHeaderCreation != nil {
+		t.Errorf("Expected the downlink data no longer forwarded to the gNB tunnel")
+	}
+	if smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		t.Errorf("Expected the user plane connection deactivated, got %s", smContext.UpCnxState)
+	}
+	if smContext.Tunnel.ANInformation.IPAddress != nil || smContext.Tunnel.ANInformation.TEID != 0 {
+		t.Errorf("Expected the AN tunnel info removed, got %+v", smContext.Tunnel.ANInformation)
+	}
+	// the downlink data pages the UE at once
+	if !smContext.AllowDownlinkDataNotification(time.Now()) {
+		t.Errorf("Expected the next downlink data to page the UE")
+	}
+}
+
+func TestHandlePfcpSessionReportRequestPagingPolicy(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	smfSelf := context.SMF_Self()
+	origWindow := smfSelf.DDNThrottlingWindow
+	smfSelf.DDNThrottlingWindow = 0
+	t.Cleanup(func() { smfSelf.DDNThrottlingWindow = origWindow })
+
+	transfers := make(chan string, 2)
+	amf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		transfers <- string(body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(models.N1N2MessageTransferRspData{
+			Cause: models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE,
+		})
+	}), &http2.Server{}))
+	t.Cleanup(amf.Close)
+
+	smContext := context.NewSMContext("imsi-123456789012351", 16)
+	smContext.Supi = "imsi-123456789012351"
+	communicationConf := Namf_Communication.NewConfiguration()
+	communicationConf.SetBasePath(amf.URL)
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	smContext.DNNInfo = &context.SnssaiSmfDnnInfo{
+		PagingPolicyIndicators: map[uint8]uint8{46: 5},
+	}
+	upf := &context.UPF{
+		NodeID: *context.NewNodeID("6.6.6.6"),
+		N3Interfaces: []context.UPFInterfaceInfo{
+			{IPv4EndPointAddresses: []net.IP{net.ParseIP("10.0.0.6")}},
+		},
+	}
+	dataPath := &context.DataPath{
+		IsDefaultPath: true,
+		FirstDPNode: &context.DataPathNode{
+			UPF:          upf,
+			UpLinkTunnel: &context.GTPTunnel{TEID: 1},
+		},
+	}
+	smContext.Tunnel = &context.UPTunnel{DataPathPool: context.DataPathPool{1: dataPath}}
+	smContext.SmPolicyData.SmCtxtSessionRules.ActiveRule = &models.SessionRule{
+		AuthSessAmbr: &models.Ambr{Uplink: "1 Gbps", Downlink: "1 Gbps"},
+	}
+	smContext.AllocateLocalSEIDForDataPath(dataPath)
+	seid := smContext.PFCPContext["6.6.6.6"].LocalSEID
+
+	reportDownlinkData := func(sequence uint32, dscp uint8) string {
+		handler.HandlePfcpSessionReportRequest(&udp.Message{
+			RemoteAddr: &net.UDPAddr{IP: net.ParseIP("6.6.6.6"), Port: 8805},
+			PfcpMessage: message.NewSessionReportRequest(0, 0, seid, sequence, 0,
+				ie.NewReportType(0, 0, 0, 1),
+				ie.NewDownlinkDataReport(ie.NewPDRID(2), ie.NewDownlinkDataServiceInformation(true, false, dscp, 0)),
+			),
+		})
+		select {
+		case body := <-transfers:
+			return body
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a N1N2 message transfer")
+			return ""
+		}
+	}
+
+	// the DSCP of the buffered packet is mapped to the paging policy indicator
+	if body := reportDownlinkData(1, 46); !strings.Contains(body, `"ppi":5`) {
+		t.Errorf("Expected paging policy indicator 5 for DSCP 46, got %s", body)
+	}
+	// DSCP not mapped, no paging policy indicator
+	if body := reportDownlinkData(2, 10); strings.Contains(body, `"ppi"`) {
+		t.Errorf("Expected no paging policy indicator for DSCP 10, got %s", body)
+	}
+}
+
+func TestHandlePfcpSessionReportRequestPathRestored(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	upfConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.7")})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	t.Cleanup(func() { _ = upfConn.Close() })
+	smfConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	origServer := udp.Server
+	udp.Server = &udp.PfcpServer{Conn: smfConn}
+	t.Cleanup(func() {
+		udp.Server = origServer
+		_ = smfConn.Close()
+	})
+	upfAddr := upfConn.LocalAddr().(*net.UDPAddr)
+
+	nodeID := context.NewNodeID("127.0.0.7")
+	upf := context.NewUPF(nodeID, nil)
+	upf.Port = uint16(upfAddr.Port)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*nodeID) })
+
+	// the downlink data of the session is buffered by the UPF since the failure of the N3 path
+	smContext := context.NewSMContext("imsi-123456789012352", 17)
+	smContext.Dnn = "internet"
+	smContext.UpCnxState = models.UpCnxState_ACTIVATED
+	dlFAR := &context.FAR{FARID: 2, State: context.RULE_CREATE}
+	dlFAR.SetBuffering(upf)
+	if dlFAR.BAR == nil {
+		t.Fatalf("Expected a BAR for the buffering FAR")
+	}
+	barID := dlFAR.BAR.BARID
+	dlPDR := &context.PDR{PDRID: 2, State: context.RULE_CREATE, FAR: dlFAR}
+	dataPath := &context.DataPath{
+		Activated: true,
+		FirstDPNode: &context.DataPathNode{
+			UPF:            upf,
+			DownLinkTunnel: &context.GTPTunnel{PDR: map[string]*context.PDR{"default": dlPDR}},
+		},
+	}
+	smContext.Tunnel = &context.UPTunnel{DataPathPool: context.DataPathPool{1: dataPath}}
+	smContext.Tunnel.ANInformation.IPAddress = net.ParseIP("10.1.1.7")
+	smContext.Tunnel.ANInformation.TEID = 0x700
+	smContext.AllocateLocalSEIDForDataPath(dataPath)
+	pfcpContext := smContext.PFCPContext["127.0.0.7"]
+	pfcpContext.RemoteSEID = 100
+	seid := pfcpContext.LocalSEID
+	smContext.AllowDownlinkDataNotification(time.Now())
+
+	reportPathRecovery := func(sequence uint32, peer string) {
+		handler.HandlePfcpSessionReportRequest(&udp.Message{
+			RemoteAddr: upfAddr,
+			PfcpMessage: message.NewSessionReportRequest(0, 0, seid, sequence, 0,
+				ie.NewUserPlanePathRecoveryReport(ie.NewRemoteGTPUPeer(0x02, peer, "", 0, "")),
+			),
+		})
+	}
+	// waitModification returns the next PFCP Session Modification Request sent to the UPF, if any
+	waitModification := func() *message.SessionModificationRequest {
+		buf := make([]byte, 2048)
+		for {
+			if err := upfConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond)); err != nil {
+				t.Fatalf("error setting the read deadline: %v", err)
+			}
+			n, err := upfConn.Read(buf)
+			if err != nil {
+				return nil
+			}
+			msg, err := message.Parse(buf[:n])
+			if err != nil {
+				t.Fatalf("error parsing PFCP message: %v", err)
+			}
+			if req, ok := msg.(*message.SessionModificationRequest); ok {
+				return req
+			}
+		}
+	}
+
+	// path recovery of another gNB, the downlink data stays buffered
+	reportPathRecovery(1, "10.1.1.8")
+	if req := waitModification(); req != nil {
+		t.Fatalf("Expected no session modification for the path of another gNB")
+	}
+
+	// path recovery of the gNB of the session, the BAR is removed and the data forwarded
+	reportPathRecovery(2, "10.1.1.7")
+	req := waitModification()
+	if req == nil {
+		t.Fatalf("Expected a session modification after the path recovery")
+	}
+	if req.RemoveBAR == nil {
+		t.Fatalf("Expected the BAR of the FAR to be removed")
+	}
+	removeBARIEs, err := req.RemoveBAR.RemoveBAR()
+	if err != nil || len(removeBARIEs) != 1 {
+		t.Fatalf("Expected the BAR ID in Remove BAR, got %v %v", removeBARIEs, err)
+	}
+	if removedID, err := removeBARIEs[0].BARID(); err != nil || removedID != barID {
+		t.Errorf("Expected BAR[%d] removed, got %d %v", barID, removedID, err)
+	}
+	if len(req.UpdateFAR) != 1 {
+		t.Fatalf("Expected the update of the downlink FAR, got %d", len(req.UpdateFAR))
+	}
+	applyAction, err := req.UpdateFAR[0].ApplyAction()
+	if err != nil || applyAction[0] != 0x02 {
+		t.Errorf("Expected the FORW apply action, got %v %v", applyAction, err)
+	}
+	if _, err := req.UpdateFAR[0].BARID(); err == nil {
+		t.Errorf("Expected no BAR ID in the update of the FAR")
+	}
+	if dlFAR.BAR != nil {
+		t.Errorf("Expected the FAR to no longer refer to a BAR")
+	}
+	if ohc := dlFAR.ForwardingParameters.OuterHeaderCreation; ohc == nil || ohc.Teid != 0x700 {
+		t.Errorf("Expected the downlink data forwarded to the gNB tunnel, got %+v", ohc)
+	}
+
+	// the paging of the UE is cancelled
+	if !smContext.AllowDownlinkDataNotification(time.Now()) {
+		t.Errorf("Expected the paging of the UE cancelled")
+	}
+}
+
+func TestHandlePfcpNodeReportRequestLoad(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	upfConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.9")})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	t.Cleanup(func() { _ = upfConn.Close() })
+	smfConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	origServer := udp.Server
+	udp.Server = &udp.PfcpServer{Conn: smfConn}
+	t.Cleanup(func() {
+		udp.Server = origServer
+		_ = smfConn.Close()
+	})
+	upfAddr := upfConn.LocalAddr().(*net.UDPAddr)
+
+	snssaiInfos := []models.SnssaiUpfInfoItem{{
+		SNssai:         &models.Snssai{Sst: 1, Sd: "010203"},
+		DnnUpfInfoList: []models.DnnUpfInfoItem{{Dnn: "internet"}},
+	}}
+	upi := context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"GNodeB": {Type: "AN", NodeID: "192.168.180.100"},
+			"UPF-1":  {Type: "UPF", NodeID: "127.0.0.9", SNssaiInfos: snssaiInfos},
+			"UPF-2":  {Type: "UPF", NodeID: "127.0.0.10", SNssaiInfos: snssaiInfos},
+		},
+		Links: []factory.UPLink{
+			{A: "GNodeB", B: "UPF-1"},
+			{A: "GNodeB", B: "UPF-2"},
+		},
+	})
+	upf1, upf2 := upi.UPFs["UPF-1"], upi.UPFs["UPF-2"]
+	t.Cleanup(func() {
+		context.RemoveUPFNodeByNodeID(upf1.NodeID)
+		context.RemoveUPFNodeByNodeID(upf2.NodeID)
+	})
+	upf1.UPF.UPFStatus = context.AssociatedSetUpSuccess
+	upf2.UPF.UPFStatus = context.AssociatedSetUpSuccess
+
+	selection := &context.UPFSelectionParams{SNssai: &context.SNssai{Sst: 1, Sd: "010203"}, Dnn: "internet"}
+	anchor := func() *context.UPNode {
+		path := upi.GetDefaultUserPlanePathByDNN(selection)
+		if len(path) == 0 {
+			t.Fatalf("Expected a path for %s", selection.String())
+		}
+		return path[len(path)-1]
+	}
+	if anchor() != upf1 {
+		t.Fatalf("Expected the sessions on UPF-1 without load reported")
+	}
+
+	reportLoad := func(sequence uint32, cpuLoad uint8) {
+		handler.HandlePfcpNodeReportRequest(&udp.Message{
+			RemoteAddr: upfAddr,
+			PfcpMessage: message.NewNodeReportRequest(sequence,
+				ie.NewNodeID("127.0.0.9", "", ""),
+				ie.NewNodeReportType(0),
+				ies.NewUPFLoadReport(context.UPFLoadMetrics{CPULoad: cpuLoad, MemoryLoad: 40, SessionCount: 1000}),
+			),
+		})
+		buf := make([]byte, 1500)
+		if err := upfConn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("error setting the read deadline: %v", err)
+		}
+		n, err := upfConn.Read(buf)
+		if err != nil {
+			t.Fatalf("Expected a node report response: %v", err)
+		}
+		msg, err := message.Parse(buf[:n])
+		if err != nil {
+			t.Fatalf("error parsing PFCP message: %v", err)
+		}
+		rsp, ok := msg.(*message.NodeReportResponse)
+		if !ok || rsp.Sequence() != sequence {
+			t.Fatalf("Expected the node report response of sequence %d, got %v", sequence, msg)
+		}
+		if cause, err := rsp.Cause.Cause(); err != nil || cause != ie.CauseRequestAccepted {
+			t.Errorf("Expected cause request accepted, got %d %v", cause, err)
+		}
+	}
+
+	// the loaded UPF is selected after the other one, both reporting their load
+	upf2.UPF.SetLoadMetrics(context.UPFLoadMetrics{CPULoad: 20, ReportTime: time.Now()})
+	reportLoad(1, 90)
+	if load := upf1.UPF.LoadMetrics(); load.CPULoad != 90 || load.MemoryLoad != 40 || load.SessionCount != 1000 {
+		t.Errorf("Expected the load of UPF-1 recorded, got %+v", load)
+	}
+	if anchor() != upf2 {
+		t.Errorf("Expected the sessions moved to UPF-2 once UPF-1 is loaded")
+	}
+
+	// UPF-1 is selected again once UPF-2 is more loaded
+	upf2.UPF.SetLoadMetrics(context.UPFLoadMetrics{CPULoad: 95, ReportTime: time.Now()})
+	if anchor() != upf1 {
+		t.Errorf("Expected the sessions back on UPF-1, less loaded than UPF-2")
+	}
+
+	// the load de-prioritizes a UPF, it is still selected when the other one is unavailable
+	upf2.UPF.UPFStatus = context.NotAssociated
+	reportLoad(2, 99)
+	if anchor() != upf1 {
+		t.Errorf("Expected the loaded UPF-1 selected while UPF-2 is not associated")
+	}
+}
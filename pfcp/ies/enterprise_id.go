@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ies
+
+import (
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+)
+
+// EnterpriseID returns the enterprise ID of the enterprise specific IEs, the IANA private
+// enterprise number of the UPF vendor configured. 8.1.1
+func EnterpriseID() uint16 {
+	if id := context.SMF_Self().PFCPEnterpriseID; id != 0 {
+		return id
+	}
+	return factory.DEFAULT_PFCP_ENTERPRISE_ID
+}
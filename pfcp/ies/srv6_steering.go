@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// SRv6 steering is not defined by TS 29.244, the segment list is carried in an enterprise
+// specific IE of the Forwarding Parameters. 8.1.1
+
+package ies
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+const (
+	// IE type of the SRv6 Steering, in the range of the enterprise specific IEs
+	SRv6SteeringIEType uint16 = 32768 + 1
+)
+
+// NewSRv6Steering encodes the SRv6 Steering IE: the number of segments, then the 16 octets of
+// each segment ID in the order they are traversed
+func NewSRv6Steering(segments []net.IP) *ie.IE {
+	payload := make([]byte, 0, 1+len(segments)*net.IPv6len)
+	payload = append(payload, uint8(len(segments)))
+	for _, segment := range segments {
+		payload = append(payload, segment.To16()...)
+	}
+	return ie.NewVendorSpecificIE(SRv6SteeringIEType, EnterpriseID(), payload)
+}
+
+// ParseSRv6Steering returns the segment IDs of the SRv6 Steering IE
+func ParseSRv6Steering(i *ie.IE) ([]net.IP, error) {
+	if i.Type != SRv6SteeringIEType || i.EnterpriseID != EnterpriseID() {
+		return nil, fmt.Errorf("IE type %d of enterprise %d is not SRv6 Steering", i.Type, i.EnterpriseID)
+	}
+	if len(i.Payload) < 1 {
+		return nil, fmt.Errorf("inadequate TLV length: %d", len(i.Payload))
+	}
+	count := int(i.Payload[0])
+	if len(i.Payload) != 1+count*net.IPv6len {
+		return nil, fmt.Errorf("inadequate TLV length %d for %d segments", len(i.Payload), count)
+	}
+	segments := make([]net.IP, 0, count)
+	for idx := 1; idx < len(i.Payload); idx += net.IPv6len {
+		segments = append(segments, net.IP(i.Payload[idx:idx+net.IPv6len]))
+	}
+	return segments, nil
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ies_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/omec-project/smf/pfcp/ies"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestNewSRv6Steering(t *testing.T) {
+	segments := []net.IP{net.ParseIP("fc00:1::1"), net.ParseIP("fc00:2::100")}
+	b, err := ies.NewSRv6Steering(segments).Marshal()
+	if err != nil {
+		t.Fatalf("error marshalling SRv6 Steering: %v", err)
+	}
+
+	expected := []byte{
+		0x80, 0x01, // type, enterprise specific
+		0x00, 0x23, // length, including the enterprise ID
+		0x7e, 0xd9, // enterprise ID
+		0x02, // number of segments
+		0xfc, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x00, 0x01,
+		0xfc, 0x00, 0x00, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("expected %x, got %x", expected, b)
+	}
+
+	parsed, err := ie.Parse(b)
+	if err != nil {
+		t.Fatalf("error parsing SRv6 Steering: %v", err)
+	}
+	decoded, err := ies.ParseSRv6Steering(parsed)
+	if err != nil {
+		t.Fatalf("error decoding SRv6 Steering: %v", err)
+	}
+	if len(decoded) != 2 || !decoded[0].Equal(segments[0]) || !decoded[1].Equal(segments[1]) {
+		t.Errorf("expected segments %v, got %v", segments, decoded)
+	}
+}
+
+func TestParseSRv6SteeringInvalid(t *testing.T) {
+	if _, err := ies.ParseSRv6Steering(ie.NewVendorSpecificIE(ies.SRv6SteeringIEType, ies.EnterpriseID(),
+		[]byte{0x02, 0xfc, 0x00})); err == nil {
+		t.Errorf("expected an error for a truncated segment list")
+	}
+	if _, err := ies.ParseSRv6Steering(ie.NewNetworkInstance("internet")); err == nil {
+		t.Errorf("expected an error for another IE")
+	}
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// The Load Control Information of TS 29.244 carries a single load metric per session message, a
+// UPF reporting its CPU and memory load and its number of sessions carries them in an enterprise
+// specific IE of the Node Report Request. 8.1.1
+
+package ies
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+const (
+	// IE type of the UPF Load Report, in the range of the enterprise specific IEs
+	UPFLoadReportIEType uint16 = 32768 + 3
+
+	upfLoadReportLen = 1 + 1 + 4
+)
+
+// NewUPFLoadReport encodes the UPF Load Report IE: the CPU and memory loads in percent on an
+// octet each, then the number of sessions on 4 octets
+func NewUPFLoadReport(load context.UPFLoadMetrics) *ie.IE {
+	payload := make([]byte, upfLoadReportLen)
+	payload[0] = load.CPULoad
+	payload[1] = load.MemoryLoad
+	binary.BigEndian.PutUint32(payload[2:6], load.SessionCount)
+	return ie.NewVendorSpecificIE(UPFLoadReportIEType, EnterpriseID(), payload)
+}
+
+// ParseUPFLoadReport returns the load of the UPF Load Report IE, reported at the time
+func ParseUPFLoadReport(i *ie.IE, reportTime time.Time) (*context.UPFLoadMetrics, error) {
+	if i.Type != UPFLoadReportIEType || i.EnterpriseID != EnterpriseID() {
+		return nil, fmt.Errorf("IE type %d of enterprise %d is not UPF Load Report", i.Type, i.EnterpriseID)
+	}
+	if len(i.Payload) != upfLoadReportLen {
+		return nil, fmt.Errorf("inadequate TLV length: %d", len(i.Payload))
+	}
+	if i.Payload[0] > 100 || i.Payload[1] > 100 {
+		return nil, fmt.Errorf("load [cpu: %d, memory: %d] not in percent", i.Payload[0], i.Payload[1])
+	}
+	return &context.UPFLoadMetrics{
+		CPULoad:      i.Payload[0],
+		MemoryLoad:   i.Payload[1],
+		SessionCount: binary.BigEndian.Uint32(i.Payload[2:6]),
+		ReportTime:   reportTime,
+	}, nil
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package ies_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/pfcp/ies"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestNewUPFLoadReport(t *testing.T) {
+	load := context.UPFLoadMetrics{CPULoad: 85, MemoryLoad: 60, SessionCount: 0x010203}
+	b, err := ies.NewUPFLoadReport(load).Marshal()
+	if err != nil {
+		t.Fatalf("error marshalling UPF Load Report: %v", err)
+	}
+
+	expected := []byte{
+		0x80, 0x03, // type, enterprise specific
+		0x00, 0x08, // length, including the enterprise ID
+		0x7e, 0xd9, // enterprise ID
+		85, 60,
+		0x00, 0x01, 0x02, 0x03,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("expected %x, got %x", expected, b)
+	}
+
+	parsed, err := ie.Parse(b)
+	if err != nil {
+		t.Fatalf("error parsing UPF Load Report: %v", err)
+	}
+	reportTime := time.Now()
+	decoded, err := ies.ParseUPFLoadReport(parsed, reportTime)
+	if err != nil {
+		t.Fatalf("error decoding UPF Load Report: %v", err)
+	}
+	load.ReportTime = reportTime
+	if *decoded != load {
+		t.Errorf("expected load %+v, got %+v", load, decoded)
+	}
+}
+
+func TestUPFLoadReportInvalid(t *testing.T) {
+	if _, err := ies.ParseUPFLoadReport(ie.NewVendorSpecificIE(ies.UPFLoadReportIEType,
+		ies.EnterpriseID(), []byte{10, 20}), time.Now()); err == nil {
+		t.Errorf("expected an error for a truncated session count")
+	}
+	if _, err := ies.ParseUPFLoadReport(ie.NewVendorSpecificIE(ies.UPFLoadReportIEType,
+		ies.EnterpriseID(), []byte{101, 20, 0, 0, 0, 1}), time.Now()); err == nil {
+		t.Errorf("expected an error for a load over 100 percent")
+	}
+	if _, err := ies.ParseUPFLoadReport(ie.NewNetworkInstance("internet"), time.Now()); err == nil {
+		t.Errorf("expected an error for another IE")
+	}
+}
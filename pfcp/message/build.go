@@ -7,10 +7,17 @@
 package message
 
 import (
+	"encoding/hex"
+	"fmt"
 	"net"
+	"strings"
 	"time"
 
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/pfcp/ies"
 	"github.com/wmnsk/go-pfcp/ie"
 	"github.com/wmnsk/go-pfcp/message"
 )
@@ -51,6 +58,13 @@ func BuildPfcpAssociationSetupResponse(cause uint8, recoveryTimeStamp time.Time,
 	)
 }
 
+func BuildPfcpAssociationReleaseRequest(sequenceNumber uint32, nodeID string) *message.AssociationReleaseRequest {
+	return message.NewAssociationReleaseRequest(
+		sequenceNumber,
+		ie.NewNodeIDHeuristic(nodeID),
+	)
+}
+
 func BuildPfcpAssociationReleaseResponse(cause uint8, nodeID string) *message.AssociationReleaseResponse {
 	return message.NewAssociationReleaseResponse(
 		1,
@@ -59,6 +73,15 @@ func BuildPfcpAssociationReleaseResponse(cause uint8, nodeID string) *message.As
 	)
 }
 
+func BuildPfcpNodeReportResponse(cause uint8, sequenceNumber uint32, nodeID string) *message.NodeReportResponse {
+	return message.NewNodeReportResponse(
+		sequenceNumber,
+		ie.NewNodeIDHeuristic(nodeID),
+		ie.NewCause(cause),
+		nil,
+	)
+}
+
 // setBit sets the bit at the given position to the specified value (true or false)
 // Positions go from 1 to 8
 func (f *Flag) setBit(position uint8, value bool) {
@@ -72,6 +95,24 @@ func (f *Flag) setBit(position uint8, value bool) {
 	}
 }
 
+// flagBit returns 1 if the flag is set, the form of the flags of the IE constructors
+func flagBit(set bool) int {
+	if set {
+		return 1
+	}
+	return 0
+}
+
+// MapAppIDToPDR translates an application ID to the Application ID IE of the PDI,
+// returns nil if no application ID is given
+func MapAppIDToPDR(appID string) *ie.IE {
+	appID = strings.TrimSpace(appID)
+	if appID == "" {
+		return nil
+	}
+	return ie.NewApplicationID(appID)
+}
+
 func createPDIIE(pdi *context.PDI) *ie.IE {
 	createPDIIes := make([]*ie.IE, 0)
 	createPDIIes = append(createPDIIes,
@@ -117,8 +158,21 @@ func createPDIIE(pdi *context.PDI) *ie.IE {
 		)
 	}
 
-	if pdi.ApplicationID != "" {
-		createPDIIes = append(createPDIIes, ie.NewApplicationID(pdi.ApplicationID))
+	for _, route := range pdi.FramedRoutes {
+		createPDIIes = append(createPDIIes, ie.NewFramedRoute(route))
+	}
+
+	if pdi.EthernetPDUSessionInformation {
+		// ETHI
+		createPDIIes = append(createPDIIes, ie.NewEthernetPDUSessionInformation(1))
+	}
+
+	if pdi.TGPPInterfaceType != nil {
+		createPDIIes = append(createPDIIes, ie.NewTGPPInterfaceType(*pdi.TGPPInterfaceType))
+	}
+
+	if appIDIE := MapAppIDToPDR(pdi.ApplicationID); appIDIE != nil {
+		createPDIIes = append(createPDIIes, appIDIE)
 	}
 
 	if pdi.SDFFilter != nil {
@@ -151,6 +205,12 @@ func pdrToCreatePDR(pdr *context.PDR) *ie.IE {
 			ies = append(ies, ie.NewQERID(qer.QERID))
 		}
 	}
+	if pdr.URR != nil {
+		ies = append(ies, ie.NewURRID(pdr.URR.URRID))
+	}
+	if pdr.MAR != nil {
+		ies = append(ies, ie.NewMARID(pdr.MAR.MARID))
+	}
 	return ie.NewCreatePDR(ies...)
 }
 
@@ -186,6 +246,19 @@ func farToCreateFAR(far *context.FAR) *ie.IE {
 		if far.ForwardingParameters.ForwardingPolicyID != "" {
 			forwardingParametersIEs = append(forwardingParametersIEs, ie.NewForwardingPolicy(far.ForwardingParameters.ForwardingPolicyID))
 		}
+		for _, header := range far.ForwardingParameters.HeaderEnrichment {
+			forwardingParametersIEs = append(forwardingParametersIEs, ie.NewHeaderEnrichment(header.HeaderType, header.Name, header.Value))
+		}
+		if len(far.ForwardingParameters.SRv6SegmentList) > 0 {
+			forwardingParametersIEs = append(forwardingParametersIEs, ies.NewSRv6Steering(far.ForwardingParameters.SRv6SegmentList))
+		}
+		if far.ForwardingParameters.TGPPInterfaceType != nil {
+			forwardingParametersIEs = append(forwardingParametersIEs, ie.NewTGPPInterfaceType(*far.ForwardingParameters.TGPPInterfaceType))
+		}
+		if redirect := far.ForwardingParameters.RedirectInformation; redirect != nil {
+			forwardingParametersIEs = append(forwardingParametersIEs,
+				ie.NewRedirectInformation(redirect.RedirectAddressType, redirect.RedirectServerAddress))
+		}
 		createFARies = append(createFARies, ie.NewForwardingParameters(forwardingParametersIEs...))
 	}
 	return ie.NewCreateFAR(createFARies...)
@@ -207,6 +280,123 @@ func qerToCreateQER(qer *context.QER) *ie.IE {
 	return ie.NewCreateQER(createQERies...)
 }
 
+// qerToUpdateQER encodes the gate and rates of the QER updated on the UPF
+func qerToUpdateQER(qer *context.QER) *ie.IE {
+	updateQERies := make([]*ie.IE, 0)
+	updateQERies = append(updateQERies, ie.NewQERID(qer.QERID))
+	if qer.GateStatus != nil {
+		updateQERies = append(updateQERies, ie.NewGateStatus(qer.GateStatus.ULGate, qer.GateStatus.DLGate))
+	}
+	if qer.MBR != nil {
+		updateQERies = append(updateQERies, ie.NewMBR(qer.MBR.ULMBR, qer.MBR.DLMBR))
+	}
+	if qer.GBR != nil {
+		updateQERies = append(updateQERies, ie.NewGBR(qer.GBR.ULGBR, qer.GBR.DLGBR))
+	}
+	return ie.NewUpdateQER(updateQERies...)
+}
+
+// urrToCreateURR encodes the usage measured by the URR, reported periodically if it has a
+// measurement period
+func urrToCreateURR(urr *context.URR) *ie.IE {
+	createURRies := make([]*ie.IE, 0)
+	createURRies = append(createURRies, ie.NewURRID(urr.URRID))
+	createURRies = append(createURRies, ie.NewMeasurementMethod(flagBit(urr.MeasurementMethod.Event),
+		flagBit(urr.MeasurementMethod.Volum), flagBit(urr.MeasurementMethod.Durat)))
+	if urr.MeasurementPeriod != 0 {
+		// PERIO
+		createURRies = append(createURRies, ie.NewReportingTriggers(0x01, 0x00))
+		createURRies = append(createURRies, ie.NewMeasurementPeriod(urr.MeasurementPeriod))
+	} else {
+		createURRies = append(createURRies, ie.NewReportingTriggers(0x00, 0x00))
+	}
+	return ie.NewCreateURR(createURRies...)
+}
+
+// barToCreateBAR encodes the buffering of the downlink packets of the FARs referring to the BAR
+func barToCreateBAR(bar *context.BAR) *ie.IE {
+	createBARies := make([]*ie.IE, 0)
+	createBARies = append(createBARies, ie.NewBARID(bar.BARID))
+	if bar.DownlinkDataNotificationDelay.DelayValue != 0 {
+		createBARies = append(createBARies, ie.NewDownlinkDataNotificationDelay(bar.DownlinkDataNotificationDelay.DelayValue))
+	}
+	if bar.SuggestedBufferingPacketsCount.PacketCountValue != 0 {
+		createBARies = append(createBARies, ie.NewSuggestedBufferingPacketsCount(bar.SuggestedBufferingPacketsCount.PacketCountValue))
+	}
+	return ie.NewCreateBAR(createBARies...)
+}
+
+// marToCreateMAR encodes the steering of the MA PDU session traffic, each access
+// forwards with its FAR and the weight or priority required by the steering mode
+func marToCreateMAR(mar *context.MAR) *ie.IE {
+	createMARies := make([]*ie.IE, 0)
+	createMARies = append(createMARies, ie.NewMARID(mar.MARID))
+	createMARies = append(createMARies, ie.NewSteeringFunctionality(uint8(mar.SteeringFunctionality)))
+	createMARies = append(createMARies, ie.NewSteeringMode(uint8(mar.SteeringMode)))
+
+	accessForwardingActionInfo := func(far *context.FAR, weight uint8, active bool) []*ie.IE {
+		ies := []*ie.IE{ie.NewFARID(far.FARID)}
+		switch mar.SteeringMode {
+		case context.ATSSSModeLoadBalancing:
+			ies = append(ies, ie.NewWeight(weight))
+		case context.ATSSSModeActiveStandby:
+			if active {
+				ies = append(ies, ie.NewPriority(ie.PriorityActive))
+			} else {
+				ies = append(ies, ie.NewPriority(ie.PriorityStandby))
+			}
+		case context.ATSSSModePriorityBased:
+			if active {
+				ies = append(ies, ie.NewPriority(ie.PriorityHigh))
+			} else {
+				ies = append(ies, ie.NewPriority(ie.PriorityLow))
+			}
+		}
+		return ies
+	}
+	if mar.TGPPAccessFAR != nil {
+		createMARies = append(createMARies, ie.NewTGPPAccessForwardingActionInformation(
+			accessForwardingActionInfo(mar.TGPPAccessFAR, mar.TGPPAccessWeight, !mar.NonTGPPAccessActive)...))
+	}
+	if mar.NonTGPPAccessFAR != nil {
+		createMARies = append(createMARies, ie.NewNonTGPPAccessForwardingActionInformation(
+			accessForwardingActionInfo(mar.NonTGPPAccessFAR, 100-mar.TGPPAccessWeight, mar.NonTGPPAccessActive)...))
+	}
+	return ie.NewCreateMAR(createMARies...)
+}
+
+// srrToCreateSRR encodes the QoS monitoring of the packet delay of each QoS flow of the SRR
+func srrToCreateSRR(srr *context.SRR) *ie.IE {
+	qosMonitoring := srr.QoSMonitoring
+	var thresholdFlags Flag
+	thresholdFlags.setBit(1, qosMonitoring.DownlinkThreshold != 0)
+	thresholdFlags.setBit(2, qosMonitoring.UplinkThreshold != 0)
+	thresholdFlags.setBit(3, qosMonitoring.RoundTripThreshold != 0)
+
+	createSRRies := make([]*ie.IE, 0, 1+len(srr.QFIs))
+	createSRRies = append(createSRRies, ie.NewSRRID(srr.SRRID))
+	for _, qfi := range srr.QFIs {
+		controlInfoIEs := []*ie.IE{
+			ie.NewQFI(qfi),
+			ie.NewRequestedQoSMonitoring(flagBit(qosMonitoring.RoundTrip), flagBit(qosMonitoring.Uplink), flagBit(qosMonitoring.Downlink)),
+			ie.NewReportingFrequency(flagBit(qosMonitoring.SessionRelease), flagBit(qosMonitoring.Periodic),
+				flagBit(qosMonitoring.EventTriggered)),
+		}
+		if thresholdFlags != 0 {
+			controlInfoIEs = append(controlInfoIEs, ie.NewPacketDelayThresholds(uint8(thresholdFlags),
+				qosMonitoring.DownlinkThreshold, qosMonitoring.UplinkThreshold, qosMonitoring.RoundTripThreshold))
+		}
+		if qosMonitoring.MinimumWaitTime != 0 {
+			controlInfoIEs = append(controlInfoIEs, ie.NewMinimumWaitTime(qosMonitoring.MinimumWaitTime))
+		}
+		if qosMonitoring.Periodic {
+			controlInfoIEs = append(controlInfoIEs, ie.NewMeasurementPeriod(qosMonitoring.MeasurementPeriod))
+		}
+		createSRRies = append(createSRRies, ie.NewQoSMonitoringPerQoSFlowControlInformation(controlInfoIEs...))
+	}
+	return ie.NewCreateSRR(createSRRies...)
+}
+
 func pdrToUpdatePDR(pdr *context.PDR) *ie.IE {
 	updatePDRies := make([]*ie.IE, 0)
 	updatePDRies = append(updatePDRies, ie.NewPDRID(pdr.PDRID))
@@ -270,11 +460,53 @@ func farToUpdateFAR(far *context.FAR) *ie.IE {
 		if far.ForwardingParameters.ForwardingPolicyID != "" {
 			forwardingParametersIEs = append(forwardingParametersIEs, ie.NewForwardingPolicy(far.ForwardingParameters.ForwardingPolicyID))
 		}
+		for _, header := range far.ForwardingParameters.HeaderEnrichment {
+			forwardingParametersIEs = append(forwardingParametersIEs, ie.NewHeaderEnrichment(header.HeaderType, header.Name, header.Value))
+		}
+		if len(far.ForwardingParameters.SRv6SegmentList) > 0 {
+			forwardingParametersIEs = append(forwardingParametersIEs, ies.NewSRv6Steering(far.ForwardingParameters.SRv6SegmentList))
+		}
+		if far.ForwardingParameters.TGPPInterfaceType != nil {
+			forwardingParametersIEs = append(forwardingParametersIEs, ie.NewTGPPInterfaceType(*far.ForwardingParameters.TGPPInterfaceType))
+		}
+		if redirect := far.ForwardingParameters.RedirectInformation; redirect != nil {
+			forwardingParametersIEs = append(forwardingParametersIEs,
+				ie.NewRedirectInformation(redirect.RedirectAddressType, redirect.RedirectServerAddress))
+		}
 		updateFARies = append(updateFARies, ie.NewUpdateForwardingParameters(forwardingParametersIEs...))
 	}
 	return ie.NewUpdateFAR(updateFARies...)
 }
 
+// SessionPDNType returns the PFCP PDN type of the PDU session type selected for the session
+func SessionPDNType(ctx *context.SMContext) uint8 {
+	switch ctx.SelectedPDUSessionType {
+	case nasMessage.PDUSessionTypeIPv6:
+		return ie.PDNTypeIPv6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return ie.PDNTypeIPv4v6
+	case nasMessage.PDUSessionTypeUnstructured:
+		return ie.PDNTypeNonIP
+	case nasMessage.PDUSessionTypeEthernet:
+		return ie.PDNTypeEthernet
+	default:
+		return ie.PDNTypeIPv4
+	}
+}
+
+// SessionEstablishmentOptions are the session parameters of the Session Establishment Request
+// besides its rules
+type SessionEstablishmentOptions struct {
+	// SRR reports the QoS monitoring of the session, none if nil
+	SRR *context.SRR
+	// TraceData activates the trace of the session, none if nil
+	TraceData *models.TraceData
+	// CreateBridgeInfo requests the UPF to allocate the NW-TT port of the TSN bridge
+	CreateBridgeInfo bool
+	// PDNType of the session, IPv4 if unset
+	PDNType uint8
+}
+
 func BuildPfcpSessionEstablishmentRequest(
 	sequenceNumber uint32,
 	nodeID string,
@@ -283,45 +515,164 @@ func BuildPfcpSessionEstablishmentRequest(
 	pdrList []*context.PDR,
 	farList []*context.FAR,
 	qerList []*context.QER,
+	opts SessionEstablishmentOptions,
 ) (*message.SessionEstablishmentRequest, error) {
 	ies := make([]*ie.IE, 0)
 	ies = append(ies, ie.NewNodeIDHeuristic(nodeID))
 	ies = append(ies, ie.NewFSEID(localSeid, fseidIpv4Address, nil))
 
+	ies = append(ies, createRuleIEs(pdrList, farList, qerList)...)
+	markRulesCreated(pdrList, farList, qerList)
+
+	if opts.SRR != nil {
+		ies = append(ies, srrToCreateSRR(opts.SRR))
+	}
+
+	pdnType := opts.PDNType
+	if pdnType == 0 {
+		pdnType = ie.PDNTypeIPv4
+	}
+	ies = append(ies, ie.NewPDNType(pdnType))
+
+	if opts.TraceData != nil {
+		if traceInfo, err := traceDataToTraceInformation(opts.TraceData); err != nil {
+			logger.PfcpLog.Warnf("trace information not sent, invalid trace data [%+v]: %v", opts.TraceData, err)
+		} else {
+			ies = append(ies, traceInfo)
+		}
+	}
+
+	// request the UPF to allocate the NW-TT port of the TSN bridge
+	if opts.CreateBridgeInfo {
+		ies = append(ies, ie.NewCreateBridgeInfoForTSC(1))
+	}
+
+	return message.NewSessionEstablishmentRequest(
+		1,
+		0,
+		0,
+		sequenceNumber,
+		0,
+		ies...,
+	), nil
+}
+
+// createRuleIEs returns the IEs creating the rules not created yet on the UPF: the PDRs with their
+// MARs and URRs, the FARs with their BARs and the QERs. The URRs and BARs shared by several rules
+// are created once. The state of the rules is left unchanged.
+func createRuleIEs(pdrList []*context.PDR, farList []*context.FAR, qerList []*context.QER) []*ie.IE {
+	ies := make([]*ie.IE, 0, len(pdrList)+len(farList)+len(qerList))
+	createdMARs := make(map[*context.MAR]bool)
+	createdURRs := make(map[*context.URR]bool)
 	for _, pdr := range pdrList {
 		if pdr.State == context.RULE_INITIAL {
 			ies = append(ies, pdrToCreatePDR(pdr))
 		}
+		if pdr.MAR != nil && pdr.MAR.State == context.RULE_INITIAL && !createdMARs[pdr.MAR] {
+			ies = append(ies, marToCreateMAR(pdr.MAR))
+			createdMARs[pdr.MAR] = true
+		}
+		// the URR is shared by the PDRs, created with the first one
+		if pdr.URR != nil && pdr.URR.State == context.RULE_INITIAL && !createdURRs[pdr.URR] {
+			ies = append(ies, urrToCreateURR(pdr.URR))
+			createdURRs[pdr.URR] = true
+		}
 	}
 
+	createdBARs := make(map[*context.BAR]bool)
 	for _, far := range farList {
 		if far.State == context.RULE_INITIAL {
 			ies = append(ies, farToCreateFAR(far))
 		}
-		far.State = context.RULE_CREATE
+		if far.BAR != nil && far.BAR.State == context.RULE_INITIAL && !createdBARs[far.BAR] {
+			ies = append(ies, barToCreateBAR(far.BAR))
+			createdBARs[far.BAR] = true
+		}
 	}
 
-	qerMap := make(map[uint32]*context.QER)
+	createdQERs := make(map[uint32]bool)
 	for _, qer := range qerList {
-		qerMap[qer.QERID] = qer
+		if qer.State == context.RULE_INITIAL && !createdQERs[qer.QERID] {
+			ies = append(ies, qerToCreateQER(qer))
+			createdQERs[qer.QERID] = true
+		}
 	}
-	for _, filteredQER := range qerMap {
-		if filteredQER.State == context.RULE_INITIAL {
-			ies = append(ies, qerToCreateQER(filteredQER))
+	return ies
+}
+
+// markRulesCreated records that the rules were sent to the UPF for their creation
+func markRulesCreated(pdrList []*context.PDR, farList []*context.FAR, qerList []*context.QER) {
+	for _, pdr := range pdrList {
+		if pdr.MAR != nil && pdr.MAR.State == context.RULE_INITIAL {
+			pdr.MAR.State = context.RULE_CREATE
+		}
+		if pdr.URR != nil && pdr.URR.State == context.RULE_INITIAL {
+			pdr.URR.State = context.RULE_CREATE
+		}
+	}
+	for _, far := range farList {
+		if far.BAR != nil && far.BAR.State == context.RULE_INITIAL {
+			far.BAR.State = context.RULE_CREATE
 		}
-		filteredQER.State = context.RULE_CREATE
+		far.State = context.RULE_CREATE
+	}
+	for _, qer := range qerList {
+		qer.State = context.RULE_CREATE
+	}
+}
+
+// traceDataToTraceInformation encodes the trace data of the session into the
+// Trace Information IE, TS 29.244 8.2.103
+func traceDataToTraceInformation(traceData *models.TraceData) (*ie.IE, error) {
+	// Trace Reference is <MCC><MNC>-<Trace ID>, TS 29.571 5.6.2
+	plmnID, traceIDStr, found := strings.Cut(traceData.TraceRef, "-")
+	if !found || (len(plmnID) != 5 && len(plmnID) != 6) {
+		return nil, fmt.Errorf("invalid trace reference [%s]", traceData.TraceRef)
+	}
+	traceID, err := hex.DecodeString(traceIDStr)
+	if err != nil || len(traceID) != 3 {
+		return nil, fmt.Errorf("invalid trace id [%s]", traceIDStr)
 	}
 
-	ies = append(ies, ie.NewPDNType(ie.PDNTypeIPv4))
+	depth, ok := traceDepthValues[traceData.TraceDepth]
+	if !ok {
+		return nil, fmt.Errorf("invalid trace depth [%s]", traceData.TraceDepth)
+	}
 
-	return message.NewSessionEstablishmentRequest(
-		1,
-		0,
-		0,
-		sequenceNumber,
-		0,
-		ies...,
-	), nil
+	events, err := hex.DecodeString(traceData.EventList)
+	if err != nil {
+		return nil, fmt.Errorf("invalid triggering events [%s]", traceData.EventList)
+	}
+
+	interfaces, err := hex.DecodeString(traceData.InterfaceList)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface list [%s]", traceData.InterfaceList)
+	}
+
+	collectionEntityIP := net.ParseIP(traceData.CollectionEntityIpv4Addr)
+	if collectionEntityIP == nil {
+		collectionEntityIP = net.ParseIP(traceData.CollectionEntityIpv6Addr)
+	}
+	// the IP address of the Trace Collection Entity is mandatory in the IE
+	if collectionEntityIP == nil {
+		return nil, fmt.Errorf("no trace collection entity address")
+	}
+
+	traceInfo := ie.NewTraceInformation(plmnID[:3], plmnID[3:], string(traceID), events, depth, interfaces, collectionEntityIP)
+	if traceInfo == nil {
+		return nil, fmt.Errorf("trace information encoding failed")
+	}
+	return traceInfo, nil
+}
+
+// Session Trace Depth, TS 32.422 5.2
+var traceDepthValues = map[models.TraceDepth]uint8{
+	models.TraceDepth_MINIMUM:                     0,
+	models.TraceDepth_MEDIUM:                      1,
+	models.TraceDepth_MAXIMUM:                     2,
+	models.TraceDepth_MINIMUM_WO_VENDOR_EXTENSION: 3,
+	models.TraceDepth_MEDIUM_WO_VENDOR_EXTENSION:  4,
+	models.TraceDepth_MAXIMUM_WO_VENDOR_EXTENSION: 5,
 }
 
 // TODO: Replace dummy value in PFCP message
@@ -346,10 +697,28 @@ func BuildPfcpSessionModificationRequest(
 		case context.RULE_REMOVE:
 			ies = append(ies, ie.NewRemovePDR(ie.NewPDRID(pdr.PDRID)))
 		}
+		if pdr.MAR != nil {
+			switch {
+			case pdr.MAR.State == context.RULE_INITIAL && pdr.State != context.RULE_REMOVE:
+				ies = append(ies, marToCreateMAR(pdr.MAR))
+			case pdr.MAR.State != context.RULE_INITIAL && pdr.State == context.RULE_REMOVE:
+				ies = append(ies, ie.NewRemoveMAR(ie.NewMARID(pdr.MAR.MARID)))
+			}
+			pdr.MAR.State = context.RULE_CREATE
+		}
+		if pdr.URR != nil && pdr.URR.State == context.RULE_INITIAL && pdr.State != context.RULE_REMOVE {
+			ies = append(ies, urrToCreateURR(pdr.URR))
+			pdr.URR.State = context.RULE_CREATE
+		}
 		pdr.State = context.RULE_CREATE
 	}
 
 	for _, far := range farList {
+		// the FAR no longer refers to its BAR removed
+		var removedBAR *context.BAR
+		if far.BAR != nil && far.BAR.State == context.RULE_REMOVE {
+			removedBAR, far.BAR = far.BAR, nil
+		}
 		switch far.State {
 		case context.RULE_INITIAL:
 			ies = append(ies, farToCreateFAR(far))
@@ -358,6 +727,13 @@ func BuildPfcpSessionModificationRequest(
 		case context.RULE_REMOVE:
 			ies = append(ies, ie.NewRemoveFAR(ie.NewFARID(far.FARID)))
 		}
+		if removedBAR != nil {
+			ies = append(ies, ie.NewRemoveBAR(ie.NewBARID(removedBAR.BARID)))
+		}
+		if far.BAR != nil && far.BAR.State == context.RULE_INITIAL && far.State != context.RULE_REMOVE {
+			ies = append(ies, barToCreateBAR(far.BAR))
+			far.BAR.State = context.RULE_CREATE
+		}
 		far.State = context.RULE_CREATE
 	}
 
@@ -365,6 +741,10 @@ func BuildPfcpSessionModificationRequest(
 		switch qer.State {
 		case context.RULE_INITIAL:
 			ies = append(ies, qerToCreateQER(qer))
+		case context.RULE_UPDATE:
+			ies = append(ies, qerToUpdateQER(qer))
+		case context.RULE_REMOVE:
+			ies = append(ies, ie.NewRemoveQER(ie.NewQERID(qer.QERID)))
 		}
 		qer.State = context.RULE_CREATE
 	}
@@ -378,6 +758,98 @@ func BuildPfcpSessionModificationRequest(
 	), nil
 }
 
+// BuildTSCManagementInformation carries the port management information container of the
+// TSN bridge to the NW-TT port of the UPF in a Session Modification Request
+func BuildTSCManagementInformation(portManagementInfo []byte, nwttPortNumber uint32) *ie.IE {
+	return ie.NewTSCManagementInformationWithinSessionModificationRequest(
+		ie.NewPortManagementInformationContainer(string(portManagementInfo)),
+		ie.NewNWTTPortNumber(nwttPortNumber),
+	)
+}
+
+// reachabilityProbePrecedence keeps the probe PDR ahead of the PDRs of the session
+const reachabilityProbePrecedence = 1
+
+// BuildPfcpReachabilityProbeRequest installs the rules of the UE reachability probe: a PDR matching
+// the ICMP echo replies of the UE, a FAR redirecting them to the echo responder and a URR counting them
+func BuildPfcpReachabilityProbeRequest(
+	sequenceNumber uint32,
+	localSEID uint64,
+	remoteSEID uint64,
+	fseidIPv4Address net.IP,
+	ueIP net.IP,
+	responderIP net.IP,
+	pdrID uint16,
+	farID uint32,
+	urrID uint32,
+) *message.SessionModificationRequest {
+	ueIPAddressflags := new(Flag)
+	ueIPAddressflags.setBit(2, true)
+	applyActionflag := new(Flag)
+	applyActionflag.setBit(2, true)
+	// MNOP, measure the number of packets
+	measurementInfoflag := new(Flag)
+	measurementInfoflag.setBit(5, true)
+
+	return message.NewSessionModificationRequest(
+		0,
+		0,
+		remoteSEID,
+		sequenceNumber,
+		0,
+		ie.NewFSEID(localSEID, fseidIPv4Address, nil),
+		ie.NewCreatePDR(
+			ie.NewPDRID(pdrID),
+			ie.NewPrecedence(reachabilityProbePrecedence),
+			ie.NewPDI(
+				ie.NewSourceInterface(ie.SrcInterfaceAccess),
+				ie.NewUEIPAddress(uint8(*ueIPAddressflags), ueIP.String(), "", 0, 0),
+				ie.NewSDFFilter(fmt.Sprintf("permit out icmp from %s to %s", responderIP, ueIP), "", "", "", 0),
+			),
+			ie.NewFARID(farID),
+			ie.NewURRID(urrID),
+		),
+		ie.NewCreateFAR(
+			ie.NewFARID(farID),
+			ie.NewApplyAction(uint8(*applyActionflag)),
+			ie.NewForwardingParameters(
+				ie.NewDestinationInterface(ie.DstInterfaceCore),
+				ie.NewRedirectInformation(ie.RedirectAddrIPv4, responderIP.String()),
+			),
+		),
+		ie.NewCreateURR(
+			ie.NewURRID(urrID),
+			ie.NewMeasurementMethod(0, 1, 0),
+			ie.NewReportingTriggers(0, 0),
+			ie.NewMeasurementInformation(uint8(*measurementInfoflag)),
+		),
+	)
+}
+
+// BuildPfcpReachabilityProbeRemovalRequest removes the rules of the UE reachability probe,
+// the UPF reports the final usage of the removed URR in the response
+func BuildPfcpReachabilityProbeRemovalRequest(
+	sequenceNumber uint32,
+	localSEID uint64,
+	remoteSEID uint64,
+	fseidIPv4Address net.IP,
+	pdrID uint16,
+	farID uint32,
+	urrID uint32,
+) *message.SessionModificationRequest {
+	return message.NewSessionModificationRequest(
+		0,
+		0,
+		remoteSEID,
+		sequenceNumber,
+		0,
+		ie.NewFSEID(localSEID, fseidIPv4Address, nil),
+		ie.NewRemovePDR(ie.NewPDRID(pdrID)),
+		ie.NewRemoveFAR(ie.NewFARID(farID)),
+		ie.NewRemoveURR(ie.NewURRID(urrID)),
+	)
+}
+
 func BuildPfcpSessionDeletionRequest(
 	sequenceNumber uint32,
 	localSEID uint64,
@@ -394,6 +866,27 @@ func BuildPfcpSessionDeletionRequest(
 	)
 }
 
+// BuildPfcpSessionReportFetchRequest builds a Session Modification Request changing no rule and
+// querying all the URRs, answered with Request accepted only when the UPF still holds the session
+func BuildPfcpSessionReportFetchRequest(
+	sequenceNumber uint32,
+	localSEID uint64,
+	remoteSEID uint64,
+	fseidIPv4Address net.IP,
+) *message.SessionModificationRequest {
+	flag := new(Flag)
+	flag.setBit(3, true) // QAURR
+	return message.NewSessionModificationRequest(
+		0,
+		0,
+		remoteSEID,
+		sequenceNumber,
+		12,
+		ie.NewFSEID(localSEID, fseidIPv4Address, nil),
+		ie.NewPFCPSMReqFlags(uint8(*flag)),
+	)
+}
+
 func BuildPfcpSessionReportResponse(cause uint8, drobu bool, seqFromUPF uint32, seid uint64) *message.SessionReportResponse {
 	flag := new(Flag)
 	if drobu {
@@ -8,7 +8,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/pfcp/ies"
 	"github.com/omec-project/smf/pfcp/message"
 	"github.com/omec-project/util/util_3gpp"
 	"github.com/wmnsk/go-pfcp/ie"
@@ -217,6 +220,38 @@ func TestBuildPfcpAssociationSetupResponse(t *testing.T) {
 	}
 }
 
+func TestBuildPfcpAssociationReleaseRequest(t *testing.T) {
+	msg := message.BuildPfcpAssociationReleaseRequest(7, cpNodeID)
+
+	if msg.MessageTypeName() != "Association Release Request" {
+		t.Errorf("expected message type to be 'Association Release Request', got %v", msg.MessageTypeName())
+	}
+
+	buf := make([]byte, msg.MarshalLen())
+	err := msg.MarshalTo(buf)
+	if err != nil {
+		t.Fatalf("error marshalling PFCP association release request: %v", err)
+	}
+
+	req, err := pfcp_message.ParseAssociationReleaseRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP association release request: %v", err)
+	}
+
+	if req.SequenceNumber != 7 {
+		t.Errorf("expected SequenceNumber to be 7, got %v", req.SequenceNumber)
+	}
+
+	nodeID, err := req.NodeID.NodeID()
+	if err != nil {
+		t.Fatalf("error getting NodeID from PFCP association release request: %v", err)
+	}
+
+	if nodeID != cpNodeID {
+		t.Errorf("expected NodeID to be %v got %v", cpNodeID, nodeID)
+	}
+}
+
 func TestBuildPfcpAssociationReleaseResponse(t *testing.T) {
 	msg := message.BuildPfcpAssociationReleaseResponse(ie.CauseRequestAccepted, cpNodeID)
 
@@ -275,7 +310,7 @@ func TestBuildPfcpSessionEstablishmentRequest(t *testing.T) {
 	}
 	farList := []*context.FAR{}
 	qerList := []*context.QER{}
-	msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, pdrList, farList, qerList)
+	msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, pdrList, farList, qerList, message.SessionEstablishmentOptions{})
 	if err != nil {
 		t.Fatalf("error building PFCP session establishment request: %v", err)
 	}
@@ -391,6 +426,278 @@ func TestBuildPfcpSessionModificationRequest(t *testing.T) {
 	}
 }
 
+func TestBuildPfcpSessionModificationRequestHeaderEnrichment(t *testing.T) {
+	farList := []*context.FAR{
+		{
+			ForwardingParameters: &context.ForwardingParameters{
+				DestinationInterface: context.DestinationInterface{InterfaceValue: context.DestinationInterfaceSgiLanN6Lan},
+				HeaderEnrichment: []context.HeaderEnrichment{
+					{HeaderType: context.HeaderTypeHTTP, Name: "X-MSISDN", Value: "33612345678"},
+					{HeaderType: context.HeaderTypeHTTP, Name: "X-IMSI", Value: "imsi-208930000000001"},
+				},
+			},
+			State:       context.RULE_UPDATE,
+			FARID:       1,
+			ApplyAction: context.ApplyAction{Forw: true},
+		},
+	}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(64, 1, 2, net.ParseIP("2.3.4.5"), nil, farList, nil)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session modification request: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session modification request: %v", err)
+	}
+	if len(req.UpdateFAR) != 1 {
+		t.Fatalf("expected 1 UpdateFAR, got %d", len(req.UpdateFAR))
+	}
+
+	forwardingParameters, err := req.UpdateFAR[0].UpdateForwardingParameters()
+	if err != nil {
+		t.Fatalf("error parsing UpdateForwardingParameters: %v", err)
+	}
+	var headers []*ie.HeaderEnrichmentFields
+	for _, i := range forwardingParameters {
+		if i.Type != ie.HeaderEnrichment {
+			continue
+		}
+		header, err := i.HeaderEnrichment()
+		if err != nil {
+			t.Fatalf("error parsing HeaderEnrichment: %v", err)
+		}
+		headers = append(headers, header)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("expected 2 HeaderEnrichment IEs, got %d", len(headers))
+	}
+	if headers[0].HeaderType != ie.HeaderTypeHTTP || headers[0].HeaderFieldName != "X-MSISDN" ||
+		headers[0].HeaderFieldValue != "33612345678" {
+		t.Errorf("unexpected first HeaderEnrichment %+v", headers[0])
+	}
+	if headers[1].HeaderFieldName != "X-IMSI" || headers[1].HeaderFieldValue != "imsi-208930000000001" {
+		t.Errorf("unexpected second HeaderEnrichment %+v", headers[1])
+	}
+}
+
+func TestBuildPfcpSessionModificationRequestSRv6Steering(t *testing.T) {
+	segments := []net.IP{net.ParseIP("fc00:1::1"), net.ParseIP("fc00:2::1")}
+	farList := []*context.FAR{
+		{
+			ForwardingParameters: &context.ForwardingParameters{
+				DestinationInterface: context.DestinationInterface{InterfaceValue: context.DestinationInterfaceSgiLanN6Lan},
+				SRv6SegmentList:      segments,
+			},
+			State:       context.RULE_UPDATE,
+			FARID:       1,
+			ApplyAction: context.ApplyAction{Forw: true},
+		},
+	}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(64, 1, 2, net.ParseIP("2.3.4.5"), nil, farList, nil)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session modification request: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session modification request: %v", err)
+	}
+	if len(req.UpdateFAR) != 1 {
+		t.Fatalf("expected 1 UpdateFAR, got %d", len(req.UpdateFAR))
+	}
+
+	forwardingParameters, err := req.UpdateFAR[0].UpdateForwardingParameters()
+	if err != nil {
+		t.Fatalf("error parsing UpdateForwardingParameters: %v", err)
+	}
+	var steered []net.IP
+	for _, i := range forwardingParameters {
+		if i.Type != ies.SRv6SteeringIEType {
+			continue
+		}
+		if steered, err = ies.ParseSRv6Steering(i); err != nil {
+			t.Fatalf("error parsing SRv6 Steering: %v", err)
+		}
+	}
+	if len(steered) != 2 || !steered[0].Equal(segments[0]) || !steered[1].Equal(segments[1]) {
+		t.Errorf("expected SRv6 segments %v, got %v", segments, steered)
+	}
+}
+
+func TestBuildPfcpSessionModificationRequestRedirectInformation(t *testing.T) {
+	farList := []*context.FAR{
+		{
+			ForwardingParameters: &context.ForwardingParameters{
+				DestinationInterface: context.DestinationInterface{InterfaceValue: context.DestinationInterfaceSgiLanN6Lan},
+				RedirectInformation: &context.RedirectInformation{
+					RedirectAddressType:   ie.RedirectAddrURL,
+					RedirectServerAddress: "http://portal.example.com/topup",
+				},
+			},
+			State:       context.RULE_INITIAL,
+			FARID:       1,
+			ApplyAction: context.ApplyAction{Forw: true},
+		},
+		{
+			ForwardingParameters: &context.ForwardingParameters{
+				DestinationInterface: context.DestinationInterface{InterfaceValue: context.DestinationInterfaceSgiLanN6Lan},
+				RedirectInformation: &context.RedirectInformation{
+					RedirectAddressType:   ie.RedirectAddrIPv4,
+					RedirectServerAddress: "10.10.10.10",
+				},
+			},
+			State:       context.RULE_UPDATE,
+			FARID:       2,
+			ApplyAction: context.ApplyAction{Forw: true},
+		},
+	}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(64, 1, 2, net.ParseIP("2.3.4.5"), nil, farList, nil)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session modification request: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session modification request: %v", err)
+	}
+	if len(req.CreateFAR) != 1 || len(req.UpdateFAR) != 1 {
+		t.Fatalf("expected 1 CreateFAR and 1 UpdateFAR, got %d and %d", len(req.CreateFAR), len(req.UpdateFAR))
+	}
+
+	forwardingParameters, err := req.CreateFAR[0].ForwardingParameters()
+	if err != nil {
+		t.Fatalf("error parsing ForwardingParameters: %v", err)
+	}
+	redirect, err := ie.NewForwardingParameters(forwardingParameters...).RedirectInformation()
+	if err != nil {
+		t.Fatalf("error parsing Redirect Information of CreateFAR: %v", err)
+	}
+	if redirect.RedirectAddressType != ie.RedirectAddrURL || redirect.RedirectServerAddress != "http://portal.example.com/topup" {
+		t.Errorf("expected URL redirection to the portal, got %+v", redirect)
+	}
+
+	updateForwardingParameters, err := req.UpdateFAR[0].UpdateForwardingParameters()
+	if err != nil {
+		t.Fatalf("error parsing UpdateForwardingParameters: %v", err)
+	}
+	redirect, err = ie.NewUpdateForwardingParameters(updateForwardingParameters...).RedirectInformation()
+	if err != nil {
+		t.Fatalf("error parsing Redirect Information of UpdateFAR: %v", err)
+	}
+	if redirect.RedirectAddressType != ie.RedirectAddrIPv4 || redirect.RedirectServerAddress != "10.10.10.10" {
+		t.Errorf("expected IPv4 redirection to 10.10.10.10, got %+v", redirect)
+	}
+}
+
+func TestBuildPfcpSessionModificationRequestTGPPInterfaceType(t *testing.T) {
+	interfaceType := context.TGPPInterfaceTypeN3TrustedNon3GPPAccess
+	pdrList := []*context.PDR{
+		{
+			PDRID: 1,
+			FAR:   &context.FAR{FARID: 1},
+			PDI: context.PDI{
+				SourceInterface:   context.SourceInterface{InterfaceValue: context.SourceInterfaceAccess},
+				TGPPInterfaceType: &interfaceType,
+			},
+		},
+	}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(64, 1, 2, net.ParseIP("2.3.4.5"), pdrList, nil, nil)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session modification request: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session modification request: %v", err)
+	}
+	if len(req.CreatePDR) != 1 {
+		t.Fatalf("expected 1 CreatePDR, got %d", len(req.CreatePDR))
+	}
+	pdi, err := req.CreatePDR[0].PDI()
+	if err != nil {
+		t.Fatalf("error parsing PDI: %v", err)
+	}
+	found := false
+	for _, i := range pdi {
+		if i.Type != ie.TGPPInterfaceType {
+			continue
+		}
+		found = true
+		if value, err := i.TGPPInterfaceType(); err != nil || value != interfaceType {
+			t.Errorf("expected 3GPP interface type %d, got %d: %v", interfaceType, value, err)
+		}
+	}
+	if !found {
+		t.Errorf("expected a 3GPP interface type in the PDI")
+	}
+}
+
+func TestBuildPfcpSessionModificationRequestFramedRoutes(t *testing.T) {
+	routes := []string{"192.168.10.0/24 0.0.0.0 1", "192.168.20.0/24"}
+	pdrList := []*context.PDR{
+		{
+			PDRID: 2,
+			FAR:   &context.FAR{FARID: 2},
+			PDI: context.PDI{
+				SourceInterface: context.SourceInterface{InterfaceValue: context.SourceInterfaceCore},
+				UEIPAddress:     &context.UEIPAddress{V4: true, Ipv4Address: net.ParseIP("10.60.0.1").To4()},
+				FramedRoutes:    routes,
+			},
+		},
+	}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(64, 1, 2, net.ParseIP("2.3.4.5"), pdrList, nil, nil)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session modification request: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session modification request: %v", err)
+	}
+	if len(req.CreatePDR) != 1 {
+		t.Fatalf("expected 1 CreatePDR, got %d", len(req.CreatePDR))
+	}
+	pdi, err := req.CreatePDR[0].PDI()
+	if err != nil {
+		t.Fatalf("error parsing PDI: %v", err)
+	}
+	var framedRoutes []string
+	for _, i := range pdi {
+		if i.Type != ie.FramedRoute {
+			continue
+		}
+		route, err := i.FramedRoute()
+		if err != nil {
+			t.Fatalf("error parsing Framed-Route: %v", err)
+		}
+		framedRoutes = append(framedRoutes, route)
+	}
+	if len(framedRoutes) != 2 || framedRoutes[0] != routes[0] || framedRoutes[1] != routes[1] {
+		t.Errorf("expected framed routes %v, got %v", routes, framedRoutes)
+	}
+}
+
 func TestBuildPfcpSessionModificationRequestNoOuterHeader(t *testing.T) {
 	pdrList := []*context.PDR{
 		{
@@ -455,6 +762,110 @@ func TestBuildPfcpSessionModificationRequestNoOuterHeader(t *testing.T) {
 	}
 }
 
+func TestBuildPfcpSessionModificationRequestBuffering(t *testing.T) {
+	bar := &context.BAR{
+		BARID:                          3,
+		SuggestedBufferingPacketsCount: context.SuggestedBufferingPacketsCount{PacketCountValue: 10},
+		State:                          context.RULE_INITIAL,
+	}
+	farList := []*context.FAR{
+		{
+			State:       context.RULE_UPDATE,
+			FARID:       1,
+			ApplyAction: context.ApplyAction{Buff: true, Nocp: true},
+			BAR:         bar,
+		},
+	}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(64, 1, 2, net.ParseIP("2.3.4.5"), nil, farList, nil)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session modification request: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session modification request: %v", err)
+	}
+
+	if req.CreateBAR == nil {
+		t.Fatalf("expected CreateBAR to be set")
+	}
+	if barID, err := req.CreateBAR.BARID(); err != nil || barID != 3 {
+		t.Errorf("expected CreateBAR BARID 3, got %v (%v)", barID, err)
+	}
+	if count, err := req.CreateBAR.SuggestedBufferingPacketsCount(); err != nil || count != 10 {
+		t.Errorf("expected SuggestedBufferingPacketsCount 10, got %v (%v)", count, err)
+	}
+	if len(req.UpdateFAR) != 1 {
+		t.Fatalf("expected 1 UpdateFAR, got %d", len(req.UpdateFAR))
+	}
+	if barID, err := req.UpdateFAR[0].BARID(); err != nil || barID != 3 {
+		t.Errorf("expected UpdateFAR BARID 3, got %v (%v)", barID, err)
+	}
+	applyAction, err := req.UpdateFAR[0].ApplyAction()
+	if err != nil {
+		t.Fatalf("error parsing ApplyAction: %v", err)
+	}
+	if applyAction[0] != 0x0c {
+		t.Errorf("expected ApplyAction BUFF and NOCP, got %#x", applyAction[0])
+	}
+	if bar.State != context.RULE_CREATE {
+		t.Errorf("expected BAR state RULE_CREATE, got %v", bar.State)
+	}
+
+	// the BAR is created once
+	farList[0].State = context.RULE_UPDATE
+	msg, err = message.BuildPfcpSessionModificationRequest(65, 1, 2, net.ParseIP("2.3.4.5"), nil, farList, nil)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	if msg.CreateBAR != nil {
+		t.Errorf("expected no CreateBAR for an existing BAR")
+	}
+}
+
+func TestBuildPfcpSessionModificationRequestUpdateQER(t *testing.T) {
+	qerList := []*context.QER{
+		{
+			QERID:      4,
+			State:      context.RULE_UPDATE,
+			GateStatus: &context.GateStatus{ULGate: context.GateClose, DLGate: context.GateClose},
+		},
+	}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(64, 1, 2, net.ParseIP("2.3.4.5"), nil, nil, qerList)
+	if err != nil {
+		t.Fatalf("error building PFCP session modification request: %v", err)
+	}
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session modification request: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session modification request: %v", err)
+	}
+
+	if len(req.CreateQER) != 0 {
+		t.Errorf("expected no CreateQER, got %d", len(req.CreateQER))
+	}
+	if len(req.UpdateQER) != 1 {
+		t.Fatalf("expected 1 UpdateQER, got %d", len(req.UpdateQER))
+	}
+	if qerID, err := req.UpdateQER[0].QERID(); err != nil || qerID != 4 {
+		t.Errorf("expected UpdateQER QERID 4, got %v (%v)", qerID, err)
+	}
+	if gate, err := req.UpdateQER[0].GateStatus(); err != nil || gate != 0x05 {
+		t.Errorf("expected UpdateQER gates closed, got %#x (%v)", gate, err)
+	}
+	if qerList[0].State != context.RULE_CREATE {
+		t.Errorf("expected QER state RULE_CREATE, got %v", qerList[0].State)
+	}
+}
+
 func TestBuildPfcpSessionDeletionRequest(t *testing.T) {
 	msg := message.BuildPfcpSessionDeletionRequest(12, 2, 3, net.ParseIP("2.2.2.2"))
 
@@ -515,3 +926,711 @@ func TestBuildPfcpSessionReportResponse(t *testing.T) {
 		t.Errorf("expected PFCPSRRspFlags to be 1, got %v", flags)
 	}
 }
+
+func TestBuildPfcpSessionReportFetchRequest(t *testing.T) {
+	msg := message.BuildPfcpSessionReportFetchRequest(1, 11, 22, net.ParseIP("192.168.1.1"))
+
+	if msg.SEID() != 22 {
+		t.Errorf("expected SEID to be 22, got %v", msg.SEID())
+	}
+
+	if len(msg.CreatePDR) != 0 || len(msg.UpdatePDR) != 0 || len(msg.UpdateFAR) != 0 {
+		t.Errorf("expected no rule in PFCP session report fetch request")
+	}
+
+	flags, err := msg.PFCPSMReqFlags.PFCPSMReqFlags()
+	if err != nil {
+		t.Fatalf("error getting PFCPSMReqFlags from PFCP session report fetch request: %v", err)
+	}
+
+	if flags != 0x04 {
+		t.Errorf("expected PFCPSMReqFlags to be QAURR, got %v", flags)
+	}
+}
+
+func TestMapAppIDToPDR(t *testing.T) {
+	if appIDIE := message.MapAppIDToPDR(""); appIDIE != nil {
+		t.Errorf("expected no Application ID IE for empty application id, got %v", appIDIE)
+	}
+
+	appIDIE := message.MapAppIDToPDR(" video-streaming ")
+	if appIDIE == nil {
+		t.Fatalf("expected Application ID IE to be non-nil")
+	}
+
+	if appIDIE.Type != ie.ApplicationID {
+		t.Errorf("expected IE type to be %v, got %v", ie.ApplicationID, appIDIE.Type)
+	}
+
+	appID, err := appIDIE.ApplicationID()
+	if err != nil {
+		t.Fatalf("error getting Application ID: %v", err)
+	}
+
+	if appID != "video-streaming" {
+		t.Errorf("expected Application ID to be 'video-streaming', got %v", appID)
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestApplicationID(t *testing.T) {
+	pdrList := []*context.PDR{
+		{
+			PDRID:      1,
+			Precedence: 123,
+			FAR:        &context.FAR{},
+			PDI: context.PDI{
+				SDFFilter:     &context.SDFFilter{},
+				ApplicationID: "video-streaming",
+			},
+		},
+	}
+	msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, pdrList, nil, nil, message.SessionEstablishmentOptions{})
+	if err != nil {
+		t.Fatalf("error building PFCP session establishment request: %v", err)
+	}
+
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+	}
+
+	req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session establishment request: %v", err)
+	}
+
+	if len(req.CreatePDR) != 1 {
+		t.Fatalf("expected 1 CreatePDR, got %v", len(req.CreatePDR))
+	}
+
+	appID, err := req.CreatePDR[0].ApplicationID()
+	if err != nil {
+		t.Fatalf("error getting Application ID from CreatePDR: %v", err)
+	}
+
+	if appID != "video-streaming" {
+		t.Errorf("expected Application ID to be 'video-streaming', got %v", appID)
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestTraceInformation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		traceData   *models.TraceData
+		expectTrace bool
+	}{
+		{
+			name: "trace data present",
+			traceData: &models.TraceData{
+				TraceRef:                 "20893-abcdef",
+				TraceDepth:               models.TraceDepth_MEDIUM,
+				NeTypeList:               "04",
+				EventList:                "0f",
+				CollectionEntityIpv4Addr: "10.0.0.100",
+			},
+			expectTrace: true,
+		},
+		{
+			name:        "trace data absent",
+			traceData:   nil,
+			expectTrace: false,
+		},
+		{
+			name: "no trace collection entity",
+			traceData: &models.TraceData{
+				TraceRef:   "20893-abcdef",
+				TraceDepth: models.TraceDepth_MEDIUM,
+				EventList:  "0f",
+			},
+			expectTrace: false,
+		},
+		{
+			name: "invalid trace reference",
+			traceData: &models.TraceData{
+				TraceRef:   "invalid",
+				TraceDepth: models.TraceDepth_MEDIUM,
+			},
+			expectTrace: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, nil, nil, nil, message.SessionEstablishmentOptions{TraceData: tc.traceData})
+			if err != nil {
+				t.Fatalf("error building PFCP session establishment request: %v", err)
+			}
+
+			buf := make([]byte, msg.MarshalLen())
+			if err = msg.MarshalTo(buf); err != nil {
+				t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+			}
+
+			req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+			if err != nil {
+				t.Fatalf("error parsing PFCP session establishment request: %v", err)
+			}
+
+			if !tc.expectTrace {
+				if req.TraceInformation != nil {
+					t.Errorf("expected TraceInformation to be nil, got %v", req.TraceInformation)
+				}
+				return
+			}
+
+			if req.TraceInformation == nil {
+				t.Fatalf("expected TraceInformation to be non-nil")
+			}
+
+			traceInfo, err := req.TraceInformation.TraceInformation()
+			if err != nil {
+				t.Fatalf("error getting TraceInformation: %v", err)
+			}
+
+			if traceInfo.MCC != "208" || traceInfo.MNC != "93" {
+				t.Errorf("expected PLMN to be 208/93, got %v/%v", traceInfo.MCC, traceInfo.MNC)
+			}
+
+			if traceInfo.TraceID != string([]byte{0xab, 0xcd, 0xef}) {
+				t.Errorf("expected TraceID to be abcdef, got %x", traceInfo.TraceID)
+			}
+
+			if traceInfo.SessionTraceDepth != 1 {
+				t.Errorf("expected SessionTraceDepth to be 1, got %v", traceInfo.SessionTraceDepth)
+			}
+
+			if traceInfo.TriggeringEventsLength != 1 {
+				t.Errorf("expected TriggeringEventsLength to be 1, got %v", traceInfo.TriggeringEventsLength)
+			}
+		})
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestCreateBridgeInfo(t *testing.T) {
+	for _, createBridgeInfo := range []bool{true, false} {
+		msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, nil, nil, nil, message.SessionEstablishmentOptions{CreateBridgeInfo: createBridgeInfo})
+		if err != nil {
+			t.Fatalf("error building PFCP session establishment request: %v", err)
+		}
+
+		buf := make([]byte, msg.MarshalLen())
+		if err = msg.MarshalTo(buf); err != nil {
+			t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+		}
+
+		req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+		if err != nil {
+			t.Fatalf("error parsing PFCP session establishment request: %v", err)
+		}
+
+		if !createBridgeInfo {
+			if req.CreateBridgeInfoForTSC != nil {
+				t.Errorf("expected CreateBridgeInfoForTSC to be nil, got %v", req.CreateBridgeInfoForTSC)
+			}
+			continue
+		}
+
+		if req.CreateBridgeInfoForTSC == nil {
+			t.Fatalf("expected CreateBridgeInfoForTSC to be non-nil")
+		}
+		bii, err := req.CreateBridgeInfoForTSC.CreateBridgeInfoForTSC()
+		if err != nil {
+			t.Fatalf("error getting CreateBridgeInfoForTSC: %v", err)
+		}
+		if bii != 1 {
+			t.Errorf("expected BII flag set, got %v", bii)
+		}
+	}
+}
+
+func TestBuildTSCManagementInformation(t *testing.T) {
+	portManagementInfo := []byte{0x01, 0x00, 0x03, 0x00, 0x80, 0x00}
+
+	tscInfo := message.BuildTSCManagementInformation(portManagementInfo, 7)
+
+	pmic, err := tscInfo.PortManagementInformationContainer()
+	if err != nil {
+		t.Fatalf("error getting PortManagementInformationContainer: %v", err)
+	}
+	if pmic != string(portManagementInfo) {
+		t.Errorf("expected port management information %x, got %x", portManagementInfo, pmic)
+	}
+
+	var port uint32
+	for _, i := range tscInfo.ChildIEs {
+		if i.Type == ie.NWTTPortNumber {
+			if port, err = i.NWTTPortNumber(); err != nil {
+				t.Fatalf("error getting NWTTPortNumber: %v", err)
+			}
+		}
+	}
+	if port != 7 {
+		t.Errorf("expected NW-TT port number 7, got %v", port)
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestATSSS(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		mode                  context.ATSSSMode
+		expectedTGPPWeight    uint8
+		expectedNonTGPPWeight uint8
+		expectedTGPPPrio      uint8
+		expectedNonTGPPPrio   uint8
+		prioritySet           bool
+	}{
+		{
+			name:                "active-standby",
+			mode:                context.ATSSSModeActiveStandby,
+			expectedTGPPPrio:    ie.PriorityActive,
+			expectedNonTGPPPrio: ie.PriorityStandby,
+			prioritySet:         true,
+		},
+		{
+			name: "smallest delay",
+			mode: context.ATSSSModeSmallestDelay,
+		},
+		{
+			name:                  "load balancing",
+			mode:                  context.ATSSSModeLoadBalancing,
+			expectedTGPPWeight:    70,
+			expectedNonTGPPWeight: 30,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tgppFAR := &context.FAR{FARID: 1}
+			nonTgppFAR := &context.FAR{FARID: 2}
+			pdrList := []*context.PDR{
+				{
+					PDRID:      1,
+					Precedence: 255,
+					FAR:        tgppFAR,
+					MAR: &context.MAR{
+						MARID:                 3,
+						SteeringFunctionality: context.SteeringFunctionalityATSSSLL,
+						SteeringMode:          tc.mode,
+						TGPPAccessFAR:         tgppFAR,
+						NonTGPPAccessFAR:      nonTgppFAR,
+						TGPPAccessWeight:      70,
+					},
+				},
+			}
+			msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, pdrList, nil, nil, message.SessionEstablishmentOptions{})
+			if err != nil {
+				t.Fatalf("error building PFCP session establishment request: %v", err)
+			}
+
+			buf := make([]byte, msg.MarshalLen())
+			if err = msg.MarshalTo(buf); err != nil {
+				t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+			}
+
+			req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+			if err != nil {
+				t.Fatalf("error parsing PFCP session establishment request: %v", err)
+			}
+
+			if len(req.CreatePDR) != 1 {
+				t.Fatalf("expected 1 CreatePDR, got %d", len(req.CreatePDR))
+			}
+			pdrMARID, err := req.CreatePDR[0].MARID()
+			if err != nil {
+				t.Fatalf("error getting MARID from CreatePDR: %v", err)
+			}
+			if pdrMARID != 3 {
+				t.Errorf("expected CreatePDR MARID to be 3, got %v", pdrMARID)
+			}
+
+			if len(req.CreateMAR) != 1 {
+				t.Fatalf("expected 1 CreateMAR, got %d", len(req.CreateMAR))
+			}
+			createMAR := req.CreateMAR[0]
+			marID, err := createMAR.MARID()
+			if err != nil {
+				t.Fatalf("error getting MARID: %v", err)
+			}
+			if marID != 3 {
+				t.Errorf("expected MARID to be 3, got %v", marID)
+			}
+			mode, err := createMAR.SteeringMode()
+			if err != nil {
+				t.Fatalf("error getting SteeringMode: %v", err)
+			}
+			if mode != uint8(tc.mode) {
+				t.Errorf("expected SteeringMode to be %v, got %v", tc.mode, mode)
+			}
+
+			for _, access := range []struct {
+				typ              uint16
+				farID            uint32
+				expectedWeight   uint8
+				expectedPriority uint8
+			}{
+				{ie.TGPPAccessForwardingActionInformation, 1, tc.expectedTGPPWeight, tc.expectedTGPPPrio},
+				{ie.NonTGPPAccessForwardingActionInformation, 2, tc.expectedNonTGPPWeight, tc.expectedNonTGPPPrio},
+			} {
+				var actionInfo *ie.IE
+				for _, i := range createMAR.ChildIEs {
+					if i.Type == access.typ {
+						actionInfo = i
+					}
+				}
+				if actionInfo == nil {
+					t.Fatalf("expected access forwarding action information %d to be non-nil", access.typ)
+				}
+
+				var farID uint32
+				var weight, priority uint8
+				var weightSet, prioritySet bool
+				for _, i := range actionInfo.ChildIEs {
+					switch i.Type {
+					case ie.FARID:
+						farID, _ = i.FARID()
+					case ie.Weight:
+						weight, _ = i.Weight()
+						weightSet = true
+					case ie.Priority:
+						priority, _ = i.Priority()
+						prioritySet = true
+					}
+				}
+				if farID != access.farID {
+					t.Errorf("expected FARID to be %v, got %v", access.farID, farID)
+				}
+				if weightSet != (tc.mode == context.ATSSSModeLoadBalancing) || weight != access.expectedWeight {
+					t.Errorf("expected Weight to be %v, got %v (set %v)", access.expectedWeight, weight, weightSet)
+				}
+				if prioritySet != tc.prioritySet || priority != access.expectedPriority {
+					t.Errorf("expected Priority to be %v, got %v (set %v)", access.expectedPriority, priority, prioritySet)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestDowngradedPDNType(t *testing.T) {
+	smContext := context.NewSMContext("imsi-208930000000202", 1)
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv6
+	if pdnType := message.SessionPDNType(smContext); pdnType != ie.PDNTypeIPv6 {
+		t.Errorf("expected PDN type %d, got %d", ie.PDNTypeIPv6, pdnType)
+	}
+
+	// IPv6 session downgraded to the IPv4 UE pool of the DNN
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, nil, nil, nil,
+		message.SessionEstablishmentOptions{PDNType: message.SessionPDNType(smContext)})
+	if err != nil {
+		t.Fatalf("error building PFCP session establishment request: %v", err)
+	}
+
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+	}
+
+	req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session establishment request: %v", err)
+	}
+	if req.PDNType == nil {
+		t.Fatalf("expected PDNType to be non-nil")
+	}
+	pdnType, err := req.PDNType.PDNType()
+	if err != nil {
+		t.Fatalf("error getting PDNType: %v", err)
+	}
+	if pdnType != ie.PDNTypeIPv4 {
+		t.Errorf("expected PDN type %d, got %d", ie.PDNTypeIPv4, pdnType)
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestQoSMonitoring(t *testing.T) {
+	srr := &context.SRR{
+		SRRID: context.QoSMonitoringSRRID,
+		QFIs:  []uint8{1, 5},
+		QoSMonitoring: &context.QoSMonitoring{
+			Uplink:            true,
+			Downlink:          true,
+			EventTriggered:    true,
+			Periodic:          true,
+			MeasurementPeriod: 10 * time.Second,
+			UplinkThreshold:   20,
+			DownlinkThreshold: 30,
+			MinimumWaitTime:   5 * time.Second,
+		},
+	}
+	msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, nil, nil, nil, message.SessionEstablishmentOptions{SRR: srr})
+	if err != nil {
+		t.Fatalf("error building PFCP session establishment request: %v", err)
+	}
+
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+	}
+
+	req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session establishment request: %v", err)
+	}
+	if len(req.CreateSRR) != 1 {
+		t.Fatalf("expected 1 CreateSRR, got %d", len(req.CreateSRR))
+	}
+	createSRR, err := req.CreateSRR[0].CreateSRR()
+	if err != nil {
+		t.Fatalf("error getting CreateSRR: %v", err)
+	}
+
+	var qfis []uint8
+	for _, i := range createSRR {
+		switch i.Type {
+		case ie.SRRID:
+			srrID, err := i.SRRID()
+			if err != nil || srrID != context.QoSMonitoringSRRID {
+				t.Errorf("expected SRRID %d, got %d (%v)", context.QoSMonitoringSRRID, srrID, err)
+			}
+		case ie.QoSMonitoringPerQoSFlowControlInformation:
+			controlInfo, err := i.QoSMonitoringPerQoSFlowControlInformation()
+			if err != nil {
+				t.Fatalf("error getting QoS Monitoring per QoS flow Control Information: %v", err)
+			}
+			for _, x := range controlInfo {
+				switch x.Type {
+				case ie.QFI:
+					qfi, _ := x.QFI()
+					qfis = append(qfis, qfi)
+				case ie.RequestedQoSMonitoring:
+					if !x.HasUL() || !x.HasDL() || x.HasRP() {
+						t.Errorf("expected uplink and downlink monitoring, got %x", x.Payload)
+					}
+				case ie.ReportingFrequency:
+					// EVETT and PERIO
+					if frequency, _ := x.ReportingFrequency(); frequency != 0x03 {
+						t.Errorf("expected event-triggered and periodic reporting, got %x", frequency)
+					}
+				case ie.PacketDelayThresholds:
+					thresholds, err := x.PacketDelayThresholds()
+					if err != nil {
+						t.Fatalf("error getting Packet Delay Thresholds: %v", err)
+					}
+					if !thresholds.HasUL() || !thresholds.HasDL() || thresholds.HasRP() ||
+						thresholds.UplinkPacketDelayThresholds != 20 || thresholds.DownlinkPacketDelayThresholds != 30 {
+						t.Errorf("expected uplink 20ms and downlink 30ms thresholds, got %+v", thresholds)
+					}
+				case ie.MinimumWaitTime:
+					if minimumWaitTime, _ := x.MinimumWaitTime(); minimumWaitTime != 5*time.Second {
+						t.Errorf("expected minimum wait time 5s, got %v", minimumWaitTime)
+					}
+				case ie.MeasurementPeriod:
+					if period, _ := x.MeasurementPeriod(); period != 10*time.Second {
+						t.Errorf("expected measurement period 10s, got %v", period)
+					}
+				}
+			}
+		}
+	}
+	if len(qfis) != 2 || qfis[0] != 1 || qfis[1] != 5 {
+		t.Errorf("expected QoS flows [1 5] monitored, got %v", qfis)
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestPeriodicUsageReporting(t *testing.T) {
+	urr := &context.URR{
+		URRID:             7,
+		MeasurementMethod: context.MeasurementMethod{Volum: true, Durat: true},
+		MeasurementPeriod: 5 * time.Minute,
+	}
+	pdrList := []*context.PDR{
+		{PDRID: 1, Precedence: 255, FAR: &context.FAR{FARID: 1}, PDI: context.PDI{SDFFilter: &context.SDFFilter{}}, URR: urr},
+		{PDRID: 2, Precedence: 255, FAR: &context.FAR{FARID: 2}, PDI: context.PDI{SDFFilter: &context.SDFFilter{}}, URR: urr},
+	}
+	msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, pdrList, nil, nil, message.SessionEstablishmentOptions{})
+	if err != nil {
+		t.Fatalf("error building PFCP session establishment request: %v", err)
+	}
+
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+	}
+
+	req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session establishment request: %v", err)
+	}
+
+	for _, createPDR := range req.CreatePDR {
+		if urrID, err := createPDR.URRID(); err != nil || urrID != 7 {
+			t.Errorf("expected URRID 7 in CreatePDR, got %v (%v)", urrID, err)
+		}
+	}
+
+	// the URR shared by the PDRs is created once
+	if len(req.CreateURR) != 1 {
+		t.Fatalf("expected 1 CreateURR, got %d", len(req.CreateURR))
+	}
+	if urrID, err := req.CreateURR[0].URRID(); err != nil || urrID != 7 {
+		t.Errorf("expected URRID 7, got %v (%v)", urrID, err)
+	}
+	// VOLUM and DURAT
+	if method, err := req.CreateURR[0].MeasurementMethod(); err != nil || method != 0x03 {
+		t.Errorf("expected volume and duration measurement, got %x (%v)", method, err)
+	}
+	if !req.CreateURR[0].HasPERIO() {
+		t.Errorf("expected periodic reporting trigger")
+	}
+	if period, err := req.CreateURR[0].MeasurementPeriod(); err != nil || period != 5*time.Minute {
+		t.Errorf("expected measurement period 5m, got %v (%v)", period, err)
+	}
+	if urr.State != context.RULE_CREATE {
+		t.Errorf("expected URR state %v, got %v", context.RULE_CREATE, urr.State)
+	}
+}
+
+func TestBuildPfcpSessionEstablishmentRequestUplinkDownlinkFARs(t *testing.T) {
+	upNodeID := context.NewNodeID("10.0.0.9")
+	upf := context.NewUPF(upNodeID, nil)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+
+	// anchor UPF serving the gNB, uplink and downlink PDRs with a FAR each
+	dpNode := context.NewDataPathNode()
+	dpNode.UPF = upf
+	ulPDR, err := upf.AddPDR()
+	if err != nil {
+		t.Fatalf("error adding uplink PDR: %v", err)
+	}
+	dlPDR, err := upf.AddPDR()
+	if err != nil {
+		t.Fatalf("error adding downlink PDR: %v", err)
+	}
+	dpNode.UpLinkTunnel.PDR["default"] = ulPDR
+	dpNode.DownLinkTunnel.PDR["default"] = dlPDR
+
+	smContext := &context.SMContext{
+		Dnn:        "internet",
+		PDUAddress: &context.UeIpAddr{Ip: net.ParseIP("10.60.0.1")},
+		Tunnel:     context.NewUPTunnel(),
+	}
+	smContext.Tunnel.ANInformation.IPAddress = net.ParseIP("10.1.0.2")
+	smContext.Tunnel.ANInformation.TEID = 100
+	qer := &context.QER{QERID: 1}
+	if err = dpNode.ActivateUpLinkPdr(smContext, qer, 255); err != nil {
+		t.Fatalf("error activating uplink PDR: %v", err)
+	}
+	if err = dpNode.ActivateDlLinkPdr(smContext, qer, 255, &context.DataPath{FirstDPNode: dpNode}); err != nil {
+		t.Fatalf("error activating downlink PDR: %v", err)
+	}
+
+	pdrList := []*context.PDR{ulPDR, dlPDR}
+	farList := []*context.FAR{ulPDR.FAR, dlPDR.FAR}
+	msg, err := message.BuildPfcpSessionEstablishmentRequest(43, cpNodeID, net.ParseIP(cpNodeID), 1, pdrList, farList, nil, message.SessionEstablishmentOptions{})
+	if err != nil {
+		t.Fatalf("error building PFCP session establishment request: %v", err)
+	}
+
+	buf := make([]byte, msg.MarshalLen())
+	if err = msg.MarshalTo(buf); err != nil {
+		t.Fatalf("error marshalling PFCP session establishment request: %v", err)
+	}
+
+	req, err := pfcp_message.ParseSessionEstablishmentRequest(buf)
+	if err != nil {
+		t.Fatalf("error parsing PFCP session establishment request: %v", err)
+	}
+
+	if len(req.CreatePDR) != 2 {
+		t.Fatalf("expected 2 CreatePDR, got %d", len(req.CreatePDR))
+	}
+	if len(req.CreateFAR) != 2 {
+		t.Fatalf("expected 2 CreateFAR, got %d", len(req.CreateFAR))
+	}
+
+	createFARs := make(map[uint32]*ie.IE)
+	for _, createFAR := range req.CreateFAR {
+		farIEs, err := createFAR.CreateFAR()
+		if err != nil {
+			t.Fatalf("error parsing CreateFAR: %v", err)
+		}
+		for _, farIE := range farIEs {
+			if farIE.Type == ie.FARID {
+				farID, _ := farIE.FARID()
+				createFARs[farID] = createFAR
+			}
+		}
+	}
+
+	for _, createPDR := range req.CreatePDR {
+		pdrID, err := createPDR.PDRID()
+		if err != nil {
+			t.Fatalf("error getting PDRID: %v", err)
+		}
+		farID, err := createPDR.FARID()
+		if err != nil {
+			t.Fatalf("error getting FARID of PDR %d: %v", pdrID, err)
+		}
+		createFAR, ok := createFARs[farID]
+		if !ok {
+			t.Fatalf("PDR %d references FAR %d not created", pdrID, farID)
+		}
+		sourceInterface, err := createPDR.SourceInterface()
+		if err != nil {
+			t.Fatalf("error getting SourceInterface of PDR %d: %v", pdrID, err)
+		}
+		destinationInterface := farDestinationInterface(t, createFAR)
+
+		switch pdrID {
+		case ulPDR.PDRID:
+			if farID != ulPDR.FAR.FARID {
+				t.Errorf("expected uplink PDR to reference FAR %d, got %d", ulPDR.FAR.FARID, farID)
+			}
+			if sourceInterface != ie.SrcInterfaceAccess {
+				t.Errorf("expected uplink PDR source interface access, got %d", sourceInterface)
+			}
+			// GTP-U decapsulated and forwarded to the data network
+			if description, err := createPDR.OuterHeaderRemovalDescription(); err != nil ||
+				description != context.OuterHeaderRemovalGtpUUdpIpv4 {
+				t.Errorf("expected uplink PDR GTP-U/UDP/IPv4 outer header removal, got %d (%v)", description, err)
+			}
+			if applyAction, err := createFAR.ApplyAction(); err != nil || applyAction[0] != 0x02 {
+				t.Errorf("expected uplink FAR action FORW, got %v (%v)", applyAction, err)
+			}
+			if destinationInterface != ie.DstInterfaceSGiLANN6LAN {
+				t.Errorf("expected uplink FAR destination interface N6, got %d", destinationInterface)
+			}
+		case dlPDR.PDRID:
+			if farID != dlPDR.FAR.FARID {
+				t.Errorf("expected downlink PDR to reference FAR %d, got %d", dlPDR.FAR.FARID, farID)
+			}
+			if sourceInterface != ie.SrcInterfaceCore {
+				t.Errorf("expected downlink PDR source interface core, got %d", sourceInterface)
+			}
+			if destinationInterface != ie.DstInterfaceAccess {
+				t.Errorf("expected downlink FAR destination interface access, got %d", destinationInterface)
+			}
+		default:
+			t.Errorf("unexpected PDR %d", pdrID)
+		}
+	}
+}
+
+func farDestinationInterface(t *testing.T, createFAR *ie.IE) uint8 {
+	t.Helper()
+	farIEs, err := createFAR.CreateFAR()
+	if err != nil {
+		t.Fatalf("error parsing CreateFAR: %v", err)
+	}
+	for _, farIE := range farIEs {
+		if farIE.Type == ie.ForwardingParameters {
+			destinationInterface, err := farIE.DestinationInterface()
+			if err != nil {
+				t.Fatalf("error getting DestinationInterface: %v", err)
+			}
+			return destinationInterface
+		}
+	}
+	t.Fatalf("expected ForwardingParameters in CreateFAR")
+	return 0
+}
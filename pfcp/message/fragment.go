@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package message
+
+import (
+	"slices"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+)
+
+// pfcpTransportOverhead is the size of the IPv6 and UDP headers carrying a PFCP message
+const pfcpTransportOverhead = 40 + 8
+
+// pfcpMaxMessageLen returns the largest PFCP message fitting in the N4 path MTU
+func pfcpMaxMessageLen() int {
+	mtu := context.SMF_Self().PFCPMtu
+	if mtu == 0 {
+		mtu = factory.DEFAULT_PFCP_MTU
+	}
+	return mtu - pfcpTransportOverhead
+}
+
+// SplitPfcpSessionRules splits the rules of a session establishment so that each PFCP message
+// carrying them fits in maxLen bytes. The first set goes in the Session Establishment Request,
+// whose other IEs take estLen bytes, and the others in Session Modification Requests, whose other
+// IEs take modLen bytes. The size of a set is the one of the IEs creating its rules, with their
+// MARs, URRs and BARs. FARs and QERs go in the set of the first PDR using them, the ones not used
+// by any PDR go in the first set. A PDR with its rules larger than maxLen gets a set of its own.
+func SplitPfcpSessionRules(
+	pdrList []*context.PDR,
+	farList []*context.FAR,
+	qerList []*context.QER,
+	estLen, modLen, maxLen int,
+) []*context.PFCPRules {
+	current := &context.PFCPRules{}
+	sets := []*context.PFCPRules{current}
+	otherLen := estLen
+
+	sentFARs := make(map[*context.FAR]bool)
+	sentQERs := make(map[*context.QER]bool)
+
+	usedFARs := make(map[*context.FAR]bool)
+	usedQERs := make(map[*context.QER]bool)
+	for _, pdr := range pdrList {
+		for _, far := range pdrFARs(pdr) {
+			usedFARs[far] = true
+		}
+		for _, qer := range pdr.QER {
+			usedQERs[qer] = true
+		}
+	}
+	for _, far := range farList {
+		if far != nil && !usedFARs[far] && !sentFARs[far] {
+			current.FARs = append(current.FARs, far)
+			sentFARs[far] = true
+		}
+	}
+	for _, qer := range qerList {
+		if qer != nil && !usedQERs[qer] && !sentQERs[qer] {
+			current.QERs = append(current.QERs, qer)
+			sentQERs[qer] = true
+		}
+	}
+
+	for _, pdr := range pdrList {
+		var fars []*context.FAR
+		for _, far := range pdrFARs(pdr) {
+			if !sentFARs[far] {
+				fars = append(fars, far)
+			}
+		}
+		var qers []*context.QER
+		for _, qer := range pdr.QER {
+			if qer != nil && !sentQERs[qer] {
+				qers = append(qers, qer)
+			}
+		}
+
+		next := &context.PFCPRules{
+			PDRs: append(slices.Clone(current.PDRs), pdr),
+			FARs: append(slices.Clone(current.FARs), fars...),
+			QERs: append(slices.Clone(current.QERs), qers...),
+		}
+		if otherLen+rulesLen(next) > maxLen && (len(current.PDRs) > 0 || len(current.FARs) > 0 || len(current.QERs) > 0) {
+			next = &context.PFCPRules{PDRs: []*context.PDR{pdr}, FARs: fars, QERs: qers}
+			current = next
+			sets = append(sets, current)
+			otherLen = modLen
+		}
+		*current = *next
+		for _, far := range fars {
+			sentFARs[far] = true
+		}
+		for _, qer := range qers {
+			sentQERs[qer] = true
+		}
+	}
+	return sets
+}
+
+// rulesLen returns the size of the IEs creating the rules in a PFCP message
+func rulesLen(rules *context.PFCPRules) int {
+	size := 0
+	for _, i := range createRuleIEs(rules.PDRs, rules.FARs, rules.QERs) {
+		size += i.MarshalLen()
+	}
+	return size
+}
+
+// pdrFARs returns the FARs used by the PDR, directly or through its MAR
+func pdrFARs(pdr *context.PDR) []*context.FAR {
+	var fars []*context.FAR
+	if pdr.FAR != nil {
+		fars = append(fars, pdr.FAR)
+	}
+	if pdr.MAR != nil {
+		for _, far := range []*context.FAR{pdr.MAR.TGPPAccessFAR, pdr.MAR.NonTGPPAccessFAR} {
+			if far != nil && far != pdr.FAR {
+				fars = append(fars, far)
+			}
+		}
+	}
+	return fars
+}
+
+// SendNextPfcpSessionRules sends the next rules of a split session establishment to the UPF in a
+// Session Modification Request. It returns false when no rules are pending.
+func SendNextPfcpSessionRules(upNodeID context.NodeID, ctx *context.SMContext, upfPort uint16) (bool, error) {
+	pfcpContext, ok := ctx.PFCPContext[upNodeID.ResolveNodeIdToIp().String()]
+	if !ok || len(pfcpContext.PendingRules) == 0 {
+		return false, nil
+	}
+	rules := pfcpContext.PendingRules[0]
+	pfcpContext.PendingRules = pfcpContext.PendingRules[1:]
+	ctx.SubPfcpLog.Infof("send %d PDRs of the split session establishment, %d messages left",
+		len(rules.PDRs), len(pfcpContext.PendingRules))
+	return true, SendPfcpSessionModificationRequest(upNodeID, ctx, rules.PDRs, rules.FARs, nil, rules.QERs, upfPort)
+}
@@ -36,8 +36,9 @@ var seq uint32
 
 const UPFAdapterURL = "http://upf-adapter:8090"
 
-func getSeqNumber() uint32 {
-	smfCount := 1
+// getSmfCount returns the SMF instance count used to separate the sequence number ranges
+func getSmfCount() (smfCount int) {
+	smfCount = 1
 	var err error
 	if smfCountStr, ok := os.LookupEnv("SMF_COUNT"); ok {
 		smfCount, err = strconv.Atoi(smfCountStr)
@@ -45,38 +46,147 @@ func getSeqNumber() uint32 {
 			logger.PfcpLog.Errorf("SMF_COUNT env variable is not a number: %v", smfCountStr)
 		}
 	}
+	return smfCount
+}
 
+func getSeqNumber() uint32 {
+	smfCount := getSmfCount()
 	seqNum := atomic.AddUint32(&seq, 1) + uint32((smfCount-1)*5000)
 	logger.PfcpLog.Debugf("unique seq num: smfCount from os: %v; seqNum %v", smfCount, seqNum)
 	return seqNum
 }
 
-func init() {
-	PfcpTxns = make(map[uint32]*smf_context.NodeID)
+// getUpfSeqNumber allocates the sequence number from the association of the UPF,
+// the upf-adapter correlates responses on sequence number only so it keeps the global one
+func getUpfSeqNumber(upNodeID smf_context.NodeID) uint32 {
+	if factory.SmfConfig.Configuration != nil && factory.SmfConfig.Configuration.EnableUpfAdapter {
+		return getSeqNumber()
+	}
+	upf := smf_context.RetrieveUPFNodeByNodeID(upNodeID)
+	if upf == nil {
+		return getSeqNumber()
+	}
+	smfCount := getSmfCount()
+	seqNum := upf.NextPfcpSeqNumber(uint32((smfCount - 1) * 5000))
+	logger.PfcpLog.Debugf("upf[%s] seq num: smfCount from os: %v; seqNum %v", upNodeID.ResolveNodeIdToIp(), smfCount, seqNum)
+	return seqNum
 }
 
 var (
-	PfcpTxns    map[uint32]*smf_context.NodeID
+	PfcpTxns    = make(map[uint32]*smf_context.PendingPfcpRequest)
 	PfcpTxnLock sync.Mutex
+	// time after which a request left without response is evicted, once all its
+	// retransmissions timed out
+	PendingPfcpRequestTTL = udp.NumOfResend * udp.ResendRequestTimeOutPeriod * time.Second
 )
 
-func FetchPfcpTxn(seqNo uint32) (upNodeID *smf_context.NodeID) {
+// FetchPfcpTxn returns the NodeID of the pending request matching the response
+// received from upIP with sequence number seqNo
+func FetchPfcpTxn(upIP net.IP, seqNo uint32) (upNodeID *smf_context.NodeID) {
+	return resolvePfcpTxn(upIP, seqNo, nil)
+}
+
+// resolvePfcpTxn removes the pending request sent to upIP with sequence number seqNo, its waiter
+// getting err, and returns its NodeID
+func resolvePfcpTxn(upIP net.IP, seqNo uint32, err error) *smf_context.NodeID {
+	if upIP != nil {
+		if upf := smf_context.RetrieveUPFNodeByNodeID(*smf_context.NewNodeID(upIP.String())); upf != nil {
+			if req := upf.ResolvePendingPfcpRequest(seqNo, err); req != nil {
+				return req.NodeID
+			}
+			return nil
+		}
+	}
+
 	PfcpTxnLock.Lock()
 	defer PfcpTxnLock.Unlock()
-	if upNodeID = PfcpTxns[seqNo]; upNodeID != nil {
+	if req := PfcpTxns[seqNo]; req != nil {
 		delete(PfcpTxns, seqNo)
+		req.Resolve(err)
+		return req.NodeID
 	}
-	return upNodeID
+	return nil
 }
 
-func InsertPfcpTxn(seqNo uint32, upNodeID *smf_context.NodeID) {
+// EvictExpiredPfcpTxns removes the requests pending for longer than PendingPfcpRequestTTL,
+// their waiters getting ErrPfcpRequestTimeout. Returns the number of evicted requests.
+func EvictExpiredPfcpTxns() int {
+	evicted := smf_context.EvictExpiredPfcpRequests(PendingPfcpRequestTTL)
+
+	now := time.Now()
 	PfcpTxnLock.Lock()
 	defer PfcpTxnLock.Unlock()
-	PfcpTxns[seqNo] = upNodeID
+	for seqNo, req := range PfcpTxns {
+		if req.Expired(now, PendingPfcpRequestTTL) {
+			delete(PfcpTxns, seqNo)
+			req.Resolve(smf_context.ErrPfcpRequestTimeout)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// SweepPfcpTxns evicts the requests left without response every retransmission period, it runs
+// along with the PFCP server
+func SweepPfcpTxns() {
+	ticker := time.NewTicker(udp.ResendRequestTimeOutPeriod * time.Second)
+	for range ticker.C {
+		EvictExpiredPfcpTxns()
+	}
+}
+
+// cpNodeIDFor returns the Node ID of the SMF in the PFCP association with the UPF
+func cpNodeIDFor(upNodeID smf_context.NodeID) string {
+	if upf := smf_context.RetrieveUPFNodeByNodeID(upNodeID); upf != nil {
+		cpNodeID := upf.CPNodeID()
+		return cpNodeID.String()
+	}
+	return smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp().String()
+}
+
+// InsertPfcpTxn records the request sent to the node until its response is received, or it is
+// evicted once PendingPfcpRequestTTL elapsed
+func InsertPfcpTxn(seqNo uint32, upNodeID *smf_context.NodeID) *smf_context.PendingPfcpRequest {
+	if upf := smf_context.RetrieveUPFNodeByNodeID(*upNodeID); upf != nil {
+		return upf.InsertPendingPfcpRequest(seqNo, upNodeID)
+	}
+
+	PfcpTxnLock.Lock()
+	defer PfcpTxnLock.Unlock()
+	if overwritten := PfcpTxns[seqNo]; overwritten != nil {
+		overwritten.Resolve(smf_context.ErrPfcpRequestTimeout)
+	}
+	req := smf_context.NewPendingPfcpRequest(upNodeID)
+	PfcpTxns[seqNo] = req
+	return req
+}
+
+// sendPfcpRequest records the request sent to the node until its response is received and sends
+// it. The ErrHandler of the event data is called once by the waiter of the request, whether it
+// fails on its send, on the timeout of its retransmissions or on its eviction.
+func sendPfcpRequest(msg message.Message, upNodeID smf_context.NodeID, addr *net.UDPAddr,
+	eventData udp.PfcpEventData,
+) error {
+	req := InsertPfcpTxn(msg.Sequence(), &upNodeID)
+	errHandler := eventData.ErrHandler
+	go func() {
+		if err := req.Wait(); err != nil && errHandler != nil {
+			errHandler(msg, err)
+		}
+	}()
+	eventData.ErrHandler = func(msg message.Message, err error) {
+		resolvePfcpTxn(addr.IP, msg.Sequence(), err)
+	}
+	if err := udp.SendPfcp(msg, addr, eventData); err != nil {
+		// the failure is returned to the sender
+		resolvePfcpTxn(addr.IP, msg.Sequence(), nil)
+		return err
+	}
+	return nil
 }
 
 func SendHeartbeatRequest(upNodeID smf_context.NodeID, upfPort uint16) error {
-	msg := BuildPfcpHeartbeatRequest(getSeqNumber(), udp.ServerStartTime)
+	msg := BuildPfcpHeartbeatRequest(getUpfSeqNumber(upNodeID), udp.ServerStartTime)
 	addr := &net.UDPAddr{
 		IP:   upNodeID.ResolveNodeIdToIp(),
 		Port: int(upfPort),
@@ -115,7 +225,7 @@ func SendHeartbeatRequest(upNodeID smf_context.NodeID, upfPort uint16) error {
 	} else {
 		InsertPfcpTxn(msg.Sequence(), &upNodeID)
 		if err := udp.SendPfcp(msg, addr, nil); err != nil {
-			FetchPfcpTxn(msg.Sequence())
+			FetchPfcpTxn(addr.IP, msg.Sequence())
 			return err
 		}
 	}
@@ -144,7 +254,7 @@ func SendPfcpAssociationSetupRequest(upNodeID smf_context.NodeID, upfPort uint16
 		return fmt.Errorf("PFCP Association Setup Request failed, invalid NodeId: %v", string(upNodeID.NodeIdValue))
 	}
 
-	pfcpMsg := BuildPfcpAssociationSetupRequest(getSeqNumber(), udp.ServerStartTime, smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp().String())
+	pfcpMsg := BuildPfcpAssociationSetupRequest(getUpfSeqNumber(upNodeID), udp.ServerStartTime, cpNodeIDFor(upNodeID))
 	addr := &net.UDPAddr{
 		IP:   upNodeID.ResolveNodeIdToIp(),
 		Port: int(upfPort),
@@ -185,7 +295,7 @@ func SendPfcpAssociationSetupRequest(upNodeID smf_context.NodeID, upfPort uint16
 }
 
 func SendPfcpAssociationSetupResponse(upNodeID smf_context.NodeID, cause uint8, upfPort uint16) error {
-	pfcpMsg := BuildPfcpAssociationSetupResponse(cause, udp.ServerStartTime, smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp().String())
+	pfcpMsg := BuildPfcpAssociationSetupResponse(cause, udp.ServerStartTime, cpNodeIDFor(upNodeID))
 	addr := &net.UDPAddr{
 		IP:   upNodeID.ResolveNodeIdToIp(),
 		Port: int(upfPort),
@@ -198,8 +308,29 @@ func SendPfcpAssociationSetupResponse(upNodeID smf_context.NodeID, cause uint8,
 	return nil
 }
 
+// SendPfcpAssociationReleaseRequest releases the PFCP association with the UPF, which deletes all
+// the PFCP sessions of the association. The UPF is not expected to be used afterwards, its
+// response is not waited for.
+func SendPfcpAssociationReleaseRequest(upNodeID smf_context.NodeID, upfPort uint16) error {
+	if net.IP.Equal(upNodeID.ResolveNodeIdToIp(), net.IPv4zero) {
+		return fmt.Errorf("PFCP Association Release Request failed, invalid NodeId: %v", string(upNodeID.NodeIdValue))
+	}
+
+	pfcpMsg := BuildPfcpAssociationReleaseRequest(getUpfSeqNumber(upNodeID), cpNodeIDFor(upNodeID))
+	addr := &net.UDPAddr{
+		IP:   upNodeID.ResolveNodeIdToIp(),
+		Port: int(upfPort),
+	}
+	err := udp.SendPfcp(pfcpMsg, addr, nil)
+	if err != nil {
+		return err
+	}
+	logger.PfcpLog.Infof("sent PFCP Association Release Request to NodeID[%s]", upNodeID.ResolveNodeIdToIp().String())
+	return nil
+}
+
 func SendPfcpAssociationReleaseResponse(upNodeID smf_context.NodeID, cause uint8, upfPort uint16) error {
-	pfcpMsg := BuildPfcpAssociationReleaseResponse(cause, smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp().String())
+	pfcpMsg := BuildPfcpAssociationReleaseResponse(cause, cpNodeIDFor(upNodeID))
 	addr := &net.UDPAddr{
 		IP:   upNodeID.ResolveNodeIdToIp(),
 		Port: int(upfPort),
@@ -228,15 +359,43 @@ func SendPfcpSessionEstablishmentRequest(
 	}
 
 	nodeIDIPAddress := smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp()
+	cpNodeID := cpNodeIDFor(upNodeID)
+	opts := SessionEstablishmentOptions{
+		SRR:              ctx.QoSMonitoringSRR(upNodeID, qerList),
+		TraceData:        ctx.TraceData,
+		CreateBridgeInfo: ctx.DNNInfo != nil && ctx.DNNInfo.TSNConfig != nil,
+		PDNType:          SessionPDNType(ctx),
+	}
+
+	// rules not fitting in the MTU are sent in Session Modification Requests once the establishment is accepted
+	if !factory.SmfConfig.Configuration.EnableUpfAdapter {
+		estMsg, err := BuildPfcpSessionEstablishmentRequest(0, cpNodeID, nodeIDIPAddress,
+			pfcpContext.LocalSEID, nil, nil, nil, opts)
+		if err != nil {
+			return err
+		}
+		modMsg, err := BuildPfcpSessionModificationRequest(0, pfcpContext.LocalSEID, 0, nodeIDIPAddress, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		ruleSets := SplitPfcpSessionRules(pdrList, farList, qerList, estMsg.MarshalLen(), modMsg.MarshalLen(), pfcpMaxMessageLen())
+		pfcpContext.PendingRules = ruleSets[1:]
+		pfcpContext.EstablishingFragments = len(ruleSets) > 1
+		if pfcpContext.EstablishingFragments {
+			ctx.SubPfcpLog.Infof("session establishment exceeds the PFCP MTU, split over %d messages", len(ruleSets))
+			pdrList, farList, qerList = ruleSets[0].PDRs, ruleSets[0].FARs, ruleSets[0].QERs
+		}
+	}
 
 	pfcpMsg, err := BuildPfcpSessionEstablishmentRequest(
-		getSeqNumber(),
-		nodeIDIPAddress.String(),
+		getUpfSeqNumber(upNodeID),
+		cpNodeID,
 		nodeIDIPAddress,
 		pfcpContext.LocalSEID,
 		pdrList,
 		farList,
 		qerList,
+		opts,
 	)
 	if err != nil {
 		return err
@@ -283,10 +442,8 @@ func SendPfcpSessionEstablishmentRequest(
 			}
 		}
 	} else {
-		InsertPfcpTxn(pfcpMsg.Sequence(), &upNodeID)
 		eventData := udp.PfcpEventData{LSEID: ctx.PFCPContext[ip.String()].LocalSEID, ErrHandler: HandlePfcpSendError}
-		err := udp.SendPfcp(pfcpMsg, upaddr, eventData)
-		if err != nil {
+		if err := sendPfcpRequest(pfcpMsg, upNodeID, upaddr, eventData); err != nil {
 			return err
 		}
 	}
@@ -303,7 +460,7 @@ func SendPfcpSessionModificationRequest(
 	qerList []*smf_context.QER,
 	upfPort uint16,
 ) error {
-	seqNum := getSeqNumber()
+	seqNum := getUpfSeqNumber(upNodeID)
 	upNodeIDStr := upNodeID.ResolveNodeIdToIp().String()
 	pfcpContext, ok := ctx.PFCPContext[upNodeIDStr]
 	if !ok {
@@ -313,6 +470,32 @@ func SendPfcpSessionModificationRequest(
 	if err != nil {
 		return err
 	}
+	return sendPfcpSessionModificationRequest(upNodeID, ctx, pfcpMsg, upfPort)
+}
+
+// SendPfcpSessionTSCManagementRequest sends the port management information container of the TSN
+// bridge to the NW-TT port of the UPF in a Session Modification Request without rules
+func SendPfcpSessionTSCManagementRequest(upNodeID smf_context.NodeID, ctx *smf_context.SMContext, upfPort uint16,
+	portManagementInfo []byte, nwttPortNumber uint32,
+) error {
+	seqNum := getUpfSeqNumber(upNodeID)
+	upNodeIDStr := upNodeID.ResolveNodeIdToIp().String()
+	pfcpContext, ok := ctx.PFCPContext[upNodeIDStr]
+	if !ok {
+		return fmt.Errorf("PFCP Context not found for NodeID[%s]", upNodeIDStr)
+	}
+	pfcpMsg, err := BuildPfcpSessionModificationRequest(seqNum, pfcpContext.LocalSEID, pfcpContext.RemoteSEID, smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp(), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	pfcpMsg.TSCManagementInformation = BuildTSCManagementInformation(portManagementInfo, nwttPortNumber)
+	pfcpMsg.SetLength()
+	return sendPfcpSessionModificationRequest(upNodeID, ctx, pfcpMsg, upfPort)
+}
+
+func sendPfcpSessionModificationRequest(upNodeID smf_context.NodeID, ctx *smf_context.SMContext,
+	pfcpMsg *message.SessionModificationRequest, upfPort uint16,
+) error {
 	nodeIDtoIP := upNodeID.ResolveNodeIdToIp().String()
 	upaddr := &net.UDPAddr{
 		IP:   upNodeID.ResolveNodeIdToIp(),
@@ -345,10 +528,8 @@ func SendPfcpSessionModificationRequest(
 			}
 		}
 	} else {
-		InsertPfcpTxn(pfcpMsg.Sequence(), &upNodeID)
 		eventData := udp.PfcpEventData{LSEID: ctx.PFCPContext[nodeIDtoIP].LocalSEID, ErrHandler: HandlePfcpSendError}
-		err := udp.SendPfcp(pfcpMsg, upaddr, eventData)
-		if err != nil {
+		if err := sendPfcpRequest(pfcpMsg, upNodeID, upaddr, eventData); err != nil {
 			logger.PfcpLog.Errorf("send pfcp session modify msg to upf error [%v]", err.Error())
 		}
 	}
@@ -357,7 +538,7 @@ func SendPfcpSessionModificationRequest(
 }
 
 func SendPfcpSessionDeletionRequest(upNodeID smf_context.NodeID, ctx *smf_context.SMContext, upfPort uint16) error {
-	seqNum := getSeqNumber()
+	seqNum := getUpfSeqNumber(upNodeID)
 	upNodeIDStr := upNodeID.ResolveNodeIdToIp().String()
 	pfcpContext, ok := ctx.PFCPContext[upNodeIDStr]
 	if !ok {
@@ -396,10 +577,8 @@ func SendPfcpSessionDeletionRequest(upNodeID smf_context.NodeID, ctx *smf_contex
 			}
 		}
 	} else {
-		InsertPfcpTxn(pfcpMsg.Sequence(), &upNodeID)
 		eventData := udp.PfcpEventData{LSEID: pfcpContext.LocalSEID, ErrHandler: HandlePfcpSendError}
-		err := udp.SendPfcp(pfcpMsg, upaddr, eventData)
-		if err != nil {
+		if err := sendPfcpRequest(pfcpMsg, upNodeID, upaddr, eventData); err != nil {
 			return err
 		}
 	}
@@ -408,6 +587,151 @@ func SendPfcpSessionDeletionRequest(upNodeID smf_context.NodeID, ctx *smf_contex
 	return nil
 }
 
+// SendPfcpSessionReportFetchRequest checks that the UPF still holds the PFCP session, the cause of
+// the response is delivered to the recovery probe of the session, see StartRecoveryProbe
+func SendPfcpSessionReportFetchRequest(upNodeID smf_context.NodeID, ctx *smf_context.SMContext, upfPort uint16) error {
+	seqNum := getUpfSeqNumber(upNodeID)
+	upNodeIDStr := upNodeID.ResolveNodeIdToIp().String()
+	pfcpContext, ok := ctx.PFCPContext[upNodeIDStr]
+	if !ok {
+		return fmt.Errorf("PFCP Context not found for NodeID[%s]", upNodeIDStr)
+	}
+	pfcpMsg := BuildPfcpSessionReportFetchRequest(seqNum, pfcpContext.LocalSEID, pfcpContext.RemoteSEID, smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp())
+
+	upaddr := &net.UDPAddr{
+		IP:   upNodeID.ResolveNodeIdToIp(),
+		Port: int(upfPort),
+	}
+
+	if factory.SmfConfig.Configuration.EnableUpfAdapter {
+		rsp, err := SendPfcpMsgToAdapter(upNodeID, pfcpMsg, upaddr, nil, UPFAdapterURL)
+		if err != nil {
+			return err
+		}
+		if rsp.StatusCode != http.StatusOK {
+			return fmt.Errorf("upf-adapter status %d", rsp.StatusCode)
+		}
+		pfcpMsgBytes, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			return err
+		}
+		pfcpRspMsg, err := message.ParseSessionModificationResponse(pfcpMsgBytes)
+		if err != nil {
+			return fmt.Errorf("parse pfcp session report fetch response failed: %v", err)
+		}
+		cause := smf_context.CauseNoResponse
+		if pfcpRspMsg.Cause != nil {
+			if cause, err = pfcpRspMsg.Cause.Cause(); err != nil {
+				return err
+			}
+		}
+		ctx.CompleteRecoveryProbe(upNodeID, cause)
+	} else {
+		eventData := udp.PfcpEventData{
+			LSEID: pfcpContext.LocalSEID,
+			ErrHandler: func(msg message.Message, pfcpErr error) {
+				ctx.SubPfcpLog.Warnf("PFCP Session Report Fetch send failure, %v", pfcpErr)
+				ctx.CompleteRecoveryProbe(upNodeID, smf_context.CauseNoResponse)
+			},
+		}
+		if err := sendPfcpRequest(pfcpMsg, upNodeID, upaddr, eventData); err != nil {
+			return err
+		}
+	}
+
+	ctx.SubPfcpLog.Infof("sent PFCP Session Report Fetch to NodeID[%s]", upNodeIDStr)
+	return nil
+}
+
+// SendPfcpReachabilityProbeRequest installs the rules of the UE reachability probe of the session
+// on the UPF, the returned channel receiving the UPF response. To be called with SMLock held.
+func SendPfcpReachabilityProbeRequest(upNodeID smf_context.NodeID, ctx *smf_context.SMContext, upfPort uint16,
+	responderIP net.IP, pdrID uint16, farID uint32, urrID uint32,
+) (<-chan *message.SessionModificationResponse, error) {
+	seqNum := getUpfSeqNumber(upNodeID)
+	upNodeIDStr := upNodeID.ResolveNodeIdToIp().String()
+	pfcpContext, ok := ctx.PFCPContext[upNodeIDStr]
+	if !ok {
+		return nil, fmt.Errorf("PFCP Context not found for NodeID[%s]", upNodeIDStr)
+	}
+	pfcpMsg := BuildPfcpReachabilityProbeRequest(seqNum, pfcpContext.LocalSEID, pfcpContext.RemoteSEID,
+		smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp(), ctx.PDUAddress.Ip, responderIP, pdrID, farID, urrID)
+	return sendPfcpReachabilityProbe(upNodeID, ctx, pfcpMsg, pfcpContext.LocalSEID, upfPort)
+}
+
+// SendPfcpReachabilityProbeRemovalRequest removes the rules of the UE reachability probe of the
+// session from the UPF, the returned channel receiving the UPF response with the usage of the
+// probe URR. To be called with SMLock held.
+func SendPfcpReachabilityProbeRemovalRequest(upNodeID smf_context.NodeID, ctx *smf_context.SMContext, upfPort uint16,
+	pdrID uint16, farID uint32, urrID uint32,
+) (<-chan *message.SessionModificationResponse, error) {
+	seqNum := getUpfSeqNumber(upNodeID)
+	upNodeIDStr := upNodeID.ResolveNodeIdToIp().String()
+	pfcpContext, ok := ctx.PFCPContext[upNodeIDStr]
+	if !ok {
+		return nil, fmt.Errorf("PFCP Context not found for NodeID[%s]", upNodeIDStr)
+	}
+	pfcpMsg := BuildPfcpReachabilityProbeRemovalRequest(seqNum, pfcpContext.LocalSEID, pfcpContext.RemoteSEID,
+		smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp(), pdrID, farID, urrID)
+	return sendPfcpReachabilityProbe(upNodeID, ctx, pfcpMsg, pfcpContext.LocalSEID, upfPort)
+}
+
+// sendPfcpReachabilityProbe sends the Session Modification of the UE reachability probe, its
+// response being delivered to the probe, see StartReachabilityProbe
+func sendPfcpReachabilityProbe(upNodeID smf_context.NodeID, ctx *smf_context.SMContext,
+	pfcpMsg *message.SessionModificationRequest, localSEID uint64, upfPort uint16,
+) (<-chan *message.SessionModificationResponse, error) {
+	upaddr := &net.UDPAddr{
+		IP:   upNodeID.ResolveNodeIdToIp(),
+		Port: int(upfPort),
+	}
+	seqNum := pfcpMsg.Sequence()
+	probe := ctx.StartReachabilityProbe(upNodeID, seqNum)
+
+	if factory.SmfConfig.Configuration.EnableUpfAdapter {
+		rsp, err := SendPfcpMsgToAdapter(upNodeID, pfcpMsg, upaddr, nil, UPFAdapterURL)
+		if err != nil {
+			ctx.CancelReachabilityProbe(upNodeID, seqNum)
+			return nil, err
+		}
+		defer func() {
+			if err = rsp.Body.Close(); err != nil {
+				logger.PfcpLog.Errorf("close response body failed: %v", err)
+			}
+		}()
+		if rsp.StatusCode != http.StatusOK {
+			ctx.CancelReachabilityProbe(upNodeID, seqNum)
+			return nil, fmt.Errorf("upf-adapter status %d", rsp.StatusCode)
+		}
+		pfcpMsgBytes, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			ctx.CancelReachabilityProbe(upNodeID, seqNum)
+			return nil, err
+		}
+		pfcpRspMsg, err := message.ParseSessionModificationResponse(pfcpMsgBytes)
+		if err != nil {
+			ctx.CancelReachabilityProbe(upNodeID, seqNum)
+			return nil, fmt.Errorf("parse pfcp reachability probe response failed: %v", err)
+		}
+		ctx.CompleteReachabilityProbe(upNodeID, seqNum, pfcpRspMsg)
+	} else {
+		eventData := udp.PfcpEventData{
+			LSEID: localSEID,
+			ErrHandler: func(msg message.Message, pfcpErr error) {
+				ctx.SubPfcpLog.Warnf("PFCP UE reachability probe send failure, %v", pfcpErr)
+				ctx.CompleteReachabilityProbe(upNodeID, msg.Sequence(), nil)
+			},
+		}
+		if err := sendPfcpRequest(pfcpMsg, upNodeID, upaddr, eventData); err != nil {
+			ctx.CancelReachabilityProbe(upNodeID, seqNum)
+			return nil, err
+		}
+	}
+
+	ctx.SubPfcpLog.Infof("sent PFCP UE reachability probe Seq[%d] to NodeID[%s]", seqNum, upaddr.IP)
+	return probe, nil
+}
+
 func SendPfcpSessionReportResponse(addr *net.UDPAddr, cause uint8, pfcpSRflag smf_context.PFCPSRRspFlags, seqFromUPF uint32, SEID uint64) error {
 	pfcpMsg := BuildPfcpSessionReportResponse(cause, pfcpSRflag.Drobu, seqFromUPF, SEID)
 	err := udp.SendPfcp(pfcpMsg, addr, nil)
@@ -428,6 +752,16 @@ func SendHeartbeatResponse(addr *net.UDPAddr, sequenceNumber uint32) error {
 	return nil
 }
 
+func SendPfcpNodeReportResponse(upNodeID smf_context.NodeID, addr *net.UDPAddr, cause uint8, sequenceNumber uint32) error {
+	pfcpMsg := BuildPfcpNodeReportResponse(cause, sequenceNumber, cpNodeIDFor(upNodeID))
+	err := udp.SendPfcp(pfcpMsg, addr, nil)
+	if err != nil {
+		return err
+	}
+	logger.PfcpLog.Infof("sent PFCP Node Report Response Seq[%d] to NodeID[%s]", sequenceNumber, addr.IP.String())
+	return nil
+}
+
 func HandlePfcpSendError(msg message.Message, pfcpErr error) {
 	logger.PfcpLog.Errorf("send of PFCP msg [%v] failed, %v",
 		msg.MessageTypeName(), pfcpErr.Error())
@@ -465,6 +799,11 @@ func handleSendPfcpSessEstReqError(msg message.Message, pfcpErr error) {
 		return
 	}
 	smContext.SubPfcpLog.Errorf("PFCP Session Establishment send failure, %v", pfcpErr.Error())
+	if smContext.SMContextState == smf_context.SmStatePfcpCreatePending {
+		// the establishment waiting for the response rejects the session
+		smContext.PostPFCPResponseStatus(smf_context.SessionEstablishFailed)
+		return
+	}
 	// N1N2 Request towards AMF
 	n1n2Request := models.N1N2MessageTransferRequest{}
 
@@ -486,10 +825,7 @@ func handleSendPfcpSessEstReqError(msg message.Message, pfcpErr error) {
 	}
 
 	// Send N1N2 Reject request
-	rspData, _, err := smContext.
-		CommunicationClient.
-		N1N2MessageCollectionDocumentApi.
-		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	rspData, err := smContext.N1N2MessageTransfer(context.Background(), n1n2Request)
 	smContext.ChangeState(smf_context.SmStateInit)
 	smContext.SubCtxLog.Debugln("SMContextState Change State:", smContext.SMContextState.String())
 	if err != nil {
@@ -516,8 +852,13 @@ func handleSendPfcpSessRelReqError(msg message.Message, pfcpErr error) {
 	smContext := smf_context.GetSMContextBySEID(SEID)
 	if smContext != nil {
 		smContext.SubPfcpLog.Errorf("PFCP Session Delete send failure, %v", pfcpErr.Error())
-		// Always send success
-		smContext.SBIPFCPCommunicationChan <- smf_context.SessionReleaseSuccess
+		// Always send success, once no other UPF is pending
+		upfNodeID := smContext.GetNodeIDByLocalSEID(SEID)
+		upfIP := upfNodeID.ResolveNodeIdToIp().String()
+		delete(smContext.PendingUPF, upfIP)
+		if smContext.PendingUPF.IsEmpty() {
+			smContext.PostPFCPResponseStatus(smf_context.SessionReleaseSuccess)
+		}
 	}
 }
 
@@ -537,7 +878,7 @@ func handleSendPfcpSessModReqError(msg message.Message, pfcpErr error) {
 	}
 	smContext.SubPfcpLog.Errorf("PFCP Session Modification send failure, %v", pfcpErr.Error())
 
-	smContext.SBIPFCPCommunicationChan <- smf_context.SessionUpdateTimeout
+	smContext.PostPFCPResponseStatus(smf_context.SessionUpdateTimeout)
 }
 
 type adapterMessage struct {
@@ -488,3 +488,526 @@ func TestSendPfcpMsgToAdapter(t *testing.T) {
 	}
 	assert.Equal(t, http.StatusOK, rsp.StatusCode)
 }
+
+// Given a session with more rules than fit in the PFCP MTU, the establishment carries the first
+// rules and the others are sent in Session Modification Requests, one at a time
+func TestSendPfcpSessionEstablishmentRequestSplit(t *testing.T) {
+	const upNodeIDStr = "127.0.0.1"
+	const upfPort = 8807
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{
+			EnableUpfAdapter: false,
+		},
+	}
+	upNodeID := context.NodeID{
+		NodeIdType:  context.NodeIdTypeIpv4Address,
+		NodeIdValue: net.ParseIP(upNodeIDStr).To4(),
+	}
+	config := zap.NewProductionConfig()
+	log, err := config.Build()
+	if err != nil {
+		panic(err)
+	}
+	mockLog := log.Sugar()
+	smContext := &context.SMContext{
+		PFCPContext: map[string]*context.PFCPSessionContext{
+			upNodeIDStr: {
+				NodeID:    upNodeID,
+				LocalSEID: 1,
+			},
+		},
+		SubPduSessLog: mockLog,
+		SubPfcpLog:    mockLog,
+	}
+	context.SMF_Self().PFCPMtu = factory.DEFAULT_PFCP_MTU
+	defer func() { context.SMF_Self().PFCPMtu = 0 }()
+
+	sessionAmbr := &context.QER{QERID: 1, QFI: context.QFI{QFI: 9}, MBR: &context.MBR{ULMBR: 1000, DLMBR: 2000}}
+	pdrList := make([]*context.PDR, 0, 50)
+	farList := make([]*context.FAR, 0, 50)
+	for i := 1; i <= 50; i++ {
+		far := &context.FAR{FARID: uint32(i), ApplyAction: context.ApplyAction{Forw: true}}
+		pdrList = append(pdrList, &context.PDR{
+			PDRID:      uint16(i),
+			Precedence: uint32(i),
+			FAR:        far,
+			QER:        []*context.QER{sessionAmbr},
+			PDI: context.PDI{
+				SourceInterface: context.SourceInterface{InterfaceValue: context.SourceInterfaceCore},
+				UEIPAddress:     &context.UEIPAddress{V4: true, Ipv4Address: net.ParseIP("10.60.0.1").To4()},
+				SDFFilter: &context.SDFFilter{
+					FlowDescription: []byte(fmt.Sprintf("permit out ip from 192.168.%d.0/24 to assigned", i)),
+				},
+			},
+		})
+		farList = append(farList, far)
+	}
+	qerList := []*context.QER{sessionAmbr}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(upNodeIDStr), Port: upfPort})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			t.Logf("error closing connection: %v", err)
+		}
+	}()
+	udp.Server = &udp.PfcpServer{
+		Conn: conn,
+	}
+
+	readMessage := func() pfcp_message.Message {
+		buf := make([]byte, udp.PFCP_MAX_UDP_LEN)
+		if err = conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("error setting read deadline: %v", err)
+		}
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("error reading PFCP message: %v", err)
+		}
+		maxLen := factory.DEFAULT_PFCP_MTU - 48
+		if n > maxLen {
+			t.Errorf("PFCP message of %d bytes exceeds %d bytes", n, maxLen)
+		}
+		msg, err := pfcp_message.Parse(buf[:n])
+		if err != nil {
+			t.Fatalf("error parsing PFCP message: %v", err)
+		}
+		// answered, its waiter is not left to the eviction
+		message.FetchPfcpTxn(net.ParseIP(upNodeIDStr), msg.Sequence())
+		return msg
+	}
+
+	err = message.SendPfcpSessionEstablishmentRequest(upNodeID, smContext, pdrList, farList, nil, qerList, upfPort)
+	if err != nil {
+		t.Fatalf("error sending PFCP Session Establishment Request: %v", err)
+	}
+
+	pfcpContext := smContext.PFCPContext[upNodeIDStr]
+	if !pfcpContext.EstablishingFragments || len(pfcpContext.PendingRules) == 0 {
+		t.Fatalf("expected the establishment to be split")
+	}
+
+	createdPDRs := make(map[uint16]bool)
+	createdFARs := make(map[uint32]bool)
+	checkRules := func(createPDRs, createFARs []*ie.IE) {
+		for _, createFAR := range createFARs {
+			farID, err := createFAR.FARID()
+			if err != nil {
+				t.Fatalf("error getting FAR ID: %v", err)
+			}
+			createdFARs[farID] = true
+		}
+		for _, createPDR := range createPDRs {
+			pdrID, err := createPDR.PDRID()
+			if err != nil {
+				t.Fatalf("error getting PDR ID: %v", err)
+			}
+			if createdPDRs[pdrID] {
+				t.Errorf("PDR %d created twice", pdrID)
+			}
+			createdPDRs[pdrID] = true
+			farID, err := createPDR.FARID()
+			if err != nil || !createdFARs[farID] {
+				t.Errorf("FAR %d of PDR %d not created along or before it", farID, pdrID)
+			}
+		}
+	}
+
+	est, ok := readMessage().(*pfcp_message.SessionEstablishmentRequest)
+	if !ok {
+		t.Fatalf("expected a Session Establishment Request first")
+	}
+	if len(est.CreateQER) != 1 {
+		t.Errorf("expected the session QER in the establishment, got %d QERs", len(est.CreateQER))
+	}
+	checkRules(est.CreatePDR, est.CreateFAR)
+
+	// the next rules are sent once the previous message is accepted
+	pfcpContext.RemoteSEID = 2
+	for {
+		sent, err := message.SendNextPfcpSessionRules(upNodeID, smContext, upfPort)
+		if err != nil {
+			t.Fatalf("error sending the next rules: %v", err)
+		}
+		if !sent {
+			break
+		}
+		mod, ok := readMessage().(*pfcp_message.SessionModificationRequest)
+		if !ok {
+			t.Fatalf("expected a Session Modification Request")
+		}
+		if mod.SEID() != 2 {
+			t.Errorf("expected SEID 2, got %d", mod.SEID())
+		}
+		if len(mod.CreateQER) != 0 {
+			t.Errorf("expected the session QER to be created once")
+		}
+		checkRules(mod.CreatePDR, mod.CreateFAR)
+	}
+
+	if len(createdPDRs) != 50 {
+		t.Errorf("expected 50 PDRs created, got %d", len(createdPDRs))
+	}
+}
+
+// The URRs and the BAR created along the PDRs and FARs count in the size of the split messages,
+// whatever the MTU
+func TestSplitPfcpSessionRulesURRsAndBARs(t *testing.T) {
+	newRules := func() ([]*context.PDR, []*context.FAR) {
+		bar := &context.BAR{
+			BARID:                          1,
+			DownlinkDataNotificationDelay:  context.DownlinkDataNotificationDelay{DelayValue: 100 * time.Millisecond},
+			SuggestedBufferingPacketsCount: context.SuggestedBufferingPacketsCount{PacketCountValue: 10},
+		}
+		pdrList := make([]*context.PDR, 0, 20)
+		farList := make([]*context.FAR, 0, 20)
+		for i := 1; i <= 20; i++ {
+			far := &context.FAR{FARID: uint32(i), ApplyAction: context.ApplyAction{Buff: true}, BAR: bar}
+			pdrList = append(pdrList, &context.PDR{
+				PDRID:      uint16(i),
+				Precedence: uint32(i),
+				FAR:        far,
+				URR: &context.URR{
+					URRID:             uint32(i),
+					MeasurementPeriod: time.Minute,
+					MeasurementMethod: context.MeasurementMethod{Volum: true, Durat: true},
+				},
+				PDI: context.PDI{
+					SourceInterface: context.SourceInterface{InterfaceValue: context.SourceInterfaceCore},
+					UEIPAddress:     &context.UEIPAddress{V4: true, Ipv4Address: net.ParseIP("10.60.0.1").To4()},
+				},
+			})
+			farList = append(farList, far)
+		}
+		return pdrList, farList
+	}
+
+	fseidIP := net.ParseIP("10.200.0.100").To4()
+	estMsg, err := message.BuildPfcpSessionEstablishmentRequest(0, "10.200.0.100", fseidIP, 1, nil, nil, nil, message.SessionEstablishmentOptions{})
+	if err != nil {
+		t.Fatalf("error building the PFCP Session Establishment Request: %v", err)
+	}
+	modMsg, err := message.BuildPfcpSessionModificationRequest(0, 1, 0, fseidIP, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("error building the PFCP Session Modification Request: %v", err)
+	}
+
+	for maxLen := 200; maxLen <= 1500; maxLen++ {
+		pdrList, farList := newRules()
+		ruleSets := message.SplitPfcpSessionRules(pdrList, farList, nil, estMsg.MarshalLen(), modMsg.MarshalLen(), maxLen)
+
+		createdURRs := 0
+		createdBARs := 0
+		for i, rules := range ruleSets {
+			var msg pfcp_message.Message
+			if i == 0 {
+				est, err := message.BuildPfcpSessionEstablishmentRequest(0, "10.200.0.100", fseidIP, 1,
+					rules.PDRs, rules.FARs, rules.QERs, message.SessionEstablishmentOptions{})
+				if err != nil {
+					t.Fatalf("error building the PFCP Session Establishment Request: %v", err)
+				}
+				createdURRs += len(est.CreateURR)
+				if est.CreateBAR != nil {
+					createdBARs++
+				}
+				msg = est
+			} else {
+				mod, err := message.BuildPfcpSessionModificationRequest(0, 1, 2, fseidIP, rules.PDRs, rules.FARs, rules.QERs)
+				if err != nil {
+					t.Fatalf("error building the PFCP Session Modification Request: %v", err)
+				}
+				createdURRs += len(mod.CreateURR)
+				if mod.CreateBAR != nil {
+					createdBARs++
+				}
+				msg = mod
+			}
+			// a single PDR with its rules only exceeds a tiny MTU
+			if msg.MarshalLen() > maxLen && len(rules.PDRs) > 1 {
+				t.Fatalf("PFCP message %d of %d bytes exceeds %d bytes", i, msg.MarshalLen(), maxLen)
+			}
+		}
+		// the BAR of the session is created once, along its first FAR
+		if createdURRs != 20 || createdBARs != 1 {
+			t.Fatalf("expected 20 URRs and 1 BAR created, got %d and %d", createdURRs, createdBARs)
+		}
+	}
+}
+
+// The SMF identifies itself with its FQDN to a UPF configured with a Node ID of FQDN type,
+// and the response of the UPF is matched with its address
+func TestSendPfcpAssociationSetupRequestFqdnNodeID(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{
+			KafkaInfo: factory.KafkaInfo{EnableKafka: boolPointer(false)},
+		},
+	}
+	smfSelf := context.SMF_Self()
+	origCPNodeID, origCPNodeFQDN := smfSelf.CPNodeID, smfSelf.CPNodeFQDN
+	t.Cleanup(func() { smfSelf.CPNodeID, smfSelf.CPNodeFQDN = origCPNodeID, origCPNodeFQDN })
+	smfSelf.CPNodeID = *context.NewNodeID("127.0.0.1")
+	smfSelf.CPNodeFQDN = "smf.example.test"
+
+	context.InsertDnsHostIp("upf-fqdn.example.test", net.ParseIP("127.0.0.2"))
+	upNodeID := *context.NewNodeID("upf-fqdn.example.test")
+	upf := context.NewUPF(&upNodeID, nil)
+	upf.CPNodeIDFqdn = true
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(upNodeID) })
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 8810})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			t.Logf("error closing connection: %v", err)
+		}
+	}()
+	udp.Server = &udp.PfcpServer{
+		Conn: conn,
+	}
+
+	if err = message.SendPfcpAssociationSetupRequest(upNodeID, 8810); err != nil {
+		t.Fatalf("error sending PFCP Association Setup Request: %v", err)
+	}
+	buf := make([]byte, 1500)
+	if err = conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("error setting read deadline: %v", err)
+	}
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("error reading PFCP Association Setup Request: %v", err)
+	}
+	req, err := pfcp_message.ParseAssociationSetupRequest(buf[:n])
+	if err != nil {
+		t.Fatalf("error parsing PFCP Association Setup Request: %v", err)
+	}
+	if req.NodeID.Payload[0] != ie.NodeIDFQDN {
+		t.Errorf("expected Node ID of FQDN type, got type %d", req.NodeID.Payload[0])
+	}
+	if nodeID, err := req.NodeID.NodeID(); err != nil || nodeID != "smf.example.test" {
+		t.Errorf("expected Node ID smf.example.test, got %s (%v)", nodeID, err)
+	}
+
+	// the response comes from the address of the UPF
+	if nodeID := message.FetchPfcpTxn(net.ParseIP("127.0.0.2"), req.Sequence()); nodeID == nil ||
+		nodeID.NodeIdType != context.NodeIdTypeFqdn {
+		t.Errorf("expected the pending request to the UPF of FQDN Node ID, got %v", nodeID)
+	}
+}
+
+// A request left without response is evicted once its retransmissions timed out, and its
+// caller gets a timeout
+func TestEvictExpiredPfcpTxns(t *testing.T) {
+	origTTL := message.PendingPfcpRequestTTL
+	t.Cleanup(func() { message.PendingPfcpRequestTTL = origTTL })
+	message.PendingPfcpRequestTTL = 10 * time.Millisecond
+
+	upNodeID := context.NewNodeID("10.20.40.1")
+	upf := context.NewUPF(upNodeID, nil)
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+	unknownNodeID := context.NewNodeID("10.20.40.2")
+
+	unanswered := message.InsertPfcpTxn(101, upNodeID)
+	unansweredUnknown := message.InsertPfcpTxn(102, unknownNodeID)
+	answered := message.InsertPfcpTxn(103, upNodeID)
+	if nodeID := message.FetchPfcpTxn(net.ParseIP("10.20.40.1"), 103); nodeID != upNodeID {
+		t.Fatalf("expected the answered request of %v, got %v", upNodeID, nodeID)
+	}
+	if err := answered.Wait(); err != nil {
+		t.Errorf("expected the answered request to be resolved without error, got %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	// the requests left by the other tests are evicted too
+	if evicted := message.EvictExpiredPfcpTxns(); evicted < 2 {
+		t.Errorf("expected at least 2 evicted requests, got %d", evicted)
+	}
+	if err := unanswered.Wait(); err != context.ErrPfcpRequestTimeout {
+		t.Errorf("expected a timeout for the unanswered request, got %v", err)
+	}
+	if err := unansweredUnknown.Wait(); err != context.ErrPfcpRequestTimeout {
+		t.Errorf("expected a timeout for the unanswered request to an unknown node, got %v", err)
+	}
+	if count := upf.PendingPfcpRequestCount(); count != 0 {
+		t.Errorf("expected no pending request, got %d", count)
+	}
+	if nodeID := message.FetchPfcpTxn(net.ParseIP("10.20.40.1"), 101); nodeID != nil {
+		t.Errorf("expected the late response of an evicted request to match nothing, got %v", nodeID)
+	}
+	if nodeID := message.FetchPfcpTxn(nil, 102); nodeID != nil {
+		t.Errorf("expected the late response of an evicted request to match nothing, got %v", nodeID)
+	}
+}
+
+// A session request left without response is evicted, its failure handled once
+func TestSendPfcpSessionReportFetchRequestEvicted(t *testing.T) {
+	const upNodeIDStr = "127.0.0.1"
+	const upfPort = 8809
+	origTTL := message.PendingPfcpRequestTTL
+	t.Cleanup(func() { message.PendingPfcpRequestTTL = origTTL })
+	message.PendingPfcpRequestTTL = 10 * time.Millisecond
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+
+	upNodeID := context.NewNodeID(upNodeIDStr)
+	upf := context.NewUPF(upNodeID, nil)
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+	smContext := &context.SMContext{
+		Ref: "urn:uuid:report-fetch-evicted",
+		PFCPContext: map[string]*context.PFCPSessionContext{
+			upNodeIDStr: {NodeID: *upNodeID, LocalSEID: 1, RemoteSEID: 2},
+		},
+		SubPduSessLog: zap.NewNop().Sugar(),
+		SubPfcpLog:    zap.NewNop().Sugar(),
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(upNodeIDStr), Port: upfPort})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			t.Logf("error closing connection: %v", err)
+		}
+	}()
+	udp.Server = &udp.PfcpServer{
+		Conn: conn,
+	}
+
+	probe := smContext.StartRecoveryProbe(*upNodeID)
+	if err = message.SendPfcpSessionReportFetchRequest(*upNodeID, smContext, upfPort); err != nil {
+		t.Fatalf("error sending PFCP Session Report Fetch Request: %v", err)
+	}
+	if count := upf.PendingPfcpRequestCount(); count != 1 {
+		t.Fatalf("expected 1 pending request, got %d", count)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	message.EvictExpiredPfcpTxns()
+	select {
+	case cause := <-probe:
+		if cause != context.CauseNoResponse {
+			t.Errorf("expected cause %d, got %d", context.CauseNoResponse, cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the failure of the evicted request to be handled")
+	}
+}
+
+func TestHandlePfcpSendErrorThrottledDeletion(t *testing.T) {
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+	smContext := context.NewSMContext("imsi-123456789012347", 12)
+	upf1 := &context.UPF{NodeID: *context.NewNodeID("3.3.3.1")}
+	upf2 := &context.UPF{NodeID: *context.NewNodeID("3.3.3.2")}
+	smContext.AllocateLocalSEIDForDataPath(&context.DataPath{
+		FirstDPNode: &context.DataPathNode{UPF: upf1},
+	})
+	smContext.AllocateLocalSEIDForDataPath(&context.DataPath{
+		FirstDPNode: &context.DataPathNode{UPF: upf2},
+	})
+	smContext.SMContextState = context.SmStatePfcpRelease
+	smContext.PendingUPF = context.PendingUPF{"3.3.3.1": true, "3.3.3.2": true}
+
+	for i, upfIP := range []string{"3.3.3.1", "3.3.3.2"} {
+		seid := smContext.PFCPContext[upfIP].LocalSEID
+		req := pfcp_message.NewSessionDeletionRequest(0, 0, seid, uint32(i+1), 0)
+		message.HandlePfcpSendError(req, udp.ErrPFCPThrottled)
+		if i == 0 && len(smContext.SBIPFCPCommunicationChan) != 0 {
+			t.Fatalf("expected no status while UPF 3.3.3.2 is pending")
+		}
+	}
+
+	select {
+	case status := <-smContext.SBIPFCPCommunicationChan:
+		if status != context.SessionReleaseSuccess {
+			t.Errorf("expected %v, got %v", context.SessionReleaseSuccess, status)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a status once no UPF is pending")
+	}
+
+	// a late failure never blocks its sender
+	message.HandlePfcpSendError(pfcp_message.NewSessionDeletionRequest(0, 0,
+		smContext.PFCPContext["3.3.3.1"].LocalSEID, 3, 0), udp.ErrPFCPThrottled)
+	message.HandlePfcpSendError(pfcp_message.NewSessionDeletionRequest(0, 0,
+		smContext.PFCPContext["3.3.3.1"].LocalSEID, 4, 0), udp.ErrPFCPThrottled)
+}
+
+// The messages of the UE reachability probe take their sequence number from the association of
+// the UPF and their response is delivered to the probe
+func TestSendPfcpReachabilityProbeRequest(t *testing.T) {
+	const upNodeIDStr = "127.0.0.3"
+	const upfPort = 8812
+	factory.SmfConfig = factory.Config{
+		Configuration: &factory.Configuration{},
+	}
+
+	upNodeID := context.NewNodeID(upNodeIDStr)
+	upf := context.NewUPF(upNodeID, nil)
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*upNodeID) })
+	smContext := &context.SMContext{
+		Ref: "urn:uuid:reachability-probe",
+		PFCPContext: map[string]*context.PFCPSessionContext{
+			upNodeIDStr: {NodeID: *upNodeID, LocalSEID: 1, RemoteSEID: 2},
+		},
+		PDUAddress:    &context.UeIpAddr{Ip: net.ParseIP("10.60.0.1").To4()},
+		SubPduSessLog: zap.NewNop().Sugar(),
+		SubPfcpLog:    zap.NewNop().Sugar(),
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(upNodeIDStr), Port: upfPort})
+	if err != nil {
+		t.Fatalf("error listening on UDP: %v", err)
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			t.Logf("error closing connection: %v", err)
+		}
+	}()
+	udp.Server = &udp.PfcpServer{
+		Conn: conn,
+	}
+
+	probe, err := message.SendPfcpReachabilityProbeRequest(*upNodeID, smContext, upfPort,
+		net.ParseIP("10.100.0.1"), 10, 11, 12)
+	if err != nil {
+		t.Fatalf("error sending PFCP UE reachability probe: %v", err)
+	}
+	if count := upf.PendingPfcpRequestCount(); count != 1 {
+		t.Fatalf("expected 1 pending request of the UPF, got %d", count)
+	}
+	buf := make([]byte, 1500)
+	if err = conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("error setting read deadline: %v", err)
+	}
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("error reading PFCP UE reachability probe: %v", err)
+	}
+	req, err := pfcp_message.ParseSessionModificationRequest(buf[:n])
+	if err != nil {
+		t.Fatalf("error parsing PFCP UE reachability probe: %v", err)
+	}
+	if next := upf.NextPfcpSeqNumber(0); next != req.Sequence()+1 {
+		t.Errorf("expected the sequence number of the UPF association, got %d then %d", req.Sequence(), next)
+	}
+
+	rsp := pfcp_message.NewSessionModificationResponse(0, 0, 1, req.Sequence(), 0, ie.NewCause(ie.CauseRequestAccepted))
+	if !smContext.CompleteReachabilityProbe(*upNodeID, req.Sequence(), rsp) {
+		t.Fatalf("expected the probe to be pending")
+	}
+	select {
+	case got := <-probe:
+		if got != rsp {
+			t.Errorf("expected the response of the UPF, got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the response to be delivered to the probe")
+	}
+}
@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package udp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// SetTransmitPfcp replaces the transmission of the PFCP messages until the end of the test
+func SetTransmitPfcp(t *testing.T, transmit func(message.Message, *net.UDPAddr, interface{}) error) {
+	t.Helper()
+	origTransmitPfcp := transmitPfcp
+	t.Cleanup(func() { transmitPfcp = origTransmitPfcp })
+	transmitPfcp = transmit
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package udp
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/smferrors"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// ErrPFCPThrottled is returned for a PFCP request dropped by the rate limiter of its UPF
+var ErrPFCPThrottled = smferrors.New(smferrors.ErrCodePFCPThrottled, "PFCP request throttled")
+
+// defaultPFCPQueueDeadline is the time a request is queued at most if not configured
+const defaultPFCPQueueDeadline = time.Second
+
+// pfcpRateLimiterQueueSize is the number of requests a limiter queues, the next ones being
+// dropped at once
+const pfcpRateLimiterQueueSize = 1024
+
+// PFCPRateLimiter sends the PFCP requests to a UPF at the rate of a token bucket. The requests
+// exceeding the rate are queued and sent in order, a request which would be sent after the queue
+// deadline is dropped with ErrPFCPThrottled. The requests are sent asynchronously, the sender
+// never waiting for the queue.
+type PFCPRateLimiter struct {
+	last     time.Time
+	queue    chan *throttledRequest
+	rate     float64
+	burst    float64
+	tokens   float64
+	deadline time.Duration
+}
+
+type throttledRequest struct {
+	queuedAt time.Time
+	send     func() error
+	fail     func(error)
+}
+
+// NewPFCPRateLimiter starts a limiter of the requests to a UPF, nil if the bucket has no burst
+func NewPFCPRateLimiter(config factory.PFCPRateLimit) *PFCPRateLimiter {
+	if config.Burst <= 0 {
+		return nil
+	}
+	rl := &PFCPRateLimiter{
+		last:     time.Now(),
+		queue:    make(chan *throttledRequest, pfcpRateLimiterQueueSize),
+		rate:     config.Rate,
+		burst:    float64(config.Burst),
+		tokens:   float64(config.Burst),
+		deadline: defaultPFCPQueueDeadline,
+	}
+	if config.QueueDeadlineMs > 0 {
+		rl.deadline = time.Duration(config.QueueDeadlineMs) * time.Millisecond
+	}
+	go rl.run()
+	return rl
+}
+
+// Send queues the request and returns without waiting for its send. The request is failed with
+// the error of its send, or with ErrPFCPThrottled if it is dropped, at once when the queue is full.
+func (rl *PFCPRateLimiter) Send(send func() error, fail func(error)) {
+	request := &throttledRequest{queuedAt: time.Now(), send: send, fail: fail}
+	select {
+	case rl.queue <- request:
+	default:
+		fail(ErrPFCPThrottled)
+	}
+}
+
+func (rl *PFCPRateLimiter) run() {
+	for request := range rl.queue {
+		for {
+			wait := rl.take()
+			if wait == 0 {
+				if err := request.send(); err != nil {
+					go request.fail(err)
+				}
+				break
+			}
+			if time.Since(request.queuedAt)+wait > rl.deadline {
+				go request.fail(ErrPFCPThrottled)
+				break
+			}
+			time.Sleep(wait)
+		}
+	}
+}
+
+// take takes a token from the bucket, it returns the time until the next token when it is empty
+func (rl *PFCPRateLimiter) take() time.Duration {
+	now := time.Now()
+	rl.tokens = math.Min(rl.burst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	rl.last = now
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+	if rl.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+}
+
+// rate limiters of the UPFs by IP address
+var (
+	rateLimiters     = make(map[string]*PFCPRateLimiter)
+	rateLimitersLock sync.Mutex
+)
+
+// rateLimiterFor returns the rate limiter of the UPF, nil if the PFCP requests are not limited
+func rateLimiterFor(addr *net.UDPAddr) *PFCPRateLimiter {
+	config := context.SMF_Self().PFCPRateLimit
+	if config == nil {
+		return nil
+	}
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+	rl, ok := rateLimiters[addr.IP.String()]
+	if !ok {
+		rl = NewPFCPRateLimiter(*config)
+		rateLimiters[addr.IP.String()] = rl
+	}
+	return rl
+}
+
+// isThrottledRequest is true for the session requests, the node messages keeping the PFCP
+// association alive are never throttled
+func isThrottledRequest(msg message.Message) bool {
+	switch msg.MessageType() {
+	case message.MsgTypeSessionEstablishmentRequest,
+		message.MsgTypeSessionModificationRequest,
+		message.MsgTypeSessionDeletionRequest:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_test
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/pfcp/udp"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+func TestPFCPRateLimiter(t *testing.T) {
+	rl := udp.NewPFCPRateLimiter(factory.PFCPRateLimit{
+		TokenBucket:     factory.TokenBucket{Rate: 20, Burst: 2},
+		QueueDeadlineMs: 500,
+	})
+
+	var lock sync.Mutex
+	var sentAt []time.Time
+	var throttled int
+	var wg sync.WaitGroup
+	for range 40 {
+		wg.Add(1)
+		start := time.Now()
+		rl.Send(func() error {
+			defer wg.Done()
+			lock.Lock()
+			defer lock.Unlock()
+			sentAt = append(sentAt, time.Now())
+			return nil
+		}, func(err error) {
+			defer wg.Done()
+			lock.Lock()
+			defer lock.Unlock()
+			if errors.Is(err, udp.ErrPFCPThrottled) {
+				throttled++
+			} else {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("expected the request queued at once, queued in %v", elapsed)
+		}
+	}
+	wg.Wait()
+
+	// the burst then 20 per second during the 500ms deadline
+	if len(sentAt) < 8 || len(sentAt) > 13 {
+		t.Errorf("expected about 12 requests sent, got %d", len(sentAt))
+	}
+	if throttled != 40-len(sentAt) {
+		t.Errorf("expected %d requests throttled, got %d", 40-len(sentAt), throttled)
+	}
+	slices.SortFunc(sentAt, func(a, b time.Time) int { return a.Compare(b) })
+	if elapsed, minimum := sentAt[len(sentAt)-1].Sub(sentAt[0]),
+		time.Duration(len(sentAt)-2)*45*time.Millisecond; elapsed < minimum {
+		t.Errorf("expected the requests after the burst sent at 20 per second, %d sent in %v", len(sentAt), elapsed)
+	}
+
+	if udp.NewPFCPRateLimiter(factory.PFCPRateLimit{TokenBucket: factory.TokenBucket{Rate: 20}}) != nil {
+		t.Error("expected no limiter without burst")
+	}
+}
+
+func TestSendPfcpRateLimited(t *testing.T) {
+	smfSelf := context.SMF_Self()
+	origRateLimit := smfSelf.PFCPRateLimit
+	defer func() { smfSelf.PFCPRateLimit = origRateLimit }()
+	smfSelf.PFCPRateLimit = &factory.PFCPRateLimit{
+		TokenBucket:     factory.TokenBucket{Rate: 10, Burst: 1},
+		QueueDeadlineMs: 300,
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	transmitted := make(map[uint32]bool)
+	udp.SetTransmitPfcp(t, func(msg message.Message, addr *net.UDPAddr, eventData interface{}) error {
+		lock.Lock()
+		defer lock.Unlock()
+		transmitted[msg.Sequence()] = true
+		if msg.MessageType() == message.MsgTypeSessionEstablishmentRequest {
+			wg.Done()
+		}
+		return nil
+	})
+	upfAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 8805}
+
+	// session establishments sent without waiting for the rate limiter, the dropped ones
+	// reported to their error handler
+	var throttled int
+	eventData := udp.PfcpEventData{ErrHandler: func(msg message.Message, err error) {
+		defer wg.Done()
+		lock.Lock()
+		defer lock.Unlock()
+		if errors.Is(err, udp.ErrPFCPThrottled) {
+			throttled++
+		} else {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}}
+	start := time.Now()
+	for i := range 20 {
+		wg.Add(1)
+		msg := message.NewSessionEstablishmentRequest(0, 0, 0, uint32(i+1), 0,
+			ie.NewNodeID("127.0.0.1", "", ""))
+		if err := udp.SendPfcp(msg, upfAddr, eventData); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected the session requests queued at once, queued in %v", elapsed)
+	}
+	wg.Wait()
+
+	// the burst then 10 per second during the 300ms deadline
+	lock.Lock()
+	sent := len(transmitted)
+	lock.Unlock()
+	if sent < 2 || sent > 5 {
+		t.Errorf("expected about 4 requests sent, got %d", sent)
+	}
+	if throttled != 20-sent {
+		t.Errorf("expected %d requests throttled, got %d", 20-sent, throttled)
+	}
+
+	// the node messages are not throttled
+	start = time.Now()
+	for i := range 5 {
+		if err := udp.SendPfcp(message.NewHeartbeatRequest(uint32(100+i), ie.NewRecoveryTimeStamp(start), nil),
+			upfAddr, nil); err != nil {
+			t.Errorf("failed to send the heartbeat request: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected the heartbeat requests sent at once, sent in %v", elapsed)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(transmitted) != sent+5 {
+		t.Errorf("expected %d messages sent, got %d", sent+5, len(transmitted))
+	}
+}
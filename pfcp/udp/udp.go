@@ -6,15 +6,21 @@
 package udp
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
 	"github.com/omec-project/smf/logger"
 	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/smferrors"
 	"github.com/wmnsk/go-pfcp/message"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 const PFCP_MAX_UDP_LEN = 2048
@@ -62,17 +68,43 @@ func Run(Dispatch func(*Message)) {
 		logger.PfcpLog.Errorf("Failed to listen on %s: %v", addr.String(), err)
 		return
 	}
-	Server = &PfcpServer{
+	if dscp := context.SMF_Self().PFCPDscp; dscp != 0 {
+		if err = SetDSCP(conn, dscp); err != nil {
+			logger.PfcpLog.Warnf("Failed to set DSCP %d on %s: %v", dscp, addr.String(), err)
+		} else {
+			logger.PfcpLog.Infof("PFCP packets marked with DSCP %d", dscp)
+		}
+	}
+	if recvSize, sendSize := context.SMF_Self().PFCPRecvBufferSize, context.SMF_Self().PFCPSendBufferSize; recvSize != 0 || sendSize != 0 {
+		if err = SetSocketBuffers(conn, recvSize, sendSize); err != nil {
+			logger.PfcpLog.Warnf("Failed to set socket buffers on %s: %v", addr.String(), err)
+		}
+		if recvSize, sendSize, err = SocketBuffers(conn); err != nil {
+			logger.PfcpLog.Warnf("Failed to read socket buffers on %s: %v", addr.String(), err)
+		} else {
+			logger.PfcpLog.Infof("PFCP socket buffers: receive %d bytes, send %d bytes", recvSize, sendSize)
+		}
+	}
+	server := &PfcpServer{
 		Addr: addr,
 		Conn: conn,
 	}
+	Server = server
 	logger.PfcpLog.Infof("Listen on %s", addr.String())
 
+	// a goroutine per message unless the messages are handled by a pool of workers
+	dispatch := func(msg *Message) { go Dispatch(msg) }
+	if workers := context.SMF_Self().PFCPWorkers; workers > 0 {
+		dispatch = NewWorkerPool(workers, Dispatch).Dispatch
+		logger.PfcpLog.Infof("PFCP messages handled by %d workers", workers)
+	}
+
+	// the server read from is the one started, whatever replaces the global afterwards
 	go func() {
 		for {
-			remoteAddr, pfcpMessage, eventData, err := readPfcpMessage()
+			remoteAddr, pfcpMessage, eventData, err := server.readPfcpMessage()
 			if err != nil {
-				if err.Error() == "Receive resend PFCP request" {
+				if errors.Is(err, ErrResentRequest) {
 					logger.PfcpLog.Infoln(err)
 				} else {
 					logger.PfcpLog.Warnf("Read PFCP error: %v", err)
@@ -80,13 +112,61 @@ func Run(Dispatch func(*Message)) {
 				continue
 			}
 			msg := NewMessage(remoteAddr, pfcpMessage, eventData)
-			go Dispatch(&msg)
+			dispatch(&msg)
 		}
 	}()
 
 	ServerStartTime = time.Now()
 }
 
+// SetDSCP marks the packets sent on the connection with the DSCP value,
+// in the ToS field for IPv4 or the Traffic Class for IPv6
+func SetDSCP(conn *net.UDPConn, dscp uint8) error {
+	if dscp > factory.MaxDSCP {
+		return fmt.Errorf("invalid DSCP %d, must be in range 0-%d", dscp, factory.MaxDSCP)
+	}
+	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if ok && localAddr.IP.To4() == nil && localAddr.IP.To16() != nil && !localAddr.IP.IsUnspecified() {
+		return ipv6.NewConn(conn).SetTrafficClass(int(dscp) << 2)
+	}
+	return ipv4.NewConn(conn).SetTOS(int(dscp) << 2)
+}
+
+// SetSocketBuffers requests the SO_RCVBUF and SO_SNDBUF sizes of the connection, a size of 0
+// keeps the system default. The kernel may cap the sizes, see SocketBuffers.
+func SetSocketBuffers(conn *net.UDPConn, recvSize, sendSize int) error {
+	if recvSize != 0 {
+		if err := conn.SetReadBuffer(recvSize); err != nil {
+			return fmt.Errorf("set receive buffer %d: %v", recvSize, err)
+		}
+	}
+	if sendSize != 0 {
+		if err := conn.SetWriteBuffer(sendSize); err != nil {
+			return fmt.Errorf("set send buffer %d: %v", sendSize, err)
+		}
+	}
+	return nil
+}
+
+// SocketBuffers returns the effective SO_RCVBUF and SO_SNDBUF sizes of the connection
+func SocketBuffers(conn *net.UDPConn) (recvSize, sendSize int, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if recvSize, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF); sockErr != nil {
+			return
+		}
+		sendSize, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return recvSize, sendSize, sockErr
+}
+
 func WaitForServer() error {
 	timeout := 10 * time.Second
 	t0 := time.Now()
@@ -102,11 +182,38 @@ func WaitForServer() error {
 	}
 }
 
+// ErrResentRequest is returned on the reception of a request already received, the response of
+// which is sent again
+var ErrResentRequest = smferrors.New(smferrors.ErrCodePFCPResentRequest, "receive resend PFCP request")
+
+// SendPfcp sends the PFCP message. A session request to a rate limited UPF is sent
+// asynchronously, its failure being reported to the ErrHandler of its event data as the failure
+// of its transaction is.
 func SendPfcp(msg message.Message, addr *net.UDPAddr, eventData interface{}) error {
-	if Server == nil {
+	if isThrottledRequest(msg) {
+		if rl := rateLimiterFor(addr); rl != nil {
+			rl.Send(func() error { return transmitPfcp(msg, addr, eventData) }, func(err error) {
+				if errors.Is(err, ErrPFCPThrottled) {
+					logger.PfcpLog.Warnf("PFCP %s to %s throttled", msg.MessageTypeName(), addr)
+					metrics.IncrementN4MsgStats(context.SMF_Self().NfInstanceID, msg.MessageTypeName(), "Out", "Failure", err.Error())
+				}
+				notifySendError(msg, eventData, err)
+			})
+			return nil
+		}
+	}
+	return transmitPfcp(msg, addr, eventData)
+}
+
+// transmitPfcp starts the transaction of the PFCP message, replaced in tests
+var transmitPfcp = sendPfcp
+
+func sendPfcp(msg message.Message, addr *net.UDPAddr, eventData interface{}) error {
+	server := Server
+	if server == nil {
 		return fmt.Errorf("PFCP server is not initialized")
 	}
-	if Server.Conn == nil {
+	if server.Conn == nil {
 		return fmt.Errorf("PFCP server is not listening")
 	}
 
@@ -116,28 +223,25 @@ func SendPfcp(msg message.Message, addr *net.UDPAddr, eventData interface{}) err
 		return err
 	}
 
-	tx := NewTransaction(msg, buf, Server.Conn, addr, eventData)
-	err = PutTransaction(tx)
+	tx := NewTransaction(msg, buf, server.Conn, addr, eventData)
+	err = server.putTransaction(tx)
 	if err != nil {
 		logger.PfcpLog.Errorf("Failed to send PFCP message: %v", err)
 		metrics.IncrementN4MsgStats(context.SMF_Self().NfInstanceID, msg.MessageTypeName(), "Out", "Failure", err.Error())
 		return err
 	}
-	go startTxLifeCycle(tx)
+	go server.startTxLifeCycle(tx)
 	metrics.IncrementN4MsgStats(context.SMF_Self().NfInstanceID, msg.MessageTypeName(), "Out", "Success", "")
 	return nil
 }
 
-func readPfcpMessage() (*net.UDPAddr, message.Message, interface{}, error) {
-	if Server == nil {
-		return nil, nil, nil, fmt.Errorf("PFCP server is not initialized")
-	}
-	if Server.Conn == nil {
+func (server *PfcpServer) readPfcpMessage() (*net.UDPAddr, message.Message, interface{}, error) {
+	if server.Conn == nil {
 		return nil, nil, nil, fmt.Errorf("PFCP server is not listening")
 	}
 
 	buf := make([]byte, PFCP_MAX_UDP_LEN)
-	n, addr, err := Server.Conn.ReadFromUDP(buf)
+	n, addr, err := server.Conn.ReadFromUDP(buf)
 	if err != nil {
 		return addr, nil, nil, err
 	}
@@ -151,12 +255,12 @@ func readPfcpMessage() (*net.UDPAddr, message.Message, interface{}, error) {
 	var eventData interface{}
 	if IsRequest(msg) {
 		// Todo: Implement SendingResponse type of reliable delivery
-		tx, err := findTransaction(msg, addr)
+		tx, err := server.findTransaction(msg, addr)
 		if err != nil {
 			return addr, msg, nil, err
 		} else if tx != nil {
 			// err == nil && tx != nil => Resend Request
-			err = fmt.Errorf("receive resend PFCP request")
+			err = ErrResentRequest
 			tx.EventChannel <- ReceiveResendRequest
 			return addr, msg, nil, err
 		} else {
@@ -164,7 +268,7 @@ func readPfcpMessage() (*net.UDPAddr, message.Message, interface{}, error) {
 			return addr, msg, nil, nil
 		}
 	} else if IsResponse(msg) {
-		tx, err := findTransaction(msg, Server.Addr)
+		tx, err := server.findTransaction(msg, server.Addr)
 		if err != nil {
 			return addr, msg, nil, err
 		}
@@ -175,20 +279,16 @@ func readPfcpMessage() (*net.UDPAddr, message.Message, interface{}, error) {
 	return addr, msg, eventData, nil
 }
 
-func findTransaction(msg message.Message, addr *net.UDPAddr) (*Transaction, error) {
+func (server *PfcpServer) findTransaction(msg message.Message, addr *net.UDPAddr) (*Transaction, error) {
 	var tx *Transaction
 	consumerAddr := addr.String()
 
-	if Server == nil {
-		return nil, fmt.Errorf("PFCP server is not initialized")
-	}
-
 	if IsResponse(msg) {
-		if _, exist := Server.ConsumerTable.Load(consumerAddr); !exist {
+		if _, exist := server.ConsumerTable.Load(consumerAddr); !exist {
 			return nil, fmt.Errorf("txTable not found")
 		}
 
-		txTable, _ := Server.ConsumerTable.Load(consumerAddr)
+		txTable, _ := server.ConsumerTable.Load(consumerAddr)
 		seqNum := msg.Sequence()
 
 		if _, exist := txTable.Load(seqNum); !exist {
@@ -197,10 +297,10 @@ func findTransaction(msg message.Message, addr *net.UDPAddr) (*Transaction, erro
 
 		tx, _ = txTable.Load(seqNum)
 	} else if IsRequest(msg) {
-		if _, exist := Server.ConsumerTable.Load(consumerAddr); !exist {
+		if _, exist := server.ConsumerTable.Load(consumerAddr); !exist {
 			return nil, nil
 		}
-		txTable, _ := Server.ConsumerTable.Load(consumerAddr)
+		txTable, _ := server.ConsumerTable.Load(consumerAddr)
 		seqNum := msg.Sequence()
 		if _, exist := txTable.Load(seqNum); !exist {
 			return nil, nil
@@ -211,11 +311,19 @@ func findTransaction(msg message.Message, addr *net.UDPAddr) (*Transaction, erro
 }
 
 func PutTransaction(tx *Transaction) error {
+	server := Server
+	if server == nil {
+		return fmt.Errorf("PFCP server is not initialized")
+	}
+	return server.putTransaction(tx)
+}
+
+func (server *PfcpServer) putTransaction(tx *Transaction) error {
 	consumerAddr := tx.ConsumerAddr
-	if _, exist := Server.ConsumerTable.Load(consumerAddr); !exist {
-		Server.ConsumerTable.Store(consumerAddr, &TxTable{})
+	if _, exist := server.ConsumerTable.Load(consumerAddr); !exist {
+		server.ConsumerTable.Store(consumerAddr, &TxTable{})
 	}
-	txTable, _ := Server.ConsumerTable.Load(consumerAddr)
+	txTable, _ := server.ConsumerTable.Load(consumerAddr)
 	if _, exist := txTable.Load(tx.SequenceNumber); !exist {
 		txTable.Store(tx.SequenceNumber, tx)
 	} else {
@@ -224,34 +332,37 @@ func PutTransaction(tx *Transaction) error {
 	return nil
 }
 
-func startTxLifeCycle(tx *Transaction) {
+func (server *PfcpServer) startTxLifeCycle(tx *Transaction) {
 	sendErr := tx.Start()
 
-	err := removeTransaction(tx)
+	err := server.removeTransaction(tx)
 	if err != nil {
 		logger.PfcpLog.Warnln(err)
 	}
 
 	if sendErr != nil && tx.EventData != nil {
-		if eventData, ok := tx.EventData.(PfcpEventData); ok {
-			if errHandler := eventData.ErrHandler; errHandler != nil {
-				msg, err := message.Parse(tx.SendMsg)
-				if err != nil {
-					logger.PfcpLog.Warnf("Parse message error: %v", err)
-					return
-				}
-				errHandler(msg, sendErr)
-			}
+		msg, err := message.Parse(tx.SendMsg)
+		if err != nil {
+			logger.PfcpLog.Warnf("Parse message error: %v", err)
+			return
 		}
+		notifySendError(msg, tx.EventData, sendErr)
 	}
 }
 
-func removeTransaction(tx *Transaction) error {
-	if Server == nil {
-		return fmt.Errorf("PFCP server is not initialized")
+// notifySendError reports the failure of the send of the message to the ErrHandler of its
+// event data, if any
+func notifySendError(msg message.Message, eventData interface{}, sendErr error) {
+	if eventData, ok := eventData.(PfcpEventData); ok {
+		if errHandler := eventData.ErrHandler; errHandler != nil {
+			errHandler(msg, sendErr)
+		}
 	}
+}
+
+func (server *PfcpServer) removeTransaction(tx *Transaction) error {
 	consumerAddr := tx.ConsumerAddr
-	txTable, _ := Server.ConsumerTable.Load(consumerAddr)
+	txTable, _ := server.ConsumerTable.Load(consumerAddr)
 
 	if txTmp, exist := txTable.Load(tx.SequenceNumber); exist {
 		tx = txTmp
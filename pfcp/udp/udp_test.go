@@ -14,6 +14,7 @@ import (
 	"github.com/omec-project/smf/pfcp/udp"
 	"github.com/wmnsk/go-pfcp/ie"
 	"github.com/wmnsk/go-pfcp/message"
+	"golang.org/x/net/ipv4"
 )
 
 var heartbeatRequestReceived bool
@@ -121,6 +122,49 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRunServerReplaced(t *testing.T) {
+	context.SMF_Self().CPNodeID = context.NodeID{
+		NodeIdType:  context.NodeIdTypeIpv4Address,
+		NodeIdValue: net.ParseIP("127.0.0.1").To4(),
+	}
+	context.SMF_Self().PFCPPort = 8813
+	origServer := udp.Server
+	udp.Server = nil
+	go udp.Run(Dispatch)
+	if err := udp.WaitForServer(); err != nil {
+		t.Fatalf("failed to start PFCP server: %v", err)
+	}
+	started := udp.Server
+	defer func() {
+		if err := started.Conn.Close(); err != nil {
+			t.Logf("error closing connection: %v", err)
+		}
+		udp.Server = origServer
+	}()
+
+	// the server started keeps reading once the global is replaced
+	udp.Server = &udp.PfcpServer{}
+	server := &Server{addr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1235}}
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Conn.Close()
+	for sequence := range uint32(2) {
+		heartbeatRequestReceived = false
+		err := server.SendPFCPMessage(message.NewHeartbeatRequest(sequence+1, ie.NewRecoveryTimeStamp(time.Now()), nil),
+			&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8813})
+		if err != nil {
+			t.Fatalf("failed to send PFCP message: %v", err)
+		}
+
+		time.Sleep(300 * time.Millisecond)
+
+		if !heartbeatRequestReceived {
+			t.Errorf("expected Heartbeat Request %d to be received", sequence+1)
+		}
+	}
+}
+
 func TestServerSendPfcp(t *testing.T) {
 	localAddress := &net.UDPAddr{
 		IP:   net.ParseIP("127.0.0.1"),
@@ -169,3 +213,59 @@ func TestServerNotSetSendPfcp(t *testing.T) {
 		t.Error("expected error, got nil")
 	}
 }
+
+func TestSetDSCP(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err = udp.SetDSCP(conn, 46); err != nil {
+		t.Skipf("setting the ToS is not supported on this platform: %v", err)
+	}
+	tos, err := ipv4.NewConn(conn).TOS()
+	if err != nil {
+		t.Skipf("reading the ToS is not supported on this platform: %v", err)
+	}
+	if tos != 46<<2 {
+		t.Errorf("expected ToS %d, got %d", 46<<2, tos)
+	}
+
+	if err = udp.SetDSCP(conn, 64); err == nil {
+		t.Errorf("expected error for DSCP out of range")
+	}
+}
+
+func TestSetSocketBuffers(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer conn.Close()
+
+	// below the default rmem_max and wmem_max of Linux, so that the kernel does not cap them
+	const recvSize, sendSize = 128 * 1024, 96 * 1024
+	if err = udp.SetSocketBuffers(conn, recvSize, sendSize); err != nil {
+		t.Fatalf("set socket buffers failed: %v", err)
+	}
+	effectiveRecv, effectiveSend, err := udp.SocketBuffers(conn)
+	if err != nil {
+		t.Skipf("reading the socket buffers is not supported on this platform: %v", err)
+	}
+	// Linux doubles the requested sizes for its bookkeeping
+	if effectiveRecv < recvSize {
+		t.Errorf("expected receive buffer of at least %d, got %d", recvSize, effectiveRecv)
+	}
+	if effectiveSend < sendSize {
+		t.Errorf("expected send buffer of at least %d, got %d", sendSize, effectiveSend)
+	}
+
+	// a size of 0 keeps the current buffers
+	if err = udp.SetSocketBuffers(conn, 0, 0); err != nil {
+		t.Fatalf("set socket buffers failed: %v", err)
+	}
+	if recv, send, _ := udp.SocketBuffers(conn); recv != effectiveRecv || send != effectiveSend {
+		t.Errorf("expected buffers %d/%d to be kept, got %d/%d", effectiveRecv, effectiveSend, recv, send)
+	}
+}
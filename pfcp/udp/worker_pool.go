@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package udp
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// workerQueueSize is the number of received messages each worker holds before the reading of
+// the socket blocks
+const workerQueueSize = 1024
+
+// WorkerPool handles the received PFCP messages with a fixed number of workers. The messages of a
+// remote node are all handled by the same worker, in the order they were received, so that the
+// messages of a PFCP association are not reordered. The Session Report Requests are handled out
+// of the workers: their handler waits for the lock of the session, which a procedure of the
+// session may hold while it waits for a response of the same UPF, queued behind the report.
+// The outgoing requests are not dispatched through the pool: they are sent from the goroutine of
+// their procedure, each transaction retransmitted by its own goroutine, and do not queue up.
+type WorkerPool struct {
+	queues   []chan *Message
+	dispatch func(*Message)
+	wg       sync.WaitGroup
+}
+
+// NewWorkerPool starts the workers handling the messages with the dispatch function
+func NewWorkerPool(workers int, dispatch func(*Message)) *WorkerPool {
+	pool := &WorkerPool{queues: make([]chan *Message, workers), dispatch: dispatch}
+	for i := range pool.queues {
+		queue := make(chan *Message, workerQueueSize)
+		pool.queues[i] = queue
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for msg := range queue {
+				dispatch(msg)
+			}
+		}()
+	}
+	return pool
+}
+
+// Dispatch queues the message to the worker of its remote node, a Session Report Request being
+// handled in its own goroutine
+func (pool *WorkerPool) Dispatch(msg *Message) {
+	if msg.PfcpMessage.MessageType() == message.MsgTypeSessionReportRequest {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			pool.dispatch(msg)
+		}()
+		return
+	}
+	hash := fnv.New32a()
+	if msg.RemoteAddr != nil {
+		hash.Write(msg.RemoteAddr.IP)
+	}
+	pool.queues[hash.Sum32()%uint32(len(pool.queues))] <- msg
+}
+
+// Stop waits for the queued messages and the Session Report Requests to be handled and stops the
+// workers
+func (pool *WorkerPool) Stop() {
+	for _, queue := range pool.queues {
+		close(queue)
+	}
+	pool.wg.Wait()
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package udp_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/pfcp/udp"
+	"github.com/wmnsk/go-pfcp/ie"
+	"github.com/wmnsk/go-pfcp/message"
+)
+
+// dispatchHeartbeats sends perUPF Heartbeat Responses of each of the UPFs to a pool of workers
+// taking the delay to handle a message, and returns the time taken and the sequence numbers
+// handled by UPF
+func dispatchHeartbeats(workers, upfs, perUPF int, delay time.Duration) (time.Duration, map[string][]uint32) {
+	var mu sync.Mutex
+	handled := make(map[string][]uint32)
+	pool := udp.NewWorkerPool(workers, func(msg *udp.Message) {
+		time.Sleep(delay)
+		mu.Lock()
+		defer mu.Unlock()
+		handled[msg.RemoteAddr.IP.String()] = append(handled[msg.RemoteAddr.IP.String()], msg.PfcpMessage.Sequence())
+	})
+
+	start := time.Now()
+	for seq := 1; seq <= perUPF; seq++ {
+		for upf := 1; upf <= upfs; upf++ {
+			// the sequence numbers of each UPF identify it, so that a response correlated to
+			// another UPF is detected
+			msg := udp.NewMessage(
+				&net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(upf)), Port: 8805},
+				message.NewHeartbeatResponse(uint32(upf*1000+seq), ie.NewRecoveryTimeStamp(start)),
+				nil,
+			)
+			pool.Dispatch(&msg)
+		}
+	}
+	pool.Stop()
+	return time.Since(start), handled
+}
+
+func TestWorkerPool(t *testing.T) {
+	const upfs, perUPF, delay = 8, 10, 2 * time.Millisecond
+	_, handled := dispatchHeartbeats(upfs, upfs, perUPF, delay)
+
+	// the messages of each UPF are all handled, in order
+	if len(handled) != upfs {
+		t.Fatalf("Expected the messages of %d UPFs, got %d", upfs, len(handled))
+	}
+	for upf := 1; upf <= upfs; upf++ {
+		ip := net.IPv4(10, 0, 0, byte(upf)).String()
+		if len(handled[ip]) != perUPF {
+			t.Fatalf("Expected %d messages of UPF[%s], got %d", perUPF, ip, len(handled[ip]))
+		}
+		for i, seq := range handled[ip] {
+			if expected := uint32(upf*1000 + i + 1); seq != expected {
+				t.Errorf("Expected message %d of UPF[%s] to be seq %d, got %d", i, ip, expected, seq)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolConcurrency(t *testing.T) {
+	const upfs = 64
+	for _, workers := range []int{1, 4, 8} {
+		t.Run(fmt.Sprintf("workers-%d", workers), func(t *testing.T) {
+			var mu sync.Mutex
+			active, peak := 0, 0
+			release := make(chan struct{})
+			pool := udp.NewWorkerPool(workers, func(msg *udp.Message) {
+				mu.Lock()
+				active++
+				peak = max(peak, active)
+				mu.Unlock()
+				<-release
+				mu.Lock()
+				active--
+				mu.Unlock()
+			})
+			for upf := 1; upf <= upfs; upf++ {
+				msg := udp.NewMessage(
+					&net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(upf)), Port: 8805},
+					message.NewHeartbeatResponse(uint32(upf), ie.NewRecoveryTimeStamp(time.Now())),
+					nil,
+				)
+				pool.Dispatch(&msg)
+			}
+
+			// the messages of the UPFs are handled by all the workers at a time, and no more,
+			// however long the workers take to be scheduled
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				mu.Lock()
+				reached := peak
+				mu.Unlock()
+				if reached >= workers || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+			pool.Stop()
+			if peak != workers {
+				t.Errorf("Expected %d messages handled at a time, got %d", workers, peak)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolSessionReport(t *testing.T) {
+	upf := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8805}
+	responded := make(chan struct{})
+	reported := make(chan struct{})
+	pool := udp.NewWorkerPool(1, func(msg *udp.Message) {
+		switch msg.PfcpMessage.MessageType() {
+		case message.MsgTypeSessionReportRequest:
+			// the lock of the session is held until the response of the UPF is handled
+			select {
+			case <-responded:
+				close(reported)
+			case <-time.After(time.Second):
+			}
+		case message.MsgTypeSessionModificationResponse:
+			close(responded)
+		}
+	})
+
+	// the report handled first does not hold up the response queued behind it
+	report := udp.NewMessage(upf, message.NewSessionReportRequest(0, 0, 1, 1, 0), nil)
+	pool.Dispatch(&report)
+	response := udp.NewMessage(upf, message.NewSessionModificationResponse(0, 0, 1, 2, 0), nil)
+	pool.Dispatch(&response)
+	pool.Stop()
+
+	select {
+	case <-reported:
+	default:
+		t.Errorf("Expected the session report handled once the response is handled")
+	}
+}
+
+func BenchmarkWorkerPool(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			for range b.N {
+				dispatchHeartbeats(workers, 8, 10, 100*time.Microsecond)
+			}
+		})
+	}
+}
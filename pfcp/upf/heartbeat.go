@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/pfcp/message"
+	pfcp_message "github.com/wmnsk/go-pfcp/message"
+)
+
+// heartbeatTick is the resolution of the heartbeat schedule
+const heartbeatTick = 100 * time.Millisecond
+
+// sendHeartbeatRequest sends the PFCP Heartbeat Request to the UPF, replaced in tests
+var sendHeartbeatRequest = message.SendHeartbeatRequest
+
+// heartbeatSchedule holds the time of the next heartbeat of each associated UPF. The first
+// heartbeat of an association is delayed by a random jitter within the interval, so that the
+// associations set up together, as after a restart of the SMF, do not send their heartbeats in a
+// burst. The next heartbeats follow every interval, each one randomly advanced or delayed by up to
+// the spread fraction of the interval so that the heartbeats of the UPFs keep apart.
+type heartbeatSchedule struct {
+	next     map[*context.UPF]time.Time
+	interval time.Duration
+	// fraction of the interval in [0, 1)
+	spread float64
+	// random jitter in [0, n), replaced in tests
+	jitter func(n int64) int64
+}
+
+func newHeartbeatSchedule(interval time.Duration, spread float64) *heartbeatSchedule {
+	return &heartbeatSchedule{
+		next:     make(map[*context.UPF]time.Time),
+		interval: interval,
+		spread:   spread,
+		jitter:   rand.Int63n,
+	}
+}
+
+// nextInterval returns the time to the next heartbeat, the interval randomly shortened or
+// lengthened by up to its spread fraction
+func (s *heartbeatSchedule) nextInterval() time.Duration {
+	span := int64(s.spread * float64(s.interval))
+	if span <= 0 {
+		return s.interval
+	}
+	return s.interval + time.Duration(s.jitter(2*span+1)-span)
+}
+
+// due returns true if the heartbeat of the associated UPF is to be sent at now, and schedules
+// the next one. The first heartbeat of the association is scheduled on its first check.
+func (s *heartbeatSchedule) due(upf *context.UPF, now time.Time) bool {
+	next, ok := s.next[upf]
+	if !ok {
+		next = now.Add(time.Duration(s.jitter(int64(s.interval))))
+		s.next[upf] = next
+	}
+	if now.Before(next) {
+		return false
+	}
+	// spaced from the first heartbeat, whatever the tick it was sent on
+	for !now.Before(next) {
+		next = next.Add(s.nextInterval())
+	}
+	s.next[upf] = next
+	return true
+}
+
+// sendDueHeartbeats sends the heartbeats due at now to the associated UPFs
+func (s *heartbeatSchedule) sendDueHeartbeats(userplane *context.UserPlaneInformation, now time.Time) {
+	associated := make(map[*context.UPF]bool, len(userplane.UPFs))
+	for _, upf := range userplane.UPFs {
+		upf.UPF.UpfLock.Lock()
+		if upf.UPF.UPFStatus == context.AssociatedSetUpSuccess {
+			associated[upf.UPF] = true
+			if s.due(upf.UPF, now) {
+				sendHeartbeat(upf)
+			}
+		}
+		upf.UPF.UpfLock.Unlock()
+	}
+
+	// a new association gets a new jitter
+	for upf := range s.next {
+		if !associated[upf] {
+			delete(s.next, upf)
+		}
+	}
+}
+
+// sendHeartbeat sends the heartbeat to the UPF, which is marked as not associated once it did
+// not answer the last heartbeats
+func sendHeartbeat(upf *context.UPNode) {
+	if upf.UPF.NHeartBeat < maxHeartbeatRetry {
+		err := sendHeartbeatRequest(upf.NodeID, upf.Port) // needs lock in sync rsp(adapter mode)
+		if err != nil {
+			logger.PfcpLog.Errorf("send pfcp heartbeat request failed: %v for UPF[%v, %v]: ", err, upf.NodeID, upf.NodeID.ResolveNodeIdToIp())
+		} else {
+			upf.UPF.NHeartBeat++
+		}
+		return
+	}
+	logger.PfcpLog.Errorf("pfcp heartbeat failure for UPF: [%v]", upf.NodeID)
+	heartbeatRequest := pfcp_message.HeartbeatRequest{}
+	metrics.IncrementN4MsgStats(context.SMF_Self().NfInstanceID, heartbeatRequest.MessageTypeName(), "Out", "Failure", "Timeout")
+	upf.UPF.UPFStatus = context.NotAssociated
+	upf.UPF.RecordError(fmt.Errorf("no PFCP Heartbeat Response after %d requests", maxHeartbeatRetry))
+}
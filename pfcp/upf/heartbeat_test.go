@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+// heartbeatRecorder records the heartbeats sent to each UPF at the time of the schedule
+type heartbeatRecorder struct {
+	now  time.Time
+	sent map[string][]time.Time
+}
+
+// newHeartbeatTestUserPlane returns n associated UPFs answering every heartbeat
+func newHeartbeatTestUserPlane(t *testing.T, n int) (*context.UserPlaneInformation, *heartbeatRecorder) {
+	t.Helper()
+	userplane := &context.UserPlaneInformation{UPFs: make(map[string]*context.UPNode)}
+	for i := range n {
+		ip := fmt.Sprintf("10.0.%d.%d", 7+i/250, 1+i%250)
+		nodeID := *context.NewNodeID(ip)
+		userplane.UPFs[ip] = &context.UPNode{
+			Type:   context.UPNODE_UPF,
+			NodeID: nodeID,
+			UPF:    &context.UPF{NodeID: nodeID, UPFStatus: context.AssociatedSetUpSuccess},
+		}
+	}
+
+	origSendHeartbeatRequest := sendHeartbeatRequest
+	t.Cleanup(func() { sendHeartbeatRequest = origSendHeartbeatRequest })
+	recorder := &heartbeatRecorder{sent: make(map[string][]time.Time)}
+	sendHeartbeatRequest = func(upNodeID context.NodeID, upfPort uint16) error {
+		ip := upNodeID.ResolveNodeIdToIp().String()
+		recorder.sent[ip] = append(recorder.sent[ip], recorder.now)
+		return nil
+	}
+	return userplane, recorder
+}
+
+// answerHeartbeats resets the heartbeat attempts of the UPFs, as on their heartbeat responses
+func answerHeartbeats(userplane *context.UserPlaneInformation) {
+	for _, upf := range userplane.UPFs {
+		upf.UPF.NHeartBeat = 0
+	}
+}
+
+func TestHeartbeatJitter(t *testing.T) {
+	const (
+		interval = 10 * time.Second
+		upfs     = 100
+		bins     = 10
+	)
+	userplane, recorder := newHeartbeatTestUserPlane(t, upfs)
+	schedule := newHeartbeatSchedule(interval, 0)
+	schedule.jitter = rand.New(rand.NewSource(1)).Int63n
+
+	// all the associations set up at start
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for now := start; now.Before(start.Add(3*interval + heartbeatTick)); now = now.Add(heartbeatTick) {
+		recorder.now = now
+		schedule.sendDueHeartbeats(userplane, now)
+		answerHeartbeats(userplane)
+	}
+
+	require.Len(t, recorder.sent, upfs)
+	histogram := make([]int, bins)
+	for ip, sent := range recorder.sent {
+		require.Len(t, sent, 3, "UPF %s", ip)
+		first := sent[0].Sub(start)
+		require.GreaterOrEqual(t, first, time.Duration(0))
+		// sent on the first tick after the jitter
+		require.Less(t, first, interval+heartbeatTick, "first heartbeat of UPF %s within the interval", ip)
+		histogram[min(int(first*bins/interval), bins-1)]++
+
+		// the next heartbeats are evenly spaced
+		require.Equal(t, interval, sent[1].Sub(sent[0]), "UPF %s", ip)
+		require.Equal(t, interval, sent[2].Sub(sent[1]), "UPF %s", ip)
+	}
+
+	// the first heartbeats are spread over the interval, not sent in a burst
+	for bin, count := range histogram {
+		require.InDelta(t, upfs/bins, count, upfs/bins/2, "first heartbeats in bin %d of %v", bin, histogram)
+	}
+}
+
+func TestHeartbeatSpread(t *testing.T) {
+	const (
+		interval = 10 * time.Second
+		spread   = 0.2
+		upfs     = 100
+		rounds   = 4
+	)
+	userplane, recorder := newHeartbeatTestUserPlane(t, upfs)
+	schedule := newHeartbeatSchedule(interval, spread)
+	rng := rand.New(rand.NewSource(1))
+	schedule.jitter = func(n int64) int64 {
+		if n == int64(interval) {
+			// the first heartbeats all sent together
+			return 0
+		}
+		return rng.Int63n(n)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(rounds * interval)
+	for now := start; now.Before(end); now = now.Add(heartbeatTick) {
+		recorder.now = now
+		schedule.sendDueHeartbeats(userplane, now)
+		answerHeartbeats(userplane)
+	}
+
+	span := time.Duration(spread * float64(interval))
+	simultaneous := make(map[time.Time]int)
+	for ip, sent := range recorder.sent {
+		require.GreaterOrEqual(t, len(sent), rounds-1, "UPF %s", ip)
+		require.Equal(t, start, sent[0], "UPF %s", ip)
+		for i := 1; i < len(sent); i++ {
+			gap := sent[i].Sub(sent[i-1])
+			require.GreaterOrEqual(t, gap, interval-span-heartbeatTick, "UPF %s", ip)
+			require.LessOrEqual(t, gap, interval+span+heartbeatTick, "UPF %s", ip)
+		}
+		simultaneous[sent[rounds-2]]++
+	}
+
+	// the heartbeats sent together at first are spread over the ticks of the jitter
+	require.Greater(t, len(simultaneous), upfs/4, "distinct times of the heartbeats %v", simultaneous)
+	for at, count := range simultaneous {
+		require.LessOrEqual(t, count, upfs/10, "heartbeats sent at %v", at)
+	}
+}
+
+func TestHeartbeatScheduleNewAssociation(t *testing.T) {
+	const interval = 10 * time.Second
+	userplane, recorder := newHeartbeatTestUserPlane(t, 1)
+	upf := userplane.UPFs["10.0.7.1"].UPF
+	schedule := newHeartbeatSchedule(interval, 0)
+	jitters := []int64{int64(2 * time.Second), int64(7 * time.Second)}
+	schedule.jitter = func(n int64) int64 {
+		require.Equal(t, int64(interval), n)
+		jitter := jitters[0]
+		jitters = jitters[1:]
+		return jitter
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tick := func(offset time.Duration) {
+		recorder.now = start.Add(offset)
+		schedule.sendDueHeartbeats(userplane, recorder.now)
+		answerHeartbeats(userplane)
+	}
+	tick(0)
+	tick(time.Second)
+	require.Empty(t, recorder.sent["10.0.7.1"], "first heartbeat delayed by the jitter")
+	tick(2 * time.Second)
+	require.Equal(t, []time.Time{start.Add(2 * time.Second)}, recorder.sent["10.0.7.1"])
+
+	// the UPF is released, then associated again with a new jitter
+	upf.UPFStatus = context.NotAssociated
+	tick(5 * time.Second)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	tick(6 * time.Second)
+	tick(12 * time.Second)
+	require.Len(t, recorder.sent["10.0.7.1"], 1, "not sent at the former schedule")
+	tick(13 * time.Second)
+	require.Equal(t, start.Add(13*time.Second), recorder.sent["10.0.7.1"][1])
+}
+
+func TestHeartbeatFailure(t *testing.T) {
+	userplane, recorder := newHeartbeatTestUserPlane(t, 1)
+	upf := userplane.UPFs["10.0.7.1"].UPF
+	schedule := newHeartbeatSchedule(time.Second, 0)
+	schedule.jitter = func(int64) int64 { return 0 }
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range maxHeartbeatRetry + 1 {
+		schedule.sendDueHeartbeats(userplane, start.Add(time.Duration(i)*time.Second))
+	}
+	require.Len(t, recorder.sent["10.0.7.1"], maxHeartbeatRetry)
+	require.Equal(t, context.NotAssociated, upf.UPFStatus, "no answer to the last heartbeats")
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/pfcp/message"
+	"github.com/wmnsk/go-pfcp/ie"
+	pfcp_message "github.com/wmnsk/go-pfcp/message"
+)
+
+// DefaultReachabilityProbeWindow is the time given to the UE to reply to the echo requests of the
+// responder, when the probe sets none
+const DefaultReachabilityProbeWindow = 2 * time.Second
+
+// MaxReachabilityProbeWindow is the longest time given to the UE to reply, the probe being run
+// while its caller waits
+const MaxReachabilityProbeWindow = 30 * time.Second
+
+var errNoProbeResponse = errors.New("no response from UPF")
+
+var (
+	sendReachabilityProbe        = message.SendPfcpReachabilityProbeRequest
+	sendReachabilityProbeRemoval = message.SendPfcpReachabilityProbeRemovalRequest
+	// sendReachabilityRouting sends the downlink FARs of the session routed by the outcome of the probe
+	sendReachabilityRouting = func(upf *context.UPF, smContext *context.SMContext, farList []*context.FAR) error {
+		return message.SendPfcpSessionModificationRequest(upf.NodeID, smContext, nil, farList, nil, nil, upf.Port)
+	}
+	// time to wait for each UPF response of the UE reachability probe
+	ReachabilityProbeResponseTimeout = 5 * time.Second
+)
+
+// UEReachabilityProbe checks that the UE of a session answers the ICMP echo requests of a
+// responder. The echo replies of the UE are redirected to the responder and counted by a URR on
+// the AN UPF of the session.
+type UEReachabilityProbe struct {
+	ResponderIP net.IP
+	// Window is the time given to the UE to reply before the URR is collected, up to
+	// MaxReachabilityProbeWindow
+	Window time.Duration
+}
+
+// probeRules are the rules of the probe on the UPF
+type probeRules struct {
+	upf *context.UPF
+	pdr *context.PDR
+	urr *context.URR
+}
+
+// CheckUEReachability reports whether the UE of the session replied to the responder within the
+// probe window. The outcome is recorded in the UEReachability of the session, and the downlink
+// packets of the session are dropped by the AN UPF while the UE is unreachable.
+func (p *UEReachabilityProbe) CheckUEReachability(smContext *context.SMContext) (bool, error) {
+	if p.ResponderIP.To4() == nil {
+		return false, fmt.Errorf("invalid ICMP echo responder address [%v]", p.ResponderIP)
+	}
+	window := p.Window
+	if window == 0 {
+		window = DefaultReachabilityProbeWindow
+	}
+	if window < 0 || window > MaxReachabilityProbeWindow {
+		return false, fmt.Errorf("invalid probe window %v, up to %v", p.Window, MaxReachabilityProbeWindow)
+	}
+
+	smContext.SMLock.Lock()
+	rules, installed, err := p.install(smContext)
+	smContext.SMLock.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	// rules rejected by the UPF are not installed, the ones not answered may be and are removed
+	_, installErr := waitProbeResponse(installed)
+	if installErr != nil && !errors.Is(installErr, errNoProbeResponse) {
+		rules.release(smContext)
+		return false, fmt.Errorf("install probe rules failed: %v", installErr)
+	}
+	if installErr == nil {
+		time.Sleep(window)
+	}
+	rsp, err := rules.remove(smContext)
+	if installErr != nil {
+		return false, fmt.Errorf("install probe rules failed: %v", installErr)
+	}
+	if err != nil {
+		return false, fmt.Errorf("remove probe rules failed: %v", err)
+	}
+	replies, err := probeReplies(rsp, rules.urr.URRID)
+	if err != nil {
+		return false, err
+	}
+
+	reachable := replies > 0
+	smContext.SubPfcpLog.Infof("UE reachability probe of IP[%s]: %d echo replies", smContext.PDUAddress.Ip, replies)
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	farList := smContext.UpdateReachabilityRouting(rules.upf.NodeID, reachable, time.Now())
+	if len(farList) > 0 {
+		if err := sendReachabilityRouting(rules.upf, smContext, farList); err != nil {
+			return reachable, fmt.Errorf("update downlink routing failed: %v", err)
+		}
+		smContext.SubPfcpLog.Infof("downlink packets of unreachable UE dropped: %v", !reachable)
+	}
+	return reachable, nil
+}
+
+// install allocates the rules of the probe on the AN UPF of the session and sends them to it.
+// To be called with SMLock held.
+func (p *UEReachabilityProbe) install(smContext *context.SMContext) (
+	*probeRules, <-chan *pfcp_message.SessionModificationResponse, error,
+) {
+	if smContext.PDUAddress == nil || smContext.PDUAddress.Ip == nil {
+		return nil, nil, fmt.Errorf("no UE IP address allocated")
+	}
+	if smContext.Tunnel == nil {
+		return nil, nil, fmt.Errorf("no user plane tunnel")
+	}
+	dataPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	if dataPath == nil || dataPath.FirstDPNode == nil || dataPath.FirstDPNode.UPF == nil {
+		return nil, nil, fmt.Errorf("no default data path")
+	}
+
+	rules := &probeRules{upf: dataPath.FirstDPNode.UPF}
+	var err error
+	if rules.pdr, err = rules.upf.AddPDR(); err != nil {
+		return nil, nil, err
+	}
+	if rules.urr, err = rules.upf.AddURR(); err != nil {
+		rules.releaseIDs()
+		return nil, nil, err
+	}
+	installed, err := sendReachabilityProbe(rules.upf.NodeID, smContext, rules.upf.Port, p.ResponderIP,
+		rules.pdr.PDRID, rules.pdr.FAR.FARID, rules.urr.URRID)
+	if err != nil {
+		rules.releaseIDs()
+		return nil, nil, fmt.Errorf("install probe rules failed: %v", err)
+	}
+	return rules, installed, nil
+}
+
+// remove removes the rules of the probe from the UPF and returns the response of the UPF with
+// the usage of the URR. The IDs of the rules are freed only once the UPF removed them.
+func (r *probeRules) remove(smContext *context.SMContext) (*pfcp_message.SessionModificationResponse, error) {
+	smContext.SMLock.Lock()
+	removed, err := sendReachabilityProbeRemoval(r.upf.NodeID, smContext, r.upf.Port,
+		r.pdr.PDRID, r.pdr.FAR.FARID, r.urr.URRID)
+	smContext.SMLock.Unlock()
+	if err != nil {
+		smContext.SubPfcpLog.Warnf("probe rules PDR[%d] URR[%d] left on UPF, IDs not released", r.pdr.PDRID, r.urr.URRID)
+		return nil, err
+	}
+	rsp, err := waitProbeResponse(removed)
+	if err != nil {
+		smContext.SubPfcpLog.Warnf("probe rules PDR[%d] URR[%d] left on UPF, IDs not released", r.pdr.PDRID, r.urr.URRID)
+		return nil, err
+	}
+	r.release(smContext)
+	return rsp, nil
+}
+
+// release frees the IDs of the rules of the probe, not installed on the UPF
+func (r *probeRules) release(smContext *context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	r.releaseIDs()
+}
+
+func (r *probeRules) releaseIDs() {
+	if r.pdr != nil {
+		if err := r.upf.RemovePDR(r.pdr); err != nil {
+			logger.PfcpLog.Warnf("release probe PDR failed: %v", err)
+		}
+		if err := r.upf.RemoveFAR(r.pdr.FAR); err != nil {
+			logger.PfcpLog.Warnf("release probe FAR failed: %v", err)
+		}
+	}
+	if r.urr != nil {
+		if err := r.upf.RemoveURR(r.urr); err != nil {
+			logger.PfcpLog.Warnf("release probe URR failed: %v", err)
+		}
+	}
+}
+
+// waitProbeResponse returns the response of the UPF accepting the message of the probe
+func waitProbeResponse(probe <-chan *pfcp_message.SessionModificationResponse) (
+	*pfcp_message.SessionModificationResponse, error,
+) {
+	var rsp *pfcp_message.SessionModificationResponse
+	select {
+	case rsp = <-probe:
+	case <-time.After(ReachabilityProbeResponseTimeout):
+	}
+	if rsp == nil {
+		return nil, errNoProbeResponse
+	}
+	if rsp.Cause == nil {
+		return nil, fmt.Errorf("missing Cause IE")
+	}
+	cause, err := rsp.Cause.Cause()
+	if err != nil {
+		return nil, err
+	}
+	if cause != ie.CauseRequestAccepted {
+		return nil, fmt.Errorf("rejected with cause %d", cause)
+	}
+	return rsp, nil
+}
+
+// probeReplies returns the number of packets the UPF counted on the probe URR
+func probeReplies(rsp *pfcp_message.SessionModificationResponse, urrID uint32) (uint64, error) {
+	for _, usageReport := range rsp.UsageReport {
+		ies, err := usageReport.UsageReport()
+		if err != nil {
+			return 0, err
+		}
+		var reportURRID uint32
+		var volume *ie.VolumeMeasurementFields
+		for _, i := range ies {
+			switch i.Type {
+			case ie.URRID:
+				if reportURRID, err = i.URRID(); err != nil {
+					return 0, err
+				}
+			case ie.VolumeMeasurement:
+				if volume, err = i.VolumeMeasurement(); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if reportURRID != urrID {
+			continue
+		}
+		if volume == nil {
+			return 0, nil
+		}
+		if volume.TotalNumberOfPackets != 0 {
+			return volume.TotalNumberOfPackets, nil
+		}
+		return volume.UplinkNumberOfPackets, nil
+	}
+	return 0, fmt.Errorf("no usage report for URR[%d]", urrID)
+}
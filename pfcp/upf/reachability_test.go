@@ -0,0 +1,259 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/pfcp/message"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+	pfcp_message "github.com/wmnsk/go-pfcp/message"
+)
+
+// mockProbeUPF answers the session modifications of the probe as a UPF where the UE sent
+// echoReplies ICMP echo replies during the probe window
+type mockProbeUPF struct {
+	requests    []*pfcp_message.SessionModificationRequest
+	echoReplies uint64
+	seq         uint32
+}
+
+func (m *mockProbeUPF) answer(t *testing.T, upNodeID context.NodeID, ctx *context.SMContext,
+	req *pfcp_message.SessionModificationRequest,
+) <-chan *pfcp_message.SessionModificationResponse {
+	// the messages of the probe are sent holding the lock of the session
+	require.False(t, ctx.SMLock.TryLock())
+
+	m.requests = append(m.requests, req)
+	ies := []*ie.IE{ie.NewCause(ie.CauseRequestAccepted)}
+	for _, removeURR := range req.RemoveURR {
+		urrID, err := removeURR.URRID()
+		require.NoError(t, err)
+		ies = append(ies, ie.NewUsageReportWithinSessionModificationResponse(
+			ie.NewURRID(urrID),
+			ie.NewURSEQN(1),
+			ie.NewUsageReportTrigger(0, 0, 0),
+			ie.NewVolumeMeasurement(0x38, 0, 0, 0, m.echoReplies, m.echoReplies, 0),
+		))
+	}
+	probe := ctx.StartReachabilityProbe(upNodeID, req.Sequence())
+	rsp := pfcp_message.NewSessionModificationResponse(0, 0, 1, req.Sequence(), 0, ies...)
+	go ctx.CompleteReachabilityProbe(upNodeID, req.Sequence(), rsp)
+	return probe
+}
+
+func stubReachabilityProbe(t *testing.T, m *mockProbeUPF) {
+	t.Helper()
+	origInstall, origRemoval := sendReachabilityProbe, sendReachabilityProbeRemoval
+	t.Cleanup(func() { sendReachabilityProbe, sendReachabilityProbeRemoval = origInstall, origRemoval })
+
+	sendReachabilityProbe = func(upNodeID context.NodeID, ctx *context.SMContext, upfPort uint16,
+		responderIP net.IP, pdrID uint16, farID uint32, urrID uint32,
+	) (<-chan *pfcp_message.SessionModificationResponse, error) {
+		m.seq++
+		req := message.BuildPfcpReachabilityProbeRequest(m.seq, 1, 2, net.ParseIP("10.0.0.1"),
+			ctx.PDUAddress.Ip, responderIP, pdrID, farID, urrID)
+		return m.answer(t, upNodeID, ctx, req), nil
+	}
+	sendReachabilityProbeRemoval = func(upNodeID context.NodeID, ctx *context.SMContext, upfPort uint16,
+		pdrID uint16, farID uint32, urrID uint32,
+	) (<-chan *pfcp_message.SessionModificationResponse, error) {
+		m.seq++
+		req := message.BuildPfcpReachabilityProbeRemovalRequest(m.seq, 1, 2, net.ParseIP("10.0.0.1"), pdrID, farID, urrID)
+		return m.answer(t, upNodeID, ctx, req), nil
+	}
+}
+
+func newProbeSMContext(t *testing.T, nodeID string) *context.SMContext {
+	t.Helper()
+	upf := contexttest.NewUPF(t, nodeID)
+	smContext := contexttest.NewSMContext("imsi-208930000000301", 5, &models.Snssai{Sst: 1, Sd: "010203"}, nil)
+	smContext.PDUAddress = &context.UeIpAddr{Ip: net.ParseIP("10.60.0.1").To4()}
+	smContext.Tunnel = &context.UPTunnel{
+		DataPathPool: context.DataPathPool{
+			1: &context.DataPath{
+				IsDefaultPath: true,
+				FirstDPNode:   &context.DataPathNode{UPF: upf},
+			},
+		},
+	}
+	smContext.PFCPContext[nodeID] = &context.PFCPSessionContext{
+		NodeID: *context.NewNodeID(nodeID), LocalSEID: 1, RemoteSEID: 2,
+	}
+	return smContext
+}
+
+func TestCheckUEReachability(t *testing.T) {
+	testCases := []struct {
+		name        string
+		echoReplies uint64
+		reachable   bool
+	}{
+		{name: "echo success", echoReplies: 3, reachable: true},
+		{name: "echo failure", echoReplies: 0, reachable: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := newProbeSMContext(t, "10.0.5.1")
+			mock := &mockProbeUPF{echoReplies: tc.echoReplies}
+			stubReachabilityProbe(t, mock)
+			probe := &UEReachabilityProbe{ResponderIP: net.ParseIP("10.100.0.1"), Window: time.Millisecond}
+
+			reachable, err := probe.CheckUEReachability(smContext)
+			require.NoError(t, err)
+			require.Equal(t, tc.reachable, reachable)
+			require.NotNil(t, smContext.UEReachability)
+			require.Equal(t, tc.reachable, smContext.UEReachability.Reachable)
+			require.Len(t, mock.requests, 2)
+
+			// the echo replies of the UE are redirected to the responder and counted
+			install := mock.requests[0]
+			require.Len(t, install.CreatePDR, 1)
+			require.Len(t, install.CreateFAR, 1)
+			require.Len(t, install.CreateURR, 1)
+			sdfFilter, err := install.CreatePDR[0].SDFFilter()
+			require.NoError(t, err)
+			require.Equal(t, "permit out icmp from 10.100.0.1 to 10.60.0.1", sdfFilter.FlowDescription)
+			forwardingParameters, err := install.CreateFAR[0].ForwardingParameters()
+			require.NoError(t, err)
+			var redirectServer string
+			for _, i := range forwardingParameters {
+				if i.Type == ie.RedirectInformation {
+					redirect, err := i.RedirectInformation()
+					require.NoError(t, err)
+					redirectServer = redirect.RedirectServerAddress
+				}
+			}
+			require.Equal(t, "10.100.0.1", redirectServer)
+			urrID, err := install.CreateURR[0].URRID()
+			require.NoError(t, err)
+
+			// the rules are removed, the UPF reporting the usage of the URR
+			removal := mock.requests[1]
+			require.Len(t, removal.RemovePDR, 1)
+			require.Len(t, removal.RemoveFAR, 1)
+			removedURRID, err := removal.RemoveURR[0].URRID()
+			require.NoError(t, err)
+			require.Equal(t, urrID, removedURRID)
+			require.NotEqual(t, install.Sequence(), removal.Sequence())
+		})
+	}
+}
+
+func TestCheckUEReachabilityNoResponse(t *testing.T) {
+	origTimeout := ReachabilityProbeResponseTimeout
+	ReachabilityProbeResponseTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { ReachabilityProbeResponseTimeout = origTimeout })
+
+	smContext := newProbeSMContext(t, "10.0.5.2")
+	mock := &mockProbeUPF{}
+	stubReachabilityProbe(t, mock)
+	sendReachabilityProbe = func(upNodeID context.NodeID, ctx *context.SMContext, upfPort uint16,
+		responderIP net.IP, pdrID uint16, farID uint32, urrID uint32,
+	) (<-chan *pfcp_message.SessionModificationResponse, error) {
+		return ctx.StartReachabilityProbe(upNodeID, 1), nil
+	}
+	probe := &UEReachabilityProbe{ResponderIP: net.ParseIP("10.100.0.1"), Window: time.Millisecond}
+
+	_, err := probe.CheckUEReachability(smContext)
+	require.Error(t, err)
+	require.Nil(t, smContext.UEReachability, "no outcome recorded")
+	// the rules the UPF may have installed are removed
+	require.Len(t, mock.requests, 1)
+	require.Len(t, mock.requests[0].RemovePDR, 1)
+	require.Len(t, mock.requests[0].RemoveURR, 1)
+}
+
+func TestCheckUEReachabilityRemovalNoResponse(t *testing.T) {
+	origTimeout := ReachabilityProbeResponseTimeout
+	ReachabilityProbeResponseTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { ReachabilityProbeResponseTimeout = origTimeout })
+
+	smContext := newProbeSMContext(t, "10.0.5.4")
+	stubReachabilityProbe(t, &mockProbeUPF{echoReplies: 1})
+	sendReachabilityProbeRemoval = func(upNodeID context.NodeID, ctx *context.SMContext, upfPort uint16,
+		pdrID uint16, farID uint32, urrID uint32,
+	) (<-chan *pfcp_message.SessionModificationResponse, error) {
+		return ctx.StartReachabilityProbe(upNodeID, 100), nil
+	}
+	probe := &UEReachabilityProbe{ResponderIP: net.ParseIP("10.100.0.1"), Window: time.Millisecond}
+
+	_, err := probe.CheckUEReachability(smContext)
+	require.Error(t, err)
+	require.Nil(t, smContext.UEReachability, "no outcome recorded")
+}
+
+func TestCheckUEReachabilityWindow(t *testing.T) {
+	smContext := newProbeSMContext(t, "10.0.5.5")
+	mock := &mockProbeUPF{}
+	stubReachabilityProbe(t, mock)
+	probe := &UEReachabilityProbe{ResponderIP: net.ParseIP("10.100.0.1"), Window: MaxReachabilityProbeWindow + time.Second}
+
+	_, err := probe.CheckUEReachability(smContext)
+	require.Error(t, err)
+	require.Empty(t, mock.requests, "no probe sent")
+}
+
+// The downlink packets of the session are dropped while its UE is unreachable
+func TestCheckUEReachabilityRouting(t *testing.T) {
+	smContext := newProbeSMContext(t, "10.0.5.6")
+	dataPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	dataPath.Activated = true
+	dlFAR := &context.FAR{
+		FARID:       1,
+		ApplyAction: context.ApplyAction{Forw: true},
+		ForwardingParameters: &context.ForwardingParameters{
+			OuterHeaderCreation: &context.OuterHeaderCreation{Teid: 7, Ipv4Address: net.ParseIP("10.1.0.2").To4()},
+		},
+	}
+	dataPath.FirstDPNode.DownLinkTunnel = &context.GTPTunnel{
+		PDR: map[string]*context.PDR{"default": {PDRID: 1, FAR: dlFAR}},
+	}
+
+	origRouting := sendReachabilityRouting
+	t.Cleanup(func() { sendReachabilityRouting = origRouting })
+	var routed [][]*context.FAR
+	sendReachabilityRouting = func(upf *context.UPF, ctx *context.SMContext, farList []*context.FAR) error {
+		require.Equal(t, dataPath.FirstDPNode.UPF, upf)
+		routed = append(routed, farList)
+		return nil
+	}
+	mock := &mockProbeUPF{}
+	stubReachabilityProbe(t, mock)
+	probe := &UEReachabilityProbe{ResponderIP: net.ParseIP("10.100.0.1"), Window: time.Millisecond}
+
+	reachable, err := probe.CheckUEReachability(smContext)
+	require.NoError(t, err)
+	require.False(t, reachable)
+	require.Equal(t, [][]*context.FAR{{dlFAR}}, routed)
+	require.Equal(t, context.ApplyAction{Drop: true}, dlFAR.ApplyAction)
+	require.Equal(t, context.RULE_UPDATE, dlFAR.State)
+
+	// still unreachable, the routing is unchanged
+	_, err = probe.CheckUEReachability(smContext)
+	require.NoError(t, err)
+	require.Len(t, routed, 1)
+
+	// forwarded again once the UE replies
+	mock.echoReplies = 2
+	reachable, err = probe.CheckUEReachability(smContext)
+	require.NoError(t, err)
+	require.True(t, reachable)
+	require.Len(t, routed, 2)
+	require.Equal(t, context.ApplyAction{Forw: true}, dlFAR.ApplyAction)
+}
+
+func TestCheckUEReachabilityInvalidResponder(t *testing.T) {
+	smContext := newProbeSMContext(t, "10.0.5.3")
+	probe := &UEReachabilityProbe{ResponderIP: net.ParseIP("2001:db8::1")}
+
+	_, err := probe.CheckUEReachability(smContext)
+	require.Error(t, err)
+}
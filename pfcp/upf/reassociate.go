@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/pfcp/message"
+)
+
+var (
+	sendPfcpAssociationSetupRequest   = message.SendPfcpAssociationSetupRequest
+	sendPfcpAssociationReleaseRequest = message.SendPfcpAssociationReleaseRequest
+	// time for the UPF to answer the re-association, then it is left to ProbeInactiveUpfs
+	ReassociationTimeout = 10 * time.Second
+)
+
+// ErrUnknownUPF is returned for a UPF which is not in the user plane
+var ErrUnknownUPF = errors.New("unknown UPF")
+
+// ReassociateUPF tears down the PFCP association with the UPF of the hostname and sets it up
+// again, e.g. after a change of the UPF configuration. The other UPFs are left untouched.
+//
+// The association is released first, the UPF deleting the PFCP sessions of the association. The
+// sessions anchored on the UPF are revalidated against its configuration: the ones whose slice
+// or DNN it no longer serves are released by the network and returned, the others are
+// established again once the UPF answers the Association Setup Request. The UPF stays
+// AssociatedSettingUp until then, no session is anchored on it meanwhile; without answer within
+// ReassociationTimeout, or if the request cannot be sent, it is NotAssociated and
+// ProbeInactiveUpfs retries.
+func ReassociateUPF(hostname string) ([]*context.SMContext, error) {
+	upf := context.RetrieveUPFNodeByNodeID(*context.NewNodeID(hostname))
+	if upf == nil {
+		return nil, fmt.Errorf("%w [%s]", ErrUnknownUPF, hostname)
+	}
+	upfIP := upf.NodeID.ResolveNodeIdToIp().String()
+
+	upf.UpfLock.Lock()
+	logger.PfcpLog.Infof("re-associate UPF[%s], current state %s", hostname, upf.UPFStatus)
+	released := false
+	if upf.UPFStatus == context.AssociatedSetUpSuccess {
+		if err := sendPfcpAssociationReleaseRequest(upf.NodeID, upf.Port); err != nil {
+			logger.PfcpLog.Errorf("release PFCP association of UPF[%s] failed: %v", hostname, err)
+			upf.RecordError(err)
+		} else {
+			released = true
+			// the sessions of the association are recovered on the next one
+			upf.SessionsLost = true
+		}
+	}
+	upf.UPFStatus = context.AssociatedSettingUp
+	upf.NHeartBeat = 0
+	upf.UpfLock.Unlock()
+
+	// revalidated before the association is set up again and recovers the sessions left
+	invalid := make([]*context.SMContext, 0)
+	for _, smContext := range context.GetSMContextsByUPF(upf.NodeID) {
+		if upf.IsSessionServed(smContext) {
+			continue
+		}
+		smContext.SubPfcpLog.Warnf("UPF[%s] no longer serves DNN[%s] of the session", hostname, smContext.Dnn)
+		if released {
+			// the PFCP session went with the association, no deletion is sent to the UPF
+			smContext.SMLock.Lock()
+			delete(smContext.PFCPContext, upfIP)
+			smContext.SMLock.Unlock()
+		}
+		invalid = append(invalid, smContext)
+	}
+	context.ReleaseSessions(invalid, nasMessage.Cause5GSMReactivationRequested,
+		fmt.Sprintf("UPF[%s] re-associated without the DNN of the session", hostname))
+
+	upf.UpfLock.Lock()
+	err := sendPfcpAssociationSetupRequest(upf.NodeID, upf.Port)
+	if err != nil {
+		upf.UPFStatus = context.NotAssociated
+		upf.RecordError(err)
+	}
+	upf.UpfLock.Unlock()
+	if err != nil {
+		return invalid, fmt.Errorf("re-associate UPF[%s] failed: %v", hostname, err)
+	}
+
+	time.AfterFunc(ReassociationTimeout, func() {
+		upf.UpfLock.Lock()
+		defer upf.UpfLock.Unlock()
+		if upf.UPFStatus == context.AssociatedSettingUp {
+			logger.PfcpLog.Warnf("UPF[%s] did not answer the re-association, left to the probes", hostname)
+			upf.UPFStatus = context.NotAssociated
+			upf.RecordError(fmt.Errorf("no answer to the re-association within %v", ReassociationTimeout))
+		}
+	})
+	return invalid, nil
+}
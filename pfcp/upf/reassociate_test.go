@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func newReassociateTestUPF(t *testing.T, ip string) *context.UPF {
+	t.Helper()
+	nodeID := context.NewNodeID(ip)
+	upf := context.NewUPF(nodeID, nil)
+	upf.UPFStatus = context.AssociatedSetUpSuccess
+	upf.SNssaiInfos = []context.SnssaiUPFInfo{
+		{
+			SNssai:  context.SNssai{Sst: 1, Sd: "010203"},
+			DnnList: []context.DnnUPFInfoItem{{Dnn: "internet"}},
+		},
+	}
+	t.Cleanup(func() { context.RemoveUPFNodeByNodeID(*nodeID) })
+	return upf
+}
+
+// stubAssociationSetup replaces the Association Setup Requests, the Association Release Requests
+// being recorded in the returned list
+func stubAssociationSetup(t *testing.T, send func(upNodeID context.NodeID, upfPort uint16) error) *[]string {
+	t.Helper()
+	origSetup, origRelease := sendPfcpAssociationSetupRequest, sendPfcpAssociationReleaseRequest
+	t.Cleanup(func() {
+		sendPfcpAssociationSetupRequest, sendPfcpAssociationReleaseRequest = origSetup, origRelease
+	})
+	released := make([]string, 0)
+	sendPfcpAssociationSetupRequest = send
+	sendPfcpAssociationReleaseRequest = func(upNodeID context.NodeID, upfPort uint16) error {
+		released = append(released, upNodeID.ResolveNodeIdToIp().String())
+		return nil
+	}
+	return &released
+}
+
+func TestReassociateUPF(t *testing.T) {
+	target := newReassociateTestUPF(t, "10.0.3.1")
+	other := newReassociateTestUPF(t, "10.0.3.2")
+
+	var sent []string
+	var statusOnSend context.UPFStatus
+	released := stubAssociationSetup(t, func(upNodeID context.NodeID, upfPort uint16) error {
+		sent = append(sent, upNodeID.ResolveNodeIdToIp().String())
+		statusOnSend = target.UPFStatus
+		return nil
+	})
+
+	_, err := ReassociateUPF("10.0.3.1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.3.1"}, *released, "the association is released first")
+	require.Equal(t, []string{"10.0.3.1"}, sent, "only the named UPF is re-associated")
+	require.Equal(t, context.AssociatedSettingUp, statusOnSend)
+	require.Equal(t, context.AssociatedSettingUp, target.UPFStatus, "setting up until the Association Setup Response")
+	require.True(t, target.SessionsLost, "sessions recovered on the Association Setup Response")
+	require.Equal(t, context.AssociatedSetUpSuccess, other.UPFStatus)
+}
+
+func TestReassociateUPFTimeout(t *testing.T) {
+	origTimeout := ReassociationTimeout
+	t.Cleanup(func() { ReassociationTimeout = origTimeout })
+	ReassociationTimeout = 10 * time.Millisecond
+
+	target := newReassociateTestUPF(t, "10.0.3.6")
+	stubAssociationSetup(t, func(upNodeID context.NodeID, upfPort uint16) error {
+		return nil
+	})
+
+	_, err := ReassociateUPF("10.0.3.6")
+	require.NoError(t, err)
+
+	// no Association Setup Response, left to ProbeInactiveUpfs
+	require.Eventually(t, func() bool {
+		target.UpfLock.RLock()
+		defer target.UpfLock.RUnlock()
+		return target.UPFStatus == context.NotAssociated
+	}, time.Second, 5*time.Millisecond)
+	require.NotNil(t, target.LastError())
+}
+
+func TestReassociateUPFSendFailure(t *testing.T) {
+	target := newReassociateTestUPF(t, "10.0.3.3")
+	other := newReassociateTestUPF(t, "10.0.3.4")
+	stubAssociationSetup(t, func(upNodeID context.NodeID, upfPort uint16) error {
+		return errors.New("network unreachable")
+	})
+
+	_, err := ReassociateUPF("10.0.3.3")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrUnknownUPF)
+	require.Equal(t, context.NotAssociated, target.UPFStatus, "left to ProbeInactiveUpfs")
+	require.Equal(t, context.AssociatedSetUpSuccess, other.UPFStatus)
+}
+
+func TestReassociateUPFUnknown(t *testing.T) {
+	stubAssociationSetup(t, func(upNodeID context.NodeID, upfPort uint16) error {
+		t.Fatal("no association setup expected")
+		return nil
+	})
+
+	_, err := ReassociateUPF("10.0.3.99")
+	require.ErrorIs(t, err, ErrUnknownUPF)
+}
+
+func TestReassociateUPFRevalidatesSessions(t *testing.T) {
+	target := newReassociateTestUPF(t, "10.0.3.5")
+	stubAssociationSetup(t, func(upNodeID context.NodeID, upfPort uint16) error {
+		return nil
+	})
+
+	served := context.NewSMContext("imsi-208930000000101", 1)
+	served.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	served.Dnn = "internet"
+	served.PFCPContext["10.0.3.5"] = &context.PFCPSessionContext{LocalSEID: 1, RemoteSEID: 1}
+
+	// the DNN was removed from the UPF configuration
+	removed := context.NewSMContext("imsi-208930000000102", 1)
+	removed.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	removed.Dnn = "enterprise"
+	removed.PFCPContext["10.0.3.5"] = &context.PFCPSessionContext{LocalSEID: 2, RemoteSEID: 2}
+
+	releasedCause := make(chan uint8, 2)
+	origRelease := context.ReleaseSessionByNetwork
+	t.Cleanup(func() { context.ReleaseSessionByNetwork = origRelease })
+	context.ReleaseSessionByNetwork = func(smContext *context.SMContext, cause uint8, procedure string) {
+		require.Same(t, removed, smContext)
+		releasedCause <- cause
+	}
+
+	invalid, err := ReassociateUPF("10.0.3.5")
+	require.NoError(t, err)
+	require.Equal(t, []*context.SMContext{removed}, invalid)
+	require.Equal(t, context.AssociatedSettingUp, target.UPFStatus)
+
+	// the invalid session is released by the network, without deletion sent to the UPF
+	require.Equal(t, nasMessage.Cause5GSMReactivationRequested, <-releasedCause)
+	require.NotContains(t, removed.PFCPContext, "10.0.3.5")
+	require.Contains(t, served.PFCPContext, "10.0.3.5", "recovered on the Association Setup Response")
+}
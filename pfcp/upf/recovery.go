@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/pfcp/message"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+var (
+	sendPfcpSessionReportFetch   = message.SendPfcpSessionReportFetchRequest
+	sendPfcpSessionEstablishment = message.SendPfcpSessionEstablishmentRequest
+	// time to wait for each UPF response during the session recovery
+	SessionRecoveryResponseTimeout = 5 * time.Second
+)
+
+// SessionRecoveryResult is the outcome of OffloadSessionRecovery for the sessions of a UPF
+type SessionRecoveryResult struct {
+	// sessions still held by the UPF, left untouched
+	Restored []*context.SMContext
+	// sessions lost by the UPF, established again
+	Recreated []*context.SMContext
+	// sessions lost by the UPF which could not be established again, released
+	Failed []*context.SMContext
+}
+
+// OffloadSessionRecovery verifies the sessions known locally on a UPF whose recovery timestamp
+// changed, instead of tearing all of them down. A PFCP Session Report Fetch is sent for each
+// session: the sessions the UPF still holds are restored as they are, the ones it lost are
+// established again with all their rules. The lost sessions which cannot be established again
+// are released by the network, freeing their UE IP and SEIDs.
+func OffloadSessionRecovery(upf *context.UPF) *SessionRecoveryResult {
+	result := &SessionRecoveryResult{}
+	for _, smContext := range context.GetSMContextsByUPF(upf.NodeID) {
+		cause := fetchSessionState(upf, smContext)
+		if cause == ie.CauseRequestAccepted {
+			smContext.SubPfcpLog.Infof("session held by UPF[%s] after its restart, restored",
+				upf.NodeID.ResolveNodeIdToIp())
+			result.Restored = append(result.Restored, smContext)
+			continue
+		}
+		smContext.SubPfcpLog.Warnf("session not held by UPF[%s] after its restart, cause %d, recreating",
+			upf.NodeID.ResolveNodeIdToIp(), cause)
+
+		if recreateSession(upf, smContext) {
+			result.Recreated = append(result.Recreated, smContext)
+		} else {
+			// the session re-established by the UE returns to the UPF and keeps its IP
+			smContext.RememberSessionAnchor(upf)
+			result.Failed = append(result.Failed, smContext)
+		}
+	}
+	logger.PfcpLog.Infof("UPF[%s] session recovery: %d restored, %d recreated, %d failed",
+		upf.NodeID.ResolveNodeIdToIp(), len(result.Restored), len(result.Recreated), len(result.Failed))
+
+	upfIP := upf.NodeID.ResolveNodeIdToIp().String()
+	for _, smContext := range result.Failed {
+		// the UPF lost the PFCP session, no deletion is sent to it
+		smContext.SMLock.Lock()
+		delete(smContext.PFCPContext, upfIP)
+		smContext.SMLock.Unlock()
+	}
+	context.ReleaseSessions(result.Failed, nasMessage.Cause5GSMReactivationRequested,
+		fmt.Sprintf("session lost by UPF[%s] after its restart", upfIP))
+	return result
+}
+
+// fetchSessionState returns the cause of the UPF answer to the Session Report Fetch of the session
+func fetchSessionState(upf *context.UPF, smContext *context.SMContext) uint8 {
+	probe := smContext.StartRecoveryProbe(upf.NodeID)
+	if err := sendPfcpSessionReportFetch(upf.NodeID, smContext, upf.Port); err != nil {
+		smContext.CancelRecoveryProbe(upf.NodeID)
+		smContext.SubPfcpLog.Errorf("send PFCP Session Report Fetch failed: %v", err)
+		return context.CauseNoResponse
+	}
+	select {
+	case cause := <-probe:
+		return cause
+	case <-time.After(SessionRecoveryResponseTimeout):
+		smContext.CancelRecoveryProbe(upf.NodeID)
+		return context.CauseNoResponse
+	}
+}
+
+// recreateSession establishes again the PFCP session of the UPF, returns true once accepted.
+// The lock of the session is released before waiting for the response, whose handler takes it.
+func recreateSession(upf *context.UPF, smContext *context.SMContext) bool {
+	smContext.SMLock.Lock()
+	pdrList, farList, qerList := smContext.ResetPFCPSession(upf.NodeID)
+	err := sendPfcpSessionEstablishment(upf.NodeID, smContext, pdrList, farList, nil, qerList, upf.Port)
+	smContext.SMLock.Unlock()
+	if err != nil {
+		smContext.SubPfcpLog.Errorf("send PFCP Session Establishment for recovery failed: %v", err)
+		return false
+	}
+	return waitSessionEstablishment(smContext)
+}
+
+// waitSessionEstablishment returns true once the UPF accepted the session establishment
+func waitSessionEstablishment(smContext *context.SMContext) bool {
+	select {
+	case status := <-smContext.SBIPFCPCommunicationChan:
+		return status == context.SessionEstablishSuccess
+	case <-time.After(SessionRecoveryResponseTimeout):
+		smContext.SubPfcpLog.Errorln("PFCP Session Establishment for recovery timed out")
+		return false
+	}
+}
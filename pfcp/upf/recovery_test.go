@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package upf
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func newRecoveryTestSMContext(ip, supi string, seid uint64) *context.SMContext {
+	smContext := contexttest.NewSMContext(supi, 1, &models.Snssai{Sst: 1, Sd: "010203"}, nil)
+	far := &context.FAR{FARID: 1, State: context.RULE_CREATE}
+	qer := &context.QER{QERID: 1, State: context.RULE_CREATE}
+	smContext.PFCPContext[ip] = &context.PFCPSessionContext{
+		NodeID:     *context.NewNodeID(ip),
+		LocalSEID:  seid,
+		RemoteSEID: seid,
+		PDRs: map[uint16]*context.PDR{
+			1: {PDRID: 1, State: context.RULE_CREATE, FAR: far, QER: []*context.QER{qer}},
+		},
+	}
+	return smContext
+}
+
+func stubSessionRecovery(t *testing.T, causes map[*context.SMContext]uint8) *[]*context.SMContext {
+	t.Helper()
+	origFetch, origEstablishment := sendPfcpSessionReportFetch, sendPfcpSessionEstablishment
+	t.Cleanup(func() {
+		sendPfcpSessionReportFetch, sendPfcpSessionEstablishment = origFetch, origEstablishment
+	})
+
+	sendPfcpSessionReportFetch = func(upNodeID context.NodeID, ctx *context.SMContext, upfPort uint16) error {
+		if cause, ok := causes[ctx]; ok {
+			go ctx.CompleteRecoveryProbe(upNodeID, cause)
+		}
+		return nil
+	}
+	established := make([]*context.SMContext, 0)
+	sendPfcpSessionEstablishment = func(upNodeID context.NodeID, ctx *context.SMContext,
+		pdrList []*context.PDR, farList []*context.FAR, barList []*context.BAR, qerList []*context.QER, upfPort uint16,
+	) error {
+		require.Len(t, pdrList, 1)
+		require.Len(t, farList, 1)
+		require.Len(t, qerList, 1)
+		established = append(established, ctx)
+		ctx.SBIPFCPCommunicationChan <- context.SessionEstablishSuccess
+		return nil
+	}
+	return &established
+}
+
+func TestOffloadSessionRecovery(t *testing.T) {
+	upf := newReassociateTestUPF(t, "10.0.4.1")
+	held := newRecoveryTestSMContext("10.0.4.1", "imsi-208930000000201", 11)
+	lost := newRecoveryTestSMContext("10.0.4.1", "imsi-208930000000202", 12)
+	established := stubSessionRecovery(t, map[*context.SMContext]uint8{
+		held: ie.CauseRequestAccepted,
+		lost: ie.CauseSessionContextNotFound,
+	})
+
+	result := OffloadSessionRecovery(upf)
+	require.Equal(t, []*context.SMContext{held}, result.Restored)
+	require.Equal(t, []*context.SMContext{lost}, result.Recreated)
+	require.Empty(t, result.Failed)
+	require.Equal(t, []*context.SMContext{lost}, *established, "only the lost session is established again")
+
+	// the session held by the UPF is left untouched
+	heldSession := held.PFCPContext["10.0.4.1"]
+	require.Equal(t, uint64(11), heldSession.RemoteSEID)
+	require.Equal(t, context.RULE_CREATE, heldSession.PDRs[1].State)
+
+	// the lost session is established again with all its rules
+	lostSession := lost.PFCPContext["10.0.4.1"]
+	require.Zero(t, lostSession.RemoteSEID)
+	require.Equal(t, uint64(12), lostSession.LocalSEID)
+	require.Equal(t, context.RULE_INITIAL, lostSession.PDRs[1].State)
+	require.Equal(t, context.RULE_INITIAL, lostSession.PDRs[1].FAR.State)
+	require.Equal(t, context.RULE_INITIAL, lostSession.PDRs[1].QER[0].State)
+}
+
+func TestOffloadSessionRecoveryNoResponse(t *testing.T) {
+	origTimeout := SessionRecoveryResponseTimeout
+	SessionRecoveryResponseTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { SessionRecoveryResponseTimeout = origTimeout })
+
+	upf := newReassociateTestUPF(t, "10.0.4.2")
+	silent := newRecoveryTestSMContext("10.0.4.2", "imsi-208930000000203", 13)
+	established := stubSessionRecovery(t, nil)
+
+	// an unanswered Session Report Fetch is handled as a lost session
+	result := OffloadSessionRecovery(upf)
+	require.Empty(t, result.Restored)
+	require.Equal(t, []*context.SMContext{silent}, result.Recreated)
+	require.Equal(t, []*context.SMContext{silent}, *established)
+	require.False(t, silent.CompleteRecoveryProbe(upf.NodeID, ie.CauseRequestAccepted), "timed out probe dropped")
+}
+
+func TestOffloadSessionRecoveryFailedRemembersAnchor(t *testing.T) {
+	upf := newReassociateTestUPF(t, "10.0.4.4")
+	lost := newRecoveryTestSMContext("10.0.4.4", "imsi-208930000000205", 15)
+	lost.PDUAddress = &context.UeIpAddr{Ip: net.ParseIP("10.60.0.5").To4()}
+	stubSessionRecovery(t, map[*context.SMContext]uint8{lost: ie.CauseSessionContextNotFound})
+	sendPfcpSessionEstablishment = func(upNodeID context.NodeID, ctx *context.SMContext,
+		pdrList []*context.PDR, farList []*context.FAR, barList []*context.BAR, qerList []*context.QER, upfPort uint16,
+	) error {
+		return errors.New("UPF unreachable")
+	}
+
+	released := make(chan uint8, 1)
+	origRelease := context.ReleaseSessionByNetwork
+	t.Cleanup(func() { context.ReleaseSessionByNetwork = origRelease })
+	context.ReleaseSessionByNetwork = func(smContext *context.SMContext, cause uint8, procedure string) {
+		released <- cause
+	}
+
+	result := OffloadSessionRecovery(upf)
+	require.Equal(t, []*context.SMContext{lost}, result.Failed)
+
+	// the lost session is released by the network, without deletion sent to the UPF
+	require.Equal(t, nasMessage.Cause5GSMReactivationRequested, <-released)
+	require.NotContains(t, lost.PFCPContext, "10.0.4.4")
+
+	// the session re-established by the UE returns to the UPF with its IP
+	reestablished := contexttest.NewSMContext("imsi-208930000000205", 2, lost.Snssai, nil)
+	anchor := reestablished.TakeSessionAnchor()
+	require.NotNil(t, anchor)
+	require.Equal(t, upf.NodeID, anchor.UPF)
+	require.Equal(t, "10.60.0.5", anchor.UeIP.String())
+}
@@ -11,9 +11,7 @@ import (
 
 	"github.com/omec-project/smf/context"
 	"github.com/omec-project/smf/logger"
-	"github.com/omec-project/smf/metrics"
 	"github.com/omec-project/smf/pfcp/message"
-	pfcp_message "github.com/wmnsk/go-pfcp/message"
 )
 
 const (
@@ -22,28 +20,15 @@ const (
 	maxUpfProbeRetryInterval = 10 // sec
 )
 
+// InitPfcpHeartbeatRequest sends the heartbeats of the associated UPFs every heartbeat interval,
+// the first heartbeat of each association being spread over the interval and the next ones
+// jittered by the configured fraction of the interval
 func InitPfcpHeartbeatRequest(userplane *context.UserPlaneInformation) {
-	// Iterate through all UPFs and send heartbeat to active UPFs
-	for {
-		time.Sleep(maxHeartbeatInterval * time.Second)
-		for _, upf := range userplane.UPFs {
-			upf.UPF.UpfLock.Lock()
-			if (upf.UPF.UPFStatus == context.AssociatedSetUpSuccess) && upf.UPF.NHeartBeat < maxHeartbeatRetry {
-				err := message.SendHeartbeatRequest(upf.NodeID, upf.Port) // needs lock in sync rsp(adapter mode)
-				if err != nil {
-					logger.PfcpLog.Errorf("send pfcp heartbeat request failed: %v for UPF[%v, %v]: ", err, upf.NodeID, upf.NodeID.ResolveNodeIdToIp())
-				} else {
-					upf.UPF.NHeartBeat++
-				}
-			} else if upf.UPF.NHeartBeat == maxHeartbeatRetry {
-				logger.PfcpLog.Errorf("pfcp heartbeat failure for UPF: [%v]", upf.NodeID)
-				heartbeatRequest := pfcp_message.HeartbeatRequest{}
-				metrics.IncrementN4MsgStats(context.SMF_Self().NfInstanceID, heartbeatRequest.MessageTypeName(), "Out", "Failure", "Timeout")
-				upf.UPF.UPFStatus = context.NotAssociated
-			}
-
-			upf.UPF.UpfLock.Unlock()
-		}
+	schedule := newHeartbeatSchedule(maxHeartbeatInterval*time.Second, context.SMF_Self().HeartbeatJitter)
+	ticker := time.NewTicker(heartbeatTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		schedule.sendDueHeartbeats(userplane, now)
 	}
 }
 
@@ -57,6 +42,7 @@ func ProbeInactiveUpfs(upfs *context.UserPlaneInformation) {
 				err := message.SendPfcpAssociationSetupRequest(upf.NodeID, upf.Port)
 				if err != nil {
 					logger.PfcpLog.Errorf("send pfcp association setup request failed: %v ", err)
+					upf.UPF.RecordError(err)
 				}
 			}
 			upf.UPF.UpfLock.Unlock()
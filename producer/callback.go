@@ -42,17 +42,37 @@ func HandleSMPolicyUpdateNotify(eventData interface{}) error {
 
 	//TODO: Response data type -
 	//[200 OK] UeCampingRep
-	//[200 OK] array(PartialSuccessReport)
 	//[400 Bad Request] ErrorReport
 
+	// the PCC rules needing QoS flows beyond the maximum of the DNN are not installed, reported
+	// in the response
+	rejected := limitQoSFlows(smContext, pcfPolicyDecision)
+
 	// Derive QoS change(compare existing vs received Policy Decision)
 	policyUpdates := qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, pcfPolicyDecision)
+	if err := smContext.CheckSessionRuleLimits(policyUpdates); err != nil {
+		// Reject the policy before any PFCP towards the UPF
+		logger.PduSessLog.Errorf("SMContext[%s-%02d] policy update rejected: %v",
+			smContext.Supi, smContext.PDUSessionID, err)
+		txn.Rsp = httpwrapper.NewResponse(http.StatusBadRequest, nil, nil)
+		txn.Err = err
+		return err
+	}
 	smContext.SmPolicyUpdates = append(smContext.SmPolicyUpdates, policyUpdates)
 
 	// Update UPF
-	// TODO
+	if err := UpdateRedirection(smContext, policyUpdates); err != nil {
+		logger.PduSessLog.Errorf("SMContext[%s-%02d] redirection not updated: %v",
+			smContext.Supi, smContext.PDUSessionID, err)
+	}
 
 	httpResponse := httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+	if len(rejected) > 0 {
+		httpResponse = httpwrapper.NewResponse(http.StatusOK, nil, []models.PartialSuccessReport{{
+			FailureCause: models.FailureCause_RULE_EVENT,
+			RuleReports:  []models.RuleReport{rejectedPccRulesReport(rejected)},
+		}})
+	}
 	txn.Rsp = httpResponse
 
 	// Form N1/N2 Msg based on QoS Change and Trigger N1/N2 Msg
@@ -119,10 +139,7 @@ func BuildAndSendQosN1N2TransferMsg(smContext *smfContext.SMContext) error {
 	}
 
 	smContext.SubPduSessLog.Infoln("QoS N1N2 transfer initiated")
-	rspData, _, err := smContext.
-		CommunicationClient.
-		N1N2MessageCollectionDocumentApi.
-		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	rspData, err := smContext.N1N2MessageTransfer(context.Background(), n1n2Request)
 	if err != nil {
 		smContext.SubPfcpLog.Warnf("send N1N2Transfer failed, %v", err.Error())
 		return err
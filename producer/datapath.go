@@ -8,6 +8,7 @@ import (
 	"github.com/omec-project/smf/context"
 	"github.com/omec-project/smf/logger"
 	"github.com/omec-project/smf/pfcp/message"
+	"golang.org/x/sync/errgroup"
 )
 
 type PFCPState struct {
@@ -64,8 +65,13 @@ func SendPFCPRule(smContext *context.SMContext, dataPath *context.DataPath) {
 }
 
 // SendPFCPRules send all datapaths to UPFs
+//
+// The sessions of the intermediate UPFs are independent from each other and are established
+// concurrently, the sessions of the PSA UPFs are established last as they forward the downlink
+// traffic to the tunnels of the intermediate UPFs.
 func SendPFCPRules(smContext *context.SMContext) {
 	pfcpPool := make(map[string]*PFCPState)
+	anchors := make(map[string]bool)
 
 	for _, dataPath := range smContext.Tunnel.DataPathPool {
 		if dataPath.Activated {
@@ -94,6 +100,9 @@ func SendPFCPRules(smContext *context.SMContext) {
 					}
 				}
 
+				if curDataPathNode.IsAnchorUPF() {
+					anchors[curDataPathNode.GetNodeIP()] = true
+				}
 				pfcpState := pfcpPool[curDataPathNode.GetNodeIP()]
 				if pfcpState == nil {
 					pfcpPool[curDataPathNode.GetNodeIP()] = &PFCPState{
@@ -111,19 +120,65 @@ func SendPFCPRules(smContext *context.SMContext) {
 			}
 		}
 	}
+
+	var intermediates errgroup.Group
 	for ip, pfcp := range pfcpPool {
-		sessionContext, exist := smContext.PFCPContext[ip]
-		if !exist || sessionContext.RemoteSEID == 0 {
-			err := message.SendPfcpSessionEstablishmentRequest(
-				pfcp.nodeID, smContext, pfcp.pdrList, pfcp.farList, nil, pfcp.qerList, pfcp.port)
-			if err != nil {
-				logger.PduSessLog.Errorf("send pfcp session establishment request failed: %v for UPF[%v, %v]: ", err, pfcp.nodeID, pfcp.nodeID.ResolveNodeIdToIp())
+		if !anchors[ip] {
+			intermediates.Go(func() error {
+				return sendPfcpState(smContext, ip, pfcp)
+			})
+		}
+	}
+	if err := intermediates.Wait(); err != nil {
+		logger.PduSessLog.Warnf("PFCP rules of the intermediate UPFs not all sent: %v", err)
+	}
+
+	updateAnchorDownLinkTEIDs(smContext)
+	for ip := range anchors {
+		if err := sendPfcpState(smContext, ip, pfcpPool[ip]); err != nil {
+			logger.PduSessLog.Warnf("PFCP rules of the PSA UPF [%s] not sent: %v", ip, err)
+		}
+	}
+}
+
+// sendPfcpState sends the rules of the UPF in a PFCP Session Establishment Request, or in a PFCP
+// Session Modification Request when the session is already established on the UPF
+var sendPfcpState = func(smContext *context.SMContext, ip string, pfcp *PFCPState) error {
+	sessionContext, exist := smContext.PFCPContext[ip]
+	if !exist || sessionContext.RemoteSEID == 0 {
+		err := message.SendPfcpSessionEstablishmentRequest(
+			pfcp.nodeID, smContext, pfcp.pdrList, pfcp.farList, nil, pfcp.qerList, pfcp.port)
+		if err != nil {
+			logger.PduSessLog.Errorf("send pfcp session establishment request failed: %v for UPF[%v, %v]: ", err, pfcp.nodeID, pfcp.nodeID.ResolveNodeIdToIp())
+		}
+		return err
+	}
+	err := message.SendPfcpSessionModificationRequest(
+		pfcp.nodeID, smContext, pfcp.pdrList, pfcp.farList, nil, pfcp.qerList, pfcp.port)
+	if err != nil {
+		logger.PduSessLog.Errorf("send pfcp session modification request failed: %v for UPF[%v, %v]: ", err, pfcp.nodeID, pfcp.nodeID.ResolveNodeIdToIp())
+	}
+	return err
+}
+
+// updateAnchorDownLinkTEIDs sets in the downlink FARs of the PSA UPFs the TEIDs of the downlink
+// tunnels of the intermediate UPFs they forward to
+func updateAnchorDownLinkTEIDs(smContext *context.SMContext) {
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			prev := node.Prev()
+			if !node.IsAnchorUPF() || prev == nil || prev.DownLinkTunnel == nil || node.DownLinkTunnel == nil {
+				continue
 			}
-		} else {
-			err := message.SendPfcpSessionModificationRequest(
-				pfcp.nodeID, smContext, pfcp.pdrList, pfcp.farList, nil, pfcp.qerList, pfcp.port)
-			if err != nil {
-				logger.PduSessLog.Errorf("send pfcp session modification request failed: %v for UPF[%v, %v]: ", err, pfcp.nodeID, pfcp.nodeID.ResolveNodeIdToIp())
+			for _, pdr := range node.DownLinkTunnel.PDR {
+				if pdr.FAR == nil || pdr.FAR.ForwardingParameters == nil ||
+					pdr.FAR.ForwardingParameters.OuterHeaderCreation == nil {
+					continue
+				}
+				pdr.FAR.ForwardingParameters.OuterHeaderCreation.Teid = prev.DownLinkTunnel.TEID
 			}
 		}
 	}
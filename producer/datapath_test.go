@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+// newChainTestNode returns a data path node on the UPF of the IP, with a downlink PDR forwarding
+// to the next hop when forward is set
+func newChainTestNode(t *testing.T, ip string, forward bool) *smfContext.DataPathNode {
+	t.Helper()
+	nodeID := smfContext.NewNodeID(ip)
+	node := smfContext.NewDataPathNode()
+	node.UPF = smfContext.NewUPF(nodeID, nil)
+	t.Cleanup(func() { smfContext.RemoveUPFNodeByNodeID(*nodeID) })
+
+	far := &smfContext.FAR{FARID: 1}
+	if forward {
+		far.ForwardingParameters = &smfContext.ForwardingParameters{
+			OuterHeaderCreation: &smfContext.OuterHeaderCreation{},
+		}
+	}
+	node.DownLinkTunnel.PDR["default"] = &smfContext.PDR{PDRID: 1, FAR: far}
+	return node
+}
+
+func TestSendPFCPRulesChain(t *testing.T) {
+	// two intermediate UPFs of the same PSA UPF
+	psa1 := newChainTestNode(t, "10.200.0.10", true)
+	psa2 := newChainTestNode(t, "10.200.0.10", true)
+	psa2.UPF = psa1.UPF
+	iUPF1 := newChainTestNode(t, "10.200.0.11", false)
+	iUPF2 := newChainTestNode(t, "10.200.0.12", false)
+	for _, chain := range [][2]*smfContext.DataPathNode{{iUPF1, psa1}, {iUPF2, psa2}} {
+		chain[0].AddNext(chain[1])
+		chain[1].AddPrev(chain[0])
+	}
+
+	smContext := smfContext.NewSMContext("imsi-208930000000102", 10)
+	smContext.Tunnel = smfContext.NewUPTunnel()
+	smContext.Tunnel.DataPathPool[1] = &smfContext.DataPath{FirstDPNode: iUPF1, Activated: true, IsDefaultPath: true}
+	smContext.Tunnel.DataPathPool[2] = &smfContext.DataPath{FirstDPNode: iUPF2, Activated: true}
+
+	// the UPFs take the delay to answer, the intermediate UPFs allocate the TEID of their downlink
+	// tunnel
+	const delay = 100 * time.Millisecond
+	teids := map[string]uint32{"10.200.0.11": 0x1001, "10.200.0.12": 0x1002}
+	origSendPfcpState := sendPfcpState
+	t.Cleanup(func() { sendPfcpState = origSendPfcpState })
+	var mu sync.Mutex
+	var sent []string
+	var psaFARTEIDs []uint32
+	sendPfcpState = func(ctx *smfContext.SMContext, ip string, pfcp *PFCPState) error {
+		time.Sleep(delay)
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, ip)
+		if teid, ok := teids[ip]; ok {
+			for _, node := range []*smfContext.DataPathNode{iUPF1, iUPF2} {
+				if node.GetNodeIP() == ip {
+					node.DownLinkTunnel.TEID = teid
+				}
+			}
+			return nil
+		}
+		for _, far := range pfcp.farList {
+			psaFARTEIDs = append(psaFARTEIDs, far.ForwardingParameters.OuterHeaderCreation.Teid)
+		}
+		return nil
+	}
+
+	start := time.Now()
+	SendPFCPRules(smContext)
+	elapsed := time.Since(start)
+
+	// the intermediate UPFs are established concurrently, then the PSA UPF
+	require.Less(t, elapsed, 3*delay, "intermediate UPFs not established concurrently")
+	require.Len(t, sent, 3)
+	require.ElementsMatch(t, []string{"10.200.0.11", "10.200.0.12"}, sent[:2])
+	require.Equal(t, "10.200.0.10", sent[2])
+
+	// the PSA UPF forwards the downlink traffic to the tunnels allocated by the intermediate UPFs
+	require.ElementsMatch(t, []uint32{0x1001, 0x1002}, psaFARTEIDs)
+}
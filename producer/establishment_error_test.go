@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/smferrors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstablishmentError(t *testing.T) {
+	smContext := &smfContext.SMContext{Ref: "urn:uuid:3"}
+
+	// the code of the cause is kept
+	cause := fmt.Errorf("activate data path: %w", smfContext.ErrTEIDPoolExhausted)
+	err := establishmentError(smContext, smferrors.ErrCodeDataPathFailure, "DataPathError", cause)
+	var smfErr *smferrors.SMFError
+	require.True(t, errors.As(err, &smfErr))
+	require.Equal(t, smferrors.ErrCodeTEIDPoolExhausted, smfErr.Code)
+	require.Equal(t, "urn:uuid:3", smfErr.SessionRef)
+	require.ErrorIs(t, err, smfContext.ErrTEIDPoolExhausted)
+
+	// else the code of the failed step
+	err = establishmentError(smContext, smferrors.ErrCodeAMFDiscoveryFailure, "AmfError", errors.New("NRF unreachable"))
+	require.Equal(t, smferrors.ErrCodeAMFDiscoveryFailure, smferrors.CodeOf(err))
+	require.Equal(t, "AmfError: NRF unreachable", err.Error())
+}
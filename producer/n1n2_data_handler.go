@@ -28,7 +28,9 @@ type pfcpParam struct {
 	qerList []*context.QER
 }
 
-func HandleUpdateN1Msg(txn *transaction.Transaction, response *models.UpdateSmContextResponse, pfcpAction *pfcpAction) error {
+func HandleUpdateN1Msg(txn *transaction.Transaction, response *models.UpdateSmContextResponse, pfcpAction *pfcpAction,
+	pfcpParam *pfcpParam,
+) error {
 	body := txn.Req.(models.UpdateSmContextRequest)
 	smContext := txn.Ctxt.(*context.SMContext)
 
@@ -101,6 +103,14 @@ func HandleUpdateN1Msg(txn *transaction.Transaction, response *models.UpdateSmCo
 				smContext.ChangeState(context.SmStateModify)
 				smContext.SubCtxLog.Debugln("PDUSessionSMContextUpdate, SMContextState Change State:", smContext.SMContextState.String())
 			}
+		case nas.MsgTypePDUSessionModificationRequest:
+			smContext.SubPduSessLog.Infoln("PDUSessionSMContextUpdate, N1 Msg PDU Session Modification Request received")
+			HandlePsDataOffModification(smContext, m.PDUSessionModificationRequest, response, pfcpAction, pfcpParam)
+		case nas.MsgTypePDUSessionAuthenticationComplete:
+			smContext.SubPduSessLog.Infoln("PDUSessionSMContextUpdate, N1 Msg PDU Session Authentication Complete received")
+			if !smContext.DeliverEAPResponse(m.PDUSessionAuthenticationComplete.GetEAPMessage()) {
+				smContext.SubPduSessLog.Warnln("PDUSessionSMContextUpdate, EAP response dropped, session not being authenticated")
+			}
 		case nas.MsgTypePDUSessionReleaseComplete:
 			smContext.SubPduSessLog.Infoln("PDUSessionSMContextUpdate, N1 Msg PDU Session Release Complete received")
 			if smContext.SMContextState != context.SmStateInActivePending {
@@ -158,6 +168,8 @@ func HandleUpCnxState(txn *transaction.Transaction, response *models.UpdateSmCon
 			smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, build PDUSession Resource Setup Request Transfer Error(%s)", err.Error())
 		}
 		smContext.UpCnxState = models.UpCnxState_ACTIVATING
+		// the UE answered the paging, the next DDN pages it again
+		smContext.ResetDDNThrottling()
 		response.BinaryDataN2SmInformation = n2Buf
 		response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_SETUP_REQ
 	case models.UpCnxState_DEACTIVATED:
@@ -175,29 +187,8 @@ func HandleUpCnxState(txn *transaction.Transaction, response *models.UpdateSmCon
 			smContext.UeLocation = body.JsonData.UeLocation
 			// TODO: Deactivate N2 downlink tunnel
 			// Set FAR and An, N3 Release Info
-			farList := []*context.FAR{}
-			smContext.PendingUPF = make(context.PendingUPF)
-			for _, dataPath := range smContext.Tunnel.DataPathPool {
-				ANUPF := dataPath.FirstDPNode
-				for _, DLPDR := range ANUPF.DownLinkTunnel.PDR {
-					if DLPDR == nil {
-						smContext.SubPduSessLog.Errorf("AN Release Error")
-					} else {
-						DLPDR.FAR.State = context.RULE_UPDATE
-						DLPDR.FAR.ApplyAction.Forw = false
-						DLPDR.FAR.ApplyAction.Buff = true
-						DLPDR.FAR.ApplyAction.Nocp = true
-						// Set DL Tunnel info to nil
-						if DLPDR.FAR.ForwardingParameters != nil {
-							DLPDR.FAR.ForwardingParameters.OuterHeaderCreation = nil
-						}
-						smContext.PendingUPF[ANUPF.GetNodeIP()] = true
-						farList = append(farList, DLPDR.FAR)
-					}
-				}
-			}
-
-			pfcpParam.farList = append(pfcpParam.farList, farList...)
+			bufferDownlinkData(smContext, pfcpParam)
+			smContext.ReleaseANTunnel()
 
 			pfcpAction.sendPfcpModify = true
 			smContext.ChangeState(context.SmStatePfcpModify)
@@ -207,6 +198,30 @@ func HandleUpCnxState(txn *transaction.Transaction, response *models.UpdateSmCon
 	return nil
 }
 
+// bufferDownlinkData sets the downlink FARs of the session on the AN UPFs to buffering, the UPF
+// notifying the arrival of downlink data to page the UE
+func bufferDownlinkData(smContext *context.SMContext, pfcpParam *pfcpParam) {
+	farList := []*context.FAR{}
+	smContext.PendingUPF = make(context.PendingUPF)
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		ANUPF := dataPath.FirstDPNode
+		for _, DLPDR := range ANUPF.DownLinkTunnel.PDR {
+			if DLPDR == nil {
+				smContext.SubPduSessLog.Errorf("AN Release Error")
+			} else {
+				DLPDR.FAR.State = context.RULE_UPDATE
+				// buffer the downlink packets and notify their arrival to page the UE,
+				// the DL tunnel info being removed
+				DLPDR.FAR.SetBuffering(ANUPF.UPF)
+				smContext.PendingUPF[ANUPF.GetNodeIP()] = true
+				farList = append(farList, DLPDR.FAR)
+			}
+		}
+	}
+
+	pfcpParam.farList = append(pfcpParam.farList, farList...)
+}
+
 func HandleUpdateHoState(txn *transaction.Transaction, response *models.UpdateSmContextResponse) error {
 	body := txn.Req.(models.UpdateSmContextRequest)
 	smContext := txn.Ctxt.(*context.SMContext)
@@ -323,6 +338,23 @@ func HandleUpdateCause(txn *transaction.Transaction, response *models.UpdateSmCo
 	return nil
 }
 
+// HandleUpdateRatType records the RAT type reported by the AMF, a change of RAT closing the usage
+// measured on the previous one in a charging update
+func HandleUpdateRatType(txn *transaction.Transaction) {
+	body := txn.Req.(models.UpdateSmContextRequest)
+	smContext := txn.Ctxt.(*context.SMContext)
+
+	update := smContext.UpdateRATType(body.JsonData.RatType)
+	if update == nil {
+		return
+	}
+	smContext.SubPduSessLog.Infof("PDUSessionSMContextUpdate, RAT type changed from %s to %s",
+		update.PreviousRATType, update.RATType)
+	if err := consumer.SendChargingDataUpdate(smContext, update); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, charging data update failed: %v", err)
+	}
+}
+
 func HandleUpdateN2Msg(txn *transaction.Transaction, response *models.UpdateSmContextResponse, pfcpAction *pfcpAction, pfcpParam *pfcpParam) error {
 	body := txn.Req.(models.UpdateSmContextRequest)
 	smContext := txn.Ctxt.(*context.SMContext)
@@ -384,10 +416,12 @@ func HandleUpdateN2Msg(txn *transaction.Transaction, response *models.UpdateSmCo
 	case models.N2SmInfoType_PDU_RES_SETUP_FAIL:
 		smContext.SubPduSessLog.Infof("PDUSessionSMContextUpdate, N2 SM info type %v received",
 			smContextUpdateData.N2SmInfoType)
-		if err := context.
-			HandlePDUSessionResourceSetupResponseTransfer(body.BinaryDataN2SmInformation, smContext); err != nil {
-			smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, handle PDUSessionResourceSetupResponseTransfer failed: %+v", err)
+		cause, err := context.HandlePDUSessionResourceSetupUnsuccessfulTransfer(body.BinaryDataN2SmInformation)
+		if err != nil {
+			smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, handle PDUSessionResourceSetupUnsuccessfulTransfer failed: %+v", err)
+			break
 		}
+		HandleRadioBearerFailure(smContext, cause, response, pfcpAction, pfcpParam)
 	case models.N2SmInfoType_PDU_RES_REL_RSP:
 		smContext.SubPduSessLog.Infof("PDUSessionSMContextUpdate, N2 SM info type %v received",
 			smContextUpdateData.N2SmInfoType)
@@ -494,6 +528,29 @@ func HandleUpdateN2Msg(txn *transaction.Transaction, response *models.UpdateSmCo
 		if err := context.HandlePathSwitchRequestSetupFailedTransfer(body.BinaryDataN2SmInformation, smContext); err != nil {
 			smContext.SubPduSessLog.Error()
 		}
+	case models.N2SmInfoType_PDU_RES_NTY:
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextUpdate, N2 SM info type %v received",
+			smContextUpdateData.N2SmInfoType)
+		releasedFlows, err := context.HandlePDUSessionResourceNotifyTransfer(body.BinaryDataN2SmInformation)
+		if err != nil {
+			smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, handle PDUSessionResourceNotifyTransfer failed: %+v", err)
+			break
+		}
+		smContext.ChangeState(context.SmStateModify)
+		smContext.SubCtxLog.Debugln("PDUSessionSMContextUpdate, SMContextState Change State:", smContext.SMContextState.String())
+		if err = HandleANQoSReleaseNotification(smContext.Ref, releasedFlows); err != nil {
+			smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, handle released QoS flows failed: %+v", err)
+		}
+	case context.N2SmInfoTypeSecondaryRATUsage:
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextUpdate, N2 SM info type %v received",
+			smContextUpdateData.N2SmInfoType)
+		// sent to the CHF in the next Charging Data Request of the session
+		usage, err := context.HandleSecondaryRATDataUsageReportTransfer(body.BinaryDataN2SmInformation)
+		if err != nil {
+			smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, handle SecondaryRATDataUsageReportTransfer failed: %+v", err)
+			break
+		}
+		smContext.AddSecondaryRATUsage(usage)
 	case models.N2SmInfoType_HANDOVER_REQUIRED:
 		smContext.SubPduSessLog.Infof("PDUSessionSMContextUpdate, N2 SM info type %v received",
 			smContextUpdateData.N2SmInfoType)
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+
+	"github.com/omec-project/smf/consumer"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/eap"
+)
+
+// NonThreeGPPAccessHandler authenticates with EAP-AKA' the UE establishing a session over trusted
+// non-3GPP access, when configured. The authentication vector of the UE is generated by the UDM
+// for the serving network of the session, the EAP messages being relayed to the UE over N1.
+type NonThreeGPPAccessHandler struct {
+	smContext *smf_context.SMContext
+}
+
+// NewNonThreeGPPAccessHandler returns the handler of the session, nil if the UE is not to be
+// authenticated with EAP-AKA'
+func NewNonThreeGPPAccessHandler(smContext *smf_context.SMContext) *NonThreeGPPAccessHandler {
+	if !smf_context.SMF_Self().EapAkaPrimeNon3gppAccess || !smContext.IsTrustedNon3GPPAccess() {
+		return nil
+	}
+	return &NonThreeGPPAccessHandler{smContext: smContext}
+}
+
+// Authenticator returns the EAP-AKA' server authenticating the UE of the session
+func (h *NonThreeGPPAccessHandler) Authenticator() (eap.Authenticator, error) {
+	networkName, err := servingNetworkName(h.smContext)
+	if err != nil {
+		return nil, err
+	}
+	return &eap.AKAPrimeServer{
+		NetworkName: networkName,
+		Vector: func() (*eap.Vector, error) {
+			return consumer.SendGenerateAuthData(h.smContext, networkName)
+		},
+	}, nil
+}
+
+// servingNetworkName returns the serving network name of the session, TS 24.501 9.12.1
+func servingNetworkName(smContext *smf_context.SMContext) (string, error) {
+	plmn := smContext.ServingNetwork
+	if plmn == nil {
+		return "", fmt.Errorf("session without serving network")
+	}
+	mnc := plmn.Mnc
+	if len(mnc) == 2 {
+		mnc = "0" + mnc
+	}
+	return fmt.Sprintf("5G:mnc%s.mcc%s.3gppnetwork.org", mnc, plmn.Mcc), nil
+}
@@ -6,11 +6,17 @@
 package producer
 
 import (
+	"errors"
+	"maps"
+	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/omec-project/openapi/models"
 	"github.com/omec-project/smf/context"
+	pfcp_upf "github.com/omec-project/smf/pfcp/upf"
 	"github.com/omec-project/util/httpwrapper"
 )
 
@@ -25,6 +31,10 @@ type PDUSessionInfo struct {
 	SessionRule  models.SessionRule
 	UpCnxState   models.UpCnxState
 	Tunnel       context.UPTunnel
+	// last packet delay of each QoS flow reported by the QoS monitoring
+	QoSMonitoring []context.QoSMonitoringReport
+	// outcome of the last UE reachability probe, omitted if the UE was never probed
+	UEReachability *context.UEReachability `json:",omitempty"`
 }
 
 func HandleOAMGetUEPDUSessionInfo(smContextRef string) *httpwrapper.Response {
@@ -43,14 +53,16 @@ func HandleOAMGetUEPDUSessionInfo(smContextRef string) *httpwrapper.Response {
 		Header: nil,
 		Status: http.StatusOK,
 		Body: PDUSessionInfo{
-			Supi:         smContext.Supi,
-			PDUSessionID: strconv.Itoa(int(smContext.PDUSessionID)),
-			Dnn:          smContext.Dnn,
-			Sst:          strconv.Itoa(int(smContext.Snssai.Sst)),
-			Sd:           smContext.Snssai.Sd,
-			AnType:       smContext.AnType,
-			PDUAddress:   smContext.PDUAddress.Ip.String(),
-			UpCnxState:   smContext.UpCnxState,
+			Supi:           smContext.Supi,
+			PDUSessionID:   strconv.Itoa(int(smContext.PDUSessionID)),
+			Dnn:            smContext.Dnn,
+			Sst:            strconv.Itoa(int(smContext.Snssai.Sst)),
+			Sd:             smContext.Snssai.Sd,
+			AnType:         smContext.AnType,
+			PDUAddress:     smContext.PDUAddress.Ip.String(),
+			UpCnxState:     smContext.UpCnxState,
+			QoSMonitoring:  smContext.QoSMonitoringReports(),
+			UEReachability: smContext.UEReachability,
 			// Tunnel: context.UPTunnel{
 			// 	//UpfRoot:  smContext.Tunnel.UpfRoot,
 			// 	ULCLRoot: smContext.Tunnel.UpfRoot,
@@ -59,3 +71,183 @@ func HandleOAMGetUEPDUSessionInfo(smContextRef string) *httpwrapper.Response {
 	}
 	return httpResponse
 }
+
+type UPFStatusInfo struct {
+	Name      string
+	NodeID    string
+	Status    string
+	Sessions  int64
+	LastError *context.UPFError `json:",omitempty"`
+}
+
+// HandleOAMGetUPFStatus returns the association status of the UPFs with their last error, for
+// the operators to know why a UPF is not associated
+func HandleOAMGetUPFStatus() *httpwrapper.Response {
+	upfs := make([]UPFStatusInfo, 0)
+	if upi := context.GetUserPlaneInformation(); upi != nil {
+		for _, name := range slices.Sorted(maps.Keys(upi.UPFs)) {
+			upNode := upi.UPFs[name]
+			if upNode == nil || upNode.UPF == nil {
+				continue
+			}
+			upNode.UPF.UpfLock.RLock()
+			status := upNode.UPF.UPFStatus
+			upNode.UPF.UpfLock.RUnlock()
+			upfs = append(upfs, UPFStatusInfo{
+				Name:      name,
+				NodeID:    upNode.NodeID.ResolveNodeIdToIp().String(),
+				Status:    status.String(),
+				Sessions:  upNode.UPF.SessionCount(),
+				LastError: upNode.UPF.LastError(),
+			})
+		}
+	}
+	return &httpwrapper.Response{
+		Header: nil,
+		Status: http.StatusOK,
+		Body:   upfs,
+	}
+}
+
+// HandleOAMGetIPPoolStats returns the usage of the UE IP pools, per slice and DNN
+func HandleOAMGetIPPoolStats() *httpwrapper.Response {
+	return &httpwrapper.Response{
+		Header: nil,
+		Status: http.StatusOK,
+		Body:   context.SMF_Self().PoolStats(),
+	}
+}
+
+// UPFReassociationInfo is the outcome of the OAM re-association of a UPF
+type UPFReassociationInfo struct {
+	// sessions released as the UPF no longer serves their slice or DNN
+	ReleasedSessions []string
+}
+
+// HandleOAMReassociateUPF tears down and sets up again the PFCP association with the UPF of the
+// node ID, e.g. after a change of its configuration
+func HandleOAMReassociateUPF(nodeID string) *httpwrapper.Response {
+	invalid, err := pfcp_upf.ReassociateUPF(nodeID)
+	if errors.Is(err, pfcp_upf.ErrUnknownUPF) {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusNotFound,
+			Body:   nil,
+		}
+	}
+	if err != nil {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusBadGateway,
+			Body: models.ProblemDetails{
+				Status: http.StatusBadGateway,
+				Detail: err.Error(),
+			},
+		}
+	}
+	info := UPFReassociationInfo{ReleasedSessions: make([]string, 0, len(invalid))}
+	for _, smContext := range invalid {
+		info.ReleasedSessions = append(info.ReleasedSessions, smContext.Ref)
+	}
+	return &httpwrapper.Response{
+		Header: nil,
+		Status: http.StatusOK,
+		Body:   info,
+	}
+}
+
+// SMContextTransferRequest is the OAM request transferring a session to the new SMF of an
+// inter-SMF handover
+type SMContextTransferRequest struct {
+	TargetSmfUri string `json:"targetSmfUri"`
+}
+
+func HandleOAMTransferSMContext(smContextRef string, request SMContextTransferRequest) *httpwrapper.Response {
+	if context.GetSMContext(smContextRef) == nil {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusNotFound,
+			Body:   nil,
+		}
+	}
+	if request.TargetSmfUri == "" {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusBadRequest,
+			Body: models.ProblemDetails{
+				Status: http.StatusBadRequest,
+				Detail: "targetSmfUri missing",
+			},
+		}
+	}
+	if err := TransferSMContext(smContextRef, request.TargetSmfUri); err != nil {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusBadGateway,
+			Body: models.ProblemDetails{
+				Status: http.StatusBadGateway,
+				Detail: err.Error(),
+			},
+		}
+	}
+	return &httpwrapper.Response{
+		Header: nil,
+		Status: http.StatusNoContent,
+		Body:   nil,
+	}
+}
+
+// UEReachabilityCheckRequest is the OAM request probing the reachability of the UE of a session
+type UEReachabilityCheckRequest struct {
+	// ICMP echo responder the UE replies to
+	ResponderIp string `json:"responderIp"`
+	// time given to the UE to reply, pfcp_upf.DefaultReachabilityProbeWindow if not set, up to
+	// pfcp_upf.MaxReachabilityProbeWindow
+	WindowSec int `json:"windowSec,omitempty"`
+}
+
+// HandleOAMCheckUEReachability probes the reachability of the UE of the session through its AN UPF
+// and returns the outcome, also recorded in the session
+func HandleOAMCheckUEReachability(smContextRef string, request UEReachabilityCheckRequest) *httpwrapper.Response {
+	smContext := context.GetSMContext(smContextRef)
+	if smContext == nil {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusNotFound,
+			Body:   nil,
+		}
+	}
+	responderIP := net.ParseIP(request.ResponderIp).To4()
+	if responderIP == nil || request.WindowSec < 0 ||
+		request.WindowSec > int(pfcp_upf.MaxReachabilityProbeWindow/time.Second) {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusBadRequest,
+			Body: models.ProblemDetails{
+				Status: http.StatusBadRequest,
+				Detail: "invalid responderIp or windowSec",
+			},
+		}
+	}
+	probe := &pfcp_upf.UEReachabilityProbe{
+		ResponderIP: responderIP,
+		Window:      time.Duration(request.WindowSec) * time.Second,
+	}
+	if _, err := probe.CheckUEReachability(smContext); err != nil {
+		return &httpwrapper.Response{
+			Header: nil,
+			Status: http.StatusBadGateway,
+			Body: models.ProblemDetails{
+				Status: http.StatusBadGateway,
+				Detail: err.Error(),
+			},
+		}
+	}
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	return &httpwrapper.Response{
+		Header: nil,
+		Status: http.StatusOK,
+		Body:   *smContext.UEReachability,
+	}
+}
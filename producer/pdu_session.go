@@ -8,14 +8,15 @@ package producer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/antihax/optional"
 	"github.com/omec-project/nas"
 	"github.com/omec-project/nas/nasMessage"
 	"github.com/omec-project/openapi"
-	"github.com/omec-project/openapi/Namf_Communication"
 	"github.com/omec-project/openapi/Nsmf_PDUSession"
 	"github.com/omec-project/openapi/Nudm_SubscriberDataManagement"
 	"github.com/omec-project/openapi/models"
@@ -26,6 +27,7 @@ import (
 	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
 	pfcp_message "github.com/omec-project/smf/pfcp/message"
 	"github.com/omec-project/smf/qos"
+	"github.com/omec-project/smf/smferrors"
 	"github.com/omec-project/smf/transaction"
 	"github.com/omec-project/util/httpwrapper"
 )
@@ -70,9 +72,16 @@ func HandlePduSessionContextReplacement(smCtxtRef string) error {
 	return nil
 }
 
+// CreateSmContextRequest is the Create SM Context Request of the AMF, with its indication, absent
+// from the model, that the UE requests a Multi-Access PDU session. TS 29.502 6.1.6.2.2
+type CreateSmContextRequest struct {
+	models.PostSmContextsRequest
+	MaRequestInd bool
+}
+
 func HandlePDUSessionSMContextCreate(eventData interface{}) error {
 	txn := eventData.(*transaction.Transaction)
-	request := txn.Req.(models.PostSmContextsRequest)
+	request := txn.Req.(CreateSmContextRequest)
 	smContext := txn.Ctxt.(*smf_context.SMContext)
 
 	// GSM State
@@ -87,7 +96,7 @@ func HandlePDUSessionSMContextCreate(eventData interface{}) error {
 		logger.PduSessLog.Errorln("PDUSessionSMContextCreate, GsmMessageDecode Error:", err)
 
 		txn.Rsp = formContextCreateErrRsp(http.StatusForbidden, &Nsmf_PDUSession.N1SmError, nil)
-		return fmt.Errorf("GsmMsgDecodeError")
+		return establishmentError(smContext, smferrors.ErrCodeN1MessageInvalid, "GsmMsgDecodeError", err)
 	}
 
 	createData := request.JsonData
@@ -95,45 +104,63 @@ func HandlePDUSessionSMContextCreate(eventData interface{}) error {
 	// Create SM context
 	// smContext := smf_context.NewSMContext(createData.Supi, createData.PduSessionId)
 	smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, SM context created")
+	// the establishment waits for the back-off of the serving AMF while it is overloaded
+	if waited := smf_context.GetOverloadController().Wait(createData.ServingNfId); waited > 0 {
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, delayed %v by the overload of AMF[%s]",
+			waited, createData.ServingNfId)
+	}
 	// smContext.ChangeState(smf_context.SmStateActivePending)
 	smContext.SubCtxLog.Debugln("PDUSessionSMContextCreate, SMContextState change state:", smContext.SMContextState.String())
-	smContext.SetCreateData(createData)
-	smContext.SmStatusNotifyUri = createData.SmContextStatusUri
-
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
 
-	// DNN Information from config
-	smContext.DNNInfo = smf_context.RetrieveDnnInformation(*createData.SNssai, createData.Dnn)
-	if smContext.DNNInfo == nil {
+	smContext.SetCreateData(createData)
+	smContext.SmStatusNotifyUri = createData.SmContextStatusUri
+
+	// Network slice and DNN Information from config, the UE IP pool and UPFs of the session
+	// being the ones of the selected slice
+	if !smContext.SelectDNNInfo() {
 		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, S-NSSAI[sst: %d, sd: %s] DNN[%s] not matched DNN Config",
 			createData.SNssai.Sst, createData.SNssai.Sd, createData.Dnn)
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("DnnNotSupported")
-		return fmt.Errorf("SnssaiError")
+		return establishmentError(smContext, smferrors.ErrCodeDnnNotSupported, "SnssaiError", nil)
+	}
+	selectATSSS(smContext, request.MaRequestInd)
+
+	// the establishment waits for a session of the DNN to be released while the DNN is at its
+	// limit of concurrent sessions
+	if err := smContext.AcquireSessionSlot(); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, DNN[%s]: %v", createData.Dnn, err)
+		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("MaxConcurrentSessionsReached")
+		return establishmentError(smContext, smferrors.ErrCodeSessionQueueFull, "MaxConcurrentSessionsReached", err)
 	}
 
+	// UP security policy from config
+	smContext.UPIntegrityProtection = smf_context.SMF_Self().GetDnnUpIntegrityProtection(createData.Dnn)
+
 	// Query UDM
+	udmStart := time.Now()
 	if problemDetails, err := consumer.SendNFDiscoveryUDM(); err != nil {
 		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, send NF Discovery Serving UDM Error[%v]", err)
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("UDMDiscoveryFailure")
-		return fmt.Errorf("UdmError")
+		return establishmentError(smContext, smferrors.ErrCodeUDMDiscoveryFailure, "UdmError", err)
 	} else if problemDetails != nil {
 		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, send NF Discovery Serving UDM Problem[%+v]", problemDetails)
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("UDMDiscoveryFailure")
-		return fmt.Errorf("UdmError")
+		return establishmentError(smContext, smferrors.ErrCodeUDMDiscoveryFailure, "UdmError", nil)
 	} else {
 		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, send NF Discovery Serving UDM Successful")
 	}
+	udmDuration := time.Since(udmStart)
+
+	// a session lost with its UPF returns to it once associated again, keeping its IP
+	sessionAnchor := smContext.TakeSessionAnchor()
 
 	// IP Allocation
-	if ip, err := smContext.DNNInfo.UeIPAllocator.Allocate(smContext.Supi); err != nil {
+	if err := assignPDUAddress(smContext, sessionAnchor); err != nil {
 		smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, failed allocate IP address: ", err)
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("IpAllocError")
-		return fmt.Errorf("IpAllocError")
-	} else {
-		smContext.PDUAddress = &smf_context.UeIpAddr{Ip: ip, UpfProvided: false}
-		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, IP alloc success IP[%s]",
-			smContext.PDUAddress.Ip.String())
+		return establishmentError(smContext, smferrors.ErrCodeIPPoolExhausted, "IpAllocError", err)
 	}
 
 	// UDM-Fetch Subscription Data based on servingnetwork.plmn and dnn, snssai
@@ -153,28 +180,37 @@ func HandlePDUSessionSMContextCreate(eventData interface{}) error {
 	SubscriberDataManagementClient := smf_context.SMF_Self().SubscriberDataManagementClient
 	metrics.IncrementSvcUdmMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmSubscriptionDataRetrieval), "Out", "", "")
 
-	if sessSubData, rsp, err := SubscriberDataManagementClient.
+	udmStart = time.Now()
+	sessSubData, rsp, err := SubscriberDataManagementClient.
 		SessionManagementSubscriptionDataRetrievalApi.
-		GetSmData(context.Background(), smContext.Supi, smDataParams); err != nil {
+		GetSmData(context.Background(), smContext.Supi, smDataParams)
+	observeSessionSetupPhase(smContext, metrics.SessionSetupUdmQuery, udmDuration+time.Since(udmStart))
+	if err != nil {
 		metrics.IncrementSvcUdmMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmSubscriptionDataRetrieval), "In", http.StatusText(rsp.StatusCode), err.Error())
 		smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, get SessionManagementSubscriptionData error: ", err)
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("SubscriptionDataFetchError")
-		return fmt.Errorf("SubscriptionError")
+		// the subscriber is unknown to the UDM
+		return &smf_context.SessionError{
+			Err:          establishmentError(smContext, smferrors.ErrCodeSubscriptionDataFailure, "SubscriptionError", err),
+			NonRetriable: rsp != nil && rsp.StatusCode == http.StatusNotFound,
+		}
+	}
+	defer func() {
+		if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+			smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, GetSmData response body cannot close: %+v", rspCloseErr)
+		}
+	}()
+	if len(sessSubData) > 0 {
+		metrics.IncrementSvcUdmMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmSubscriptionDataRetrieval), "In", http.StatusText(rsp.StatusCode), "")
+		smContext.DnnConfiguration = sessSubData[0].DnnConfigurations[smContext.Dnn]
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, subscription data retrieved from UDM")
 	} else {
-		defer func() {
-			if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
-				smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, GetSmData response body cannot close: %+v", rspCloseErr)
-			}
-		}()
-		if len(sessSubData) > 0 {
-			metrics.IncrementSvcUdmMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmSubscriptionDataRetrieval), "In", http.StatusText(rsp.StatusCode), "")
-			smContext.DnnConfiguration = sessSubData[0].DnnConfigurations[smContext.Dnn]
-			smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, subscription data retrieved from UDM")
-		} else {
-			metrics.IncrementSvcUdmMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmSubscriptionDataRetrieval), "In", http.StatusText(rsp.StatusCode), "NilSubscriptionData")
-			smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, SessionManagementSubscriptionData from UDM is nil")
-			txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("SubscriptionDataLenError")
-			return fmt.Errorf("NoSubscriptionError")
+		metrics.IncrementSvcUdmMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmSubscriptionDataRetrieval), "In", http.StatusText(rsp.StatusCode), "NilSubscriptionData")
+		smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, SessionManagementSubscriptionData from UDM is nil")
+		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("SubscriptionDataLenError")
+		return &smf_context.SessionError{
+			Err:          establishmentError(smContext, smferrors.ErrCodeSubscriptionDataFailure, "NoSubscriptionError", nil),
+			NonRetriable: true,
 		}
 	}
 
@@ -185,52 +221,105 @@ func HandlePDUSessionSMContextCreate(eventData interface{}) error {
 	if smContext.SelectedPDUSessionType == nasMessage.PDUSessionTypeUnstructured {
 		smContext.SubPduSessLog.Errorf("Unstructured PDU Session Not Supported")
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PDUSessionTypeIPv4OnlyAllowed")
-		return fmt.Errorf("unstructured PDU Session not supported")
+		return establishmentError(smContext, smferrors.ErrCodePDUSessionTypeNotAllowed, "unstructured PDU Session not supported", nil)
+	}
+	if err := smContext.MatchPDUSessionTypeToUePool(); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, %v", err)
+		if errors.Is(err, smf_context.ErrPDUSessionTypeNotAllowed) {
+			txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PDUSessionTypeNotAllowed")
+		} else {
+			txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PDUSessionTypeIPv4OnlyAllowed")
+		}
+		return establishmentError(smContext, smferrors.ErrCodePDUSessionTypeNotAllowed, "PduSessionTypeError", err)
 	}
 
+	// PCF Policy Association, falls back to the DNN default QoS if PCF is not available
+	var smPolicyDecision *models.SmPolicyDecision
+	pcfStart := time.Now()
 	if err := smContext.PCFSelection(); err != nil {
 		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, send NF Discovery Serving PCF Error[%v]", err)
-		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PCFDiscoveryFailure")
-		return fmt.Errorf("PcfError")
+		if smPolicyDecision = fallbackSmPolicyDecision(smContext); smPolicyDecision == nil {
+			txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PCFDiscoveryFailure")
+			return establishmentError(smContext, smferrors.ErrCodePCFDiscoveryFailure, "PcfError", err)
+		}
+	} else {
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, send NF Discovery Serving PCF success")
+
+		// the UE location is validated against the service area restriction of its AM policy,
+		// the session proceeds when the PCF offers no AM policy
+		if amPolicyClient := consumer.AMPolicyClientForPCF(smContext.SelectedPCFProfile); amPolicyClient != nil {
+			if amPolicy, err := amPolicyClient.GetAMPolicy(smContext.Supi); err != nil {
+				smContext.SubPduSessLog.Warnf("PDUSessionSMContextCreate, AM policy retrieval error: %v", err)
+			} else if err = amPolicy.CheckServiceArea(smContext.UeLocation); err != nil {
+				smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, service area restriction: %v", err)
+				txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("ServiceAreaRestricted")
+				return establishmentError(smContext, smferrors.ErrCodeServiceAreaRestricted, "ServiceAreaRestricted", err)
+			}
+		}
+
+		metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationCreate), "Out", "", "")
+		if smPolicyDecisionRsp, httpStatus, err := consumer.SendSMPolicyAssociationCreate(smContext); err != nil {
+			metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationCreate), "In", http.StatusText(httpStatus), err.Error())
+			smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, SMPolicyAssociationCreate error: ", err)
+		} else if httpStatus != http.StatusCreated {
+			metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationCreate), "In", http.StatusText(httpStatus), "error")
+			smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, SMPolicyAssociationCreate http status: ", http.StatusText(httpStatus))
+		} else {
+			smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, Policy association create success")
+			smPolicyDecision = smPolicyDecisionRsp
+		}
+		observeSessionSetupPhase(smContext, metrics.SessionSetupPcfQuery, time.Since(pcfStart))
+
+		if smPolicyDecision == nil {
+			if smPolicyDecision = fallbackSmPolicyDecision(smContext); smPolicyDecision == nil {
+				txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PCFPolicyCreateFailure")
+				return establishmentError(smContext, smferrors.ErrCodePCFPolicyFailure, "PcfAssoError", nil)
+			}
+		}
 	}
-	smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, send NF Discovery Serving PCF success")
 
-	// PCF Policy Association
-	var smPolicyDecision *models.SmPolicyDecision
-	metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationCreate), "Out", "", "")
-	if smPolicyDecisionRsp, httpStatus, err := consumer.SendSMPolicyAssociationCreate(smContext); err != nil {
-		metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationCreate), "In", http.StatusText(httpStatus), err.Error())
-		smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, SMPolicyAssociationCreate error: ", err)
-		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PCFPolicyCreateFailure")
-		return fmt.Errorf("PcfAssoError")
-	} else if httpStatus != http.StatusCreated {
-		metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationCreate), "In", http.StatusText(httpStatus), "error")
-		smContext.SubPduSessLog.Errorln("PDUSessionSMContextCreate, SMPolicyAssociationCreate http status: ", http.StatusText(httpStatus))
-		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("PCFPolicyCreateFailure")
-		return fmt.Errorf("PcfAssoError")
-	} else {
-		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, Policy association create success")
-		smPolicyDecision = smPolicyDecisionRsp
+	// smPolicyDecision = qos.TestMakeSamplePolicyDecision()
+	limitEstablishmentQoSFlows(smContext, smPolicyDecision)
+
+	// Derive QoS change(compare existing vs received Policy Decision)
+	smContext.SubQosLog.Infof("PDUSessionSMContextCreate, received SM policy data: %v",
+		qos.SmPolicyDecisionString(smPolicyDecision))
+	policyUpdates := qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, smPolicyDecision)
+	smContext.SubQosLog.Infof("PDUSessionSMContextCreate, generated SM policy update: %v",
+		policyUpdates)
+	if err := smContext.CheckSessionRuleLimits(policyUpdates); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, %v", err)
+		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("SessionRuleLimitExceeded")
+		return establishmentError(smContext, smferrors.ErrCodeSessionRuleLimit, "SessionRuleLimitExceeded", err)
+	}
+	smContext.SmPolicyUpdates = append(smContext.SmPolicyUpdates, policyUpdates)
 
-		// smPolicyDecision = qos.TestMakeSamplePolicyDecision()
-		// Derive QoS change(compare existing vs received Policy Decision)
-		smContext.SubQosLog.Infof("PDUSessionSMContextCreate, received SM policy data: %v",
-			qos.SmPolicyDecisionString(smPolicyDecision))
-		policyUpdates := qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, smPolicyDecision)
-		smContext.SubQosLog.Infof("PDUSessionSMContextCreate, generated SM policy update: %v",
-			policyUpdates)
-		smContext.SmPolicyUpdates = append(smContext.SmPolicyUpdates, policyUpdates)
+	// when the sessions of the DNN preempt each other, the session slot is taken once the ARP of
+	// the session is authorized, the preempted session being released
+	var sessionRule *models.SessionRule
+	if policyUpdates.SessRuleUpdate != nil {
+		sessionRule = policyUpdates.SessRuleUpdate.ActiveSessRule
+	}
+	if preempted, err := smContext.AcquireSessionSlotByArp(smf_context.SessionRuleArp(sessionRule)); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, DNN[%s]: %v", createData.Dnn, err)
+		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("MaxConcurrentSessionsReached")
+		return establishmentError(smContext, smferrors.ErrCodeSessionQueueFull, "MaxConcurrentSessionsReached", err)
+	} else if preempted != nil {
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, DNN[%s] at its session limit, session[%s] preempted",
+			createData.Dnn, preempted.Ref)
+		go releasePreemptedSession(preempted)
 	}
 
 	// dataPath selection
 	smContext.Tunnel = smf_context.NewUPTunnel()
 	var defaultPath *smf_context.DataPath
-	upfSelectionParams := &smf_context.UPFSelectionParams{
-		Dnn: createData.Dnn,
-		SNssai: &smf_context.SNssai{
-			Sst: createData.SNssai.Sst,
-			Sd:  createData.SNssai.Sd,
-		},
+	upfSelectionParams := smContext.UPFSelectionParams()
+
+	upfSelectionStart := time.Now()
+	if err := waitForAssociatedUPF(smContext, upfSelectionParams); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, %v", err)
+		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("UPFUnavailable")
+		return establishmentError(smContext, smferrors.ErrCodeUPFNotAssociated, "UPFUnavailable", err)
 	}
 
 	if smf_context.SMF_Self().ULCLSupport && smf_context.CheckUEHasPreConfig(createData.Supi) {
@@ -248,48 +337,60 @@ func HandlePDUSessionSMContextCreate(eventData interface{}) error {
 		// UE has no pre-config path.
 		// Use default route
 		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, no pre-config route")
-		defaultUPPath := smf_context.GetUserPlaneInformation().GetDefaultUserPlanePathByDNN(upfSelectionParams)
+		defaultUPPath, err := selectDefaultUPPath(smContext, smf_context.GetUserPlaneInformation(),
+			sessionAnchor, upfSelectionParams)
+		if err != nil {
+			smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, %v", err)
+			txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("InsufficientResourceSliceDnn")
+			return establishmentError(smContext, smferrors.ErrCodeUPFNotFound, "InsufficientResourceSliceDnn", err)
+		}
 		defaultPath = smf_context.GenerateDataPath(defaultUPPath, smContext)
 		if defaultPath != nil {
 			defaultPath.IsDefaultPath = true
 			smContext.Tunnel.AddDataPath(defaultPath)
-			if err := defaultPath.ActivateTunnelAndPDR(smContext, 255); err != nil {
+			if err := retrySessionSetup(smContext, "data path activation", func(attempt int) error {
+				if attempt > 1 {
+					return smContext.ReselectDefaultDataPath()
+				}
+				return defaultPath.ActivateTunnelAndPDR(smContext, 255)
+			}); err != nil {
 				smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, data path error: %v", err.Error())
-				txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("UPFDataPathError")
-				return fmt.Errorf("DataPathError")
+				if smf_context.IsRetriable(err) {
+					txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("UPFUnavailable")
+				} else {
+					txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("InsufficientResourceSliceDnn")
+				}
+				return establishmentError(smContext, smferrors.ErrCodeDataPathFailure, "DataPathError", err)
 			}
+			defaultPath = smContext.Tunnel.DataPathPool.GetDefaultPath()
 		}
 	}
 
+	observeSessionSetupPhase(smContext, metrics.SessionSetupUpfSelection, time.Since(upfSelectionStart))
+
 	if defaultPath == nil {
 		smContext.ChangeState(smf_context.SmStateInit)
 		smContext.SubCtxLog.Debugln("PDUSessionSMContextCreate, SMContextState Change State:", smContext.SMContextState.String())
 		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, data path not found for selection param %v", upfSelectionParams.String())
 
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("InsufficientResourceSliceDnn")
-		return fmt.Errorf("InsufficientResourceSliceDnn")
+		return establishmentError(smContext, smferrors.ErrCodeUPFNotFound, "InsufficientResourceSliceDnn", nil)
 	}
 
 	// AMF Selection for SMF -> AMF communication
 	if problemDetails, err := consumer.SendNFDiscoveryServingAMF(smContext); err != nil {
 		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, send NF Discovery Serving AMF Error[%v]", err)
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("AMFDiscoveryFailure")
-		return fmt.Errorf("AmfError")
+		return establishmentError(smContext, smferrors.ErrCodeAMFDiscoveryFailure, "AmfError", err)
 	} else if problemDetails != nil {
 		smContext.SubPduSessLog.Warnf("PDUSessionSMContextCreate, send NF Discovery Serving AMF Problem[%+v]", problemDetails)
 		txn.Rsp = smContext.GeneratePDUSessionEstablishmentReject("AMFDiscoveryFailure")
-		return fmt.Errorf("AmfError")
+		return establishmentError(smContext, smferrors.ErrCodeAMFDiscoveryFailure, "AmfError", nil)
 	} else {
 		smContext.SubPduSessLog.Debugln("PDUSessionSMContextCreate, Send NF Discovery Serving AMF success")
 	}
 
-	for _, service := range *smContext.AMFProfile.NfServices {
-		if service.ServiceName == models.ServiceName_NAMF_COMM {
-			communicationConf := Namf_Communication.NewConfiguration()
-			communicationConf.SetBasePath(service.ApiPrefix)
-			smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
-		}
-	}
+	setCommunicationClient(smContext)
 
 	response.JsonData = smContext.BuildCreatedData()
 	txn.Rsp = &httpwrapper.Response{
@@ -301,11 +402,127 @@ func HandlePDUSessionSMContextCreate(eventData interface{}) error {
 	}
 
 	smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, PDU session context create success ")
+	smContext.AuditSession(smf_context.SessionAuditCreate, txn.SourceIP)
 
 	return nil
 	// TODO: UECM registration
 }
 
+// establishmentError is the failure of the establishment of the session, with the code of its
+// cause when the cause has one
+func establishmentError(smContext *smf_context.SMContext, code smferrors.SMFErrorCode, message string, cause error) error {
+	if causeCode := smferrors.CodeOf(cause); causeCode != "" {
+		code = causeCode
+	}
+	return smferrors.Wrap(code, cause, message).ForSession(smContext.Ref)
+}
+
+// selectDefaultUPPath returns the default user plane path of the session: the path to its
+// previous anchor if still usable, else the path to the first available UPF of the selection
+// chain of the DNN if one is configured, failing once every UPF of the chain is exhausted, else
+// the default path of the selection.
+func selectDefaultUPPath(smContext *smf_context.SMContext, upi *smf_context.UserPlaneInformation,
+	sessionAnchor *smf_context.SessionAnchor, selection *smf_context.UPFSelectionParams,
+) (smf_context.UPPath, error) {
+	if path := upi.AnchorUserPlanePath(sessionAnchor, selection); path != nil {
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, session re-anchored on UPF[%s]",
+			sessionAnchor.UPF.ResolveNodeIdToIp())
+		return path, nil
+	}
+	if chain := smf_context.UPFSelectionChain(selection); len(chain) > 0 {
+		anchor, err := upi.SelectAnchorUPF(selection, chain)
+		if err != nil {
+			return nil, err
+		}
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, UPF[%s] of the selection chain selected",
+			anchor.NodeID.ResolveNodeIdToIp())
+	}
+	return upi.GetDefaultUserPlanePathByDNN(selection), nil
+}
+
+// assignPDUAddress allocates the UE IP of the session, the one of the anchor if still free. The
+// sessions of an Ethernet DNN have no UE IP.
+func assignPDUAddress(smContext *smf_context.SMContext, anchor *smf_context.SessionAnchor) error {
+	if smContext.DNNInfo.Ethernet {
+		smContext.PDUAddress = &smf_context.UeIpAddr{}
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, Ethernet DNN[%s], no IP allocated", smContext.Dnn)
+		return nil
+	}
+	ip, err := smContext.AllocateUeIP(anchor)
+	if err != nil {
+		return err
+	}
+	smContext.PDUAddress = &smf_context.UeIpAddr{Ip: ip, UpfProvided: false}
+	smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, IP alloc success IP[%s]",
+		smContext.PDUAddress.Ip.String())
+	return nil
+}
+
+// fallbackSmPolicyDecision returns the policy decision built from the default QoS configured
+// for the DNN of the session, or nil if the DNN has none
+func fallbackSmPolicyDecision(smContext *smf_context.SMContext) *models.SmPolicyDecision {
+	smPolicyDecision := smContext.DNNInfo.BuildDefaultSmPolicyDecision()
+	if smPolicyDecision == nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, no policy from PCF and no default QoS configured for DNN[%s]", smContext.Dnn)
+		return nil
+	}
+	// subscribed session AMBR preferred over the DNN default one
+	for _, rule := range smPolicyDecision.SessRules {
+		rule.AuthSessAmbr = nil
+	}
+	if err := smContext.ApplySessionAmbrFallback(smPolicyDecision); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextCreate, no policy from PCF: %v", err)
+		return nil
+	}
+	smContext.SubPduSessLog.Warnf("PDUSessionSMContextCreate, no policy from PCF, FALLBACK to default QoS configured for DNN[%s]: 5QI[%d], ARP[%d]",
+		smContext.Dnn, smContext.DNNInfo.DefaultQos.Var5qi, smContext.DNNInfo.DefaultQos.ArpPriorityLevel)
+	// the PCF holds no SM policy association of the session, none to update or delete
+	smContext.SMPolicyClient = nil
+	return smPolicyDecision
+}
+
+// deleteSMPolicyAssociation deletes the SM policy association of the released session at the
+// PCF, the sessions on the DNN default QoS having none
+func deleteSMPolicyAssociation(smContext *smf_context.SMContext, request *models.ReleaseSmContextRequest, procedure string) {
+	if smContext.SMPolicyClient == nil {
+		smContext.SubCtxLog.Infof("%s, no SM policy association to delete", procedure)
+		return
+	}
+	metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationDelete), "Out", "", "")
+	if httpStatus, err := consumer.SendSMPolicyAssociationDelete(smContext, request); err != nil {
+		metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationDelete), "In", http.StatusText(httpStatus), err.Error())
+		smContext.SubCtxLog.Errorf("%s, SM policy delete error [%v]", procedure, err)
+	} else {
+		metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationDelete), "In", http.StatusText(httpStatus), "")
+		smContext.SubCtxLog.Infof("%s, SM policy delete success with http status [%v]", procedure, httpStatus)
+	}
+}
+
+// selectATSSS steers the traffic of an MA PDU session with the ATSSS of its DNN. The user plane
+// of the SMF being over the 3GPP access only, a session requested over the non-3GPP access or on
+// a DNN without ATSSS is established as a single access PDU session. TS 23.501 5.32.2
+func selectATSSS(smContext *smf_context.SMContext, maRequestInd bool) {
+	switch {
+	case !maRequestInd:
+	case smContext.DNNInfo.ATSSS == nil:
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, no ATSSS for DNN[%s], MA PDU session established single access",
+			smContext.Dnn)
+	case smContext.AnType != models.AccessType__3_GPP_ACCESS:
+		smContext.SubPduSessLog.Infof("PDUSessionSMContextCreate, MA PDU session over %s established single access",
+			smContext.AnType)
+	default:
+		smContext.ATSSSConfig = smContext.DNNInfo.ATSSS
+	}
+}
+
+// observeSessionSetupPhase records the duration of a phase of the setup of the session
+func observeSessionSetupPhase(smContext *smf_context.SMContext, phase string, duration time.Duration) {
+	if smContext.Snssai == nil {
+		return
+	}
+	metrics.ObserveSessionSetupPhaseDuration(smContext.Snssai.Sst, smContext.Snssai.Sd, smContext.Dnn, phase, duration)
+}
+
 func HandlePDUSessionSMContextUpdate(eventData interface{}) error {
 	txn := eventData.(*transaction.Transaction)
 	smContext := txn.Ctxt.(*smf_context.SMContext)
@@ -318,11 +535,6 @@ func HandlePDUSessionSMContextUpdate(eventData interface{}) error {
 	var response models.UpdateSmContextResponse
 	response.JsonData = new(models.SmContextUpdatedData)
 
-	// N1 Msg Handling
-	if err := HandleUpdateN1Msg(txn, &response, pfcpAction); err != nil {
-		return err
-	}
-
 	pfcpParam := &pfcpParam{
 		pdrList: []*smf_context.PDR{},
 		farList: []*smf_context.FAR{},
@@ -330,6 +542,11 @@ func HandlePDUSessionSMContextUpdate(eventData interface{}) error {
 		qerList: []*smf_context.QER{},
 	}
 
+	// N1 Msg Handling
+	if err := HandleUpdateN1Msg(txn, &response, pfcpAction, pfcpParam); err != nil {
+		return err
+	}
+
 	// UP Cnx State handling
 	if err := HandleUpCnxState(txn, &response, pfcpAction, pfcpParam); err != nil {
 		return err
@@ -350,6 +567,9 @@ func HandlePDUSessionSMContextUpdate(eventData interface{}) error {
 		return err
 	}
 
+	// RAT type change handling
+	HandleUpdateRatType(txn)
+
 	var httpResponse *httpwrapper.Response
 	// Check FSM and take corresponding action
 	switch smContext.SMContextState {
@@ -436,6 +656,9 @@ func HandlePDUSessionSMContextUpdate(eventData interface{}) error {
 	}
 
 	txn.Rsp = httpResponse
+	if httpResponse.Status < http.StatusMultipleChoices {
+		smContext.AuditSession(smf_context.SessionAuditModify, txn.SourceIP)
+	}
 	return nil
 }
 
@@ -507,14 +730,7 @@ func HandlePDUSessionSMContextRelease(eventData interface{}) error {
 	smContext.SubPduSessLog.Infof("PDUSessionSMContextRelease, PDU Session SMContext Release received")
 
 	// Send Policy delete
-	metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationDelete), "Out", "", "")
-	if httpStatus, err := consumer.SendSMPolicyAssociationDelete(smContext, &body); err != nil {
-		metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationDelete), "In", http.StatusText(httpStatus), err.Error())
-		smContext.SubCtxLog.Errorf("PDUSessionSMContextRelease, SM policy delete error [%v] ", err.Error())
-	} else {
-		metrics.IncrementSvcPcfMsgStats(smf_context.SMF_Self().NfInstanceID, string(svcmsgtypes.SmPolicyAssociationDelete), "In", http.StatusText(httpStatus), "")
-		smContext.SubCtxLog.Infof("PDUSessionSMContextRelease, SM policy delete success with http status [%v] ", httpStatus)
-	}
+	deleteSMPolicyAssociation(smContext, &body, "PDUSessionSMContextRelease")
 
 	// Release UE IP-Address
 	err := smContext.ReleaseUeIpAddr()
@@ -530,18 +746,23 @@ func HandlePDUSessionSMContextRelease(eventData interface{}) error {
 
 	// Release User-plane
 	if ok := releaseTunnel(smContext); !ok {
-		// already released
+		// already released, charging is closed unless the release of the tunnel did
+		closeCharging(smContext)
 		httpResponse = &httpwrapper.Response{
 			Status: http.StatusNoContent,
 			Body:   nil,
 		}
 
 		txn.Rsp = httpResponse
+		smContext.AuditSession(smf_context.SessionAuditRelease, txn.SourceIP)
 		smf_context.RemoveSMContext(smContext.Ref)
 		return nil
 	}
 
 	PFCPResponseStatus := <-smContext.SBIPFCPCommunicationChan
+	// the PFCP sessions were deleted whatever the UPF answered, the tunnel is not released
+	// again, charging is closed with the usage reported
+	closeCharging(smContext)
 
 	switch PFCPResponseStatus {
 	case smf_context.SessionReleaseSuccess:
@@ -615,6 +836,7 @@ func HandlePDUSessionSMContextRelease(eventData interface{}) error {
 	}
 
 	txn.Rsp = httpResponse
+	smContext.AuditSession(smf_context.SessionAuditRelease, txn.SourceIP)
 	smf_context.RemoveSMContext(smContext.Ref)
 
 	return nil
@@ -636,19 +858,51 @@ func releaseTunnel(smContext *smf_context.SMContext) bool {
 				continue
 			}
 			if _, exist := deletedPFCPNode[curUPFID]; !exist {
+				// pending before the send, whose failure may be reported at once
+				smContext.PendingUPF[curDataPathNode.GetNodeIP()] = true
 				err := pfcp_message.SendPfcpSessionDeletionRequest(curDataPathNode.UPF.NodeID, smContext, curDataPathNode.UPF.Port)
 				if err != nil {
 					smContext.SubPduSessLog.Errorf("releaseTunnel, send PFCP session deletion request failed: %v", err)
+					delete(smContext.PendingUPF, curDataPathNode.GetNodeIP())
 				}
 				deletedPFCPNode[curUPFID] = true
-				smContext.PendingUPF[curDataPathNode.GetNodeIP()] = true
 			}
 		}
 	}
 	smContext.Tunnel = nil
+	if smContext.PendingUPF.IsEmpty() {
+		// no deletion sent, no response to wait for
+		smContext.PostPFCPResponseStatus(smf_context.SessionReleaseSuccess)
+	}
 	return true
 }
 
+// openCharging opens the charging of the established session at the CHF. The session is not
+// rejected when the CHF fails, its usage being still logged and written in the CDR files.
+func openCharging(smContext *smf_context.SMContext) {
+	if err := consumer.SendChargingDataCreate(smContext); err != nil {
+		smContext.SubPduSessLog.Errorf("openCharging, charging data create failed: %v", err)
+	}
+}
+
+// closeCharging forwards the final usage of the released session to charging. A UPF which
+// did not answer the PFCP Session Deletion, or rejected it, leaves no final usage, charging is
+// closed anyway.
+func closeCharging(smContext *smf_context.SMContext) {
+	if !smContext.CloseCharging() {
+		return
+	}
+	finalUsage := smContext.FinalUsage()
+	if len(finalUsage) == 0 {
+		smContext.SubPduSessLog.Warnln("closeCharging, no final usage reported by UPF")
+	}
+	if err := consumer.SendChargingDataRelease(smContext, finalUsage); err != nil {
+		smContext.SubPduSessLog.Errorf("closeCharging, charging data release failed: %v", err)
+		return
+	}
+	smContext.SubPduSessLog.Infof("closeCharging, charging closed with %d usage reports", len(finalUsage))
+}
+
 func SendPduSessN1N2Transfer(smContext *smf_context.SMContext, success bool) error {
 	// N1N2 Request towards AMF
 	n1n2Request := models.N1N2MessageTransferRequest{}
@@ -678,7 +932,10 @@ func SendPduSessN1N2Transfer(smContext *smf_context.SMContext, success bool) err
 	n1n2Request.JsonData = &models.N1N2MessageTransferReqData{PduSessionId: smContext.PDUSessionID}
 
 	if success {
-		if smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentAccept(smContext); err != nil {
+		nasStart := time.Now()
+		smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentAccept(smContext)
+		observeSessionSetupPhase(smContext, metrics.SessionSetupNasEncode, time.Since(nasStart))
+		if err != nil {
 			logger.PduSessLog.Errorf("build GSM PDUSessionEstablishmentAccept failed: %s", err)
 		} else {
 			n1n2Request.BinaryDataN1Message = smNasBuf
@@ -702,10 +959,11 @@ func SendPduSessN1N2Transfer(smContext *smf_context.SMContext, success bool) err
 	}
 
 	smContext.SubPduSessLog.Infof("N1N2 transfer initiated")
-	rspData, _, err := smContext.
-		CommunicationClient.
-		N1N2MessageCollectionDocumentApi.
-		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	amfStart := time.Now()
+	rspData, err := smContext.N1N2MessageTransfer(context.Background(), n1n2Request)
+	if success {
+		observeSessionSetupPhase(smContext, metrics.SessionSetupAmfNotify, time.Since(amfStart))
+	}
 	if err != nil {
 		smContext.SubPfcpLog.Warnf("send N1N2Transfer failed, %v ", err.Error())
 		err = smContext.CommitSmPolicyDecision(false)
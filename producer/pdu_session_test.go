@@ -0,0 +1,334 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/omec-project/openapi/Npcf_SMPolicyControl"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+	"github.com/omec-project/smf/qos"
+	"github.com/omec-project/smf/smferrors"
+	"github.com/omec-project/smf/transaction"
+	"github.com/omec-project/util/httpwrapper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func newFallbackTestSMContext(defaultQos *factory.DnnDefaultQos) *smfContext.SMContext {
+	return &smfContext.SMContext{
+		Dnn:           "internet",
+		SubPduSessLog: logger.PduSessLog,
+		DNNInfo:       &smfContext.SnssaiSmfDnnInfo{DefaultQos: defaultQos},
+	}
+}
+
+func TestFallbackSmPolicyDecisionWithoutPCF(t *testing.T) {
+	smContext := newFallbackTestSMContext(&factory.DnnDefaultQos{
+		Var5qi:              7,
+		ArpPriorityLevel:    4,
+		SessionAmbrUplink:   "100 Mbps",
+		SessionAmbrDownlink: "200 Mbps",
+	})
+
+	// PCF not selected, policy association can not be set up
+	_, _, err := consumer.SendSMPolicyAssociationCreate(smContext)
+	require.Error(t, err)
+
+	smPolicyDecision := fallbackSmPolicyDecision(smContext)
+	require.NotNil(t, smPolicyDecision)
+
+	policyUpdates := qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, smPolicyDecision)
+	sessRule := policyUpdates.SessRuleUpdate.ActiveSessRule
+	require.NotNil(t, sessRule)
+	assert.Equal(t, int32(7), sessRule.AuthDefQos.Var5qi)
+	assert.Equal(t, int32(4), sessRule.AuthDefQos.Arp.PriorityLevel)
+	assert.Equal(t, "100 Mbps", sessRule.AuthSessAmbr.Uplink)
+	assert.Equal(t, "200 Mbps", sessRule.AuthSessAmbr.Downlink)
+
+	defQosData := qos.GetDefaultQoSDataFromPolicyDecision(smPolicyDecision)
+	assert.Equal(t, int32(7), defQosData.Var5qi)
+
+	for _, pccRule := range policyUpdates.PccRuleUpdate.GetAddPccRuleUpdate() {
+		assert.NotNil(t, qos.GetQoSDataFromPolicyDecision(smPolicyDecision, pccRule.RefQosData[0]))
+		assert.NotNil(t, qos.GetTcDataFromPolicyDecision(smPolicyDecision, pccRule.RefTcData[0]))
+	}
+	assert.Len(t, policyUpdates.PccRuleUpdate.GetAddPccRuleUpdate(), 1)
+}
+
+func TestFallbackSmPolicyDecisionSubscribedSessionAmbr(t *testing.T) {
+	smContext := newFallbackTestSMContext(&factory.DnnDefaultQos{
+		Var5qi:              7,
+		ArpPriorityLevel:    4,
+		SessionAmbrUplink:   "100 Mbps",
+		SessionAmbrDownlink: "200 Mbps",
+	})
+	smContext.DnnConfiguration.SessionAmbr = &models.Ambr{Uplink: "30 Mbps", Downlink: "60 Mbps"}
+
+	smPolicyDecision := fallbackSmPolicyDecision(smContext)
+	require.NotNil(t, smPolicyDecision)
+
+	sessRule := qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, smPolicyDecision).SessRuleUpdate.ActiveSessRule
+	require.NotNil(t, sessRule)
+	assert.Equal(t, "30 Mbps", sessRule.AuthSessAmbr.Uplink)
+	assert.Equal(t, "60 Mbps", sessRule.AuthSessAmbr.Downlink)
+	assert.Equal(t, int32(7), sessRule.AuthDefQos.Var5qi)
+}
+
+func TestFallbackSmPolicyDecisionNotConfigured(t *testing.T) {
+	smContext := newFallbackTestSMContext(nil)
+
+	assert.Nil(t, fallbackSmPolicyDecision(smContext))
+}
+
+func TestSelectATSSS(t *testing.T) {
+	atsss := &smfContext.ATSSSConfig{Mode: smfContext.ATSSSModeLoadBalancing, TGPPAccessWeight: 70}
+	for _, tc := range []struct {
+		name         string
+		maRequestInd bool
+		anType       models.AccessType
+		atsss        *smfContext.ATSSSConfig
+		steered      bool
+	}{
+		{"MA PDU session", true, models.AccessType__3_GPP_ACCESS, atsss, true},
+		{"single access PDU session", false, models.AccessType__3_GPP_ACCESS, atsss, false},
+		{"DNN without ATSSS", true, models.AccessType__3_GPP_ACCESS, nil, false},
+		{"over non-3GPP access", true, models.AccessType_NON_3_GPP_ACCESS, atsss, false},
+	} {
+		smContext := newFallbackTestSMContext(nil)
+		smContext.AnType = tc.anType
+		smContext.DNNInfo.ATSSS = tc.atsss
+		selectATSSS(smContext, tc.maRequestInd)
+		if tc.steered {
+			require.Same(t, atsss, smContext.ATSSSConfig, tc.name)
+		} else {
+			require.Nil(t, smContext.ATSSSConfig, tc.name)
+		}
+	}
+}
+
+func TestAssignPDUAddress(t *testing.T) {
+	allocator, err := smfContext.NewIPAllocator("10.62.0.0/30")
+	require.NoError(t, err)
+
+	// IP DNN, UE IP allocated
+	smContext := newFallbackTestSMContext(nil)
+	smContext.DNNInfo.UeIPAllocator = allocator
+	require.NoError(t, assignPDUAddress(smContext, nil))
+	require.NotNil(t, smContext.PDUAddress.Ip)
+
+	// Ethernet DNN, no UE IP
+	smContext = newFallbackTestSMContext(nil)
+	smContext.DNNInfo.Ethernet = true
+	require.NoError(t, assignPDUAddress(smContext, nil))
+	require.Nil(t, smContext.PDUAddress.Ip)
+}
+
+func TestSelectDefaultUPPathSelectionChain(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	smfSelf := smfContext.SMF_Self()
+	contexttest.SetSnssaiInfos(t, []smfContext.SnssaiSmfInfo{
+		{
+			Snssai: smfContext.SNssai{Sst: 1, Sd: "010203"},
+			DnnInfos: map[string]*smfContext.SnssaiSmfDnnInfo{
+				"internet": {UPFSelectionChain: []string{"upf2"}},
+			},
+		},
+	})
+	upi := smfSelf.UserPlaneInformation
+	selection := &smfContext.UPFSelectionParams{Dnn: "internet", SNssai: &smfContext.SNssai{Sst: 1, Sd: "010203"}}
+
+	path, err := selectDefaultUPPath(smContext, upi, nil, selection)
+	require.NoError(t, err)
+	require.NotEmpty(t, path)
+	require.Same(t, upi.UPFs["upf2"], path[len(path)-1], "UPF of the selection chain")
+
+	// upf1 is available but not in the chain
+	upi.UPFs["upf2"].UPF.UPFStatus = smfContext.NotAssociated
+	path, err = selectDefaultUPPath(smContext, upi, nil, selection)
+	require.Equal(t, smferrors.ErrCodeUPFNotFound, smferrors.CodeOf(err), "every UPF of the chain exhausted")
+	require.Nil(t, path)
+}
+
+func TestSendSMPolicyAssociationDeleteWithoutPCF(t *testing.T) {
+	smContext := newFallbackTestSMContext(nil)
+	smContext.ServingNetwork = &models.PlmnId{Mcc: "208", Mnc: "93"}
+
+	_, err := consumer.SendSMPolicyAssociationDelete(smContext, &models.ReleaseSmContextRequest{
+		JsonData: &models.SmContextReleaseData{},
+	})
+	require.Error(t, err)
+}
+
+func TestFallbackSmPolicyDecisionSkipsPolicyDelete(t *testing.T) {
+	var deletes int
+	pcf := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/delete") {
+			deletes++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), &http2.Server{}))
+	t.Cleanup(pcf.Close)
+	pcfConfig := Npcf_SMPolicyControl.NewConfiguration()
+	pcfConfig.SetBasePath(pcf.URL)
+	release := &models.ReleaseSmContextRequest{JsonData: &models.SmContextReleaseData{}}
+
+	// the PCF selected but rejecting the policy association, the DNN default QoS applies
+	smContext := newFallbackTestSMContext(contexttest.DefaultQos())
+	smContext.SubCtxLog = logger.CtxLog
+	smContext.ServingNetwork = &models.PlmnId{Mcc: "208", Mnc: "93"}
+	smContext.SMPolicyClient = Npcf_SMPolicyControl.NewAPIClient(pcfConfig)
+	require.NotNil(t, fallbackSmPolicyDecision(smContext))
+	require.Nil(t, smContext.SMPolicyClient, "no SM policy association at the PCF")
+	deleteSMPolicyAssociation(smContext, release, "PDUSessionSMContextRelease")
+	require.Zero(t, deletes)
+
+	// the association of a session on the policy of the PCF is deleted
+	smContext.SMPolicyClient = Npcf_SMPolicyControl.NewAPIClient(pcfConfig)
+	smContext.Supi = "imsi-208930000000001"
+	deleteSMPolicyAssociation(smContext, release, "PDUSessionSMContextRelease")
+	require.Equal(t, 1, deletes)
+}
+
+func TestCloseChargingForwardsFinalUsage(t *testing.T) {
+	origSendChargingDataRelease := consumer.SendChargingDataRelease
+	defer func() { consumer.SendChargingDataRelease = origSendChargingDataRelease }()
+
+	var released []smfContext.UsageReport
+	calls := 0
+	consumer.SendChargingDataRelease = func(smContext *smfContext.SMContext, finalUsage []smfContext.UsageReport) error {
+		calls++
+		released = finalUsage
+		return nil
+	}
+
+	usage := smfContext.UsageReport{UpfIP: "2.2.2.2", URRID: 5, TotalVolume: 3000, UplinkVolume: 1000, DownlinkVolume: 2000}
+	smContext := &smfContext.SMContext{SubPduSessLog: logger.PduSessLog}
+	smContext.AddFinalUsage([]smfContext.UsageReport{usage})
+
+	closeCharging(smContext)
+	require.Equal(t, 1, calls)
+	assert.Equal(t, []smfContext.UsageReport{usage}, released)
+
+	// UPF gone, no deletion response: charging is closed without final usage
+	closeCharging(&smfContext.SMContext{SubPduSessLog: logger.PduSessLog})
+	require.Equal(t, 2, calls)
+	assert.Empty(t, released)
+}
+
+func TestSendPfcpSessionReleaseReqClosesCharging(t *testing.T) {
+	origSendChargingDataRelease := consumer.SendChargingDataRelease
+	defer func() { consumer.SendChargingDataRelease = origSendChargingDataRelease }()
+
+	calls := 0
+	consumer.SendChargingDataRelease = func(smContext *smfContext.SMContext, finalUsage []smfContext.UsageReport) error {
+		calls++
+		return nil
+	}
+
+	for _, status := range []smfContext.PFCPSessionResponseStatus{
+		smfContext.SessionReleaseSuccess,
+		smfContext.SessionReleaseTimeout,
+		smfContext.SessionReleaseFailed,
+	} {
+		smContext := &smfContext.SMContext{
+			Tunnel:                   smfContext.NewUPTunnel(),
+			SBIPFCPCommunicationChan: make(chan smfContext.PFCPSessionResponseStatus, 1),
+			SubPduSessLog:            logger.PduSessLog,
+			SubPfcpLog:               logger.PfcpLog,
+			SubCtxLog:                logger.CtxLog,
+		}
+		// outcome of the PFCP Session Deletion
+		smContext.PostPFCPResponseStatus(status)
+
+		err := SendPfcpSessionReleaseReq(smContext)
+		if status == smfContext.SessionReleaseSuccess {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
+	}
+	require.Equal(t, 3, calls)
+}
+
+func TestReleaseSMContextAlreadyReleasedClosesCharging(t *testing.T) {
+	origSendChargingDataRelease := consumer.SendChargingDataRelease
+	defer func() { consumer.SendChargingDataRelease = origSendChargingDataRelease }()
+
+	calls := 0
+	consumer.SendChargingDataRelease = func(smContext *smfContext.SMContext, finalUsage []smfContext.UsageReport) error {
+		calls++
+		return nil
+	}
+
+	// the user plane of the session is gone, no PFCP session to delete
+	smContext := adoptTestSMContext(t)
+	smContext.Tunnel = nil
+	txn := transaction.NewTransaction(models.ReleaseSmContextRequest{
+		JsonData: &models.SmContextReleaseData{},
+	}, nil, svcmsgtypes.ReleaseSmContext)
+	txn.Ctxt = smContext
+	require.NoError(t, HandlePDUSessionSMContextRelease(txn))
+	require.Equal(t, http.StatusNoContent, txn.Rsp.(*httpwrapper.Response).Status)
+	require.Equal(t, 1, calls)
+
+	// charging is closed once
+	closeCharging(smContext)
+	require.Equal(t, 1, calls)
+}
+
+func TestHandleUpdateRatTypeSendsChargingUpdate(t *testing.T) {
+	origSendChargingDataUpdate := consumer.SendChargingDataUpdate
+	defer func() { consumer.SendChargingDataUpdate = origSendChargingDataUpdate }()
+
+	var updates []*smfContext.ChargingUpdate
+	consumer.SendChargingDataUpdate = func(smContext *smfContext.SMContext, update *smfContext.ChargingUpdate) error {
+		updates = append(updates, update)
+		return nil
+	}
+
+	smContext := &smfContext.SMContext{Ref: "urn:uuid:rat-change", RatType: models.RatType_NR, SubPduSessLog: logger.PduSessLog}
+	recorder := smfContext.GetPacketRateRecorder()
+	t.Cleanup(func() { recorder.Remove(smContext.Ref) })
+	updateRatType := func(ratType models.RatType) {
+		HandleUpdateRatType(&transaction.Transaction{
+			Req:  models.UpdateSmContextRequest{JsonData: &models.SmContextUpdateData{RatType: ratType}},
+			Ctxt: smContext,
+		})
+	}
+
+	// RAT type not reported or unchanged
+	updateRatType("")
+	updateRatType(models.RatType_NR)
+	require.Empty(t, updates)
+
+	recorder.Record(smContext.Ref, []smfContext.UsageReport{{URRID: 1, UplinkVolume: 100, DownlinkVolume: 300, TotalVolume: 400, Duration: 60}})
+	updateRatType(models.RatType_EUTRA)
+	require.Len(t, updates, 1)
+	assert.Equal(t, smfContext.ChargingTriggerRATChange, updates[0].Trigger)
+	assert.Equal(t, models.RatType_NR, updates[0].PreviousRATType)
+	assert.Equal(t, models.RatType_EUTRA, updates[0].RATType)
+	assert.Equal(t, uint64(400), updates[0].Usage.TotalVolume)
+	assert.Equal(t, uint32(60), updates[0].Usage.Duration)
+	assert.Equal(t, models.RatType_EUTRA, smContext.RatType)
+
+	// the next update holds the usage measured on the new RAT only
+	recorder.Record(smContext.Ref, []smfContext.UsageReport{{URRID: 1, UplinkVolume: 10, DownlinkVolume: 20, TotalVolume: 30, Duration: 60}})
+	updateRatType(models.RatType_NR)
+	require.Len(t, updates, 2)
+	assert.Equal(t, models.RatType_EUTRA, updates[1].PreviousRATType)
+	assert.Equal(t, uint64(30), updates[1].Usage.TotalVolume)
+	assert.Equal(t, uint64(10), updates[1].Usage.UplinkVolume)
+	assert.Equal(t, 1, updates[1].Usage.Reports)
+}
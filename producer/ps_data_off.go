@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	"github.com/omec-project/smf/context"
+)
+
+// HandlePsDataOffModification handles the PDU Session Modification Request of the UE signalling its
+// 3GPP PS data off status, TS 23.501 5.24. While PS data off is activated the flows of the PCC
+// rules of the services not exempt are blocked by the AN UPF, they are forwarded again once it is
+// deactivated. The PCF is notified of the change if it requested it. A request without the PS
+// data off status, the only modification requested by the UE supported, is rejected.
+func HandlePsDataOffModification(smContext *context.SMContext, req *nasMessage.PDUSessionModificationRequest,
+	response *models.UpdateSmContextResponse, pfcpAction *pfcpAction, pfcpParam *pfcpParam,
+) {
+	smContext.Pti = req.GetPTI()
+	activated, present, err := context.PsDataOffStatus(req)
+	if err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, parsing PCO of PDU Session Modification Request failed: %v", err)
+		rejectPduSessionModification(smContext, nasMessage.Cause5GSMProtocolErrorUnspecified, response)
+		return
+	}
+	if !present {
+		smContext.SubPduSessLog.Warnln("PDUSessionSMContextUpdate, PDU Session Modification Request without PS data off status not supported")
+		rejectPduSessionModification(smContext, nasMessage.Cause5GSMRequestRejectedUnspecified, response)
+		return
+	}
+	changed := activated != smContext.PsDataOff
+	smContext.SubPduSessLog.Infof("PDUSessionSMContextUpdate, 3GPP PS data off activated: %t", activated)
+
+	if qerList := smContext.SetPsDataOff(activated); len(qerList) != 0 {
+		pfcpParam.qerList = append(pfcpParam.qerList, qerList...)
+		pfcpAction.sendPfcpModify = true
+		smContext.ChangeState(context.SmStatePfcpModify)
+	} else {
+		smContext.ChangeState(context.SmStateModify)
+	}
+	smContext.SubCtxLog.Debugln("PDUSessionSMContextUpdate, SMContextState Change State:", smContext.SMContextState.String())
+
+	if buf, err := context.BuildGSMPDUSessionModificationCommand(smContext); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, build GSM PDUSessionModificationCommand failed: %+v", err)
+	} else {
+		response.BinaryDataN1SmMessage = buf
+		response.JsonData.N1SmMsg = &models.RefToBinaryData{ContentId: "PDUSessionModificationCommand"}
+	}
+
+	if changed && smContext.SMPolicyClient != nil && smContext.PsDataOffReportRequested() {
+		updateData := &models.SmPolicyUpdateContextData{
+			RepPolicyCtrlReqTriggers: []models.PolicyControlRequestTrigger{models.PolicyControlRequestTrigger_PS_DA_OFF},
+			Var3gppPsDataOffStatus:   activated,
+		}
+		if _, err := consumer.SendSMPolicyAssociationUpdate(smContext, updateData); err != nil {
+			smContext.SubPduSessLog.Errorf("report PS data off status to PCF failed: %v", err)
+		}
+	}
+}
+
+// rejectPduSessionModification answers the PDU Session Modification Request of the UE with a PDU
+// Session Modification Reject, the session being left unchanged
+func rejectPduSessionModification(smContext *context.SMContext, cause uint8, response *models.UpdateSmContextResponse) {
+	smContext.ChangeState(context.SmStateModify)
+	if buf, err := context.BuildGSMPDUSessionModificationReject(smContext, cause); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, build GSM PDUSessionModificationReject failed: %+v", err)
+	} else {
+		response.BinaryDataN1SmMessage = buf
+		response.JsonData.N1SmMsg = &models.RefToBinaryData{ContentId: "PDUSessionModificationReject"}
+	}
+}
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"testing"
+
+	"github.com/omec-project/nas"
+	"github.com/omec-project/nas/nasConvert"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/nas/nasType"
+	"github.com/omec-project/openapi/Npcf_SMPolicyControl"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+	"github.com/omec-project/smf/qos"
+	"github.com/omec-project/smf/transaction"
+	"github.com/stretchr/testify/require"
+)
+
+// psDataOffModificationRequest encodes the PDU Session Modification Request of the UE signalling
+// its 3GPP PS data off status
+func psDataOffModificationRequest(t *testing.T, activated bool) []byte {
+	t.Helper()
+	pco := nasConvert.NewProtocolConfigurationOptions()
+	status := uint8(0)
+	if activated {
+		status = 1
+	}
+	pco.ProtocolOrContainerList = append(pco.ProtocolOrContainerList, &nasConvert.ProtocolOrContainerUnit{
+		ProtocolOrContainerID: nasMessage.UEStatus3GPPPSDataOffUL,
+		LengthOfContents:      1,
+		Contents:              []byte{status},
+	})
+	return pduSessionModificationRequest(t, pco.Marshal())
+}
+
+// pduSessionModificationRequest encodes a PDU Session Modification Request of the UE, with the
+// extended protocol configuration options only if contents is not nil
+func pduSessionModificationRequest(t *testing.T, contents []byte) []byte {
+	t.Helper()
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionModificationRequest)
+	m.GsmHeader.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	req := nasMessage.NewPDUSessionModificationRequest(0x0)
+	req.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	req.SetPDUSessionID(10)
+	req.SetPTI(3)
+	req.SetMessageType(nas.MsgTypePDUSessionModificationRequest)
+	if contents != nil {
+		req.ExtendedProtocolConfigurationOptions = nasType.NewExtendedProtocolConfigurationOptions(
+			nasMessage.PDUSessionModificationRequestExtendedProtocolConfigurationOptionsType)
+		req.ExtendedProtocolConfigurationOptions.SetLen(uint16(len(contents)))
+		req.SetExtendedProtocolConfigurationOptionsContents(contents)
+	}
+	m.PDUSessionModificationRequest = req
+	buf, err := m.PlainNasEncode()
+	require.NoError(t, err)
+	return buf
+}
+
+func updatePsDataOff(t *testing.T, smContext *smfContext.SMContext, activated bool) (
+	*models.UpdateSmContextResponse, *pfcpAction, *pfcpParam,
+) {
+	t.Helper()
+	return updatePduSessionModification(t, smContext, psDataOffModificationRequest(t, activated))
+}
+
+func updatePduSessionModification(t *testing.T, smContext *smfContext.SMContext, n1SmMsg []byte) (
+	*models.UpdateSmContextResponse, *pfcpAction, *pfcpParam,
+) {
+	t.Helper()
+	txn := transaction.NewTransaction(models.UpdateSmContextRequest{
+		JsonData:              &models.SmContextUpdateData{N1SmMsg: &models.RefToBinaryData{ContentId: "N1SmMsg"}},
+		BinaryDataN1SmMessage: n1SmMsg,
+	}, nil, svcmsgtypes.UpdateSmContext)
+	txn.Ctxt = smContext
+
+	response := &models.UpdateSmContextResponse{JsonData: new(models.SmContextUpdatedData)}
+	action := &pfcpAction{}
+	param := &pfcpParam{}
+	require.NoError(t, HandleUpdateN1Msg(txn, response, action, param))
+	return response, action, param
+}
+
+func TestHandlePsDataOffModification(t *testing.T) {
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+
+	smContext, node := newQosFlowTestSMContext(t, "imsi-208930000000031")
+	smContext.DNNInfo = &smfContext.SnssaiSmfDnnInfo{PsDataOffExemptApps: []string{"ims"}}
+	smContext.SmPolicyData.SmCtxtPccRules.PccRules["rule-1"].AppId = "ims"
+	for _, tunnel := range []*smfContext.GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+		for name, pdr := range tunnel.PDR {
+			if name != "default" {
+				pdr.QER[0].PccRuleID = name
+			}
+			for _, qer := range pdr.QER {
+				qer.GateStatus = &smfContext.GateStatus{ULGate: smfContext.GateOpen, DLGate: smfContext.GateOpen}
+			}
+		}
+	}
+	// PCF requesting the PS data off status
+	smContext.SMPolicyClient = Npcf_SMPolicyControl.NewAPIClient(Npcf_SMPolicyControl.NewConfiguration())
+	smContext.SmPolicyUpdates = []*qos.PolicyUpdate{{SmPolicyDecision: &models.SmPolicyDecision{
+		PolicyCtrlReqTriggers: []models.PolicyControlRequestTrigger{models.PolicyControlRequestTrigger_PS_DA_OFF},
+	}}}
+	var reported []models.SmPolicyUpdateContextData
+	origSendSMPolicyAssociationUpdate := consumer.SendSMPolicyAssociationUpdate
+	t.Cleanup(func() { consumer.SendSMPolicyAssociationUpdate = origSendSMPolicyAssociationUpdate })
+	consumer.SendSMPolicyAssociationUpdate = func(smContext *smfContext.SMContext,
+		updateData *models.SmPolicyUpdateContextData,
+	) (*models.SmPolicyDecision, error) {
+		reported = append(reported, *updateData)
+		return nil, nil
+	}
+
+	// activated, the flows of rule-2 blocked by the AN UPF and those of the exempt rule-1 kept
+	response, action, param := updatePsDataOff(t, smContext, true)
+	require.True(t, smContext.PsDataOff)
+	require.Equal(t, uint8(3), smContext.Pti)
+	require.True(t, action.sendPfcpModify)
+	require.Equal(t, smfContext.SmStatePfcpModify, smContext.SMContextState)
+	require.Equal(t, "PDUSessionModificationCommand", response.JsonData.N1SmMsg.ContentId)
+	require.NotEmpty(t, response.BinaryDataN1SmMessage)
+	require.Len(t, param.qerList, 2)
+	for _, qer := range param.qerList {
+		require.Equal(t, "rule-2", qer.PccRuleID)
+		require.Equal(t, smfContext.GateStatus{ULGate: smfContext.GateClose, DLGate: smfContext.GateClose}, *qer.GateStatus)
+		require.Equal(t, smfContext.RULE_UPDATE, qer.State)
+	}
+	require.Equal(t, smfContext.GateOpen, node.UpLinkTunnel.PDR["rule-1"].QER[0].GateStatus.ULGate)
+	require.Equal(t, smfContext.GateOpen, node.UpLinkTunnel.PDR["default"].QER[0].GateStatus.ULGate)
+	require.Equal(t, []models.SmPolicyUpdateContextData{{
+		RepPolicyCtrlReqTriggers: []models.PolicyControlRequestTrigger{models.PolicyControlRequestTrigger_PS_DA_OFF},
+		Var3gppPsDataOffStatus:   true,
+	}}, reported)
+
+	// deactivated, the flows of rule-2 forwarded again
+	_, action, param = updatePsDataOff(t, smContext, false)
+	require.False(t, smContext.PsDataOff)
+	require.True(t, action.sendPfcpModify)
+	require.Len(t, param.qerList, 2)
+	for _, qer := range param.qerList {
+		require.Equal(t, smfContext.GateStatus{ULGate: smfContext.GateOpen, DLGate: smfContext.GateOpen}, *qer.GateStatus)
+	}
+	require.Len(t, reported, 2)
+	require.False(t, reported[1].Var3gppPsDataOffStatus)
+
+	// unchanged, no PFCP modification nor report
+	_, action, param = updatePsDataOff(t, smContext, false)
+	require.False(t, action.sendPfcpModify)
+	require.Empty(t, param.qerList)
+	require.Equal(t, smfContext.SmStateModify, smContext.SMContextState)
+	require.Len(t, reported, 2)
+
+	// without the PS data off status, rejected and the session left unchanged
+	response, action, param = updatePduSessionModification(t, smContext, pduSessionModificationRequest(t, nil))
+	require.False(t, smContext.PsDataOff)
+	require.False(t, action.sendPfcpModify)
+	require.Empty(t, param.qerList)
+	require.Equal(t, "PDUSessionModificationReject", response.JsonData.N1SmMsg.ContentId)
+	m := nas.NewMessage()
+	require.NoError(t, m.GsmMessageDecode(&response.BinaryDataN1SmMessage))
+	require.Equal(t, nasMessage.Cause5GSMRequestRejectedUnspecified, m.PDUSessionModificationReject.GetCauseValue())
+	require.Equal(t, uint8(3), m.PDUSessionModificationReject.GetPTI())
+	require.Len(t, reported, 2)
+}
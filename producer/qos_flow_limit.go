@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/qos"
+)
+
+// limitQoSFlows keeps the PCC rules of the session once the policy decision is applied within
+// the maximum QoS flows of the DNN, and returns the IDs of the rules of the decision rejected
+func limitQoSFlows(smContext *smf_context.SMContext, smPolicyDecision *models.SmPolicyDecision) []string {
+	if smContext.DNNInfo == nil {
+		return nil
+	}
+	rejected := qos.LimitPccRules(smContext.SmPolicyData.SmCtxtPccRules.PccRules, smPolicyDecision,
+		smContext.DNNInfo.MaxQoSFlows)
+	if len(rejected) > 0 {
+		smContext.SubQosLog.Warnf("PCC rules %v rejected, more than %d QoS flows", rejected, smContext.DNNInfo.MaxQoSFlows)
+	}
+	return rejected
+}
+
+// rejectedPccRulesReport reports the PCC rules not installed, beyond the maximum QoS flows
+func rejectedPccRulesReport(rejected []string) models.RuleReport {
+	return models.RuleReport{
+		PccRuleIds:  rejected,
+		RuleStatus:  models.RuleStatus_INACTIVE,
+		FailureCode: models.FailureCode_MAX_NR_QO_S_FLOW,
+	}
+}
+
+// limitEstablishmentQoSFlows limits the QoS flows of the policy decision of the session
+// establishment and reports the rejected PCC rules to the PCF
+func limitEstablishmentQoSFlows(smContext *smf_context.SMContext, smPolicyDecision *models.SmPolicyDecision) {
+	rejected := limitQoSFlows(smContext, smPolicyDecision)
+	// policy set up with the DNN default QoS, no PCF to report to
+	if len(rejected) == 0 || smContext.SMPolicyClient == nil {
+		return
+	}
+	updateData := &models.SmPolicyUpdateContextData{
+		RuleReports: []models.RuleReport{rejectedPccRulesReport(rejected)},
+	}
+	if _, err := consumer.SendSMPolicyAssociationUpdate(smContext, updateData); err != nil {
+		smContext.SubQosLog.Errorf("report rejected PCC rules %v to PCF failed: %v", rejected, err)
+	}
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/omec-project/openapi/Npcf_SMPolicyControl"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitQoSFlows(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	smContext.DNNInfo.MaxQoSFlows = 5
+	smContext.SMPolicyClient = &Npcf_SMPolicyControl.APIClient{}
+
+	origSendSMPolicyAssociationUpdate := consumer.SendSMPolicyAssociationUpdate
+	t.Cleanup(func() { consumer.SendSMPolicyAssociationUpdate = origSendSMPolicyAssociationUpdate })
+	var reports []models.RuleReport
+	consumer.SendSMPolicyAssociationUpdate = func(_ *smfContext.SMContext,
+		updateData *models.SmPolicyUpdateContextData,
+	) (*models.SmPolicyDecision, error) {
+		reports = append(reports, updateData.RuleReports...)
+		return nil, nil
+	}
+
+	// the PCF returns 10 PCC rules of their own QoS flow, of precedences 10 to 19 in no
+	// particular order
+	decision := smContext.DNNInfo.BuildDefaultSmPolicyDecision()
+	decision.PccRules = make(map[string]*models.PccRule)
+	for i, precedence := range []int32{17, 12, 19, 10, 15, 11, 18, 14, 16, 13} {
+		id := fmt.Sprintf("rule-%d", i)
+		qosID := fmt.Sprintf("qos-%d", i)
+		decision.QosDecs[qosID] = &models.QosData{QosId: qosID, Var5qi: 9}
+		decision.PccRules[id] = &models.PccRule{
+			PccRuleId:  id,
+			Precedence: precedence,
+			RefQosData: []string{qosID},
+			RefTcData:  []string{"DefaultTcData"},
+			FlowInfos: []models.FlowInformation{{
+				FlowDescription: fmt.Sprintf("permit out ip from 10.10.%d.0/24 to assigned", i),
+				PackFiltId:      fmt.Sprint(i + 1),
+				FlowDirection:   models.FlowDirectionRm_BIDIRECTIONAL,
+			}},
+		}
+	}
+
+	limitEstablishmentQoSFlows(smContext, decision)
+
+	// the 5 rules of the highest priority, lowest precedence, are kept
+	kept := []string{"rule-1", "rule-3", "rule-5", "rule-7", "rule-9"}
+	require.ElementsMatch(t, kept, slices.Collect(maps.Keys(decision.PccRules)))
+	require.Len(t, reports, 1)
+	require.Equal(t, []string{"rule-4", "rule-8", "rule-0", "rule-6", "rule-2"}, reports[0].PccRuleIds)
+	require.Equal(t, models.RuleStatus_INACTIVE, reports[0].RuleStatus)
+	require.Equal(t, models.FailureCode_MAX_NR_QO_S_FLOW, reports[0].FailureCode)
+
+	// only the kept rules are established on the UPF, with the default PDR
+	smContext.SmPolicyUpdates = []*qos.PolicyUpdate{qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, decision)}
+	require.NoError(t, smContext.ReselectDefaultDataPath())
+	node := smContext.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode
+	for _, tunnel := range []*smfContext.GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+		require.ElementsMatch(t, append(kept, "default"), slices.Collect(maps.Keys(tunnel.PDR)))
+	}
+
+	// not limited without maximum
+	smContext.DNNInfo.MaxQoSFlows = 0
+	reports = nil
+	limitEstablishmentQoSFlows(smContext, decision)
+	require.Len(t, decision.PccRules, 5)
+	require.Empty(t, reports)
+}
+
+func TestLimitQoSFlowsSharedFlows(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	smContext.DNNInfo.MaxQoSFlows = 2
+
+	// 6 PCC rules over 3 QoS flows, the rules of the flow of the lowest priority are rejected
+	decision := smContext.DNNInfo.BuildDefaultSmPolicyDecision()
+	decision.PccRules = make(map[string]*models.PccRule)
+	for i := range 6 {
+		id := fmt.Sprintf("rule-%d", i)
+		qosID := fmt.Sprintf("qos-%d", i/2)
+		decision.QosDecs[qosID] = &models.QosData{QosId: qosID, Var5qi: 9}
+		decision.PccRules[id] = &models.PccRule{PccRuleId: id, Precedence: int32(10 + i), RefQosData: []string{qosID}}
+	}
+	require.ElementsMatch(t, []string{"rule-4", "rule-5"}, limitQoSFlows(smContext, decision))
+	require.Len(t, decision.PccRules, 4)
+}
+
+func TestLimitQoSFlowsPolicyUpdate(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	smContext.DNNInfo.MaxQoSFlows = 2
+
+	// the installed rules take the 2 QoS flows of the session
+	decision := smContext.DNNInfo.BuildDefaultSmPolicyDecision()
+	decision.PccRules = make(map[string]*models.PccRule)
+	for i := range 2 {
+		id := fmt.Sprintf("rule-%d", i)
+		qosID := fmt.Sprintf("qos-%d", i)
+		decision.QosDecs[qosID] = &models.QosData{QosId: qosID, Var5qi: 9}
+		decision.PccRules[id] = &models.PccRule{PccRuleId: id, Precedence: int32(10 + i), RefQosData: []string{qosID}}
+	}
+	require.Empty(t, limitQoSFlows(smContext, decision))
+	require.NoError(t, qos.CommitSmPolicyDecision(&smContext.SmPolicyData,
+		qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, decision)))
+
+	// the PCF adds a rule to an installed flow and a rule needing a third flow, even of a
+	// higher priority than the installed ones
+	update := &models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"rule-2": {PccRuleId: "rule-2", Precedence: 20, RefQosData: []string{"qos-0"}},
+			"rule-3": {PccRuleId: "rule-3", Precedence: 5, RefQosData: []string{"qos-2"}},
+		},
+		QosDecs: map[string]*models.QosData{"qos-2": {QosId: "qos-2", Var5qi: 9}},
+	}
+	require.Equal(t, []string{"rule-3"}, limitQoSFlows(smContext, update))
+	require.ElementsMatch(t, []string{"rule-2"}, slices.Collect(maps.Keys(update.PccRules)))
+
+	// once an installed rule is removed, its flow is free for the new rule
+	update = &models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"rule-1": nil,
+			"rule-3": {PccRuleId: "rule-3", Precedence: 5, RefQosData: []string{"qos-2"}},
+		},
+		QosDecs: map[string]*models.QosData{"qos-2": {QosId: "qos-2", Var5qi: 9}},
+	}
+	require.Empty(t, limitQoSFlows(smContext, update))
+}
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smf_context "github.com/omec-project/smf/context"
+)
+
+// ModifyPFCPSession sends the PFCP Session Modification of the rules to the AN UPF and waits for the response
+var ModifyPFCPSession = func(smContext *smf_context.SMContext, pfcpParam *pfcpParam) error {
+	return SendPfcpSessionModifyReq(smContext, pfcpParam)
+}
+
+// HandleANQoSReleaseNotification removes the QoS flows released by the AN, reported in a
+// PDU Session Resource Notify, from the PFCP session and reports the released PCC rules to the PCF
+func HandleANQoSReleaseNotification(smCtxRef string, releasedFlows []smf_context.QoSFlow) error {
+	smContext := smf_context.GetSMContext(smCtxRef)
+	if smContext == nil {
+		return fmt.Errorf("SM context [%s] not found", smCtxRef)
+	}
+	if len(releasedFlows) == 0 {
+		return nil
+	}
+
+	qfis := make(map[uint8]bool)
+	for _, flow := range releasedFlows {
+		qfis[flow.QFI] = true
+	}
+	smContext.SubPduSessLog.Infof("QoS flows %v released by the AN", releasedFlows)
+
+	rules := smContext.GetQosFlowRules(qfis)
+	if len(rules.PDRs) == 0 {
+		smContext.SubPduSessLog.Warnf("no rules installed for the released QoS flows")
+	} else {
+		for _, pdr := range rules.PDRs {
+			pdr.State = smf_context.RULE_REMOVE
+		}
+		for _, far := range rules.FARs {
+			far.State = smf_context.RULE_REMOVE
+		}
+		for _, qer := range rules.QERs {
+			qer.State = smf_context.RULE_REMOVE
+		}
+
+		smContext.PendingUPF = make(smf_context.PendingUPF)
+		for _, dataPath := range smContext.Tunnel.DataPathPool {
+			if dataPath.Activated {
+				smContext.PendingUPF[dataPath.FirstDPNode.GetNodeIP()] = true
+			}
+		}
+
+		if err := ModifyPFCPSession(smContext, &pfcpParam{
+			pdrList: rules.PDRs,
+			farList: rules.FARs,
+			qerList: rules.QERs,
+		}); err != nil {
+			return fmt.Errorf("remove released QoS flows failed: %v", err)
+		}
+		if err := smContext.RemoveQosFlowRules(rules); err != nil {
+			smContext.SubPduSessLog.Errorf("release QoS flow rules failed: %v", err)
+		}
+	}
+
+	if len(rules.PccRuleIds) == 0 {
+		return nil
+	}
+
+	// the PCC rules of the released flows are no longer enforced
+	ruleReport := models.RuleReport{
+		PccRuleIds:  rules.PccRuleIds,
+		RuleStatus:  models.RuleStatus_INACTIVE,
+		FailureCode: models.FailureCode_RES_ALLO_FAIL,
+	}
+	for _, flow := range releasedFlows {
+		if flow.Cause != nil {
+			ruleReport.RanNasRelCauses = append(ruleReport.RanNasRelCauses, models.RanNasRelCause{NgApCause: flow.Cause})
+		}
+	}
+	updateData := &models.SmPolicyUpdateContextData{
+		RepPolicyCtrlReqTriggers: []models.PolicyControlRequestTrigger{models.PolicyControlRequestTrigger_RES_RELEASE},
+		RuleReports:              []models.RuleReport{ruleReport},
+	}
+	if _, err := consumer.SendSMPolicyAssociationUpdate(smContext, updateData); err != nil {
+		return fmt.Errorf("report released PCC rules %v to PCF failed: %v", rules.PccRuleIds, err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	pfcp_message "github.com/omec-project/smf/pfcp/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newQosFlowTestSMContext returns a session with the default QoS flow (QFI 9) and
+// one QoS flow per PCC rule, with QFIs 1 and 2, installed on the AN UPF
+func newQosFlowTestSMContext(t *testing.T, supi string) (*smfContext.SMContext, *smfContext.DataPathNode) {
+	t.Helper()
+	upf := contexttest.NewUPF(t, "10.0.0.2")
+
+	smContext := smfContext.NewSMContext(supi, 10)
+	node := smfContext.NewDataPathNode()
+	node.UPF = upf
+	dataPath := smfContext.NewDataPath()
+	dataPath.Activated = true
+	dataPath.IsDefaultPath = true
+	dataPath.FirstDPNode = node
+	smContext.Tunnel = &smfContext.UPTunnel{DataPathPool: smfContext.DataPathPool{1: dataPath}}
+
+	addPDR := func(tunnel *smfContext.GTPTunnel, name string, qers ...*smfContext.QER) {
+		pdr, err := upf.AddPDR()
+		require.NoError(t, err)
+		pdr.QER = qers
+		tunnel.PDR[name] = pdr
+	}
+	addQER := func(qfi uint8) *smfContext.QER {
+		qer, err := upf.AddQER()
+		require.NoError(t, err)
+		qer.QFI.QFI = qfi
+		return qer
+	}
+
+	smContext.SmPolicyData.SmCtxtQosData.QosData = make(map[string]*models.QosData)
+	for _, tunnel := range []*smfContext.GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+		defQER := addQER(9)
+		addPDR(tunnel, "default", defQER)
+		for _, qfi := range []uint8{1, 2} {
+			id := strconv.Itoa(int(qfi))
+			addPDR(tunnel, "rule-"+id, addQER(qfi), defQER)
+			smContext.SmPolicyData.SmCtxtPccRules.PccRules["rule-"+id] = &models.PccRule{
+				PccRuleId:  "rule-" + id,
+				RefQosData: []string{id},
+			}
+			smContext.SmPolicyData.SmCtxtQosData.QosData[id] = &models.QosData{QosId: id}
+		}
+	}
+	return smContext, node
+}
+
+func TestHandleANQoSReleaseNotification(t *testing.T) {
+	origModifyPFCPSession := ModifyPFCPSession
+	origSendSMPolicyAssociationUpdate := consumer.SendSMPolicyAssociationUpdate
+	defer func() {
+		ModifyPFCPSession = origModifyPFCPSession
+		consumer.SendSMPolicyAssociationUpdate = origSendSMPolicyAssociationUpdate
+	}()
+
+	testCases := []struct {
+		name             string
+		supi             string
+		releasedQFIs     []uint8
+		expectedPccRules []string
+		remainingPDRs    []string
+	}{
+		{
+			name:             "single flow",
+			supi:             "imsi-208930000000011",
+			releasedQFIs:     []uint8{1},
+			expectedPccRules: []string{"rule-1"},
+			remainingPDRs:    []string{"default", "rule-2"},
+		},
+		{
+			name:             "multiple flows",
+			supi:             "imsi-208930000000012",
+			releasedQFIs:     []uint8{1, 2},
+			expectedPccRules: []string{"rule-1", "rule-2"},
+			remainingPDRs:    []string{"default"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext, node := newQosFlowTestSMContext(t, tc.supi)
+
+			var modification *pfcpParam
+			ModifyPFCPSession = func(smContext *smfContext.SMContext, param *pfcpParam) error {
+				modification = param
+				return nil
+			}
+			var policyUpdate *models.SmPolicyUpdateContextData
+			consumer.SendSMPolicyAssociationUpdate = func(smContext *smfContext.SMContext,
+				updateData *models.SmPolicyUpdateContextData,
+			) (*models.SmPolicyDecision, error) {
+				policyUpdate = updateData
+				return &models.SmPolicyDecision{}, nil
+			}
+
+			releasedFlows := make([]smfContext.QoSFlow, 0)
+			for _, qfi := range tc.releasedQFIs {
+				releasedFlows = append(releasedFlows, smfContext.QoSFlow{
+					QFI:   qfi,
+					Cause: &models.NgApCause{Group: 0, Value: 21},
+				})
+			}
+			require.NoError(t, HandleANQoSReleaseNotification(smContext.Ref, releasedFlows))
+
+			// one UL and one DL PDR per released flow
+			require.NotNil(t, modification)
+			require.Len(t, modification.pdrList, 2*len(tc.releasedQFIs))
+			require.Len(t, modification.farList, 2*len(tc.releasedQFIs))
+			require.Len(t, modification.qerList, 2*len(tc.releasedQFIs))
+			for _, qer := range modification.qerList {
+				assert.Contains(t, tc.releasedQFIs, qer.QFI.QFI)
+			}
+
+			req, err := pfcp_message.BuildPfcpSessionModificationRequest(1, 1, 2, net.ParseIP("10.0.0.1"),
+				modification.pdrList, modification.farList, modification.qerList)
+			require.NoError(t, err)
+			assert.Len(t, req.RemovePDR, 2*len(tc.releasedQFIs))
+			assert.Len(t, req.RemoveFAR, 2*len(tc.releasedQFIs))
+			assert.Len(t, req.RemoveQER, 2*len(tc.releasedQFIs))
+			assert.Empty(t, req.CreatePDR)
+
+			for _, tunnel := range []*smfContext.GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+				remaining := make([]string, 0)
+				for name := range tunnel.PDR {
+					remaining = append(remaining, name)
+				}
+				assert.ElementsMatch(t, tc.remainingPDRs, remaining)
+			}
+
+			require.NotNil(t, policyUpdate)
+			assert.Equal(t, []models.PolicyControlRequestTrigger{models.PolicyControlRequestTrigger_RES_RELEASE},
+				policyUpdate.RepPolicyCtrlReqTriggers)
+			require.Len(t, policyUpdate.RuleReports, 1)
+			assert.ElementsMatch(t, tc.expectedPccRules, policyUpdate.RuleReports[0].PccRuleIds)
+			assert.Equal(t, models.RuleStatus_INACTIVE, policyUpdate.RuleReports[0].RuleStatus)
+			assert.Len(t, policyUpdate.RuleReports[0].RanNasRelCauses, len(tc.releasedQFIs))
+			for _, id := range tc.expectedPccRules {
+				assert.NotContains(t, smContext.SmPolicyData.SmCtxtPccRules.PccRules, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+	"strings"
+
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/metrics"
+)
+
+// HandleQoSMonitoringReports forwards the packet delays of the QoS flows reported by the anchor
+// UPF. The session keeps the last report of each QoS flow, returned by the OAM session info,
+// and the delays are exported as metrics. The PCF is not notified, the Npcf_SMPolicyControl
+// models in use have no QoS monitoring report.
+func HandleQoSMonitoringReports(smContext *smf_context.SMContext, reports []smf_context.QoSMonitoringReport) {
+	smContext.AddQoSMonitoringReports(reports)
+	for _, report := range reports {
+		if report.MeasurementFailure {
+			smContext.SubPfcpLog.Warnf("UPF[%s] failed to measure the packet delay of QoS flow [%d]", report.UpfIP, report.QFI)
+		}
+		delays := make([]string, 0, 3)
+		for _, packetDelay := range []struct {
+			direction string
+			delay     *uint32
+		}{
+			{factory.QoSMonitoringUplink, report.UplinkDelay},
+			{factory.QoSMonitoringDownlink, report.DownlinkDelay},
+			{factory.QoSMonitoringRoundTrip, report.RoundTripDelay},
+		} {
+			if packetDelay.delay == nil {
+				continue
+			}
+			delays = append(delays, fmt.Sprintf("%s %dms", packetDelay.direction, *packetDelay.delay))
+			metrics.SetQoSMonitoringPacketDelay(smContext.Supi, smContext.PDUSessionID, report.QFI,
+				packetDelay.direction, *packetDelay.delay)
+		}
+		smContext.SubPfcpLog.Infof("QoS monitoring of QoS flow [%d] from UPF[%s]: %s", report.QFI, report.UpfIP,
+			strings.Join(delays, ", "))
+	}
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+)
+
+// HandleRadioBearerFailure handles the failure of the radio bearer of the session reported by the
+// AN, per the session continuity mode of its DNN. With BufferAndWait the session is kept with its
+// user plane deactivated, the UPF buffering the downlink data and notifying its arrival to page
+// the UE, which then sets up the radio bearer again. With ImmediateRelease the session is released.
+func HandleRadioBearerFailure(smContext *context.SMContext, cause *ngapType.Cause,
+	response *models.UpdateSmContextResponse, pfcpAction *pfcpAction, pfcpParam *pfcpParam,
+) {
+	mode := factory.SessionContinuityBufferAndWait
+	if smContext.DNNInfo != nil && smContext.DNNInfo.SessionContinuityMode != "" {
+		mode = smContext.DNNInfo.SessionContinuityMode
+	}
+	smContext.SubPduSessLog.Infof("radio bearer setup failed with cause group %d, session continuity mode %s",
+		cause.Present, mode)
+
+	if mode == factory.SessionContinuityImmediateRelease {
+		releaseAfterRadioBearerFailure(smContext, response, pfcpAction)
+		return
+	}
+
+	if smContext.Tunnel == nil {
+		smContext.SubPduSessLog.Errorln("radio bearer failure, no user plane to buffer the downlink data")
+		return
+	}
+	bufferDownlinkData(smContext, pfcpParam)
+	smContext.ReleaseANTunnel()
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	response.JsonData.UpCnxState = models.UpCnxState_DEACTIVATED
+	pfcpAction.sendPfcpModify = true
+	smContext.ChangeState(context.SmStatePfcpModify)
+	smContext.SubCtxLog.Debugln("PDUSessionSMContextUpdate, SMContextState Change State:", smContext.SMContextState.String())
+}
+
+// releaseAfterRadioBearerFailure releases the session as requested by the network, the UE and the
+// AN being sent the PDU Session Release Command and the UPFs the PFCP Session Deletion
+func releaseAfterRadioBearerFailure(smContext *context.SMContext, response *models.UpdateSmContextResponse,
+	pfcpAction *pfcpAction,
+) {
+	// network requested, no procedure transaction
+	smContext.Pti = 0
+	if buf, err := context.BuildGSMPDUSessionReleaseCommand(smContext); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, build GSM PDUSessionReleaseCommand failed: %+v", err)
+	} else {
+		response.BinaryDataN1SmMessage = buf
+	}
+	response.JsonData.N1SmMsg = &models.RefToBinaryData{ContentId: "PDUSessionReleaseCommand"}
+
+	response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDUResourceReleaseCommand"}
+	response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_REL_CMD
+	if buf, err := context.BuildPDUSessionResourceReleaseCommandTransfer(smContext); err != nil {
+		smContext.SubPduSessLog.Errorf("PDUSessionSMContextUpdate, build PDUSessionResourceReleaseCommandTransfer failed: %+v", err)
+	} else {
+		response.BinaryDataN2SmInformation = buf
+	}
+
+	if smContext.Tunnel != nil {
+		smContext.ChangeState(context.SmStatePfcpModify)
+		pfcpAction.sendPfcpDelete = true
+	} else {
+		smContext.ChangeState(context.SmStateModify)
+	}
+	smContext.SubCtxLog.Debugln("PDUSessionSMContextUpdate, SMContextState Change State:", smContext.SMContextState.String())
+}
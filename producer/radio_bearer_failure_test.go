@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"net"
+	"testing"
+
+	"github.com/omec-project/aper"
+	"github.com/omec-project/nas"
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+	pfcp_message "github.com/omec-project/smf/pfcp/message"
+	"github.com/omec-project/smf/transaction"
+	"github.com/stretchr/testify/require"
+)
+
+// updateAfterRadioBearerFailure runs the N2 handling of the Update SM Context Request of the AMF
+// reporting the failure of the radio bearer of the session
+func updateAfterRadioBearerFailure(t *testing.T, smContext *smfContext.SMContext) (
+	*models.UpdateSmContextResponse, *pfcpAction, *pfcpParam,
+) {
+	t.Helper()
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+
+	n2Info, err := aper.MarshalWithParams(ngapType.PDUSessionResourceSetupUnsuccessfulTransfer{
+		Cause: ngapType.Cause{
+			Present: ngapType.CausePresentRadioNetwork,
+			RadioNetwork: &ngapType.CauseRadioNetwork{
+				Value: ngapType.CauseRadioNetworkPresentRadioResourcesNotAvailable,
+			},
+		},
+	}, "valueExt")
+	require.NoError(t, err)
+
+	txn := transaction.NewTransaction(models.UpdateSmContextRequest{
+		JsonData: &models.SmContextUpdateData{
+			N2SmInfo:     &models.RefToBinaryData{ContentId: "N2SmInfo"},
+			N2SmInfoType: models.N2SmInfoType_PDU_RES_SETUP_FAIL,
+		},
+		BinaryDataN2SmInformation: n2Info,
+	}, nil, svcmsgtypes.UpdateSmContext)
+	txn.Ctxt = smContext
+
+	response := &models.UpdateSmContextResponse{JsonData: new(models.SmContextUpdatedData)}
+	action := &pfcpAction{}
+	param := &pfcpParam{}
+	require.NoError(t, HandleUpdateN2Msg(txn, response, action, param))
+	return response, action, param
+}
+
+func TestRadioBearerFailureBufferAndWait(t *testing.T) {
+	smContext, node := newQosFlowTestSMContext(t, "imsi-208930000000021")
+	smContext.DNNInfo = &smfContext.SnssaiSmfDnnInfo{SessionContinuityMode: factory.SessionContinuityBufferAndWait}
+	smContext.PDUAddress = &smfContext.UeIpAddr{Ip: net.ParseIP("10.60.0.21")}
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	smContext.UpCnxState = models.UpCnxState_ACTIVATED
+	smContext.SMContextState = smfContext.SmStateActive
+
+	response, action, param := updateAfterRadioBearerFailure(t, smContext)
+
+	// the session is kept with its user plane deactivated
+	require.True(t, action.sendPfcpModify)
+	require.False(t, action.sendPfcpDelete)
+	require.Equal(t, smfContext.SmStatePfcpModify, smContext.SMContextState)
+	require.Equal(t, models.UpCnxState_DEACTIVATED, smContext.UpCnxState)
+	require.Equal(t, models.UpCnxState_DEACTIVATED, response.JsonData.UpCnxState)
+	require.Nil(t, response.JsonData.N1SmMsg)
+
+	// the UPF buffers the downlink data and notifies its arrival
+	require.Len(t, param.farList, len(node.DownLinkTunnel.PDR))
+	for _, far := range param.farList {
+		require.Equal(t, smfContext.ApplyAction{Buff: true, Nocp: true}, far.ApplyAction)
+		require.NotNil(t, far.BAR)
+	}
+	req, err := pfcp_message.BuildPfcpSessionModificationRequest(1, 1, 2, net.ParseIP("10.0.0.1"),
+		param.pdrList, param.farList, param.qerList)
+	require.NoError(t, err)
+	require.Len(t, req.UpdateFAR, len(node.DownLinkTunnel.PDR))
+	require.NotNil(t, req.CreateBAR)
+}
+
+func TestRadioBearerFailureImmediateRelease(t *testing.T) {
+	smContext, _ := newQosFlowTestSMContext(t, "imsi-208930000000022")
+	smContext.DNNInfo = &smfContext.SnssaiSmfDnnInfo{SessionContinuityMode: factory.SessionContinuityImmediateRelease}
+	smContext.PDUAddress = &smfContext.UeIpAddr{Ip: net.ParseIP("10.60.0.22")}
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	smContext.UpCnxState = models.UpCnxState_ACTIVATED
+	smContext.SMContextState = smfContext.SmStateActive
+	smContext.Pti = 3
+
+	response, action, param := updateAfterRadioBearerFailure(t, smContext)
+
+	// the UPFs are sent the PFCP Session Deletion
+	require.True(t, action.sendPfcpDelete)
+	require.Empty(t, param.farList)
+	require.Equal(t, smfContext.SmStatePfcpModify, smContext.SMContextState)
+
+	// the UE and the AN are sent the network requested release
+	require.Equal(t, models.N2SmInfoType_PDU_RES_REL_CMD, response.JsonData.N2SmInfoType)
+	require.NotEmpty(t, response.BinaryDataN2SmInformation)
+	require.Equal(t, "PDUSessionReleaseCommand", response.JsonData.N1SmMsg.ContentId)
+	m := nas.NewMessage()
+	require.NoError(t, m.GsmMessageDecode(&response.BinaryDataN1SmMessage))
+	require.Equal(t, nas.MsgTypePDUSessionReleaseCommand, m.GsmHeader.GetMessageType())
+	require.Zero(t, m.PDUSessionReleaseCommand.GetPTI())
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+
+	smf_context "github.com/omec-project/smf/context"
+	pfcp_message "github.com/omec-project/smf/pfcp/message"
+	"github.com/omec-project/smf/qos"
+)
+
+// sendRedirectFARs sends the PFCP Session Modification of the redirected FARs to the UPF
+var sendRedirectFARs = func(upf *smf_context.UPF, smContext *smf_context.SMContext, farList []*smf_context.FAR) error {
+	return pfcp_message.SendPfcpSessionModificationRequest(upf.NodeID, smContext, nil, farList, nil, nil, upf.Port)
+}
+
+// UpdateRedirection enables or disables on the anchor UPFs the redirection of the traffic control
+// data modified by the PCF, and commits the traffic control data once the UPFs accepted it
+func UpdateRedirection(smContext *smf_context.SMContext, policyUpdate *qos.PolicyUpdate) error {
+	tcData := policyUpdate.TCUpdate.GetModTrafficControlUpdate()
+	if len(tcData) == 0 {
+		return nil
+	}
+	updatedFARs := smContext.UpdateRedirectInformation(tcData)
+	if len(updatedFARs) > 0 {
+		if err := modifyRedirectFARs(smContext, updatedFARs); err != nil {
+			return err
+		}
+	}
+	for id, tc := range tcData {
+		smContext.SmPolicyData.SmCtxtTCData.TrafficControlData[id] = tc
+	}
+	return nil
+}
+
+// modifyRedirectFARs sends the PFCP Session Modification of the FARs to their UPFs and waits for
+// the responses
+func modifyRedirectFARs(smContext *smf_context.SMContext, updatedFARs map[*smf_context.UPF][]*smf_context.FAR) error {
+	state := smContext.SMContextState
+	smContext.ChangeState(smf_context.SmStatePfcpModify)
+	defer smContext.ChangeState(state)
+
+	smContext.PendingUPF = make(smf_context.PendingUPF)
+	for upf := range updatedFARs {
+		smContext.PendingUPF[upf.NodeID.ResolveNodeIdToIp().String()] = true
+	}
+	sent := 0
+	for upf, farList := range updatedFARs {
+		if err := sendRedirectFARs(upf, smContext, farList); err != nil {
+			smContext.SubPfcpLog.Errorf("redirection of UPF[%s] not updated: %v", upf.NodeID.ResolveNodeIdToIp(), err)
+			delete(smContext.PendingUPF, upf.NodeID.ResolveNodeIdToIp().String())
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return fmt.Errorf("redirection not updated")
+	}
+
+	switch <-smContext.SBIPFCPCommunicationChan {
+	case smf_context.SessionUpdateSuccess:
+		smContext.SubPfcpLog.Infoln("redirection updated")
+		return nil
+	case smf_context.SessionUpdateTimeout:
+		return fmt.Errorf("redirection update timeout")
+	default:
+		return fmt.Errorf("redirection update rejected")
+	}
+}
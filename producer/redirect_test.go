@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestUpdateRedirection(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	enableKafka := false
+	factory.SmfConfig.Configuration.KafkaInfo.EnableKafka = &enableKafka
+	smContext.ChangeState(smfContext.SmStateActive)
+	require.NoError(t, smContext.CommitSmPolicyDecision(true))
+
+	origSendRedirectFARs := sendRedirectFARs
+	t.Cleanup(func() { sendRedirectFARs = origSendRedirectFARs })
+	var sent []*smfContext.FAR
+	sendRedirectFARs = func(upf *smfContext.UPF, ctx *smfContext.SMContext, farList []*smfContext.FAR) error {
+		require.Equal(t, smfContext.SmStatePfcpModify, ctx.SMContextState)
+		sent = farList
+		delete(ctx.PendingUPF, upf.NodeID.ResolveNodeIdToIp().String())
+		ctx.SBIPFCPCommunicationChan <- smfContext.SessionUpdateSuccess
+		return nil
+	}
+	update := func(redirectInfo *models.RedirectInformation) {
+		t.Helper()
+		decision := smContext.DNNInfo.BuildDefaultSmPolicyDecision()
+		decision.TraffContDecs["DefaultTcData"].RedirectInfo = redirectInfo
+		sent = nil
+		require.NoError(t, UpdateRedirection(smContext, qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, decision)))
+		require.Equal(t, smfContext.SmStateActive, smContext.SMContextState)
+		require.Equal(t, redirectInfo, smContext.SmPolicyData.SmCtxtTCData.TrafficControlData["DefaultTcData"].RedirectInfo)
+	}
+
+	// redirection enabled by the PCF
+	update(&models.RedirectInformation{
+		RedirectEnabled:       true,
+		RedirectAddressType:   models.URLRedirectAddressType,
+		RedirectServerAddress: "http://portal.example.com/topup",
+	})
+	require.Len(t, sent, 1)
+	require.Equal(t, smfContext.RULE_UPDATE, sent[0].State)
+	require.Equal(t, &smfContext.RedirectInformation{
+		RedirectAddressType:   ie.RedirectAddrURL,
+		RedirectServerAddress: "http://portal.example.com/topup",
+	}, sent[0].ForwardingParameters.RedirectInformation)
+
+	// redirection disabled by the PCF
+	update(nil)
+	require.Len(t, sent, 1)
+	require.Nil(t, sent[0].ForwardingParameters.RedirectInformation)
+
+	// unchanged traffic control data, the UPF is not modified
+	update(nil)
+	require.Empty(t, sent)
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/transaction"
+	"github.com/omec-project/util/httpwrapper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSMPolicyUpdateNotifyRuleLimit(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	enableKafka := false
+	factory.SmfConfig.Configuration.KafkaInfo.EnableKafka = &enableKafka
+	smContext.ChangeState(smfContext.SmStateActive)
+	require.NoError(t, smContext.CommitSmPolicyDecision(true))
+
+	smfSelf := smfContext.SMF_Self()
+	origMaxPDRs := smfSelf.MaxPDRsPerSession
+	t.Cleanup(func() { smfSelf.MaxPDRsPerSession = origMaxPDRs })
+	smfSelf.MaxPDRsPerSession = 4
+
+	origSendRedirectFARs := sendRedirectFARs
+	t.Cleanup(func() { sendRedirectFARs = origSendRedirectFARs })
+	sendRedirectFARs = func(upf *smfContext.UPF, ctx *smfContext.SMContext, farList []*smfContext.FAR) error {
+		t.Fatal("PFCP sent to the UPF for a rejected policy")
+		return nil
+	}
+
+	// two PCC rules added to the default one, 6 PDRs needed per UPF
+	decision := smContext.DNNInfo.BuildDefaultSmPolicyDecision()
+	for i := 1; i <= 2; i++ {
+		name := fmt.Sprintf("rule-%d", i)
+		decision.PccRules[name] = &models.PccRule{
+			PccRuleId:  name,
+			Precedence: int32(10 + i),
+			RefQosData: []string{"DefaultQosData"},
+			RefTcData:  []string{"DefaultTcData"},
+			FlowInfos: []models.FlowInformation{{
+				FlowDescription: fmt.Sprintf("permit out ip from 10.10.%d.0/24 to assigned", i),
+				FlowDirection:   models.FlowDirectionRm_BIDIRECTIONAL,
+			}},
+		}
+	}
+	policyUpdates := len(smContext.SmPolicyUpdates)
+	txn := &transaction.Transaction{
+		Req:  models.SmPolicyNotification{SmPolicyDecision: decision},
+		Ctxt: smContext,
+	}
+
+	err := HandleSMPolicyUpdateNotify(txn)
+	require.ErrorIs(t, err, smfContext.ErrSessionRuleLimit)
+	require.ErrorContains(t, err, "6 PDRs needed per UPF")
+	require.Equal(t, err, txn.Err)
+	require.Equal(t, http.StatusBadRequest, txn.Rsp.(*httpwrapper.Response).Status)
+	require.Len(t, smContext.SmPolicyUpdates, policyUpdates, "rejected policy not kept")
+	require.Len(t, smContext.SmPolicyData.SmCtxtPccRules.PccRules, 1)
+}
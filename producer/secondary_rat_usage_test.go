@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/omec-project/aper"
+	"github.com/omec-project/ngap/ngapType"
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+	"github.com/omec-project/smf/transaction"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleUpdateN2MsgSecondaryRATUsage(t *testing.T) {
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+	smContext, _ := newQosFlowTestSMContext(t, "imsi-208930000000131")
+
+	end := time.Date(2026, time.March, 14, 15, 9, 26, 0, time.UTC)
+	timestamp := make([]byte, 4)
+	binary.BigEndian.PutUint32(timestamp, uint32(end.Unix()+2208988800))
+	n2Info, err := aper.MarshalWithParams(smfContext.SecondaryRATDataUsageReportTransfer{
+		SecondaryRATUsageInformation: &smfContext.SecondaryRATUsageInformation{
+			PDUSessionUsageReport: &smfContext.PDUSessionUsageReport{
+				PDUSessionTimedReportList: ngapType.VolumeTimedReportList{List: []ngapType.VolumeTimedReportItem{{
+					StartTimeStamp: timestamp,
+					EndTimeStamp:   timestamp,
+					UsageCountUL:   400,
+					UsageCountDL:   3000,
+				}}},
+			},
+		},
+	}, "valueExt")
+	require.NoError(t, err)
+
+	txn := transaction.NewTransaction(models.UpdateSmContextRequest{
+		JsonData: &models.SmContextUpdateData{
+			N2SmInfo:     &models.RefToBinaryData{ContentId: "N2SmInfo"},
+			N2SmInfoType: smfContext.N2SmInfoTypeSecondaryRATUsage,
+		},
+		BinaryDataN2SmInformation: n2Info,
+	}, nil, svcmsgtypes.UpdateSmContext)
+	txn.Ctxt = smContext
+	response := &models.UpdateSmContextResponse{JsonData: new(models.SmContextUpdatedData)}
+	require.NoError(t, HandleUpdateN2Msg(txn, response, &pfcpAction{}, &pfcpParam{}))
+
+	// kept for the next Charging Data Request of the session
+	require.Equal(t, []smfContext.SecondaryRATUsage{
+		{RATType: models.RatType_NR, StartTime: end, EndTime: end, UplinkVolume: 400, DownlinkVolume: 3000},
+	}, smContext.SecondaryRATUsage())
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/logger"
+	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+	"github.com/omec-project/smf/transaction"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionAuditLogLifecycle(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	// the lines of a previous run are kept
+	require.NoError(t, os.WriteFile(auditFile, []byte("{\"eventType\":\"previous\"}\n"), 0o600))
+	require.NoError(t, logger.InitAuditLog(auditFile))
+	t.Cleanup(logger.CloseAuditLog)
+
+	txn := newSessionSetup(t, "imsi-208930000000141")
+	txn.SourceIP = "10.100.0.1"
+	smContext := txn.Ctxt.(*smfContext.SMContext)
+	require.NoError(t, HandlePDUSessionSMContextCreate(txn))
+
+	txn = transaction.NewTransaction(models.UpdateSmContextRequest{
+		JsonData: &models.SmContextUpdateData{},
+	}, nil, svcmsgtypes.UpdateSmContext)
+	txn.Ctxt = smContext
+	txn.SourceIP = "10.100.0.1"
+	require.NoError(t, HandlePDUSessionSMContextUpdate(txn))
+
+	txn = transaction.NewTransaction(models.ReleaseSmContextRequest{
+		JsonData: &models.SmContextReleaseData{},
+	}, nil, svcmsgtypes.ReleaseSmContext)
+	txn.Ctxt = smContext
+	txn.SourceIP = "10.100.0.2"
+	smContext.SBIPFCPCommunicationChan <- smfContext.SessionReleaseSuccess
+	require.NoError(t, HandlePDUSessionSMContextRelease(txn))
+
+	// the session events along with the UE IP ones, in the same audit log
+	type auditEvent struct {
+		Timestamp  string         `json:"timestamp"`
+		Category   string         `json:"category"`
+		EventType  string         `json:"eventType"`
+		SmCtxRef   string         `json:"smCtxRef"`
+		Supi       string         `json:"supi"`
+		Dnn        string         `json:"dnn"`
+		Snssai     *models.Snssai `json:"snssai"`
+		SourceIP   string         `json:"sourceIP"`
+		OperatorID string         `json:"operatorID"`
+		IP         string         `json:"ip"`
+	}
+	f, err := os.Open(auditFile)
+	require.NoError(t, err)
+	defer f.Close()
+	var events, ipEvents []auditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event auditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		if event.IP != "" {
+			ipEvents = append(ipEvents, event)
+		} else {
+			events = append(events, event)
+		}
+	}
+	require.NoError(t, scanner.Err())
+
+	require.NotEmpty(t, ipEvents)
+	require.Len(t, events, 4)
+	require.Equal(t, "previous", events[0].EventType)
+	sourceIPs := []string{"10.100.0.1", "10.100.0.1", "10.100.0.2"}
+	for i, eventType := range []string{
+		smfContext.SessionAuditCreate, smfContext.SessionAuditModify, smfContext.SessionAuditRelease,
+	} {
+		event := events[i+1]
+		require.Equal(t, "Audit", event.Category)
+		require.Equal(t, eventType, event.EventType)
+		require.Equal(t, smContext.Ref, event.SmCtxRef)
+		require.Equal(t, "imsi-208930000000141", event.Supi)
+		require.Equal(t, "internet", event.Dnn)
+		require.Equal(t, &models.Snssai{Sst: 1, Sd: "010203"}, event.Snssai)
+		require.Equal(t, sourceIPs[i], event.SourceIP)
+		require.Equal(t, "20893", event.OperatorID)
+		require.NotEmpty(t, event.Timestamp)
+	}
+}
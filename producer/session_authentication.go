@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/eap"
+	"github.com/omec-project/smf/smferrors"
+)
+
+// ErrSessionAuthFailed is returned when the UE of the session is not authenticated
+var ErrSessionAuthFailed = smferrors.New(smferrors.ErrCodeSessionAuthFailed, "session authentication failed")
+
+// eapResponseTimeout is the time the UE is given to answer a PDU Session Authentication Command,
+// T3590 of TS 24.501 10.3
+var eapResponseTimeout = 15 * time.Second
+
+// eapCommandTransmissions is the number of times a PDU Session Authentication Command is sent,
+// it being retransmitted four times on the expiry of T3590. TS 24.501 6.3.1.2
+const eapCommandTransmissions = 5
+
+// AuthenticateSession authenticates the UE of the created session, before its user plane is set
+// up, when the access of the session requires it, then for the slice of the session when it
+// requires NSSAA. It runs out of any transaction of the session, the UE answering in Update SM
+// Context requests. The session of a UE not authenticated is rejected and released.
+func AuthenticateSession(smContext *smf_context.SMContext) error {
+	err := authenticateAccess(smContext)
+	if err == nil {
+		err = authenticateSlice(smContext)
+	}
+	if err != nil {
+		smContext.SubPduSessLog.Errorf("session authentication failed: %v", err)
+		rejectUnauthenticatedSession(smContext)
+		return err
+	}
+	return nil
+}
+
+// authenticateAccess authenticates with EAP-AKA' the UE of the session over trusted non-3GPP
+// access, when configured
+func authenticateAccess(smContext *smf_context.SMContext) error {
+	handler := NewNonThreeGPPAccessHandler(smContext)
+	if handler == nil {
+		return nil
+	}
+	authenticator, err := handler.Authenticator()
+	if err != nil {
+		return err
+	}
+	if err := relayEAP(smContext, authenticator); err != nil {
+		return err
+	}
+	smContext.SubPduSessLog.Infof("UE authenticated with EAP-AKA'")
+	return nil
+}
+
+// relayEAP relays the EAP exchange between the authenticator and the UE of the session, the EAP
+// requests being sent in PDU Session Authentication Commands and the EAP responses received in
+// PDU Session Authentication Completes. The EAP-Success or EAP-Failure ending the exchange is
+// kept for the establishment accept or reject. TS 24.501 6.3.1
+func relayEAP(smContext *smf_context.SMContext, authenticator eap.Authenticator) error {
+	smContext.SMLock.Lock()
+	responses := smContext.StartEAPExchange()
+	smContext.SMLock.Unlock()
+	defer func() {
+		smContext.SMLock.Lock()
+		smContext.EndEAPExchange()
+		smContext.SMLock.Unlock()
+	}()
+
+	var identifier uint8
+	message, err := authenticator.Start()
+	for err == nil {
+		var p *eap.Packet
+		if p, err = eap.Decode(message); err != nil {
+			break
+		}
+		identifier = p.Identifier
+		switch p.Code {
+		case eap.CodeSuccess:
+			smContext.EAPResult = message
+			return nil
+		case eap.CodeFailure:
+			smContext.EAPResult = message
+			return ErrSessionAuthFailed
+		}
+
+		var response []byte
+		if response, err = requestEAPResponse(smContext, message, identifier, responses); err == nil {
+			message, err = authenticator.Next(response)
+		}
+	}
+	smContext.EAPResult = eap.Failure(identifier)
+	return smferrors.Wrap(smferrors.ErrCodeSessionAuthFailed, err, "session authentication failed")
+}
+
+// requestEAPResponse sends the EAP request to the UE and returns its response, the request being
+// sent again each time T3590 expires
+func requestEAPResponse(smContext *smf_context.SMContext, request []byte, identifier uint8,
+	responses <-chan []byte,
+) ([]byte, error) {
+	for range eapCommandTransmissions {
+		if err := sendPDUSessionAuthenticationCommand(smContext, request); err != nil {
+			return nil, err
+		}
+		if response := waitEAPResponse(smContext, identifier, responses); response != nil {
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("no EAP response of the UE to the request %d", identifier)
+}
+
+// waitEAPResponse returns the EAP response of the UE to the request of the identifier, nil if
+// none is received before T3590 expires. The responses to other requests are dropped.
+func waitEAPResponse(smContext *smf_context.SMContext, identifier uint8, responses <-chan []byte) []byte {
+	timeout := time.After(eapResponseTimeout)
+	for {
+		select {
+		case response := <-responses:
+			if p, err := eap.Decode(response); err == nil && p.Code == eap.CodeResponse && p.Identifier == identifier {
+				return response
+			}
+			smContext.SubPduSessLog.Warnf("EAP message not answering the request %d dropped", identifier)
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+// sendPDUSessionAuthenticationCommand sends the EAP request to the UE of the session in a PDU
+// Session Authentication Command
+func sendPDUSessionAuthenticationCommand(smContext *smf_context.SMContext, eapMessage []byte) error {
+	buf, err := smf_context.BuildGSMPDUSessionAuthenticationCommand(smContext, eapMessage)
+	if err != nil {
+		return fmt.Errorf("build GSM PDUSessionAuthenticationCommand failed: %w", err)
+	}
+	n1n2Request := models.N1N2MessageTransferRequest{
+		JsonData: &models.N1N2MessageTransferReqData{
+			PduSessionId: smContext.PDUSessionID,
+			N1MessageContainer: &models.N1MessageContainer{
+				N1MessageClass:   "SM",
+				N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+			},
+		},
+		BinaryDataN1Message: buf,
+	}
+	rspData, err := smContext.N1N2MessageTransfer(context.Background(), n1n2Request)
+	if err != nil {
+		return fmt.Errorf("send N1N2Transfer failed: %w", err)
+	}
+	if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
+		return fmt.Errorf("N1N2MessageTransfer failure, %v", rspData.Cause)
+	}
+	return nil
+}
+
+// rejectUnauthenticatedSession rejects the establishment of the session of the UE not
+// authenticated with cause #29, the EAP-Failure being sent to the UE in the PDU Session
+// Establishment Reject, then releases the session: the PCF is sent the SM policy association
+// deletion and the AMF is notified of the release of the SM context.
+func rejectUnauthenticatedSession(smContext *smf_context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	n1n2Request := models.N1N2MessageTransferRequest{
+		JsonData: &models.N1N2MessageTransferReqData{PduSessionId: smContext.PDUSessionID},
+	}
+	if buf, err := smf_context.BuildGSMPDUSessionEstablishmentReject(smContext,
+		nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed); err != nil {
+		smContext.SubPduSessLog.Errorf("session authentication, build GSM PDUSessionEstablishmentReject failed: %+v", err)
+	} else {
+		n1n2Request.BinaryDataN1Message = buf
+		n1n2Request.JsonData.N1MessageContainer = &models.N1MessageContainer{
+			N1MessageClass:   "SM",
+			N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+		}
+	}
+	if rspData, err := smContext.N1N2MessageTransfer(context.Background(), n1n2Request); err != nil {
+		smContext.SubPduSessLog.Warnf("session authentication, send N1N2Transfer failed: %v", err)
+	} else if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
+		smContext.SubPduSessLog.Warnf("session authentication, N1N2MessageTransfer failure, %v", rspData.Cause)
+	}
+
+	deleteSMPolicyAssociation(smContext, &models.ReleaseSmContextRequest{
+		JsonData: &models.SmContextReleaseData{},
+	}, "session authentication")
+
+	smf_context.RemoveSMContext(smContext.Ref)
+
+	if problemDetails, err := consumer.SendSMContextStatusNotification(smContext.SmStatusNotifyUri); err != nil {
+		smContext.SubPduSessLog.Warnf("session authentication, send SMContext Status Notification Error[%v]", err)
+	} else if problemDetails != nil {
+		smContext.SubPduSessLog.Warnf("session authentication, send SMContext Status Notification Problem[%+v]", problemDetails)
+	}
+}
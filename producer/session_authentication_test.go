@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omec-project/nas"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/Namf_Communication"
+	"github.com/omec-project/openapi/Nudm_UEAuthentication"
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/eap"
+	"github.com/omec-project/smf/transaction"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// eapTestUE is a UE answering the EAP-AKA' authentication of its session with its RES, and the
+// challenges of the other EAP methods with its secret
+type eapTestUE struct {
+	identity string
+	vector   *eap.Vector
+	res      []byte
+	secret   []byte
+	// PDU Session Authentication Commands ignored before answering
+	ignore int
+}
+
+// answer returns the EAP response of the UE to the EAP request, nil if it ignores it
+func (ue *eapTestUE) answer(t *testing.T, request []byte) []byte {
+	if ue.ignore > 0 {
+		ue.ignore--
+		return nil
+	}
+	p, err := eap.Decode(request)
+	require.NoError(t, err)
+	require.Equal(t, eap.CodeRequest, p.Code)
+	if p.Type == eap.TypeIdentity {
+		return (&eap.Packet{
+			Code: eap.CodeResponse, Identifier: p.Identifier, Type: eap.TypeIdentity, Data: []byte(ue.identity),
+		}).Encode()
+	}
+	if p.Type != eap.TypeAKAPrime {
+		return (&eap.Packet{Code: eap.CodeResponse, Identifier: p.Identifier, Type: p.Type, Data: ue.secret}).Encode()
+	}
+	keys := eap.DeriveKeys(ue.identity, ue.vector.IkPrime, ue.vector.CkPrime)
+	require.True(t, eap.Verify(keys.KAut, request))
+	response := (&eap.Packet{
+		Code:       eap.CodeResponse,
+		Identifier: p.Identifier,
+		Type:       eap.TypeAKAPrime,
+		Data:       eap.AKAPrimeData(eap.SubtypeChallenge, eap.ResAttribute(ue.res), eap.MacAttribute()),
+	}).Encode()
+	require.NoError(t, eap.Sign(keys.KAut, response))
+	return response
+}
+
+// sendAuthenticationComplete sends the EAP response of the UE to the SMF in the PDU Session
+// Authentication Complete of an Update SM Context Request
+func sendAuthenticationComplete(t *testing.T, smContext *smfContext.SMContext, eapMessage []byte) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionAuthenticationComplete)
+	m.PDUSessionAuthenticationComplete = nasMessage.NewPDUSessionAuthenticationComplete(0)
+	complete := m.PDUSessionAuthenticationComplete
+	complete.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	complete.SetMessageType(nas.MsgTypePDUSessionAuthenticationComplete)
+	complete.SetPDUSessionID(uint8(smContext.PDUSessionID))
+	complete.EAPMessage.SetLen(uint16(len(eapMessage)))
+	complete.SetEAPMessage(eapMessage)
+	nasPdu, err := m.PlainNasEncode()
+	require.NoError(t, err)
+
+	txn := transaction.NewTransaction(models.UpdateSmContextRequest{
+		JsonData:              &models.SmContextUpdateData{},
+		BinaryDataN1SmMessage: nasPdu,
+	}, nil, "")
+	txn.Ctxt = smContext
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	require.NoError(t, HandleUpdateN1Msg(txn, &models.UpdateSmContextResponse{
+		JsonData: &models.SmContextUpdatedData{},
+	}, &pfcpAction{}, &pfcpParam{}))
+}
+
+// n1Message returns the NAS message of the multipart N1N2 Message Transfer request
+func n1Message(t *testing.T, r *http.Request) *nas.Message {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		require.NoError(t, err, "N1N2 Message Transfer without N1 message")
+		if part.Header.Get("Content-Type") != "application/vnd.3gpp.5gnas" {
+			continue
+		}
+		buf, err := io.ReadAll(part)
+		require.NoError(t, err)
+		m := nas.NewMessage()
+		require.NoError(t, m.GsmMessageDecode(&buf))
+		return m
+	}
+}
+
+// newEAPTestNFs starts the UDM generating the vector of the UE and the AMF relaying the N1
+// messages of the session to the UE, the other N1 messages being returned on the channel
+func newEAPTestNFs(t *testing.T, smContext *smfContext.SMContext, ue *eapTestUE) <-chan *nas.Message {
+	t.Helper()
+	n1Messages := make(chan *nas.Message, 1)
+	nfs := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/generate-auth-data"):
+			var request models.AuthenticationInfoRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			require.Equal(t, "5G:mnc093.mcc208.3gppnetwork.org", request.ServingNetworkName)
+			require.NoError(t, json.NewEncoder(w).Encode(models.AuthenticationInfoResult{
+				AuthType: models.AuthType_EAP_AKA_PRIME,
+				AuthenticationVector: &models.AuthenticationVector{
+					AvType:  models.AvType_EAP_AKA_PRIME,
+					Rand:    hex.EncodeToString(ue.vector.Rand),
+					Autn:    hex.EncodeToString(ue.vector.Autn),
+					Xres:    hex.EncodeToString(ue.vector.Xres),
+					CkPrime: hex.EncodeToString(ue.vector.CkPrime),
+					IkPrime: hex.EncodeToString(ue.vector.IkPrime),
+				},
+			}))
+		case strings.HasSuffix(r.URL.Path, "/n1-n2-messages"):
+			m := n1Message(t, r)
+			require.NoError(t, json.NewEncoder(w).Encode(models.N1N2MessageTransferRspData{
+				Cause: models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED,
+			}))
+			if m.GsmHeader.GetMessageType() != nas.MsgTypePDUSessionAuthenticationCommand {
+				n1Messages <- m
+				return
+			}
+			require.Equal(t, uint8(0), m.PDUSessionAuthenticationCommand.GetPTI())
+			if response := ue.answer(t, m.PDUSessionAuthenticationCommand.GetEAPMessage()); response != nil {
+				go sendAuthenticationComplete(t, smContext, response)
+			}
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}), &http2.Server{}))
+	t.Cleanup(nfs.Close)
+
+	smfSelf := smfContext.SMF_Self()
+	origUEAuthenticationClient := smfSelf.UEAuthenticationClient
+	t.Cleanup(func() { smfSelf.UEAuthenticationClient = origUEAuthenticationClient })
+	ueauConfig := Nudm_UEAuthentication.NewConfiguration()
+	ueauConfig.SetBasePath(nfs.URL)
+	smfSelf.UEAuthenticationClient = Nudm_UEAuthentication.NewAPIClient(ueauConfig)
+
+	commConfig := Namf_Communication.NewConfiguration()
+	commConfig.SetBasePath(nfs.URL)
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(commConfig)
+	smContext.AMFClient = nil
+	smContext.SmStatusNotifyUri = nfs.URL + "/sm-context-status"
+	return n1Messages
+}
+
+// newEAPTestSession creates the session of the SUPI over trusted non-3GPP access, the SMF
+// authenticating its UEs with EAP-AKA'
+func newEAPTestSession(t *testing.T, supi string, ratType models.RatType) *smfContext.SMContext {
+	t.Helper()
+	smfSelf := smfContext.SMF_Self()
+	origEapAkaPrime := smfSelf.EapAkaPrimeNon3gppAccess
+	t.Cleanup(func() { smfSelf.EapAkaPrimeNon3gppAccess = origEapAkaPrime })
+	smfSelf.EapAkaPrimeNon3gppAccess = true
+
+	txn := newSessionSetup(t, supi)
+	createData := txn.Req.(CreateSmContextRequest).JsonData
+	createData.AnType = models.AccessType_NON_3_GPP_ACCESS
+	createData.RatType = ratType
+	require.NoError(t, HandlePDUSessionSMContextCreate(txn))
+	return txn.Ctxt.(*smfContext.SMContext)
+}
+
+func TestAuthenticateSession(t *testing.T) {
+	origEapResponseTimeout := eapResponseTimeout
+	t.Cleanup(func() { eapResponseTimeout = origEapResponseTimeout })
+	eapResponseTimeout = 50 * time.Millisecond
+
+	vector := &eap.Vector{
+		Rand:    bytes.Repeat([]byte{0x01}, 16),
+		Autn:    bytes.Repeat([]byte{0x02}, 16),
+		Xres:    bytes.Repeat([]byte{0x03}, 8),
+		CkPrime: bytes.Repeat([]byte{0x04}, 16),
+		IkPrime: bytes.Repeat([]byte{0x05}, 16),
+	}
+
+	t.Run("Authenticated", func(t *testing.T) {
+		smContext := newEAPTestSession(t, "imsi-208930000000141", smfContext.RatTypeTrustedWLAN)
+		// the first PDU Session Authentication Command is lost, T3590 expires
+		ue := &eapTestUE{identity: "0208930000000141", vector: vector, res: vector.Xres, ignore: 1}
+		n1Messages := newEAPTestNFs(t, smContext, ue)
+
+		require.NoError(t, AuthenticateSession(smContext))
+		require.Equal(t, byte(eap.CodeSuccess), smContext.EAPResult[0])
+
+		// the session is set up as over 3GPP access, its PFCP rules marking the trusted access
+		stubUPF(t, smContext, smfContext.SessionEstablishSuccess)
+		require.NoError(t, EstablishPfcpSession(smContext))
+		require.NoError(t, SendPduSessN1N2Transfer(smContext, true))
+		accept := <-n1Messages
+		require.Equal(t, nas.MsgTypePDUSessionEstablishmentAccept, accept.GsmHeader.GetMessageType())
+		require.NotNil(t, accept.PDUSessionEstablishmentAccept.EAPMessage)
+		require.Equal(t, smContext.EAPResult, accept.PDUSessionEstablishmentAccept.EAPMessage.GetEAPMessage())
+		require.Equal(t, smfContext.TGPPInterfaceTypeN3TrustedNon3GPPAccess, *smContext.AccessInterfaceType())
+		require.NotNil(t, smfContext.GetSMContext(smContext.Ref))
+	})
+
+	t.Run("WrongRes", func(t *testing.T) {
+		smContext := newEAPTestSession(t, "imsi-208930000000142", smfContext.RatTypeTrustedN3GA)
+		ue := &eapTestUE{identity: "0208930000000142", vector: vector, res: bytes.Repeat([]byte{0x09}, 8)}
+		n1Messages := newEAPTestNFs(t, smContext, ue)
+
+		err := AuthenticateSession(smContext)
+		require.True(t, errors.Is(err, ErrSessionAuthFailed))
+		reject := <-n1Messages
+		require.Equal(t, nas.MsgTypePDUSessionEstablishmentReject, reject.GsmHeader.GetMessageType())
+		require.Equal(t, nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed,
+			reject.PDUSessionEstablishmentReject.GetCauseValue())
+		require.NotNil(t, reject.PDUSessionEstablishmentReject.EAPMessage)
+		require.Equal(t, byte(eap.CodeFailure), reject.PDUSessionEstablishmentReject.EAPMessage.GetEAPMessage()[0])
+		require.Nil(t, smfContext.GetSMContext(smContext.Ref))
+	})
+
+	t.Run("UENotAnswering", func(t *testing.T) {
+		smContext := newEAPTestSession(t, "imsi-208930000000143", smfContext.RatTypeTrustedN3GA)
+		ue := &eapTestUE{identity: "0208930000000143", vector: vector, res: vector.Xres, ignore: eapCommandTransmissions}
+		n1Messages := newEAPTestNFs(t, smContext, ue)
+
+		require.True(t, errors.Is(AuthenticateSession(smContext), ErrSessionAuthFailed))
+		require.Equal(t, 0, ue.ignore)
+		reject := <-n1Messages
+		require.Equal(t, nas.MsgTypePDUSessionEstablishmentReject, reject.GsmHeader.GetMessageType())
+		require.Nil(t, smfContext.GetSMContext(smContext.Ref))
+	})
+
+	t.Run("UntrustedAccess", func(t *testing.T) {
+		smContext := newEAPTestSession(t, "imsi-208930000000144", models.RatType_WLAN)
+		require.Nil(t, NewNonThreeGPPAccessHandler(smContext))
+		require.NoError(t, AuthenticateSession(smContext))
+		require.Nil(t, smContext.EAPResult)
+	})
+}
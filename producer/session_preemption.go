@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"context"
+
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smf_context "github.com/omec-project/smf/context"
+)
+
+// releasePreemptedSession releases the session preempted at the session limit of its DNN, with
+// cause #26
+var releasePreemptedSession = func(smContext *smf_context.SMContext) {
+	smContext.SubPduSessLog.Infof("session preempted by a session of a higher ARP priority, released")
+	ReleaseSessionByNetwork(smContext, nasMessage.Cause5GSMInsufficientResources, "session preemption")
+}
+
+// ReleaseSessionOnErrorIndication releases the session whose N9 tunnel was lost by the peer UPF,
+// with cause #39 for the UE to establish it again
+var ReleaseSessionOnErrorIndication = func(smContext *smf_context.SMContext) {
+	smContext.SubPduSessLog.Infof("N9 tunnel of the session lost, released")
+	ReleaseSessionByNetwork(smContext, nasMessage.Cause5GSMReactivationRequested, "error indication")
+}
+
+// ReleaseSessionByNetwork releases the session as requested by the network: the UE and the AN
+// are sent the PDU Session Release Command with the cause, the PCF the SM policy association
+// deletion and the UPFs the PFCP Session Deletion, then the AMF is notified of the release of the
+// SM context. The procedure names the release in the logs.
+func ReleaseSessionByNetwork(smContext *smf_context.SMContext, cause uint8, procedure string) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	// network requested, no procedure transaction
+	smContext.Pti = 0
+	n1n2Request := models.N1N2MessageTransferRequest{
+		JsonData: &models.N1N2MessageTransferReqData{PduSessionId: smContext.PDUSessionID},
+	}
+	if buf, err := smf_context.BuildGSMPDUSessionReleaseCommandWithCause(smContext, cause); err != nil {
+		smContext.SubPduSessLog.Errorf("%s, build GSM PDUSessionReleaseCommand failed: %+v", procedure, err)
+	} else {
+		n1n2Request.BinaryDataN1Message = buf
+		n1n2Request.JsonData.N1MessageContainer = &models.N1MessageContainer{
+			N1MessageClass:   "SM",
+			N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+		}
+	}
+	if buf, err := smf_context.BuildPDUSessionResourceReleaseCommandTransfer(smContext); err != nil {
+		smContext.SubPduSessLog.Errorf("%s, build PDUSessionResourceReleaseCommandTransfer failed: %+v", procedure, err)
+	} else {
+		n1n2Request.BinaryDataN2Information = buf
+		n1n2Request.JsonData.N2InfoContainer = &models.N2InfoContainer{
+			N2InformationClass: models.N2InformationClass_SM,
+			SmInfo: &models.N2SmInformation{
+				PduSessionId: smContext.PDUSessionID,
+				N2InfoContent: &models.N2InfoContent{
+					NgapIeType: models.NgapIeType_PDU_RES_REL_CMD,
+					NgapData:   &models.RefToBinaryData{ContentId: "N2SmInformation"},
+				},
+				SNssai: smContext.Snssai,
+			},
+		}
+	}
+	if rspData, err := smContext.N1N2MessageTransfer(context.Background(), n1n2Request); err != nil {
+		smContext.SubPduSessLog.Warnf("%s, send N1N2Transfer failed: %v", procedure, err)
+	} else if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
+		smContext.SubPduSessLog.Warnf("%s, N1N2MessageTransfer failure, %v", procedure, rspData.Cause)
+	}
+
+	deleteSMPolicyAssociation(smContext, &models.ReleaseSmContextRequest{
+		JsonData: &models.SmContextReleaseData{},
+	}, procedure)
+
+	smContext.ChangeState(smf_context.SmStatePfcpRelease)
+	if smContext.Tunnel != nil {
+		if err := SendPfcpSessionReleaseReq(smContext); err != nil {
+			smContext.SubPduSessLog.Errorf("%s, %v", procedure, err)
+		}
+	}
+	smf_context.RemoveSMContext(smContext.Ref)
+
+	if problemDetails, err := consumer.SendSMContextStatusNotification(smContext.SmStatusNotifyUri); err != nil {
+		smContext.SubPduSessLog.Warnf("%s, send SMContext Status Notification Error[%v]", procedure, err)
+	} else if problemDetails != nil {
+		smContext.SubPduSessLog.Warnf("%s, send SMContext Status Notification Problem[%+v]", procedure, problemDetails)
+	}
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"testing"
+
+	"github.com/omec-project/nas"
+	"github.com/omec-project/nas/nasMessage"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseSessionOnErrorIndication(t *testing.T) {
+	txn := newSessionSetup(t, "imsi-208930000000143")
+	require.NoError(t, HandlePDUSessionSMContextCreate(txn))
+	smContext := txn.Ctxt.(*smfContext.SMContext)
+	// no PFCP session established
+	smContext.Tunnel = nil
+	n1Messages := newEAPTestNFs(t, smContext, &eapTestUE{})
+
+	ReleaseSessionOnErrorIndication(smContext)
+	release := <-n1Messages
+	require.Equal(t, nas.MsgTypePDUSessionReleaseCommand, release.GsmHeader.GetMessageType())
+	require.Equal(t, nasMessage.Cause5GSMReactivationRequested, release.PDUSessionReleaseCommand.GetCauseValue())
+	require.Equal(t, uint8(0), release.PDUSessionReleaseCommand.GetPTI())
+	require.Nil(t, smfContext.GetSMContext(smContext.Ref))
+}
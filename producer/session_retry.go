@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+	"time"
+
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/metrics"
+)
+
+// sendPFCPRules sends the rules of the session to the UPFs, replaced in tests
+var sendPFCPRules = SendPFCPRules
+
+// MaxRetryBackoff caps the backoff between the attempts of the establishment of a session, the
+// AMF waiting for its outcome
+var MaxRetryBackoff = 2 * time.Second
+
+// retrySessionSetup runs a step of the session establishment up to the number of attempts of the
+// retry policy of the DNN. The retries select another UPF, after a backoff doubled on each retry.
+// A non retriable failure is returned at once.
+func retrySessionSetup(smContext *smf_context.SMContext, step string, setup func(attempt int) error) error {
+	maxAttempts, backoff := smContext.RetryPolicy()
+	for attempt := 1; ; attempt++ {
+		err := setup(attempt)
+		if err == nil {
+			return nil
+		}
+		if !smf_context.IsRetriable(err) || attempt >= maxAttempts {
+			return err
+		}
+		delay := min(backoff, MaxRetryBackoff)
+		smContext.SubPduSessLog.Warnf("%s attempt %d/%d failed: %v, retrying on another UPF in %v",
+			step, attempt, maxAttempts, err, delay)
+		time.Sleep(delay)
+		backoff *= 2
+	}
+}
+
+// EstablishPfcpSession sets up the PFCP sessions of the session on its UPFs. When the
+// establishment fails, the UPF is selected again and the establishment retried according
+// to the retry policy of the DNN. A rejection by the UPF is returned as a PFCPError. The NW-TT
+// port of a TSN bridge is then configured, and the charging of the session opened.
+func EstablishPfcpSession(smContext *smf_context.SMContext) error {
+	start := time.Now()
+	defer func() {
+		observeSessionSetupPhase(smContext, metrics.SessionSetupPfcpEstablish, time.Since(start))
+	}()
+	if err := establishPfcpSessions(smContext); err != nil {
+		return err
+	}
+	configureTSNBridge(smContext)
+	openCharging(smContext)
+	return nil
+}
+
+// establishPfcpSessions sends the rules of the session to its UPFs and waits for the PFCP session
+// establishments, retried on another UPF according to the retry policy of the DNN
+func establishPfcpSessions(smContext *smf_context.SMContext) error {
+	return retrySessionSetup(smContext, "pfcp session establishment", func(attempt int) error {
+		if attempt > 1 {
+			if err := smContext.ReselectDefaultDataPath(); err != nil {
+				return err
+			}
+		}
+		smContext.PFCPError = nil
+		smContext.TSNBridge = nil
+		sendPFCPRules(smContext)
+		smContext.SubFsmLog.Debug("waiting for pfcp session establish response")
+		if <-smContext.SBIPFCPCommunicationChan != smf_context.SessionEstablishSuccess {
+			if smContext.PFCPError != nil {
+				return fmt.Errorf("pfcp session establish response failure: %w", smContext.PFCPError)
+			}
+			return fmt.Errorf("pfcp session establish response failure")
+		}
+		return nil
+	})
+}
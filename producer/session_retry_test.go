@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+// newRetryTestSMContext returns a session of a DNN served by two UPFs, with its default data path activated
+func newRetryTestSMContext(t *testing.T, retry *factory.RetryConfig) *smfContext.SMContext {
+	t.Helper()
+	contexttest.SetConfiguration(t, &factory.Configuration{})
+
+	snssai := &models.Snssai{Sst: 1, Sd: "010203"}
+	contexttest.SetUserPlane(t, contexttest.NewUserPlane(t, "10.200.0.100", map[string]factory.UPNode{
+		"upf1": contexttest.UPF("10.200.0.1", snssai),
+		"upf2": contexttest.UPF("10.200.0.2", snssai),
+	}))
+
+	smContext := contexttest.NewSMContext("imsi-208930000000101", 10, snssai,
+		&smfContext.SnssaiSmfDnnInfo{DefaultQos: contexttest.DefaultQos(), Retry: retry})
+	smContext.SmPolicyUpdates = append(smContext.SmPolicyUpdates,
+		qos.BuildSmPolicyUpdate(&smContext.SmPolicyData, fallbackSmPolicyDecision(smContext)))
+	smContext.PDUAddress = &smfContext.UeIpAddr{Ip: net.ParseIP("60.60.0.1")}
+	smContext.Tunnel = smfContext.NewUPTunnel()
+	require.NoError(t, smContext.ReselectDefaultDataPath())
+	return smContext
+}
+
+// stubUPF answers the PFCP session establishments of the session with the results in order
+func stubUPF(t *testing.T, smContext *smfContext.SMContext, results ...smfContext.PFCPSessionResponseStatus) *int {
+	t.Helper()
+	origSendPFCPRules := sendPFCPRules
+	t.Cleanup(func() { sendPFCPRules = origSendPFCPRules })
+
+	calls := 0
+	sendPFCPRules = func(ctx *smfContext.SMContext) {
+		require.Less(t, calls, len(results), "unexpected PFCP session establishment")
+		ctx.SBIPFCPCommunicationChan <- results[calls]
+		calls++
+	}
+	return &calls
+}
+
+func TestEstablishPfcpSessionRetriesFailedUPF(t *testing.T) {
+	smContext := newRetryTestSMContext(t, &factory.RetryConfig{MaxAttempts: 3})
+	failedUPF := smContext.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.UPF
+	calls := stubUPF(t, smContext, smfContext.SessionEstablishFailed, smfContext.SessionEstablishSuccess)
+
+	require.NoError(t, EstablishPfcpSession(smContext))
+	assert.Equal(t, 2, *calls)
+
+	// the default path was selected again on the other UPF for the second attempt
+	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	require.NotNil(t, defaultPath)
+	assert.True(t, defaultPath.Activated)
+	assert.Len(t, smContext.Tunnel.DataPathPool, 1)
+	assert.NotEqual(t, failedUPF.NodeID.ResolveNodeIdToIp().String(),
+		defaultPath.FirstDPNode.UPF.NodeID.ResolveNodeIdToIp().String())
+}
+
+func TestEstablishPfcpSessionRetryBackoff(t *testing.T) {
+	smContext := newRetryTestSMContext(t, &factory.RetryConfig{MaxAttempts: 2, BackoffMs: 50})
+	calls := stubUPF(t, smContext, smfContext.SessionEstablishFailed, smfContext.SessionEstablishSuccess)
+
+	start := time.Now()
+	require.NoError(t, EstablishPfcpSession(smContext))
+	assert.Equal(t, 2, *calls)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestEstablishPfcpSessionWithoutRetryPolicy(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	calls := stubUPF(t, smContext, smfContext.SessionEstablishFailed)
+
+	require.Error(t, EstablishPfcpSession(smContext))
+	assert.Equal(t, 1, *calls)
+}
+
+func TestEstablishPfcpSessionAttemptsExhausted(t *testing.T) {
+	smContext := newRetryTestSMContext(t, &factory.RetryConfig{MaxAttempts: 2})
+	calls := stubUPF(t, smContext, smfContext.SessionEstablishFailed, smfContext.SessionEstablishFailed)
+
+	err := EstablishPfcpSession(smContext)
+	require.Error(t, err)
+	assert.True(t, smfContext.IsRetriable(err))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestEstablishPfcpSessionNoOtherUPF(t *testing.T) {
+	smContext := newRetryTestSMContext(t, &factory.RetryConfig{MaxAttempts: 3})
+	calls := stubUPF(t, smContext, smfContext.SessionEstablishFailed, smfContext.SessionEstablishFailed)
+
+	// both UPFs failed, the third attempt has no UPF left to select
+	err := EstablishPfcpSession(smContext)
+	require.Error(t, err)
+	assert.True(t, smfContext.IsRetriable(err))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestSessionErrorNonRetriable(t *testing.T) {
+	smContext := newRetryTestSMContext(t, &factory.RetryConfig{MaxAttempts: 3})
+
+	attempts := 0
+	err := retrySessionSetup(smContext, "test", func(int) error {
+		attempts++
+		return &smfContext.SessionError{Err: assert.AnError, NonRetriable: true}
+	})
+	require.ErrorIs(t, err, assert.AnError)
+	assert.False(t, smfContext.IsRetriable(err))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestEstablishPfcpSessionRejectedByUPF(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	origSendPFCPRules := sendPFCPRules
+	t.Cleanup(func() { sendPFCPRules = origSendPFCPRules })
+	sendPFCPRules = func(ctx *smfContext.SMContext) {
+		ctx.PFCPError = smfContext.NewPFCPError("Session Establishment Response",
+			ie.NewCause(ie.CauseNoEstablishedPFCPAssociation), nil)
+		ctx.SBIPFCPCommunicationChan <- smfContext.SessionEstablishFailed
+	}
+
+	err := EstablishPfcpSession(smContext)
+	var pfcpErr *smfContext.PFCPError
+	require.True(t, errors.As(err, &pfcpErr))
+	assert.Equal(t, ie.CauseNoEstablishedPFCPAssociation, pfcpErr.Cause)
+	assert.True(t, smfContext.IsRetriable(err))
+}
+
+func TestEstablishPfcpSessionConfiguresTSNBridge(t *testing.T) {
+	smContext := newRetryTestSMContext(t, nil)
+	smContext.DNNInfo.TSNConfig = &factory.TSNConfig{TxPropagationDelay: 1000, Priority1: 246, Priority2: 248}
+	upfNodeID := smContext.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.UPF.NodeID
+
+	origSendPFCPRules, origSendPfcpTSCManagement := sendPFCPRules, sendPfcpTSCManagement
+	t.Cleanup(func() { sendPFCPRules, sendPfcpTSCManagement = origSendPFCPRules, origSendPfcpTSCManagement })
+	// the UPF creates the DS-TT and NW-TT ports of the session
+	sendPFCPRules = func(ctx *smfContext.SMContext) {
+		ctx.TSNBridge = &smfContext.TSNBridge{UPFNodeID: upfNodeID, DSTTPortNumber: 2, NWTTPortNumber: 7}
+		ctx.SBIPFCPCommunicationChan <- smfContext.SessionEstablishSuccess
+	}
+	var sentPort uint32
+	var sentPortManagement []byte
+	sendPfcpTSCManagement = func(upNodeID smfContext.NodeID, ctx *smfContext.SMContext, upfPort uint16,
+		portManagementInfo []byte, nwttPortNumber uint32,
+	) error {
+		require.True(t, upNodeID.ResolveNodeIdToIp().Equal(upfNodeID.ResolveNodeIdToIp()))
+		sentPort, sentPortManagement = nwttPortNumber, portManagementInfo
+		ctx.SBIPFCPCommunicationChan <- smfContext.SessionUpdateSuccess
+		return nil
+	}
+
+	require.NoError(t, EstablishPfcpSession(smContext))
+	expected, err := smfContext.GetTSNPortManagement(smContext)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), sentPort)
+	assert.Equal(t, expected, sentPortManagement)
+
+	// no NW-TT port reported, nothing to configure
+	sendPFCPRules = func(ctx *smfContext.SMContext) {
+		ctx.TSNBridge = &smfContext.TSNBridge{UPFNodeID: upfNodeID, DSTTPortNumber: 2}
+		ctx.SBIPFCPCommunicationChan <- smfContext.SessionEstablishSuccess
+	}
+	sentPort = 0
+	require.NoError(t, EstablishPfcpSession(smContext))
+	assert.Equal(t, uint32(0), sentPort)
+}
@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/omec-project/nas"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/Nnrf_NFDiscovery"
+	"github.com/omec-project/openapi/Nnrf_NFManagement"
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/msgtypes/svcmsgtypes"
+	"github.com/omec-project/smf/transaction"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newSessionSetupNFs starts an NRF discovering itself as the UDM, PCF and AMF of the session.
+// The PCF rejects the policy association, the default QoS of the DNN applies.
+func newSessionSetupNFs(t *testing.T) *httptest.Server {
+	t.Helper()
+	var nfs *httptest.Server
+	nfs = httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/nf-instances"):
+			nfType := models.NfType(r.URL.Query().Get("target-nf-type"))
+			services := map[models.NfType]models.ServiceName{
+				models.NfType_UDM: models.ServiceName_NUDM_SDM,
+				models.NfType_PCF: models.ServiceName_NPCF_SMPOLICYCONTROL,
+				models.NfType_AMF: models.ServiceName_NAMF_COMM,
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(models.SearchResult{
+				NfInstances: []models.NfProfile{{
+					NfInstanceId: string(nfType) + "-1",
+					NfType:       nfType,
+					NfServices: &[]models.NfService{{
+						ServiceName: services[nfType],
+						ApiPrefix:   nfs.URL,
+					}},
+				}},
+			}))
+		case strings.HasSuffix(r.URL.Path, "/subscriptions"):
+			w.WriteHeader(http.StatusCreated)
+			require.NoError(t, json.NewEncoder(w).Encode(models.NrfSubscriptionData{SubscriptionId: "1"}))
+		case strings.HasSuffix(r.URL.Path, "/sm-data"):
+			require.NoError(t, json.NewEncoder(w).Encode([]models.SessionManagementSubscriptionData{{
+				SingleNssai:       &models.Snssai{Sst: 1, Sd: "010203"},
+				DnnConfigurations: map[string]models.DnnConfiguration{"internet": {}},
+			}}))
+		case strings.HasSuffix(r.URL.Path, "/n1-n2-messages"):
+			require.NoError(t, json.NewEncoder(w).Encode(models.N1N2MessageTransferRspData{
+				Cause: models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED,
+			}))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}), &http2.Server{}))
+	t.Cleanup(nfs.Close)
+	return nfs
+}
+
+// sessionSetupPhaseObservations returns the number of observations of the phase of the setups of
+// the sessions of the slice and DNN
+func sessionSetupPhaseObservations(t *testing.T, phase string) uint64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "smf_session_setup_phase_duration_seconds" {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{"snssai_sst": "1", "snssai_sd": "010203", "dnn": "internet", "phase": phase}
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			return metric.GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+// newSessionSetup prepares the establishment of the session of the SUPI, its UDM, PCF and AMF
+// discovered from the NRF of newSessionSetupNFs and its UPF associated. It returns the transaction
+// of the Create SM Context Request of the AMF.
+func newSessionSetup(t *testing.T, supi string) *transaction.Transaction {
+	t.Helper()
+	nfs := newSessionSetupNFs(t)
+	smfSelf := smfContext.SMF_Self()
+	origNrfUri := smfSelf.NrfUri
+	origNFDiscoveryClient := smfSelf.NFDiscoveryClient
+	origNFManagementClient := smfSelf.NFManagementClient
+	t.Cleanup(func() {
+		smfSelf.NrfUri = origNrfUri
+		smfSelf.NFDiscoveryClient = origNFDiscoveryClient
+		smfSelf.NFManagementClient = origNFManagementClient
+		for _, nfInstanceID := range []string{"UDM-1", "PCF-1", "AMF-1"} {
+			smfSelf.NfStatusSubscriptions.Delete(nfInstanceID)
+		}
+	})
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+	smfSelf.NrfUri = nfs.URL
+	discoveryConfig := Nnrf_NFDiscovery.NewConfiguration()
+	discoveryConfig.SetBasePath(nfs.URL)
+	smfSelf.NFDiscoveryClient = Nnrf_NFDiscovery.NewAPIClient(discoveryConfig)
+	managementConfig := Nnrf_NFManagement.NewConfiguration()
+	managementConfig.SetBasePath(nfs.URL)
+	smfSelf.NFManagementClient = Nnrf_NFManagement.NewAPIClient(managementConfig)
+
+	snssai := &models.Snssai{Sst: 1, Sd: "010203"}
+	ueIPAllocator, err := smfContext.NewIPAllocator("10.60.0.0/24")
+	require.NoError(t, err)
+	contexttest.SetSnssaiInfos(t, []smfContext.SnssaiSmfInfo{{
+		Snssai: smfContext.SNssai{Sst: 1, Sd: "010203"},
+		DnnInfos: map[string]*smfContext.SnssaiSmfDnnInfo{
+			"internet": {
+				UeIPAllocator: ueIPAllocator,
+				DefaultQos:    contexttest.DefaultQos(),
+			},
+		},
+	}})
+	contexttest.SetUserPlane(t, contexttest.NewUserPlane(t, "10.200.0.100", map[string]factory.UPNode{
+		"upf": contexttest.UPF("10.200.0.1", snssai),
+	}))
+
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionEstablishmentRequest)
+	m.PDUSessionEstablishmentRequest = nasMessage.NewPDUSessionEstablishmentRequest(0)
+	m.PDUSessionEstablishmentRequest.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	m.PDUSessionEstablishmentRequest.SetMessageType(nas.MsgTypePDUSessionEstablishmentRequest)
+	m.PDUSessionEstablishmentRequest.SetPDUSessionID(12)
+	m.PDUSessionEstablishmentRequest.SetPTI(1)
+	nasPdu, err := m.PlainNasEncode()
+	require.NoError(t, err)
+
+	smContext := smfContext.NewSMContext(supi, 12)
+	t.Cleanup(func() {
+		if smfContext.GetSMContext(smContext.Ref) != nil {
+			smfContext.RemoveSMContext(smContext.Ref)
+		}
+	})
+	txn := transaction.NewTransaction(CreateSmContextRequest{PostSmContextsRequest: models.PostSmContextsRequest{
+		JsonData: &models.SmContextCreateData{
+			Supi:           supi,
+			PduSessionId:   12,
+			Dnn:            "internet",
+			SNssai:         snssai,
+			ServingNfId:    "AMF-1",
+			ServingNetwork: &models.PlmnId{Mcc: "208", Mnc: "93"},
+		},
+		BinaryDataN1SmMessage: nasPdu,
+	}}, nil, svcmsgtypes.CreateSmContext)
+	txn.Ctxt = smContext
+
+	return txn
+}
+
+func TestSessionSetupPhaseDuration(t *testing.T) {
+	txn := newSessionSetup(t, "imsi-208930000000131")
+	smContext := txn.Ctxt.(*smfContext.SMContext)
+
+	phases := []string{
+		metrics.SessionSetupUdmQuery, metrics.SessionSetupPcfQuery, metrics.SessionSetupUpfSelection,
+		metrics.SessionSetupPfcpEstablish, metrics.SessionSetupNasEncode, metrics.SessionSetupAmfNotify,
+	}
+	observations := make(map[string]uint64)
+	for _, phase := range phases {
+		observations[phase] = sessionSetupPhaseObservations(t, phase)
+	}
+
+	require.NoError(t, HandlePDUSessionSMContextCreate(txn))
+	stubUPF(t, smContext, smfContext.SessionEstablishSuccess)
+	require.NoError(t, EstablishPfcpSession(smContext))
+	require.NoError(t, SendPduSessN1N2Transfer(smContext, true))
+
+	for _, phase := range phases {
+		require.Equal(t, observations[phase]+1, sessionSetupPhaseObservations(t, phase), phase)
+	}
+}
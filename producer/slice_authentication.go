@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/nnssaaf"
+)
+
+// authenticateSlice runs the network slice-specific authentication and authorization of the UE
+// when the slice of the session requires it, the EAP exchange between the NSSAAF and the UE being
+// relayed over N1. The UE is not authorized on the slice without an answer of the NSSAAF.
+func authenticateSlice(smContext *smf_context.SMContext) error {
+	client := nnssaaf.GetNSSAAFClient()
+	if client == nil || smContext.Snssai == nil || !client.Required(*smContext.Snssai) {
+		return nil
+	}
+	return relayEAP(smContext, client.Authenticator(smContext.Supi, smContext.Gpsi, *smContext.Snssai))
+}
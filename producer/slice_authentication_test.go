@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/omec-project/nas"
+	"github.com/omec-project/nas/nasMessage"
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/eap"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/nnssaaf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newSliceAuthTestNSSAAF starts the NSSAAF of the slices of the S-NSSAIs, challenging the UEs
+// with EAP-MD5-Challenge for the secret. The slice authentications are counted.
+func newSliceAuthTestNSSAAF(t *testing.T, secret string, snssais ...models.Snssai) *int32 {
+	t.Helper()
+	var authentications int32
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&authentications, 1)
+			var authInfo nnssaaf.SliceAuthInfo
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&authInfo))
+			w.WriteHeader(http.StatusCreated)
+			require.NoError(t, json.NewEncoder(w).Encode(nnssaaf.SliceAuthContext{
+				Snssai:     authInfo.Snssai,
+				AuthCtxId:  authInfo.Supi,
+				EapMessage: (&eap.Packet{Code: eap.CodeRequest, Identifier: 7, Type: 4, Data: []byte("challenge")}).Encode(),
+			}))
+			return
+		}
+		var confirmation nnssaaf.SliceAuthConfirmationData
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&confirmation))
+		response, err := eap.Decode(confirmation.EapMessage)
+		require.NoError(t, err)
+		result := nnssaaf.AuthResultFailure
+		if string(response.Data) == secret && strings.HasSuffix(r.URL.Path, "/slice-authentications/imsi-208930000000121") {
+			result = nnssaaf.AuthResultSuccess
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(nnssaaf.SliceAuthConfirmationResponse{
+			Snssai:     confirmation.Snssai,
+			AuthResult: result,
+		}))
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+
+	t.Cleanup(func() { nnssaaf.InitNSSAAFClient(nil) })
+	nnssaaf.InitNSSAAFClient(&factory.NssaafConfig{Uri: server.URL, Snssais: snssais})
+	return &authentications
+}
+
+// newSliceAuthTestSession creates the session of the SUPI over 3GPP access in the slice
+// sst:1 sd:010203
+func newSliceAuthTestSession(t *testing.T, supi string) *smfContext.SMContext {
+	t.Helper()
+	txn := newSessionSetup(t, supi)
+	require.NoError(t, HandlePDUSessionSMContextCreate(txn))
+	return txn.Ctxt.(*smfContext.SMContext)
+}
+
+func TestAuthenticateSlice(t *testing.T) {
+	protected := models.Snssai{Sst: 1, Sd: "010203"}
+
+	t.Run("Authenticated", func(t *testing.T) {
+		authentications := newSliceAuthTestNSSAAF(t, "secret", protected)
+		smContext := newSliceAuthTestSession(t, "imsi-208930000000121")
+		newEAPTestNFs(t, smContext, &eapTestUE{identity: "user@slice", secret: []byte("secret")})
+
+		require.NoError(t, AuthenticateSession(smContext))
+		require.Equal(t, eap.Success(7), smContext.EAPResult)
+		require.Equal(t, int32(1), atomic.LoadInt32(authentications))
+		require.NotNil(t, smfContext.GetSMContext(smContext.Ref))
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		newSliceAuthTestNSSAAF(t, "secret", protected)
+		smContext := newSliceAuthTestSession(t, "imsi-208930000000122")
+		n1Messages := newEAPTestNFs(t, smContext, &eapTestUE{identity: "user@slice", secret: []byte("secret")})
+
+		require.True(t, errors.Is(AuthenticateSession(smContext), ErrSessionAuthFailed))
+		reject := <-n1Messages
+		require.Equal(t, nas.MsgTypePDUSessionEstablishmentReject, reject.GsmHeader.GetMessageType())
+		require.Equal(t, nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed,
+			reject.PDUSessionEstablishmentReject.GetCauseValue())
+		require.Equal(t, eap.Failure(7), reject.PDUSessionEstablishmentReject.EAPMessage.GetEAPMessage())
+		require.Nil(t, smfContext.GetSMContext(smContext.Ref))
+	})
+
+	t.Run("NotRequired", func(t *testing.T) {
+		authentications := newSliceAuthTestNSSAAF(t, "secret", models.Snssai{Sst: 1, Sd: "112233"})
+		smContext := newSliceAuthTestSession(t, "imsi-208930000000123")
+
+		require.NoError(t, AuthenticateSession(smContext))
+		require.Nil(t, smContext.EAPResult)
+		require.Equal(t, int32(0), atomic.LoadInt32(authentications))
+	})
+}
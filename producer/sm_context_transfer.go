@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/omec-project/openapi/Namf_Communication"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/consumer"
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/util/httpwrapper"
+)
+
+// HandleSMContextTransfer adopts the session context transferred by the old SMF of an inter-SMF
+// handover. The adopted session takes a slot of the sessions of its DNN, and the PCF and the AMF of
+// the session are selected again, the clients of the old SMF being dropped. Its user plane is set
+// up on the UPFs of this SMF before the transfer is accepted, the old SMF deleting its PFCP
+// sessions once accepted. The adopted session is removed if any of these fails.
+func HandleSMContextTransfer(smContext *smf_context.SMContext) *httpwrapper.Response {
+	if err := smf_context.AdoptTransferredSMContext(smContext); err != nil {
+		smContext.SubPduSessLog.Errorf("SM context transfer rejected: %v", err)
+		return &httpwrapper.Response{
+			Status: http.StatusBadRequest,
+			Body: models.ProblemDetails{
+				Title:  "SM context transfer rejected",
+				Status: http.StatusBadRequest,
+				Detail: err.Error(),
+			},
+		}
+	}
+
+	if err := setUpTransferredSession(smContext); err != nil {
+		smContext.SubPduSessLog.Errorf("SM context transfer rejected: %v", err)
+		removeTransferredSession(smContext)
+		return &httpwrapper.Response{
+			Status: http.StatusServiceUnavailable,
+			Body: models.ProblemDetails{
+				Title:  "SM context transfer rejected",
+				Status: http.StatusServiceUnavailable,
+				Detail: err.Error(),
+			},
+		}
+	}
+	smContext.SubPduSessLog.Infof("SM context transfer accepted, user plane set up")
+
+	return &httpwrapper.Response{
+		Header: http.Header{
+			"Location": {smContext.Ref},
+		},
+		Status: http.StatusCreated,
+		Body: models.SmContextCreatedData{
+			PduSessionId: smContext.PDUSessionID,
+			SNssai:       smContext.Snssai,
+			UpCnxState:   smContext.UpCnxState,
+		},
+	}
+}
+
+// setUpTransferredSession accounts the adopted session in the sessions of its DNN, selects its PCF
+// and AMF and sets up its user plane
+func setUpTransferredSession(smContext *smf_context.SMContext) error {
+	if err := smContext.AcquireSessionSlot(); err != nil {
+		return fmt.Errorf("DNN[%s]: %w", smContext.Dnn, err)
+	}
+	preempted, err := smContext.AcquireSessionSlotByArp(smf_context.SessionRuleArp(smContext.SelectedSessionRule()))
+	if err != nil {
+		return fmt.Errorf("DNN[%s]: %w", smContext.Dnn, err)
+	} else if preempted != nil {
+		smContext.SubPduSessLog.Infof("DNN[%s] at its session limit, session[%s] preempted", smContext.Dnn, preempted.Ref)
+		go releasePreemptedSession(preempted)
+	}
+	if err := selectTransferredSessionNFs(smContext); err != nil {
+		return err
+	}
+	if err := EstablishTransferredUserPlane(smContext); err != nil {
+		return fmt.Errorf("user plane setup failed: %w", err)
+	}
+	return nil
+}
+
+// EstablishTransferredUserPlane sets up the user plane of the adopted session, replaced in tests
+var EstablishTransferredUserPlane = establishTransferredUserPlane
+
+// establishTransferredUserPlane activates the default data path of the adopted session and
+// establishes its PFCP sessions on the UPFs, its downlink forwarded to the AN tunnel of the session
+// kept from the old SMF. The policy of the session is committed once established, the session
+// being active.
+func establishTransferredUserPlane(smContext *smf_context.SMContext) error {
+	selection := smContext.UPFSelectionParams()
+	if err := waitForAssociatedUPF(smContext, selection); err != nil {
+		return err
+	}
+	upPath, err := selectDefaultUPPath(smContext, smf_context.GetUserPlaneInformation(), nil, selection)
+	if err != nil {
+		return err
+	}
+	var dataPath *smf_context.DataPath
+	if upPath != nil {
+		dataPath = smf_context.GenerateDataPath(upPath, smContext)
+	}
+	if dataPath == nil {
+		return fmt.Errorf("data path not found for selection param %v", selection.String())
+	}
+	dataPath.IsDefaultPath = true
+	smContext.Tunnel.AddDataPath(dataPath)
+	if err := dataPath.ActivateTunnelAndPDR(smContext, 255); err != nil {
+		return err
+	}
+	if err := establishPfcpSessions(smContext); err != nil {
+		return err
+	}
+	if err := smContext.CommitSmPolicyDecision(true); err != nil {
+		return err
+	}
+	smContext.ChangeState(smf_context.SmStateActive)
+	return nil
+}
+
+// removeTransferredSession removes the adopted session whose transfer is rejected, the PFCP
+// sessions it set up being deleted from the UPFs
+func removeTransferredSession(smContext *smf_context.SMContext) {
+	if len(smContext.PFCPContext) > 0 && releaseTunnel(smContext) {
+		if status := <-smContext.SBIPFCPCommunicationChan; status != smf_context.SessionReleaseSuccess {
+			smContext.SubPduSessLog.Warnf("PFCP sessions of the rejected transfer not released: %v", status)
+		}
+	}
+	smf_context.RemoveSMContext(smContext.Ref)
+}
+
+// selectTransferredSessionNFs selects the PCF and the AMF of the session transferred by the old SMF
+func selectTransferredSessionNFs(smContext *smf_context.SMContext) error {
+	if err := smContext.PCFSelection(); err != nil {
+		return fmt.Errorf("PCF selection failed: %w", err)
+	}
+	if problemDetails, err := consumer.SendNFDiscoveryServingAMF(smContext); err != nil {
+		return fmt.Errorf("AMF selection failed: %w", err)
+	} else if problemDetails != nil {
+		return fmt.Errorf("AMF selection failed: %s", problemDetails.Cause)
+	}
+	setCommunicationClient(smContext)
+	if smContext.CommunicationClient == nil {
+		return fmt.Errorf("AMF[%s] without Namf_Communication service", smContext.ServingNfId)
+	}
+	return nil
+}
+
+// setCommunicationClient creates the Namf_Communication client of the session for its AMF
+func setCommunicationClient(smContext *smf_context.SMContext) {
+	if smContext.AMFProfile.NfServices == nil {
+		return
+	}
+	for _, service := range *smContext.AMFProfile.NfServices {
+		if service.ServiceName == models.ServiceName_NAMF_COMM {
+			communicationConf := Namf_Communication.NewConfiguration()
+			communicationConf.SetBasePath(service.ApiPrefix)
+			smContext.SetCorrelationHeader(communicationConf)
+			smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+		}
+	}
+}
+
+// TransferSMContext transfers the session to the new SMF of an inter-SMF handover. Once the new
+// SMF adopted the session and set up its user plane, the PFCP sessions of the session are deleted from the UPFs of this SMF
+// and the session is removed, its UE IP being released. The policy association and the charging
+// of the session follow it to the new SMF.
+func TransferSMContext(smCtxRef, targetSmfUri string) error {
+	if err := consumer.TransferSessionContext(smCtxRef, targetSmfUri); err != nil {
+		return err
+	}
+	smContext := smf_context.GetSMContext(smCtxRef)
+	if smContext == nil {
+		return nil
+	}
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if releaseTunnel(smContext) {
+		if status := <-smContext.SBIPFCPCommunicationChan; status != smf_context.SessionReleaseSuccess {
+			smContext.SubPduSessLog.Warnf("TransferSMContext, PFCP sessions of the transferred session not released: %v", status)
+		}
+	}
+	smf_context.RemoveSMContext(smCtxRef)
+	return nil
+}
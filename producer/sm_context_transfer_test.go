@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/omec-project/openapi/models"
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/context/contexttest"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/qos"
+	"github.com/stretchr/testify/require"
+)
+
+// adoptTestSMContext returns the session transferred by the old SMF with its committed policy and
+// its AN tunnel, adopted by this SMF whose DNN is served by a UPF
+func adoptTestSMContext(t *testing.T) *smfContext.SMContext {
+	t.Helper()
+	enableKafka := false
+	contexttest.SetConfiguration(t, &factory.Configuration{KafkaInfo: factory.KafkaInfo{EnableKafka: &enableKafka}})
+	snssai := &models.Snssai{Sst: 1, Sd: "010203"}
+	contexttest.SetUserPlane(t, contexttest.NewUserPlane(t, "10.200.0.100", map[string]factory.UPNode{
+		"upf1": contexttest.UPF("10.200.0.1", snssai),
+	}))
+	ueIPAllocator, err := smfContext.NewIPAllocator("10.60.0.0/24")
+	require.NoError(t, err)
+	dnnInfo := &smfContext.SnssaiSmfDnnInfo{DefaultQos: contexttest.DefaultQos(), UeIPAllocator: ueIPAllocator}
+	contexttest.SetSnssaiInfos(t, []smfContext.SnssaiSmfInfo{{
+		Snssai:   smfContext.SNssai{Sst: 1, Sd: "010203"},
+		DnnInfos: map[string]*smfContext.SnssaiSmfDnnInfo{contexttest.Dnn: dnnInfo},
+	}})
+
+	source := contexttest.NewSMContext("imsi-208930000000121", 5, snssai, dnnInfo)
+	policyUpdate := qos.BuildSmPolicyUpdate(&source.SmPolicyData, fallbackSmPolicyDecision(source))
+	require.NoError(t, qos.CommitSmPolicyDecision(&source.SmPolicyData, policyUpdate))
+	source.AnType = models.AccessType__3_GPP_ACCESS
+	source.PDUAddress = &smfContext.UeIpAddr{Ip: net.ParseIP("10.60.0.7").To4()}
+	source.Tunnel = smfContext.NewUPTunnel()
+	source.Tunnel.ANInformation.IPAddress = net.ParseIP("10.1.0.2")
+	source.Tunnel.ANInformation.TEID = 7
+	body, err := json.Marshal(source)
+	require.NoError(t, err)
+	smfContext.RemoveSMContext(source.Ref)
+
+	smContext := &smfContext.SMContext{}
+	require.NoError(t, json.Unmarshal(body, smContext))
+	require.NoError(t, smfContext.AdoptTransferredSMContext(smContext))
+	t.Cleanup(func() {
+		if smfContext.GetSMContext(smContext.Ref) != nil {
+			smfContext.RemoveSMContext(smContext.Ref)
+		}
+	})
+	return smContext
+}
+
+func TestEstablishTransferredUserPlane(t *testing.T) {
+	smContext := adoptTestSMContext(t)
+	calls := stubUPF(t, smContext, smfContext.SessionEstablishSuccess)
+
+	require.NoError(t, establishTransferredUserPlane(smContext))
+	require.Equal(t, 1, *calls)
+
+	// the PFCP session set up on the UPF of this SMF counts in its sessions
+	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	require.NotNil(t, defaultPath)
+	require.True(t, defaultPath.Activated)
+	upf := defaultPath.FirstDPNode.UPF
+	require.Contains(t, smContext.PFCPContext, upf.NodeID.ResolveNodeIdToIp().String())
+	require.Equal(t, int64(1), upf.SessionCount())
+
+	// the downlink is forwarded to the AN tunnel kept from the old SMF
+	dlFAR := defaultPath.FirstDPNode.DownLinkTunnel.PDR["default"].FAR
+	require.NotNil(t, dlFAR.ForwardingParameters)
+	require.Equal(t, uint32(7), dlFAR.ForwardingParameters.OuterHeaderCreation.Teid)
+	require.True(t, net.ParseIP("10.1.0.2").Equal(dlFAR.ForwardingParameters.OuterHeaderCreation.Ipv4Address))
+
+	// the policy installed on the UPF is committed again
+	require.Empty(t, smContext.SmPolicyUpdates)
+	require.NotNil(t, smContext.SmPolicyData.SmCtxtSessionRules.ActiveRule)
+	require.Equal(t, smfContext.SmStateActive, smContext.SMContextState)
+
+	smfContext.RemoveSMContext(smContext.Ref)
+	require.Equal(t, int64(0), upf.SessionCount())
+}
+
+func TestEstablishTransferredUserPlaneFailed(t *testing.T) {
+	smContext := adoptTestSMContext(t)
+	stubUPF(t, smContext, smfContext.SessionEstablishFailed)
+
+	require.Error(t, establishTransferredUserPlane(smContext))
+	require.NotEmpty(t, smContext.SmPolicyUpdates, "policy not committed")
+}
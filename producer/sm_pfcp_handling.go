@@ -45,6 +45,8 @@ func SendPfcpSessionReleaseReq(smContext *smf_context.SMContext) error {
 	releaseTunnel(smContext)
 
 	PFCPResponseStatus := <-smContext.SBIPFCPCommunicationChan
+	// the session is torn down whatever the UPF answered, charging is closed with the usage reported
+	closeCharging(smContext)
 	switch PFCPResponseStatus {
 	case smf_context.SessionReleaseSuccess:
 		smContext.SubCtxLog.Debugln("PDUSessionSMContextUpdate, PFCP Session Release Success")
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	smf_context "github.com/omec-project/smf/context"
+	pfcp_message "github.com/omec-project/smf/pfcp/message"
+)
+
+// sendPfcpTSCManagement sends the port management of the NW-TT to the UPF, replaced in tests
+var sendPfcpTSCManagement = pfcp_message.SendPfcpSessionTSCManagementRequest
+
+// configureTSNBridge commands the TSN configuration of the DNN on the NW-TT port of the bridge
+// the UPF created for the session, once the PFCP session is established. The session is kept
+// when the UPF does not apply it, the bridge then runs with the NW-TT defaults.
+func configureTSNBridge(smContext *smf_context.SMContext) {
+	bridge := smContext.TSNBridge
+	if bridge == nil {
+		return
+	}
+	if bridge.NWTTPortNumber == 0 {
+		smContext.SubPduSessLog.Warnf("TSN bridge [%s] without NW-TT port, port management not sent", bridge.BridgeID)
+		return
+	}
+	portManagement, err := smf_context.GetTSNPortManagement(smContext)
+	if err != nil {
+		smContext.SubPduSessLog.Errorf("TSN port management: %v", err)
+		return
+	}
+	upf := smf_context.RetrieveUPFNodeByNodeID(bridge.UPFNodeID)
+	if upf == nil {
+		smContext.SubPduSessLog.Errorf("TSN port management: UPF[%s] not found", bridge.UPFNodeID.ResolveNodeIdToIp())
+		return
+	}
+	if err := sendPfcpTSCManagement(bridge.UPFNodeID, smContext, upf.Port, portManagement, bridge.NWTTPortNumber); err != nil {
+		smContext.SubPduSessLog.Errorf("send TSN port management failed: %v", err)
+		return
+	}
+	if status := <-smContext.SBIPFCPCommunicationChan; status != smf_context.SessionUpdateSuccess {
+		smContext.SubPduSessLog.Warnf("TSN port management of NW-TT port %d not applied: %v", bridge.NWTTPortNumber, status)
+	}
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"fmt"
+	"time"
+
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/smferrors"
+)
+
+// hasAssociatedUPF reports whether a UPF serving the selection is associated, replaced in tests
+var hasAssociatedUPF = func(selection *smf_context.UPFSelectionParams) bool {
+	return smf_context.GetUserPlaneInformation().HasAssociatedUPF(selection)
+}
+
+// MaxUPFUnavailableBackoff caps the backoff between the checks of the associated UPFs
+var MaxUPFUnavailableBackoff = time.Second
+
+// MaxUPFUnavailableWait caps the total wait for an associated UPF. The establishment is handled
+// under the lock of the session, the wait must end well before the AMF request times out.
+var MaxUPFUnavailableWait = 5 * time.Second
+
+// waitForAssociatedUPF applies the policy of the DNN while none of its UPFs is associated. With
+// reject the establishment fails at once, with retry-with-backoff the UPFs are checked again
+// after a backoff doubled on each retry, until the attempts of the policy are exhausted or the
+// establishment waited MaxUPFUnavailableWait.
+func waitForAssociatedUPF(smContext *smf_context.SMContext, selection *smf_context.UPFSelectionParams) error {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.UPFUnavailable == nil {
+		return nil
+	}
+	policy := smContext.DNNInfo.UPFUnavailable
+	backoff := time.Duration(policy.BackoffMs) * time.Millisecond
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		if hasAssociatedUPF(selection) {
+			return nil
+		}
+		if policy.Policy != factory.UPFUnavailableRetryWithBackoff || attempt >= policy.MaxAttempts ||
+			waited >= MaxUPFUnavailableWait {
+			return smferrors.New(smferrors.ErrCodeUPFNotAssociated,
+				fmt.Sprintf("no UPF associated for selection param %v after %d attempts in %v",
+					selection.String(), attempt, waited))
+		}
+		delay := min(backoff, MaxUPFUnavailableBackoff, MaxUPFUnavailableWait-waited)
+		smContext.SubPduSessLog.Warnf("no UPF associated for selection param %v, attempt %d/%d, retrying in %v",
+			selection.String(), attempt, policy.MaxAttempts, delay)
+		time.Sleep(delay)
+		waited += delay
+		backoff *= 2
+	}
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package producer
+
+import (
+	"testing"
+	"time"
+
+	smfContext "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+// stubAssociatedUPF answers the checks of the associated UPFs with the results in order
+func stubAssociatedUPF(t *testing.T, results ...bool) *int {
+	t.Helper()
+	origHasAssociatedUPF := hasAssociatedUPF
+	t.Cleanup(func() { hasAssociatedUPF = origHasAssociatedUPF })
+
+	calls := 0
+	hasAssociatedUPF = func(*smfContext.UPFSelectionParams) bool {
+		require.Less(t, calls, len(results), "unexpected check of the associated UPFs")
+		calls++
+		return results[calls-1]
+	}
+	return &calls
+}
+
+func newUPFUnavailableSMContext(policy *factory.UPFUnavailableConfig) *smfContext.SMContext {
+	smContext := smfContext.NewSMContext("imsi-208930000000111", 11)
+	smContext.DNNInfo = &smfContext.SnssaiSmfDnnInfo{UPFUnavailable: policy}
+	return smContext
+}
+
+var upfUnavailableSelection = &smfContext.UPFSelectionParams{
+	Dnn:    "internet",
+	SNssai: &smfContext.SNssai{Sst: 1, Sd: "010203"},
+}
+
+func TestWaitForAssociatedUPFReject(t *testing.T) {
+	smContext := newUPFUnavailableSMContext(&factory.UPFUnavailableConfig{Policy: factory.UPFUnavailableReject})
+
+	calls := stubAssociatedUPF(t, false)
+	require.Error(t, waitForAssociatedUPF(smContext, upfUnavailableSelection))
+	require.Equal(t, 1, *calls, "rejected without retry")
+
+	calls = stubAssociatedUPF(t, true)
+	require.NoError(t, waitForAssociatedUPF(smContext, upfUnavailableSelection))
+	require.Equal(t, 1, *calls)
+}
+
+func TestWaitForAssociatedUPFRetryWithBackoff(t *testing.T) {
+	smContext := newUPFUnavailableSMContext(&factory.UPFUnavailableConfig{
+		Policy:      factory.UPFUnavailableRetryWithBackoff,
+		MaxAttempts: 3,
+		BackoffMs:   1,
+	})
+
+	// a UPF associates during the retries
+	calls := stubAssociatedUPF(t, false, false, true)
+	require.NoError(t, waitForAssociatedUPF(smContext, upfUnavailableSelection))
+	require.Equal(t, 3, *calls)
+
+	// the attempts are bounded, then the establishment is rejected
+	calls = stubAssociatedUPF(t, false, false, false)
+	require.Error(t, waitForAssociatedUPF(smContext, upfUnavailableSelection))
+	require.Equal(t, 3, *calls)
+}
+
+func TestWaitForAssociatedUPFBoundedWait(t *testing.T) {
+	origMaxBackoff, origMaxWait := MaxUPFUnavailableBackoff, MaxUPFUnavailableWait
+	t.Cleanup(func() { MaxUPFUnavailableBackoff, MaxUPFUnavailableWait = origMaxBackoff, origMaxWait })
+	MaxUPFUnavailableBackoff, MaxUPFUnavailableWait = 20*time.Millisecond, 30*time.Millisecond
+
+	smContext := newUPFUnavailableSMContext(&factory.UPFUnavailableConfig{
+		Policy:      factory.UPFUnavailableRetryWithBackoff,
+		MaxAttempts: 10,
+		BackoffMs:   60000,
+	})
+
+	// the backoffs are capped to 20ms then to the 10ms left, rejected once the wait is over
+	calls := stubAssociatedUPF(t, false, false, false)
+	start := time.Now()
+	require.Error(t, waitForAssociatedUPF(smContext, upfUnavailableSelection))
+	require.Equal(t, 3, *calls)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestWaitForAssociatedUPFNoPolicy(t *testing.T) {
+	smContext := newUPFUnavailableSMContext(nil)
+
+	calls := stubAssociatedUPF(t)
+	require.NoError(t, waitForAssociatedUPF(smContext, upfUnavailableSelection))
+	require.Zero(t, *calls, "UPFs not checked without policy")
+}
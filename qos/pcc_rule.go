@@ -6,6 +6,10 @@
 package qos
 
 import (
+	"cmp"
+	"slices"
+	"strings"
+
 	"github.com/omec-project/openapi/models"
 )
 
@@ -73,3 +77,117 @@ func GetPccRuleChanges(s, d *models.PccRule) bool {
 func (upd *PccRulesUpdate) GetAddPccRuleUpdate() map[string]*models.PccRule {
 	return upd.add
 }
+
+// GetDefaultPccRuleName returns the name of the PCC rule provided by the PCF for the default
+// QoS flow and matching all the traffic of the session, or "" if the PCF provided none.
+// Among several candidates the rule with the lowest priority, highest precedence value, wins.
+func GetDefaultPccRuleName(smPolicyDecision *models.SmPolicyDecision, pccRules map[string]*models.PccRule) string {
+	defaultName := ""
+	if smPolicyDecision == nil {
+		return defaultName
+	}
+	for name, rule := range pccRules {
+		if rule == nil || len(rule.RefQosData) == 0 || len(rule.FlowInfos) == 0 {
+			continue
+		}
+		qosData := GetQoSDataFromPolicyDecision(smPolicyDecision, rule.RefQosData[0])
+		if qosData == nil || !qosData.DefQosFlowIndication {
+			continue
+		}
+		matchAll := true
+		for _, flow := range rule.FlowInfos {
+			if !IsMatchAllFlowDescription(flow.FlowDescription) {
+				matchAll = false
+				break
+			}
+		}
+		if !matchAll {
+			continue
+		}
+		if defaultName == "" || rule.Precedence > pccRules[defaultName].Precedence ||
+			(rule.Precedence == pccRules[defaultName].Precedence && name < defaultName) {
+			defaultName = name
+		}
+	}
+	return defaultName
+}
+
+// PccRulesAfterUpdate returns the PCC rules of the session once the update is committed
+func PccRulesAfterUpdate(ctxtPccRules map[string]*models.PccRule, update *PccRulesUpdate) map[string]*models.PccRule {
+	rules := make(map[string]*models.PccRule, len(ctxtPccRules))
+	for name, rule := range ctxtPccRules {
+		rules[name] = rule
+	}
+	if update == nil {
+		return rules
+	}
+	for name, rule := range update.add {
+		rules[name] = rule
+	}
+	for name := range update.del {
+		delete(rules, name)
+	}
+	return rules
+}
+
+// pccRuleQosFlow returns the QoS data of the QoS flow the PCC rule is bound to, empty for the
+// default QoS flow
+func pccRuleQosFlow(rule *models.PccRule) string {
+	if len(rule.RefQosData) == 0 {
+		return ""
+	}
+	return rule.RefQosData[0]
+}
+
+// LimitPccRules keeps the PCC rules of the session, the ones installed and the ones of the policy
+// decision, within at most maxFlows QoS flows, the PCC rules sharing their QoS data sharing their
+// QoS flow. The installed rules are kept, then the rules of the decision in priority order: the
+// default PCC rule and then the ones of the lowest precedence value. The rules of the decision
+// needing an additional QoS flow are removed from it and their IDs returned. The rules are not
+// limited if maxFlows is 0.
+func LimitPccRules(ctxtPccRules map[string]*models.PccRule, smPolicyDecision *models.SmPolicyDecision, maxFlows int) []string {
+	if smPolicyDecision == nil || maxFlows <= 0 {
+		return nil
+	}
+
+	// QoS flows of the installed rules neither removed nor modified by the decision
+	flows := make(map[string]bool)
+	for name, rule := range ctxtPccRules {
+		if _, updated := smPolicyDecision.PccRules[name]; rule != nil && !updated {
+			flows[pccRuleQosFlow(rule)] = true
+		}
+	}
+
+	names := make([]string, 0, len(smPolicyDecision.PccRules))
+	for name, rule := range smPolicyDecision.PccRules {
+		if rule != nil {
+			names = append(names, name)
+		}
+	}
+	defaultName := GetDefaultPccRuleName(smPolicyDecision, smPolicyDecision.PccRules)
+	slices.SortFunc(names, func(a, b string) int {
+		switch {
+		case a == defaultName:
+			return -1
+		case b == defaultName:
+			return 1
+		}
+		ruleA, ruleB := smPolicyDecision.PccRules[a], smPolicyDecision.PccRules[b]
+		if ruleA.Precedence != ruleB.Precedence {
+			return cmp.Compare(ruleA.Precedence, ruleB.Precedence)
+		}
+		return strings.Compare(a, b)
+	})
+
+	var rejected []string
+	for _, name := range names {
+		flow := pccRuleQosFlow(smPolicyDecision.PccRules[name])
+		if flows[flow] || len(flows) < maxFlows {
+			flows[flow] = true
+			continue
+		}
+		rejected = append(rejected, smPolicyDecision.PccRules[name].PccRuleId)
+		delete(smPolicyDecision.PccRules, name)
+	}
+	return rejected
+}
@@ -283,6 +283,15 @@ func DecodeFlowDescToIPFilters(flowDesc string) *IPFilterRule {
 	return ipfRule
 }
 
+// IsMatchAllFlowDescription returns true if the flow description matches all the traffic of the UE
+func IsMatchAllFlowDescription(flowDesc string) bool {
+	// action, direction, protocol, "from", source, "to", destination at least
+	if len(strings.Fields(flowDesc)) < 7 {
+		return false
+	}
+	return DecodeFlowDescToIPFilters(flowDesc).IsMatchAllIPFilter()
+}
+
 func (ipf *IPFilterRule) IsMatchAllIPFilter() bool {
 	if ipf.sAddrv4.addr == "any" && ipf.dAddrv4.addr == "assigned" {
 		return true
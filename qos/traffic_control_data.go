@@ -51,7 +51,11 @@ func CommitTrafficControlUpdate(smCtxtPolData *SmCtxtPolicyData, update *Traffic
 	}
 
 	// Mod rules
-	// TODO
+	if len(update.mod) > 0 {
+		for name, tc := range update.mod {
+			smCtxtPolData.SmCtxtTCData.TrafficControlData[name] = tc
+		}
+	}
 
 	// Del Rules
 	if len(update.del) > 0 {
@@ -61,9 +65,20 @@ func CommitTrafficControlUpdate(smCtxtPolData *SmCtxtPolicyData, update *Traffic
 	}
 }
 
+// GetTCDataChanges returns true if the redirection of the traffic control data changed,
+// the other attributes are not compared
 func GetTCDataChanges(pcfTc, ctxtTc *models.TrafficControlData) bool {
-	// TODO
-	return false
+	if pcfTc.RedirectInfo == nil || ctxtTc.RedirectInfo == nil {
+		return (pcfTc.RedirectInfo == nil) != (ctxtTc.RedirectInfo == nil)
+	}
+	return *pcfTc.RedirectInfo != *ctxtTc.RedirectInfo
+}
+
+func (upd *TrafficControlUpdate) GetModTrafficControlUpdate() map[string]*models.TrafficControlData {
+	if upd == nil {
+		return nil
+	}
+	return upd.mod
 }
 
 func GetTcDataFromPolicyDecision(smPolicyDecision *models.SmPolicyDecision, refTcData string) *models.TrafficControlData {
@@ -7,6 +7,7 @@
 package service
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof" // Using package only for invoking initialization.
@@ -31,14 +32,20 @@ import (
 	"github.com/omec-project/smf/context"
 	"github.com/omec-project/smf/eventexposure"
 	"github.com/omec-project/smf/factory"
+	"github.com/omec-project/smf/health"
 	"github.com/omec-project/smf/logger"
 	"github.com/omec-project/smf/metrics"
+	"github.com/omec-project/smf/nchf"
+	"github.com/omec-project/smf/nnssaaf"
+	"github.com/omec-project/smf/nnwdaf"
 	"github.com/omec-project/smf/oam"
 	"github.com/omec-project/smf/pdusession"
 	"github.com/omec-project/smf/pfcp"
 	"github.com/omec-project/smf/pfcp/message"
 	"github.com/omec-project/smf/pfcp/udp"
 	"github.com/omec-project/smf/pfcp/upf"
+	"github.com/omec-project/smf/producer"
+	"github.com/omec-project/smf/util"
 	"github.com/omec-project/util/http2_util"
 	utilLogger "github.com/omec-project/util/logger"
 	"github.com/urfave/cli/v3"
@@ -85,6 +92,16 @@ type OneInstance struct {
 
 var nrfRegInProgress OneInstance
 
+// registration to the NRF deferred at the startup until the UPFs are associated
+var nrfRegistrationDeferred atomic.Bool
+
+// time the registration to the NRF is deferred at most at the startup, and the interval the
+// associations of the UPFs are checked meanwhile
+const (
+	defaultStartupUpfTimeout = 60 * time.Second
+	startupUpfCheckInterval  = time.Second
+)
+
 func init() {
 	nrfRegInProgress = OneInstance{}
 }
@@ -138,6 +155,11 @@ func (smf *SMF) Initialize(c *cli.Command) error {
 		}()
 	}
 
+	// liveness and readiness probes
+	if factory.SmfConfig.Configuration.HealthProbePort != 0 {
+		go health.Run(factory.SmfConfig.Configuration.HealthProbePort)
+	}
+
 	if os.Getenv("MANAGED_BY_CONFIG_POD") == "true" {
 		logger.InitLog.Infoln("MANAGED_BY_CONFIG_POD is true")
 		go manageGrpcClient(factory.SmfConfig.Configuration.WebuiUri)
@@ -181,6 +203,9 @@ func manageGrpcClient(webuiUri string) {
 			if configChannel == nil {
 				configChannel = client.PublishOnConfigChange(true, stream)
 				logger.InitLog.Infoln("PublishOnConfigChange is triggered")
+				factory.OnConfigParseError = func(err error) {
+					metrics.IncrementConfigUpdateErrors(metrics.ConfigUpdateParseError)
+				}
 				go factory.SmfConfig.UpdateConfig(configChannel)
 				logger.InitLog.Infoln("SMF updateConfig is triggered")
 			}
@@ -316,6 +341,15 @@ func (smf *SMF) FilterCli(c *cli.Command) (args []string) {
 func (smf *SMF) Start() {
 	logger.InitLog.Infoln("SMF app initialising")
 
+	startup := factory.SmfConfig.Configuration.Startup
+	waitForUpfs := startup != nil && startup.WaitForUpfs
+	nrfRegistrationDeferred.Store(waitForUpfs)
+
+	// TLS versions and cipher suites of the SBI requests, before the first one
+	if err := util.SetSbiTLSPolicy(sbiTLSPolicy()); err != nil {
+		logger.InitLog.Fatalf("SBI TLS policy: %v", err)
+	}
+
 	// Initialise channel to stop SMF
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
@@ -338,6 +372,27 @@ func (smf *SMF) Start() {
 	// Init UE Specific Config
 	context.InitSMFUERouting(&factory.UERoutingConfig)
 
+	// user plane removals release the associations and sessions, set before any config update
+	context.ReleaseSessionByNetwork = producer.ReleaseSessionByNetwork
+	context.ReleaseUPFAssociation = message.SendPfcpAssociationReleaseRequest
+
+	// Reload the config file on SIGHUP, alongside the config pod updates
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	go func() {
+		for range reloadChannel {
+			logger.InitLog.Infoln("SIGHUP received, reloading config")
+			sendNrfRegistration, err := context.ReloadConfig()
+			if err != nil {
+				logger.InitLog.Errorln(err)
+				continue
+			}
+			if sendNrfRegistration {
+				go smf.SendNrfRegistration()
+			}
+		}
+	}()
+
 	// Wait for additional/updated config from config pod
 	if os.Getenv("MANAGED_BY_CONFIG_POD") == "true" {
 		logger.InitLog.Infof("configuration is managed by Config Pod")
@@ -345,20 +400,24 @@ func (smf *SMF) Start() {
 
 		// Main thread should be blocked for config update from ROC
 		// Future config update from ROC can be handled via background go-routine.
-		if <-factory.ConfigPodTrigger {
-			logger.InitLog.Infoln("minimum configuration from config pod available")
-			context.ProcessConfigUpdate()
+		cfg := <-factory.ConfigPodTrigger
+		logger.InitLog.Infoln("minimum configuration from config pod available")
+		if _, err := context.UpdateSmfContext(cfg); err != nil {
+			logger.InitLog.Errorf("initial config update failed: %v", err)
 		}
 
 		// Trigger background goroutine to handle further config updates
 		go func() {
 			logger.InitLog.Infoln("dynamic config update task initialised")
-			for {
-				if <-factory.ConfigPodTrigger {
-					if context.ProcessConfigUpdate() {
-						// Let NRF registration happen in background
-						go smf.SendNrfRegistration()
-					}
+			for cfg := range factory.ConfigPodTrigger {
+				sendNrfRegistration, err := context.UpdateSmfContext(cfg)
+				if err != nil {
+					logger.InitLog.Errorf("config update failed: %v", err)
+					continue
+				}
+				if sendNrfRegistration {
+					// Let NRF registration happen in background
+					go smf.SendNrfRegistration()
 				}
 			}
 		}()
@@ -366,14 +425,53 @@ func (smf *SMF) Start() {
 		logger.InitLog.Infoln("configuration is managed by Helm")
 	}
 
-	// Send NRF Registration
-	smf.SendNrfRegistration()
+	// Send NRF Registration, once the UPFs are associated if deferred
+	if !waitForUpfs {
+		smf.SendNrfRegistration()
+	}
 
 	if smfCtxt.EnableNrfCaching {
 		logger.InitLog.Infof("enable NRF caching feature for %d seconds", smfCtxt.NrfCacheEvictionInterval)
 		nrfCache.InitNrfCaching(smfCtxt.NrfCacheEvictionInterval*time.Second, consumer.SendNrfForNfInstance)
 	}
 
+	consumer.InitCDRWriter(factory.SmfConfig.Configuration.CDR)
+
+	if auditConfig := factory.SmfConfig.Configuration.AuditLog; auditConfig != nil {
+		if err := logger.InitAuditLog(auditConfig.File); err != nil {
+			logger.InitLog.Fatalf("audit log: %v", err)
+		}
+	}
+
+	// network slice-specific authentication of the UEs
+	nnssaaf.InitNSSAAFClient(factory.SmfConfig.Configuration.Nssaaf)
+
+	// converged charging of the sessions
+	nchf.InitCHFClient(factory.SmfConfig.Configuration.Chf)
+
+	// congestion of the UPFs predicted by the NWDAF
+	if nwdafClient := nnwdaf.InitNWDAFClient(factory.SmfConfig.Configuration.Nwdaf,
+		smfCtxt.SBIUri()+"/nsmf-callback/nwdaf-notify"); nwdafClient != nil {
+		go func() {
+			err := nwdafClient.SubscribeAnalytics(nnwdaf.AnalyticsNfLoad, nnwdaf.AnalyticsFilter{
+				NfTypes: []models.NfType{models.NfType_UPF},
+			})
+			if err != nil {
+				logger.InitLog.Errorf("NWDAF subscription failed, UPF congestion not predicted: %v", err)
+			}
+		}()
+	}
+
+	// congestion of the UPFs detected from the intervals of their session reports
+	if detector := context.GetCongestionDetector(); detector != nil {
+		go func() {
+			for alert := range detector.Alerts() {
+				logger.InitLog.Warnf("UPF[%s] congestion detected, session reports %.1f times more often than the threshold",
+					alert.UPFName, alert.Level)
+			}
+		}()
+	}
+
 	router := utilLogger.NewGinWithZap(logger.GinLog)
 	oam.AddService(router)
 	callback.AddService(router)
@@ -393,6 +491,8 @@ func (smf *SMF) Start() {
 		if err := smfCtxt.InitDrsm(); err != nil {
 			logger.InitLog.Errorf("initialise drsm failed, %v ", err.Error())
 		}
+		// gNB TEIDs of the sessions kept across the restart
+		context.GetUserPlaneInformation().TEIDPool.LoadFromDB()
 	} else {
 		logger.InitLog.Infoln("DB is disabled, not initialising drsm")
 	}
@@ -403,6 +503,8 @@ func (smf *SMF) Start() {
 	}
 
 	udp.Run(pfcp.Dispatch)
+	// evict the PFCP requests left without response
+	go message.SweepPfcpTxns()
 
 	for _, upf := range context.SMF_Self().UserPlaneInformation.UPFs {
 		if upf.NodeID.NodeIdType == context.NodeIdTypeFqdn {
@@ -414,9 +516,14 @@ func (smf *SMF) Start() {
 		err := message.SendPfcpAssociationSetupRequest(upf.NodeID, upf.Port)
 		if err != nil {
 			logger.AppLog.Errorf("send PFCP Association Request failed: %v", err)
+			upf.UPF.RecordError(err)
 		}
 	}
 
+	if waitForUpfs {
+		go smf.sendNrfRegistrationWhenUPFsReady(startup)
+	}
+
 	// Trigger PFCP Heartbeat towards all connected UPFs
 	go upf.InitPfcpHeartbeatRequest(context.SMF_Self().UserPlaneInformation)
 
@@ -438,6 +545,16 @@ func (smf *SMF) Start() {
 		logger.InitLog.Warnln("initialize HTTP server:", err)
 	}
 
+	if policy := sbiTLSPolicy(); policy != nil {
+		if server.TLSConfig == nil {
+			server.TLSConfig = &tls.Config{}
+		}
+		if err = policy.Apply(server.TLSConfig); err != nil {
+			logger.InitLog.Fatalf("HTTP server setup failed: SBI TLS policy: %v", err)
+			return
+		}
+	}
+
 	serverScheme := factory.SmfConfig.Configuration.Sbi.Scheme
 	switch serverScheme {
 	case "http":
@@ -454,8 +571,19 @@ func (smf *SMF) Start() {
 	}
 }
 
+// sbiTLSPolicy returns the TLS policy of the SBI server and clients, nil if not configured
+func sbiTLSPolicy() *factory.TLSPolicy {
+	sbi := factory.SmfConfig.Configuration.Sbi
+	if sbi == nil || sbi.TLS == nil {
+		return nil
+	}
+	return sbi.TLS.Policy
+}
+
 func (smf *SMF) Terminate() {
 	logger.InitLog.Infoln("terminating SMF")
+	consumer.CloseCDRWriter()
+	logger.CloseAuditLog()
 	// deregister with NRF
 	problemDetails, err := consumer.SendDeregisterNFInstance()
 	if problemDetails != nil {
@@ -537,7 +665,32 @@ func UpdateNF() {
 	KeepAliveTimer = time.AfterFunc(time.Duration(heartBeatTimer)*time.Second, UpdateNF)
 }
 
+// sendNrfRegistrationWhenUPFsReady registers the SMF to the NRF once a UPF is associated for each
+// DNN, or after the timeout of the startup
+func (smf *SMF) sendNrfRegistrationWhenUPFsReady(startup *factory.StartupConfig) {
+	timeout := defaultStartupUpfTimeout
+	if startup.UpfTimeoutSec > 0 {
+		timeout = time.Duration(startup.UpfTimeoutSec) * time.Second
+	}
+	logger.InitLog.Infof("NRF registration deferred until a UPF is associated for each DNN, at most %v", timeout)
+	if notReady := health.AwaitUPFsPerDNN(timeout, startupUpfCheckInterval); len(notReady) > 0 {
+		for _, component := range notReady {
+			logger.InitLog.Warnf("registering to the NRF after %v: %s", timeout, component.Reason)
+		}
+	} else {
+		logger.InitLog.Infoln("UPFs associated for each DNN, registering to the NRF")
+	}
+	nrfRegistrationDeferred.Store(false)
+	smf.SendNrfRegistration()
+}
+
 func (smf *SMF) SendNrfRegistration() {
+	// registered with the latest profile once the UPFs are associated
+	if nrfRegistrationDeferred.Load() {
+		logger.InitLog.Infoln("NRF Registration deferred until the UPFs are associated")
+		return
+	}
+
 	// If NRF registration is ongoing then don't start another in parallel
 	// Just mark it so that once ongoing finishes then resend another
 	if nrfRegInProgress.intanceRun(consumer.ReSendNFRegistration) {
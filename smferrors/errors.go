@@ -46,6 +46,20 @@ var (
 		Cause:         "INSUFFICIENT_RESOURCES",
 		InvalidParams: nil,
 	}
+	MaxConcurrentSessionsReached = models.ProblemDetails{
+		Title:         "Maximum Concurrent Sessions Reached",
+		Status:        http.StatusInternalServerError,
+		Detail:        "The request cannot be provided as the DNN is at its maximum of concurrent sessions.",
+		Cause:         "INSUFFICIENT_RESOURCES",
+		InvalidParams: nil,
+	}
+	SessionRuleLimitExceeded = models.ProblemDetails{
+		Title:         "Session Rule Limit Exceeded",
+		Status:        http.StatusInternalServerError,
+		Detail:        "The request cannot be provided as the policy of the session needs more PFCP rules than allowed per session.",
+		Cause:         "INSUFFICIENT_RESOURCES",
+		InvalidParams: nil,
+	}
 	SubscriptionDataFetchError = models.ProblemDetails{
 		Title:         "Subscription Data Fetch error",
 		Status:        http.StatusInternalServerError,
@@ -74,6 +88,13 @@ var (
 		Cause:         "REQUEST_REJECTED",
 		InvalidParams: nil,
 	}
+	UPFUnavailable = models.ProblemDetails{
+		Title:         "UPF Unavailable",
+		Status:        http.StatusServiceUnavailable,
+		Detail:        "The request cannot be provided due to no UPF available for the session.",
+		Cause:         "UPF_NOT_RESPONDING",
+		InvalidParams: nil,
+	}
 	PCFDiscoveryFailure = models.ProblemDetails{
 		Title:         "PCF Discovery Failure",
 		Status:        http.StatusInternalServerError,
@@ -102,6 +123,13 @@ var (
 		Cause:         "REQUEST_REJECTED",
 		InvalidParams: nil,
 	}
+	ServiceAreaRestricted = models.ProblemDetails{
+		Title:         "Service Area Restricted",
+		Status:        http.StatusForbidden,
+		Detail:        "The UE is outside the service area allowed by its access and mobility policy.",
+		Cause:         "REQUEST_REJECTED",
+		InvalidParams: nil,
+	}
 	PduSessionTypeNotSupported = models.ProblemDetails{
 		Title:         "PduSession Type Not Supported",
 		Status:        http.StatusForbidden,
@@ -109,6 +137,13 @@ var (
 		Cause:         "REQUEST_REJECTED",
 		InvalidParams: nil,
 	}
+	PduSessionTypeNotAllowed = models.ProblemDetails{
+		Title:         "PduSession Type Not Allowed",
+		Status:        http.StatusForbidden,
+		Detail:        "The PDU session type, IP or Ethernet, is not carried by the DNN.",
+		Cause:         "REQUEST_REJECTED",
+		InvalidParams: nil,
+	}
 )
 
 var ErrorType = map[string]*models.ProblemDetails{
@@ -120,11 +155,16 @@ var ErrorType = map[string]*models.ProblemDetails{
 	"SubscriptionDataLenError":      &SubscriptionDataLenError,
 	"UDMDiscoveryFailure":           &UDMDiscoveryFailure,
 	"UPFDataPathError":              &UPFDataPathError,
+	"UPFUnavailable":                &UPFUnavailable,
 	"PCFDiscoveryFailure":           &PCFDiscoveryFailure,
 	"PCFPolicyCreateFailure":        &PCFPolicyCreateFailure,
 	"ApplySMPolicyFailure":          &ApplySMPolicyFailure,
 	"AMFDiscoveryFailure":           &AMFDiscoveryFailure,
 	"PDUSessionTypeIPv4OnlyAllowed": &PduSessionTypeNotSupported,
+	"PDUSessionTypeNotAllowed":      &PduSessionTypeNotAllowed,
+	"ServiceAreaRestricted":         &ServiceAreaRestricted,
+	"MaxConcurrentSessionsReached":  &MaxConcurrentSessionsReached,
+	"SessionRuleLimitExceeded":      &SessionRuleLimitExceeded,
 }
 
 var ErrorCause = map[string]uint8{
@@ -136,10 +176,15 @@ var ErrorCause = map[string]uint8{
 	"SubscriptionDataLenError":      nasMessage.Cause5GSMRequestRejectedUnspecified,
 	"UDMDiscoveryFailure":           nasMessage.Cause5GSMRequestRejectedUnspecified,
 	"UPFDataPathError":              nasMessage.Cause5GSMRequestRejectedUnspecified,
+	"UPFUnavailable":                nasMessage.Cause5GSMInsufficientResources,
 	"PCFDiscoveryFailure":           nasMessage.Cause5GSMRequestRejectedUnspecified,
 	"PCFPolicyCreateFailure":        nasMessage.Cause5GSMRequestRejectedUnspecified,
 	"ApplySMPolicyFailure":          nasMessage.Cause5GSMRequestRejectedUnspecified,
 	"AMFDiscoveryFailure":           nasMessage.Cause5GSMRequestRejectedUnspecified,
 	"PDUSessionTypeIPv4OnlyAllowed": nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
+	"PDUSessionTypeNotAllowed":      nasMessage.Cause5GSMUnknownPDUSessionType,
 	"InvalidPDUSessionIdentity":     nasMessage.Cause5GSMInvalidPDUSessionIdentity,
+	"ServiceAreaRestricted":         nasMessage.Cause5GSMRequestRejectedUnspecified,
+	"MaxConcurrentSessionsReached":  nasMessage.Cause5GSMInsufficientResources,
+	"SessionRuleLimitExceeded":      nasMessage.Cause5GSMInsufficientResources,
 }
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package smferrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SMFErrorCode identifies a failure mode of the SMF
+type SMFErrorCode string
+
+// Failure modes of the SMF
+const (
+	ErrCodeN1MessageInvalid         SMFErrorCode = "N1_MESSAGE_INVALID"
+	ErrCodeDnnNotSupported          SMFErrorCode = "DNN_NOT_SUPPORTED"
+	ErrCodeSliceAuthFailed          SMFErrorCode = "SLICE_AUTHENTICATION_FAILED"
+	ErrCodeSessionAuthFailed        SMFErrorCode = "SESSION_AUTHENTICATION_FAILED"
+	ErrCodeSessionQueueFull         SMFErrorCode = "SESSION_QUEUE_FULL"
+	ErrCodeSessionQueueTimeout      SMFErrorCode = "SESSION_QUEUE_TIMEOUT"
+	ErrCodeSessionRuleLimit         SMFErrorCode = "SESSION_RULE_LIMIT"
+	ErrCodeUDMDiscoveryFailure      SMFErrorCode = "UDM_DISCOVERY_FAILURE"
+	ErrCodeSubscriptionDataFailure  SMFErrorCode = "SUBSCRIPTION_DATA_FAILURE"
+	ErrCodeIPPoolExhausted          SMFErrorCode = "IP_POOL_EXHAUSTED"
+	ErrCodeTEIDPoolExhausted        SMFErrorCode = "TEID_POOL_EXHAUSTED"
+	ErrCodePDUSessionTypeNotAllowed SMFErrorCode = "PDU_SESSION_TYPE_NOT_ALLOWED"
+	ErrCodePCFDiscoveryFailure      SMFErrorCode = "PCF_DISCOVERY_FAILURE"
+	ErrCodePCFPolicyFailure         SMFErrorCode = "PCF_POLICY_FAILURE"
+	ErrCodeServiceAreaRestricted    SMFErrorCode = "SERVICE_AREA_RESTRICTED"
+	ErrCodeUPFNotFound              SMFErrorCode = "UPF_NOT_FOUND"
+	ErrCodeUPFNotAssociated         SMFErrorCode = "UPF_NOT_ASSOCIATED"
+	ErrCodeDataPathFailure          SMFErrorCode = "DATA_PATH_FAILURE"
+	ErrCodeAMFDiscoveryFailure      SMFErrorCode = "AMF_DISCOVERY_FAILURE"
+	ErrCodeGnbInUse                 SMFErrorCode = "GNB_IN_USE"
+	ErrCodePFCPTimeout              SMFErrorCode = "PFCP_TIMEOUT"
+	ErrCodePFCPThrottled            SMFErrorCode = "PFCP_THROTTLED"
+	ErrCodePFCPResentRequest        SMFErrorCode = "PFCP_RESENT_REQUEST"
+	ErrCodePFCPRejected             SMFErrorCode = "PFCP_REJECTED"
+	ErrCodeSessionSetupFailure      SMFErrorCode = "SESSION_SETUP_FAILURE"
+	ErrCodeUPFFailure               SMFErrorCode = "UPF_FAILURE"
+)
+
+// SMFError is a failure of the SMF, identified by its code. Two SMFErrors with the same code
+// match with errors.Is, the one in a chain of wrapped errors is retrieved with errors.As.
+type SMFError struct {
+	Code    SMFErrorCode
+	Message string
+	// reference of the SM context of the failed session, empty if the failure is not a session's
+	SessionRef string
+	Cause      error
+}
+
+// New returns an error of the code
+func New(code SMFErrorCode, message string) *SMFError {
+	return &SMFError{Code: code, Message: message}
+}
+
+// Wrap returns an error of the code caused by the cause
+func Wrap(code SMFErrorCode, cause error, message string) *SMFError {
+	return &SMFError{Code: code, Message: message, Cause: cause}
+}
+
+// ForSession returns a copy of the error for the session of the SM context reference
+func (e *SMFError) ForSession(ref string) *SMFError {
+	sessionErr := *e
+	sessionErr.SessionRef = ref
+	return &sessionErr
+}
+
+func (e *SMFError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *SMFError) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches an SMFError of the same code
+func (e *SMFError) Is(target error) bool {
+	smfErr, ok := target.(*SMFError)
+	return ok && smfErr.Code == e.Code
+}
+
+// CodeOf returns the code of the first SMFError of the chain of err, empty if none
+func CodeOf(err error) SMFErrorCode {
+	var smfErr *SMFError
+	if errors.As(err, &smfErr) {
+		return smfErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package smferrors_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	smf_context "github.com/omec-project/smf/context"
+	"github.com/omec-project/smf/smferrors"
+	"github.com/stretchr/testify/require"
+	"github.com/wmnsk/go-pfcp/ie"
+)
+
+func TestSMFErrorAsThroughWrappedChain(t *testing.T) {
+	cause := fmt.Errorf("reading the pool: %w", io.EOF)
+	smfErr := smferrors.Wrap(smferrors.ErrCodeIPPoolExhausted, cause, "ip allocation failed").ForSession("urn:uuid:1")
+	err := &smf_context.SessionError{
+		Err:          fmt.Errorf("establishment attempt 2: %w", fmt.Errorf("assign PDU address: %w", smfErr)),
+		NonRetriable: true,
+	}
+
+	var target *smferrors.SMFError
+	require.True(t, errors.As(err, &target))
+	require.Same(t, smfErr, target)
+	require.Equal(t, smferrors.ErrCodeIPPoolExhausted, target.Code)
+	require.Equal(t, "urn:uuid:1", target.SessionRef)
+	require.Equal(t, "ip allocation failed: reading the pool: EOF", target.Error())
+	require.Equal(t, smferrors.ErrCodeIPPoolExhausted, smferrors.CodeOf(err))
+
+	// the cause is unwrapped too
+	require.ErrorIs(t, err, io.EOF)
+	require.False(t, smf_context.IsRetriable(err))
+}
+
+func TestSMFErrorIsMatchesCode(t *testing.T) {
+	err := fmt.Errorf("gNB [gnb1]: %w (2)", smf_context.ErrGnbInUse)
+	require.ErrorIs(t, err, smf_context.ErrGnbInUse)
+	require.NotErrorIs(t, err, smf_context.ErrTEIDPoolExhausted)
+
+	// an error of the same code matches the sentinel
+	err = fmt.Errorf("establishment: %w", smferrors.New(smferrors.ErrCodePFCPTimeout, "no response from UPF[10.0.0.1]"))
+	require.ErrorIs(t, err, smf_context.ErrPfcpRequestTimeout)
+
+	// the sentinels are not modified by the session errors derived from them
+	sessionErr := smf_context.ErrSessionQueueFull.ForSession("urn:uuid:2")
+	require.Empty(t, smf_context.ErrSessionQueueFull.SessionRef)
+	require.ErrorIs(t, sessionErr, smf_context.ErrSessionQueueFull)
+
+	require.Empty(t, smferrors.CodeOf(errors.New("plain error")))
+	require.Empty(t, smferrors.CodeOf(nil))
+}
+
+func TestSMFErrorAsContextErrors(t *testing.T) {
+	// the rejection of a PFCP request
+	pfcpErr := smf_context.NewPFCPError("Session Establishment Response", ie.NewCause(ie.CauseNoResourcesAvailable), nil)
+	err := fmt.Errorf("pfcp session establish response failure: %w", pfcpErr)
+	var target *smferrors.SMFError
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, smferrors.ErrCodePFCPRejected, target.Code)
+	require.Equal(t, pfcpErr.Error(), target.Error())
+	require.ErrorIs(t, err, smferrors.New(smferrors.ErrCodePFCPRejected, ""))
+
+	// a session establishment failure of no SMFError, the one of its error otherwise
+	err = &smf_context.SessionError{Err: errors.New("no data path")}
+	require.Equal(t, smferrors.ErrCodeSessionSetupFailure, smferrors.CodeOf(err))
+	err = &smf_context.SessionError{Err: fmt.Errorf("establishment: %w", pfcpErr)}
+	require.Equal(t, smferrors.ErrCodePFCPRejected, smferrors.CodeOf(err))
+
+	// the last error of a UPF keeps the code of the error recorded
+	upf := &smf_context.UPF{}
+	upf.RecordError(errors.New("no PFCP Heartbeat Response after 3 requests"))
+	require.True(t, errors.As(upf.LastError(), &target))
+	require.Equal(t, smferrors.ErrCodeUPFFailure, target.Code)
+	upf.RecordError(fmt.Errorf("re-association: %w", smf_context.ErrPfcpRequestTimeout))
+	lastError := upf.LastError()
+	require.Equal(t, smferrors.ErrCodePFCPTimeout, lastError.Code)
+	require.ErrorIs(t, lastError, smf_context.ErrPfcpRequestTimeout)
+}
@@ -20,13 +20,15 @@ type Transaction struct {
 	Rsp                interface{}
 	Ctxt               interface{}
 	CtxtKey            string
-	Err                error
-	Status             chan bool
-	NextTxn            *Transaction
-	TxnFsmLog          *zap.SugaredLogger
-	MsgType            svcmsgtypes.SmfMsgType
-	TxnId              uint32
-	Priority           uint32
+	// address of the NF requesting the transaction over the SBI, empty if internal
+	SourceIP  string
+	Err       error
+	Status    chan bool
+	NextTxn   *Transaction
+	TxnFsmLog *zap.SugaredLogger
+	MsgType   svcmsgtypes.SmfMsgType
+	TxnId     uint32
+	Priority  uint32
 }
 
 func (t *Transaction) initLogTags() {
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync/atomic"
+	_ "unsafe" // go:linkname of the openapi HTTPS client
+
+	"github.com/omec-project/openapi"
+	"github.com/omec-project/smf/factory"
+	"golang.org/x/net/http2"
+)
+
+// HTTPS client of the SBI requests of the SMF applying the TLS policy, nil without policy
+var sbiTLSClient atomic.Pointer[http.Client]
+
+// openapiHTTPSClient is the HTTPS client of all the requests of the openapi clients, to the NRF,
+// UDM, PCF and AMF, which the openapi package does not let configure
+//
+//go:linkname openapiHTTPSClient github.com/omec-project/openapi.innerHTTP2Client
+var openapiHTTPSClient *http.Client
+
+// sbiTransport sends the requests of the openapi clients with the TLS policy of the SMF if any,
+// with the transport of the openapi package otherwise
+type sbiTransport struct {
+	defaultTransport http.RoundTripper
+}
+
+func (t *sbiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if client := sbiTLSClient.Load(); client != nil {
+		return client.Transport.RoundTrip(req)
+	}
+	return t.defaultTransport.RoundTrip(req)
+}
+
+func init() {
+	openapiHTTPSClient.Transport = &sbiTransport{defaultTransport: openapiHTTPSClient.Transport}
+}
+
+// SetSbiTLSPolicy makes the SBI requests of the SMF over HTTPS, its own ones and the ones of the
+// openapi clients, use the TLS versions and cipher suites of the policy, the defaults of the
+// openapi client being used without policy
+func SetSbiTLSPolicy(policy *factory.TLSPolicy) error {
+	if policy == nil {
+		sbiTLSClient.Store(nil)
+		return nil
+	}
+	// the peers are not verified, as by the openapi client
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if err := policy.Apply(tlsConfig); err != nil {
+		return err
+	}
+	sbiTLSClient.Store(&http.Client{
+		Transport: &http2.Transport{TLSClientConfig: tlsConfig},
+	})
+	return nil
+}
+
+// CallSbi sends the SBI request, over HTTPS with the TLS policy of the SMF if any
+func CallSbi(req *http.Request) (*http.Response, error) {
+	return openapi.CallAPI(nil, req)
+}
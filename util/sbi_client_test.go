@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/omec-project/openapi/Npcf_SMPolicyControl"
+	"github.com/omec-project/openapi/models"
+	"github.com/omec-project/smf/factory"
+	"github.com/stretchr/testify/require"
+)
+
+// newTLSPolicyServer starts an HTTP/2 server applying the TLS policy, returning the connection
+// states of the requests it served
+func newTLSPolicyServer(t *testing.T, policy *factory.TLSPolicy) (*httptest.Server, chan tls.ConnectionState) {
+	t.Helper()
+	states := make(chan tls.ConnectionState, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		states <- *r.TLS
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{}
+	require.NoError(t, policy.Apply(server.TLS))
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, states
+}
+
+func callSbi(t *testing.T, uri string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, uri, nil)
+	require.NoError(t, err)
+	rsp, err := CallSbi(req)
+	if err == nil {
+		require.NoError(t, rsp.Body.Close())
+	}
+	return rsp, err
+}
+
+func TestCallSbiTLSPolicy(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetSbiTLSPolicy(nil)) })
+
+	t.Run("TLS 1.3", func(t *testing.T) {
+		server, states := newTLSPolicyServer(t, &factory.TLSPolicy{MinVersion: "1.3"})
+		require.NoError(t, SetSbiTLSPolicy(&factory.TLSPolicy{MinVersion: "1.2"}))
+
+		rsp, err := callSbi(t, server.URL)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, rsp.StatusCode)
+		require.Equal(t, uint16(tls.VersionTLS13), rsp.TLS.Version)
+		require.Equal(t, uint16(tls.VersionTLS13), (<-states).Version)
+	})
+
+	t.Run("TLS 1.2 cipher suite", func(t *testing.T) {
+		server, states := newTLSPolicyServer(t, &factory.TLSPolicy{
+			MaxVersion: "1.2",
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			},
+		})
+		require.NoError(t, SetSbiTLSPolicy(&factory.TLSPolicy{
+			MaxVersion:   "1.2",
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		}))
+
+		rsp, err := callSbi(t, server.URL)
+		require.NoError(t, err)
+		require.Equal(t, uint16(tls.VersionTLS12), rsp.TLS.Version)
+		require.Equal(t, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, rsp.TLS.CipherSuite)
+		state := <-states
+		require.Equal(t, uint16(tls.VersionTLS12), state.Version)
+		require.Equal(t, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, state.CipherSuite)
+	})
+
+	t.Run("no common version", func(t *testing.T) {
+		server, _ := newTLSPolicyServer(t, &factory.TLSPolicy{MinVersion: "1.3"})
+		require.NoError(t, SetSbiTLSPolicy(&factory.TLSPolicy{MaxVersion: "1.2"}))
+
+		_, err := callSbi(t, server.URL)
+		require.Error(t, err)
+	})
+}
+
+// The requests of the openapi clients, as the ones to the PCF, apply the TLS policy too
+func TestOpenapiClientTLSPolicy(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetSbiTLSPolicy(nil)) })
+	server, states := newTLSPolicyServer(t, &factory.TLSPolicy{
+		MaxVersion: "1.2",
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+	})
+	configuration := Npcf_SMPolicyControl.NewConfiguration()
+	configuration.SetBasePath(server.URL)
+	client := Npcf_SMPolicyControl.NewAPIClient(configuration)
+	deletePolicy := func() (*http.Response, error) {
+		return client.DefaultApi.SmPoliciesSmPolicyIdDeletePost(context.Background(), "1", models.SmPolicyDeleteData{})
+	}
+
+	require.NoError(t, SetSbiTLSPolicy(&factory.TLSPolicy{
+		MaxVersion:   "1.2",
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}))
+	rsp, err := deletePolicy()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, rsp.StatusCode)
+	require.Equal(t, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, (<-states).CipherSuite)
+
+	// no TLS 1.2 cipher suite in common
+	require.NoError(t, SetSbiTLSPolicy(&factory.TLSPolicy{
+		MaxVersion:   "1.2",
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}))
+	_, err = deletePolicy()
+	require.Error(t, err)
+}
+
+func TestSetSbiTLSPolicyInvalid(t *testing.T) {
+	require.Error(t, SetSbiTLSPolicy(&factory.TLSPolicy{MinVersion: "1.1"}))
+	require.Nil(t, sbiTLSClient.Load())
+}